@@ -28,7 +28,6 @@ import (
 	"github.com/livekit/psrpc"
 
 	"github.com/livekit/sip/pkg/config"
-	"github.com/livekit/sip/pkg/errors"
 	"github.com/livekit/sip/pkg/service"
 	"github.com/livekit/sip/pkg/sip"
 	"github.com/livekit/sip/version"
@@ -89,13 +88,21 @@ func runService(c *cli.Context) error {
 		return err
 	}
 
-	svc := service.NewService(conf, log, sipsrv.InternalServerImpl(), sipsrv.Stop, sipsrv.ActiveCalls, psrpcClient, bus)
+	svc := service.NewService(conf, log, sipsrv.InternalServerImpl(), sipsrv.Stop, sipsrv.ActiveCalls, sipsrv.Trunks, psrpcClient, bus)
 	sipsrv.SetHandler(svc)
 
 	if err = sipsrv.Start(); err != nil {
 		return err
 	}
 
+	if file := c.String("config"); file != "" && c.String("config-body") == "" {
+		stopReload, err := config.HotReload(file, conf.ApplyHotReload)
+		if err != nil {
+			return err
+		}
+		defer stopReload()
+	}
+
 	go func() {
 		select {
 		case sig := <-stopChan:
@@ -113,10 +120,7 @@ func runService(c *cli.Context) error {
 func getConfig(c *cli.Context, initialize bool) (*config.Config, error) {
 	configFile := c.String("config")
 	configBody := c.String("config-body")
-	if configBody == "" {
-		if configFile == "" {
-			return nil, errors.ErrNoConfig
-		}
+	if configBody == "" && configFile != "" {
 		content, err := os.ReadFile(configFile)
 		if err != nil {
 			return nil, err
@@ -124,7 +128,16 @@ func getConfig(c *cli.Context, initialize bool) (*config.Config, error) {
 		configBody = string(content)
 	}
 
-	conf, err := config.NewConfig(configBody)
+	var (
+		conf *config.Config
+		err  error
+	)
+	if configBody == "" {
+		// Without a config file, the service is configured with SIP_ environment variables only.
+		conf, err = config.FromEnv()
+	} else if conf, err = config.NewConfig(configBody); err == nil {
+		err = config.MergeEnv(conf)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -15,9 +15,11 @@
 package audiotest
 
 import (
+	"fmt"
 	"math"
 	"math/cmplx"
 	"slices"
+	"strings"
 
 	"github.com/mjibson/go-dsp/fft"
 
@@ -29,6 +31,48 @@ type Wave struct {
 	Amp int
 }
 
+func (w Wave) String() string {
+	return fmt.Sprintf("f%d@%ddB", w.Ind, w.Amp)
+}
+
+// WaveSlice is a list of signals, usually returned by FindSignal.
+type WaveSlice []Wave
+
+// Diff compares signals with the expected ones by frequency index, and returns a human-readable diff.
+// Missing signals are prefixed with "-", unexpected ones with "+", and signals with a different amplitude with "~".
+// Empty string is returned if signals match exactly. Order of signals is ignored.
+func (s WaveSlice) Diff(expected []Wave) string {
+	got := slices.Clone(s)
+	exp := slices.Clone(expected)
+	byInd := func(a, b Wave) int { return a.Ind - b.Ind }
+	slices.SortStableFunc(got, byInd)
+	slices.SortStableFunc(exp, byInd)
+	var (
+		out  []string
+		diff bool
+	)
+	for len(got) != 0 || len(exp) != 0 {
+		switch {
+		case len(got) == 0 || (len(exp) != 0 && exp[0].Ind < got[0].Ind):
+			out = append(out, "-"+exp[0].String())
+			exp, diff = exp[1:], true
+		case len(exp) == 0 || got[0].Ind < exp[0].Ind:
+			out = append(out, "+"+got[0].String())
+			got, diff = got[1:], true
+		case got[0].Amp != exp[0].Amp:
+			out = append(out, fmt.Sprintf("~%s (expected %ddB)", got[0], exp[0].Amp))
+			got, exp, diff = got[1:], exp[1:], true
+		default:
+			out = append(out, " "+got[0].String())
+			got, exp = got[1:], exp[1:]
+		}
+	}
+	if !diff {
+		return ""
+	}
+	return strings.Join(out, ", ")
+}
+
 // GenSignal generates audio signals into dst.
 func GenSignal(dst media.PCM16Sample, waves []Wave) {
 	// Generate a sin wave for each signal. Index 0 fits one full period inside dst.
@@ -70,3 +114,43 @@ func FindSignal(src media.PCM16Sample) []Wave {
 	})
 	return waves
 }
+
+// ChannelSeparation checks that the left and right channels of interleaved stereo audio contain signals
+// previously generated by GenSignal with given frequencies, and that neither channel leaks into the other.
+// Frequencies must map to a GenSignal index for the channel length: freq = (1<<Ind) * sampleRate / samples.
+// Leakage is ignored if it's 20 dB below the expected signal.
+func ChannelSeparation(stereo []int16, leftFreq, rightFreq int, sampleRate int) (leftOK, rightOK bool) {
+	n := len(stereo) / 2
+	left, right := make(media.PCM16Sample, n), make(media.PCM16Sample, n)
+	for i := 0; i < n; i++ {
+		left[i], right[i] = stereo[2*i], stereo[2*i+1]
+	}
+	leftInd, rightInd := freqIndex(leftFreq, n, sampleRate), freqIndex(rightFreq, n, sampleRate)
+	return hasSeparateSignal(left, leftInd, rightInd), hasSeparateSignal(right, rightInd, leftInd)
+}
+
+// freqIndex converts frequency to GenSignal index for a given number of samples.
+func freqIndex(freq, samples, sampleRate int) int {
+	bin := freq * samples / sampleRate
+	if bin <= 0 {
+		return -1
+	}
+	return int(math.Log2(float64(bin)))
+}
+
+// hasSeparateSignal checks that the strongest signal has expected index, and that the other signal is not present.
+func hasSeparateSignal(src media.PCM16Sample, ind, other int) bool {
+	waves := FindSignal(src)
+	if ind < 0 || len(waves) == 0 || waves[0].Ind != ind {
+		return false
+	}
+	if other == ind {
+		return true
+	}
+	for _, w := range waves[1:] {
+		if w.Ind == other && w.Amp*10 > waves[0].Amp {
+			return false
+		}
+	}
+	return true
+}
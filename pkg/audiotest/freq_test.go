@@ -15,6 +15,8 @@
 package audiotest
 
 import (
+	"math"
+	"slices"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -35,3 +37,96 @@ func TestFreq(t *testing.T) {
 	out := FindSignal(sig)
 	require.Equal(t, inp, out)
 }
+
+func TestSNR(t *testing.T) {
+	sig := make(media.PCM16Sample, 160)
+	GenSignal(sig, []Wave{{Ind: 2, Amp: 1000}})
+	require.True(t, math.IsInf(SNR(sig, sig), 1))
+
+	// Noise at 1/10 of the signal amplitude is 20 dB lower.
+	noise := make(media.PCM16Sample, len(sig))
+	GenSignal(noise, []Wave{{Ind: 5, Amp: 100}})
+	noisy := slices.Clone(sig)
+	for i := range noisy {
+		noisy[i] += noise[i]
+	}
+	require.InDelta(t, 20, SNR(sig, noisy), 0.1)
+	require.InDelta(t, 20, ToneSNR(noisy, []int{2}), 0.1)
+	require.Greater(t, ToneSNR(noisy, []int{2, 5}), 40.0) // only rounding errors
+
+	// Delay must be compensated.
+	delayed := append(make(media.PCM16Sample, 7), sig...)
+	require.Less(t, SNR(sig, delayed), 0.0)
+	d := FindDelay(sig, delayed, 20)
+	require.Equal(t, 7, d)
+	require.True(t, math.IsInf(SNR(sig, delayed[d:]), 1))
+}
+
+func TestChannelSeparation(t *testing.T) {
+	const (
+		sampleRate = 8000
+		samples    = 160
+		amp        = 1000
+	)
+	// Index 2 and 4 on 160 samples at 8 kHz.
+	const leftFreq, rightFreq = 200, 800
+	left, right := make(media.PCM16Sample, samples), make(media.PCM16Sample, samples)
+	GenSignal(left, []Wave{{Ind: 2, Amp: amp}})
+	GenSignal(right, []Wave{{Ind: 4, Amp: amp}})
+	interleave := func(l, r media.PCM16Sample) []int16 {
+		out := make([]int16, 0, 2*len(l))
+		for i := range l {
+			out = append(out, l[i], r[i])
+		}
+		return out
+	}
+
+	leftOK, rightOK := ChannelSeparation(interleave(left, right), leftFreq, rightFreq, sampleRate)
+	require.True(t, leftOK)
+	require.True(t, rightOK)
+
+	// Swapped channels.
+	leftOK, rightOK = ChannelSeparation(interleave(right, left), leftFreq, rightFreq, sampleRate)
+	require.False(t, leftOK)
+	require.False(t, rightOK)
+
+	// Left channel bleeds into the right one.
+	bleed := slices.Clone(right)
+	for i := range bleed {
+		bleed[i] += left[i] / 2
+	}
+	leftOK, rightOK = ChannelSeparation(interleave(left, bleed), leftFreq, rightFreq, sampleRate)
+	require.True(t, leftOK)
+	require.False(t, rightOK)
+
+	// Leakage below -20 dB is tolerated.
+	for i := range bleed {
+		bleed[i] = right[i] + left[i]/20
+	}
+	leftOK, rightOK = ChannelSeparation(interleave(left, bleed), leftFreq, rightFreq, sampleRate)
+	require.True(t, leftOK)
+	require.True(t, rightOK)
+}
+
+func TestWaveDiff(t *testing.T) {
+	require.Equal(t, "f3@100dB", Wave{Ind: 3, Amp: 100}.String())
+
+	exp := []Wave{{Ind: 5, Amp: 100}, {Ind: 2, Amp: 50}}
+	cases := []struct {
+		name string
+		got  WaveSlice
+		diff string
+	}{
+		{"exact", WaveSlice{{Ind: 2, Amp: 50}, {Ind: 5, Amp: 100}}, ""},
+		{"missing", WaveSlice{{Ind: 5, Amp: 100}}, "-f2@50dB,  f5@100dB"},
+		{"none", nil, "-f2@50dB, -f5@100dB"},
+		{"frequency", WaveSlice{{Ind: 5, Amp: 100}, {Ind: 3, Amp: 50}}, "-f2@50dB, +f3@50dB,  f5@100dB"},
+		{"amplitude", WaveSlice{{Ind: 5, Amp: 90}, {Ind: 2, Amp: 50}}, " f2@50dB, ~f5@90dB (expected 100dB)"},
+		{"extra", WaveSlice{{Ind: 5, Amp: 100}, {Ind: 2, Amp: 50}, {Ind: 7, Amp: 3}}, " f2@50dB,  f5@100dB, +f7@3dB"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.diff, c.got.Diff(exp))
+		})
+	}
+}
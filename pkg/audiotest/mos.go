@@ -0,0 +1,204 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audiotest
+
+import (
+	"math"
+	"math/cmplx"
+
+	"github.com/mjibson/go-dsp/fft"
+)
+
+const (
+	// MOSMax is the max score returned by MOS, same as for P.862.2.
+	MOSMax = 4.64
+	// MOSMin is the min score returned by MOS.
+	MOSMin = 1.0
+
+	mosFrameMs    = 32   // max frame size required by PESQ
+	mosMaxDelayMs = 100  // max delay compensated before comparing signals
+	mosSilenceDB  = -40  // frames this much quieter than the loudest one are ignored
+	mosZwicker    = 0.23 // loudness compression exponent
+	mosLevel      = 1e4  // reference band power after normalization; hearing threshold is 1
+	mosLoudness   = 10   // loudness scale; calibrated so that white noise at 10 dB SNR scores below 2
+)
+
+type barkBand struct {
+	lo, hi int     // FFT bins
+	width  float64 // in Bark
+}
+
+// hzToBark converts frequency to Bark scale (Zwicker & Terhardt).
+func hzToBark(f float64) float64 {
+	return 13*math.Atan(0.00076*f) + 3.5*math.Atan((f/7500)*(f/7500))
+}
+
+// barkBands splits FFT bins (up to Nyquist) into bands of 1 Bark. Frequencies below 100 Hz are ignored, same as in PESQ.
+func barkBands(frameSize, sampleRate int) []barkBand {
+	binHz := float64(sampleRate) / float64(frameSize)
+	var (
+		bands []barkBand
+		cur   barkBand
+	)
+	cur.lo = int(math.Ceil(100 / binHz))
+	start := hzToBark(float64(cur.lo) * binHz)
+	for i := cur.lo; i < frameSize/2; i++ {
+		b := hzToBark(float64(i+1) * binHz)
+		if b-start >= 1 || i == frameSize/2-1 {
+			cur.hi = i + 1
+			cur.width = b - start
+			bands = append(bands, cur)
+			cur = barkBand{lo: i + 1}
+			start = b
+		}
+	}
+	return bands
+}
+
+// MOS estimates Mean Opinion Score of the degraded audio compared to the reference one.
+//
+// It's a simplified version of PESQ (ITU-T P.862.2, wideband): signals are aligned in time and level,
+// converted to Bark-scaled loudness in frames of 32 ms, and compared with an asymmetric disturbance measure.
+// Scores are comparable between runs, but not with the real PESQ. The result is in MOSMin..MOSMax range.
+func MOS(reference, degraded []int16, sampleRate int) float64 {
+	maxDelay := sampleRate * mosMaxDelayMs / 1000
+	d := FindDelay(reference, degraded, maxDelay)
+	degraded = degraded[d:]
+	n := min(len(reference), len(degraded))
+
+	frameSize := sampleRate * mosFrameMs / 1000
+	if n < frameSize {
+		return MOSMin
+	}
+	// Level alignment: compensate for a gain change, it's not considered a distortion.
+	var refPow, degPow float64
+	for i := 0; i < n; i++ {
+		refPow += float64(reference[i]) * float64(reference[i])
+		degPow += float64(degraded[i]) * float64(degraded[i])
+	}
+	if refPow == 0 && degPow == 0 {
+		return MOSMax
+	} else if refPow == 0 || degPow == 0 {
+		return MOSMin
+	}
+	gain := math.Sqrt(refPow / degPow)
+
+	window := make([]float64, frameSize)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(frameSize-1)) // Hann
+	}
+	bands := barkBands(frameSize, sampleRate)
+	spectrum := func(src []int16, gain float64, out []float64) {
+		cmp := make([]complex128, frameSize)
+		for i := range cmp {
+			cmp[i] = complex(float64(src[i])*gain*window[i], 0)
+		}
+		xf := fft.FFT(cmp)
+		for bi, b := range bands {
+			var p float64
+			for _, v := range xf[b.lo:b.hi] {
+				a := cmplx.Abs(v)
+				p += a * a
+			}
+			out[bi] = p / float64(b.hi-b.lo)
+		}
+	}
+
+	// Band powers for all frames, with 50% overlap.
+	var refFrames, degFrames [][]float64
+	var maxEnergy, meanPow float64
+	for off := 0; off+frameSize <= n; off += frameSize / 2 {
+		rf, df := make([]float64, len(bands)), make([]float64, len(bands))
+		spectrum(reference[off:off+frameSize], 1, rf)
+		spectrum(degraded[off:off+frameSize], gain, df)
+		refFrames, degFrames = append(refFrames, rf), append(degFrames, df)
+		var e float64
+		for _, p := range rf {
+			e += p
+		}
+		maxEnergy = max(maxEnergy, e)
+	}
+	// Only speech (active) frames are scored.
+	active := make([]bool, len(refFrames))
+	var nActive int
+	for i, rf := range refFrames {
+		var e float64
+		for _, p := range rf {
+			e += p
+		}
+		if e > 0 && 10*math.Log10(e/maxEnergy) > mosSilenceDB {
+			active[i] = true
+			nActive++
+			meanPow += e / float64(len(bands))
+		}
+	}
+	if nActive == 0 {
+		return MOSMin
+	}
+	meanPow /= float64(nActive)
+	// Normalize to a fixed listening level, so that hearing threshold is at 1.
+	norm := mosLevel / meanPow
+	loudness := func(p float64) float64 {
+		const p0 = 1
+		if p <= p0 {
+			return 0
+		}
+		return mosLoudness * math.Pow(p0/0.5, mosZwicker) * (math.Pow(0.5+0.5*p/p0, mosZwicker) - 1)
+	}
+
+	var symSum, asymSum float64
+	for i := range refFrames {
+		if !active[i] {
+			continue
+		}
+		var sym, asym, wsum float64
+		for bi, b := range bands {
+			pr, pd := refFrames[i][bi]*norm, degFrames[i][bi]*norm
+			lr, ld := loudness(pr), loudness(pd)
+			diff := ld - lr
+			// Masking: small differences are not audible.
+			m := 0.25 * min(lr, ld)
+			switch {
+			case diff > m:
+				diff -= m
+			case diff < -m:
+				diff += m
+			default:
+				diff = 0
+			}
+			// Introduced components are more annoying than missing ones.
+			h := math.Pow((pd+50)/(pr+50), 1.2)
+			if h < 3 {
+				h = 0
+			} else if h > 12 {
+				h = 12
+			}
+			sym += b.width * diff * diff
+			asym += b.width * math.Abs(diff) * h
+			wsum += b.width
+		}
+		sym = math.Sqrt(sym / wsum)
+		asym /= wsum
+		symSum += sym * sym
+		asymSum += asym
+	}
+	dSym := math.Sqrt(symSum / float64(nActive))
+	dAsym := asymSum / float64(nActive)
+
+	raw := 4.5 - 0.1*dSym - 0.0309*dAsym
+	// P.862.2 mapping from raw PESQ score to MOS-LQO.
+	mos := 0.999 + 4/(1+math.Exp(-1.3669*raw+3.8224))
+	return max(MOSMin, min(MOSMax, mos))
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audiotest
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func addNoise(src []int16, snr float64) []int16 {
+	var p float64
+	for _, v := range src {
+		p += float64(v) * float64(v)
+	}
+	amp := math.Sqrt(p / float64(len(src)) / math.Pow(10, snr/10))
+	rnd := rand.New(rand.NewSource(1))
+	out := make([]int16, len(src))
+	for i, v := range src {
+		out[i] = int16(float64(v) + rnd.NormFloat64()*amp)
+	}
+	return out
+}
+
+func TestMOS(t *testing.T) {
+	for _, sampleRate := range []int{8000, 16000} {
+		t.Run("", func(t *testing.T) {
+			ref := make([]int16, 2*sampleRate)
+			GenVoice(ref, sampleRate, 8000)
+			require.Equal(t, MOSMax, MOS(ref, ref, sampleRate))
+
+			// Gain and delay are not distortions.
+			quiet := make([]int16, len(ref))
+			for i, v := range ref {
+				quiet[i] = v / 2
+			}
+			require.InDelta(t, MOSMax, MOS(ref, quiet, sampleRate), 0.01)
+			delayed := append(make([]int16, sampleRate/100), ref...)
+			require.Equal(t, MOSMax, MOS(ref, delayed, sampleRate))
+
+			// More noise - lower score.
+			prev := MOSMax
+			for _, snr := range []float64{30, 20, 10} {
+				mos := MOS(ref, addNoise(ref, snr), sampleRate)
+				t.Logf("SNR %v dB: MOS %.2f", snr, mos)
+				require.Less(t, mos, prev)
+				prev = mos
+			}
+			require.Less(t, prev, 2.0)
+			require.Less(t, MOS(ref, addNoise(ref, 0), sampleRate), 1.5)
+
+			require.Equal(t, MOSMin, MOS(ref, make([]int16, len(ref)), sampleRate))
+		})
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audiotest
+
+import (
+	"math"
+	"math/cmplx"
+
+	"github.com/mjibson/go-dsp/fft"
+)
+
+// SNR returns signal-to-noise ratio in dB of a noisy copy of the signal.
+// Noise is the difference between the two. Only the common part of both is compared.
+func SNR(signal, noisy []int16) float64 {
+	n := min(len(signal), len(noisy))
+	var sig, noise float64
+	for i := 0; i < n; i++ {
+		v, d := float64(signal[i]), float64(signal[i])-float64(noisy[i])
+		sig += v * v
+		noise += d * d
+	}
+	if noise == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(sig/noise)
+}
+
+// FindDelay returns a delay (in samples) of the noisy copy of the signal, up to maxDelay.
+// It must be compensated before calling SNR for codecs with algorithmic delay.
+func FindDelay(signal, noisy []int16, maxDelay int) int {
+	best, bestCorr := 0, math.Inf(-1)
+	for d := 0; d <= maxDelay && d < len(noisy); d++ {
+		n := min(len(signal), len(noisy)-d)
+		var corr float64
+		for i := 0; i < n; i++ {
+			corr += float64(signal[i]) * float64(noisy[i+d])
+		}
+		if corr /= float64(n); corr > bestCorr {
+			best, bestCorr = d, corr
+		}
+	}
+	return best
+}
+
+// ToneSNR returns SNR in dB of signals previously generated by GenSignal with given indexes.
+// Power of these signals is compared with power of all other frequencies, thus the phase of the signals doesn't matter.
+func ToneSNR(src []int16, inds []int) float64 {
+	cmp := make([]complex128, len(src))
+	for i, v := range src {
+		cmp[i] = complex(float64(v), 0)
+	}
+	out := fft.FFT(cmp)
+	var sig, noise float64
+	for i, v := range out[:len(out)/2] {
+		if i == 0 {
+			continue // Ignore offset.
+		}
+		p := cmplx.Abs(v)
+		p *= p
+		isSig := false
+		for _, ind := range inds {
+			if i == 1<<ind {
+				isSig = true
+				break
+			}
+		}
+		if isSig {
+			sig += p
+		} else {
+			noise += p
+		}
+	}
+	if noise == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(sig/noise)
+}
@@ -0,0 +1,136 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audiotest
+
+import (
+	"math"
+	"math/rand"
+)
+
+// vowel formant frequencies, Hz
+var vowels = [][3]float64{
+	{730, 1090, 2440}, // a
+	{270, 2290, 3010}, // i
+	{300, 870, 2240},  // u
+	{530, 1840, 2480}, // e
+	{570, 840, 2410},  // o
+}
+
+// GenVoice generates a deterministic speech-like signal: voiced syllables with changing pitch and vowels, separated by pauses.
+// Unlike GenSignal, it has a wide spectrum, which makes it suitable for audio quality estimation with MOS.
+func GenVoice(dst []int16, sampleRate int, amp int) {
+	const (
+		syllableDur = 0.25 // sec
+		pauseDur    = 0.05 // sec
+	)
+	sr := float64(sampleRate)
+	var phase float64
+	for i := range dst {
+		t := float64(i) / sr
+		syl := int(t / syllableDur)
+		st := t - float64(syl)*syllableDur // time within the syllable
+		if st >= syllableDur-pauseDur {
+			dst[i] = 0
+			continue
+		}
+		env := math.Sin(math.Pi * st / (syllableDur - pauseDur))
+		f0 := 110 + 30*math.Sin(2*math.Pi*0.7*t) + 5*math.Sin(2*math.Pi*5*t)
+		phase += 2 * math.Pi * f0 / sr
+		formants := vowels[syl%len(vowels)]
+		var v, norm float64
+		for h := 1; float64(h)*f0 < sr/2; h++ {
+			f := float64(h) * f0
+			// Simple resonances around the formants with a spectral tilt.
+			a := 0.0
+			for fi, ff := range formants {
+				bw := 80 + 40*float64(fi)
+				a += 1 / (1 + ((f-ff)/bw)*((f-ff)/bw)) / float64(fi+1)
+			}
+			a *= 1 / math.Sqrt(float64(h))
+			v += a * math.Sin(float64(h)*phase)
+			norm += a
+		}
+		if norm != 0 {
+			v /= norm
+		}
+		dst[i] = int16(float64(amp) * env * v * 2)
+	}
+}
+
+// speechAmp is the peak amplitude of the signal generated by GenSpeech.
+const speechAmp = 8000
+
+// GenSpeech generates a pseudo-random speech-like signal: syllables with a gliding pitch and a random vowel,
+// separated by pauses of a random length, on top of a low noise floor. The same seed always generates the same signal.
+// It is more realistic than GenVoice, and should be preferred for new codec tests.
+func GenSpeech(dst []int16, sampleRate int, seed int64) {
+	const (
+		noiseAmp = speechAmp / 300 // about -50 dB
+		glideDur = 0.05            // sec, transition between vowels
+	)
+	rnd := rand.New(rand.NewSource(seed))
+	sr := float64(sampleRate)
+	base := 90 + 130*rnd.Float64() // fundamental frequency of the speaker, Hz
+
+	var (
+		phase     float64
+		prev, cur [3]float64 // formants of the previous and current vowel
+		f0a, f0b  float64    // pitch at the start and the end of the syllable
+		sylLen    int        // length of the current syllable, in samples
+		pauseLen  int        // length of the pause after it
+		pos       = 0        // position within the syllable and the pause
+	)
+	cur = vowels[rnd.Intn(len(vowels))]
+	for i := range dst {
+		if pos >= sylLen+pauseLen {
+			prev, cur = cur, vowels[rnd.Intn(len(vowels))]
+			f0a = base * (0.8 + 0.4*rnd.Float64())
+			f0b = base * (0.8 + 0.4*rnd.Float64())
+			sylLen = int(sr * (0.15 + 0.2*rnd.Float64()))
+			pauseLen = int(sr * (0.03 + 0.07*rnd.Float64()))
+			pos = 0
+		}
+		noise := rnd.NormFloat64() * noiseAmp
+		if pos >= sylLen {
+			pos++
+			dst[i] = int16(noise)
+			continue
+		}
+		st := float64(pos) / float64(sylLen) // relative position within the syllable
+		pos++
+		env := math.Sin(math.Pi * st)
+		f0 := f0a + (f0b-f0a)*st + 3*math.Sin(2*math.Pi*5*float64(i)/sr)
+		phase += 2 * math.Pi * f0 / sr
+		// Formants move from the previous vowel to the current one at the start of the syllable.
+		mix := min(1, st*float64(sylLen)/(sr*glideDur))
+		var v, norm float64
+		for h := 1; float64(h)*f0 < sr/2; h++ {
+			f := float64(h) * f0
+			a := 0.0
+			for fi := range cur {
+				ff := prev[fi] + (cur[fi]-prev[fi])*mix
+				bw := 80 + 40*float64(fi)
+				a += 1 / (1 + ((f-ff)/bw)*((f-ff)/bw)) / float64(fi+1)
+			}
+			a *= 1 / math.Sqrt(float64(h))
+			v += a * math.Sin(float64(h)*phase)
+			norm += a
+		}
+		if norm != 0 {
+			v /= norm
+		}
+		dst[i] = int16(speechAmp*env*v*2 + noise)
+	}
+}
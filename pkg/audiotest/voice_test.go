@@ -0,0 +1,48 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audiotest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenSpeech(t *testing.T) {
+	const sampleRate = 16000
+	a := make([]int16, 2*sampleRate)
+	b := make([]int16, len(a))
+	GenSpeech(a, sampleRate, 1)
+	GenSpeech(b, sampleRate, 1)
+	require.Equal(t, a, b)
+
+	GenSpeech(b, sampleRate, 2)
+	require.NotEqual(t, a, b)
+
+	// Signal has pauses with the noise floor only, and doesn't clip.
+	var quiet, peak int
+	for _, v := range a {
+		if v > -speechAmp/100 && v < speechAmp/100 {
+			quiet++
+		}
+		peak = max(peak, int(v), -int(v))
+	}
+	require.Greater(t, quiet, len(a)/20)
+	require.Greater(t, peak, speechAmp/2)
+	require.Less(t, peak, 3*speechAmp)
+
+	require.Equal(t, MOSMax, MOS(a, a, sampleRate))
+	require.Less(t, MOS(a, addNoise(a, 10), sampleRate), 2.0)
+}
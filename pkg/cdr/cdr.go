@@ -0,0 +1,242 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cdr writes call detail records (CDR) for billing systems.
+package cdr
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// TimeFormat is the format of times in CSV records.
+const TimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+const queueSize = 256
+
+// CDRRecord describes a single call. It is written once the call ends.
+type CDRRecord struct {
+	CallID      string    `json:"call_id"`
+	TrunkID     string    `json:"trunk_id,omitempty"`
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	StartTime   time.Time `json:"start_time"`
+	AnswerTime  time.Time `json:"answer_time"` // zero if the call was not answered
+	EndTime     time.Time `json:"end_time"`
+	Duration    float64   `json:"duration"` // billable seconds from answer to end; zero if the call was not answered
+	Direction   string    `json:"direction"`
+	HangupCause string    `json:"hangup_cause"`
+
+	// RTP statistics of the call; zero if no media was set up.
+	PacketsSent     uint64  `json:"packets_sent"`
+	PacketsReceived uint64  `json:"packets_received"`
+	BytesSent       uint64  `json:"bytes_sent"`
+	BytesReceived   uint64  `json:"bytes_received"`
+	LostPackets     uint64  `json:"lost_packets"`
+	JitterMs        float64 `json:"jitter_ms"`
+}
+
+// csvHeader lists CSV columns in the order of CDRRecord.csvRow.
+var csvHeader = []string{"call_id", "trunk_id", "from", "to", "start_time", "answer_time", "end_time", "duration", "direction", "hangup_cause",
+	"packets_sent", "packets_received", "bytes_sent", "bytes_received", "lost_packets", "jitter_ms"}
+
+// MarshalJSON omits the answer time of calls which were not answered.
+func (r *CDRRecord) MarshalJSON() ([]byte, error) {
+	type record CDRRecord
+	var answer *time.Time
+	if !r.AnswerTime.IsZero() {
+		answer = &r.AnswerTime
+	}
+	return json.Marshal(&struct {
+		*record
+		AnswerTime *time.Time `json:"answer_time,omitempty"`
+	}{(*record)(r), answer})
+}
+
+func (r *CDRRecord) csvRow() []string {
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(TimeFormat)
+	}
+	return []string{
+		r.CallID,
+		r.TrunkID,
+		r.From,
+		r.To,
+		formatTime(r.StartTime),
+		formatTime(r.AnswerTime),
+		formatTime(r.EndTime),
+		strconv.FormatFloat(r.Duration, 'f', 3, 64),
+		r.Direction,
+		r.HangupCause,
+		strconv.FormatUint(r.PacketsSent, 10),
+		strconv.FormatUint(r.PacketsReceived, 10),
+		strconv.FormatUint(r.BytesSent, 10),
+		strconv.FormatUint(r.BytesReceived, 10),
+		strconv.FormatUint(r.LostPackets, 10),
+		strconv.FormatFloat(r.JitterMs, 'f', 3, 64),
+	}
+}
+
+// CDRWriter writes call detail records. Writers which also implement io.Closer are closed by the Exporter.
+type CDRWriter interface {
+	WriteCDR(r *CDRRecord) error
+}
+
+// NewWriter creates a writer for a given format, csv or json.
+func NewWriter(w io.Writer, format string) (CDRWriter, error) {
+	switch format {
+	case FormatCSV:
+		return NewCSVWriter(w), nil
+	case FormatJSON, "":
+		return NewJSONWriter(w), nil
+	}
+	return nil, fmt.Errorf("unsupported CDR format: %q", format)
+}
+
+// CSVWriter writes records as CSV rows, preceded by a header row.
+type CSVWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w)}
+}
+
+// SkipHeader disables the header row, for example when appending to an existing file.
+func (w *CSVWriter) SkipHeader() {
+	w.header = true
+}
+
+func (w *CSVWriter) WriteCDR(r *CDRRecord) error {
+	if !w.header {
+		if err := w.w.Write(csvHeader); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	if err := w.w.Write(r.csvRow()); err != nil {
+		return err
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// JSONWriter writes records as JSON objects, one per line.
+type JSONWriter struct {
+	enc *json.Encoder
+}
+
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	return &JSONWriter{enc: json.NewEncoder(w)}
+}
+
+func (w *JSONWriter) WriteCDR(r *CDRRecord) error {
+	return w.enc.Encode(r)
+}
+
+// Exporter passes records to writers in a separate goroutine, so that slow writers don't delay calls.
+//
+// A nil Exporter is valid and ignores all records.
+type Exporter struct {
+	log     logger.Logger
+	writers []CDRWriter
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan *CDRRecord
+	done   chan struct{}
+}
+
+// NewExporter creates an exporter for given writers. It returns nil if there are no writers.
+func NewExporter(log logger.Logger, writers ...CDRWriter) *Exporter {
+	if len(writers) == 0 {
+		return nil
+	}
+	if log == nil {
+		log = logger.GetLogger()
+	}
+	e := &Exporter{
+		log:     log,
+		writers: writers,
+		queue:   make(chan *CDRRecord, queueSize),
+		done:    make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Export schedules the record for writing.
+func (e *Exporter) Export(r *CDRRecord) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.queue <- r:
+	default:
+		e.log.Warnw("CDR queue is full, dropping record", nil, "callID", r.CallID)
+	}
+}
+
+// Close writes queued records and closes the writers.
+func (e *Exporter) Close() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.mu.Unlock()
+	<-e.done
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+	for r := range e.queue {
+		for _, w := range e.writers {
+			if err := w.WriteCDR(r); err != nil {
+				e.log.Warnw("failed to write CDR", err, "callID", r.CallID)
+			}
+		}
+	}
+	for _, w := range e.writers {
+		if c, ok := w.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				e.log.Warnw("failed to close CDR writer", err)
+			}
+		}
+	}
+}
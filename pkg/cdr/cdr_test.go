@@ -0,0 +1,165 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdr
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/webhook"
+)
+
+var testStart = time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+func testRecord() *CDRRecord {
+	return &CDRRecord{
+		CallID:      "SCL_abc",
+		TrunkID:     "ST_a",
+		From:        "+15550100",
+		To:          "+15550199",
+		StartTime:   testStart,
+		AnswerTime:  testStart.Add(2 * time.Second),
+		EndTime:     testStart.Add(62500 * time.Millisecond),
+		Duration:    60.5,
+		Direction:   "inbound",
+		HangupCause: "bye",
+
+		PacketsSent:     3125,
+		PacketsReceived: 3120,
+		BytesSent:       537500,
+		BytesReceived:   536640,
+		LostPackets:     5,
+		JitterMs:        1.25,
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewCSVWriter(&buf)
+	require.NoError(t, w.WriteCDR(testRecord()))
+	r := testRecord()
+	r.CallID, r.AnswerTime, r.Duration, r.HangupCause = "SCL_def", time.Time{}, 0, "rejected, busy"
+	r.PacketsSent, r.PacketsReceived, r.BytesSent, r.BytesReceived, r.LostPackets, r.JitterMs = 0, 0, 0, 0, 0, 0
+	require.NoError(t, w.WriteCDR(r))
+	require.Equal(t, ""+
+		"call_id,trunk_id,from,to,start_time,answer_time,end_time,duration,direction,hangup_cause,"+
+		"packets_sent,packets_received,bytes_sent,bytes_received,lost_packets,jitter_ms\n"+
+		"SCL_abc,ST_a,+15550100,+15550199,2024-01-02T15:04:05.000Z,2024-01-02T15:04:07.000Z,2024-01-02T15:05:07.500Z,60.500,inbound,bye,"+
+		"3125,3120,537500,536640,5,1.250\n"+
+		"SCL_def,ST_a,+15550100,+15550199,2024-01-02T15:04:05.000Z,,2024-01-02T15:05:07.500Z,0.000,inbound,\"rejected, busy\",0,0,0,0,0,0.000\n",
+		buf.String())
+}
+
+func TestJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewJSONWriter(&buf)
+	require.NoError(t, w.WriteCDR(testRecord()))
+	r := testRecord()
+	r.AnswerTime, r.Duration = time.Time{}, 0
+	require.NoError(t, w.WriteCDR(r))
+	require.Equal(t, ""+
+		`{"call_id":"SCL_abc","trunk_id":"ST_a","from":"+15550100","to":"+15550199","start_time":"2024-01-02T15:04:05Z","end_time":"2024-01-02T15:05:07.5Z","duration":60.5,"direction":"inbound","hangup_cause":"bye","packets_sent":3125,"packets_received":3120,"bytes_sent":537500,"bytes_received":536640,"lost_packets":5,"jitter_ms":1.25,"answer_time":"2024-01-02T15:04:07Z"}`+"\n"+
+		`{"call_id":"SCL_abc","trunk_id":"ST_a","from":"+15550100","to":"+15550199","start_time":"2024-01-02T15:04:05Z","end_time":"2024-01-02T15:05:07.5Z","duration":0,"direction":"inbound","hangup_cause":"bye","packets_sent":3125,"packets_received":3120,"bytes_sent":537500,"bytes_received":536640,"lost_packets":5,"jitter_ms":1.25}`+"\n",
+		buf.String())
+
+	var got CDRRecord
+	require.NoError(t, json.Unmarshal(bytes.Split(buf.Bytes(), []byte("\n"))[0], &got))
+	require.Equal(t, *testRecord(), got)
+
+	_, err := NewWriter(&buf, "xml")
+	require.Error(t, err)
+}
+
+func TestFileWriter(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewFileWriter(filepath.Join(dir, "cdr.csv"), FormatCSV)
+	require.NoError(t, err)
+	now := testStart
+	w.now = func() time.Time { return now }
+
+	require.NoError(t, w.WriteCDR(testRecord()))
+	now = now.Add(30 * time.Minute)
+	require.NoError(t, w.WriteCDR(testRecord()))
+	now = now.Add(30 * time.Minute)
+	require.NoError(t, w.WriteCDR(testRecord()))
+	require.NoError(t, w.Close())
+
+	// Appending to an existing file doesn't repeat the header.
+	w, err = NewFileWriter(filepath.Join(dir, "cdr.csv"), FormatCSV)
+	require.NoError(t, err)
+	w.now = func() time.Time { return now }
+	require.NoError(t, w.WriteCDR(testRecord()))
+	require.NoError(t, w.Close())
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "cdr-2024010215.csv"),
+		filepath.Join(dir, "cdr-2024010216.csv"),
+	}, files)
+	lines := func(path string) int {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return bytes.Count(data, []byte("\n"))
+	}
+	require.Equal(t, 3, lines(files[0]))
+	require.Equal(t, 3, lines(files[1]))
+}
+
+func TestExporter(t *testing.T) {
+	require.Nil(t, NewExporter(nil))
+	var nilExporter *Exporter
+	nilExporter.Export(testRecord())
+	nilExporter.Close()
+
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		require.True(t, webhook.Verify([]byte("secret"), body, r.Header.Get(webhook.SignatureHeader)))
+		bodies <- body
+	}))
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	fw, err := NewFileWriter(filepath.Join(dir, "cdr.json"), FormatJSON)
+	require.NoError(t, err)
+	e := NewExporter(nil, fw, NewWebhookWriter(srv.URL, "secret"))
+	e.Export(testRecord())
+	select {
+	case body := <-bodies:
+		var got CDRRecord
+		require.NoError(t, json.Unmarshal(body, &got))
+		require.Equal(t, *testRecord(), got)
+	case <-time.After(5 * time.Second):
+		t.Fatal("CDR was not posted")
+	}
+	e.Close()
+	e.Export(testRecord()) // ignored
+
+	data, err := os.ReadFile(fw.Path(time.Now()))
+	require.NoError(t, err)
+	var got CDRRecord
+	require.NoError(t, json.Unmarshal(data, &got))
+	require.Equal(t, *testRecord(), got)
+}
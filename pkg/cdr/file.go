@@ -0,0 +1,96 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdr
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// rotateFormat is appended to file names, so that records of each hour are written to a separate file.
+const rotateFormat = "2006010215"
+
+// FileWriter writes records to a file, which is rotated hourly. The hour is added to the file name before
+// the extension, e.g. records for /var/log/cdr.csv are written to /var/log/cdr-2024010215.csv.
+// Existing files are appended to.
+type FileWriter struct {
+	path   string
+	format string
+	now    func() time.Time
+
+	hour string
+	f    *os.File
+	w    CDRWriter
+}
+
+// NewFileWriter creates a file writer with a given format, csv or json.
+func NewFileWriter(path, format string) (*FileWriter, error) {
+	// Check the format early, files are only opened on write.
+	if _, err := NewWriter(nil, format); err != nil {
+		return nil, err
+	}
+	return &FileWriter{path: path, format: format, now: time.Now}, nil
+}
+
+// Path returns the path of the file for a given time.
+func (w *FileWriter) Path(t time.Time) string {
+	ext := filepath.Ext(w.path)
+	return strings.TrimSuffix(w.path, ext) + "-" + t.UTC().Format(rotateFormat) + ext
+}
+
+func (w *FileWriter) WriteCDR(r *CDRRecord) error {
+	now := w.now()
+	if hour := now.UTC().Format(rotateFormat); w.f == nil || hour != w.hour {
+		if err := w.Close(); err != nil {
+			return err
+		}
+		if err := w.open(now); err != nil {
+			return err
+		}
+		w.hour = hour
+	}
+	return w.w.WriteCDR(r)
+}
+
+func (w *FileWriter) open(t time.Time) error {
+	f, err := os.OpenFile(w.Path(t), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fw, err := NewWriter(f, w.format)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	if cw, ok := fw.(*CSVWriter); ok {
+		if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+			cw.SkipHeader()
+		}
+	}
+	w.f, w.w = f, fw
+	return nil
+}
+
+// Close closes the current file. The next record opens it again.
+func (w *FileWriter) Close() error {
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f, w.w = nil, nil
+	return err
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/livekit/sip/pkg/webhook"
+)
+
+const requestTimeout = 5 * time.Second
+
+// WebhookWriter posts each record as JSON to a URL. If the secret is set, the body is signed
+// the same way as call events, see webhook.SignatureHeader.
+type WebhookWriter struct {
+	url    string
+	secret []byte
+	cli    *http.Client
+}
+
+func NewWebhookWriter(url, secret string) *WebhookWriter {
+	return &WebhookWriter{
+		url:    url,
+		secret: []byte(secret),
+		cli:    &http.Client{Timeout: requestTimeout},
+	}
+}
+
+func (w *WebhookWriter) WriteCDR(r *CDRRecord) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) != 0 {
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(w.secret, body))
+	}
+	resp, err := w.cli.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
@@ -15,10 +15,19 @@
 package config
 
 import (
+	"cmp"
+	goerrors "errors"
 	"fmt"
 	"net"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/pion/stun"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 
@@ -33,9 +42,122 @@ import (
 )
 
 const (
-	DefaultSIPPort int = 5060
+	DefaultSIPPort      int = 5060
+	DefaultMaxRedirects int = 3
+
+	DefaultOutboundRetryCount       = 2
+	DefaultOutboundRetryBackoffBase = time.Second
+
+	DefaultOptionsCapabilityCacheTTL = 5 * time.Minute
+	DefaultOptionsCapabilityTimeout  = 2 * time.Second
+	DefaultAuthCacheTTL              = time.Minute
+	DefaultMediaTimeout              = 30 * time.Second
+	DefaultPreBufferDuration         = 300 * time.Millisecond
+	DefaultPoolIdleTimeout           = time.Minute
+
+	DefaultParkingMaxSlots = 100
+
+	DefaultCallQueueMaxLength = 20
+
+	DefaultDTMFDataChannelTopic = "sip-dtmf"
+
+	DefaultPINMaxAttempts = 3
+
+	DefaultRegisterExpiry = 3600 // seconds
+	MaxPINDigits          = 16
+)
+
+// DTMFMode controls how DTMF digits are received from SIP participants.
+type DTMFMode string
+
+const (
+	DTMFModeRFC4733 DTMFMode = "rfc4733" // RTP events (RFC 4733)
+	DTMFModeInfo    DTMFMode = "info"    // SIP INFO requests
+	DTMFModeInband  DTMFMode = "inband"  // audio tones
+	DTMFModeAuto    DTMFMode = "auto"    // RTP events and SIP INFO; audio tones if RTP events are not negotiated
+)
+
+// DNSLoadBalanceMode controls how outbound calls pick the address of the trunk host.
+type DNSLoadBalanceMode string
+
+const (
+	DNSLoadBalanceFirst       DNSLoadBalanceMode = "first"        // host is resolved by the transport, which uses the first address
+	DNSLoadBalanceRoundRobin  DNSLoadBalanceMode = "round_robin"  // A records in turn, starting with the next address on each call
+	DNSLoadBalanceRandom      DNSLoadBalanceMode = "random"       // A records in a random order
+	DNSLoadBalanceWeightedSRV DNSLoadBalanceMode = "weighted_srv" // SRV records ordered by priority and weight, if published
 )
 
+// ListenerConfig describes a single SIP signaling listener.
+type ListenerConfig struct {
+	Transport string `yaml:"transport"` // udp, tcp or tls
+	Address   string `yaml:"address"`   // bind address; all interfaces by default
+	Port      int    `yaml:"port"`      // sip_port by default
+	CertFile  string `yaml:"cert_file"` // required for tls
+	KeyFile   string `yaml:"key_file"`  // required for tls
+}
+
+// ProxyAuthConfig sets credentials for SIP proxies, which respond to outbound INVITEs with 407.
+type ProxyAuthConfig struct {
+	ProxyAuthUser     string `yaml:"proxy_auth_user"`
+	ProxyAuthPassword string `yaml:"proxy_auth_password"`
+}
+
+// TURNConfig is a TURN server used to gather relay ICE candidates.
+type TURNConfig struct {
+	URL        string `yaml:"url"` // e.g. turn:turn.example.com:3478?transport=udp
+	Username   string `yaml:"username"`
+	Credential string `yaml:"credential"`
+}
+
+// OutboundFromConfig sets the display name in the From header of outbound INVITEs.
+type OutboundFromConfig struct {
+	// FromDisplayName is a fixed display name.
+	FromDisplayName string `yaml:"from_display_name"`
+	// FromDisplayNameTemplate overrides from_display_name. Tokens {name}, {number} and {room} are replaced
+	// with the participant name, the outbound number and the room name.
+	FromDisplayNameTemplate string `yaml:"from_display_name_template"`
+}
+
+// FromDisplayNameTokens lists tokens supported in from_display_name_template.
+var FromDisplayNameTokens = []string{"{name}", "{number}", "{room}"}
+
+var fromTemplateToken = regexp.MustCompile(`\{[^{}]*\}`)
+
+// DialPlanRule normalizes the number called by outbound calls.
+type DialPlanRule struct {
+	Match   string `yaml:"match"`   // Go regexp, which must match the whole number
+	Replace string `yaml:"replace"` // replacement, where $1 or ${name} refer to the groups of the match
+	Trunk   string `yaml:"trunk"`   // trunk ID the rule applies to; all trunks if empty
+}
+
+// Compile returns the regexp of the rule, anchored to match the whole number.
+func (r DialPlanRule) Compile() (*regexp.Regexp, error) {
+	return regexp.Compile(`^(?:` + r.Match + `)$`)
+}
+
+// RegisterConfig registers the service with an outbound trunk (RFC 3261, section 10),
+// for providers which only accept calls from registered users.
+type RegisterConfig struct {
+	RegisterEnabled  bool   `yaml:"register_enabled"`
+	RegisterUser     string `yaml:"register_user"`     // user of the registered address, also used for digest authentication
+	RegisterPassword string `yaml:"register_password"` // digest password, if the registrar requires authentication
+	RegisterExpiry   int    `yaml:"register_expiry"`   // requested registration lifetime in seconds; 3600 by default
+}
+
+// Expiry returns the requested registration lifetime.
+func (r RegisterConfig) Expiry() time.Duration {
+	if r.RegisterExpiry <= 0 {
+		return DefaultRegisterExpiry * time.Second
+	}
+	return time.Duration(r.RegisterExpiry) * time.Second
+}
+
+// TrunkRef refers to an outbound trunk listed in outbound_trunks.
+type TrunkRef struct {
+	ID       string `yaml:"id"`
+	Priority int    `yaml:"priority"` // trunks with lower values are tried first
+}
+
 var (
 	DefaultRTPPortRange = rtcconfig.PortRange{Start: 10000, End: 20000}
 )
@@ -49,19 +171,227 @@ type Config struct {
 	HealthPort     int                 `yaml:"health_port"`
 	PrometheusPort int                 `yaml:"prometheus_port"`
 	SIPPort        int                 `yaml:"sip_port"`
-	RTPPort        rtcconfig.PortRange `yaml:"rtp_port"`
-	Logging        logger.Config       `yaml:"logging"`
-	ClusterID      string              `yaml:"cluster_id"` // cluster this instance belongs to
+	Listeners      []ListenerConfig    `yaml:"listeners"`        // UDP listener on sip_port is used if not set
+	RTPPortMin     uint16              `yaml:"rtp_port_min"`     // first port of the RTP port pool
+	RTPPortMax     uint16              `yaml:"rtp_port_max"`     // last port of the RTP port pool
+	RTPPort        rtcconfig.PortRange `yaml:"rtp_port"`         // deprecated: use rtp_port_min and rtp_port_max
+	MaxActiveCalls int                 `yaml:"max_active_calls"` // used to validate the RTP port range; 0 means no check
+	MaxRedirects   *int                `yaml:"max_redirects"`    // max number of 3xx redirects to follow for outbound calls; 0 disables redirects
+	Logging        logger.Config       `yaml:"logging" hot_reload:"true"`
+	ClusterID      string              `yaml:"cluster_id"` // cluster this instance belongs to; empty uses the default RPC topic
+
+	// OutboundRetryCount is the number of times outbound INVITE is retried after 5xx or timeout. Zero disables retries.
+	OutboundRetryCount *int `yaml:"outbound_retry_count"`
+	// OutboundRetryBackoffBase is a delay before the first retry. It doubles with each retry.
+	OutboundRetryBackoffBase time.Duration `yaml:"outbound_retry_backoff_base"`
 
 	UseExternalIP bool   `yaml:"use_external_ip"`
 	LocalNet      string `yaml:"local_net"` // local IP net to use, e.g. 192.168.0.0/24
 	NAT1To1IP     string `yaml:"nat_1_to_1_ip"`
 
+	// NATKeepAliveInterval enables comfort noise RTP packets sent while the call audio is silent, to keep NAT mappings open.
+	NATKeepAliveInterval time.Duration `yaml:"nat_keepalive_interval" hot_reload:"true"`
+	// NATKeepAliveTrunks overrides nat_keepalive_interval per trunk ID.
+	NATKeepAliveTrunks map[string]time.Duration `yaml:"nat_keepalive_trunks" hot_reload:"true"`
+
+	// OutboundTrunks maps trunk IDs to outbound trunk addresses. Requests for outbound calls carry only the address,
+	// thus it's used to find the trunk ID for per-trunk settings of outbound calls.
+	OutboundTrunks map[string]string `yaml:"outbound_trunks"`
+
+	// MaxConcurrentCalls limits the number of concurrent calls per trunk ID, counted separately for inbound and outbound calls.
+	// Outbound trunks must be listed in outbound_trunks. No limit if not set.
+	MaxConcurrentCalls map[string]int `yaml:"max_concurrent_calls" hot_reload:"true"`
+	// MaxCallDuration limits the duration of answered calls per trunk ID. Calls are hung up once the limit is reached.
+	// Outbound trunks must be listed in outbound_trunks. No limit if not set or zero.
+	MaxCallDuration map[string]time.Duration `yaml:"max_call_duration"`
+
+	// OutboundFrom sets the display name of the From header per trunk ID. Outbound trunks must be listed in outbound_trunks.
+	// The outbound number is used if not set.
+	OutboundFrom map[string]OutboundFromConfig `yaml:"outbound_from"`
+
+	// DialPlan normalizes numbers called by outbound calls. The first rule matching the number is applied.
+	// Trunks of the rules must be listed in outbound_trunks.
+	DialPlan []DialPlanRule `yaml:"dial_plan"`
+
+	// OutboundTrunkFailover lists trunks to try for outbound calls, keyed by the trunk ID of the call. The next trunk is
+	// tried if the current one is unreachable: the connection fails, or it responds with 503. Trunks are tried in the
+	// order of priority after the trunk of the call. All trunks must be listed in outbound_trunks.
+	OutboundTrunkFailover map[string][]TrunkRef `yaml:"outbound_trunk_failover"`
+
+	// DNSLoadBalanceMode sets how outbound calls spread over the addresses of the trunk host, keyed by trunk ID.
+	// Outbound trunks must be listed in outbound_trunks. Calls fail over to the next address if the current one
+	// is unreachable. Trunks use weighted_srv if not set.
+	DNSLoadBalanceMode map[string]DNSLoadBalanceMode `yaml:"dns_load_balance_mode"`
+
+	// PoolMaxConns enables reuse of TCP connections to trunk hosts for outbound calls, and limits the number of
+	// connections kept open. Each outbound INVITE over TCP opens a new connection if not set.
+	PoolMaxConns int `yaml:"pool_max_conns"`
+	// PoolIdleTimeout is how long pooled TCP connections are kept open without outbound calls.
+	PoolIdleTimeout time.Duration `yaml:"pool_idle_timeout"`
+
+	// QueryCapabilitiesBeforeDial enables SIP OPTIONS requests to discover codecs supported by the trunk before
+	// placing outbound calls. Keyed by trunk address.
+	QueryCapabilitiesBeforeDial map[string]bool `yaml:"query_capabilities_before_dial"`
+	// OptionsCapabilityCacheTTL is how long the OPTIONS response of the trunk is reused for new calls.
+	OptionsCapabilityCacheTTL time.Duration `yaml:"options_capability_cache_ttl"`
+	// OptionsCapabilityTimeout is how long to wait for the OPTIONS response before using the default offer.
+	OptionsCapabilityTimeout time.Duration `yaml:"options_capability_timeout"`
+
+	// AuthCacheTTL is how long trunk authentication of inbound calls is reused for the same caller,
+	// without asking LiveKit server again. Failed lookups are not cached.
+	AuthCacheTTL time.Duration `yaml:"auth_cache_ttl"`
+
+	// OutboundRegister registers the service with trunk providers, keyed by trunk ID. Registrations are renewed
+	// before they expire. Trunks must be listed in outbound_trunks.
+	OutboundRegister map[string]RegisterConfig `yaml:"outbound_register"`
+
+	// ProxyAuth sets separate credentials for proxy authentication, keyed by trunk address.
+	// Trunk credentials are used for both proxy and endpoint authentication if not set.
+	ProxyAuth map[string]ProxyAuthConfig `yaml:"proxy_auth"`
+
 	Codecs map[string]bool `yaml:"codecs"`
+	// CodecPreference overrides the default codec order per trunk ID. Outbound trunks must be listed in outbound_trunks.
+	// Codecs are listed by SDP name (e.g. "PCMU/8000") or encoding name (e.g. "PCMU"). Other enabled codecs are ranked after.
+	CodecPreference map[string][]string `yaml:"codec_preference"`
+	// OpusEncoderBitrate sets the bitrate of audio published to LiveKit, 6000-510000 bps. Library default if not set.
+	OpusEncoderBitrate int `yaml:"opus_encoder_bitrate"`
+	// OpusEncoderComplexity sets the complexity of the Opus encoder, 0-10. Library default if not set.
+	OpusEncoderComplexity *int     `yaml:"opus_encoder_complexity"`
+	DTMFMode              DTMFMode `yaml:"dtmf_mode"` // auto by default
+	// DTMFDataChannelTopic is the data topic on which DTMF digits received from SIP participants are published
+	// to the room as JSON, in addition to SIP DTMF packets.
+	DTMFDataChannelTopic string `yaml:"dtmf_data_channel_topic"`
+
+	// PINDigits is the number of digits of PINs requested by dispatch rules. The PIN is checked once all digits are
+	// entered, or when # is pressed. If not set, the PIN must be terminated by #.
+	PINDigits int `yaml:"pin_digits"`
+	// PINTimeout is how long to wait for the next PIN digit before the prompt is repeated. No timeout if not set.
+	PINTimeout time.Duration `yaml:"pin_timeout"`
+	// PINMaxAttempts is the number of PIN prompts after which the call is closed on timeout.
+	PINMaxAttempts int `yaml:"pin_max_attempts"`
+	// PINPromptFile is a WebM file with PCM audio played instead of the default PIN prompt. It's the fallback
+	// for CallDispatch.PinPromptFile, used when the dispatch doesn't set a prompt. It's read once on start.
+	PINPromptFile string `yaml:"pin_prompt_file"`
+
+	// ComfortNoise replaces digital silence of the audio sent to SIP with low-level white noise.
+	ComfortNoise      bool    `yaml:"comfort_noise"`
+	ComfortNoiseLevel float64 `yaml:"comfort_noise_level"` // RMS level in dBFS, -60 by default
+	// NoiseReduction reduces stationary background noise of the audio sent to SIP with spectral subtraction.
+	NoiseReduction bool `yaml:"noise_reduction"`
+
+	WebhookURL    string `yaml:"webhook_url"`    // call lifecycle events are posted to this URL
+	WebhookSecret string `yaml:"webhook_secret"` // used to sign webhook payloads with HMAC-SHA256
+
+	// CDRFile enables call detail records, written to this file at the end of each call. The file is rotated hourly,
+	// with the hour added to the name, e.g. cdr.csv becomes cdr-2024010215.csv.
+	CDRFile   string `yaml:"cdr_file"`
+	CDRFormat string `yaml:"cdr_format"` // csv or json (one object per line); json by default
+	// CDRWebhookURL receives call detail records as JSON, signed with webhook_secret.
+	CDRWebhookURL string `yaml:"cdr_webhook_url"`
+
+	// TranscriptionWebhookURL receives audio of each SIP caller, posted in batches as raw PCM.
+	// Transcripts published by room participants on the transcription data topic are relayed to the caller with SIP INFO.
+	TranscriptionWebhookURL string `yaml:"transcription_webhook_url"`
+
+	// T38FaxServer is the UDPTL address (host:port) of the fax server. If set, T.38 re-INVITEs of inbound calls are accepted
+	// and the fax stream of the caller is relayed to this address, instead of being decoded as audio.
+	T38FaxServer string `yaml:"t38_fax_server"`
+
+	// PresenceWebhookPort is the port for receiving LiveKit participant webhooks, which drive presence NOTIFY.
+	PresenceWebhookPort int `yaml:"presence_webhook_port"`
+
+	// ControlWSPort is the port of the WebSocket API for controlling active calls: /calls/{call_id}/control.
+	// Connections are authorized with tokens signed by the API secret.
+	ControlWSPort int `yaml:"control_ws_port"`
+
+	// PublishURI is the event state compositor, which receives SIP PUBLISH with the state of each call (RFC 3903).
+	PublishURI string `yaml:"publish_uri"`
+	// PublishExpires is the publication lifetime requested from the compositor. Publications are refreshed before they expire.
+	PublishExpires time.Duration `yaml:"publish_expires"`
+
+	MusicOnHoldFile string `yaml:"music_on_hold_file"` // raw 16 bit PCM, 8 kHz mono; played in a loop
+	MusicOnHoldURL  string `yaml:"music_on_hold_url"`  // HTTP stream with the same audio format as the file
+
+	// RecordingAnnouncementFile is played to inbound callers before they join the room (e.g. recording consent).
+	// Same format as music_on_hold_file.
+	RecordingAnnouncementFile string `yaml:"recording_announcement_file"`
+
+	// LiveKitDataChannelDialEnabled allows room participants to start outbound calls
+	// by publishing "DIAL:<number>@<trunk_id>" on the data channel.
+	LiveKitDataChannelDialEnabled bool `yaml:"livekit_data_channel_dial_enabled"`
+	// LiveKitDataChannelTransferEnabled allows room participants to transfer SIP participants
+	// by publishing "TRANSFER:<call_id>:<target_uri>" on the data channel.
+	LiveKitDataChannelTransferEnabled bool `yaml:"livekit_data_channel_transfer_enabled"`
+	// LiveKitDataChannelSenders lists participant identities allowed to send DIAL and TRANSFER requests.
+	// A trailing "*" matches any identity with a given prefix. Required when either feature is enabled.
+	LiveKitDataChannelSenders []string `yaml:"livekit_data_channel_senders"`
+
+	// ParkingEnabled allows SIP participants to park calls with REFER to sip:park@<host>. Parked calls are
+	// retrieved with INVITE to sip:slot-<N>@<host>. Slots are kept in memory, so parking only works on a single node.
+	ParkingEnabled bool `yaml:"parking_enabled"`
+	// ParkingMaxSlots limits the number of parked calls.
+	ParkingMaxSlots int `yaml:"parking_max_slots"`
+	// ParkingAnnouncementDir contains recordings used to announce the slot: parked.pcm, and 0.pcm to 9.pcm for digits.
+	// Same format as music_on_hold_file. Missing digits are played as DTMF tones.
+	ParkingAnnouncementDir string `yaml:"parking_announcement_dir"`
+
+	// CallQueueEnabled queues inbound calls when the trunk reaches max_concurrent_calls, instead of rejecting them.
+	// Queued calls are answered, and bridged in order once other calls on the trunk end.
+	CallQueueEnabled bool `yaml:"call_queue_enabled"`
+	// CallQueueMaxLength limits the number of queued calls on each trunk. Calls are rejected when the queue is full.
+	CallQueueMaxLength int `yaml:"call_queue_max_length"`
+	// CallQueueAnnouncementTemplate is a path of recordings announcing the queue position, with "{position}"
+	// replaced by the position, e.g. /prompts/queue-{position}.pcm. Same format as music_on_hold_file.
+	// Positions without a recording are played as DTMF tones.
+	CallQueueAnnouncementTemplate string `yaml:"call_queue_announcement_template"`
+
+	// DTLSSRTPEnabled accepts inbound offers with DTLS-SRTP media (RFC 5763). Such offers are rejected if not set.
+	DTLSSRTPEnabled bool `yaml:"dtls_srtp_enabled"`
+	// DTLSSRTPOutbound offers DTLS-SRTP media for outbound calls. Requires dtls_srtp_enabled.
+	DTLSSRTPOutbound bool `yaml:"dtls_srtp_outbound"`
+
+	// ICEEnabled adds ICE candidates gathered on the RTP port to SDP (RFC 8839), for bridging to WebRTC endpoints.
+	// Outbound calls always offer ICE, while inbound calls only use it if the offer has ICE attributes.
+	ICEEnabled bool `yaml:"ice_enabled"`
+	// STUNServers are used to gather server reflexive ICE candidates, e.g. stun:stun.example.com:3478.
+	STUNServers []string `yaml:"stun_servers"`
+	// TURNServers are used to gather relay ICE candidates. Media is only sent from the RTP port,
+	// thus pairs with a local relay candidate keep the media address from SDP.
+	TURNServers []TURNConfig `yaml:"turn_servers"`
+
+	// SMIMECertFile and SMIMEKeyFile are used to sign SDP of outbound INVITEs with S/MIME (RFC 3261, Section 23).
+	// Signed inbound bodies are verified regardless, and mismatches are logged.
+	SMIMECertFile string `yaml:"smime_cert_file"`
+	SMIMEKeyFile  string `yaml:"smime_key_file"`
+
+	// MediaTimeoutDetection detects one-way audio. If no RTP is received for media_timeout, the session is refreshed
+	// with re-INVITE, and the call is closed if media doesn't resume within another timeout.
+	MediaTimeoutDetection bool          `yaml:"media_timeout_detection"`
+	MediaTimeout          time.Duration `yaml:"media_timeout"`
+
+	// RTCPXREnabled adds RTCP XR VoIP metrics reports (RFC 3611) to RTCP sender reports.
+	RTCPXREnabled bool `yaml:"rtcp_xr_enabled"`
+
+	// RedundancyLevel is the number of previous audio frames sent with each RTP packet as redundant audio (RFC 2198),
+	// if the caller offers it. Lost packets are recovered from the redundancy the caller sends. Disabled if zero.
+	RedundancyLevel int `yaml:"redundancy_level"`
+
+	// PreBufferDuration is how much audio of the caller received before the participant joins the room is kept.
+	// Buffered audio is sent to the room first once the participant joins. Disabled if negative.
+	PreBufferDuration time.Duration `yaml:"pre_buffer_duration"`
+
+	// PPROFPerCallEnabled writes CPU and heap profiles for each call to temp files. Calls are distinguished by the call_id profiler label.
+	PPROFPerCallEnabled bool `yaml:"pprof_per_call_enabled"`
+
+	// TrunkStatusEnabled serves the state of trunks on /trunks of the Prometheus port. The endpoint is not authenticated.
+	TrunkStatusEnabled bool `yaml:"trunk_status_enabled"`
 
 	// internal
 	ServiceName string `yaml:"-"`
 	NodeID      string // Do not provide, will be overwritten
+
+	hotMu      sync.RWMutex // protects fields tagged with hot_reload, except for logging
+	hotChanged []string     // YAML keys of fields set by HotReload
 }
 
 func NewConfig(confString string) (*Config, error) {
@@ -86,26 +416,502 @@ func NewConfig(confString string) (*Config, error) {
 
 func (conf *Config) Init() error {
 	conf.NodeID = utils.NewGuid("NE_")
+	conf.setDefaults()
+
+	if err := conf.InitLogger(); err != nil {
+		return err
+	}
+
+	return conf.Validate()
+}
 
+func (conf *Config) setDefaults() {
 	if conf.SIPPort == 0 {
 		conf.SIPPort = DefaultSIPPort
 	}
-	if conf.RTPPort.Start == 0 {
-		conf.RTPPort.Start = DefaultRTPPortRange.Start
+	if conf.RTPPortMin == 0 && conf.RTPPortMax == 0 && conf.RTPPort.End <= 0xFFFF {
+		conf.RTPPortMin, conf.RTPPortMax = uint16(conf.RTPPort.Start), uint16(conf.RTPPort.End)
+	}
+	if conf.RTPPortMin == 0 {
+		conf.RTPPortMin = uint16(DefaultRTPPortRange.Start)
+	}
+	if conf.RTPPortMax == 0 {
+		conf.RTPPortMax = uint16(DefaultRTPPortRange.End)
+	}
+	if conf.OutboundRetryBackoffBase == 0 {
+		conf.OutboundRetryBackoffBase = DefaultOutboundRetryBackoffBase
+	}
+	if conf.DTMFMode == "" {
+		conf.DTMFMode = DTMFModeAuto
+	}
+	if conf.DTMFDataChannelTopic == "" {
+		conf.DTMFDataChannelTopic = DefaultDTMFDataChannelTopic
+	}
+	if conf.OptionsCapabilityCacheTTL == 0 {
+		conf.OptionsCapabilityCacheTTL = DefaultOptionsCapabilityCacheTTL
+	}
+	if conf.OptionsCapabilityTimeout == 0 {
+		conf.OptionsCapabilityTimeout = DefaultOptionsCapabilityTimeout
+	}
+	if conf.AuthCacheTTL == 0 {
+		conf.AuthCacheTTL = DefaultAuthCacheTTL
+	}
+	if conf.PreBufferDuration == 0 {
+		conf.PreBufferDuration = DefaultPreBufferDuration
+	}
+	if conf.ParkingMaxSlots == 0 {
+		conf.ParkingMaxSlots = DefaultParkingMaxSlots
+	}
+	if conf.CallQueueMaxLength == 0 {
+		conf.CallQueueMaxLength = DefaultCallQueueMaxLength
 	}
-	if conf.RTPPort.End == 0 {
-		conf.RTPPort.End = DefaultRTPPortRange.End
+	if conf.MediaTimeout == 0 {
+		conf.MediaTimeout = DefaultMediaTimeout
 	}
+	if conf.PINMaxAttempts == 0 {
+		conf.PINMaxAttempts = DefaultPINMaxAttempts
+	}
+	if conf.PoolIdleTimeout == 0 {
+		conf.PoolIdleTimeout = DefaultPoolIdleTimeout
+	}
+}
 
-	if err := conf.InitLogger(); err != nil {
-		return err
+// Validate checks config invariants. It returns an error listing all violations.
+func (conf *Config) Validate() error {
+	var errs []error
+	checkPort := func(name string, port int) {
+		if port < 0 || port > 65535 {
+			errs = append(errs, fmt.Errorf("invalid %s: %d", name, port))
+		}
+	}
+	checkPort("sip_port", conf.SIPPort)
+	if len(conf.Listeners) != 0 {
+		errs = append(errs, conf.validateListeners()...)
+	}
+	checkPort("health_port", conf.HealthPort)
+	checkPort("prometheus_port", conf.PrometheusPort)
+	checkPort("presence_webhook_port", conf.PresenceWebhookPort)
+	checkPort("control_ws_port", conf.ControlWSPort)
+	if conf.ControlWSPort > 0 && conf.ApiSecret == "" {
+		errs = append(errs, fmt.Errorf("control_ws_port requires api_secret"))
+	}
+	if conf.RTPPort.Start > 65535 || conf.RTPPort.End > 65535 || conf.RTPPort.Start > conf.RTPPort.End {
+		errs = append(errs, fmt.Errorf("invalid rtp_port range: %d-%d", conf.RTPPort.Start, conf.RTPPort.End))
+	}
+	if conf.RTPPortMin > conf.RTPPortMax {
+		errs = append(errs, fmt.Errorf("invalid rtp_port_min and rtp_port_max: %d-%d", conf.RTPPortMin, conf.RTPPortMax))
+	} else if n := int(conf.RTPPortMax) - int(conf.RTPPortMin) + 1; conf.RTPPortMax != 0 && conf.MaxActiveCalls > 0 && n < conf.MaxActiveCalls {
+		errs = append(errs, fmt.Errorf("rtp port range %d-%d has only %d ports, which is not enough for max_active_calls=%d",
+			conf.RTPPortMin, conf.RTPPortMax, n, conf.MaxActiveCalls))
+	}
+	if conf.MaxActiveCalls < 0 {
+		errs = append(errs, fmt.Errorf("invalid max_active_calls: %d", conf.MaxActiveCalls))
+	}
+	// Cluster ID is used as the RPC topic, thus it must not contain channel delimiters or whitespace.
+	if strings.ContainsAny(conf.ClusterID, "|. \t\r\n") {
+		errs = append(errs, fmt.Errorf("invalid cluster_id: %q", conf.ClusterID))
+	}
+	if n := conf.MaxRedirects; n != nil && *n < 0 {
+		errs = append(errs, fmt.Errorf("invalid max_redirects: %d", *n))
+	}
+	if n := conf.OutboundRetryCount; n != nil && *n < 0 {
+		errs = append(errs, fmt.Errorf("invalid outbound_retry_count: %d", *n))
+	}
+	if conf.OutboundRetryBackoffBase < 0 {
+		errs = append(errs, fmt.Errorf("invalid outbound_retry_backoff_base: %v", conf.OutboundRetryBackoffBase))
+	}
+
+	if conf.NATKeepAliveInterval < 0 {
+		errs = append(errs, fmt.Errorf("invalid nat_keepalive_interval: %v", conf.NATKeepAliveInterval))
+	}
+	for trunk, dt := range conf.NATKeepAliveTrunks {
+		if dt < 0 {
+			errs = append(errs, fmt.Errorf("invalid nat_keepalive_trunks interval for %q: %v", trunk, dt))
+		}
+	}
+	seenAddr := make(map[string]string)
+	for trunk, addr := range conf.OutboundTrunks {
+		key := strings.ToLower(addr)
+		if addr == "" {
+			errs = append(errs, fmt.Errorf("invalid outbound_trunks address for %q: empty", trunk))
+		} else if other, ok := seenAddr[key]; ok {
+			errs = append(errs, fmt.Errorf("invalid outbound_trunks: %q and %q have the same address %q", min(trunk, other), max(trunk, other), addr))
+		}
+		seenAddr[key] = trunk
+	}
+	for trunk, n := range conf.MaxConcurrentCalls {
+		if n < 0 {
+			errs = append(errs, fmt.Errorf("invalid max_concurrent_calls for %q: %d", trunk, n))
+		}
+	}
+	for trunk, dt := range conf.MaxCallDuration {
+		if dt < 0 {
+			errs = append(errs, fmt.Errorf("invalid max_call_duration for %q: %v", trunk, dt))
+		}
+	}
+
+	for trunk, from := range conf.OutboundFrom {
+		for _, tok := range fromTemplateToken.FindAllString(from.FromDisplayNameTemplate, -1) {
+			if !slices.Contains(FromDisplayNameTokens, tok) {
+				errs = append(errs, fmt.Errorf("invalid outbound_from template for %q: unknown token %s", trunk, tok))
+			}
+		}
+	}
+
+	for i, rule := range conf.DialPlan {
+		if rule.Match == "" {
+			errs = append(errs, fmt.Errorf("invalid dial_plan[%d]: match is not set", i))
+		} else if _, err := rule.Compile(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid dial_plan[%d] match: %w", i, err))
+		}
+	}
+
+	for trunk, refs := range conf.OutboundTrunkFailover {
+		if _, ok := conf.OutboundTrunks[trunk]; !ok {
+			errs = append(errs, fmt.Errorf("invalid outbound_trunk_failover: trunk %q is not listed in outbound_trunks", trunk))
+		}
+		for i, ref := range refs {
+			if _, ok := conf.OutboundTrunks[ref.ID]; !ok {
+				errs = append(errs, fmt.Errorf("invalid outbound_trunk_failover[%q][%d]: trunk %q is not listed in outbound_trunks", trunk, i, ref.ID))
+			}
+		}
+	}
+
+	for trunk, mode := range conf.DNSLoadBalanceMode {
+		switch mode {
+		case DNSLoadBalanceFirst, DNSLoadBalanceRoundRobin, DNSLoadBalanceRandom, DNSLoadBalanceWeightedSRV:
+		default:
+			errs = append(errs, fmt.Errorf("invalid dns_load_balance_mode for %q: %q", trunk, mode))
+		}
+	}
+
+	if conf.OptionsCapabilityCacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("invalid options_capability_cache_ttl: %v", conf.OptionsCapabilityCacheTTL))
+	}
+	if conf.OptionsCapabilityTimeout < 0 {
+		errs = append(errs, fmt.Errorf("invalid options_capability_timeout: %v", conf.OptionsCapabilityTimeout))
+	}
+	if conf.RedundancyLevel < 0 {
+		errs = append(errs, fmt.Errorf("invalid redundancy_level: %d", conf.RedundancyLevel))
+	}
+	if conf.AuthCacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("invalid auth_cache_ttl: %v", conf.AuthCacheTTL))
+	}
+
+	for trunk, reg := range conf.OutboundRegister {
+		if _, ok := conf.OutboundTrunks[trunk]; !ok {
+			errs = append(errs, fmt.Errorf("invalid outbound_register: trunk %q is not listed in outbound_trunks", trunk))
+		}
+		if reg.RegisterEnabled && reg.RegisterUser == "" {
+			errs = append(errs, fmt.Errorf("invalid outbound_register for %q: register_user must be set", trunk))
+		}
+		if reg.RegisterExpiry < 0 {
+			errs = append(errs, fmt.Errorf("invalid outbound_register expiry for %q: %d", trunk, reg.RegisterExpiry))
+		}
+	}
+
+	for trunk, auth := range conf.ProxyAuth {
+		if auth.ProxyAuthUser == "" || auth.ProxyAuthPassword == "" {
+			errs = append(errs, fmt.Errorf("invalid proxy_auth for %q: both user and password must be set", trunk))
+		}
+	}
+
+	if conf.OpusEncoderBitrate != 0 && (conf.OpusEncoderBitrate < 6000 || conf.OpusEncoderBitrate > 510000) {
+		errs = append(errs, fmt.Errorf("invalid opus_encoder_bitrate: %d", conf.OpusEncoderBitrate))
+	}
+	if c := conf.OpusEncoderComplexity; c != nil && (*c < 0 || *c > 10) {
+		errs = append(errs, fmt.Errorf("invalid opus_encoder_complexity: %d", *c))
+	}
+	if conf.ComfortNoiseLevel > 0 {
+		errs = append(errs, fmt.Errorf("invalid comfort_noise_level: %v", conf.ComfortNoiseLevel))
+	}
+
+	switch conf.DTMFMode {
+	case "", DTMFModeRFC4733, DTMFModeInfo, DTMFModeInband, DTMFModeAuto:
+	default:
+		errs = append(errs, fmt.Errorf("invalid dtmf_mode: %q", conf.DTMFMode))
 	}
 
 	if conf.UseExternalIP && conf.NAT1To1IP != "" {
-		return fmt.Errorf("use_external_ip and nat_1_to_1_ip can not both be set")
+		errs = append(errs, fmt.Errorf("use_external_ip and nat_1_to_1_ip can not both be set"))
+	}
+	if conf.NAT1To1IP != "" && net.ParseIP(conf.NAT1To1IP) == nil {
+		errs = append(errs, fmt.Errorf("invalid nat_1_to_1_ip: %q", conf.NAT1To1IP))
+	}
+	if conf.LocalNet != "" {
+		if _, _, err := net.ParseCIDR(conf.LocalNet); err != nil {
+			errs = append(errs, fmt.Errorf("invalid local_net: %w", err))
+		}
+	}
+	if conf.WebhookURL != "" {
+		if u, err := url.Parse(conf.WebhookURL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid webhook_url: %w", err))
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("invalid webhook_url: unsupported scheme %q", u.Scheme))
+		}
+	}
+	if conf.CDRFormat != "" && conf.CDRFormat != "csv" && conf.CDRFormat != "json" {
+		errs = append(errs, fmt.Errorf("invalid cdr_format: %q", conf.CDRFormat))
+	}
+	if conf.CDRWebhookURL != "" {
+		if u, err := url.Parse(conf.CDRWebhookURL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid cdr_webhook_url: %w", err))
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("invalid cdr_webhook_url: unsupported scheme %q", u.Scheme))
+		}
+	}
+	if conf.TranscriptionWebhookURL != "" {
+		if u, err := url.Parse(conf.TranscriptionWebhookURL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid transcription_webhook_url: %w", err))
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("invalid transcription_webhook_url: unsupported scheme %q", u.Scheme))
+		}
+	}
+	if conf.T38FaxServer != "" {
+		if _, port, err := net.SplitHostPort(conf.T38FaxServer); err != nil || port == "" {
+			errs = append(errs, fmt.Errorf("invalid t38_fax_server: %q", conf.T38FaxServer))
+		}
+	}
+	if conf.PublishExpires < 0 {
+		errs = append(errs, fmt.Errorf("invalid publish_expires: %v", conf.PublishExpires))
+	}
+	if conf.MediaTimeout < 0 {
+		errs = append(errs, fmt.Errorf("invalid media_timeout: %v", conf.MediaTimeout))
+	}
+	if conf.PINDigits < 0 || conf.PINDigits > MaxPINDigits {
+		errs = append(errs, fmt.Errorf("invalid pin_digits: %d", conf.PINDigits))
+	}
+	if conf.PINTimeout < 0 {
+		errs = append(errs, fmt.Errorf("invalid pin_timeout: %v", conf.PINTimeout))
+	}
+	if conf.PINMaxAttempts < 0 {
+		errs = append(errs, fmt.Errorf("invalid pin_max_attempts: %d", conf.PINMaxAttempts))
+	}
+	if conf.PoolMaxConns < 0 {
+		errs = append(errs, fmt.Errorf("invalid pool_max_conns: %d", conf.PoolMaxConns))
+	}
+	if conf.PoolIdleTimeout < 0 {
+		errs = append(errs, fmt.Errorf("invalid pool_idle_timeout: %v", conf.PoolIdleTimeout))
+	}
+	if conf.ParkingMaxSlots < 0 {
+		errs = append(errs, fmt.Errorf("invalid parking_max_slots: %d", conf.ParkingMaxSlots))
+	}
+	if conf.CallQueueMaxLength < 0 {
+		errs = append(errs, fmt.Errorf("invalid call_queue_max_length: %d", conf.CallQueueMaxLength))
+	}
+	if tmpl := conf.CallQueueAnnouncementTemplate; tmpl != "" && !strings.Contains(tmpl, "{position}") {
+		errs = append(errs, fmt.Errorf("invalid call_queue_announcement_template: %q has no {position}", tmpl))
+	}
+	if conf.DTLSSRTPOutbound && !conf.DTLSSRTPEnabled {
+		errs = append(errs, fmt.Errorf("dtls_srtp_outbound requires dtls_srtp_enabled"))
+	}
+	if _, err := conf.ICEServers(); err != nil {
+		errs = append(errs, err)
+	}
+	if !conf.ICEEnabled && (len(conf.STUNServers) != 0 || len(conf.TURNServers) != 0) {
+		errs = append(errs, fmt.Errorf("stun_servers and turn_servers require ice_enabled"))
+	}
+	if (conf.SMIMECertFile == "") != (conf.SMIMEKeyFile == "") {
+		errs = append(errs, fmt.Errorf("smime_cert_file and smime_key_file must be set together"))
+	}
+	if (conf.LiveKitDataChannelDialEnabled || conf.LiveKitDataChannelTransferEnabled) && len(conf.LiveKitDataChannelSenders) == 0 {
+		errs = append(errs, fmt.Errorf("livekit_data_channel_senders must be set when data channel dial or transfer is enabled"))
+	}
+	for _, id := range conf.LiveKitDataChannelSenders {
+		if id == "" || strings.Contains(strings.TrimSuffix(id, "*"), "*") {
+			errs = append(errs, fmt.Errorf("invalid livekit_data_channel_senders identity: %q", id))
+		}
+	}
+	if conf.MusicOnHoldFile != "" && conf.MusicOnHoldURL != "" {
+		errs = append(errs, fmt.Errorf("music_on_hold_file and music_on_hold_url can not both be set"))
+	}
+	return goerrors.Join(errs...)
+}
+
+func (conf *Config) validateListeners() []error {
+	var errs []error
+	type listenKey struct {
+		network string
+		addr    string
+		port    int
+	}
+	listeners := make(map[listenKey]struct{})
+	for i, l := range conf.SIPListeners() {
+		if l.Port < 0 || l.Port > 65535 {
+			errs = append(errs, fmt.Errorf("listeners[%d]: invalid port: %d", i, l.Port))
+		}
+		network := "tcp"
+		switch l.Transport {
+		case "udp":
+			network = "udp"
+		case "tcp":
+		case "tls":
+			if l.CertFile == "" || l.KeyFile == "" {
+				errs = append(errs, fmt.Errorf("listeners[%d]: cert_file and key_file are required for tls", i))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("listeners[%d]: invalid transport: %q", i, l.Transport))
+		}
+		if l.Address != "" && net.ParseIP(l.Address) == nil {
+			errs = append(errs, fmt.Errorf("listeners[%d]: invalid address: %q", i, l.Address))
+		}
+		k := listenKey{network, l.Address, l.Port}
+		if _, ok := listeners[k]; ok {
+			errs = append(errs, fmt.Errorf("listeners[%d]: duplicate %s listener on port %d", i, network, l.Port))
+		}
+		listeners[k] = struct{}{}
 	}
+	return errs
+}
 
-	return nil
+// SIPListeners returns all configured SIP listeners. By default, it's a single UDP listener on SIPPort.
+func (conf *Config) SIPListeners() []ListenerConfig {
+	if len(conf.Listeners) == 0 {
+		return []ListenerConfig{{Transport: "udp", Port: conf.SIPPort}}
+	}
+	out := make([]ListenerConfig, 0, len(conf.Listeners))
+	for _, l := range conf.Listeners {
+		if l.Port == 0 {
+			l.Port = conf.SIPPort
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+// GetMaxRedirects returns max number of redirects to follow for outbound calls. Zero means redirects are not followed.
+func (conf *Config) GetMaxRedirects() int {
+	if conf.MaxRedirects == nil {
+		return DefaultMaxRedirects
+	}
+	return *conf.MaxRedirects
+}
+
+// GetMediaTimeout returns the time without RTP after which the audio is considered one-way.
+func (conf *Config) GetMediaTimeout() time.Duration {
+	if conf.MediaTimeout <= 0 {
+		return DefaultMediaTimeout
+	}
+	return conf.MediaTimeout
+}
+
+// GetOutboundRetryCount returns the number of retries for outbound INVITEs. Zero means INVITEs are not retried.
+func (conf *Config) GetOutboundRetryCount() int {
+	if conf.OutboundRetryCount == nil {
+		return DefaultOutboundRetryCount
+	}
+	return *conf.OutboundRetryCount
+}
+
+// OutboundTrunkID returns the ID of the outbound trunk with a given address, as set in outbound_trunks.
+// It returns an empty string if the trunk is not listed.
+func (conf *Config) OutboundTrunkID(address string) string {
+	for id, addr := range conf.OutboundTrunks {
+		if strings.EqualFold(addr, address) {
+			return id
+		}
+	}
+	return ""
+}
+
+// GetDNSLoadBalanceMode returns the DNS load balancing mode of the outbound trunk, as set in dns_load_balance_mode.
+func (conf *Config) GetDNSLoadBalanceMode(trunkID string) DNSLoadBalanceMode {
+	if mode, ok := conf.DNSLoadBalanceMode[trunkID]; ok && trunkID != "" {
+		return mode
+	}
+	return DNSLoadBalanceWeightedSRV
+}
+
+// ICEServers returns URIs of STUN and TURN servers used to gather ICE candidates, with TURN credentials.
+func (conf *Config) ICEServers() ([]*stun.URI, error) {
+	var out []*stun.URI
+	for i, s := range conf.STUNServers {
+		u, err := stun.ParseURI(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stun_servers[%d]: %w", i, err)
+		}
+		if u.Scheme != stun.SchemeTypeSTUN && u.Scheme != stun.SchemeTypeSTUNS {
+			return nil, fmt.Errorf("invalid stun_servers[%d]: not a STUN server: %q", i, s)
+		}
+		out = append(out, u)
+	}
+	for i, t := range conf.TURNServers {
+		u, err := stun.ParseURI(t.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid turn_servers[%d] url: %w", i, err)
+		}
+		if u.Scheme != stun.SchemeTypeTURN && u.Scheme != stun.SchemeTypeTURNS {
+			return nil, fmt.Errorf("invalid turn_servers[%d] url: not a TURN server: %q", i, t.URL)
+		}
+		if t.Username == "" || t.Credential == "" {
+			return nil, fmt.Errorf("invalid turn_servers[%d]: username and credential must be set", i)
+		}
+		u.Username, u.Password = t.Username, t.Credential
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+// GetMaxCallDuration returns the duration limit of calls via a given trunk, as set in max_call_duration. Zero means no limit.
+func (conf *Config) GetMaxCallDuration(trunkID string) time.Duration {
+	if trunkID == "" {
+		return 0
+	}
+	return conf.MaxCallDuration[trunkID]
+}
+
+// FailoverTrunks returns IDs of trunks to try for outbound calls via a given trunk, as set in outbound_trunk_failover.
+// The trunk itself goes first, followed by failover trunks in the order of priority. It returns nil if failover is not configured.
+func (conf *Config) FailoverTrunks(trunkID string) []string {
+	refs := conf.OutboundTrunkFailover[trunkID]
+	if trunkID == "" || len(refs) == 0 {
+		return nil
+	}
+	refs = slices.Clone(refs)
+	slices.SortStableFunc(refs, func(a, b TrunkRef) int {
+		return cmp.Compare(a.Priority, b.Priority)
+	})
+	ids := []string{trunkID}
+	for _, r := range refs {
+		if !slices.Contains(ids, r.ID) {
+			ids = append(ids, r.ID)
+		}
+	}
+	return ids
+}
+
+// DataChannelSenderAllowed checks if a participant is allowed to send DIAL and TRANSFER requests on the data channel.
+func (conf *Config) DataChannelSenderAllowed(identity string) bool {
+	if identity == "" {
+		return false
+	}
+	for _, id := range conf.LiveKitDataChannelSenders {
+		if prefix, ok := strings.CutSuffix(id, "*"); ok {
+			if strings.HasPrefix(identity, prefix) {
+				return true
+			}
+		} else if id == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMaxConcurrentCalls returns the limit of concurrent calls for a given trunk ID. Zero means no limit.
+func (conf *Config) GetMaxConcurrentCalls(trunkID string) int {
+	conf.hotMu.RLock()
+	defer conf.hotMu.RUnlock()
+	return conf.MaxConcurrentCalls[trunkID]
+}
+
+// NATKeepAlive returns RTP keepalive interval for a given trunk ID. Zero means keepalive is disabled.
+func (conf *Config) NATKeepAlive(trunk string) time.Duration {
+	conf.hotMu.RLock()
+	defer conf.hotMu.RUnlock()
+	if dt, ok := conf.NATKeepAliveTrunks[trunk]; ok {
+		return dt
+	}
+	return conf.NATKeepAliveInterval
 }
 
 func (c *Config) InitLogger(values ...interface{}) error {
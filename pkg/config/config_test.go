@@ -0,0 +1,241 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livekit/mediatransportutil/pkg/rtcconfig"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		conf := &Config{
+			SIPPort:        DefaultSIPPort,
+			RTPPortMin:     10000,
+			RTPPortMax:     20000,
+			MaxActiveCalls: 100,
+			ClusterID:      "us-east-1",
+			LocalNet:       "192.168.0.0/24",
+			WebhookURL:     "https://example.com/hook",
+		}
+		require.NoError(t, conf.Validate())
+		require.NoError(t, (&Config{}).Validate())
+	})
+	t.Run("broken", func(t *testing.T) {
+		complexity := 11
+		redirects := -1
+		conf := &Config{
+			SIPPort:         70000,
+			PrometheusPort:  -1,
+			ControlWSPort:   -1,
+			RTPPort:         rtcconfig.PortRange{Start: 20000, End: 10000},
+			RTPPortMin:      20000,
+			RTPPortMax:      10000,
+			MaxRedirects:    &redirects,
+			ClusterID:       "us east",
+			UseExternalIP:   true,
+			NAT1To1IP:       "not-an-ip",
+			LocalNet:        "192.168.0.0",
+			WebhookURL:      "ftp://example.com",
+			MusicOnHoldFile: "moh.pcm",
+			MusicOnHoldURL:  "http://example.com/moh",
+			DTMFMode:        "sms",
+
+			TranscriptionWebhookURL: "ws://example.com/stt",
+			CDRFormat:               "xml",
+			CDRWebhookURL:           "example.com/cdr",
+			T38FaxServer:            "fax.example.com",
+
+			LiveKitDataChannelDialEnabled: true,
+			CallQueueAnnouncementTemplate: "/prompts/queue.pcm",
+
+			NATKeepAliveInterval:     -time.Second,
+			OutboundTrunks:           map[string]string{"ST_a": "sip.example.com", "ST_b": "SIP.example.com", "ST_c": ""},
+			MaxConcurrentCalls:       map[string]int{"ST_a": -1},
+			MaxCallDuration:          map[string]time.Duration{"ST_a": -time.Second},
+			OutboundFrom:             map[string]OutboundFromConfig{"ST_a": {FromDisplayNameTemplate: "{name} ({phone})"}},
+			DialPlan:                 []DialPlanRule{{Replace: "+1$1"}, {Match: "(555"}},
+			OutboundTrunkFailover:    map[string][]TrunkRef{"ST_a": {{ID: "ST_b"}, {ID: "ST_x"}}, "ST_y": {{ID: "ST_a"}}},
+			DNSLoadBalanceMode:       map[string]DNSLoadBalanceMode{"ST_a": "dns"},
+			OptionsCapabilityTimeout: -time.Second,
+			AuthCacheTTL:             -time.Second,
+			RedundancyLevel:          -1,
+			PublishExpires:           -time.Second,
+			ParkingMaxSlots:          -1,
+			CallQueueMaxLength:       -1,
+			MediaTimeout:             -time.Second,
+			DTLSSRTPOutbound:         true,
+			STUNServers:              []string{"turn:turn.example.com"},
+			SMIMECertFile:            "cert.pem",
+			ProxyAuth:                map[string]ProxyAuthConfig{"sip.example.com": {ProxyAuthUser: "user"}},
+			OutboundRegister:         map[string]RegisterConfig{"ST_a": {RegisterEnabled: true, RegisterExpiry: -1}, "ST_z": {}},
+			OpusEncoderBitrate:       1000,
+			OpusEncoderComplexity:    &complexity,
+			ComfortNoiseLevel:        6,
+			PINDigits:                17,
+			PINTimeout:               -time.Second,
+			PINMaxAttempts:           -1,
+			PoolMaxConns:             -1,
+			PoolIdleTimeout:          -time.Second,
+		}
+		err := conf.Validate()
+		require.Error(t, err)
+		for _, exp := range []string{
+			"invalid sip_port: 70000",
+			"invalid prometheus_port: -1",
+			"invalid control_ws_port: -1",
+			"invalid rtp_port range: 20000-10000",
+			"invalid rtp_port_min and rtp_port_max: 20000-10000",
+			"invalid max_redirects: -1",
+			`invalid cluster_id: "us east"`,
+			"use_external_ip and nat_1_to_1_ip can not both be set",
+			`invalid nat_1_to_1_ip: "not-an-ip"`,
+			"invalid local_net",
+			`invalid webhook_url: unsupported scheme "ftp"`,
+			`invalid transcription_webhook_url: unsupported scheme "ws"`,
+			`invalid cdr_format: "xml"`,
+			`invalid cdr_webhook_url: unsupported scheme ""`,
+			`invalid t38_fax_server: "fax.example.com"`,
+			"music_on_hold_file and music_on_hold_url can not both be set",
+			`invalid dtmf_mode: "sms"`,
+			"invalid nat_keepalive_interval: -1s",
+			`invalid outbound_trunks: "ST_a" and "ST_b" have the same address`,
+			`invalid outbound_trunks address for "ST_c": empty`,
+			`invalid max_concurrent_calls for "ST_a": -1`,
+			`invalid max_call_duration for "ST_a": -1s`,
+			`invalid outbound_from template for "ST_a": unknown token {phone}`,
+			"invalid dial_plan[0]: match is not set",
+			"invalid dial_plan[1] match: error parsing regexp",
+			`invalid outbound_trunk_failover["ST_a"][1]: trunk "ST_x" is not listed in outbound_trunks`,
+			`invalid outbound_trunk_failover: trunk "ST_y" is not listed in outbound_trunks`,
+			`invalid dns_load_balance_mode for "ST_a": "dns"`,
+			"invalid options_capability_timeout: -1s",
+			"invalid redundancy_level: -1",
+			"invalid auth_cache_ttl: -1s",
+			`invalid outbound_register: trunk "ST_z" is not listed in outbound_trunks`,
+			`invalid outbound_register for "ST_a": register_user must be set`,
+			`invalid outbound_register expiry for "ST_a": -1`,
+			`invalid proxy_auth for "sip.example.com"`,
+			"invalid publish_expires: -1s",
+			"invalid media_timeout: -1s",
+			"invalid parking_max_slots: -1",
+			"invalid call_queue_max_length: -1",
+			`invalid call_queue_announcement_template: "/prompts/queue.pcm" has no {position}`,
+			"dtls_srtp_outbound requires dtls_srtp_enabled",
+			`invalid stun_servers[0]: not a STUN server: "turn:turn.example.com"`,
+			"stun_servers and turn_servers require ice_enabled",
+			"smime_cert_file and smime_key_file must be set together",
+			"invalid opus_encoder_bitrate: 1000",
+			"invalid opus_encoder_complexity: 11",
+			"invalid comfort_noise_level: 6",
+			"invalid pin_digits: 17",
+			"invalid pin_timeout: -1s",
+			"invalid pin_max_attempts: -1",
+			"invalid pool_max_conns: -1",
+			"invalid pool_idle_timeout: -1s",
+			"livekit_data_channel_senders must be set",
+		} {
+			require.ErrorContains(t, err, exp)
+		}
+	})
+	t.Run("listeners", func(t *testing.T) {
+		conf := &Config{
+			SIPPort: DefaultSIPPort,
+			Listeners: []ListenerConfig{
+				{Transport: "udp"},
+				{Transport: "tcp"},
+				{Transport: "tls", Port: 5061, CertFile: "cert.pem", KeyFile: "key.pem"},
+			},
+		}
+		require.NoError(t, conf.Validate())
+		require.Equal(t, []ListenerConfig{
+			{Transport: "udp", Port: 5060},
+			{Transport: "tcp", Port: 5060},
+			{Transport: "tls", Port: 5061, CertFile: "cert.pem", KeyFile: "key.pem"},
+		}, conf.SIPListeners())
+
+		conf.Listeners = []ListenerConfig{
+			{Transport: "udp"},
+			{Transport: "udp", Port: DefaultSIPPort},
+			{Transport: "tls", Port: 5061},
+			{Transport: "ws", Port: 5062},
+			{Transport: "tcp", Address: "localhost", Port: 70000},
+		}
+		err := conf.Validate()
+		for _, exp := range []string{
+			"listeners[1]: duplicate udp listener on port 5060",
+			"listeners[2]: cert_file and key_file are required for tls",
+			`listeners[3]: invalid transport: "ws"`,
+			`listeners[4]: invalid address: "localhost"`,
+			"listeners[4]: invalid port: 70000",
+		} {
+			require.ErrorContains(t, err, exp)
+		}
+	})
+	t.Run("not enough ports", func(t *testing.T) {
+		conf := &Config{
+			RTPPortMin:     10000,
+			RTPPortMax:     10009,
+			MaxActiveCalls: 20,
+		}
+		require.ErrorContains(t, conf.Validate(), "has only 10 ports")
+	})
+}
+
+func TestDataChannelSenderAllowed(t *testing.T) {
+	conf := &Config{LiveKitDataChannelSenders: []string{"agent", "dispatcher-*"}}
+	require.True(t, conf.DataChannelSenderAllowed("agent"))
+	require.True(t, conf.DataChannelSenderAllowed("dispatcher-1"))
+	require.False(t, conf.DataChannelSenderAllowed("agent-2"))
+	require.False(t, conf.DataChannelSenderAllowed("user"))
+	require.False(t, conf.DataChannelSenderAllowed(""))
+
+	conf = &Config{LiveKitDataChannelTransferEnabled: true, LiveKitDataChannelSenders: []string{"a*b"}}
+	require.ErrorContains(t, conf.Validate(), `invalid livekit_data_channel_senders identity: "a*b"`)
+}
+
+func TestNATKeepAlive(t *testing.T) {
+	conf := &Config{
+		NATKeepAliveInterval: 15 * time.Second,
+		NATKeepAliveTrunks: map[string]time.Duration{
+			"ST_off":  0,
+			"ST_fast": 5 * time.Second,
+		},
+		OutboundTrunks: map[string]string{"ST_fast": "sip.example.com"},
+	}
+	require.Equal(t, 15*time.Second, conf.NATKeepAlive(""))
+	require.Equal(t, 15*time.Second, conf.NATKeepAlive("ST_other"))
+	require.Equal(t, time.Duration(0), conf.NATKeepAlive("ST_off"))
+	require.Equal(t, 5*time.Second, conf.NATKeepAlive("ST_fast"))
+
+	// Outbound calls use the same trunk ID.
+	require.Equal(t, "ST_fast", conf.OutboundTrunkID("SIP.example.com"))
+	require.Equal(t, "", conf.OutboundTrunkID("other.example.com"))
+	require.Equal(t, 15*time.Second, conf.NATKeepAlive(conf.OutboundTrunkID("other.example.com")))
+}
+
+func TestFailoverTrunks(t *testing.T) {
+	conf := &Config{
+		OutboundTrunkFailover: map[string][]TrunkRef{
+			"ST_a": {{ID: "ST_c", Priority: 2}, {ID: "ST_b", Priority: 1}, {ID: "ST_d", Priority: 2}, {ID: "ST_a"}},
+		},
+	}
+	require.Equal(t, []string{"ST_a", "ST_b", "ST_c", "ST_d"}, conf.FailoverTrunks("ST_a"))
+	require.Nil(t, conf.FailoverTrunks("ST_b"))
+	require.Nil(t, conf.FailoverTrunks(""))
+}
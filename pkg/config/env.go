@@ -0,0 +1,128 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	goerrors "errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/livekit/psrpc"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of environment variables read by FromEnv and MergeEnv.
+const EnvPrefix = "SIP_"
+
+// FromEnv creates the config from environment variables only, see MergeEnv. LIVEKIT_ variables are used
+// for the API credentials and the URL, same as in NewConfig.
+func FromEnv() (*Config, error) {
+	conf := &Config{
+		ApiKey:      os.Getenv("LIVEKIT_API_KEY"),
+		ApiSecret:   os.Getenv("LIVEKIT_API_SECRET"),
+		WsUrl:       os.Getenv("LIVEKIT_WS_URL"),
+		ServiceName: "sip",
+	}
+	if err := MergeEnv(conf); err != nil {
+		return nil, err
+	}
+	if conf.Redis == nil {
+		return nil, psrpc.NewErrorf(psrpc.InvalidArgument, "redis configuration is required")
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// MergeEnv overrides config fields with environment variables. Variables are named after the YAML keys,
+// in upper case with the SIP_ prefix, e.g. SIP_PROMETHEUS_PORT for prometheus_port. Fields of nested structs
+// are joined with underscores, e.g. SIP_REDIS_ADDRESS for redis.address.
+//
+// Strings are used as is. Other values use the YAML syntax, including durations (e.g. "30s"), lists and maps
+// (e.g. '{"ST_abc": "sip.example.com"}'). Nested structs can be set as a whole the same way.
+func MergeEnv(base *Config) error {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(k, EnvPrefix) {
+			env[k] = v
+		}
+	}
+	var errs []error
+	mergeEnv(reflect.ValueOf(base).Elem(), EnvPrefix, env, &errs)
+	return goerrors.Join(errs...)
+}
+
+// mergeEnv sets fields of the struct from env. It returns true if any field was set.
+func mergeEnv(v reflect.Value, prefix string, env map[string]string, errs *[]error) bool {
+	set := false
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if !f.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		key := prefix + strings.ToUpper(name)
+		fv := v.Field(i)
+		if s, ok := env[key]; ok {
+			if err := parseEnvValue(fv, s); err != nil {
+				*errs = append(*errs, fmt.Errorf("invalid %s: %w", key, err))
+			}
+			set = true
+			continue
+		}
+		typ := fv.Type()
+		if typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct || !hasEnvPrefix(env, key+"_") {
+			continue
+		}
+		if fv.Kind() != reflect.Pointer {
+			set = mergeEnv(fv, key+"_", env, errs) || set
+			continue
+		}
+		// Pointers to structs are only allocated if any of the fields are set.
+		nv := reflect.New(typ)
+		if !fv.IsNil() {
+			nv.Elem().Set(fv.Elem())
+		}
+		if mergeEnv(nv.Elem(), key+"_", env, errs) {
+			fv.Set(nv)
+			set = true
+		}
+	}
+	return set
+}
+
+func hasEnvPrefix(env map[string]string, prefix string) bool {
+	for k := range env {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseEnvValue(v reflect.Value, s string) error {
+	if v.Kind() == reflect.String {
+		v.SetString(s)
+		return nil
+	}
+	return yaml.Unmarshal([]byte(s), v.Addr().Interface())
+}
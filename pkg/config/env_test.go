@@ -0,0 +1,97 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livekit/mediatransportutil/pkg/rtcconfig"
+	"github.com/livekit/protocol/redis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("LIVEKIT_API_KEY", "key")
+	t.Setenv("SIP_REDIS_ADDRESS", "localhost:6379")
+	t.Setenv("SIP_REDIS_DB", "2")
+	t.Setenv("SIP_PROMETHEUS_PORT", "6789")
+	t.Setenv("SIP_CLUSTER_ID", "us-east-1")
+	t.Setenv("SIP_RTP_PORT_MIN", "11000")
+	t.Setenv("SIP_RTP_PORT_MAX", "12000")
+	t.Setenv("SIP_RTP_PORT", "1-2")
+	t.Setenv("SIP_MAX_REDIRECTS", "3")
+	t.Setenv("SIP_MEDIA_TIMEOUT_DETECTION", "true")
+	t.Setenv("SIP_MEDIA_TIMEOUT", "45s")
+	t.Setenv("SIP_COMFORT_NOISE_LEVEL", "-50.5")
+	t.Setenv("SIP_DTMF_MODE", "info")
+	t.Setenv("SIP_LOGGING_LEVEL", "debug")
+	t.Setenv("SIP_OUTBOUND_TRUNKS", `{"ST_a": "sip.example.com"}`)
+	t.Setenv("SIP_LIVEKIT_DATA_CHANNEL_SENDERS", `["agent", "dispatcher-*"]`)
+	t.Setenv("SIP_LISTENERS", `[{"transport": "tcp", "port": 5060}]`)
+
+	conf, err := FromEnv()
+	require.NoError(t, err)
+	// Logger config can't be copied, so it's checked separately.
+	require.Equal(t, "debug", conf.Logging.Level)
+	conf.Logging.Level = ""
+	redirects := 3
+	require.Equal(t, &Config{
+		Redis:                     &redis.RedisConfig{Address: "localhost:6379", DB: 2},
+		ApiKey:                    "key",
+		PrometheusPort:            6789,
+		ClusterID:                 "us-east-1",
+		RTPPortMin:                11000,
+		RTPPortMax:                12000,
+		RTPPort:                   rtcconfig.PortRange{Start: 1, End: 2},
+		MaxRedirects:              &redirects,
+		MediaTimeoutDetection:     true,
+		MediaTimeout:              45 * time.Second,
+		ComfortNoiseLevel:         -50.5,
+		DTMFMode:                  DTMFModeInfo,
+		OutboundTrunks:            map[string]string{"ST_a": "sip.example.com"},
+		LiveKitDataChannelSenders: []string{"agent", "dispatcher-*"},
+		Listeners:                 []ListenerConfig{{Transport: "tcp", Port: 5060}},
+		ServiceName:               "sip",
+	}, conf)
+
+	// Invalid values are reported, as well as validation errors.
+	t.Setenv("SIP_SIP_PORT", "70000")
+	t.Setenv("SIP_HEALTH_PORT", "none")
+	_, err = FromEnv()
+	require.ErrorContains(t, err, "invalid SIP_HEALTH_PORT")
+	t.Setenv("SIP_HEALTH_PORT", "")
+	_, err = FromEnv()
+	require.ErrorContains(t, err, "invalid sip_port: 70000")
+}
+
+func TestFromEnvNoRedis(t *testing.T) {
+	t.Setenv("SIP_PROMETHEUS_PORT", "6789")
+	_, err := FromEnv()
+	require.ErrorContains(t, err, "redis configuration is required")
+}
+
+func TestMergeEnv(t *testing.T) {
+	conf, err := NewConfig("redis:\n  address: redis:6379\n  db: 1\nsip_port: 5070\nws_url: ws://file\n")
+	require.NoError(t, err)
+	t.Setenv("SIP_SIP_PORT", "5080")
+	t.Setenv("SIP_REDIS_DB", "3")
+	require.NoError(t, MergeEnv(conf))
+	require.Equal(t, 5080, conf.SIPPort)
+	require.Equal(t, "ws://file", conf.WsUrl)
+	require.Equal(t, &redis.RedisConfig{Address: "redis:6379", DB: 3}, conf.Redis)
+	// Unset pointers to structs are not allocated.
+	require.Nil(t, conf.Redis.TLS)
+}
@@ -0,0 +1,175 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/livekit/protocol/logger"
+)
+
+// hotReloadDelay groups file events, so that the config is not read while it is being written.
+const hotReloadDelay = 100 * time.Millisecond
+
+// HotReload watches the config file and reloads it on change. Fields tagged with hot_reload:"true" which changed
+// are passed to onChange, all other fields are left zero, see Config.ApplyHotReload. Changes of other fields
+// require a restart, thus they are only logged. Invalid configs are ignored.
+//
+// The file is compared to its contents at the time of the call. Environment variables are merged the same way
+// as when the service starts. The returned function stops watching.
+func HotReload(path string, onChange func(*Config)) (stop func(), err error) {
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := loadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// The directory is watched, because editors often replace the file instead of writing it.
+	if err = w.Add(filepath.Dir(path)); err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+	log := logger.GetLogger().WithValues("path", path)
+	go func() {
+		timer := time.NewTimer(hotReloadDelay)
+		timer.Stop()
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					timer.Stop()
+					return
+				}
+				if filepath.Clean(ev.Name) == path && ev.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+					timer.Reset(hotReloadDelay)
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					timer.Stop()
+					return
+				}
+				log.Warnw("Config watch failed", err)
+			case <-timer.C:
+				next, err := loadConfigFile(path)
+				if err != nil {
+					log.Warnw("Cannot reload config", err)
+					continue
+				}
+				changed, static := diffHotReload(cur, next)
+				cur = next
+				if len(static) != 0 {
+					log.Warnw("Config changes require a restart, ignoring them", nil, "fields", static)
+				}
+				if changed != nil {
+					log.Infow("Reloading config", "fields", changed.hotChanged)
+					onChange(changed)
+				}
+			}
+		}
+	}()
+	return func() { _ = w.Close() }, nil
+}
+
+func loadConfigFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := NewConfig(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if err = MergeEnv(conf); err != nil {
+		return nil, err
+	}
+	conf.setDefaults()
+	if err = conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// diffHotReload returns a config with hot reloaded fields which differ between the configs, or nil if none of them do.
+// It also returns YAML keys of other fields which differ.
+func diffHotReload(old, next *Config) (changed *Config, static []string) {
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(next).Elem()
+	t := ov.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if !f.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		if reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		if f.Tag.Get("hot_reload") != "true" {
+			static = append(static, name)
+			continue
+		}
+		if changed == nil {
+			changed = &Config{}
+		}
+		setConfigField(reflect.ValueOf(changed).Elem().Field(i), nv.Field(i))
+		changed.hotChanged = append(changed.hotChanged, name)
+	}
+	return changed, static
+}
+
+// setConfigField copies the field value. Logging config includes a lock, so it's updated instead.
+func setConfigField(dst, src reflect.Value) {
+	if l, ok := dst.Addr().Interface().(*logger.Config); ok {
+		_ = l.Update(src.Addr().Interface().(*logger.Config))
+		return
+	}
+	dst.Set(src)
+}
+
+// HotReloadFields returns YAML keys of fields set by HotReload.
+func (conf *Config) HotReloadFields() []string {
+	return conf.hotChanged
+}
+
+// ApplyHotReload updates the running config with fields changed by HotReload. Log levels are applied immediately,
+// other fields are used by new calls.
+func (conf *Config) ApplyHotReload(changed *Config) {
+	if len(changed.hotChanged) == 0 {
+		return
+	}
+	dst, src := reflect.ValueOf(conf).Elem(), reflect.ValueOf(changed).Elem()
+	t := dst.Type()
+	conf.hotMu.Lock()
+	defer conf.hotMu.Unlock()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		for _, key := range changed.hotChanged {
+			if key == name {
+				setConfigField(dst.Field(i), src.Field(i))
+			}
+		}
+	}
+}
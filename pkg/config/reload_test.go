@@ -0,0 +1,86 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHotReload(t *testing.T) {
+	const base = `
+redis:
+  address: localhost:6379
+sip_port: 5060
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(base+`
+logging:
+  level: info
+max_concurrent_calls:
+  ST_a: 10
+nat_keepalive_interval: 15s
+`), 0644))
+
+	conf, err := loadConfigFile(path)
+	require.NoError(t, err)
+	changes := make(chan *Config, 1)
+	stop, err := HotReload(path, func(c *Config) {
+		changes <- c
+	})
+	require.NoError(t, err)
+	t.Cleanup(stop)
+
+	// Port changes require a restart, and the keepalive interval did not change, so neither is reloaded.
+	require.NoError(t, os.WriteFile(path, []byte(`
+redis:
+  address: localhost:6379
+sip_port: 5070
+logging:
+  level: debug
+max_concurrent_calls:
+  ST_a: 2
+nat_keepalive_interval: 15s
+`), 0644))
+	var changed *Config
+	select {
+	case changed = <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("config was not reloaded")
+	}
+	require.ElementsMatch(t, []string{"logging", "max_concurrent_calls"}, changed.HotReloadFields())
+	require.Equal(t, "debug", changed.Logging.Level)
+	require.Equal(t, map[string]int{"ST_a": 2}, changed.MaxConcurrentCalls)
+	require.Zero(t, changed.SIPPort)
+	require.Zero(t, changed.NATKeepAliveInterval)
+
+	conf.ApplyHotReload(changed)
+	require.Equal(t, "debug", conf.Logging.Level)
+	require.Equal(t, 2, conf.GetMaxConcurrentCalls("ST_a"))
+	require.Equal(t, 5060, conf.SIPPort)
+	require.Equal(t, 15*time.Second, conf.NATKeepAlive("ST_a"))
+
+	// Invalid configs are ignored.
+	require.NoError(t, os.WriteFile(path, []byte(base+"nat_keepalive_interval: -1s\n"), 0644))
+	select {
+	case <-changes:
+		t.Fatal("invalid config was reloaded")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
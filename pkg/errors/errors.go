@@ -20,6 +20,9 @@ import (
 
 var (
 	ErrNoConfig = psrpc.NewErrorf(psrpc.InvalidArgument, "missing config")
+
+	// ErrTrunkCapacityExceeded is returned when the outbound trunk has reached max_concurrent_calls.
+	ErrTrunkCapacityExceeded = psrpc.NewErrorf(psrpc.ResourceExhausted, "sip_trunk_capacity_exceeded")
 )
 
 func ErrCouldNotParseConfig(err error) psrpc.Error {
@@ -25,6 +25,7 @@ type CodecInfo struct {
 	RTPIsStatic bool
 	Priority    int
 	Disabled    bool
+	SampleRate  int // sample rate of decoded audio; rtp.DefSampleRate if not set
 }
 
 type Codec interface {
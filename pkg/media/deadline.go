@@ -0,0 +1,144 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package media
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrDeadlineExceeded is returned by writers when the deadline passes. Same as for net.Conn,
+// it's os.ErrDeadlineExceeded, so errors.Is works with deadline errors of network connections as well.
+var ErrDeadlineExceeded = os.ErrDeadlineExceeded
+
+// WriteDeadliner is implemented by writers which support write deadlines, similar to net.Conn.
+// Zero time means no deadline.
+type WriteDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// NewDeadlineWriter adds write deadlines to any writer. If timeout is set, it's used as a deadline
+// for each write, unless the deadline is set explicitly.
+//
+// Samples are written to w by a separate goroutine, one at a time. Without a deadline, the write blocks while
+// a previous sample is still waiting to be written. Otherwise, the sample is dropped and the write returns
+// ErrDeadlineExceeded right away. Samples which wait past their deadline are dropped as well.
+// Errors of w are returned by the next write. Thus, the sample must not be modified by the caller after the write,
+// unless the writer copies it. Close stops the goroutine.
+func NewDeadlineWriter[T any](w Writer[T], timeout time.Duration) *DeadlineWriter[T] {
+	d := &DeadlineWriter[T]{
+		w:       w,
+		timeout: timeout,
+		queue:   make(chan deadlineSample[T], 1),
+		closed:  make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+var _ WriteDeadliner = (*DeadlineWriter[[]byte])(nil)
+
+type deadlineSample[T any] struct {
+	sample   T
+	deadline time.Time
+}
+
+type DeadlineWriter[T any] struct {
+	w         Writer[T]
+	timeout   time.Duration
+	queue     chan deadlineSample[T] // the sample waiting for the underlying write
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+	err      error // returned by the next write
+}
+
+func (d *DeadlineWriter[T]) run() {
+	for {
+		select {
+		case <-d.closed:
+			return
+		case s := <-d.queue:
+			if !s.deadline.IsZero() && time.Now().After(s.deadline) {
+				continue
+			}
+			if err := d.w.WriteSample(s.sample); err != nil {
+				d.mu.Lock()
+				d.err = err
+				d.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (d *DeadlineWriter[T]) SetWriteDeadline(t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.deadline = t
+	return nil
+}
+
+// writeDeadline returns the deadline of the next write, and the error of a previous one.
+func (d *DeadlineWriter[T]) writeDeadline() (time.Time, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := d.err
+	d.err = nil
+	if d.deadline.IsZero() && d.timeout > 0 {
+		return time.Now().Add(d.timeout), err
+	}
+	return d.deadline, err
+}
+
+func (d *DeadlineWriter[T]) WriteSample(sample T) error {
+	deadline, err := d.writeDeadline()
+	if err != nil {
+		return err
+	}
+	s := deadlineSample[T]{sample: sample, deadline: deadline}
+	if deadline.IsZero() {
+		select {
+		case d.queue <- s:
+			return nil
+		case <-d.closed:
+			return io.ErrClosedPipe
+		}
+	}
+	if !time.Now().Before(deadline) {
+		return ErrDeadlineExceeded
+	}
+	select {
+	case <-d.closed:
+		return io.ErrClosedPipe
+	default:
+	}
+	select {
+	case d.queue <- s:
+		return nil
+	default:
+		// Previous write is still stuck.
+		return ErrDeadlineExceeded
+	}
+}
+
+// Close stops the writer goroutine. It doesn't wait for the underlying write in progress, and doesn't close it.
+func (d *DeadlineWriter[T]) Close() error {
+	d.closeOnce.Do(func() { close(d.closed) })
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package media
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadlineWriter(t *testing.T) {
+	unblock := make(chan struct{})
+	written := make(chan int, 10)
+	w := NewDeadlineWriter[int](WriterFunc[int](func(v int) error {
+		if v < 0 {
+			<-unblock // stalled consumer
+		}
+		written <- v
+		return nil
+	}), 0)
+	t.Cleanup(func() { _ = w.Close() })
+	expect := func(exp int) {
+		t.Helper()
+		select {
+		case v := <-written:
+			require.Equal(t, exp, v)
+		case <-time.After(time.Second):
+			t.Fatal("sample was not written")
+		}
+	}
+
+	// No deadline.
+	require.NoError(t, w.WriteSample(1))
+	expect(1)
+
+	require.NoError(t, w.SetWriteDeadline(time.Now().Add(-time.Second)))
+	require.ErrorIs(t, w.WriteSample(2), ErrDeadlineExceeded)
+
+	// Consumer stalls on this sample.
+	require.NoError(t, w.SetWriteDeadline(time.Now().Add(time.Second)))
+	require.NoError(t, w.WriteSample(-1))
+	require.Eventually(t, func() bool {
+		return len(w.queue) == 0
+	}, time.Second, time.Millisecond)
+
+	// The next sample waits until its deadline, and others fail right away.
+	require.NoError(t, w.SetWriteDeadline(time.Now().Add(20*time.Millisecond)))
+	require.NoError(t, w.WriteSample(3))
+	start := time.Now()
+	err := w.WriteSample(4)
+	require.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	close(unblock)
+	expect(-1)
+	// Sample 3 is dropped, since it waited past the deadline.
+	require.NoError(t, w.SetWriteDeadline(time.Now().Add(time.Second)))
+	require.NoError(t, w.WriteSample(5))
+	expect(5)
+
+	require.NoError(t, w.Close())
+	require.ErrorIs(t, w.WriteSample(6), io.ErrClosedPipe)
+}
+
+func TestDeadlineWriterTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+	w := NewDeadlineWriter[int](WriterFunc[int](func(v int) error {
+		<-unblock
+		return nil
+	}), 10*time.Millisecond)
+	defer w.Close()
+	require.NoError(t, w.WriteSample(1))
+	require.Eventually(t, func() bool {
+		return len(w.queue) == 0
+	}, time.Second, time.Millisecond)
+	require.NoError(t, w.WriteSample(2))
+	require.ErrorIs(t, w.WriteSample(3), ErrDeadlineExceeded)
+
+	// Explicit deadline takes precedence.
+	require.NoError(t, w.SetWriteDeadline(time.Now().Add(-time.Second)))
+	require.ErrorIs(t, w.WriteSample(4), ErrDeadlineExceeded)
+}
+
+func TestDeadlineWriterError(t *testing.T) {
+	errWrite := errors.New("write failed")
+	w := NewDeadlineWriter[int](WriterFunc[int](func(v int) error {
+		return errWrite
+	}), 0)
+	defer w.Close()
+	require.NoError(t, w.WriteSample(1))
+	require.Eventually(t, func() bool {
+		return errors.Is(w.WriteSample(2), errWrite)
+	}, time.Second, time.Millisecond)
+}
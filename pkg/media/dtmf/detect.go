@@ -0,0 +1,140 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dtmf
+
+import (
+	"math"
+
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/tones"
+)
+
+const (
+	// detectBlockDur is the duration of audio analyzed at once, in milliseconds.
+	detectBlockDur = 20
+	// detectMinBlocks is the number of consecutive blocks with the same tone required to report a digit.
+	detectMinBlocks = 2
+	// detectMinPower is the minimal average power of the signal. Roughly -40 dBm0.
+	detectMinPower = 100 * 100
+	// detectMinRatio is the minimal fraction of the signal power that each of the two tones must have.
+	detectMinRatio = 0.2
+)
+
+var (
+	detectLow  = [4]tones.Hz{dtmfLow1, dtmfLow2, dtmfLow3, dtmfLow4}
+	detectHigh = [4]tones.Hz{dtmfHigh1, dtmfHigh2, dtmfHigh3, dtmfHigh4}
+)
+
+var _ media.PCM16Writer = (*Detector)(nil)
+
+// Detector detects in-band (analog) DTMF tones in the audio stream.
+type Detector struct {
+	onDigit func(ev Event)
+	size    int
+	buf     media.PCM16Sample
+	low     [4]float64
+	high    [4]float64
+
+	last  byte // last detected code, 0xff means none
+	count int  // number of consecutive blocks with the last code
+}
+
+// NewDetector creates an in-band DTMF detector for audio with a given sample rate.
+// Each digit is reported once, when the tone is long enough.
+func NewDetector(sampleRate int, onDigit func(ev Event)) *Detector {
+	d := &Detector{
+		onDigit: onDigit,
+		size:    sampleRate * detectBlockDur / 1000,
+		last:    0xff,
+	}
+	for i := range detectLow {
+		d.low[i] = goertzelCoeff(detectLow[i], sampleRate)
+		d.high[i] = goertzelCoeff(detectHigh[i], sampleRate)
+	}
+	return d
+}
+
+func goertzelCoeff(f tones.Hz, sampleRate int) float64 {
+	return 2 * math.Cos(2*math.Pi*float64(f)/float64(sampleRate))
+}
+
+// goertzel returns a fraction of the signal power at the frequency with a given coefficient.
+func goertzel(coeff float64, samples media.PCM16Sample, energy float64) float64 {
+	var s1, s2 float64
+	for _, v := range samples {
+		s0 := float64(v) + coeff*s1 - s2
+		s2, s1 = s1, s0
+	}
+	p := s1*s1 + s2*s2 - coeff*s1*s2
+	return p / (float64(len(samples)) * energy / 2)
+}
+
+// maxTone returns the index of the strongest tone and its power.
+func maxTone(coeffs *[4]float64, samples media.PCM16Sample, energy float64) (int, float64) {
+	ind, max := -1, 0.0
+	for i, c := range coeffs {
+		if p := goertzel(c, samples, energy); p > max {
+			ind, max = i, p
+		}
+	}
+	return ind, max
+}
+
+func (d *Detector) detect(samples media.PCM16Sample) byte {
+	var energy float64
+	for _, v := range samples {
+		energy += float64(v) * float64(v)
+	}
+	if energy/float64(len(samples)) < detectMinPower {
+		return 0xff
+	}
+	li, lp := maxTone(&d.low, samples, energy)
+	hi, hp := maxTone(&d.high, samples, energy)
+	if li < 0 || hi < 0 || lp < detectMinRatio || hp < detectMinRatio {
+		return 0xff
+	}
+	for code, f := range eventFreq {
+		if f[0] == detectLow[li] && f[1] == detectHigh[hi] {
+			return byte(code)
+		}
+	}
+	return 0xff
+}
+
+func (d *Detector) WriteSample(in media.PCM16Sample) error {
+	for len(in) > 0 {
+		n := min(d.size-len(d.buf), len(in))
+		d.buf = append(d.buf, in[:n]...)
+		in = in[n:]
+		if len(d.buf) < d.size {
+			return nil
+		}
+		code := d.detect(d.buf)
+		d.buf = d.buf[:0]
+		if code != d.last {
+			d.last, d.count = code, 0
+		}
+		d.count++
+		if code != 0xff && d.count == detectMinBlocks {
+			d.onDigit(Event{
+				Code:  code,
+				Digit: eventToChar[code],
+				Dur:   uint16(detectMinBlocks * d.size),
+				End:   true,
+			})
+		}
+	}
+	return nil
+}
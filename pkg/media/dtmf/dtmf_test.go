@@ -18,10 +18,13 @@ import (
 	"context"
 	"encoding/hex"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/livekit/sip/pkg/media"
 	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/media/tones"
 )
 
 func TestDTMF(t *testing.T) {
@@ -89,3 +92,86 @@ func TestDTMFDelay(t *testing.T) {
 		require.EqualValues(t, ts, p.Timestamp, "i=%d, dt=%v", i, p.Timestamp-ts)
 	}
 }
+
+func TestDecodeInfo(t *testing.T) {
+	cases := []struct {
+		name  string
+		ctype string
+		body  string
+		exp   Event
+		err   error
+	}{
+		{
+			name:  "relay",
+			ctype: ContentTypeRelay,
+			body:  "Signal=5\r\nDuration=250\r\n",
+			exp:   Event{Code: 5, Digit: '5', Dur: 2000, End: true},
+		},
+		{
+			name:  "relay star",
+			ctype: ContentTypeRelay,
+			body:  "Signal= *\nDuration= 100",
+			exp:   Event{Code: 10, Digit: '*', Dur: 800, End: true},
+		},
+		{
+			name:  "relay code",
+			ctype: ContentTypeRelay,
+			body:  "signal=11\r\nduration=160",
+			exp:   Event{Code: 11, Digit: '#', Dur: 1280, End: true},
+		},
+		{
+			name:  "relay no duration",
+			ctype: ContentTypeRelay + "; charset=utf-8",
+			body:  "Signal=A",
+			exp:   Event{Code: 12, Digit: 'a', Dur: 2000, End: true},
+		},
+		{
+			name:  "dtmf",
+			ctype: ContentTypeDTMF,
+			body:  "9\r\n",
+			exp:   Event{Code: 9, Digit: '9', Dur: 2000, End: true},
+		},
+		{name: "no signal", ctype: ContentTypeRelay, body: "Duration=250", err: ErrInvalidInfo},
+		{name: "bad signal", ctype: ContentTypeRelay, body: "Signal=X", err: ErrInvalidInfo},
+		{name: "bad duration", ctype: ContentTypeRelay, body: "Signal=1\r\nDuration=x", err: ErrInvalidInfo},
+		{name: "content type", ctype: "text/plain", body: "1", err: ErrUnsupportedContentType},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			got, err := DecodeInfo(c.ctype, []byte(c.body))
+			if c.err != nil {
+				require.ErrorIs(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.exp, got)
+		})
+	}
+}
+
+func TestDetector(t *testing.T) {
+	const digits = "159*0#d"
+	var got []byte
+	d := NewDetector(rtp.DefSampleRate, func(ev Event) {
+		got = append(got, ev.Digit)
+	})
+	frame := make(media.PCM16Sample, rtp.DefPacketDur)
+	write := func(freq []tones.Hz, dur time.Duration) {
+		for ts := time.Duration(0); ts < dur; ts += rtp.DefFrameDur {
+			tones.Generate(frame, ts, rtp.DefFrameDur, toneVolume, freq)
+			require.NoError(t, d.WriteSample(frame))
+		}
+	}
+	write(nil, 100*time.Millisecond)
+	write([]tones.Hz{425}, 200*time.Millisecond) // dial tone must be ignored
+	for i := range digits {
+		_, freq := Tone(digits[i])
+		write(freq, 100*time.Millisecond)
+		write(nil, 60*time.Millisecond)
+	}
+	// Too short to be detected.
+	_, freq := Tone('1')
+	write(freq, 20*time.Millisecond)
+	require.Equal(t, digits, string(got))
+}
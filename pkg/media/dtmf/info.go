@@ -0,0 +1,99 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dtmf
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/livekit/sip/pkg/media/rtp"
+)
+
+const (
+	// ContentTypeRelay is used by SIP INFO DTMF (Cisco style), e.g. "Signal=5\r\nDuration=250".
+	ContentTypeRelay = "application/dtmf-relay"
+	// ContentTypeDTMF is used by SIP INFO DTMF with a body containing only the signal.
+	ContentTypeDTMF = "application/dtmf"
+)
+
+var (
+	ErrUnsupportedContentType = errors.New("unsupported DTMF content type")
+	ErrInvalidInfo            = errors.New("invalid DTMF info")
+)
+
+// DecodeInfo decodes DTMF event sent in the SIP INFO body.
+func DecodeInfo(contentType string, body []byte) (Event, error) {
+	typ, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return Event{}, fmt.Errorf("%w: %v", ErrUnsupportedContentType, err)
+	}
+	var (
+		signal string
+		durMs  = uint64(eventDur.Milliseconds())
+	)
+	switch typ {
+	case ContentTypeDTMF:
+		signal = strings.TrimSpace(string(body))
+	case ContentTypeRelay:
+		for _, line := range strings.Split(string(body), "\n") {
+			key, val, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			key, val = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(val)
+			switch key {
+			case "signal":
+				signal = val
+			case "duration":
+				if durMs, err = strconv.ParseUint(val, 10, 32); err != nil {
+					return Event{}, fmt.Errorf("%w: duration %q", ErrInvalidInfo, val)
+				}
+			}
+		}
+	default:
+		return Event{}, fmt.Errorf("%w: %q", ErrUnsupportedContentType, typ)
+	}
+	code, ok := parseSignal(signal)
+	if !ok {
+		return Event{}, fmt.Errorf("%w: signal %q", ErrInvalidInfo, signal)
+	}
+	dur := durMs * rtp.DefSampleRate / 1000
+	if dur > 0xffff {
+		dur = 0xffff
+	}
+	return Event{
+		Code:  code,
+		Digit: eventToChar[code],
+		Dur:   uint16(dur),
+		End:   true,
+	}, nil
+}
+
+// parseSignal accepts both digits ("5", "*", "A") and RFC 4733 event codes ("10" for "*", "11" for "#").
+func parseSignal(s string) (byte, bool) {
+	if len(s) == 1 {
+		if code, ok := charToEvent[strings.ToLower(s)[0]]; ok {
+			return code, true
+		}
+	}
+	v, err := strconv.ParseUint(s, 10, 8)
+	if err != nil || v >= uint64(len(eventToChar)) {
+		return 0, false
+	}
+	return byte(v), true
+}
@@ -29,6 +29,7 @@ func init() {
 		SDPName:     SDPName,
 		RTPDefType:  prtp.PayloadTypeG722,
 		RTPIsStatic: true,
+		SampleRate:  8000,
 		Priority:    1,
 		Disabled:    true,
 	}, Decode, Encode))
@@ -0,0 +1,177 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package media
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// Rewinder is implemented by readers that can restart reading from the beginning.
+type Rewinder interface {
+	Rewind() error
+}
+
+func closeReader[T any](r Reader[T]) error {
+	if c, ok := r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// LimitReader returns a Reader that reads at most n samples from src, similar to io.LimitReader.
+// It returns io.EOF after n samples were read, or when src ends. Close closes src, if it implements io.Closer.
+func LimitReader[T any](src Reader[T], n int) ReadCloser[T] {
+	return &limitReader[T]{src: src, left: n}
+}
+
+type limitReader[T any] struct {
+	src  Reader[T]
+	left int
+}
+
+func (r *limitReader[T]) ReadSample(buf T) (int, error) {
+	if r.left <= 0 {
+		return 0, io.EOF
+	}
+	n, err := r.src.ReadSample(buf)
+	if n > 0 {
+		r.left--
+	}
+	return n, err
+}
+
+func (r *limitReader[T]) Close() error {
+	return closeReader(r.src)
+}
+
+// RepeatReader returns a Reader that rewinds src when it ends, thus repeating it forever.
+// The src must implement Rewinder, otherwise io.EOF is returned as-is. Other errors from src are returned as well.
+// Close closes src, if it implements io.Closer.
+func RepeatReader[T any](src Reader[T]) ReadCloser[T] {
+	return &repeatReader[T]{src: src}
+}
+
+type repeatReader[T any] struct {
+	src Reader[T]
+}
+
+func (r *repeatReader[T]) ReadSample(buf T) (int, error) {
+	n, err := r.src.ReadSample(buf)
+	if !errors.Is(err, io.EOF) || n > 0 {
+		return n, err
+	}
+	rw, ok := r.src.(Rewinder)
+	if !ok {
+		return n, err
+	}
+	if err = rw.Rewind(); err != nil {
+		return 0, err
+	}
+	// Still returns io.EOF if src is empty, so the caller does not spin forever.
+	return r.src.ReadSample(buf)
+}
+
+func (r *repeatReader[T]) Close() error {
+	return closeReader(r.src)
+}
+
+// NewBufferReader returns a Reader that returns given samples in order. It can be rewound with Rewind.
+// Each sample is copied to the read buffer, so the buffer must be large enough to fit it.
+func NewBufferReader[T ~[]E, E any](samples ...T) *BufferReader[T, E] {
+	return &BufferReader[T, E]{samples: samples}
+}
+
+type BufferReader[T ~[]E, E any] struct {
+	samples []T
+	pos     int
+}
+
+func (r *BufferReader[T, E]) ReadSample(buf T) (int, error) {
+	if r.pos >= len(r.samples) {
+		return 0, io.EOF
+	}
+	n := copy(buf, r.samples[r.pos])
+	r.pos++
+	return n, nil
+}
+
+// Rewind restarts reading from the first sample.
+func (r *BufferReader[T, E]) Rewind() error {
+	r.pos = 0
+	return nil
+}
+
+func (r *BufferReader[T, E]) Close() error {
+	return nil
+}
+
+// BufferedReader returns a Reader that returns given samples in order, one every frameDur, similar to a live source.
+// The first sample is returned immediately. Reads block until the time of the next sample, which is measured from
+// the first read, so slow readers catch up instead of drifting. It returns io.EOF after the last sample.
+func BufferedReader[T ~[]E, E any](samples []T, frameDur time.Duration) ReadCloser[T] {
+	return &bufferedReader[T, E]{src: NewBufferReader(samples...), frameDur: frameDur}
+}
+
+type bufferedReader[T ~[]E, E any] struct {
+	src      *BufferReader[T, E]
+	frameDur time.Duration
+	start    time.Time
+	reads    int
+}
+
+func (r *bufferedReader[T, E]) ReadSample(buf T) (int, error) {
+	if r.src.pos >= len(r.src.samples) {
+		return 0, io.EOF
+	}
+	if r.start.IsZero() {
+		r.start = time.Now()
+	} else if wait := time.Until(r.start.Add(time.Duration(r.reads) * r.frameDur)); wait > 0 {
+		time.Sleep(wait)
+	}
+	n, err := r.src.ReadSample(buf)
+	if err == nil {
+		r.reads++
+	}
+	return n, err
+}
+
+func (r *bufferedReader[T, E]) Close() error {
+	return nil
+}
+
+// DrainReader reads samples from src until io.EOF, or until max elements (e.g. audio samples) are read in total.
+// Each sample is read into a new buffer of the remaining size. Errors other than io.EOF are returned with samples read so far.
+func DrainReader[T ~[]E, E any](src Reader[T], max int) ([]T, error) {
+	var (
+		out   []T
+		total int
+	)
+	for total < max {
+		buf := make(T, max-total)
+		n, err := src.ReadSample(buf)
+		if n > 0 {
+			out = append(out, buf[:n:n])
+			total += n
+		}
+		if errors.Is(err, io.EOF) {
+			return out, nil
+		} else if err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package media
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// readAll reads frames from r until an error, and returns the first value of each frame.
+func readAll(t *testing.T, r Reader[PCM16Sample], max int) ([]int16, error) {
+	t.Helper()
+	var out []int16
+	buf := make(PCM16Sample, 2)
+	for i := 0; i < max; i++ {
+		n, err := r.ReadSample(buf)
+		if err != nil {
+			return out, err
+		}
+		require.Equal(t, 2, n)
+		out = append(out, buf[0])
+	}
+	return out, nil
+}
+
+type errReader struct {
+	err    error
+	closed bool
+}
+
+func (r *errReader) ReadSample(buf PCM16Sample) (int, error) {
+	return 0, r.err
+}
+
+func (r *errReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestLimitReader(t *testing.T) {
+	r := LimitReader[PCM16Sample](&seqReader{max: 10}, 3)
+	got, err := readAll(t, r, 10)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, []int16{0, 1, 2}, got)
+
+	// Source ends earlier.
+	r = LimitReader[PCM16Sample](&seqReader{max: 2}, 5)
+	got, err = readAll(t, r, 10)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, []int16{0, 1}, got)
+
+	errTest := errors.New("test")
+	src := &errReader{err: errTest}
+	r = LimitReader[PCM16Sample](src, 5)
+	_, err = readAll(t, r, 10)
+	require.ErrorIs(t, err, errTest)
+	require.NoError(t, r.Close())
+	require.True(t, src.closed)
+}
+
+func TestRepeatReader(t *testing.T) {
+	src := NewBufferReader(PCM16Sample{1, 1}, PCM16Sample{2, 2})
+	r := RepeatReader[PCM16Sample](src)
+	got, err := readAll(t, r, 5)
+	require.NoError(t, err)
+	require.Equal(t, []int16{1, 2, 1, 2, 1}, got)
+
+	// Bounded repeat.
+	src = NewBufferReader(PCM16Sample{1, 1}, PCM16Sample{2, 2})
+	got, err = readAll(t, LimitReader(RepeatReader[PCM16Sample](src), 3), 10)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, []int16{1, 2, 1}, got)
+
+	// Empty sources and sources that cannot be rewound.
+	_, err = readAll(t, RepeatReader[PCM16Sample](NewBufferReader[PCM16Sample]()), 10)
+	require.ErrorIs(t, err, io.EOF)
+	got, err = readAll(t, RepeatReader[PCM16Sample](&seqReader{max: 2}), 10)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, []int16{0, 1}, got)
+
+	errTest := errors.New("test")
+	esrc := &errReader{err: errTest}
+	er := RepeatReader[PCM16Sample](esrc)
+	_, err = readAll(t, er, 10)
+	require.ErrorIs(t, err, errTest)
+	require.NoError(t, er.Close())
+	require.True(t, esrc.closed)
+}
+
+func TestBufferedReader(t *testing.T) {
+	const frameDur = 20 * time.Millisecond
+	r := BufferedReader([]PCM16Sample{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 5}}, frameDur)
+	start := time.Now()
+	got, err := readAll(t, r, 10)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, []int16{1, 2, 3, 4, 5}, got)
+	// The first frame is returned immediately.
+	require.InDelta(t, 4*frameDur, time.Since(start), float64(10*time.Millisecond))
+	require.NoError(t, r.Close())
+}
+
+func TestDrainReader(t *testing.T) {
+	got, err := DrainReader[PCM16Sample](NewBufferReader(PCM16Sample{1, 2}, PCM16Sample{3}), 10)
+	require.NoError(t, err)
+	require.Equal(t, []PCM16Sample{{1, 2}, {3}}, got)
+
+	// Limited by the number of samples.
+	got, err = DrainReader[PCM16Sample](&seqReader{max: 10}, 3)
+	require.NoError(t, err)
+	require.Equal(t, []PCM16Sample{{0, 0, 0}}, got)
+
+	errTest := errors.New("test")
+	_, err = DrainReader[PCM16Sample](&errReader{err: errTest}, 10)
+	require.ErrorIs(t, err, errTest)
+}
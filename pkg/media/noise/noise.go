@@ -0,0 +1,83 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package noise implements comfort noise generation and noise reduction of PCM audio.
+package noise
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/livekit/sip/pkg/media"
+)
+
+// DefaultComfortNoiseLevel is the default RMS level of comfort noise, relative to the full scale.
+const DefaultComfortNoiseLevel = -60 // dBFS
+
+var _ media.Reader[media.PCM16Sample] = (*ComfortNoiseGenerator)(nil)
+
+// ComfortNoiseGenerator produces low-level white noise. Reads never fail.
+type ComfortNoiseGenerator struct {
+	mu  sync.Mutex
+	amp float64
+	rnd *rand.Rand
+}
+
+// NewComfortNoiseGenerator creates a generator of white noise with a given RMS level in dBFS.
+// DefaultComfortNoiseLevel is used if the level is zero.
+func NewComfortNoiseGenerator(level float64) *ComfortNoiseGenerator {
+	if level == 0 {
+		level = DefaultComfortNoiseLevel
+	}
+	rms := math.MaxInt16 * math.Pow(10, level/20)
+	return &ComfortNoiseGenerator{
+		// RMS of uniform noise in [-a, a] is a/sqrt(3).
+		amp: min(rms*math.Sqrt(3), math.MaxInt16),
+		rnd: rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+// ReadSample fills the whole buffer with noise.
+func (g *ComfortNoiseGenerator) ReadSample(buf media.PCM16Sample) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i := range buf {
+		buf[i] = int16(math.Round((2*g.rnd.Float64() - 1) * g.amp))
+	}
+	return len(buf), nil
+}
+
+// FillSilence returns a writer which replaces frames of digital silence with noise from a given reader.
+// Frames with any non-zero sample are passed as-is. Frames written to it are not modified.
+func FillSilence(w media.PCM16Writer, noise media.Reader[media.PCM16Sample]) media.PCM16Writer {
+	return media.WriterFunc[media.PCM16Sample](func(sample media.PCM16Sample) error {
+		if isSilence(sample) {
+			sample = make(media.PCM16Sample, len(sample))
+			if _, err := noise.ReadSample(sample); err != nil {
+				return err
+			}
+		}
+		return w.WriteSample(sample)
+	})
+}
+
+func isSilence(sample media.PCM16Sample) bool {
+	for _, v := range sample {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
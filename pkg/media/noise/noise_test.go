@@ -0,0 +1,108 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package noise
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/media"
+)
+
+func rms(samples []int16) float64 {
+	var sum float64
+	for _, v := range samples {
+		sum += float64(v) * float64(v)
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+func dbfs(samples []int16) float64 {
+	return 20 * math.Log10(rms(samples)/math.MaxInt16)
+}
+
+func TestComfortNoiseGenerator(t *testing.T) {
+	buf := make(media.PCM16Sample, 16000)
+	n, err := NewComfortNoiseGenerator(0).ReadSample(buf)
+	require.NoError(t, err)
+	require.Equal(t, len(buf), n)
+	require.InDelta(t, DefaultComfortNoiseLevel, dbfs(buf), 0.5)
+
+	_, err = NewComfortNoiseGenerator(-40).ReadSample(buf)
+	require.NoError(t, err)
+	require.InDelta(t, -40, dbfs(buf), 0.5)
+}
+
+func TestFillSilence(t *testing.T) {
+	var got []media.PCM16Sample
+	w := FillSilence(media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		got = append(got, s)
+		return nil
+	}), NewComfortNoiseGenerator(-40))
+
+	silence := make(media.PCM16Sample, 160)
+	require.NoError(t, w.WriteSample(silence))
+	require.NoError(t, w.WriteSample(media.PCM16Sample{0, 1, 0}))
+	require.Len(t, got, 2)
+	require.Len(t, got[0], 160)
+	require.NotZero(t, rms(got[0]))
+	require.Equal(t, make(media.PCM16Sample, 160), silence)
+	require.Equal(t, media.PCM16Sample{0, 1, 0}, got[1])
+}
+
+func TestSpectralSubtraction(t *testing.T) {
+	const (
+		rate  = 8000
+		frame = 160
+	)
+	var out []int16
+	w := SpectralSubtraction(media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		require.Len(t, s, frame)
+		out = append(out, s...)
+		return nil
+	}))
+	rnd := rand.New(rand.NewSource(1))
+	var in []int16
+	write := func(dur int, tone float64) {
+		for i := 0; i < dur*rate/frame; i++ {
+			buf := make(media.PCM16Sample, frame)
+			for j := range buf {
+				ts := float64(len(in)+j) / rate
+				buf[j] = int16(300*(2*rnd.Float64()-1) + tone*math.Sin(2*math.Pi*1000*ts))
+			}
+			in = append(in, buf...)
+			require.NoError(t, w.WriteSample(buf))
+		}
+	}
+	// Stationary noise is reduced.
+	write(2, 0)
+	require.Len(t, out, len(in))
+	require.Less(t, rms(out[rate:]), rms(in[rate:])/2)
+
+	// Tone is passed with the noise reduced, delayed by one hop.
+	start := len(in)
+	write(1, 8000)
+	sig := out[start+rate/2:]
+	require.InDelta(t, 8000/math.Sqrt2, rms(sig), 8000/math.Sqrt2*0.1)
+	var diff []int16
+	for i, v := range sig {
+		ts := float64(start+rate/2+i-specHop) / rate
+		diff = append(diff, v-int16(8000*math.Sin(2*math.Pi*1000*ts)))
+	}
+	require.Less(t, rms(diff), rms(in[rate:2*rate]))
+}
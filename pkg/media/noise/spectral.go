@@ -0,0 +1,167 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package noise
+
+import (
+	"math"
+	"math/cmplx"
+	"sync"
+
+	"github.com/livekit/sip/pkg/media"
+)
+
+const (
+	// Size of the FFT block. Blocks are overlapped by a half, thus the audio is delayed by specHop samples.
+	specSize = 256
+	specHop  = specSize / 2
+
+	specOverSubtract = 2.0   // noise estimate is scaled up to reduce residual noise
+	specFloor        = 0.1   // minimal gain of a bin, to avoid musical noise
+	specNoiseMax     = 3.0   // bins louder than the noise estimate by this factor are not averaged into it
+	specNoiseAvg     = 0.05  // smoothing factor of the noise estimate, per block
+	specNoiseRise    = 1.005 // how fast the noise estimate follows a rising noise level otherwise, per block
+)
+
+// SpectralSubtraction returns a writer which reduces stationary background noise of the audio and writes it to w.
+//
+// The noise spectrum is estimated by averaging quiet blocks of each frequency bin, and is subtracted from the signal.
+// Output frames have the same size as input frames, but the audio is delayed by 16 ms at 8 kHz.
+func SpectralSubtraction(w media.Writer[media.PCM16Sample]) media.Writer[media.PCM16Sample] {
+	s := &spectralSubtraction{
+		w:      w,
+		in:     make([]float64, specSize),
+		ola:    make([]float64, specSize),
+		noise:  make([]float64, specSize/2+1),
+		window: make([]float64, specSize),
+		buf:    make([]complex128, specSize),
+		// Output is delayed by one hop, so that each write has enough samples.
+		out: make(media.PCM16Sample, specHop),
+	}
+	for i := range s.window {
+		// Square root of the periodic Hann window, applied twice, sums to one with 50% overlap.
+		s.window[i] = math.Sqrt(0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/specSize))
+	}
+	for i := range s.noise {
+		s.noise[i] = -1
+	}
+	return s
+}
+
+type spectralSubtraction struct {
+	w media.Writer[media.PCM16Sample]
+
+	mu      sync.Mutex
+	in      []float64 // last block of input samples in [-1, 1]; only the first half is filled after each block
+	pending int       // input samples received since the last block
+	ola     []float64 // overlap-add buffer of the output
+	noise   []float64 // noise magnitude per bin; negative until the first block
+	window  []float64
+	buf     []complex128
+	out     media.PCM16Sample // processed samples, not written yet
+}
+
+func (s *spectralSubtraction) WriteSample(sample media.PCM16Sample) error {
+	s.mu.Lock()
+	for _, v := range media.ToFloat32(sample) {
+		s.in[specHop+s.pending] = float64(v)
+		s.pending++
+		if s.pending == specHop {
+			s.pending = 0
+			s.processBlock()
+			copy(s.in, s.in[specHop:])
+		}
+	}
+	// Output is delayed by one hop, thus it always has enough samples for the frame.
+	frame := make(media.PCM16Sample, len(sample))
+	n := copy(frame, s.out)
+	s.out = s.out[:copy(s.out, s.out[n:])]
+	s.mu.Unlock()
+	return s.w.WriteSample(frame)
+}
+
+func (s *spectralSubtraction) processBlock() {
+	for i, v := range s.in {
+		s.buf[i] = complex(v*s.window[i], 0)
+	}
+	fft(s.buf, false)
+	for k := 0; k <= specSize/2; k++ {
+		mag := cmplx.Abs(s.buf[k])
+		switch n := s.noise[k]; {
+		case n < 0:
+			s.noise[k] = mag
+		case mag < specNoiseMax*n:
+			// Likely noise, average it.
+			s.noise[k] = n + (mag-n)*specNoiseAvg
+		default:
+			// Likely speech. The small constant (one PCM step) lets the estimate rise after digital silence.
+			s.noise[k] = n*specNoiseRise + 1.0/32768
+		}
+		gain := 1.0
+		if mag > 0 {
+			gain = max(1-specOverSubtract*s.noise[k]/mag, specFloor)
+		}
+		s.buf[k] *= complex(gain, 0)
+		if k > 0 && k < specSize/2 {
+			// Keep the spectrum symmetric, so that the signal stays real.
+			s.buf[specSize-k] = cmplx.Conj(s.buf[k])
+		}
+	}
+	fft(s.buf, true)
+	for i := range s.ola {
+		s.ola[i] += real(s.buf[i]) * s.window[i]
+	}
+	hop := make([]float32, specHop)
+	for i, v := range s.ola[:specHop] {
+		hop[i] = float32(v)
+	}
+	s.out = append(s.out, media.FromFloat32(hop)...)
+	copy(s.ola, s.ola[specHop:])
+	clear(s.ola[specSize-specHop:])
+}
+
+// fft is an in-place radix-2 FFT. The inverse transform is scaled by 1/n. Length of x must be a power of 2.
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+	if inverse {
+		for i := range x {
+			x[i] /= complex(float64(n), 0)
+		}
+	}
+}
@@ -15,6 +15,8 @@
 package opus
 
 import (
+	"fmt"
+
 	"gopkg.in/hraban/opus.v2"
 
 	"github.com/livekit/sip/pkg/media"
@@ -22,32 +24,227 @@ import (
 
 type Sample []byte
 
-func Decode(w media.Writer[media.PCM16Sample], sampleRate int, channels int) (media.Writer[Sample], error) {
+// maxDTXFrameSize is the max size of an encoded frame that doesn't need to be transmitted when DTX is enabled.
+const maxDTXFrameSize = 2
+
+var _ media.LossConcealer = (*decoder)(nil)
+
+type decodeOptions struct {
+	fec bool
+}
+
+// DecodeOption configures the Opus decoder.
+type DecodeOption func(o *decodeOptions)
+
+// WithFECDecode enables recovery of lost frames from forward error correction data in the next packet.
+// It requires the sender to enable FEC (see WithFEC) and delays the output by one frame after each loss.
+func WithFECDecode() DecodeOption {
+	return func(o *decodeOptions) {
+		o.fec = true
+	}
+}
+
+type decoder struct {
+	w          media.Writer[media.PCM16Sample]
+	dec        *opus.Decoder
+	buf        []int16
+	channels   int
+	defaultDur int // samples per channel in a frame, if it's not known yet
+	fec        bool
+	lost       int // frames lost before the next packet; only used with FEC
+}
+
+func Decode(w media.Writer[media.PCM16Sample], sampleRate int, channels int, opts ...DecodeOption) (media.Writer[Sample], error) {
+	var o decodeOptions
+	for _, fnc := range opts {
+		fnc(&o)
+	}
 	dec, err := opus.NewDecoder(sampleRate, channels)
 	if err != nil {
 		return nil, err
 	}
-	buf := make([]int16, 1000)
-	return media.WriterFunc[Sample](func(in Sample) error {
-		n, err := dec.Decode(in, buf)
-		if err != nil {
+	return &decoder{
+		w:          w,
+		dec:        dec,
+		buf:        make([]int16, 1000),
+		channels:   channels,
+		defaultDur: sampleRate / 50, // 20ms
+		fec:        o.fec,
+	}, nil
+}
+
+// DecodeStage is a pipeline stage decoding Opus to PCM audio. See Decode.
+func DecodeStage(sampleRate int, channels int, opts ...DecodeOption) media.Stage {
+	return media.NewStage("opus-decode", func(w media.Writer[media.PCM16Sample]) (media.Writer[Sample], error) {
+		return Decode(w, sampleRate, channels, opts...)
+	})
+}
+
+func (d *decoder) WriteSample(in Sample) error {
+	if d.lost > 0 {
+		// Conceal all lost frames except the last one, which is recovered from FEC data in this packet.
+		if err := d.conceal(d.lost - 1); err != nil {
 			return err
 		}
-		return w.WriteSample(buf[:n])
-	}), nil
+		d.lost = 0
+		size := d.frameSize()
+		if err := d.dec.DecodeFEC(in, d.buf[:size]); err != nil {
+			return err
+		}
+		if err := d.w.WriteSample(d.buf[:size]); err != nil {
+			return err
+		}
+	}
+	n, err := d.dec.Decode(in, d.buf)
+	if err != nil {
+		return err
+	}
+	return d.w.WriteSample(d.buf[:n])
+}
+
+// ConcealLoss generates audio for lost frames using Opus packet loss concealment.
+// With FEC enabled, concealment is delayed until the next packet is received.
+func (d *decoder) ConcealLoss(n int) error {
+	if d.fec {
+		d.lost += n
+		return nil
+	}
+	return d.conceal(n)
+}
+
+func (d *decoder) frameSize() int {
+	dur, err := d.dec.LastPacketDuration()
+	if err != nil || dur <= 0 {
+		dur = d.defaultDur
+	}
+	return min(dur*d.channels, len(d.buf))
+}
+
+func (d *decoder) conceal(n int) error {
+	size := d.frameSize()
+	for i := 0; i < n; i++ {
+		if err := d.dec.DecodePLC(d.buf[:size]); err != nil {
+			return err
+		}
+		if err := d.w.WriteSample(d.buf[:size]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fecLossPerc is the expected packet loss used by the encoder when FEC is enabled.
+// Opus only adds FEC data if the expected loss is not zero.
+const fecLossPerc = 10
+
+// Ranges of encoder settings supported by Opus.
+const (
+	MinBitrate    = 6000
+	MaxBitrate    = 510000
+	MaxComplexity = 10
+)
+
+type encodeOptions struct {
+	dtx           bool
+	fec           bool
+	bitrate       int  // bits per second; library default if zero
+	complexity    int  // only used if setComplexity is set
+	setComplexity bool // zero complexity is valid
+}
+
+// EncodeOption configures the Opus encoder.
+type EncodeOption func(o *encodeOptions)
+
+// WithDTX enables discontinuous transmission. Frames with silence are not written at all.
+// If the writer implements media.SampleSkipper, it is notified about each skipped frame.
+func WithDTX() EncodeOption {
+	return func(o *encodeOptions) {
+		o.dtx = true
+	}
+}
+
+// WithFEC enables in-band forward error correction: each packet carries a low-bitrate copy of the previous frame.
+func WithFEC() EncodeOption {
+	return func(o *encodeOptions) {
+		o.fec = true
+	}
+}
+
+// WithBitrate sets the target bitrate of the encoder, in bits per second. It must be between MinBitrate and MaxBitrate.
+func WithBitrate(bps int) EncodeOption {
+	return func(o *encodeOptions) {
+		o.bitrate = bps
+	}
 }
 
-func Encode(w media.Writer[Sample], sampleRate int, channels int) (media.Writer[media.PCM16Sample], error) {
+// WithComplexity sets computational complexity of the encoder, from 0 to MaxComplexity.
+// Lower complexity uses less CPU at the cost of audio quality.
+func WithComplexity(c int) EncodeOption {
+	return func(o *encodeOptions) {
+		o.complexity = c
+		o.setComplexity = true
+	}
+}
+
+// EncodeStage is a pipeline stage encoding PCM audio with Opus. See Encode.
+func EncodeStage(sampleRate int, channels int, opts ...EncodeOption) media.Stage {
+	return media.NewStage("opus-encode", func(w media.Writer[Sample]) (media.Writer[media.PCM16Sample], error) {
+		return Encode(w, sampleRate, channels, opts...)
+	})
+}
+
+func Encode(w media.Writer[Sample], sampleRate int, channels int, opts ...EncodeOption) (media.Writer[media.PCM16Sample], error) {
+	var o encodeOptions
+	for _, fnc := range opts {
+		fnc(&o)
+	}
+	if o.bitrate != 0 && (o.bitrate < MinBitrate || o.bitrate > MaxBitrate) {
+		return nil, fmt.Errorf("invalid opus bitrate: %d (must be %d-%d)", o.bitrate, MinBitrate, MaxBitrate)
+	}
+	if o.setComplexity && (o.complexity < 0 || o.complexity > MaxComplexity) {
+		return nil, fmt.Errorf("invalid opus complexity: %d (must be 0-%d)", o.complexity, MaxComplexity)
+	}
 	enc, err := opus.NewEncoder(sampleRate, channels, opus.AppVoIP)
 	if err != nil {
 		return nil, err
 	}
+	if o.bitrate != 0 {
+		if err = enc.SetBitrate(o.bitrate); err != nil {
+			return nil, err
+		}
+	}
+	if o.setComplexity {
+		if err = enc.SetComplexity(o.complexity); err != nil {
+			return nil, err
+		}
+	}
+	if o.dtx {
+		if err = enc.SetDTX(true); err != nil {
+			return nil, err
+		}
+	}
+	if o.fec {
+		if err = enc.SetInBandFEC(true); err != nil {
+			return nil, err
+		}
+		if err = enc.SetPacketLossPerc(fecLossPerc); err != nil {
+			return nil, err
+		}
+	}
+	skipper, _ := w.(media.SampleSkipper)
 	buf := make([]byte, 1024)
 	return media.WriterFunc[media.PCM16Sample](func(in media.PCM16Sample) error {
 		n, err := enc.Encode(in, buf)
 		if err != nil {
 			return err
 		}
+		if o.dtx && n <= maxDTXFrameSize {
+			// DTX frame, do not send it.
+			if skipper != nil {
+				skipper.SkipSample()
+			}
+			return nil
+		}
 		return w.WriteSample(buf[:n])
 	}), nil
 }
@@ -0,0 +1,288 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opus
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+
+	prtp "github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/audiotest"
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/rtp"
+)
+
+const (
+	testSampleRate = rtp.DefSampleRate
+	testFrameSize  = int(rtp.DefPacketDur)
+	testSignalInd  = 2
+)
+
+// packetBuffer collects encoded frames. Skipped (DTX) frames are recorded as nil.
+type packetBuffer []Sample
+
+func (b *packetBuffer) WriteSample(s Sample) error {
+	*b = append(*b, slices.Clone(s))
+	return nil
+}
+
+func (b *packetBuffer) SkipSample() {
+	*b = append(*b, nil)
+}
+
+// genAudio generates frames with a signal, followed by silence, followed by the signal again.
+func genAudio(signal, silence int) []media.PCM16Sample {
+	sig := make(media.PCM16Sample, testFrameSize)
+	audiotest.GenSignal(sig, []audiotest.Wave{{Ind: testSignalInd, Amp: 5000}})
+	var out []media.PCM16Sample
+	for i := 0; i < signal; i++ {
+		out = append(out, sig)
+	}
+	for i := 0; i < silence; i++ {
+		out = append(out, make(media.PCM16Sample, testFrameSize))
+	}
+	for i := 0; i < signal; i++ {
+		out = append(out, sig)
+	}
+	return out
+}
+
+func encodeAll(t testing.TB, frames []media.PCM16Sample, opts ...EncodeOption) packetBuffer {
+	var buf packetBuffer
+	enc, err := Encode(&buf, testSampleRate, 1, opts...)
+	require.NoError(t, err)
+	for _, f := range frames {
+		require.NoError(t, enc.WriteSample(f))
+	}
+	return buf
+}
+
+func TestDTX(t *testing.T) {
+	const (
+		signal  = 25
+		silence = 100
+	)
+	frames := genAudio(signal, silence)
+	packets := encodeAll(t, frames, WithDTX())
+	require.Len(t, packets, len(frames))
+
+	skipped := 0
+	for _, p := range packets {
+		if p == nil {
+			skipped++
+		}
+	}
+	require.Greater(t, skipped, silence/2, "DTX frames must not be sent")
+
+	// Decode the packets the same way they are received from RTP: DTX does not create gaps in sequence numbers,
+	// only timestamps of the skipped frames are missing.
+	var out []media.PCM16Sample
+	dec, err := Decode(media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		out = append(out, slices.Clone(s))
+		return nil
+	}), testSampleRate, 1)
+	require.NoError(t, err)
+	h := rtp.NewMediaStreamIn[Sample](dec)
+	var seq uint16
+	for i, p := range packets {
+		if p == nil {
+			continue
+		}
+		seq++
+		ts := uint32(i * testFrameSize)
+		require.NoError(t, h.HandleRTP(&prtp.Packet{Header: prtp.Header{SequenceNumber: seq, Timestamp: ts}, Payload: p}))
+	}
+	// Skipped frames must not be concealed as lost.
+	require.Len(t, out, len(frames)-skipped)
+	require.NotEmpty(t, out)
+
+	// Signal must be detectable after the silence period.
+	waves := audiotest.FindSignal(out[len(out)-1])
+	require.NotEmpty(t, waves)
+	require.Equal(t, testSignalInd, waves[0].Ind)
+}
+
+func BenchmarkDTX(b *testing.B) {
+	// Typical conversation: the participant is silent most of the time.
+	frames := genAudio(10, 80)
+	for _, c := range []struct {
+		name string
+		opts []EncodeOption
+	}{
+		{name: "no dtx"},
+		{name: "dtx", opts: []EncodeOption{WithDTX()}},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			var bytes, sent int
+			for i := 0; i < b.N; i++ {
+				for _, p := range encodeAll(b, frames, c.opts...) {
+					if p != nil {
+						bytes += len(p)
+						sent++
+					}
+				}
+			}
+			total := float64(b.N * len(frames))
+			b.ReportMetric(float64(bytes)/total, "bytes/frame")
+			b.ReportMetric(float64(sent)/total, "packets/frame")
+		})
+	}
+}
+
+// minSNR is the minimal expected quality of Opus round-trip, in dB.
+const minSNR = 20
+
+func TestRoundTrip(t *testing.T) {
+	frames := genAudio(50, 0)
+	packets := encodeAll(t, frames)
+
+	var out []media.PCM16Sample
+	dec, err := Decode(media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		out = append(out, slices.Clone(s))
+		return nil
+	}), testSampleRate, 1)
+	require.NoError(t, err)
+	for _, p := range packets {
+		require.NoError(t, dec.WriteSample(p))
+	}
+	ref, got := slices.Concat(frames...), slices.Concat(out...)
+	require.Len(t, got, len(ref))
+
+	// Compensate for the codec delay and skip the encoder warm-up.
+	d := audiotest.FindDelay(ref, got, testFrameSize)
+	skip := 5 * testFrameSize
+	snr := audiotest.SNR(ref[skip:len(ref)-d], got[skip+d:])
+	t.Logf("SNR: %.1f dB, delay: %d", snr, d)
+	require.Greater(t, snr, float64(minSNR))
+}
+
+// minMOS is the minimal expected perceptual quality of Opus round-trip.
+const minMOS = 3.5
+
+func TestRoundTripMOS(t *testing.T) {
+	ref := make(media.PCM16Sample, 150*testFrameSize)
+	audiotest.GenSpeech(ref, testSampleRate, 1)
+	var frames []media.PCM16Sample
+	for i := 0; i < len(ref); i += testFrameSize {
+		frames = append(frames, ref[i:i+testFrameSize])
+	}
+	packets := encodeAll(t, frames)
+
+	var out []media.PCM16Sample
+	dec, err := Decode(media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		out = append(out, slices.Clone(s))
+		return nil
+	}), testSampleRate, 1)
+	require.NoError(t, err)
+	for _, p := range packets {
+		require.NoError(t, dec.WriteSample(p))
+	}
+	mos := audiotest.MOS(ref, slices.Concat(out...), testSampleRate)
+	t.Logf("MOS: %.2f", mos)
+	require.Greater(t, mos, minMOS)
+}
+
+func TestFEC(t *testing.T) {
+	// Changing signal, so that PLC can't simply repeat the previous frame.
+	var frames []media.PCM16Sample
+	for i := 0; i < 200; i++ {
+		frame := make(media.PCM16Sample, testFrameSize)
+		audiotest.GenSignal(frame, []audiotest.Wave{{Ind: 1 + i%3, Amp: 5000}, {Ind: 3 + i%2, Amp: 2000}})
+		frames = append(frames, frame)
+	}
+	packets := encodeAll(t, frames, WithFEC())
+
+	decodeAll := func(drop func(i int) bool, opts ...DecodeOption) []media.PCM16Sample {
+		var out []media.PCM16Sample
+		dec, err := Decode(media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+			out = append(out, slices.Clone(s))
+			return nil
+		}), testSampleRate, 1, opts...)
+		require.NoError(t, err)
+		h := rtp.NewMediaStreamIn[Sample](dec)
+		for i, p := range packets {
+			if drop(i) {
+				continue
+			}
+			require.NoError(t, h.HandleRTP(&prtp.Packet{Header: prtp.Header{SequenceNumber: uint16(i)}, Payload: p}))
+		}
+		return out
+	}
+	noLoss := func(i int) bool { return false }
+	loss := func(i int) bool { return i%10 == 5 }
+
+	ref := decodeAll(noLoss)
+	plc := decodeAll(loss)
+	fec := decodeAll(loss, WithFECDecode())
+	require.Len(t, plc, len(ref))
+	require.Len(t, fec, len(ref))
+
+	flat := slices.Concat(ref...)
+	snrPLC, snrFEC := audiotest.SNR(flat, slices.Concat(plc...)), audiotest.SNR(flat, slices.Concat(fec...))
+	t.Logf("SNR: plc=%.1f dB, fec=%.1f dB", snrPLC, snrFEC)
+	require.Greater(t, snrFEC, snrPLC)
+}
+
+func TestEncodeOptionsRange(t *testing.T) {
+	var buf packetBuffer
+	for _, opts := range [][]EncodeOption{
+		{WithBitrate(MinBitrate - 1)},
+		{WithBitrate(MaxBitrate + 1)},
+		{WithComplexity(-1)},
+		{WithComplexity(MaxComplexity + 1)},
+	} {
+		_, err := Encode(&buf, testSampleRate, 1, opts...)
+		require.Error(t, err)
+	}
+	_, err := Encode(&buf, testSampleRate, 1, WithBitrate(16000), WithComplexity(0))
+	require.NoError(t, err)
+}
+
+// roundTripSNR encodes and decodes frames, and returns the SNR of the decoded audio.
+func roundTripSNR(t testing.TB, frames []media.PCM16Sample, opts ...EncodeOption) float64 {
+	packets := encodeAll(t, frames, opts...)
+	var out []media.PCM16Sample
+	dec, err := Decode(media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		out = append(out, slices.Clone(s))
+		return nil
+	}), testSampleRate, 1)
+	require.NoError(t, err)
+	for _, p := range packets {
+		require.NoError(t, dec.WriteSample(p))
+	}
+	ref, got := slices.Concat(frames...), slices.Concat(out...)
+	d := audiotest.FindDelay(ref, got, testFrameSize)
+	skip := 5 * testFrameSize
+	return audiotest.SNR(ref[skip:len(ref)-d], got[skip+d:])
+}
+
+func BenchmarkComplexity(b *testing.B) {
+	frames := genAudio(50, 0)
+	for _, c := range []int{0, MaxComplexity} {
+		b.Run(strconv.Itoa(c), func(b *testing.B) {
+			opts := []EncodeOption{WithBitrate(16000), WithComplexity(c)}
+			snr := roundTripSNR(b, frames, opts...)
+			require.Greater(b, snr, float64(minSNR))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				encodeAll(b, frames, opts...)
+			}
+			b.ReportMetric(snr, "snr_db")
+		})
+	}
+}
@@ -15,7 +15,11 @@
 package media
 
 import (
+	"bufio"
 	"context"
+	"encoding/binary"
+	"io"
+	"math"
 	"time"
 
 	"github.com/pion/webrtc/v3/pkg/media"
@@ -59,6 +63,60 @@ func (s *PCM16Sample) WriteSample(data PCM16Sample) error {
 	return nil
 }
 
+// ReadPCM16 reads raw 16 bit little-endian PCM samples until EOF. Trailing odd byte is ignored.
+func ReadPCM16(r io.Reader) (PCM16Sample, error) {
+	br := bufio.NewReader(r)
+	var out PCM16Sample
+	for {
+		var v int16
+		err := binary.Read(br, binary.LittleEndian, &v)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return out, nil
+		} else if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+}
+
+// SplitPCM16 splits samples into frames of a given size. Last frame is padded with silence.
+func SplitPCM16(s PCM16Sample, size int) []PCM16Sample {
+	var frames []PCM16Sample
+	for len(s) > 0 {
+		frame := make(PCM16Sample, size)
+		n := copy(frame, s)
+		s = s[n:]
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// ToFloat32 converts PCM samples to float32 in [-1, 1) range, as expected by most DSP algorithms.
+func ToFloat32(s PCM16Sample) []float32 {
+	out := make([]float32, len(s))
+	for i, v := range s {
+		out[i] = float32(v) / 32768
+	}
+	return out
+}
+
+// FromFloat32 converts float32 samples in [-1, 1] range back to PCM. Values out of range are clipped.
+func FromFloat32(f []float32) PCM16Sample {
+	out := make(PCM16Sample, len(f))
+	for i, v := range f {
+		v *= 32768
+		switch {
+		case v >= math.MaxInt16:
+			out[i] = math.MaxInt16
+		case v <= math.MinInt16:
+			out[i] = math.MinInt16
+		default:
+			out[i] = int16(v)
+		}
+	}
+	return out
+}
+
 type PCM16Writer = Writer[PCM16Sample]
 type PCM16WriteCloser = WriteCloser[PCM16Sample]
 
@@ -66,10 +124,46 @@ type MediaSampleWriter interface {
 	WriteSample(sample media.Sample) error
 }
 
+// SampleSkipper is implemented by writers which must know about samples that were intentionally not sent
+// (for example, Opus DTX frames). Skipped samples still advance the timestamps, but are not reported as lost.
+type SampleSkipper interface {
+	SkipSample()
+}
+
+// LossConcealer is implemented by decoders which can conceal a number of lost samples (packet loss concealment).
+type LossConcealer interface {
+	ConcealLoss(n int) error
+}
+
 func FromSampleWriter[T ~[]byte](w MediaSampleWriter, sampleDur time.Duration) Writer[T] {
-	return WriterFunc[T](func(in T) error {
-		data := make([]byte, len(in))
-		copy(data, in)
-		return w.WriteSample(media.Sample{Data: data, Duration: sampleDur})
+	return &sampleWriter[T]{w: w, dur: sampleDur}
+}
+
+// SampleWriterStage is a pipeline stage wrapping encoded frames into media samples. See FromSampleWriter.
+func SampleWriterStage[T ~[]byte](sampleDur time.Duration) Stage {
+	return NewStage("sample", func(w Writer[media.Sample]) (Writer[T], error) {
+		return FromSampleWriter[T](w, sampleDur), nil
 	})
 }
+
+var _ SampleSkipper = (*sampleWriter[[]byte])(nil)
+
+type sampleWriter[T ~[]byte] struct {
+	w       MediaSampleWriter
+	dur     time.Duration
+	skipped time.Duration // duration of skipped samples since the last write
+}
+
+// SkipSample accounts for the sample which was not sent. Skipped duration is added to the next sample,
+// so that the timestamps keep advancing, while sequence numbers stay continuous.
+func (s *sampleWriter[T]) SkipSample() {
+	s.skipped += s.dur
+}
+
+func (s *sampleWriter[T]) WriteSample(in T) error {
+	data := make([]byte, len(in))
+	copy(data, in)
+	dur := s.dur + s.skipped
+	s.skipped = 0
+	return s.w.WriteSample(media.Sample{Data: data, Duration: dur})
+}
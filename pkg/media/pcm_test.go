@@ -0,0 +1,86 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package media
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/stretchr/testify/require"
+)
+
+type sampleBuffer []media.Sample
+
+func (b *sampleBuffer) WriteSample(s media.Sample) error {
+	*b = append(*b, s)
+	return nil
+}
+
+func TestFromSampleWriterSkip(t *testing.T) {
+	var buf sampleBuffer
+	w := FromSampleWriter[[]byte](&buf, 20*time.Millisecond)
+	sk, ok := w.(SampleSkipper)
+	require.True(t, ok)
+
+	require.NoError(t, w.WriteSample([]byte{1}))
+	sk.SkipSample()
+	sk.SkipSample()
+	require.NoError(t, w.WriteSample([]byte{2}))
+	require.NoError(t, w.WriteSample([]byte{3}))
+
+	require.Equal(t, sampleBuffer{
+		{Data: []byte{1}, Duration: 20 * time.Millisecond},
+		{Data: []byte{2}, Duration: 60 * time.Millisecond},
+		{Data: []byte{3}, Duration: 20 * time.Millisecond},
+	}, buf)
+}
+
+func TestFloat32(t *testing.T) {
+	s := PCM16Sample{0, 1, -1, 16384, -16384, math.MaxInt16, math.MinInt16}
+	f := ToFloat32(s)
+	require.Equal(t, []float32{0, 1.0 / 32768, -1.0 / 32768, 0.5, -0.5, 32767.0 / 32768, -1}, f)
+	require.Equal(t, s, FromFloat32(f))
+
+	// Out of range values are clipped.
+	require.Equal(t, PCM16Sample{math.MaxInt16, math.MaxInt16, math.MinInt16, math.MinInt16}, FromFloat32([]float32{1, 2.5, -1, -3}))
+}
+
+func benchFrame() PCM16Sample {
+	s := make(PCM16Sample, 960) // 20ms at 48kHz
+	for i := range s {
+		s[i] = int16(i*37) - 16000
+	}
+	return s
+}
+
+func BenchmarkToFloat32(b *testing.B) {
+	s := benchFrame()
+	b.SetBytes(int64(len(s)) * 2)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = ToFloat32(s)
+	}
+}
+
+func BenchmarkFromFloat32(b *testing.B) {
+	f := ToFloat32(benchFrame())
+	b.SetBytes(int64(len(f)) * 4)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = FromFloat32(f)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package media
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Stage is a single step of a Pipeline, such as encoding, decoding, resampling or filtering.
+// It writes samples of one type to the writer of the next stage, possibly of a different type.
+type Stage struct {
+	name string
+	in   string // sample type accepted by the stage
+	out  string // sample type written to the next stage
+	wrap func(next any) (any, error)
+}
+
+// NewStage creates a pipeline stage from a constructor, which wraps the writer of the next stage.
+func NewStage[In, Out any](name string, fnc func(w Writer[Out]) (Writer[In], error)) Stage {
+	return Stage{
+		name: name,
+		in:   typeName[In](),
+		out:  typeName[Out](),
+		wrap: func(next any) (any, error) {
+			w, ok := next.(Writer[Out])
+			if !ok {
+				return nil, fmt.Errorf("stage %q expects a writer of %s, got %T", name, typeName[Out](), next)
+			}
+			return fnc(w)
+		},
+	}
+}
+
+// NewFilter creates a pipeline stage which doesn't change the sample type and can't fail.
+func NewFilter[T any](name string, fnc func(w Writer[T]) Writer[T]) Stage {
+	return NewStage(name, func(w Writer[T]) (Writer[T], error) {
+		return fnc(w), nil
+	})
+}
+
+// Name returns the name of the stage.
+func (s Stage) Name() string {
+	return s.name
+}
+
+func (s Stage) String() string {
+	return fmt.Sprintf("%s(%s->%s)", s.name, s.in, s.out)
+}
+
+func typeName[T any]() string {
+	return fmt.Sprintf("%T", *new(T))
+}
+
+// Pipeline describes a chain of stages converting samples of type In to samples of type Out.
+// Stages are listed in the order in which samples pass through them. Types of adjacent stages are checked by Build.
+type Pipeline[In, Out any] struct {
+	stages []Stage
+}
+
+// NewPipeline creates a pipeline from a list of stages.
+func NewPipeline[In, Out any](stages ...Stage) *Pipeline[In, Out] {
+	return &Pipeline[In, Out]{stages: stages}
+}
+
+// Stages returns stages of the pipeline.
+func (p *Pipeline[In, Out]) Stages() []Stage {
+	return slices.Clone(p.stages)
+}
+
+// String describes the pipeline for debugging, e.g. "opus-encode(media.PCM16Sample->opus.Sample) -> sample(opus.Sample->media.Sample)".
+func (p *Pipeline[In, Out]) String() string {
+	names := make([]string, 0, len(p.stages))
+	for _, s := range p.stages {
+		names = append(names, s.String())
+	}
+	return strings.Join(names, " -> ")
+}
+
+// Build creates writers of all stages, starting from the sink, and returns the writer of the first stage.
+// Pipeline without stages returns the sink itself, if In and Out are the same.
+func (p *Pipeline[In, Out]) Build(sink Writer[Out]) (Writer[In], error) {
+	var w any = sink
+	for i := len(p.stages) - 1; i >= 0; i-- {
+		var err error
+		w, err = p.stages[i].wrap(w)
+		if err != nil {
+			return nil, fmt.Errorf("cannot build pipeline %s: %w", p, err)
+		}
+	}
+	head, ok := w.(Writer[In])
+	if !ok {
+		return nil, fmt.Errorf("cannot build pipeline %s: expected a writer of %s, got %T", p, typeName[In](), w)
+	}
+	return head, nil
+}
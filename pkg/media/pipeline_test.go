@@ -0,0 +1,94 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package media
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testEncodeStage converts each sample to a text line.
+func testEncodeStage() Stage {
+	return NewStage("encode", func(w Writer[string]) (Writer[PCM16Sample], error) {
+		return WriterFunc[PCM16Sample](func(in PCM16Sample) error {
+			var s string
+			for _, v := range in {
+				s += strconv.Itoa(int(v)) + " "
+			}
+			return w.WriteSample(s)
+		}), nil
+	})
+}
+
+func testGainStage(gain int16) Stage {
+	return NewFilter("gain", func(w PCM16Writer) PCM16Writer {
+		return WriterFunc[PCM16Sample](func(in PCM16Sample) error {
+			out := make(PCM16Sample, len(in))
+			for i, v := range in {
+				out[i] = v * gain
+			}
+			return w.WriteSample(out)
+		})
+	})
+}
+
+func TestPipeline(t *testing.T) {
+	t.Run("build", func(t *testing.T) {
+		p := NewPipeline[PCM16Sample, string](testGainStage(2), testGainStage(3), testEncodeStage())
+		require.Equal(t, "gain(media.PCM16Sample->media.PCM16Sample) -> gain(media.PCM16Sample->media.PCM16Sample) -> encode(media.PCM16Sample->string)", p.String())
+		require.Len(t, p.Stages(), 3)
+		require.Equal(t, "encode", p.Stages()[2].Name())
+
+		var got []string
+		w, err := p.Build(WriterFunc[string](func(in string) error {
+			got = append(got, in)
+			return nil
+		}))
+		require.NoError(t, err)
+		require.NoError(t, w.WriteSample(PCM16Sample{1, 2}))
+		require.NoError(t, w.WriteSample(PCM16Sample{-1}))
+		require.Equal(t, []string{"6 12 ", "-6 "}, got)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		var buf PCM16Sample
+		w, err := NewPipeline[PCM16Sample, PCM16Sample]().Build(&buf)
+		require.NoError(t, err)
+		require.NoError(t, w.WriteSample(PCM16Sample{1}))
+		require.Equal(t, PCM16Sample{1}, buf)
+
+		_, err = NewPipeline[string, PCM16Sample]().Build(&buf)
+		require.ErrorContains(t, err, "expected a writer of string")
+	})
+
+	t.Run("type mismatch", func(t *testing.T) {
+		p := NewPipeline[PCM16Sample, string](testEncodeStage(), testGainStage(2))
+		_, err := p.Build(WriterFunc[string](func(in string) error { return nil }))
+		require.ErrorContains(t, err, `stage "gain" expects a writer of media.PCM16Sample`)
+	})
+
+	t.Run("stage error", func(t *testing.T) {
+		errStage := errors.New("no codec")
+		p := NewPipeline[PCM16Sample, PCM16Sample](NewStage("codec", func(w PCM16Writer) (PCM16Writer, error) {
+			return nil, errStage
+		}))
+		var buf PCM16Sample
+		_, err := p.Build(&buf)
+		require.ErrorIs(t, err, errStage)
+	})
+}
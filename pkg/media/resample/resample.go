@@ -0,0 +1,188 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package resample implements sample rate conversion for PCM audio.
+package resample
+
+import (
+	"math"
+
+	"github.com/livekit/sip/pkg/media"
+)
+
+// polyphaseTaps is the number of filter taps per input sample for the lowest of two sample rates.
+// Must be even, so that the filter delay is a whole number of samples.
+const polyphaseTaps = 16
+
+type options struct {
+	polyphase bool
+}
+
+// Option configures the resampler.
+type Option func(o *options)
+
+// WithPolyphase enables a polyphase windowed-sinc filter instead of linear interpolation.
+// It has better quality, especially when downsampling, but uses more CPU and delays audio by a few samples.
+func WithPolyphase() Option {
+	return func(o *options) {
+		o.polyphase = true
+	}
+}
+
+// Resample returns a writer that converts audio from inRate to outRate and writes it to dst.
+// If the rates are the same, dst is returned as-is.
+func Resample(dst media.PCM16Writer, inRate, outRate int, opts ...Option) media.PCM16Writer {
+	if inRate == outRate {
+		return dst
+	}
+	if inRate <= 0 || outRate <= 0 {
+		panic("invalid sample rate")
+	}
+	var o options
+	for _, fnc := range opts {
+		fnc(&o)
+	}
+	g := gcd(inRate, outRate)
+	up, down := outRate/g, inRate/g
+	if o.polyphase {
+		return newPolyphase(dst, up, down)
+	}
+	return &linear{dst: dst, up: up, down: down, pos: up}
+}
+
+// Stage is a pipeline stage converting audio from inRate to outRate. See Resample.
+func Stage(inRate, outRate int, opts ...Option) media.Stage {
+	return media.NewFilter("resample", func(w media.PCM16Writer) media.PCM16Writer {
+		return Resample(w, inRate, outRate, opts...)
+	})
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func outSize(n, up, down int) int {
+	return n*up/down + 1
+}
+
+// linear resampler interpolates between two adjacent input samples.
+type linear struct {
+	dst      media.PCM16Writer
+	up, down int
+	prev     int16 // last sample of the previous frame
+	pos      int   // position of the next output sample, in 1/up of the input samples; 0 points to prev
+}
+
+func (r *linear) WriteSample(in media.PCM16Sample) error {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(media.PCM16Sample, 0, outSize(len(in), r.up, r.down))
+	at := func(i int) int {
+		if i == 0 {
+			return int(r.prev)
+		}
+		return int(in[i-1])
+	}
+	for {
+		i, frac := r.pos/r.up, r.pos%r.up
+		if i >= len(in) {
+			break
+		}
+		a, b := at(i), at(i+1)
+		out = append(out, int16(a+(b-a)*frac/r.up))
+		r.pos += r.down
+	}
+	r.pos -= len(in) * r.up
+	r.prev = in[len(in)-1]
+	return r.dst.WriteSample(out)
+}
+
+// polyphase resampler upsamples by up, applies a low-pass filter and downsamples by down.
+// The filter is split into up phases, thus only non-zero samples of the upsampled signal are used.
+type polyphase struct {
+	dst      media.PCM16Writer
+	up, down int
+	taps     int       // per phase
+	h        []float64 // filter, taps*up long
+	delay    int       // filter delay, in upsampled samples
+	buf      []int16   // taps-1 samples of history, followed by the current frame
+	pos      int       // position of the next output sample in buf, in upsampled samples
+}
+
+func newPolyphase(dst media.PCM16Writer, up, down int) *polyphase {
+	taps := polyphaseTaps * max(up, down) / up
+	taps += taps % 2
+	n := taps * up
+	// Odd filter length, so that the filter is symmetric around a whole sample.
+	h := make([]float64, n)
+	cutoff := 0.5 / float64(max(up, down))
+	center := float64(n-2) / 2
+	for i := 0; i < n-1; i++ {
+		x := float64(i) - center
+		v := 2 * cutoff
+		if x != 0 {
+			v = math.Sin(2*math.Pi*cutoff*x) / (math.Pi * x)
+		}
+		// Blackman window.
+		w := 0.42 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-2)) + 0.08*math.Cos(4*math.Pi*float64(i)/float64(n-2))
+		h[i] = v * w * float64(up)
+	}
+	return &polyphase{
+		dst: dst, up: up, down: down,
+		taps:  taps,
+		h:     h,
+		delay: (n - 2) / 2,
+		buf:   make([]int16, taps-1),
+		pos:   (taps - 1) * up,
+	}
+}
+
+func (r *polyphase) WriteSample(in media.PCM16Sample) error {
+	if len(in) == 0 {
+		return nil
+	}
+	r.buf = append(r.buf, in...)
+	out := make(media.PCM16Sample, 0, outSize(len(in), r.up, r.down))
+	for {
+		u := r.pos + r.delay
+		base, phase := u/r.up, u%r.up
+		if base >= len(r.buf) {
+			break
+		}
+		var v float64
+		for k := 0; k < r.taps; k++ {
+			v += r.h[phase+k*r.up] * float64(r.buf[base-k])
+		}
+		out = append(out, clamp(v))
+		r.pos += r.down
+	}
+	drop := len(r.buf) - (r.taps - 1)
+	r.buf = append(r.buf[:0], r.buf[drop:]...)
+	r.pos -= drop * r.up
+	return r.dst.WriteSample(out)
+}
+
+func clamp(v float64) int16 {
+	v = math.Round(v)
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	} else if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(v)
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resample
+
+import (
+	"math"
+	"math/cmplx"
+	"strconv"
+	"testing"
+
+	"github.com/mjibson/go-dsp/fft"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/audiotest"
+	"github.com/livekit/sip/pkg/media"
+)
+
+// minSNR is the minimal expected resampling quality, in dB.
+const minSNR = 30
+
+func genSine(n, sampleRate int, freq float64) media.PCM16Sample {
+	out := make(media.PCM16Sample, n)
+	for i := range out {
+		out[i] = int16(10000 * math.Sin(2*math.Pi*freq*float64(i)/float64(sampleRate)))
+	}
+	return out
+}
+
+// peakFreq returns the frequency with the highest amplitude.
+func peakFreq(src media.PCM16Sample, sampleRate int) float64 {
+	cmp := make([]complex128, len(src))
+	for i, v := range src {
+		cmp[i] = complex(float64(v), 0)
+	}
+	out := fft.FFT(cmp)
+	peak, amp := 0, 0.0
+	for i, v := range out[1 : len(out)/2] {
+		if a := cmplx.Abs(v); a > amp {
+			peak, amp = i+1, a
+		}
+	}
+	return float64(peak) * float64(sampleRate) / float64(len(src))
+}
+
+func TestResample(t *testing.T) {
+	const (
+		freq     = 500
+		duration = 1 // sec
+		frameMs  = 20
+	)
+	for _, c := range []struct {
+		in, out int
+	}{
+		{8000, 16000},
+		{16000, 8000},
+		{8000, 48000},
+		{48000, 8000},
+		{8000, 8000},
+	} {
+		for _, poly := range []bool{false, true} {
+			name := strconv.Itoa(c.in) + "-" + strconv.Itoa(c.out)
+			var opts []Option
+			if poly {
+				name += "-polyphase"
+				opts = append(opts, WithPolyphase())
+			}
+			t.Run(name, func(t *testing.T) {
+				src := genSine(c.in*duration, c.in, freq)
+				var got media.PCM16Sample
+				w := Resample(&got, c.in, c.out, opts...)
+				frame := c.in * frameMs / 1000
+				for i := 0; i < len(src); i += frame {
+					require.NoError(t, w.WriteSample(src[i:i+frame]))
+				}
+				require.InDelta(t, c.out*duration, len(got), float64(c.out)/1000)
+
+				exp := genSine(c.out*duration, c.out, freq)
+				got = got[:min(len(got), len(exp))]
+				require.InDelta(t, freq, peakFreq(got, c.out), 1)
+
+				// Skip the filter warm-up and compensate for its delay.
+				skip := c.out / 100
+				d := audiotest.FindDelay(exp, got, skip)
+				snr := audiotest.SNR(exp[skip:len(got)-skip], got[skip+d:len(got)-skip+d])
+				t.Logf("SNR: %.1f dB, delay: %d", snr, d)
+				require.Greater(t, snr, float64(minSNR))
+			})
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rtcpxr builds RTCP Extended Reports (RFC 3611) with VoIP metrics of received RTP streams.
+package rtcpxr
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// Gmin is the minimal number of packets received between losses to end a burst (RFC 3611, Section 4.7.2).
+const Gmin = 16
+
+// Unavailable is reported for metrics which are not measured.
+const Unavailable = 127
+
+// maxGap is the largest jump of sequence numbers counted as loss. Larger jumps indicate that the sender restarted.
+const maxGap = 3000
+
+// Metrics of a received stream, as reported in the VoIP Metrics Report Block (RFC 3611, Section 4.7).
+type Metrics struct {
+	SSRC           uint32
+	LossRate       float64 // fraction of packets lost, 0-1
+	DiscardRate    float64 // fraction of packets discarded due to late or early arrival, 0-1
+	BurstDensity   float64 // fraction of packets lost or discarded within bursts, 0-1
+	GapDensity     float64 // fraction of packets lost or discarded within gaps, 0-1
+	BurstDuration  time.Duration
+	GapDuration    time.Duration
+	RoundTripDelay time.Duration // zero if unknown
+	RFactor        float64       // conversational R factor (ITU-T G.107)
+	MOSLQ          float64       // listening quality MOS, 1-5
+	MOSCQ          float64       // conversational quality MOS, 1-5
+}
+
+// Block encodes the metrics as the VoIP Metrics Report Block. Metrics which are not measured are reported as unavailable.
+func (m Metrics) Block() *rtcp.VoIPMetricsReportBlock {
+	return &rtcp.VoIPMetricsReportBlock{
+		SSRC:           m.SSRC,
+		LossRate:       fixed8(m.LossRate),
+		DiscardRate:    fixed8(m.DiscardRate),
+		BurstDensity:   fixed8(m.BurstDensity),
+		GapDensity:     fixed8(m.GapDensity),
+		BurstDuration:  millis(m.BurstDuration),
+		GapDuration:    millis(m.GapDuration),
+		RoundTripDelay: millis(m.RoundTripDelay),
+		SignalLevel:    Unavailable,
+		NoiseLevel:     Unavailable,
+		RERL:           Unavailable,
+		Gmin:           Gmin,
+		RFactor:        uint8(math.Round(clamp(m.RFactor, 0, 100))),
+		ExtRFactor:     Unavailable,
+		MOSLQ:          mos10(m.MOSLQ),
+		MOSCQ:          mos10(m.MOSCQ),
+	}
+}
+
+// ParseBlock decodes metrics from the VoIP Metrics Report Block. Values are rounded to the precision of the block.
+func ParseBlock(b *rtcp.VoIPMetricsReportBlock) Metrics {
+	m := Metrics{
+		SSRC:           b.SSRC,
+		LossRate:       float64(b.LossRate) / 256,
+		DiscardRate:    float64(b.DiscardRate) / 256,
+		BurstDensity:   float64(b.BurstDensity) / 256,
+		GapDensity:     float64(b.GapDensity) / 256,
+		BurstDuration:  time.Duration(b.BurstDuration) * time.Millisecond,
+		GapDuration:    time.Duration(b.GapDuration) * time.Millisecond,
+		RoundTripDelay: time.Duration(b.RoundTripDelay) * time.Millisecond,
+	}
+	if b.RFactor != Unavailable {
+		m.RFactor = float64(b.RFactor)
+	}
+	if b.MOSLQ != Unavailable {
+		m.MOSLQ = float64(b.MOSLQ) / 10
+	}
+	if b.MOSCQ != Unavailable {
+		m.MOSCQ = float64(b.MOSCQ) / 10
+	}
+	return m
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
+
+// fixed8 encodes a fraction as 8 bit fixed point, with the binary point at the left edge of the field.
+func fixed8(v float64) uint8 {
+	return uint8(clamp(v*256, 0, 255))
+}
+
+func millis(d time.Duration) uint16 {
+	return uint16(clamp(float64(d.Milliseconds()), 0, math.MaxUint16))
+}
+
+func mos10(v float64) uint8 {
+	if v == 0 {
+		return Unavailable
+	}
+	return uint8(math.Round(clamp(v, 1, 5) * 10))
+}
+
+// Collector gathers VoIP metrics of a received RTP stream, using the Markov model of bursts and gaps (RFC 3611, Section 4.7.2).
+// Only packets newer than the last one are used, so reordered packets are counted as lost.
+type Collector struct {
+	mu        sync.Mutex
+	packetDur time.Duration
+	started   bool
+	ssrc      uint32
+	lastSeq   uint16
+	expected  uint64
+	lost      uint64
+	rtt       time.Duration
+
+	pkt                          uint64 // packets received since the last loss
+	burst                        uint64 // packets lost in the current burst
+	c11, c13, c14, c22, c23, c33 uint64
+}
+
+// NewCollector creates a collector for a stream with a given duration of packets.
+func NewCollector(packetDur time.Duration) *Collector {
+	return &Collector{packetDur: packetDur}
+}
+
+// Packet records a received RTP packet.
+func (c *Collector) Packet(ssrc uint32, seq uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.started || ssrc != c.ssrc {
+		c.started, c.ssrc, c.lastSeq = true, ssrc, seq
+		c.expected++
+		c.pkt++
+		return
+	}
+	diff := seq - c.lastSeq
+	if diff == 0 || diff >= 0x8000 {
+		return // duplicate or late
+	}
+	c.lastSeq = seq
+	if diff > maxGap {
+		c.expected++
+		c.pkt++
+		return
+	}
+	c.expected += uint64(diff)
+	for i := uint16(1); i < diff; i++ {
+		c.lostPacket()
+	}
+	c.pkt++
+}
+
+func (c *Collector) lostPacket() {
+	c.lost++
+	if c.pkt >= Gmin {
+		if c.burst == 1 {
+			c.c14++
+		} else {
+			c.c13++
+		}
+		c.burst = 1
+		c.c11 += c.pkt
+	} else {
+		c.burst++
+		if c.pkt == 0 {
+			c.c33++
+		} else {
+			c.c23++
+			c.c22 += c.pkt - 1
+		}
+	}
+	c.pkt = 0
+}
+
+// SetRoundTrip sets the round-trip delay measured with RTCP reports.
+func (c *Collector) SetRoundTrip(rtt time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rtt = rtt
+}
+
+// Metrics returns the metrics of the stream since the first packet. It returns false if no packets were received yet.
+func (c *Collector) Metrics() (Metrics, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.started {
+		return Metrics{}, false
+	}
+	m := Metrics{
+		SSRC:           c.ssrc,
+		LossRate:       float64(c.lost) / float64(c.expected),
+		RoundTripDelay: c.rtt,
+	}
+	// Include packets received since the last loss, which didn't make it to the counters yet.
+	c11, c14 := c.c11, c.c14
+	if c.pkt >= Gmin {
+		c11 += c.pkt
+	}
+	c31, c32 := c.c13, c.c23
+	if c.c13 == 0 {
+		// No bursts, the whole stream is a gap.
+		if c11+c14 > 0 {
+			m.GapDensity = float64(c14) / float64(c11+c14)
+		}
+		m.GapDuration = time.Duration(c.expected) * c.packetDur
+	} else {
+		p23 := 1.0
+		if c.c22+c.c23 > 0 {
+			p23 = 1 - float64(c.c22)/float64(c.c22+c.c23)
+		}
+		var p32 float64
+		if c31+c32+c.c33 > 0 {
+			p32 = float64(c32) / float64(c31+c32+c.c33)
+		}
+		if p23+p32 > 0 {
+			m.BurstDensity = p23 / (p23 + p32)
+		}
+		if c11+c14 > 0 {
+			m.GapDensity = float64(c14) / float64(c11+c14)
+		}
+		total := c11 + c14 + c.c13 + c.c22 + c.c23 + c31 + c32 + c.c33
+		gap := time.Duration(c11+c14+c.c13) * c.packetDur / time.Duration(c.c13)
+		m.GapDuration = gap
+		m.BurstDuration = max(0, time.Duration(total)*c.packetDur/time.Duration(c.c13)-gap)
+	}
+	m.RFactor, m.MOSLQ, m.MOSCQ = estimateQuality(m.LossRate, m.RoundTripDelay)
+	return m, true
+}
+
+// Report returns an extended report of the stream, sent by a given SSRC. It returns nil if no packets were received yet.
+func (c *Collector) Report(senderSSRC uint32) *rtcp.ExtendedReport {
+	m, ok := c.Metrics()
+	if !ok {
+		return nil
+	}
+	return &rtcp.ExtendedReport{
+		SenderSSRC: senderSSRC,
+		Reports:    []rtcp.ReportBlock{m.Block()},
+	}
+}
+
+// G.711 with packet loss concealment (ITU-T G.113, Appendix I).
+const (
+	codecIe  = 0
+	codecBpl = 25.1
+)
+
+// estimateQuality uses a simplified E-model (ITU-T G.107) with random loss. Listening quality ignores the delay.
+// One-way delay is estimated as a half of the round-trip delay.
+func estimateQuality(loss float64, rtt time.Duration) (r, mosLQ, mosCQ float64) {
+	ppl := loss * 100
+	ie := codecIe + (95-codecIe)*ppl/(ppl+codecBpl)
+	rLQ := 93.2 - ie
+	d := float64(rtt.Milliseconds()) / 2
+	id := 0.024 * d
+	if d > 177.3 {
+		id += 0.11 * (d - 177.3)
+	}
+	r = rLQ - id
+	return r, mosFromR(rLQ), mosFromR(r)
+}
+
+func mosFromR(r float64) float64 {
+	switch {
+	case r <= 0:
+		return 1
+	case r >= 100:
+		return 4.5
+	}
+	return 1 + 0.035*r + r*(r-60)*(100-r)*7e-6
+}
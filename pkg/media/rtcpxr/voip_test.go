@@ -0,0 +1,136 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtcpxr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockRoundTrip(t *testing.T) {
+	m := Metrics{
+		SSRC:           0xB0B,
+		LossRate:       0.05,
+		BurstDensity:   0.5,
+		GapDensity:     0.01,
+		BurstDuration:  120 * time.Millisecond,
+		GapDuration:    4 * time.Second,
+		RoundTripDelay: 80 * time.Millisecond,
+		RFactor:        70.4,
+		MOSLQ:          3.62,
+		MOSCQ:          3.58,
+	}
+	block := m.Block()
+	data, err := rtcp.Marshal([]rtcp.Packet{&rtcp.ExtendedReport{SenderSSRC: 0xA11CE, Reports: []rtcp.ReportBlock{block}}})
+	require.NoError(t, err)
+	pkts, err := rtcp.Unmarshal(data)
+	require.NoError(t, err)
+	require.Len(t, pkts, 1)
+	xr, ok := pkts[0].(*rtcp.ExtendedReport)
+	require.True(t, ok)
+	require.Equal(t, uint32(0xA11CE), xr.SenderSSRC)
+	require.Len(t, xr.Reports, 1)
+	got, ok := xr.Reports[0].(*rtcp.VoIPMetricsReportBlock)
+	require.True(t, ok)
+	require.Equal(t, block, got)
+	require.EqualValues(t, rtcp.VoIPMetricsReportBlockType, got.BlockType)
+	require.Equal(t, uint8(Gmin), got.Gmin)
+	require.Equal(t, uint8(Unavailable), got.SignalLevel)
+
+	require.Equal(t, Metrics{
+		SSRC:           0xB0B,
+		LossRate:       12.0 / 256,
+		BurstDensity:   0.5,
+		GapDensity:     2.0 / 256,
+		BurstDuration:  120 * time.Millisecond,
+		GapDuration:    4 * time.Second,
+		RoundTripDelay: 80 * time.Millisecond,
+		RFactor:        70,
+		MOSLQ:          3.6,
+		MOSCQ:          3.6,
+	}, ParseBlock(got))
+}
+
+func TestCollector(t *testing.T) {
+	const dur = 20 * time.Millisecond
+	t.Run("empty", func(t *testing.T) {
+		c := NewCollector(dur)
+		_, ok := c.Metrics()
+		require.False(t, ok)
+		require.Nil(t, c.Report(1))
+	})
+	t.Run("no loss", func(t *testing.T) {
+		c := NewCollector(dur)
+		for i := 0; i < 500; i++ {
+			c.Packet(1, uint16(65500+i)) // wraps around
+		}
+		m, ok := c.Metrics()
+		require.True(t, ok)
+		require.Zero(t, m.LossRate)
+		require.Zero(t, m.GapDensity)
+		require.Zero(t, m.BurstDensity)
+		require.Equal(t, 10*time.Second, m.GapDuration)
+		require.InDelta(t, 4.4, m.MOSLQ, 0.05)
+		require.Equal(t, m.MOSLQ, m.MOSCQ)
+	})
+	t.Run("isolated loss", func(t *testing.T) {
+		c := NewCollector(dur)
+		for i := 0; i < 1000; i++ {
+			if i%50 != 49 {
+				c.Packet(1, uint16(i))
+			}
+		}
+		c.SetRoundTrip(600 * time.Millisecond)
+		m, _ := c.Metrics()
+		require.InDelta(t, 0.02, m.LossRate, 0.001)
+		require.InDelta(t, 0.02, m.GapDensity, 0.003)
+		require.Less(t, m.MOSLQ, 4.4)
+		require.Less(t, m.MOSCQ, m.MOSLQ)
+		require.Equal(t, 600*time.Millisecond, m.RoundTripDelay)
+	})
+	t.Run("bursts", func(t *testing.T) {
+		c := NewCollector(dur)
+		// Every 200 packets, a burst of 20 packets loses every other packet.
+		for i := 0; i < 2000; i++ {
+			if j := i % 200; j >= 100 && j < 120 && j%2 == 0 {
+				continue
+			}
+			c.Packet(1, uint16(i))
+		}
+		m, _ := c.Metrics()
+		require.InDelta(t, 0.05, m.LossRate, 0.001)
+		require.Zero(t, m.GapDensity)
+		require.InDelta(t, 0.5, m.BurstDensity, 0.05)
+		require.InDelta(t, 380*time.Millisecond, m.BurstDuration, float64(40*time.Millisecond))
+		require.InDelta(t, 3600*time.Millisecond, m.GapDuration, float64(400*time.Millisecond))
+
+		xr := c.Report(2)
+		require.Equal(t, uint32(2), xr.SenderSSRC)
+		require.Equal(t, uint32(1), xr.Reports[0].(*rtcp.VoIPMetricsReportBlock).SSRC)
+	})
+	t.Run("restart", func(t *testing.T) {
+		c := NewCollector(dur)
+		c.Packet(1, 100)
+		c.Packet(1, 100) // duplicate
+		c.Packet(1, 10000)
+		c.Packet(2, 5)
+		m, _ := c.Metrics()
+		require.Zero(t, m.LossRate)
+		require.Equal(t, uint32(2), m.SSRC)
+	})
+}
@@ -0,0 +1,58 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+// RTPTimestampClock generates RTP timestamps from the number of media samples sent, instead of the wall clock.
+// This keeps timestamps continuous when writes are delayed, e.g. by CPU spikes or scheduling jitter.
+//
+// Samples are counted at the sample rate of the audio, which may differ from the RTP clock rate of the codec.
+type RTPTimestampClock struct {
+	clockRate  uint64
+	sampleRate uint64
+	base       uint32 // timestamp of the first sample
+	samples    uint64 // samples sent since base
+}
+
+// NewRTPTimestampClock creates a clock for a given RTP clock rate and audio sample rate.
+// Both default to DefSampleRate if not set.
+func NewRTPTimestampClock(clockRate, sampleRate int) *RTPTimestampClock {
+	if clockRate <= 0 {
+		clockRate = DefSampleRate
+	}
+	if sampleRate <= 0 {
+		sampleRate = DefSampleRate
+	}
+	return &RTPTimestampClock{clockRate: uint64(clockRate), sampleRate: uint64(sampleRate)}
+}
+
+// Timestamp returns the RTP timestamp of the next sample.
+func (c *RTPTimestampClock) Timestamp() uint32 {
+	// Timestamps wrap around, as expected by RTP.
+	return c.base + uint32(c.samples*c.clockRate/c.sampleRate)
+}
+
+// Advance the clock by a number of samples sent.
+func (c *RTPTimestampClock) Advance(samples int) {
+	if samples > 0 {
+		c.samples += uint64(samples)
+	}
+}
+
+// Skip advances the clock by a duration in RTP clock units, e.g. for a gap in the stream.
+func (c *RTPTimestampClock) Skip(dur uint32) {
+	// Rebase, so that rounding of the sample count is not affected by the gap.
+	c.base = c.Timestamp() + dur
+	c.samples = 0
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/media"
+)
+
+func TestRTPTimestampClock(t *testing.T) {
+	c := NewRTPTimestampClock(8000, 16000)
+	require.Equal(t, uint32(0), c.Timestamp())
+	c.Advance(320)
+	require.Equal(t, uint32(160), c.Timestamp())
+	// Odd frame sizes do not accumulate rounding errors.
+	for i := 0; i < 3; i++ {
+		c.Advance(3)
+	}
+	require.Equal(t, uint32(160+4), c.Timestamp())
+	c.Skip(100)
+	require.Equal(t, uint32(264), c.Timestamp())
+
+	// Wraparound.
+	c = NewRTPTimestampClock(0, 0)
+	c.Skip(^uint32(0) - 79)
+	c.Advance(160)
+	require.Equal(t, uint32(80), c.Timestamp())
+}
+
+func TestStreamTimestampJitter(t *testing.T) {
+	const frames = 10
+	var buf Buffer
+	st := NewSeqWriter(&buf).NewStream(0)
+	codec := NewAudioCodec(media.CodecInfo{SDPName: "test/8000"}, func(w media.PCM16Writer) media.Writer[[]byte] {
+		return nil
+	}, func(w media.Writer[[]byte]) media.PCM16Writer {
+		return media.WriterFunc[media.PCM16Sample](func(in media.PCM16Sample) error {
+			return w.WriteSample(make([]byte, len(in)))
+		})
+	})
+	w := codec.EncodeRTP(st)
+
+	// Frames are written with up to 50 ms of scheduling jitter, yet timestamps only depend on the sample count.
+	rnd := rand.New(rand.NewSource(1))
+	var exp []uint32
+	ts := uint32(0)
+	for i := 0; i < frames; i++ {
+		time.Sleep(time.Duration(rnd.Int63n(int64(50 * time.Millisecond))))
+		size := int(DefPacketDur)
+		if i%3 == 2 {
+			size /= 2 // shorter frame
+		}
+		require.NoError(t, w.WriteSample(make(media.PCM16Sample, size)))
+		exp = append(exp, ts)
+		ts += uint32(size)
+	}
+	st.Delay(DefPacketDur)
+	require.NoError(t, w.WriteSample(make(media.PCM16Sample, DefPacketDur)))
+	exp = append(exp, ts+DefPacketDur)
+
+	require.Len(t, buf, len(exp))
+	for i, p := range buf {
+		require.Equal(t, exp[i], p.Timestamp, "packet %d", i)
+		if i > 0 {
+			require.Greater(t, p.Timestamp, buf[i-1].Timestamp)
+		}
+	}
+}
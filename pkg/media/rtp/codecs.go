@@ -15,6 +15,9 @@
 package rtp
 
 import (
+	"strconv"
+	"strings"
+
 	"github.com/livekit/sip/pkg/media"
 )
 
@@ -35,6 +38,24 @@ func CodecByPayloadType(typ byte) media.Codec {
 	return codecByType[typ]
 }
 
+// CodecSampleRate returns the sample rate of audio decoded by the codec.
+func CodecSampleRate(c media.Codec) int {
+	if r := c.Info().SampleRate; r > 0 {
+		return r
+	}
+	return DefSampleRate
+}
+
+// CodecClockRate returns the RTP clock rate of the codec, as specified in its SDP name. It may differ from the sample rate.
+func CodecClockRate(c media.Codec) int {
+	_, rate, _ := strings.Cut(c.Info().SDPName, "/")
+	rate, _, _ = strings.Cut(rate, "/") // channels
+	if r, err := strconv.Atoi(rate); err == nil && r > 0 {
+		return r
+	}
+	return DefSampleRate
+}
+
 type AudioCodec interface {
 	media.Codec
 	EncodeRTP(w *Stream) media.PCM16Writer
@@ -80,7 +101,9 @@ func (c *audioCodec[S]) Encode(w media.Writer[S]) media.PCM16Writer {
 }
 
 func (c *audioCodec[S]) EncodeRTP(w *Stream) media.PCM16Writer {
-	return c.encode(NewMediaStreamOut[S](w))
+	w.SetSampleRate(CodecSampleRate(c))
+	out := NewMediaStreamOut[S](w)
+	return &sampleCounter[S]{enc: c.encode(out), out: out}
 }
 
 func (c *audioCodec[S]) DecodeRTP(w media.PCM16Writer, typ byte) Handler {
@@ -21,10 +21,14 @@ import (
 	"time"
 
 	"github.com/frostbyte73/core"
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 )
 
-var _ Writer = (*Conn)(nil)
+var (
+	_ Writer     = (*Conn)(nil)
+	_ RTCPWriter = (*Conn)(nil)
+)
 
 const (
 	timeoutCheckInterval = time.Second * 30
@@ -33,6 +37,8 @@ const (
 func NewConn(timeoutCallback func()) *Conn {
 	c := &Conn{
 		readBuf: make([]byte, 1500), // MTU
+		decBuf:  make([]byte, 1500),
+		encBuf:  make([]byte, 1500),
 	}
 	if timeoutCallback != nil {
 		c.onTimeout(timeoutCallback)
@@ -45,10 +51,22 @@ type Conn struct {
 	conn        *net.UDPConn
 	closed      core.Fuse
 	readBuf     []byte
+	decBuf      []byte
+	encBuf      []byte // guarded by wmu
 	packetCount atomic.Uint64
+	lastPacket  atomic.Int64 // unix nanoseconds
+	stats       connStats
+
+	dest   atomic.Pointer[net.UDPAddr]
+	rtcp   atomic.Pointer[rtcpConn] // set if RTCP is not multiplexed with RTP
+	onRTP  atomic.Pointer[Handler]
+	onRTCP atomic.Pointer[RTCPHandler]
+	ports  *PortPool
 
-	dest  atomic.Pointer[net.UDPAddr]
-	onRTP atomic.Pointer[Handler]
+	secure atomic.Bool
+	cipher atomic.Pointer[Cipher]
+	dtls   atomic.Pointer[dtlsConn]
+	stun   atomic.Pointer[stunConn]
 }
 
 func (c *Conn) LocalAddr() *net.UDPAddr {
@@ -77,12 +95,37 @@ func (c *Conn) OnRTP(h Handler) {
 	}
 }
 
+// OnRTCP sets a handler for RTCP packets, either multiplexed with RTP (RFC 5761), or received on the RTCP port.
+func (c *Conn) OnRTCP(h RTCPHandler) {
+	if c == nil {
+		return
+	}
+	if h == nil {
+		c.onRTCP.Store(nil)
+	} else {
+		c.onRTCP.Store(&h)
+	}
+}
+
 func (c *Conn) Close() error {
 	if c == nil {
 		return nil
 	}
 	c.closed.Once(func() {
+		if c.conn == nil {
+			return
+		}
+		port := c.LocalAddr().Port
 		c.conn.Close()
+		if c.ports != nil {
+			c.ports.Release(port)
+		}
+		if r := c.rtcp.Load(); r != nil {
+			r.conn.Close()
+			if c.ports != nil {
+				c.ports.Release(port + 1)
+			}
+		}
 	})
 	return nil
 }
@@ -108,6 +151,29 @@ func (c *Conn) ListenAndServe(portMin, portMax int, listenAddr string) error {
 	return nil
 }
 
+// ListenPool is similar to Listen, but allocates the port from the pool.
+// The port is returned to the pool when the connection is closed.
+func (c *Conn) ListenPool(ports *PortPool, listenAddr string) error {
+	if listenAddr == "" {
+		listenAddr = "0.0.0.0"
+	}
+
+	conn, err := ports.ListenUDP(net.ParseIP(listenAddr))
+	if err != nil {
+		return err
+	}
+	c.conn, c.ports = conn, ports
+	return nil
+}
+
+func (c *Conn) ListenAndServePool(ports *PortPool, listenAddr string) error {
+	if err := c.ListenPool(ports, listenAddr); err != nil {
+		return err
+	}
+	go c.readLoop()
+	return nil
+}
+
 func (c *Conn) readLoop() {
 	conn, buf := c.conn, c.readBuf
 	var p rtp.Packet
@@ -116,20 +182,152 @@ func (c *Conn) readLoop() {
 		if err != nil {
 			return
 		}
+		data := buf[:n]
+		if IsSTUN(data) {
+			if s := c.stun.Load(); s != nil {
+				s.push(data, srcAddr)
+			}
+			continue
+		}
 		c.dest.Store(srcAddr)
 
+		if IsDTLS(data) {
+			if d := c.dtls.Load(); d != nil {
+				d.push(data)
+			}
+			continue
+		}
+
+		isRTCP := IsRTCP(data)
+		data, ok := c.decrypt(data, isRTCP)
+		if !ok {
+			continue
+		}
+		if isRTCP {
+			c.handleRTCP(data)
+			continue
+		}
+
 		p = rtp.Packet{}
-		if err := p.Unmarshal(buf[:n]); err != nil {
+		if err := p.Unmarshal(data); err != nil {
 			continue
 		}
 
+		now := time.Now()
 		c.packetCount.Add(1)
+		c.lastPacket.Store(now.UnixNano())
+		c.stats.onReceived(&p, n, now)
 		if h := c.onRTP.Load(); h != nil {
 			_ = (*h).HandleRTP(&p)
 		}
 	}
 }
 
+// rtcpConn is a connection for RTCP on a separate port, when it's not multiplexed with RTP (RFC 3550, section 11).
+type rtcpConn struct {
+	conn    *net.UDPConn
+	readBuf []byte
+	decBuf  []byte
+	dest    atomic.Pointer[net.UDPAddr] // learned from received packets
+}
+
+// ListenRTCP starts listening for RTCP on the next port after RTP, for peers that do not support rtcp-mux.
+// Reports are sent to the next port after the RTP destination, unless the peer sends RTCP from a different one.
+// It's a no-op if already listening. It must not be called concurrently, and it must not be used with DTLS,
+// since DTLS would require a separate handshake on the RTCP port (RFC 5764, section 4.1).
+func (c *Conn) ListenRTCP() error {
+	if c.rtcp.Load() != nil {
+		return nil
+	}
+	if c.closed.IsBroken() {
+		return net.ErrClosed
+	}
+	laddr := c.LocalAddr()
+	if laddr == nil {
+		return net.ErrClosed
+	}
+	var (
+		conn *net.UDPConn
+		err  error
+	)
+	if c.ports != nil {
+		conn, err = c.ports.ListenUDPPort(laddr.IP, laddr.Port+1)
+	} else {
+		conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: laddr.IP, Port: laddr.Port + 1})
+	}
+	if err != nil {
+		return err
+	}
+	r := &rtcpConn{conn: conn, readBuf: make([]byte, 1500), decBuf: make([]byte, 1500)}
+	c.rtcp.Store(r)
+	go c.readRTCPLoop(r)
+	return nil
+}
+
+func (c *Conn) readRTCPLoop(r *rtcpConn) {
+	for {
+		n, srcAddr, err := r.conn.ReadFromUDP(r.readBuf)
+		if err != nil {
+			return
+		}
+		data := r.readBuf[:n]
+		if !IsRTCP(data) {
+			continue
+		}
+		r.dest.Store(srcAddr)
+		data, ok := c.decryptRTCP(r.decBuf, data)
+		if !ok {
+			continue
+		}
+		c.handleRTCP(data)
+	}
+}
+
+// rtcpDest returns the destination of RTCP packets sent on the separate port.
+func (r *rtcpConn) rtcpDest(rtpDest *net.UDPAddr) *net.UDPAddr {
+	if addr := r.dest.Load(); addr != nil {
+		return addr
+	}
+	if rtpDest == nil {
+		return nil
+	}
+	return &net.UDPAddr{IP: rtpDest.IP, Port: rtpDest.Port + 1, Zone: rtpDest.Zone}
+}
+
+func (c *Conn) handleRTCP(data []byte) {
+	h := c.onRTCP.Load()
+	if h == nil {
+		return
+	}
+	pkts, err := rtcp.Unmarshal(data)
+	if err != nil {
+		return
+	}
+	_ = (*h).HandleRTCP(pkts)
+}
+
+func (c *Conn) WriteRTCP(pkts []rtcp.Packet) error {
+	conn, addr := net.PacketConn(c.conn), c.dest.Load()
+	if r := c.rtcp.Load(); r != nil {
+		conn, addr = r.conn, r.rtcpDest(addr)
+	}
+	if addr == nil {
+		return nil
+	}
+	data, err := rtcp.Marshal(pkts)
+	if err != nil {
+		return err
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	data, ok, err := c.encrypt(data, true)
+	if !ok {
+		return err
+	}
+	_, err = conn.WriteTo(data, addr)
+	return err
+}
+
 func (c *Conn) WriteRTP(p *rtp.Packet) error {
 	addr := c.dest.Load()
 	if addr == nil {
@@ -141,8 +339,15 @@ func (c *Conn) WriteRTP(p *rtp.Packet) error {
 	}
 	c.wmu.Lock()
 	defer c.wmu.Unlock()
-	_, err = c.conn.WriteTo(data, addr)
-	return err
+	data, ok, err := c.encrypt(data, false)
+	if !ok {
+		return err
+	}
+	if _, err = c.conn.WriteTo(data, addr); err != nil {
+		return err
+	}
+	c.stats.onSent(len(data), time.Now())
+	return nil
 }
 
 func (c *Conn) ReadRTP() (*rtp.Packet, *net.UDPAddr, error) {
@@ -158,6 +363,15 @@ func (c *Conn) ReadRTP() (*rtp.Packet, *net.UDPAddr, error) {
 	return &p, addr, nil
 }
 
+// LastPacket returns the time when the last RTP packet was received. It's zero if nothing was received yet.
+func (c *Conn) LastPacket() time.Time {
+	t := c.lastPacket.Load()
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, t)
+}
+
 func (c *Conn) onTimeout(timeoutCallback func()) {
 	go func() {
 		ticker := time.NewTicker(timeoutCheckInterval)
@@ -0,0 +1,95 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+import (
+	"math"
+	"time"
+
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/resample"
+)
+
+const (
+	// MaxDriftPPM limits the rate adjustment of DriftCompensator, in parts per million (0.01%).
+	MaxDriftPPM = 100
+
+	// minDriftWindow is the minimal time between sender reports used for drift estimation.
+	// NTP timestamps are usually generated with a few ms of jitter, so short windows are not precise enough.
+	minDriftWindow = 30 * time.Second
+	// maxDriftPPM rejects estimates which are caused by timestamp jumps rather than the clock drift.
+	maxDriftPPM = 1000
+)
+
+// Drift estimates how much faster the RTP clock of the stream runs compared to its sender wall clock,
+// in parts per million. Positive drift means the sender produces more samples per second than its clock rate.
+// It returns false until sender reports of the stream cover at least 30 seconds.
+func (c *SenderClock) Drift(ssrc uint32) (ppm float64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	first, ok1 := c.first[ssrc]
+	last, ok2 := c.streams[ssrc]
+	if !ok1 || !ok2 || last.ntp.Sub(first.ntp) < minDriftWindow {
+		return 0, false
+	}
+	return driftPPM(first, last, c.rate)
+}
+
+func driftPPM(first, last senderRef, rate int) (float64, bool) {
+	if rate <= 0 {
+		return 0, false
+	}
+	wall := last.ntp.Sub(first.ntp).Seconds()
+	if wall <= 0 {
+		return 0, false
+	}
+	// Streams longer than 2^31 samples (3 days at 8 kHz) are not supported.
+	samples := float64(int32(last.rtp - first.rtp))
+	ppm := (samples/float64(rate)/wall - 1) * 1e6
+	if math.Abs(ppm) > maxDriftPPM {
+		return 0, false
+	}
+	return ppm, true
+}
+
+// DriftFunc returns the current clock drift estimate of the stream, in parts per million.
+type DriftFunc func() (ppm float64, ok bool)
+
+// NewDriftCompensator resamples audio of the remote stream by its clock drift, so that it's played at
+// the nominal sample rate of the local clock. Adjustment is limited to ±MaxDriftPPM.
+// Audio is written as-is until the drift is known.
+func NewDriftCompensator(dst media.PCM16Writer, drift DriftFunc) media.PCM16Writer {
+	return &driftCompensator{dst: dst, drift: drift, w: dst}
+}
+
+type driftCompensator struct {
+	dst   media.PCM16Writer
+	drift DriftFunc
+	ppm   int
+	w     media.PCM16Writer
+}
+
+func (d *driftCompensator) WriteSample(sample media.PCM16Sample) error {
+	ppm := 0
+	if v, ok := d.drift(); ok {
+		ppm = max(-MaxDriftPPM, min(MaxDriftPPM, int(math.Round(v))))
+	}
+	if ppm != d.ppm {
+		// Estimates change slowly, so the resampler is only recreated once new sender reports arrive.
+		d.ppm = ppm
+		d.w = resample.Resample(d.dst, 1e6+ppm, 1e6)
+	}
+	return d.w.WriteSample(sample)
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/media"
+)
+
+func TestSenderClockDrift(t *testing.T) {
+	const ssrc = 1234
+	c := NewSenderClock(DefSampleRate)
+	_, ok := c.Drift(ssrc)
+	require.False(t, ok)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var ts uint32 = 0xFFFFFF00 // close to wraparound
+	report := func(dt time.Duration, ppm float64) {
+		samples := dt.Seconds() * DefSampleRate * (1 + ppm/1e6)
+		c.Update(&rtcp.SenderReport{SSRC: ssrc, NTPTime: ToNTP(now.Add(dt)), RTPTime: ts + uint32(samples)})
+	}
+	report(0, 20)
+	report(10*time.Second, 20)
+	// Not enough time passed to estimate the drift.
+	_, ok = c.Drift(ssrc)
+	require.False(t, ok)
+
+	report(10*time.Minute, 20)
+	ppm, ok := c.Drift(ssrc)
+	require.True(t, ok)
+	require.InDelta(t, 20, ppm, 0.1)
+
+	// Timestamp jumps are not drift.
+	report(11*time.Minute, 1e5)
+	_, ok = c.Drift(ssrc)
+	require.False(t, ok)
+}
+
+func TestDriftCompensator(t *testing.T) {
+	const (
+		ppm   = 20
+		dur   = time.Hour
+		frame = int(DefPacketDur)
+	)
+	c := NewSenderClock(DefSampleRate)
+	var out, outRaw int
+	w := NewDriftCompensator(media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		out += len(s)
+		return nil
+	}), func() (float64, bool) {
+		return c.Drift(1)
+	})
+
+	// The sender clock runs faster, so it sends more than 8000 samples per second of the wall clock.
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var sent int
+	buf := make(media.PCM16Sample, 2*frame)
+	for dt := time.Duration(0); dt < dur; dt += DefFrameDur {
+		if dt%DefRTCPInterval == 0 {
+			c.Update(&rtcp.SenderReport{SSRC: 1, NTPTime: ToNTP(start.Add(dt)), RTPTime: uint32(sent)})
+		}
+		n := int((dt+DefFrameDur).Seconds()*DefSampleRate*(1+ppm/1e6)) - sent
+		sent += n
+		outRaw += n
+		require.NoError(t, w.WriteSample(buf[:n]))
+	}
+	exp := int(dur.Seconds() * DefSampleRate)
+	// Without compensation, the stream is ahead by more than one frame.
+	require.Greater(t, outRaw-exp, frame)
+	require.InDelta(t, exp, out, float64(frame))
+}
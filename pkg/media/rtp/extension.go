@@ -0,0 +1,117 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+import (
+	"errors"
+	"math"
+
+	"github.com/livekit/sip/pkg/media"
+)
+
+// AudioLevelURI identifies the client-to-mixer audio level header extension (RFC 6464).
+const AudioLevelURI = "urn:ietf:params:rtp-hdrext:ssrc-audio-level"
+
+// AudioLevelSilence is the level of digital silence, in -dBov.
+const AudioLevelSilence = 127
+
+// ExtensionHeader is an RTP header extension (RFC 8285) negotiated for the stream, for example with a=extmap in SDP.
+type ExtensionHeader struct {
+	ID  uint8
+	URI string
+}
+
+// FindExtension returns the ID of the header extension with a given URI, or zero if it was not negotiated.
+func FindExtension(exts []ExtensionHeader, uri string) uint8 {
+	for _, ext := range exts {
+		if ext.URI == uri {
+			return ext.ID
+		}
+	}
+	return 0
+}
+
+// AudioLevel is the audio level of a packet, as carried in the audio level header extension (RFC 6464).
+type AudioLevel struct {
+	Level uint8 // in -dBov, from 0 (loudest) to 127 (silence)
+	Voice bool  // set if the sender detected voice in the packet
+}
+
+// DBov returns the level in dBov, which is zero or negative.
+func (l AudioLevel) DBov() float64 {
+	return -float64(l.Level)
+}
+
+func (l AudioLevel) Marshal() []byte {
+	return []byte{l.byte()}
+}
+
+func (l AudioLevel) byte() byte {
+	b := min(l.Level, AudioLevelSilence)
+	if l.Voice {
+		b |= 0x80
+	}
+	return b
+}
+
+// ParseAudioLevel parses the payload of the audio level header extension.
+func ParseAudioLevel(data []byte) (AudioLevel, error) {
+	// Only the first byte is used, the rest is padding (RFC 6464, section 3).
+	if len(data) == 0 {
+		return AudioLevel{}, errors.New("empty audio level extension")
+	}
+	return AudioLevel{Level: data[0] & 0x7f, Voice: data[0]&0x80 != 0}, nil
+}
+
+// GetAudioLevel returns the audio level carried in the header extension with a given ID, if any.
+func GetAudioLevel(p *Packet, id uint8) (AudioLevel, bool) {
+	if id == 0 || !p.Extension {
+		return AudioLevel{}, false
+	}
+	l, err := ParseAudioLevel(p.GetExtension(id))
+	if err != nil {
+		return AudioLevel{}, false
+	}
+	return l, true
+}
+
+// NewAudioLevel measures the level of the audio. It is the RMS of samples relative to the full scale,
+// as required by RFC 6464. The voice flag is not set.
+func NewAudioLevel(sample media.PCM16Sample) AudioLevel {
+	if len(sample) == 0 {
+		return AudioLevel{Level: AudioLevelSilence}
+	}
+	var sum float64
+	for _, v := range sample {
+		sum += float64(v) * float64(v)
+	}
+	rms := math.Sqrt(sum/float64(len(sample))) / math.MaxInt16
+	if rms == 0 {
+		return AudioLevel{Level: AudioLevelSilence}
+	}
+	dBov := -math.Round(20 * math.Log10(rms))
+	return AudioLevel{Level: uint8(max(0, min(dBov, AudioLevelSilence)))}
+}
+
+// NewAudioLevelHandler calls onLevel with audio levels carried in the header extension with a given ID,
+// and passes all packets to the next handler.
+func NewAudioLevelHandler(h Handler, id uint8, onLevel func(l AudioLevel)) Handler {
+	return HandlerFunc(func(p *Packet) error {
+		if l, ok := GetAudioLevel(p, id); ok {
+			onLevel(l)
+		}
+		return h.HandleRTP(p)
+	})
+}
@@ -0,0 +1,122 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/media"
+)
+
+func TestAudioLevelExtension(t *testing.T) {
+	// Audio level in the one-byte header (RFC 8285), after a MID extension (RFC 8843).
+	data := []byte{
+		0x90, 0x00, 0x00, 0x01, // V=2, X=1, PT=0, seq=1
+		0x00, 0x00, 0x00, 0xa0, // timestamp
+		0x00, 0x00, 0x12, 0x34, // SSRC
+		0xbe, 0xde, 0x00, 0x02, // one-byte profile, 2 words
+		0x21, 'a', '0', // id=2, MID "a0"
+		0x10, 0x80 | 30, // id=1, V=1, level=30
+		0x00, 0x00, 0x00, // padding
+		0xff, 0xff, // payload
+	}
+	var p rtp.Packet
+	require.NoError(t, p.Unmarshal(data))
+	l, ok := GetAudioLevel(&p, 1)
+	require.True(t, ok)
+	require.Equal(t, AudioLevel{Level: 30, Voice: true}, l)
+	require.Equal(t, -30.0, l.DBov())
+	_, ok = GetAudioLevel(&p, 3)
+	require.False(t, ok)
+
+	// Two-byte header.
+	p = rtp.Packet{Header: rtp.Header{Version: 2, Extension: true, ExtensionProfile: 0x1000}}
+	require.NoError(t, p.SetExtension(20, AudioLevel{Level: 127}.Marshal()))
+	data, err := p.Marshal()
+	require.NoError(t, err)
+	p = rtp.Packet{}
+	require.NoError(t, p.Unmarshal(data))
+	l, ok = GetAudioLevel(&p, 20)
+	require.True(t, ok)
+	require.Equal(t, AudioLevel{Level: AudioLevelSilence}, l)
+
+	var got []AudioLevel
+	h := NewAudioLevelHandler(HandlerFunc(func(p *Packet) error { return nil }), 20, func(l AudioLevel) {
+		got = append(got, l)
+	})
+	require.NoError(t, h.HandleRTP(&p))
+	require.NoError(t, h.HandleRTP(&rtp.Packet{}))
+	require.Equal(t, []AudioLevel{{Level: AudioLevelSilence}}, got)
+
+	require.EqualValues(t, 5, FindExtension([]ExtensionHeader{{ID: 3, URI: "urn:ietf:params:rtp-hdrext:sdes:mid"}, {ID: 5, URI: AudioLevelURI}}, AudioLevelURI))
+	require.Zero(t, FindExtension(nil, AudioLevelURI))
+}
+
+func TestNewAudioLevel(t *testing.T) {
+	square := func(amp int16) media.PCM16Sample {
+		s := make(media.PCM16Sample, 160)
+		for i := range s {
+			s[i] = amp
+			if i%2 == 1 {
+				s[i] = -amp
+			}
+		}
+		return s
+	}
+	require.Equal(t, AudioLevel{Level: 0}, NewAudioLevel(square(0x7FFF)))
+	require.Equal(t, AudioLevel{Level: 6}, NewAudioLevel(square(0x4000)))
+	require.Equal(t, AudioLevel{Level: 40}, NewAudioLevel(square(328)))
+	require.Equal(t, AudioLevel{Level: AudioLevelSilence}, NewAudioLevel(square(0)))
+	require.Equal(t, AudioLevel{Level: AudioLevelSilence}, NewAudioLevel(nil))
+}
+
+func TestStreamAudioLevelExtension(t *testing.T) {
+	var buf Buffer
+	s := NewSeqWriter(&buf).NewStream(0)
+	enc := &sampleCounter[[]byte]{
+		enc: media.WriterFunc[media.PCM16Sample](func(sample media.PCM16Sample) error {
+			return s.WritePayload(make([]byte, len(sample)), false)
+		}),
+		out: NewMediaStreamOut[[]byte](s),
+	}
+	loud := make(media.PCM16Sample, 160)
+	for i := range loud {
+		loud[i] = 0x4000
+	}
+	require.NoError(t, enc.WriteSample(loud))
+	s.SetAudioLevelExtension(4)
+	require.NoError(t, enc.WriteSample(loud))
+	require.NoError(t, enc.WriteSample(make(media.PCM16Sample, 160)))
+	// Payloads written directly are not measured.
+	require.NoError(t, s.WritePayload([]byte{1}, false))
+
+	require.Len(t, buf, 4)
+	require.False(t, buf[0].Extension)
+	var levels []AudioLevel
+	for _, p := range buf[1:3] {
+		data, err := p.Marshal()
+		require.NoError(t, err)
+		var p2 rtp.Packet
+		require.NoError(t, p2.Unmarshal(data))
+		l, ok := GetAudioLevel(&p2, 4)
+		require.True(t, ok)
+		levels = append(levels, l)
+	}
+	require.Equal(t, []AudioLevel{{Level: 6}, {Level: AudioLevelSilence}}, levels)
+	require.False(t, buf[3].Extension)
+}
@@ -16,12 +16,17 @@ package rtp
 
 import (
 	"errors"
+	"fmt"
 	"math/rand"
 	"net"
+	"sync"
 )
 
 var ListenErr = errors.New("failed to listen on udp port")
 
+// ErrNoFreePorts is returned by PortPool when all ports in the range are already allocated.
+var ErrNoFreePorts = fmt.Errorf("%w: no free ports in the range", ListenErr)
+
 func ListenUDPPortRange(portMin, portMax int, IP net.IP) (*net.UDPConn, error) {
 	if portMin == 0 && portMax == 0 {
 		return net.ListenUDP("udp", &net.UDPAddr{
@@ -63,3 +68,108 @@ func ListenUDPPortRange(portMin, portMax int, IP net.IP) (*net.UDPConn, error) {
 	}
 	return nil, ListenErr
 }
+
+// NewPortPool creates a pool of UDP ports in [portMin, portMax] range.
+//
+// Unlike ListenUDPPortRange, the pool keeps track of allocated ports, so they must be returned with Release.
+// If both portMin and portMax are zero, the pool is unbounded and ports are selected by the OS.
+func NewPortPool(portMin, portMax int) *PortPool {
+	if portMin != 0 || portMax != 0 {
+		if portMin == 0 {
+			portMin = 1
+		}
+		if portMax == 0 {
+			portMax = 0xFFFF
+		}
+	}
+	return &PortPool{min: portMin, max: portMax, used: make(map[int]struct{})}
+}
+
+type PortPool struct {
+	min, max int
+
+	mu   sync.Mutex
+	used map[int]struct{}
+}
+
+// Size returns the number of ports in the pool. Zero means that the pool is unbounded.
+func (p *PortPool) Size() int {
+	if p.min == 0 && p.max == 0 {
+		return 0
+	}
+	if p.min > p.max {
+		return 0
+	}
+	return p.max - p.min + 1
+}
+
+// InUse returns the number of ports currently allocated from the pool.
+func (p *PortPool) InUse() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.used)
+}
+
+// ListenUDP allocates a free port from the pool and starts listening on it.
+// Port must be returned to the pool with Release after the connection is closed.
+func (p *PortPool) ListenUDP(ip net.IP) (*net.UDPConn, error) {
+	if p.min == 0 && p.max == 0 {
+		return net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: 0})
+	}
+	if p.min > p.max {
+		return nil, ListenErr
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.used) >= p.Size() {
+		return nil, ErrNoFreePorts
+	}
+	portStart := rand.Intn(p.max-p.min+1) + p.min
+	portCurrent := portStart
+	for {
+		if _, ok := p.used[portCurrent]; !ok {
+			c, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: portCurrent})
+			if err == nil {
+				p.used[portCurrent] = struct{}{}
+				return c, nil
+			}
+		}
+		portCurrent++
+		if portCurrent > p.max {
+			portCurrent = p.min
+		}
+		if portCurrent == portStart {
+			break
+		}
+	}
+	return nil, ListenErr
+}
+
+// ListenUDPPort allocates a specific port and starts listening on it. Ports outside the range are not tracked by the pool.
+// Port must be returned to the pool with Release after the connection is closed.
+func (p *PortPool) ListenUDPPort(ip net.IP, port int) (*net.UDPConn, error) {
+	if port < p.min || port > p.max {
+		return net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port})
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.used[port]; ok {
+		return nil, fmt.Errorf("%w: port %d is already allocated", ListenErr, port)
+	}
+	c, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port})
+	if err != nil {
+		return nil, err
+	}
+	p.used[port] = struct{}{}
+	return c, nil
+}
+
+// Release returns the port to the pool.
+func (p *PortPool) Release(port int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.used, port)
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPortPool(t *testing.T) {
+	const (
+		portMin = 30200
+		portMax = 30202
+	)
+	ip := net.IPv4(127, 0, 0, 1)
+	p := NewPortPool(portMin, portMax)
+	require.Equal(t, 3, p.Size())
+
+	var conns []*net.UDPConn
+	for i := 0; i < p.Size(); i++ {
+		c, err := p.ListenUDP(ip)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = c.Close() })
+		port := c.LocalAddr().(*net.UDPAddr).Port
+		require.GreaterOrEqual(t, port, portMin)
+		require.LessOrEqual(t, port, portMax)
+		conns = append(conns, c)
+	}
+	require.Equal(t, 3, p.InUse())
+
+	_, err := p.ListenUDP(ip)
+	require.ErrorIs(t, err, ErrNoFreePorts)
+	require.ErrorIs(t, err, ListenErr)
+
+	c := conns[1]
+	port := c.LocalAddr().(*net.UDPAddr).Port
+	_ = c.Close()
+	p.Release(port)
+	require.Equal(t, 2, p.InUse())
+
+	c, err = p.ListenUDP(ip)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+	require.Equal(t, port, c.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func TestConnPortRelease(t *testing.T) {
+	p := NewPortPool(30210, 30210)
+
+	c1 := NewConn(nil)
+	require.NoError(t, c1.ListenPool(p, "127.0.0.1"))
+
+	c2 := NewConn(nil)
+	require.ErrorIs(t, c2.ListenPool(p, "127.0.0.1"), ErrNoFreePorts)
+
+	require.NoError(t, c1.Close())
+	require.Equal(t, 0, p.InUse())
+
+	require.NoError(t, c2.ListenPool(p, "127.0.0.1"))
+	require.NoError(t, c2.Close())
+}
@@ -0,0 +1,233 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+import (
+	"errors"
+
+	"github.com/pion/rtp"
+
+	"github.com/livekit/sip/pkg/media"
+)
+
+// REDSDPName is the SDP encoding name of redundant audio data (RFC 2198). The clock rate matches the primary codec,
+// and the fmtp attribute lists payload types of all blocks, e.g. "a=fmtp:63 111/111".
+const REDSDPName = "red"
+
+const (
+	// MaxRedundancyLevel limits the number of previous frames sent with each packet.
+	MaxRedundancyLevel = 4
+
+	maxREDOffset   = 1<<14 - 1 // 14 bit timestamp offset
+	maxREDBlockLen = 1<<10 - 1 // 10 bit block length
+)
+
+var errInvalidRED = errors.New("invalid RED payload")
+
+// REDBlock is a redundant block of the RED payload (RFC 2198), usually an earlier frame of the primary codec.
+type REDBlock struct {
+	Type            byte
+	TimestampOffset uint16 // relative to the timestamp of the packet
+	Payload         []byte
+}
+
+// AppendRED appends the RED payload with redundant blocks, oldest first, followed by the primary payload.
+// Blocks with the offset or the length that cannot be encoded are skipped.
+func AppendRED(dst []byte, primaryType byte, primary []byte, blocks []REDBlock) []byte {
+	for _, b := range blocks {
+		if !b.valid() {
+			continue
+		}
+		// F bit, block PT, 14 bit timestamp offset and 10 bit block length (RFC 2198, section 3).
+		dst = append(dst,
+			0x80|b.Type&0x7f,
+			byte(b.TimestampOffset>>6),
+			byte(b.TimestampOffset<<2)|byte(len(b.Payload)>>8),
+			byte(len(b.Payload)),
+		)
+	}
+	dst = append(dst, primaryType&0x7f)
+	for _, b := range blocks {
+		if b.valid() {
+			dst = append(dst, b.Payload...)
+		}
+	}
+	return append(dst, primary...)
+}
+
+func (b REDBlock) valid() bool {
+	return b.TimestampOffset <= maxREDOffset && len(b.Payload) <= maxREDBlockLen
+}
+
+// ParseRED parses the RED payload. Returned payloads reference the data.
+func ParseRED(data []byte) (primaryType byte, primary []byte, blocks []REDBlock, _ error) {
+	var lens []int
+	i := 0
+	for {
+		if i >= len(data) {
+			return 0, nil, nil, errInvalidRED
+		}
+		if data[i]&0x80 == 0 {
+			primaryType = data[i] & 0x7f
+			i++
+			break
+		}
+		if i+4 > len(data) {
+			return 0, nil, nil, errInvalidRED
+		}
+		blocks = append(blocks, REDBlock{
+			Type:            data[i] & 0x7f,
+			TimestampOffset: uint16(data[i+1])<<6 | uint16(data[i+2])>>2,
+		})
+		lens = append(lens, int(data[i+2]&0x03)<<8|int(data[i+3]))
+		i += 4
+	}
+	for j, n := range lens {
+		if i+n > len(data) {
+			return 0, nil, nil, errInvalidRED
+		}
+		blocks[j].Payload = data[i : i+n]
+		i += n
+	}
+	return primaryType, data[i:], blocks, nil
+}
+
+// redFrame is an earlier payload of the primary codec, kept for redundancy.
+type redFrame struct {
+	ts   uint32
+	data []byte
+}
+
+// redEncoder wraps primary payloads of the stream into RED payloads with up to level previous frames.
+type redEncoder struct {
+	typ   byte
+	level int
+	hist  []redFrame // oldest first
+	buf   []byte
+}
+
+func (e *redEncoder) encode(ts uint32, data []byte) []byte {
+	blocks := make([]REDBlock, 0, len(e.hist))
+	for _, f := range e.hist {
+		if off := ts - f.ts; off <= maxREDOffset {
+			blocks = append(blocks, REDBlock{Type: e.typ, TimestampOffset: uint16(off), Payload: f.data})
+		}
+	}
+	e.buf = AppendRED(e.buf[:0], e.typ, data, blocks)
+	// Payload may be reused by the primary encoder.
+	if len(e.hist) >= e.level {
+		f := e.hist[0]
+		e.hist = append(e.hist[:0], e.hist[1:]...)
+		f.ts, f.data = ts, append(f.data[:0], data...)
+		e.hist = append(e.hist, f)
+	} else {
+		e.hist = append(e.hist, redFrame{ts: ts, data: append([]byte(nil), data...)})
+	}
+	return e.buf
+}
+
+// setRedundancy sends payloads of the stream as RED (RFC 2198) with up to level previous frames of the primary type.
+func (s *Stream) setRedundancy(primaryType byte, level int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if level <= 0 {
+		s.red = nil
+		return
+	}
+	s.red = &redEncoder{typ: primaryType, level: min(level, MaxRedundancyLevel)}
+}
+
+var _ AudioCodec = (*FECRedundancyCodec)(nil)
+
+// FECRedundancyCodec wraps an audio codec to send redundant audio data (RFC 2198). Each packet carries
+// up to level previous frames in addition to the primary one, which allows the receiver to recover lost packets.
+//
+// The stream passed to EncodeRTP must use the RED payload type. Info returns the primary codec info.
+type FECRedundancyCodec struct {
+	codec AudioCodec
+	typ   byte
+	level int
+}
+
+// NewFECRedundancyCodec wraps the codec with a given primary payload type. Level is limited to MaxRedundancyLevel.
+func NewFECRedundancyCodec(codec AudioCodec, primaryType byte, level int) *FECRedundancyCodec {
+	return &FECRedundancyCodec{codec: codec, typ: primaryType, level: min(level, MaxRedundancyLevel)}
+}
+
+func (c *FECRedundancyCodec) Info() media.CodecInfo {
+	return c.codec.Info()
+}
+
+func (c *FECRedundancyCodec) EncodeRTP(w *Stream) media.PCM16Writer {
+	w.setRedundancy(c.typ, c.level)
+	return c.codec.EncodeRTP(w)
+}
+
+// DecodeRTP decodes RED packets of the given type.
+func (c *FECRedundancyCodec) DecodeRTP(w media.PCM16Writer, typ byte) Handler {
+	return NewREDHandler(c.codec.DecodeRTP(w, c.typ), c.typ)
+}
+
+// NewREDHandler unwraps RED packets (RFC 2198) and passes payloads of the primary type to h.
+// Primary payloads are always used. Redundant blocks are only used to recover packets which were lost.
+// Blocks of other types (e.g. DTMF events) are ignored.
+//
+// Each redundant block is assumed to be carried by one of the preceding packets, the most recent one last,
+// which is how senders usually build RED packets.
+func NewREDHandler(h Handler, primaryType byte) Handler {
+	return &redHandler{h: h, typ: primaryType}
+}
+
+type redHandler struct {
+	h       Handler
+	typ     byte
+	started bool
+	last    uint16 // last sequence number passed to the handler
+	p       rtp.Packet
+}
+
+func (r *redHandler) HandleRTP(p *rtp.Packet) error {
+	typ, primary, blocks, err := ParseRED(p.Payload)
+	if err != nil {
+		return err
+	}
+	if r.started {
+		for i, b := range blocks {
+			seq := p.SequenceNumber - uint16(len(blocks)-i)
+			if int16(seq-r.last) <= 0 || b.Type != r.typ {
+				continue // received already, or not audio
+			}
+			r.p = rtp.Packet{Header: p.Header, Payload: b.Payload}
+			r.p.PayloadType = r.typ
+			r.p.Marker = false
+			r.p.SequenceNumber = seq
+			r.p.Timestamp = p.Timestamp - uint32(b.TimestampOffset)
+			r.last = seq
+			if err := r.h.HandleRTP(&r.p); err != nil {
+				return err
+			}
+		}
+	}
+	if !r.started || int16(p.SequenceNumber-r.last) > 0 {
+		r.started = true
+		r.last = p.SequenceNumber
+	}
+	if typ != r.typ {
+		return nil
+	}
+	r.p = rtp.Packet{Header: p.Header, Payload: primary}
+	r.p.PayloadType = r.typ
+	return r.h.HandleRTP(&r.p)
+}
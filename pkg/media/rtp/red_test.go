@@ -0,0 +1,165 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/media"
+)
+
+func TestRED(t *testing.T) {
+	// Example from RFC 2198, section 3: one redundant block with a timestamp offset.
+	blocks := []REDBlock{{Type: 0, TimestampOffset: 160, Payload: []byte{1, 2, 3}}}
+	data := AppendRED(nil, 0, []byte{4, 5}, blocks)
+	require.Equal(t, []byte{0x80, 0x02, 0x80, 0x03, 0x00, 1, 2, 3, 4, 5}, data)
+
+	typ, primary, got, err := ParseRED(data)
+	require.NoError(t, err)
+	require.Equal(t, byte(0), typ)
+	require.Equal(t, []byte{4, 5}, primary)
+	require.Equal(t, blocks, got)
+
+	// Primary only.
+	typ, primary, got, err = ParseRED([]byte{111, 7})
+	require.NoError(t, err)
+	require.Equal(t, byte(111), typ)
+	require.Equal(t, []byte{7}, primary)
+	require.Empty(t, got)
+
+	// Blocks which cannot be encoded are skipped.
+	data = AppendRED(nil, 0, nil, []REDBlock{{TimestampOffset: maxREDOffset + 1}, {Payload: make([]byte, maxREDBlockLen+1)}})
+	require.Equal(t, []byte{0}, data)
+
+	for _, data := range [][]byte{
+		nil,
+		{0x80, 0x02, 0x80},
+		{0x80, 0x02, 0x80, 0x03, 0x00, 1, 2},
+	} {
+		_, _, _, err = ParseRED(data)
+		require.Error(t, err)
+	}
+}
+
+// newTestPCMCodec creates a codec which sends the first sample of each frame as a one byte payload.
+func newTestPCMCodec() AudioCodec {
+	return NewAudioCodec(media.CodecInfo{SDPName: "test/8000"}, func(w media.PCM16Writer) media.Writer[[]byte] {
+		return media.WriterFunc[[]byte](func(in []byte) error {
+			return w.WriteSample(media.PCM16Sample{int16(in[0])})
+		})
+	}, func(w media.Writer[[]byte]) media.PCM16Writer {
+		return media.WriterFunc[media.PCM16Sample](func(in media.PCM16Sample) error {
+			return w.WriteSample([]byte{byte(in[0])})
+		})
+	})
+}
+
+func TestFECRedundancyCodec(t *testing.T) {
+	const (
+		primaryType = 0
+		redType     = 63
+		frames      = 20
+	)
+	codec := NewFECRedundancyCodec(newTestPCMCodec(), primaryType, 2)
+
+	var buf Buffer
+	enc := codec.EncodeRTP(NewSeqWriter(&buf).NewStream(redType))
+	for i := 1; i <= frames; i++ {
+		frame := make(media.PCM16Sample, DefPacketDur)
+		frame[0] = int16(i)
+		require.NoError(t, enc.WriteSample(frame))
+	}
+	require.Len(t, buf, frames)
+
+	// Each packet carries up to two previous frames.
+	typ, primary, blocks, err := ParseRED(buf[5].Payload)
+	require.NoError(t, err)
+	require.Equal(t, byte(primaryType), typ)
+	require.Equal(t, []byte{6}, primary)
+	require.Equal(t, []REDBlock{
+		{Type: primaryType, TimestampOffset: 2 * uint16(DefPacketDur), Payload: []byte{4}},
+		{Type: primaryType, TimestampOffset: uint16(DefPacketDur), Payload: []byte{5}},
+	}, blocks)
+
+	var got []int16
+	dec := codec.DecodeRTP(media.WriterFunc[media.PCM16Sample](func(in media.PCM16Sample) error {
+		got = append(got, in[0])
+		return nil
+	}), redType)
+
+	// Drop single packets, two packets in a row which can still be recovered, and three which cannot.
+	dropped := map[int]bool{3: true, 7: true, 8: true, 12: true, 13: true, 14: true}
+	for i, p := range buf {
+		if dropped[i] {
+			continue
+		}
+		require.NoError(t, dec.HandleRTP(p))
+	}
+	var exp []int16
+	for i := 1; i <= frames; i++ {
+		if i == 13 {
+			continue // lost together with both packets carrying its redundancy
+		}
+		exp = append(exp, int16(i))
+	}
+	require.Equal(t, exp, got)
+}
+
+func TestREDHandler(t *testing.T) {
+	const primaryType = 0
+	var got []*Packet
+	h := NewREDHandler(HandlerFunc(func(p *Packet) error {
+		got = append(got, p.Clone())
+		return nil
+	}), primaryType)
+
+	red := func(seq uint16, ts uint32, primary byte, blocks ...REDBlock) *Packet {
+		p := &Packet{Payload: AppendRED(nil, primaryType, []byte{primary}, blocks)}
+		p.SequenceNumber, p.Timestamp, p.PayloadType = seq, ts, 63
+		return p
+	}
+	require.NoError(t, h.HandleRTP(red(10, 1600, 10)))
+	// Redundancy of received packets is ignored.
+	require.NoError(t, h.HandleRTP(red(11, 1760, 11, REDBlock{Type: primaryType, TimestampOffset: 160, Payload: []byte{10}})))
+	// Packet 12 is lost, and recovered from the redundancy. Non-audio blocks are ignored.
+	require.NoError(t, h.HandleRTP(red(13, 2080, 13,
+		REDBlock{Type: 101, TimestampOffset: 320, Payload: []byte{0xff}},
+		REDBlock{Type: primaryType, TimestampOffset: 160, Payload: []byte{12}},
+	)))
+	// Reordered packet is passed through, the downstream handler decides what to do with it.
+	require.NoError(t, h.HandleRTP(red(12, 1920, 12)))
+
+	type pkt struct {
+		seq     uint16
+		ts      uint32
+		typ     uint8
+		payload byte
+	}
+	var out []pkt
+	for _, p := range got {
+		out = append(out, pkt{p.SequenceNumber, p.Timestamp, p.PayloadType, p.Payload[0]})
+	}
+	require.Equal(t, []pkt{
+		{10, 1600, primaryType, 10},
+		{11, 1760, primaryType, 11},
+		{12, 1920, primaryType, 12},
+		{13, 2080, primaryType, 13},
+		{12, 1920, primaryType, 12},
+	}, out)
+
+	require.Error(t, h.HandleRTP(&Packet{Payload: []byte{0x80}}))
+}
@@ -0,0 +1,213 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// DefRTCPInterval is the default interval between RTCP reports (RFC 3550, section 6.2).
+const DefRTCPInterval = 5 * time.Second
+
+type RTCPHandler interface {
+	HandleRTCP(pkts []rtcp.Packet) error
+}
+
+type RTCPHandlerFunc func(pkts []rtcp.Packet) error
+
+func (fnc RTCPHandlerFunc) HandleRTCP(pkts []rtcp.Packet) error {
+	return fnc(pkts)
+}
+
+type RTCPWriter interface {
+	WriteRTCP(pkts []rtcp.Packet) error
+}
+
+// IsRTCP checks if the packet is RTCP, when it's multiplexed with RTP on the same port (RFC 5761, section 4).
+func IsRTCP(data []byte) bool {
+	return len(data) >= 8 && data[0]>>6 == 2 && data[1] >= 192 && data[1] <= 223
+}
+
+// unixToNTP is the number of seconds between NTP epoch (1900) and Unix epoch (1970).
+const unixToNTP = 2208988800
+
+// ToNTP converts time to a 64 bit NTP timestamp.
+func ToNTP(t time.Time) uint64 {
+	sec := uint64(t.Unix()) + unixToNTP
+	frac := (uint64(t.Nanosecond()) << 32) / uint64(time.Second)
+	return sec<<32 | frac
+}
+
+// FromNTP converts a 64 bit NTP timestamp to time.
+func FromNTP(ntp uint64) time.Time {
+	sec := int64(ntp>>32) - unixToNTP
+	nsec := (int64(ntp&0xFFFFFFFF) * int64(time.Second)) >> 32
+	return time.Unix(sec, nsec)
+}
+
+// ntpShort returns middle 32 bits of the NTP timestamp, in 1/65536 of a second.
+func ntpShort(ntp uint64) uint32 {
+	return uint32(ntp >> 16)
+}
+
+func fromNTPShort(v uint32) time.Duration {
+	return time.Duration(uint64(v) * uint64(time.Second) >> 16)
+}
+
+// ReceptionStats describes the quality of a stream, as seen by the remote side.
+type ReceptionStats struct {
+	SSRC         uint32        // SSRC of the stream the report is about
+	FractionLost float64       // fraction of packets lost since the previous report, 0-1
+	TotalLost    uint32        // cumulative number of packets lost
+	Jitter       time.Duration // interarrival jitter
+	RTT          time.Duration // round-trip time; zero if unknown
+}
+
+// NewReceptionStats converts an RTCP reception report to stats. RTT can only be calculated
+// if the remote side received our sender report. The clock rate is used to convert jitter to time.
+func NewReceptionStats(r rtcp.ReceptionReport, clockRate int, now time.Time) ReceptionStats {
+	st := ReceptionStats{
+		SSRC:         r.SSRC,
+		FractionLost: float64(r.FractionLost) / 256,
+		TotalLost:    r.TotalLost,
+	}
+	if clockRate > 0 {
+		st.Jitter = time.Duration(uint64(r.Jitter) * uint64(time.Second) / uint64(clockRate))
+	}
+	if r.LastSenderReport != 0 {
+		// RFC 3550, section 6.4.1.
+		if rtt := ntpShort(ToNTP(now)) - r.LastSenderReport - r.Delay; rtt < 1<<31 {
+			st.RTT = fromNTPShort(rtt)
+		}
+	}
+	return st
+}
+
+// maxSenderClockStreams limits the number of remote streams tracked by SenderClock.
+const maxSenderClockStreams = 8
+
+// SenderClock maps RTP timestamps of remote streams to wall clock time, using NTP timestamps from sender reports.
+// Streams with different clocks (e.g. audio and video) can be synchronized by comparing their wall clock time.
+// Each stream is identified by its SSRC.
+type SenderClock struct {
+	mu      sync.Mutex
+	rate    int
+	streams map[uint32]senderRef
+	first   map[uint32]senderRef // first report of each stream, for drift estimation
+}
+
+// senderRef is the reference point from the last sender report of the stream.
+type senderRef struct {
+	ntp time.Time
+	rtp uint32
+}
+
+func NewSenderClock(clockRate int) *SenderClock {
+	return &SenderClock{rate: clockRate, streams: make(map[uint32]senderRef), first: make(map[uint32]senderRef)}
+}
+
+// Update the clock with a sender report. Reports for new streams are ignored once the limit of streams is reached.
+func (c *SenderClock) Update(sr *rtcp.SenderReport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.streams[sr.SSRC]; !ok && len(c.streams) >= maxSenderClockStreams {
+		return
+	}
+	ref := senderRef{ntp: FromNTP(sr.NTPTime), rtp: sr.RTPTime}
+	c.streams[sr.SSRC] = ref
+	if first, ok := c.first[sr.SSRC]; !ok {
+		c.first[sr.SSRC] = ref
+	} else if _, ok = driftPPM(first, ref, c.rate); !ok && ref.ntp.Sub(first.ntp) >= minDriftWindow {
+		// Timestamps jumped, e.g. the sender switched the source. Start over.
+		c.first[sr.SSRC] = ref
+	}
+}
+
+// Time returns wall clock time of the RTP timestamp of the stream.
+// It returns false if no sender reports were received for the stream yet.
+func (c *SenderClock) Time(ssrc, ts uint32) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ref, ok := c.streams[ssrc]
+	if !ok || c.rate <= 0 {
+		return time.Time{}, false
+	}
+	// Signed difference handles timestamp wraparound, as well as timestamps before the report.
+	diff := int64(int32(ts - ref.rtp))
+	return ref.ntp.Add(time.Duration(diff * int64(time.Second) / int64(c.rate))), true
+}
+
+// SenderReport returns an RTCP sender report for the stream at a given time.
+// The RTP timestamp is extrapolated from the last written packet, using the clock rate.
+func (s *SeqWriter) SenderReport(now time.Time, clockRate int) *rtcp.SenderReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts := s.p.Timestamp
+	if !s.last.IsZero() && clockRate > 0 {
+		ts += uint32(now.Sub(s.last) * time.Duration(clockRate) / time.Second)
+	}
+	return &rtcp.SenderReport{
+		SSRC:        s.p.SSRC,
+		NTPTime:     ToNTP(now),
+		RTPTime:     ts,
+		PacketCount: s.packets,
+		OctetCount:  s.octets,
+	}
+}
+
+// SenderReports starts sending RTCP sender reports for the stream. They allow the remote side to calculate RTT.
+// Returned function stops sending reports.
+func (s *SeqWriter) SenderReports(w RTCPWriter, clockRate int, interval time.Duration) (stop func()) {
+	return s.SenderReportsWith(w, clockRate, interval, nil)
+}
+
+// SenderReportsWith is like SenderReports, but adds packets returned by extra to each compound packet.
+// Extra is optional, and it may return no packets.
+func (s *SeqWriter) SenderReportsWith(w RTCPWriter, clockRate int, interval time.Duration, extra func(now time.Time) []rtcp.Packet) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	var (
+		done = make(chan struct{})
+		once sync.Once
+		wg   sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-t.C:
+				// Errors are ignored, reports are best-effort.
+				pkts := []rtcp.Packet{s.SenderReport(now, clockRate)}
+				if extra != nil {
+					pkts = append(pkts, extra(now)...)
+				}
+				_ = w.WriteRTCP(pkts)
+			}
+		}
+	}()
+	return func() {
+		once.Do(func() { close(done) })
+		wg.Wait()
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRTCP(t *testing.T) {
+	rr, err := (&rtcp.ReceiverReport{SSRC: 1}).Marshal()
+	require.NoError(t, err)
+	require.True(t, IsRTCP(rr))
+	sr, err := (&rtcp.SenderReport{SSRC: 1}).Marshal()
+	require.NoError(t, err)
+	require.True(t, IsRTCP(sr))
+
+	p, err := (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 0}, Payload: make([]byte, 160)}).Marshal()
+	require.NoError(t, err)
+	require.False(t, IsRTCP(p))
+	p, err = (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 101, Marker: true}, Payload: make([]byte, 4)}).Marshal()
+	require.NoError(t, err)
+	require.False(t, IsRTCP(p))
+
+	// RTP payload types 64-95 are reserved, since with the marker bit they overlap RTCP packet types (RFC 5761, section 4).
+	for _, typ := range []uint8{63, 96} {
+		p, err = (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: typ, Marker: true}, Payload: make([]byte, 4)}).Marshal()
+		require.NoError(t, err)
+		require.False(t, IsRTCP(p), "type %d", typ)
+	}
+	for _, typ := range []uint8{64, 95} {
+		p, err = (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: typ, Marker: true}, Payload: make([]byte, 4)}).Marshal()
+		require.NoError(t, err)
+		require.True(t, IsRTCP(p), "type %d", typ)
+	}
+}
+
+func TestNTP(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 15, 250_000_000, time.UTC)
+	ntp := ToNTP(now)
+	require.Equal(t, uint64(now.Unix()+unixToNTP), ntp>>32)
+	require.Equal(t, uint64(1<<30), ntp&0xFFFFFFFF) // 0.25 sec
+	require.WithinDuration(t, now, FromNTP(ntp), time.Microsecond)
+}
+
+func TestReceptionStats(t *testing.T) {
+	now := time.Now()
+	const rtt = 120 * time.Millisecond
+	const delay = 30 * time.Millisecond
+	st := NewReceptionStats(rtcp.ReceptionReport{
+		SSRC:             5000,
+		FractionLost:     64,
+		TotalLost:        10,
+		Jitter:           160,
+		LastSenderReport: ntpShort(ToNTP(now.Add(-rtt - delay))),
+		Delay:            uint32(delay * (1 << 16) / time.Second),
+	}, DefSampleRate, now)
+	require.Equal(t, uint32(5000), st.SSRC)
+	require.Equal(t, 0.25, st.FractionLost)
+	require.Equal(t, uint32(10), st.TotalLost)
+	require.Equal(t, 20*time.Millisecond, st.Jitter)
+	require.InDelta(t, rtt, st.RTT, float64(time.Millisecond))
+
+	// No sender report received by the remote.
+	st = NewReceptionStats(rtcp.ReceptionReport{Jitter: 80}, DefSampleRate, now)
+	require.Zero(t, st.RTT)
+	require.Equal(t, 10*time.Millisecond, st.Jitter)
+}
+
+func TestSenderClock(t *testing.T) {
+	const ssrc = 1234
+	c := NewSenderClock(DefSampleRate)
+	_, ok := c.Time(ssrc, 0)
+	require.False(t, ok)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var ts uint32 = 0xFFFFFF00 // close to wraparound
+	c.Update(&rtcp.SenderReport{SSRC: ssrc, NTPTime: ToNTP(now), RTPTime: ts})
+
+	got, ok := c.Time(ssrc, ts+DefSampleRate)
+	require.True(t, ok)
+	require.WithinDuration(t, now.Add(time.Second), got, time.Microsecond)
+
+	got, ok = c.Time(ssrc, ts-DefSampleRate/2)
+	require.True(t, ok)
+	require.WithinDuration(t, now.Add(-time.Second/2), got, time.Microsecond)
+
+	// Reports of other streams do not affect the stream.
+	c.Update(&rtcp.SenderReport{SSRC: ssrc + 1, NTPTime: ToNTP(now.Add(time.Hour)), RTPTime: 0})
+	got, ok = c.Time(ssrc, ts+DefSampleRate)
+	require.True(t, ok)
+	require.WithinDuration(t, now.Add(time.Second), got, time.Microsecond)
+	got, ok = c.Time(ssrc+1, DefSampleRate)
+	require.True(t, ok)
+	require.WithinDuration(t, now.Add(time.Hour+time.Second), got, time.Microsecond)
+
+	// Number of streams is limited.
+	for i := 0; i < 2*maxSenderClockStreams; i++ {
+		c.Update(&rtcp.SenderReport{SSRC: uint32(100 + i), NTPTime: ToNTP(now)})
+	}
+	require.Len(t, c.streams, maxSenderClockStreams)
+	_, ok = c.Time(ssrc, ts)
+	require.True(t, ok)
+}
+
+func TestSenderReport(t *testing.T) {
+	var buf Buffer
+	s := NewSeqWriter(&buf)
+	st := s.NewStream(0)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, st.WritePayload(make([]byte, 160), false))
+	}
+	now := time.Now()
+	sr := s.SenderReport(now, DefSampleRate)
+	require.Equal(t, uint32(5000), sr.SSRC)
+	require.Equal(t, uint32(3), sr.PacketCount)
+	require.Equal(t, uint32(3*160), sr.OctetCount)
+	require.Equal(t, ToNTP(now), sr.NTPTime)
+	require.GreaterOrEqual(t, sr.RTPTime, uint32(2*DefPacketDur))
+}
+
+func TestConnRTCP(t *testing.T) {
+	c := NewConn(nil)
+	require.NoError(t, c.ListenAndServe(30220, 30230, "127.0.0.1"))
+	t.Cleanup(func() { _ = c.Close() })
+
+	rtcpCh := make(chan []rtcp.Packet, 1)
+	rtpCh := make(chan *rtp.Packet, 1)
+	c.OnRTCP(RTCPHandlerFunc(func(pkts []rtcp.Packet) error {
+		rtcpCh <- pkts
+		return nil
+	}))
+	c.OnRTP(HandlerFunc(func(p *rtp.Packet) error {
+		rtpCh <- p
+		return nil
+	}))
+
+	peer, err := net.DialUDP("udp", nil, c.LocalAddr())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = peer.Close() })
+
+	rr := &rtcp.ReceiverReport{SSRC: 1, Reports: []rtcp.ReceptionReport{{SSRC: 5000, FractionLost: 10}}}
+	data, err := rr.Marshal()
+	require.NoError(t, err)
+	_, err = peer.Write(data)
+	require.NoError(t, err)
+	select {
+	case pkts := <-rtcpCh:
+		require.Len(t, pkts, 1)
+		got, ok := pkts[0].(*rtcp.ReceiverReport)
+		require.True(t, ok)
+		require.Equal(t, rr.SSRC, got.SSRC)
+		require.Equal(t, rr.Reports, got.Reports)
+	case <-time.After(time.Second):
+		t.Fatal("no RTCP packet")
+	}
+
+	data, err = (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 0, SequenceNumber: 1}, Payload: []byte{1}}).Marshal()
+	require.NoError(t, err)
+	_, err = peer.Write(data)
+	require.NoError(t, err)
+	select {
+	case p := <-rtpCh:
+		require.Equal(t, uint16(1), p.SequenceNumber)
+	case <-time.After(time.Second):
+		t.Fatal("no RTP packet")
+	}
+	require.Empty(t, rtcpCh)
+
+	// Reports are sent back to the peer.
+	require.NoError(t, c.WriteRTCP([]rtcp.Packet{&rtcp.SenderReport{SSRC: 5000}}))
+	buf := make([]byte, 1500)
+	require.NoError(t, peer.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := peer.Read(buf)
+	require.NoError(t, err)
+	pkts, err := rtcp.Unmarshal(buf[:n])
+	require.NoError(t, err)
+	require.Equal(t, []rtcp.Packet{&rtcp.SenderReport{SSRC: 5000}}, pkts)
+}
+
+func TestConnRTCPPort(t *testing.T) {
+	ports := NewPortPool(30240, 30250)
+	c := NewConn(nil)
+	require.NoError(t, c.ListenAndServePool(ports, "127.0.0.1"))
+	t.Cleanup(func() { _ = c.Close() })
+	require.NoError(t, c.ListenRTCP())
+	require.NoError(t, c.ListenRTCP())
+	_, err := ports.ListenUDPPort(c.LocalAddr().IP, c.LocalAddr().Port+1)
+	require.Error(t, err)
+
+	rtcpCh := make(chan []rtcp.Packet, 1)
+	c.OnRTCP(RTCPHandlerFunc(func(pkts []rtcp.Packet) error {
+		rtcpCh <- pkts
+		return nil
+	}))
+
+	peerRTP, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = peerRTP.Close() })
+	peerRTCP, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: peerRTP.LocalAddr().(*net.UDPAddr).Port + 1})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = peerRTCP.Close() })
+	c.SetDestAddr(peerRTP.LocalAddr().(*net.UDPAddr))
+
+	// Reports are sent to the next port after RTP.
+	require.NoError(t, c.WriteRTCP([]rtcp.Packet{&rtcp.SenderReport{SSRC: 5000}}))
+	buf := make([]byte, 1500)
+	require.NoError(t, peerRTCP.SetReadDeadline(time.Now().Add(time.Second)))
+	n, src, err := peerRTCP.ReadFromUDP(buf)
+	require.NoError(t, err)
+	require.Equal(t, c.LocalAddr().Port+1, src.Port)
+	pkts, err := rtcp.Unmarshal(buf[:n])
+	require.NoError(t, err)
+	require.Equal(t, []rtcp.Packet{&rtcp.SenderReport{SSRC: 5000}}, pkts)
+
+	// Reports from the peer are received on the RTCP port.
+	rr := &rtcp.ReceiverReport{SSRC: 1, Reports: []rtcp.ReceptionReport{{SSRC: 5000, FractionLost: 10}}}
+	data, err := rr.Marshal()
+	require.NoError(t, err)
+	_, err = peerRTCP.WriteToUDP(data, src)
+	require.NoError(t, err)
+	select {
+	case pkts := <-rtcpCh:
+		require.Len(t, pkts, 1)
+		require.Equal(t, rr.Reports, pkts[0].(*rtcp.ReceiverReport).Reports)
+	case <-time.After(time.Second):
+		t.Fatal("no RTCP packet")
+	}
+
+	// Both ports are returned to the pool.
+	require.NoError(t, c.Close())
+	require.Zero(t, ports.InUse())
+}
+
+func TestConnRTCPMux(t *testing.T) {
+	c := NewConn(nil)
+	require.NoError(t, c.ListenAndServe(30260, 30270, "127.0.0.1"))
+	t.Cleanup(func() { _ = c.Close() })
+
+	const n = 10
+	rtcpCh := make(chan []rtcp.Packet, n)
+	rtpCh := make(chan rtp.Packet, n)
+	c.OnRTCP(RTCPHandlerFunc(func(pkts []rtcp.Packet) error {
+		rtcpCh <- pkts
+		return nil
+	}))
+	c.OnRTP(HandlerFunc(func(p *rtp.Packet) error {
+		// The packet and its payload are reused by the connection.
+		rtpCh <- *p.Clone()
+		return nil
+	}))
+
+	peer, err := net.DialUDP("udp", nil, c.LocalAddr())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = peer.Close() })
+
+	// Interleave RTP, including dynamic payload types with the marker bit, and compound RTCP packets.
+	for i := 0; i < n; i++ {
+		data, err := (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 101, Marker: i%2 == 0, SequenceNumber: uint16(i)}, Payload: []byte{byte(i)}}).Marshal()
+		require.NoError(t, err)
+		_, err = peer.Write(data)
+		require.NoError(t, err)
+		data, err = rtcp.Marshal([]rtcp.Packet{
+			&rtcp.SenderReport{SSRC: 1, PacketCount: uint32(i)},
+			&rtcp.SourceDescription{Chunks: []rtcp.SourceDescriptionChunk{{Source: 1, Items: []rtcp.SourceDescriptionItem{{Type: rtcp.SDESCNAME, Text: "peer"}}}}},
+		})
+		require.NoError(t, err)
+		_, err = peer.Write(data)
+		require.NoError(t, err)
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case p := <-rtpCh:
+			require.Equal(t, uint16(i), p.SequenceNumber)
+			require.Equal(t, []byte{byte(i)}, p.Payload)
+		case <-time.After(time.Second):
+			t.Fatal("no RTP packet")
+		}
+		select {
+		case pkts := <-rtcpCh:
+			require.Len(t, pkts, 2)
+			require.Equal(t, uint32(i), pkts[0].(*rtcp.SenderReport).PacketCount)
+		case <-time.After(time.Second):
+			t.Fatal("no RTCP packet")
+		}
+	}
+	require.Empty(t, rtpCh)
+	require.Empty(t, rtcpCh)
+	require.Equal(t, uint64(n), c.packetCount.Load())
+}
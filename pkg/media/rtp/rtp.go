@@ -16,6 +16,7 @@ package rtp
 
 import (
 	"sync"
+	"time"
 
 	"github.com/pion/interceptor"
 	"github.com/pion/rtp"
@@ -83,35 +84,115 @@ type Event struct {
 	Timestamp uint32
 	Payload   []byte
 	Marker    bool
+
+	AudioLevelID uint8 // ID of the audio level header extension, or zero to send the packet without it
+	AudioLevel   AudioLevel
 }
 
 type SeqWriter struct {
-	mu sync.Mutex
-	w  Writer
-	p  Packet
+	mu      sync.Mutex
+	w       Writer
+	p       Packet
+	last    time.Time // last write
+	packets uint32    // sent packets, for sender reports
+	octets  uint32    // sent payload bytes, for sender reports
+	ext     [1]byte   // audio level extension payload
+}
+
+// SSRC returns the synchronization source of packets written by the writer.
+func (s *SeqWriter) SSRC() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.p.SSRC
 }
 
 func (s *SeqWriter) WriteEvent(ev *Event) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.writeEvent(ev)
+}
+
+func (s *SeqWriter) writeEvent(ev *Event) error {
+	s.last = time.Now()
 	s.p.PayloadType = ev.Type
 	s.p.Payload = ev.Payload
 	s.p.Marker = ev.Marker
 	s.p.Timestamp = ev.Timestamp
+	s.p.Extension = false
+	s.p.Extensions = s.p.Extensions[:0]
+	if ev.AudioLevelID != 0 {
+		s.ext[0] = ev.AudioLevel.byte()
+		if err := s.p.SetExtension(ev.AudioLevelID, s.ext[:]); err != nil {
+			return err
+		}
+	}
 	if err := s.w.WriteRTP(&s.p); err != nil {
 		return err
 	}
+	s.packets++
+	s.octets += uint32(len(s.p.Payload))
 	s.p.Header.SequenceNumber++
 	return nil
 }
 
+const (
+	// ComfortNoiseType is the static payload type of comfort noise (RFC 3389) for 8 kHz streams.
+	ComfortNoiseType = 13
+	// comfortNoiseLevel is the noise level of keepalive packets, in -dBov. It's the lowest level allowed by RFC 3389.
+	comfortNoiseLevel = 127
+)
+
+// KeepAlive starts sending comfort noise packets (RFC 3389) if nothing was written for the interval.
+// This keeps NAT port mappings alive while the stream is silent (RFC 6263, section 4.2).
+// Receivers which do not support comfort noise ignore the unknown payload type. Returned function stops sending keepalives.
+func (s *SeqWriter) KeepAlive(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	var (
+		done = make(chan struct{})
+		once sync.Once
+		wg   sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t := time.NewTimer(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+			t.Reset(s.keepAlive(interval))
+		}
+	}()
+	return func() {
+		once.Do(func() { close(done) })
+		wg.Wait()
+	}
+}
+
+// keepAlive sends a keepalive packet if the stream was silent for the interval. It returns a delay until the next check.
+func (s *SeqWriter) keepAlive(interval time.Duration) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if idle := time.Since(s.last); idle < interval {
+		return interval - idle
+	}
+	// Reuse the last timestamp, so that the receiver doesn't see a time jump. Errors are ignored, it's best-effort.
+	_ = s.writeEvent(&Event{Type: ComfortNoiseType, Timestamp: s.p.Timestamp, Payload: []byte{comfortNoiseLevel}})
+	return interval
+}
+
 // NewStream creates a new media stream in RTP and tracks timestamps associated with it.
 func (s *SeqWriter) NewStream(typ byte) *Stream {
 	return s.NewStreamWithDur(typ, DefPacketDur)
 }
 
 func (s *SeqWriter) NewStreamWithDur(typ byte, packetDur uint32) *Stream {
-	st := &Stream{s: s, packetDur: packetDur}
+	st := &Stream{s: s, packetDur: packetDur, clock: NewRTPTimestampClock(DefSampleRate, DefSampleRate)}
 	st.ev.Type = typ
 	return st
 }
@@ -121,24 +202,91 @@ type Stream struct {
 	packetDur uint32
 	mu        sync.Mutex
 	ev        Event
+	clock     *RTPTimestampClock
+	levelID   uint8       // audio level header extension ID
+	red       *redEncoder // set if payloads are sent as RED (RFC 2198)
+}
+
+// SetAudioLevelExtension adds the audio level header extension (RFC 6464) with a given ID to packets of the stream.
+// Levels are measured by encoders created with AudioCodec.EncodeRTP. Zero ID disables the extension.
+func (s *Stream) SetAudioLevelExtension(id uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.levelID = id
+}
+
+// setAudioLevel measures the audio level for the next packet, if the extension is enabled.
+func (s *Stream) setAudioLevel(sample media.PCM16Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.levelID == 0 {
+		return
+	}
+	s.ev.AudioLevelID = s.levelID
+	s.ev.AudioLevel = NewAudioLevel(sample)
+}
+
+// SetSampleRate sets the sample rate of the audio written with WritePayloadSamples.
+// Timestamps continue from the last packet.
+func (s *Stream) SetSampleRate(sampleRate int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts := s.clock.Timestamp()
+	s.clock = NewRTPTimestampClock(DefSampleRate, sampleRate)
+	s.clock.base = ts
 }
 
+// WritePayload writes the payload with a default packet duration.
 func (s *Stream) WritePayload(data []byte, marker bool) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.ev.Payload = data
-	s.ev.Marker = marker
-	if err := s.s.WriteEvent(&s.ev); err != nil {
+	if err := s.writePayload(data, marker); err != nil {
 		return err
 	}
-	s.ev.Timestamp += s.packetDur
+	s.clock.Skip(s.packetDur)
 	return nil
 }
 
+// WritePayloadSamples writes the payload which carries a given number of audio samples.
+// Timestamp of the next packet is derived from the number of samples, not from the wall clock.
+func (s *Stream) WritePayloadSamples(data []byte, samples int, marker bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.writePayload(data, marker); err != nil {
+		return err
+	}
+	s.clock.Advance(samples)
+	return nil
+}
+
+// skipSamples advances the timestamp by a number of audio samples, or by a default packet duration if not set.
+func (s *Stream) skipSamples(samples int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ev.AudioLevelID = 0
+	if samples <= 0 {
+		s.clock.Skip(s.packetDur)
+		return
+	}
+	s.clock.Advance(samples)
+}
+
+func (s *Stream) writePayload(data []byte, marker bool) error {
+	s.ev.Marker = marker
+	s.ev.Timestamp = s.clock.Timestamp()
+	if s.red != nil {
+		data = s.red.encode(s.ev.Timestamp, data)
+	}
+	s.ev.Payload = data
+	err := s.s.WriteEvent(&s.ev)
+	s.ev.AudioLevelID = 0 // only set for payloads with a measured level
+	return err
+}
+
 func (s *Stream) Delay(dur uint32) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.ev.Timestamp += dur
+	s.clock.Skip(dur)
 }
 
 func NewMediaStreamOut[T ~[]byte](s *Stream) *MediaStreamOut[T] {
@@ -146,21 +294,61 @@ func NewMediaStreamOut[T ~[]byte](s *Stream) *MediaStreamOut[T] {
 }
 
 type MediaStreamOut[T ~[]byte] struct {
-	s *Stream
+	s       *Stream
+	samples int // audio samples in the next payload; default packet duration is used if not set
 }
 
 func (s *MediaStreamOut[T]) WriteSample(sample T) error {
-	return s.s.WritePayload([]byte(sample), false)
+	n := s.samples
+	s.samples = 0
+	if n <= 0 {
+		return s.s.WritePayload([]byte(sample), false)
+	}
+	return s.s.WritePayloadSamples([]byte(sample), n, false)
+}
+
+// SkipSample advances the timestamp without sending the payload, e.g. for Opus DTX.
+func (s *MediaStreamOut[T]) SkipSample() {
+	n := s.samples
+	s.samples = 0
+	s.s.skipSamples(n)
+}
+
+// sampleCounter passes the number of samples in each frame to the encoded stream.
+type sampleCounter[T ~[]byte] struct {
+	enc media.PCM16Writer
+	out *MediaStreamOut[T]
+}
+
+func (w *sampleCounter[T]) WriteSample(sample media.PCM16Sample) error {
+	w.out.samples = len(sample)
+	w.out.s.setAudioLevel(sample)
+	return w.enc.WriteSample(sample)
 }
 
 func NewMediaStreamIn[T ~[]byte](w media.Writer[T]) *MediaStreamIn[T] {
 	return &MediaStreamIn[T]{w: w}
 }
 
+// maxConcealPackets is the max number of lost packets concealed at once.
+// Longer gaps (e.g. Opus DTX during silence) are mostly silent anyway.
+const maxConcealPackets = 5
+
 type MediaStreamIn[T ~[]byte] struct {
-	w media.Writer[T]
+	w   media.Writer[T]
+	seq SequenceTracker
 }
 
 func (s *MediaStreamIn[T]) HandleRTP(p *rtp.Packet) error {
+	// Reordered packets are still passed through, duplicates are dropped.
+	lost, duplicate, _ := s.seq.Process(p.SequenceNumber)
+	if duplicate {
+		return nil
+	}
+	if lc, ok := s.w.(media.LossConcealer); ok && lost > 0 {
+		if err := lc.ConcealLoss(min(lost, maxConcealPackets)); err != nil {
+			return err
+		}
+	}
 	return s.w.WriteSample(T(p.Payload))
 }
@@ -0,0 +1,140 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/audiotest"
+	"github.com/livekit/sip/pkg/media"
+)
+
+type concealWriter struct {
+	events []string
+}
+
+func (w *concealWriter) WriteSample(s []byte) error {
+	w.events = append(w.events, string(s))
+	return nil
+}
+
+func (w *concealWriter) ConcealLoss(n int) error {
+	for i := 0; i < n; i++ {
+		w.events = append(w.events, "plc")
+	}
+	return nil
+}
+
+func TestMediaStreamInConcealLoss(t *testing.T) {
+	var w concealWriter
+	s := NewMediaStreamIn[[]byte](&w)
+	for _, p := range []struct {
+		seq  uint16
+		data string
+	}{
+		{65534, "a"},
+		{65535, "b"},
+		{1, "c"},  // wraparound, one lost
+		{0, "x"},  // reordered
+		{1, "y"},  // duplicate, dropped
+		{4, "d"},  // two lost
+		{20, "e"}, // long gap (DTX), limited
+	} {
+		err := s.HandleRTP(&rtp.Packet{Header: rtp.Header{SequenceNumber: p.seq}, Payload: []byte(p.data)})
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{
+		"a", "b",
+		"plc", "c",
+		"x",
+		"plc", "plc", "d",
+		"plc", "plc", "plc", "plc", "plc", "e",
+	}, w.events)
+}
+
+type syncBuffer struct {
+	mu sync.Mutex
+	Buffer
+}
+
+func (b *syncBuffer) WriteRTP(p *Packet) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.Buffer.WriteRTP(p)
+}
+
+func TestKeepAlive(t *testing.T) {
+	const (
+		frameSize = 160
+		interval  = 20 * time.Millisecond
+	)
+	var buf syncBuffer
+	sw := NewSeqWriter(&buf)
+	st := sw.NewStream(0)
+	stop := sw.KeepAlive(interval)
+
+	signal := make(media.PCM16Sample, 10*frameSize)
+	waves := []audiotest.Wave{{Ind: 5, Amp: 5000}}
+	audiotest.GenSignal(signal, waves)
+	writeFrames := func(frames media.PCM16Sample) {
+		for i := 0; i < len(frames); i += frameSize {
+			data := make([]byte, 2*frameSize)
+			for j, v := range frames[i : i+frameSize] {
+				binary.LittleEndian.PutUint16(data[2*j:], uint16(v))
+			}
+			require.NoError(t, st.WritePayload(data, false))
+		}
+	}
+	writeFrames(signal[:len(signal)/2])
+	time.Sleep(3*interval + interval/2)
+	writeFrames(signal[len(signal)/2:])
+	stop()
+	stop() // no-op
+
+	var keepalives int
+	for i, p := range buf.Buffer {
+		require.Equal(t, uint16(i), p.SequenceNumber)
+		if p.PayloadType == ComfortNoiseType {
+			keepalives++
+			require.Equal(t, uint32(4*DefPacketDur), p.Timestamp)
+			require.Equal(t, []byte{comfortNoiseLevel}, p.Payload)
+		}
+	}
+	require.GreaterOrEqual(t, keepalives, 2)
+	require.LessOrEqual(t, keepalives, 4)
+
+	// Keepalive packets must not affect the received audio.
+	var recv []byte
+	mux := NewMux(nil)
+	mux.Register(0, NewMediaStreamIn[[]byte](media.WriterFunc[[]byte](func(data []byte) error {
+		recv = append(recv, data...)
+		return nil
+	})))
+	for _, p := range buf.Buffer {
+		require.NoError(t, mux.HandleRTP(p))
+	}
+	got := make(media.PCM16Sample, len(recv)/2)
+	for i := range got {
+		got[i] = int16(binary.LittleEndian.Uint16(recv[2*i:]))
+	}
+	require.Equal(t, signal, got)
+	require.Equal(t, waves, audiotest.FindSignal(got))
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+import "math/bits"
+
+// seqHistory is the number of recent sequence numbers remembered for duplicate and reorder detection.
+const seqHistory = 64
+
+// SequenceTracker tracks RTP sequence numbers of a single stream to detect lost, duplicate and reordered packets.
+// Sequence number wraparound is handled. Zero value is ready to use.
+type SequenceTracker struct {
+	started bool
+	last    uint16 // highest sequence number received
+	seen    uint64 // bit N is set if last-N was received
+	known   uint64 // bit N is set if last-N was sent after the first received packet
+	lost    uint64 // packets that left the history without being received
+}
+
+// Process records a sequence number of the received packet. It returns the number of packets missing
+// between the highest sequence number seen so far and this one, which can be concealed right away.
+// Missing packets may still arrive later, in which case they are reported as reordered. Use Lost for loss statistics.
+// Duplicate packets should be dropped by the caller.
+func (t *SequenceTracker) Process(seq uint16) (lost int, duplicate bool, reorder bool) {
+	if !t.started {
+		t.started = true
+		t.last = seq
+		t.seen = 1
+		t.known = 1
+		return 0, false, false
+	}
+	// Unsigned difference handles sequence number wraparound.
+	diff := seq - t.last
+	switch {
+	case diff == 0:
+		return 0, true, false
+	case diff < 0x8000:
+		t.advance(diff)
+		t.last = seq
+		return int(diff - 1), false, false
+	}
+	back := t.last - seq
+	if back >= seqHistory {
+		// Too old to know if it's a duplicate. It was already counted as lost.
+		return 0, false, true
+	}
+	bit := uint64(1) << back
+	if t.seen&bit != 0 {
+		return 0, true, false
+	}
+	t.seen |= bit
+	return 0, false, true
+}
+
+// advance moves the history forward by n packets. Missing packets which leave the history are counted as lost.
+func (t *SequenceTracker) advance(n uint16) {
+	if n >= seqHistory {
+		t.lost += uint64(bits.OnesCount64(t.known &^ t.seen))
+		// Missing packets which never fit into the history.
+		t.lost += uint64(n - seqHistory)
+		t.seen, t.known = 1, ^uint64(0)
+		return
+	}
+	leaving := ^uint64(0) << (seqHistory - n)
+	t.lost += uint64(bits.OnesCount64(t.known &^ t.seen & leaving))
+	t.seen = t.seen<<n | 1
+	t.known = t.known<<n | (1<<n - 1)
+}
+
+// Lost returns the number of packets which were not received, even out of order, within the history of the tracker.
+// Packets are counted once they are older than the last 64 packets, so recent gaps are not included yet.
+func (t *SequenceTracker) Lost() uint64 {
+	return t.lost
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequenceTracker(t *testing.T) {
+	type result struct {
+		lost      int
+		duplicate bool
+		reorder   bool
+	}
+	var s SequenceTracker
+	for _, c := range []struct {
+		seq uint16
+		exp result
+	}{
+		{65533, result{}},
+		{65534, result{}},
+		{1, result{lost: 2}},           // wraparound, 65535 and 0 lost
+		{65535, result{reorder: true}}, // late
+		{1, result{duplicate: true}},
+		{65535, result{duplicate: true}}, // late duplicate
+		{2, result{}},
+		{6, result{lost: 3}},
+		{4, result{reorder: true}},
+		{3, result{reorder: true}},
+		{4, result{duplicate: true}},
+		{200, result{lost: 193}},
+		{6, result{reorder: true}}, // too old to detect duplicates
+	} {
+		lost, dup, reorder := s.Process(c.seq)
+		require.Equal(t, c.exp, result{lost, dup, reorder}, "seq %d", c.seq)
+	}
+	// Late packets are not lost. Packets 0, 5 and 7-136 left the history without being received, 137-199 are still in it.
+	require.EqualValues(t, 2+130, s.Lost())
+}
+
+func TestSequenceTrackerLost(t *testing.T) {
+	var s SequenceTracker
+	s.Process(100)
+	s.Process(103) // 101 and 102 are missing
+	s.Process(101) // late
+	for seq := uint16(104); seq < 104+seqHistory; seq++ {
+		s.Process(seq)
+	}
+	require.EqualValues(t, 1, s.Lost())
+
+	// Gap longer than the history. Packets 168-999 are missing, but the last 63 of them are still in the history.
+	s.Process(1000)
+	require.EqualValues(t, 1+(1000-168)-(seqHistory-1), s.Lost())
+}
+
+func TestSequenceTrackerShuffled(t *testing.T) {
+	const (
+		n    = 1000
+		base = 65000 // wraps around
+	)
+	rnd := rand.New(rand.NewSource(1))
+	var seqs []uint16
+	for i := 0; i < n; i++ {
+		if i%50 == 10 {
+			continue // deliberate gap
+		}
+		seqs = append(seqs, uint16(base+i))
+		if i%100 == 20 {
+			seqs = append(seqs, uint16(base+i)) // duplicate
+		}
+	}
+	// Shuffle within small windows, like a network would do. The first packet must stay first.
+	for i := 1; i+4 <= len(seqs); i += 4 {
+		rnd.Shuffle(4, func(a, b int) {
+			seqs[i+a], seqs[i+b] = seqs[i+b], seqs[i+a]
+		})
+	}
+
+	var (
+		s                     SequenceTracker
+		lost, dups, reordered int
+	)
+	for _, seq := range seqs {
+		l, dup, reorder := s.Process(seq)
+		lost += l
+		if dup {
+			dups++
+		}
+		if reorder {
+			reordered++
+		}
+	}
+	// Each reordered packet was first reported as missing.
+	require.Equal(t, n/50, lost-reordered)
+	require.Equal(t, n/100, dups)
+	require.NotZero(t, reordered)
+	// The last gap is still in the history.
+	require.EqualValues(t, n/50-1, s.Lost())
+}
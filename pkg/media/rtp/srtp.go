@@ -0,0 +1,230 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/frostbyte73/core"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// Cipher encrypts and decrypts RTP and RTCP packets, for example with SRTP keys negotiated with DTLS.
+type Cipher interface {
+	EncryptRTP(dst, plaintext []byte, header *rtp.Header) ([]byte, error)
+	DecryptRTP(dst, encrypted []byte, header *rtp.Header) ([]byte, error)
+	EncryptRTCP(dst, plaintext []byte, header *rtcp.Header) ([]byte, error)
+	DecryptRTCP(dst, encrypted []byte, header *rtcp.Header) ([]byte, error)
+}
+
+// IsDTLS checks if the packet is DTLS, when it's multiplexed with RTP on the same port (RFC 7983, section 7).
+func IsDTLS(data []byte) bool {
+	return len(data) >= 13 && data[0] >= 20 && data[0] <= 63
+}
+
+// RequireCipher makes the connection drop all RTP and RTCP packets until the cipher is set.
+// It must be called before media is negotiated, if the connection is expected to be encrypted.
+func (c *Conn) RequireCipher() {
+	c.secure.Store(true)
+}
+
+// SetCipher sets the cipher for all following RTP and RTCP packets.
+func (c *Conn) SetCipher(ciph Cipher) {
+	if ciph == nil {
+		c.cipher.Store(nil)
+	} else {
+		c.cipher.Store(&ciph)
+	}
+}
+
+// DTLSConn returns a connection for DTLS packets multiplexed with RTP. Packets are sent to the current destination address.
+// DTLS packets received before the first call are dropped. A new connection is returned once the previous one is closed.
+func (c *Conn) DTLSConn() net.Conn {
+	for {
+		old := c.dtls.Load()
+		if old != nil && !old.closed.IsBroken() {
+			return old
+		}
+		d := &dtlsConn{c: c, recv: make(chan []byte, 16)}
+		if c.dtls.CompareAndSwap(old, d) {
+			return d
+		}
+	}
+}
+
+// decrypt decrypts the packet, if the cipher is set. It returns false if the packet must be dropped.
+func (c *Conn) decrypt(data []byte, isRTCP bool) ([]byte, bool) {
+	ciph := c.cipher.Load()
+	if ciph == nil {
+		return data, !c.secure.Load()
+	}
+	var err error
+	if isRTCP {
+		data, err = (*ciph).DecryptRTCP(c.decBuf[:0], data, nil)
+	} else {
+		data, err = (*ciph).DecryptRTP(c.decBuf[:0], data, nil)
+	}
+	return data, err == nil
+}
+
+// decryptRTCP decrypts RTCP received on the separate RTCP port into a given buffer, using the same cipher as RTP.
+func (c *Conn) decryptRTCP(buf, data []byte) ([]byte, bool) {
+	ciph := c.cipher.Load()
+	if ciph == nil {
+		return data, !c.secure.Load()
+	}
+	data, err := (*ciph).DecryptRTCP(buf[:0], data, nil)
+	return data, err == nil
+}
+
+// encrypt encrypts the packet, if the cipher is set. It returns false if the packet must be dropped.
+// Must be called with the write lock held.
+func (c *Conn) encrypt(data []byte, isRTCP bool) ([]byte, bool, error) {
+	ciph := c.cipher.Load()
+	if ciph == nil {
+		return data, !c.secure.Load(), nil
+	}
+	var err error
+	if isRTCP {
+		data, err = (*ciph).EncryptRTCP(c.encBuf[:0], data, nil)
+	} else {
+		data, err = (*ciph).EncryptRTP(c.encBuf[:0], data, nil)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// dtlsConn is a datagram connection for DTLS packets demultiplexed from RTP.
+type dtlsConn struct {
+	c      *Conn
+	recv   chan []byte
+	closed core.Fuse
+
+	mu       sync.Mutex
+	deadline time.Time
+	wake     chan struct{} // closed when the read deadline changes
+}
+
+// push queues the packet received by the RTP connection. Packets are dropped if the queue is full.
+func (d *dtlsConn) push(data []byte) {
+	select {
+	case d.recv <- append([]byte{}, data...):
+	default:
+	}
+}
+
+func (d *dtlsConn) Read(b []byte) (int, error) {
+	for {
+		d.mu.Lock()
+		deadline, wake := d.deadline, d.wake
+		if wake == nil {
+			wake = make(chan struct{})
+			d.wake = wake
+		}
+		d.mu.Unlock()
+
+		var (
+			timer   *time.Timer
+			timeout <-chan time.Time
+		)
+		if !deadline.IsZero() {
+			dt := time.Until(deadline)
+			if dt <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(dt)
+			timeout = timer.C
+		}
+		n, changed, err := d.wait(b, timeout, wake)
+		if timer != nil {
+			timer.Stop()
+		}
+		if !changed {
+			return n, err
+		}
+	}
+}
+
+// wait waits for a single packet. It returns changed=true if the read deadline was updated while waiting.
+func (d *dtlsConn) wait(b []byte, timeout <-chan time.Time, wake <-chan struct{}) (int, bool, error) {
+	select {
+	case data := <-d.recv:
+		return copy(b, data), false, nil
+	case <-d.closed.Watch():
+		return 0, false, net.ErrClosed
+	case <-d.c.closed.Watch():
+		return 0, false, net.ErrClosed
+	case <-timeout:
+		return 0, false, os.ErrDeadlineExceeded
+	case <-wake:
+		return 0, true, nil
+	}
+}
+
+func (d *dtlsConn) Write(b []byte) (int, error) {
+	if d.closed.IsBroken() {
+		return 0, net.ErrClosed
+	}
+	addr := d.c.dest.Load()
+	if addr == nil {
+		return len(b), nil
+	}
+	d.c.wmu.Lock()
+	defer d.c.wmu.Unlock()
+	return d.c.conn.WriteTo(b, addr)
+}
+
+func (d *dtlsConn) Close() error {
+	d.closed.Break()
+	return nil
+}
+
+func (d *dtlsConn) LocalAddr() net.Addr {
+	return d.c.LocalAddr()
+}
+
+func (d *dtlsConn) RemoteAddr() net.Addr {
+	if addr := d.c.DestAddr(); addr != nil {
+		return addr
+	}
+	return &net.UDPAddr{}
+}
+
+func (d *dtlsConn) SetDeadline(t time.Time) error {
+	return d.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for Read. It is used by DTLS to interrupt reads when the handshake is cancelled.
+func (d *dtlsConn) SetReadDeadline(t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.deadline = t
+	if d.wake != nil {
+		close(d.wake)
+		d.wake = nil
+	}
+	return nil
+}
+
+// SetWriteDeadline is a no-op, since writes never block.
+func (d *dtlsConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
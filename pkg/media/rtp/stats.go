@@ -0,0 +1,137 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+import (
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// CallStats are RTP statistics of a call, collected by Conn. They are logged when the call ends.
+type CallStats struct {
+	PacketsSent     uint64  `json:"packets_sent"`
+	PacketsReceived uint64  `json:"packets_received"`
+	BytesSent       uint64  `json:"bytes_sent"`
+	BytesReceived   uint64  `json:"bytes_received"`
+	DurationMs      int64   `json:"duration_ms"`  // from the first to the last RTP packet, sent or received
+	LostPackets     uint64  `json:"lost_packets"` // packets of received streams which never arrived
+	JitterMs        float64 `json:"jitter_ms"`    // interarrival jitter of the received stream (RFC 3550), see Conn.TrackJitter
+}
+
+// connStats collects CallStats of the connection.
+type connStats struct {
+	mu    sync.Mutex
+	first time.Time
+	last  time.Time
+
+	sent, sentBytes uint64
+	recv, recvBytes uint64
+
+	ssrc uint32
+	seq  SequenceTracker
+	lost uint64 // lost packets of previous streams
+
+	jitterType byte
+	clockRate  int
+	transit    int64   // relative transit time of the previous packet, in timestamp units
+	jitter     float64 // in timestamp units
+}
+
+func (s *connStats) touch(now time.Time) {
+	if s.first.IsZero() {
+		s.first = now
+	}
+	s.last = now
+}
+
+func (s *connStats) onSent(n int, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(now)
+	s.sent++
+	s.sentBytes += uint64(n)
+}
+
+func (s *connStats) onReceived(p *rtp.Packet, n int, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(now)
+	s.recv++
+	s.recvBytes += uint64(n)
+	if p.SSRC != s.ssrc {
+		// New stream, e.g. after re-INVITE. Losses of the previous one are final.
+		s.lost += s.seq.Missing()
+		s.seq = SequenceTracker{}
+		s.ssrc, s.transit, s.jitter = p.SSRC, 0, 0
+	}
+	s.seq.Process(p.SequenceNumber)
+	if s.clockRate <= 0 || p.PayloadType != s.jitterType {
+		return
+	}
+	// RFC 3550, appendix A.8.
+	arrival := now.UnixNano() * int64(s.clockRate) / int64(time.Second)
+	transit := arrival - int64(p.Timestamp)
+	if s.transit != 0 {
+		d := transit - s.transit
+		if d < 0 {
+			d = -d
+		}
+		s.jitter += (float64(d) - s.jitter) / 16
+	}
+	s.transit = transit
+}
+
+func (s *connStats) stats() CallStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := CallStats{
+		PacketsSent:     s.sent,
+		PacketsReceived: s.recv,
+		BytesSent:       s.sentBytes,
+		BytesReceived:   s.recvBytes,
+		DurationMs:      s.last.Sub(s.first).Milliseconds(),
+		LostPackets:     s.lost + s.seq.Missing(),
+	}
+	if s.clockRate > 0 {
+		st.JitterMs = s.jitter * 1000 / float64(s.clockRate)
+	}
+	return st
+}
+
+// Missing returns the number of packets which were not received, including recent gaps which may still be
+// filled by reordered packets. Unlike Lost, it's meant for final statistics, e.g. when the call ends.
+func (t *SequenceTracker) Missing() uint64 {
+	return t.lost + uint64(bits.OnesCount64(t.known&^t.seen))
+}
+
+// TrackJitter enables interarrival jitter calculation for received RTP packets of a given payload type,
+// which must use a given clock rate. Jitter is reported by Stats.
+func (c *Conn) TrackJitter(typ byte, clockRate int) {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+	c.stats.jitterType, c.stats.clockRate = typ, clockRate
+	c.stats.transit, c.stats.jitter = 0, 0
+}
+
+// Stats returns RTP statistics of the connection. Only RTP packets are counted, bytes are counted as sent on the wire.
+func (c *Conn) Stats() CallStats {
+	if c == nil {
+		return CallStats{}
+	}
+	return c.stats.stats()
+}
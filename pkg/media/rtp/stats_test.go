@@ -0,0 +1,99 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/media"
+)
+
+func TestConnStats(t *testing.T) {
+	const (
+		sent    = 50
+		payload = 160
+	)
+	newConn := func() *Conn {
+		c := NewConn(nil)
+		require.NoError(t, c.ListenAndServe(0, 0, "127.0.0.1"))
+		t.Cleanup(func() { _ = c.Close() })
+		return c
+	}
+	a, b := newConn(), newConn()
+	a.SetDestAddr(b.LocalAddr())
+	b.TrackJitter(0, DefSampleRate)
+	require.Equal(t, CallStats{}, b.Stats())
+
+	// Packets 10 and 20 are lost, and the stream is sent with a varying delay.
+	for i := 0; i < sent; i++ {
+		if i == 10 || i == 20 {
+			continue
+		}
+		p := &Packet{Payload: make([]byte, payload)}
+		p.Version, p.SSRC, p.SequenceNumber, p.Timestamp = 2, 0xABC, uint16(i), uint32(i)*DefPacketDur
+		require.NoError(t, a.WriteRTP(p))
+		time.Sleep(DefFrameDur + time.Duration(i%3)*time.Millisecond)
+	}
+	require.Eventually(t, func() bool {
+		return b.Stats().PacketsReceived == sent-2
+	}, time.Second, 10*time.Millisecond)
+
+	sst, rst := a.Stats(), b.Stats()
+	require.EqualValues(t, sent-2, sst.PacketsSent)
+	require.EqualValues(t, (sent-2)*(12+payload), sst.BytesSent)
+	require.Zero(t, sst.PacketsReceived)
+	require.Zero(t, sst.JitterMs)
+	require.Equal(t, sst.BytesSent, rst.BytesReceived)
+	require.EqualValues(t, 2, rst.LostPackets)
+	require.Greater(t, rst.JitterMs, 0.0)
+	require.Less(t, rst.JitterMs, 20.0)
+	require.GreaterOrEqual(t, rst.DurationMs, int64((sent-3)*DefFrameDur/time.Millisecond))
+
+	// A new stream doesn't reset the statistics.
+	p := &Packet{Payload: make([]byte, payload)}
+	p.Version, p.SSRC, p.SequenceNumber = 2, 0xDEF, 1000
+	require.NoError(t, a.WriteRTP(p))
+	require.Eventually(t, func() bool {
+		return b.Stats().PacketsReceived == sent-1
+	}, time.Second, 10*time.Millisecond)
+	require.EqualValues(t, 2, b.Stats().LostPackets)
+
+	var nilConn *Conn
+	require.Equal(t, CallStats{}, nilConn.Stats())
+}
+
+func TestCodecClockRate(t *testing.T) {
+	for name, exp := range map[string]int{
+		"PCMU/8000":    8000,
+		"G722/8000":    8000,
+		"opus/48000/2": 48000,
+		"test":         DefSampleRate,
+	} {
+		require.Equal(t, exp, CodecClockRate(newTestCodecInfo(name)), name)
+	}
+}
+
+type testCodecInfo media.CodecInfo
+
+func newTestCodecInfo(name string) media.Codec {
+	return testCodecInfo{SDPName: name}
+}
+
+func (c testCodecInfo) Info() media.CodecInfo {
+	return media.CodecInfo(c)
+}
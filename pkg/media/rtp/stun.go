@@ -0,0 +1,104 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtp
+
+import (
+	"net"
+	"time"
+
+	"github.com/frostbyte73/core"
+)
+
+// IsSTUN checks if the packet is STUN, when it's multiplexed with RTP on the same port (RFC 7983, section 7).
+func IsSTUN(data []byte) bool {
+	return len(data) >= 20 && data[0] <= 3
+}
+
+// STUNConn returns a packet connection for STUN packets multiplexed with RTP, used for ICE.
+// Unlike DTLS, STUN packets may come from servers other than the peer, thus they don't change the destination address.
+// STUN packets received before the first call are dropped. A new connection is returned once the previous one is closed.
+func (c *Conn) STUNConn() net.PacketConn {
+	for {
+		old := c.stun.Load()
+		if old != nil && !old.closed.IsBroken() {
+			return old
+		}
+		s := &stunConn{c: c, recv: make(chan stunPacket, 16)}
+		if c.stun.CompareAndSwap(old, s) {
+			return s
+		}
+	}
+}
+
+type stunPacket struct {
+	data []byte
+	addr *net.UDPAddr
+}
+
+// stunConn is a packet connection for STUN packets demultiplexed from RTP. Deadlines are not supported.
+type stunConn struct {
+	c      *Conn
+	recv   chan stunPacket
+	closed core.Fuse
+}
+
+// push queues the packet received by the RTP connection. Packets are dropped if the queue is full.
+func (s *stunConn) push(data []byte, addr *net.UDPAddr) {
+	select {
+	case s.recv <- stunPacket{data: append([]byte{}, data...), addr: addr}:
+	default:
+	}
+}
+
+func (s *stunConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case p := <-s.recv:
+		return copy(b, p.data), p.addr, nil
+	case <-s.closed.Watch():
+		return 0, nil, net.ErrClosed
+	case <-s.c.closed.Watch():
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (s *stunConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if s.closed.IsBroken() {
+		return 0, net.ErrClosed
+	}
+	s.c.wmu.Lock()
+	defer s.c.wmu.Unlock()
+	return s.c.conn.WriteTo(b, addr)
+}
+
+func (s *stunConn) Close() error {
+	s.closed.Break()
+	return nil
+}
+
+func (s *stunConn) LocalAddr() net.Addr {
+	return s.c.LocalAddr()
+}
+
+func (s *stunConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (s *stunConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (s *stunConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
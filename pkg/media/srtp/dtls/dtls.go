@@ -0,0 +1,228 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dtls implements SRTP key exchange over DTLS (DTLS-SRTP, RFC 5763 and RFC 5764).
+package dtls
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	piondtls "github.com/pion/dtls/v2"
+	"github.com/pion/dtls/v2/pkg/crypto/fingerprint"
+	"github.com/pion/dtls/v2/pkg/crypto/selfsign"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/srtp/v2"
+)
+
+const (
+	// SDPProto is the media transport protocol for DTLS-SRTP (RFC 5764).
+	SDPProto = "UDP/TLS/RTP/SAVP"
+
+	fingerprintAlgo = "sha-256"
+)
+
+var ErrFingerprintMismatch = errors.New("dtls: remote certificate does not match the fingerprint")
+
+// Role is the value of the SDP "setup" attribute (RFC 4145), which determines the side initiating the DTLS handshake.
+type Role string
+
+const (
+	RoleActive  = Role("active")  // acts as a DTLS client
+	RolePassive = Role("passive") // acts as a DTLS server
+	RoleActPass = Role("actpass") // can act as either; only valid in offers
+)
+
+// AnswerRole returns the role for the answer to the offer with a given role.
+// Answerer takes the active role when possible, as recommended by RFC 5763.
+func AnswerRole(offer Role) Role {
+	if offer == RoleActive {
+		return RolePassive
+	}
+	return RoleActive
+}
+
+// OffererRole returns the role of the offerer after receiving the answer with a given role.
+func OffererRole(answer Role) Role {
+	if answer == RolePassive {
+		return RoleActive
+	}
+	return RolePassive
+}
+
+// Certificate is a self-signed certificate used for the DTLS handshake. It is identified by its fingerprint in SDP.
+type Certificate struct {
+	cert        tls.Certificate
+	fingerprint string
+}
+
+// GenerateCertificate generates a new self-signed certificate.
+func GenerateCertificate() (*Certificate, error) {
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		return nil, err
+	}
+	x, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	fp, err := fingerprint.Fingerprint(x, crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	return &Certificate{cert: cert, fingerprint: fingerprintAlgo + " " + fp}, nil
+}
+
+// Fingerprint returns the value of the SDP "fingerprint" attribute for the certificate (RFC 8122), e.g. "sha-256 AB:CD:...".
+func (c *Certificate) Fingerprint() string {
+	return c.fingerprint
+}
+
+// verifyFingerprint checks that the certificate matches the fingerprint from SDP.
+func verifyFingerprint(rawCerts [][]byte, expected string) error {
+	algo, value, ok := strings.Cut(strings.TrimSpace(expected), " ")
+	if !ok || len(rawCerts) == 0 {
+		return ErrFingerprintMismatch
+	}
+	hash, err := fingerprint.HashFromString(algo)
+	if err != nil {
+		return fmt.Errorf("dtls: unsupported fingerprint: %w", err)
+	}
+	x, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	fp, err := fingerprint.Fingerprint(x, hash)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(fp, strings.TrimSpace(value)) {
+		return ErrFingerprintMismatch
+	}
+	return nil
+}
+
+var srtpProfiles = map[piondtls.SRTPProtectionProfile]srtp.ProtectionProfile{
+	piondtls.SRTP_AEAD_AES_128_GCM:       srtp.ProtectionProfileAeadAes128Gcm,
+	piondtls.SRTP_AES128_CM_HMAC_SHA1_80: srtp.ProtectionProfileAes128CmHmacSha1_80,
+}
+
+// Handshake performs a DTLS handshake over the connection and derives SRTP keys from it.
+// Role must be either active or passive. Remote certificate is verified against the fingerprint from SDP.
+func Handshake(ctx context.Context, conn net.Conn, cert *Certificate, role Role, remoteFingerprint string) (*Session, error) {
+	conf := &piondtls.Config{
+		Certificates: []tls.Certificate{cert.cert},
+		SRTPProtectionProfiles: []piondtls.SRTPProtectionProfile{
+			piondtls.SRTP_AEAD_AES_128_GCM,
+			piondtls.SRTP_AES128_CM_HMAC_SHA1_80,
+		},
+		// Certificates are self-signed, they are authenticated by the fingerprint instead.
+		InsecureSkipVerify: true,
+		ClientAuth:         piondtls.RequireAnyClientCert,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyFingerprint(rawCerts, remoteFingerprint)
+		},
+	}
+	var (
+		dconn *piondtls.Conn
+		err   error
+	)
+	switch role {
+	case RoleActive:
+		dconn, err = piondtls.ClientWithContext(ctx, conn, conf)
+	case RolePassive:
+		dconn, err = piondtls.ServerWithContext(ctx, conn, conf)
+	default:
+		return nil, fmt.Errorf("dtls: unexpected role: %q", role)
+	}
+	if err != nil {
+		// The connection is not usable after a failed handshake. Closing it also unblocks pending reads.
+		_ = conn.Close()
+		return nil, err
+	}
+	sess, err := newSession(dconn, role == RoleActive)
+	if err != nil {
+		_ = dconn.Close()
+		return nil, err
+	}
+	return sess, nil
+}
+
+// Session holds SRTP contexts negotiated with DTLS. It implements rtp.Cipher.
+//
+// Encryption and decryption are not safe for concurrent use, but encryption can run concurrently with decryption.
+type Session struct {
+	conn    *piondtls.Conn
+	profile srtp.ProtectionProfile
+	local   *srtp.Context
+	remote  *srtp.Context
+}
+
+func newSession(conn *piondtls.Conn, isClient bool) (*Session, error) {
+	dprof, ok := conn.SelectedSRTPProtectionProfile()
+	if !ok {
+		return nil, errors.New("dtls: no SRTP protection profile negotiated")
+	}
+	profile, ok := srtpProfiles[dprof]
+	if !ok {
+		return nil, fmt.Errorf("dtls: unsupported SRTP protection profile: %v", dprof)
+	}
+	conf := &srtp.Config{Profile: profile}
+	state := conn.ConnectionState()
+	if err := conf.ExtractSessionKeysFromDTLS(&state, isClient); err != nil {
+		return nil, err
+	}
+	local, err := srtp.CreateContext(conf.Keys.LocalMasterKey, conf.Keys.LocalMasterSalt, profile)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := srtp.CreateContext(conf.Keys.RemoteMasterKey, conf.Keys.RemoteMasterSalt, profile, srtp.SRTPReplayProtection(64), srtp.SRTCPReplayProtection(64))
+	if err != nil {
+		return nil, err
+	}
+	return &Session{conn: conn, profile: profile, local: local, remote: remote}, nil
+}
+
+// Profile returns the negotiated SRTP protection profile.
+func (s *Session) Profile() srtp.ProtectionProfile {
+	return s.profile
+}
+
+func (s *Session) EncryptRTP(dst, plaintext []byte, header *rtp.Header) ([]byte, error) {
+	return s.local.EncryptRTP(dst, plaintext, header)
+}
+
+func (s *Session) DecryptRTP(dst, encrypted []byte, header *rtp.Header) ([]byte, error) {
+	return s.remote.DecryptRTP(dst, encrypted, header)
+}
+
+func (s *Session) EncryptRTCP(dst, plaintext []byte, header *rtcp.Header) ([]byte, error) {
+	return s.local.EncryptRTCP(dst, plaintext, header)
+}
+
+func (s *Session) DecryptRTCP(dst, encrypted []byte, header *rtcp.Header) ([]byte, error) {
+	return s.remote.DecryptRTCP(dst, encrypted, header)
+}
+
+// Close sends DTLS close notification and closes the underlying connection.
+func (s *Session) Close() error {
+	return s.conn.Close()
+}
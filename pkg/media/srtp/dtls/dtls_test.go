@@ -0,0 +1,177 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dtls
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	prtp "github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/media/rtp"
+)
+
+func TestRoles(t *testing.T) {
+	require.Equal(t, RoleActive, AnswerRole(RoleActPass))
+	require.Equal(t, RoleActive, AnswerRole(RolePassive))
+	require.Equal(t, RolePassive, AnswerRole(RoleActive))
+
+	require.Equal(t, RolePassive, OffererRole(RoleActive))
+	require.Equal(t, RoleActive, OffererRole(RolePassive))
+}
+
+func TestFingerprint(t *testing.T) {
+	cert, err := GenerateCertificate()
+	require.NoError(t, err)
+	fp := cert.Fingerprint()
+	require.True(t, strings.HasPrefix(fp, "sha-256 "), fp)
+
+	raw := cert.cert.Certificate
+	require.NoError(t, verifyFingerprint(raw, fp))
+	require.NoError(t, verifyFingerprint(raw, strings.ToLower(fp)))
+	require.ErrorIs(t, verifyFingerprint(raw, "sha-256 00:11"), ErrFingerprintMismatch)
+	require.ErrorIs(t, verifyFingerprint(raw, ""), ErrFingerprintMismatch)
+	require.Error(t, verifyFingerprint(raw, "md2 00:11"))
+}
+
+func newTestConn(t *testing.T) *rtp.Conn {
+	c := rtp.NewConn(nil)
+	require.NoError(t, c.ListenAndServe(0, 0, "127.0.0.1"))
+	t.Cleanup(func() { _ = c.Close() })
+	c.RequireCipher()
+	return c
+}
+
+type testPeer struct {
+	conn *rtp.Conn
+	cert *Certificate
+	sess *Session
+	err  error
+}
+
+func handshakePair(t *testing.T, clientFingerprint, serverFingerprint func(client, server *testPeer) string) (client, server *testPeer) {
+	client, server = &testPeer{conn: newTestConn(t)}, &testPeer{conn: newTestConn(t)}
+	client.conn.SetDestAddr(server.conn.LocalAddr())
+	server.conn.SetDestAddr(client.conn.LocalAddr())
+	for _, p := range []*testPeer{client, server} {
+		var err error
+		p.cert, err = GenerateCertificate()
+		require.NoError(t, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.sess, server.err = Handshake(ctx, server.conn.DTLSConn(), server.cert, RolePassive, serverFingerprint(client, server))
+		if server.err != nil {
+			cancel()
+		}
+	}()
+	client.sess, client.err = Handshake(ctx, client.conn.DTLSConn(), client.cert, RoleActive, clientFingerprint(client, server))
+	if client.err != nil {
+		cancel()
+	}
+	<-done
+	for _, p := range []*testPeer{client, server} {
+		if p.sess != nil {
+			t.Cleanup(func() { _ = p.sess.Close() })
+		}
+	}
+	return client, server
+}
+
+func TestHandshake(t *testing.T) {
+	client, server := handshakePair(t,
+		func(client, server *testPeer) string { return server.cert.Fingerprint() },
+		func(client, server *testPeer) string { return client.cert.Fingerprint() },
+	)
+	require.NoError(t, client.err)
+	require.NoError(t, server.err)
+	require.Equal(t, client.sess.Profile(), server.sess.Profile())
+
+	// Check that packets are encrypted on the wire.
+	payload := bytes.Repeat([]byte{0xaa}, 160)
+	pkt := &prtp.Packet{
+		Header:  prtp.Header{Version: 2, PayloadType: 0, SequenceNumber: 1, Timestamp: 160, SSRC: 1234},
+		Payload: payload,
+	}
+	plain, err := pkt.Marshal()
+	require.NoError(t, err)
+	enc, err := client.sess.EncryptRTP(nil, plain, nil)
+	require.NoError(t, err)
+	require.NotContains(t, string(enc), string(payload))
+	dec, err := server.sess.DecryptRTP(nil, enc, nil)
+	require.NoError(t, err)
+	require.Equal(t, plain, dec)
+
+	// Audio sent by the RTP connection must be decrypted on the other side.
+	for _, p := range []*testPeer{client, server} {
+		p.conn.SetCipher(p.sess)
+	}
+	got := make(chan *prtp.Packet, 10)
+	server.conn.OnRTP(rtp.HandlerFunc(func(p *prtp.Packet) error {
+		got <- p.Clone()
+		return nil
+	}))
+	for i := 0; i < 5; i++ {
+		pkt.SequenceNumber++
+		pkt.Timestamp += 160
+		require.NoError(t, client.conn.WriteRTP(pkt))
+	}
+	select {
+	case p := <-got:
+		require.Equal(t, uint32(1234), p.SSRC)
+		require.Equal(t, payload, p.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("no audio received")
+	}
+}
+
+func TestHandshakeFingerprintMismatch(t *testing.T) {
+	other, err := GenerateCertificate()
+	require.NoError(t, err)
+	client, server := handshakePair(t,
+		func(client, server *testPeer) string { return other.Fingerprint() },
+		func(client, server *testPeer) string { return client.cert.Fingerprint() },
+	)
+	require.Error(t, client.err)
+	require.Nil(t, client.sess)
+	require.Error(t, server.err)
+}
+
+func TestDrop(t *testing.T) {
+	// Plain RTP is dropped while the cipher is not set.
+	a, b := newTestConn(t), rtp.NewConn(nil)
+	require.NoError(t, b.ListenAndServe(0, 0, "127.0.0.1"))
+	defer b.Close()
+	b.SetDestAddr(a.LocalAddr())
+	got := make(chan struct{}, 1)
+	a.OnRTP(rtp.HandlerFunc(func(p *prtp.Packet) error {
+		got <- struct{}{}
+		return nil
+	}))
+	require.NoError(t, b.WriteRTP(&prtp.Packet{Header: prtp.Header{Version: 2, SSRC: 1}, Payload: []byte{1}}))
+	select {
+	case <-got:
+		t.Fatal("unexpected plain RTP")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,641 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mikey implements SRTP key exchange with a pre-shared key using MIKEY (MIKEY-PSK, RFC 3830).
+//
+// The initiator sends a message with a random TEK generation key (TGK) encrypted with the pre-shared key,
+// and the responder answers with a verification message. Both messages are carried in SDP (RFC 4567).
+// Only the AES_CM_128_HMAC_SHA1_80 and AES_CM_128_HMAC_SHA1_32 SRTP profiles are supported.
+//
+// Two crypto sessions are negotiated: the first one protects media sent by the initiator,
+// and the second one protects media sent by the responder.
+package mikey
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pion/rtcp"
+	prtp "github.com/pion/rtp"
+	"github.com/pion/srtp/v2"
+
+	"github.com/livekit/sip/pkg/media/rtp"
+)
+
+const (
+	// SDPAttr is the SDP attribute carrying the MIKEY message (RFC 4567).
+	SDPAttr = "key-mgmt"
+	// sdpProtoID is the key management protocol identifier in the SDP attribute value.
+	sdpProtoID = "mikey"
+
+	// MinPSKLen is the minimal length of the pre-shared key.
+	MinPSKLen = 16
+	// MaxClockSkew is the maximal difference between the timestamp of the message and the local clock.
+	MaxClockSkew = 5 * time.Minute
+)
+
+var (
+	ErrAuthFailed = errors.New("mikey: message authentication failed")
+	ErrClockSkew  = errors.New("mikey: message timestamp is out of the allowed range")
+)
+
+// Protocol constants (RFC 3830, section 6).
+const (
+	version = 1
+
+	dataTypePSK    = 0 // initiator's pre-shared key message
+	dataTypePSKVer = 1 // verification message
+
+	prfMIKEY1   = 0
+	csIDMapSRTP = 0
+
+	payloadLast  = 0
+	payloadKEMAC = 1
+	payloadT     = 5
+	payloadV     = 9
+	payloadSP    = 10
+	payloadRAND  = 11
+
+	tsNTPUTC = 0
+
+	encrAESCM128 = 1
+	macHMACSHA1  = 1
+
+	keyTypeTGK = 0
+	protSRTP   = 0
+
+	tgkLen  = 16
+	randLen = 16
+	keyLen  = 16 // SRTP master key
+	saltLen = 14 // SRTP master salt and the salt protecting the key data
+	authLen = sha1.Size
+)
+
+// SRTP policy parameters (RFC 3830, section 6.10.1).
+const (
+	spEncrAlg    = 0
+	spEncrKeyLen = 1
+	spAuthAlg    = 2
+	spAuthKeyLen = 3
+	spSaltLen    = 4
+	spPRF        = 5
+	spKDR        = 6
+	spEncrSRTP   = 7
+	spEncrSRTCP  = 8
+	spFEC        = 9
+	spAuthSRTP   = 10
+	spAuthTagLen = 11
+	spPrefixLen  = 12
+)
+
+const (
+	csInitiator = 1
+	csResponder = 2
+	numCS       = 2
+	policyNo    = 0
+)
+
+// FormatSDP returns the value of the SDP "key-mgmt" attribute for a MIKEY message.
+func FormatSDP(msg []byte) string {
+	return sdpProtoID + " " + base64.StdEncoding.EncodeToString(msg)
+}
+
+// ParseSDP parses the value of the SDP "key-mgmt" attribute and returns the MIKEY message.
+func ParseSDP(value string) ([]byte, error) {
+	proto, data, ok := strings.Cut(strings.TrimSpace(value), " ")
+	if !ok || !strings.EqualFold(proto, sdpProtoID) {
+		return nil, fmt.Errorf("mikey: unsupported key management protocol: %q", proto)
+	}
+	msg, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+	if err != nil {
+		return nil, fmt.Errorf("mikey: invalid key-mgmt data: %w", err)
+	}
+	return msg, nil
+}
+
+type cryptoSession struct {
+	ssrc uint32
+	roc  uint32
+}
+
+type header struct {
+	dataType byte
+	verify   bool // V flag, response is expected
+	csbID    uint32
+	cs       [numCS]cryptoSession
+}
+
+// envelopeKeys protect the MIKEY message. They are derived from the pre-shared key.
+type envelopeKeys struct {
+	encr []byte
+	auth []byte
+	salt []byte
+}
+
+func newEnvelopeKeys(psk []byte, csbID uint32, rnd []byte) envelopeKeys {
+	return envelopeKeys{
+		encr: deriveKey(psk, labelEncr, csIDEnvelope, csbID, rnd, keyLen),
+		auth: deriveKey(psk, labelAuth, csIDEnvelope, csbID, rnd, authLen),
+		salt: deriveKey(psk, labelSalt, csIDEnvelope, csbID, rnd, saltLen),
+	}
+}
+
+func (k envelopeKeys) mac(data ...[]byte) []byte {
+	h := hmac.New(sha1.New, k.auth)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// crypt encrypts or decrypts the key data with AES in counter mode (RFC 3830, section 4.2.3).
+// The IV is (salt XOR (0x0000 || CSB ID || T)) || 0x0000.
+func (k envelopeKeys) crypt(data []byte, csbID uint32, ts uint64) ([]byte, error) {
+	block, err := aes.NewCipher(k.encr)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint32(iv[2:], csbID)
+	binary.BigEndian.PutUint64(iv[6:], ts)
+	for i, b := range k.salt {
+		iv[i] ^= b
+	}
+	out := make([]byte, len(data))
+	cipher.NewCTR(block, iv).XORKeyStream(out, data)
+	return out, nil
+}
+
+// message is a parsed MIKEY message with payloads used by the PSK mode.
+type message struct {
+	hdr     header
+	ts      uint64
+	rand    []byte
+	profile srtp.ProtectionProfile
+	keyData []byte // encrypted key data of KEMAC
+	mac     []byte // MAC of either KEMAC or V payload
+	macOff  int    // offset of the MAC in the message
+}
+
+func appendHeader(b []byte, h *header, next byte) []byte {
+	flags := byte(prfMIKEY1)
+	if h.verify {
+		flags |= 0x80
+	}
+	b = append(b, version, h.dataType, next, flags)
+	b = binary.BigEndian.AppendUint32(b, h.csbID)
+	b = append(b, numCS, csIDMapSRTP)
+	for _, cs := range h.cs {
+		b = append(b, policyNo)
+		b = binary.BigEndian.AppendUint32(b, cs.ssrc)
+		b = binary.BigEndian.AppendUint32(b, cs.roc)
+	}
+	return b
+}
+
+func appendTimestamp(b []byte, ts uint64, next byte) []byte {
+	b = append(b, next, tsNTPUTC)
+	return binary.BigEndian.AppendUint64(b, ts)
+}
+
+func appendPolicy(b []byte, profile srtp.ProtectionProfile, next byte) []byte {
+	tagLen := byte(10)
+	if profile == srtp.ProtectionProfileAes128CmHmacSha1_32 {
+		tagLen = 4
+	}
+	params := []byte{
+		spEncrAlg, 1, encrAESCM128,
+		spEncrKeyLen, 1, keyLen,
+		spAuthAlg, 1, macHMACSHA1,
+		spAuthKeyLen, 1, authLen,
+		spSaltLen, 1, saltLen,
+		spPRF, 1, 0, // AES-CM
+		spEncrSRTP, 1, 1,
+		spEncrSRTCP, 1, 1,
+		spAuthSRTP, 1, 1,
+		spAuthTagLen, 1, tagLen,
+	}
+	b = append(b, next, policyNo, protSRTP)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(params)))
+	return append(b, params...)
+}
+
+// parsePolicy checks SRTP policy parameters and returns the matching protection profile.
+// Parameters which are not set take default values from RFC 3830, section 6.10.1.
+func parsePolicy(params []byte) (srtp.ProtectionProfile, error) {
+	profile := srtp.ProtectionProfileAes128CmHmacSha1_80
+	for len(params) > 0 {
+		if len(params) < 2 || len(params) < 2+int(params[1]) {
+			return 0, errors.New("mikey: truncated security policy")
+		}
+		typ, val := params[0], params[2:2+int(params[1])]
+		params = params[2+len(val):]
+		if typ == spKDR || typ == spPrefixLen {
+			if len(val) > 0 && val[0] != 0 {
+				return 0, fmt.Errorf("mikey: unsupported security policy parameter %d: %x", typ, val)
+			}
+			continue
+		}
+		if len(val) != 1 {
+			return 0, fmt.Errorf("mikey: invalid security policy parameter %d: %x", typ, val)
+		}
+		ok := true
+		switch v := val[0]; typ {
+		case spEncrAlg:
+			ok = v == encrAESCM128
+		case spEncrKeyLen:
+			ok = v == keyLen
+		case spAuthAlg:
+			ok = v == macHMACSHA1
+		case spAuthKeyLen:
+			ok = v == authLen
+		case spSaltLen:
+			ok = v == saltLen
+		case spPRF, spFEC:
+			ok = v == 0
+		case spEncrSRTP, spEncrSRTCP, spAuthSRTP:
+			ok = v == 1
+		case spAuthTagLen:
+			switch v {
+			case 10:
+				profile = srtp.ProtectionProfileAes128CmHmacSha1_80
+			case 4:
+				profile = srtp.ProtectionProfileAes128CmHmacSha1_32
+			default:
+				ok = false
+			}
+		}
+		if !ok {
+			return 0, fmt.Errorf("mikey: unsupported security policy parameter %d: %d", typ, val[0])
+		}
+	}
+	return profile, nil
+}
+
+var errTruncated = errors.New("mikey: truncated message")
+
+// parseMessage parses the MIKEY message. It doesn't verify the MAC.
+func parseMessage(raw []byte) (*message, error) {
+	m := &message{}
+	if len(raw) < 10 {
+		return nil, errTruncated
+	}
+	if raw[0] != version {
+		return nil, fmt.Errorf("mikey: unsupported version: %d", raw[0])
+	}
+	m.hdr.dataType = raw[1]
+	next := raw[2]
+	m.hdr.verify = raw[3]&0x80 != 0
+	if prf := raw[3] & 0x7f; prf != prfMIKEY1 {
+		return nil, fmt.Errorf("mikey: unsupported PRF: %d", prf)
+	}
+	m.hdr.csbID = binary.BigEndian.Uint32(raw[4:])
+	if raw[8] != numCS || raw[9] != csIDMapSRTP {
+		return nil, fmt.Errorf("mikey: unsupported crypto sessions: %d, map type %d", raw[8], raw[9])
+	}
+	b := raw[10:]
+	if len(b) < numCS*9 {
+		return nil, errTruncated
+	}
+	for i := range m.hdr.cs {
+		if b[0] != policyNo {
+			return nil, fmt.Errorf("mikey: unknown security policy: %d", b[0])
+		}
+		m.hdr.cs[i] = cryptoSession{
+			ssrc: binary.BigEndian.Uint32(b[1:]),
+			roc:  binary.BigEndian.Uint32(b[5:]),
+		}
+		b = b[9:]
+	}
+	hasPolicy := false
+	for next != payloadLast {
+		if len(b) < 1 {
+			return nil, errTruncated
+		}
+		typ := next
+		next = b[0]
+		switch typ {
+		case payloadT:
+			if len(b) < 10 {
+				return nil, errTruncated
+			}
+			if b[1] != tsNTPUTC {
+				return nil, fmt.Errorf("mikey: unsupported timestamp type: %d", b[1])
+			}
+			m.ts = binary.BigEndian.Uint64(b[2:])
+			b = b[10:]
+		case payloadRAND:
+			if len(b) < 2 || len(b) < 2+int(b[1]) {
+				return nil, errTruncated
+			}
+			m.rand = b[2 : 2+int(b[1])]
+			b = b[2+len(m.rand):]
+		case payloadSP:
+			if len(b) < 5 {
+				return nil, errTruncated
+			}
+			if b[1] != policyNo || b[2] != protSRTP {
+				return nil, fmt.Errorf("mikey: unsupported security policy: %d, protocol %d", b[1], b[2])
+			}
+			n := int(binary.BigEndian.Uint16(b[3:]))
+			if len(b) < 5+n {
+				return nil, errTruncated
+			}
+			profile, err := parsePolicy(b[5 : 5+n])
+			if err != nil {
+				return nil, err
+			}
+			m.profile, hasPolicy = profile, true
+			b = b[5+n:]
+		case payloadKEMAC:
+			if next != payloadLast {
+				return nil, errors.New("mikey: KEMAC must be the last payload")
+			}
+			if len(b) < 4 {
+				return nil, errTruncated
+			}
+			if b[1] != encrAESCM128 {
+				return nil, fmt.Errorf("mikey: unsupported encryption algorithm: %d", b[1])
+			}
+			n := int(binary.BigEndian.Uint16(b[2:]))
+			if len(b) < 4+n+1 {
+				return nil, errTruncated
+			}
+			m.keyData = b[4 : 4+n]
+			b = b[4+n:]
+			if b[0] != macHMACSHA1 {
+				return nil, fmt.Errorf("mikey: unsupported MAC algorithm: %d", b[0])
+			}
+			m.macOff = len(raw) - len(b) + 1
+			m.mac, b = b[1:], nil
+		case payloadV:
+			if next != payloadLast {
+				return nil, errors.New("mikey: V must be the last payload")
+			}
+			if len(b) < 2 {
+				return nil, errTruncated
+			}
+			if b[1] != macHMACSHA1 {
+				return nil, fmt.Errorf("mikey: unsupported MAC algorithm: %d", b[1])
+			}
+			m.macOff = len(raw) - len(b) + 2
+			m.mac, b = b[2:], nil
+		default:
+			return nil, fmt.Errorf("mikey: unsupported payload: %d", typ)
+		}
+	}
+	if len(m.mac) != authLen {
+		return nil, errors.New("mikey: no valid MAC in the message")
+	}
+	if !hasPolicy {
+		m.profile = srtp.ProtectionProfileAes128CmHmacSha1_80
+	}
+	return m, nil
+}
+
+func checkTimestamp(ts uint64) error {
+	if d := time.Since(rtp.FromNTP(ts)); d > MaxClockSkew || d < -MaxClockSkew {
+		return ErrClockSkew
+	}
+	return nil
+}
+
+// Offer is the initiator of the MIKEY-PSK exchange.
+type Offer struct {
+	psk  []byte
+	tgk  []byte
+	hdr  header
+	ts   uint64
+	rand []byte
+	keys envelopeKeys
+	prof srtp.ProtectionProfile
+	msg  []byte
+}
+
+// NewOffer generates a new TGK and creates the initiator's message for media sent with a given SSRC.
+func NewOffer(psk []byte, ssrc uint32) (*Offer, error) {
+	return newOffer(psk, ssrc, srtp.ProtectionProfileAes128CmHmacSha1_80, time.Now())
+}
+
+func newOffer(psk []byte, ssrc uint32, profile srtp.ProtectionProfile, now time.Time) (*Offer, error) {
+	if len(psk) < MinPSKLen {
+		return nil, fmt.Errorf("mikey: pre-shared key must be at least %d bytes", MinPSKLen)
+	}
+	o := &Offer{
+		psk:  bytes.Clone(psk),
+		tgk:  make([]byte, tgkLen),
+		rand: make([]byte, randLen),
+		ts:   rtp.ToNTP(now),
+		prof: profile,
+	}
+	var csb [4]byte
+	for _, b := range [][]byte{o.tgk, o.rand, csb[:]} {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+	}
+	o.hdr = header{
+		dataType: dataTypePSK,
+		verify:   true,
+		csbID:    binary.BigEndian.Uint32(csb[:]),
+	}
+	o.hdr.cs[csInitiator-1].ssrc = ssrc
+	o.keys = newEnvelopeKeys(o.psk, o.hdr.csbID, o.rand)
+
+	keyData := []byte{payloadLast, keyTypeTGK << 4}
+	keyData = binary.BigEndian.AppendUint16(keyData, tgkLen)
+	keyData = append(keyData, o.tgk...)
+	encKeyData, err := o.keys.crypt(keyData, o.hdr.csbID, o.ts)
+	if err != nil {
+		return nil, err
+	}
+
+	b := appendHeader(nil, &o.hdr, payloadT)
+	b = appendTimestamp(b, o.ts, payloadRAND)
+	b = append(b, payloadSP, randLen)
+	b = append(b, o.rand...)
+	b = appendPolicy(b, o.prof, payloadKEMAC)
+	b = append(b, payloadLast, encrAESCM128)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(encKeyData)))
+	b = append(b, encKeyData...)
+	b = append(b, macHMACSHA1)
+	o.msg = append(b, o.keys.mac(b)...)
+	return o, nil
+}
+
+// Message returns the initiator's message, which should be sent to the responder.
+func (o *Offer) Message() []byte {
+	return o.msg
+}
+
+// Accept verifies the responder's message and creates the SRTP session.
+func (o *Offer) Accept(resp []byte) (*Session, error) {
+	m, err := parseMessage(resp)
+	if err != nil {
+		return nil, err
+	}
+	if m.hdr.dataType != dataTypePSKVer {
+		return nil, fmt.Errorf("mikey: unexpected message type: %d", m.hdr.dataType)
+	}
+	if m.hdr.csbID != o.hdr.csbID {
+		return nil, fmt.Errorf("mikey: unexpected CSB ID: %08x", m.hdr.csbID)
+	}
+	var tsi [8]byte
+	binary.BigEndian.PutUint64(tsi[:], o.ts)
+	if !hmac.Equal(m.mac, o.keys.mac(resp[:m.macOff], tsi[:])) {
+		return nil, ErrAuthFailed
+	}
+	if err = checkTimestamp(m.ts); err != nil {
+		return nil, err
+	}
+	return newSession(o.tgk, o.hdr.csbID, o.rand, o.prof, csInitiator, csResponder, m.hdr.cs)
+}
+
+// Answer verifies the initiator's message and returns the SRTP session along with the verification message,
+// which should be sent back to the initiator. The SSRC is used for media sent by the responder.
+func Answer(psk, offer []byte, ssrc uint32) (*Session, []byte, error) {
+	return answer(psk, offer, ssrc, time.Now())
+}
+
+func answer(psk, offer []byte, ssrc uint32, now time.Time) (*Session, []byte, error) {
+	m, err := parseMessage(offer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.hdr.dataType != dataTypePSK {
+		return nil, nil, fmt.Errorf("mikey: unexpected message type: %d", m.hdr.dataType)
+	}
+	if m.keyData == nil {
+		return nil, nil, errors.New("mikey: no KEMAC payload")
+	}
+	if len(m.rand) < randLen {
+		return nil, nil, errors.New("mikey: no valid RAND payload")
+	}
+	keys := newEnvelopeKeys(psk, m.hdr.csbID, m.rand)
+	if !hmac.Equal(m.mac, keys.mac(offer[:m.macOff])) {
+		return nil, nil, ErrAuthFailed
+	}
+	if err = checkTimestamp(m.ts); err != nil {
+		return nil, nil, err
+	}
+	keyData, err := keys.crypt(m.keyData, m.hdr.csbID, m.ts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(keyData) < 4 || keyData[1]>>4 != keyTypeTGK || keyData[1]&0xf != 0 {
+		return nil, nil, errors.New("mikey: unsupported key data")
+	}
+	n := int(binary.BigEndian.Uint16(keyData[2:]))
+	if n < tgkLen || len(keyData) < 4+n {
+		return nil, nil, errors.New("mikey: invalid TGK")
+	}
+	tgk := keyData[4 : 4+n]
+
+	hdr := m.hdr
+	hdr.dataType = dataTypePSKVer
+	hdr.verify = false
+	hdr.cs[csResponder-1].ssrc = ssrc
+
+	b := appendHeader(nil, &hdr, payloadT)
+	b = appendTimestamp(b, rtp.ToNTP(now), payloadV)
+	b = append(b, payloadLast, macHMACSHA1)
+	var tsi [8]byte
+	binary.BigEndian.PutUint64(tsi[:], m.ts)
+	b = append(b, keys.mac(b, tsi[:])...)
+
+	sess, err := newSession(tgk, hdr.csbID, m.rand, m.profile, csResponder, csInitiator, hdr.cs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sess, b, nil
+}
+
+// Session holds SRTP contexts negotiated with MIKEY. It implements rtp.Cipher.
+//
+// Encryption and decryption are not safe for concurrent use, but encryption can run concurrently with decryption.
+type Session struct {
+	profile    srtp.ProtectionProfile
+	remoteSSRC uint32
+	localKey   []byte // master key and salt
+	remoteKey  []byte
+	local      *srtp.Context
+	remote     *srtp.Context
+}
+
+func newSession(tgk []byte, csbID uint32, rnd []byte, profile srtp.ProtectionProfile, localCS, remoteCS byte, cs [numCS]cryptoSession) (*Session, error) {
+	s := &Session{
+		profile:    profile,
+		remoteSSRC: cs[remoteCS-1].ssrc,
+	}
+	var err error
+	s.localKey, s.local, err = newContext(tgk, csbID, rnd, profile, localCS, cs[localCS-1])
+	if err != nil {
+		return nil, err
+	}
+	s.remoteKey, s.remote, err = newContext(tgk, csbID, rnd, profile, remoteCS, cs[remoteCS-1], srtp.SRTPReplayProtection(64), srtp.SRTCPReplayProtection(64))
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newContext derives the SRTP master key and salt of the crypto session from the TGK (RFC 3830, section 4.1.3).
+func newContext(tgk []byte, csbID uint32, rnd []byte, profile srtp.ProtectionProfile, csID byte, cs cryptoSession, opts ...srtp.ContextOption) ([]byte, *srtp.Context, error) {
+	key := deriveKey(tgk, labelTEK, csID, csbID, rnd, keyLen)
+	salt := deriveKey(tgk, labelSalt, csID, csbID, rnd, saltLen)
+	ctx, err := srtp.CreateContext(key, salt, profile, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cs.ssrc != 0 {
+		ctx.SetROC(cs.ssrc, cs.roc)
+	}
+	return append(key, salt...), ctx, nil
+}
+
+// Profile returns the negotiated SRTP protection profile.
+func (s *Session) Profile() srtp.ProtectionProfile {
+	return s.profile
+}
+
+// RemoteSSRC returns the SSRC of the remote media stream, if it was set in the exchange.
+func (s *Session) RemoteSSRC() uint32 {
+	return s.remoteSSRC
+}
+
+func (s *Session) EncryptRTP(dst, plaintext []byte, header *prtp.Header) ([]byte, error) {
+	return s.local.EncryptRTP(dst, plaintext, header)
+}
+
+func (s *Session) DecryptRTP(dst, encrypted []byte, header *prtp.Header) ([]byte, error) {
+	return s.remote.DecryptRTP(dst, encrypted, header)
+}
+
+func (s *Session) EncryptRTCP(dst, plaintext []byte, header *rtcp.Header) ([]byte, error) {
+	return s.local.EncryptRTCP(dst, plaintext, header)
+}
+
+func (s *Session) DecryptRTCP(dst, encrypted []byte, header *rtcp.Header) ([]byte, error) {
+	return s.remote.DecryptRTCP(dst, encrypted, header)
+}
@@ -0,0 +1,208 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mikey
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"testing"
+	"time"
+
+	prtp "github.com/pion/rtp"
+	"github.com/pion/srtp/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/media/rtp"
+)
+
+var testPSK = []byte("0123456789abcdef0123456789abcdef0123")
+
+func hmacSHA1(key []byte, data ...[]byte) []byte {
+	h := hmac.New(sha1.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// TestPRF checks the PRF against its definition in RFC 3830, section 4.1.2, since the RFC has no test vectors.
+func TestPRF(t *testing.T) {
+	label := []byte{0x2A, 0xD0, 0x1C, 0x64, 0x01, 0xDE, 0xAD, 0xBE, 0xEF, 0x01, 0x02, 0x03}
+	// Keys shorter than 256 bits, exactly 256 bits, and split into a full and a partial block.
+	for _, klen := range []int{16, 32, 45} {
+		key := make([]byte, klen)
+		for i := range key {
+			key[i] = byte(i*7 + 1)
+		}
+		for _, n := range []int{14, 16, 20, 30, 41} {
+			m := (n + 19) / 20
+			exp := make([]byte, m*20)
+			for off := 0; off < klen; off += 32 {
+				s := key[off:min(off+32, klen)]
+				a := label
+				for i := 0; i < m; i++ {
+					a = hmacSHA1(s, a)
+					for j, b := range hmacSHA1(s, a, label) {
+						exp[i*20+j] ^= b
+					}
+				}
+			}
+			require.Equal(t, exp[:n], prf(key, label, n), "key %d, out %d", klen, n)
+		}
+	}
+	// The label is constant || cs_id || CSB ID || RAND.
+	rnd := []byte{0x01, 0x02, 0x03}
+	require.Equal(t, prf(testPSK, label, 16), deriveKey(testPSK, labelTEK, 1, 0xDEADBEEF, rnd, 16))
+}
+
+func exchange(t *testing.T, profile srtp.ProtectionProfile) (initiator, responder *Session) {
+	o, err := newOffer(testPSK, 1111, profile, time.Now())
+	require.NoError(t, err)
+	// The message must survive the SDP encoding.
+	msg, err := ParseSDP(FormatSDP(o.Message()))
+	require.NoError(t, err)
+	responder, resp, err := Answer(testPSK, msg, 2222)
+	require.NoError(t, err)
+	initiator, err = o.Accept(resp)
+	require.NoError(t, err)
+	return initiator, responder
+}
+
+func TestExchange(t *testing.T) {
+	for _, profile := range []srtp.ProtectionProfile{
+		srtp.ProtectionProfileAes128CmHmacSha1_80,
+		srtp.ProtectionProfileAes128CmHmacSha1_32,
+	} {
+		initiator, responder := exchange(t, profile)
+		require.Equal(t, profile, initiator.Profile())
+		require.Equal(t, profile, responder.Profile())
+		require.EqualValues(t, 2222, initiator.RemoteSSRC())
+		require.EqualValues(t, 1111, responder.RemoteSSRC())
+
+		require.Len(t, initiator.localKey, keyLen+saltLen)
+		require.Equal(t, initiator.localKey, responder.remoteKey)
+		require.Equal(t, initiator.remoteKey, responder.localKey)
+		require.NotEqual(t, initiator.localKey, initiator.remoteKey)
+	}
+}
+
+func TestExchangeFailure(t *testing.T) {
+	o, err := NewOffer(testPSK, 1)
+	require.NoError(t, err)
+
+	_, err = NewOffer(testPSK[:MinPSKLen-1], 1)
+	require.Error(t, err)
+
+	t.Run("wrong psk", func(t *testing.T) {
+		_, _, err := Answer(bytes.ToUpper(testPSK), o.Message(), 2)
+		require.ErrorIs(t, err, ErrAuthFailed)
+	})
+	t.Run("tampered offer", func(t *testing.T) {
+		msg := bytes.Clone(o.Message())
+		msg[len(msg)-authLen-3] ^= 1 // key data
+		_, _, err := Answer(testPSK, msg, 2)
+		require.ErrorIs(t, err, ErrAuthFailed)
+	})
+	t.Run("tampered answer", func(t *testing.T) {
+		_, resp, err := Answer(testPSK, o.Message(), 2)
+		require.NoError(t, err)
+		resp = bytes.Clone(resp)
+		resp[10+9+1] ^= 1 // SSRC of the responder
+		_, err = o.Accept(resp)
+		require.ErrorIs(t, err, ErrAuthFailed)
+	})
+	t.Run("clock skew", func(t *testing.T) {
+		old, err := newOffer(testPSK, 1, srtp.ProtectionProfileAes128CmHmacSha1_80, time.Now().Add(-2*MaxClockSkew))
+		require.NoError(t, err)
+		_, _, err = Answer(testPSK, old.Message(), 2)
+		require.ErrorIs(t, err, ErrClockSkew)
+	})
+	t.Run("truncated", func(t *testing.T) {
+		msg := o.Message()
+		for _, n := range []int{0, 5, 20, 40, len(msg) - 1} {
+			_, _, err := Answer(testPSK, msg[:n], 2)
+			require.Error(t, err, "length %d", n)
+		}
+	})
+	t.Run("offer as answer", func(t *testing.T) {
+		_, err := o.Accept(o.Message())
+		require.Error(t, err)
+	})
+}
+
+func TestParseSDP(t *testing.T) {
+	_, err := ParseSDP("sdes AAAA")
+	require.Error(t, err)
+	_, err = ParseSDP("mikey !!!")
+	require.Error(t, err)
+	msg, err := ParseSDP("MIKEY AQID")
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3}, msg)
+}
+
+func newTestConn(t *testing.T) *rtp.Conn {
+	c := rtp.NewConn(nil)
+	require.NoError(t, c.ListenAndServe(0, 0, "127.0.0.1"))
+	t.Cleanup(func() { _ = c.Close() })
+	c.RequireCipher()
+	return c
+}
+
+func TestLoopback(t *testing.T) {
+	initiator, responder := exchange(t, srtp.ProtectionProfileAes128CmHmacSha1_80)
+	a, b := newTestConn(t), newTestConn(t)
+	a.SetDestAddr(b.LocalAddr())
+	b.SetDestAddr(a.LocalAddr())
+	a.SetCipher(initiator)
+	b.SetCipher(responder)
+
+	gotA, gotB := make(chan *prtp.Packet, 10), make(chan *prtp.Packet, 10)
+	a.OnRTP(rtp.HandlerFunc(func(p *prtp.Packet) error {
+		gotA <- p.Clone()
+		return nil
+	}))
+	b.OnRTP(rtp.HandlerFunc(func(p *prtp.Packet) error {
+		gotB <- p.Clone()
+		return nil
+	}))
+
+	payload := bytes.Repeat([]byte{0xaa}, 160)
+	for _, c := range []struct {
+		conn *rtp.Conn
+		ssrc uint32
+		got  chan *prtp.Packet
+	}{
+		{a, 1111, gotB},
+		{b, 2222, gotA},
+	} {
+		pkt := &prtp.Packet{
+			Header:  prtp.Header{Version: 2, SSRC: c.ssrc},
+			Payload: payload,
+		}
+		for i := 0; i < 5; i++ {
+			pkt.SequenceNumber++
+			pkt.Timestamp += 160
+			require.NoError(t, c.conn.WriteRTP(pkt))
+		}
+		select {
+		case p := <-c.got:
+			require.Equal(t, c.ssrc, p.SSRC)
+			require.Equal(t, payload, p.Payload)
+		case <-time.After(time.Second):
+			t.Fatalf("no audio received from %d", c.ssrc)
+		}
+	}
+}
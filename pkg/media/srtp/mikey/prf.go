@@ -0,0 +1,82 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mikey
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+)
+
+// Constants of the key derivation (RFC 3830, section 4.1.3 and 4.1.4).
+const (
+	labelTEK  = 0x2AD01C64
+	labelEncr = 0x15798CEF
+	labelSalt = 0x39A2C14B
+	labelAuth = 0x1B5C7973
+)
+
+// csIDEnvelope is the crypto session ID used to derive keys protecting the message itself from the pre-shared key.
+const csIDEnvelope = 0xFF
+
+// prfBlock is the size of input key blocks of the PRF, 256 bits.
+const prfBlock = 32
+
+// deriveKey derives n bytes of a key from inkey, with the label constructed from a given constant,
+// crypto session ID, CSB ID and RAND (RFC 3830, section 4.1.3).
+func deriveKey(inkey []byte, constant uint32, csID byte, csbID uint32, rand []byte, n int) []byte {
+	label := make([]byte, 9, 9+len(rand))
+	binary.BigEndian.PutUint32(label[0:], constant)
+	label[4] = csID
+	binary.BigEndian.PutUint32(label[5:], csbID)
+	label = append(label, rand...)
+	return prf(inkey, label, n)
+}
+
+// prf is the MIKEY-1 pseudo-random function (RFC 3830, section 4.1.2). The input key is split into 256-bit blocks,
+// the last one may be shorter, and outputs of P for each block are XOR-ed.
+func prf(inkey, label []byte, n int) []byte {
+	m := (n + sha1.Size - 1) / sha1.Size
+	out := make([]byte, m*sha1.Size)
+	for len(inkey) > 0 {
+		s := inkey[:min(prfBlock, len(inkey))]
+		inkey = inkey[len(s):]
+		for i, b := range pSHA1(s, label, m) {
+			out[i] ^= b
+		}
+	}
+	return out[:n]
+}
+
+// pSHA1 is the P function of MIKEY-1, similar to the one of TLS 1.0 (RFC 2246):
+//
+//	P(s, label, m) = HMAC(s, A_1 || label) || HMAC(s, A_2 || label) || ... || HMAC(s, A_m || label)
+//
+// where A_0 = label and A_i = HMAC(s, A_{i-1}).
+func pSHA1(s, label []byte, m int) []byte {
+	out := make([]byte, 0, m*sha1.Size)
+	a := label
+	for i := 0; i < m; i++ {
+		h := hmac.New(sha1.New, s)
+		h.Write(a)
+		a = h.Sum(nil)
+
+		h = hmac.New(sha1.New, s)
+		h.Write(a)
+		h.Write(label)
+		out = h.Sum(out)
+	}
+	return out
+}
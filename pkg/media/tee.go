@@ -0,0 +1,61 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package media
+
+// Tee returns a Reader that writes to tap every sample it reads from src, similar to io.TeeReader.
+//
+// Unlike io.TeeReader, errors from the tap are ignored, so it never affects the pipeline.
+// The tap must not retain the sample and must be safe for concurrent use if the reader is used concurrently.
+// If tap is nil, src is returned as-is.
+func Tee[T ~[]E, E any](src Reader[T], tap Writer[T]) Reader[T] {
+	if tap == nil {
+		return src
+	}
+	return &teeReader[T, E]{src: src, tap: tap}
+}
+
+type teeReader[T ~[]E, E any] struct {
+	src Reader[T]
+	tap Writer[T]
+}
+
+func (r *teeReader[T, E]) ReadSample(buf T) (int, error) {
+	n, err := r.src.ReadSample(buf)
+	if n > 0 {
+		_ = r.tap.WriteSample(buf[:n])
+	}
+	return n, err
+}
+
+// WriterTee returns a Writer that writes every sample to both w and tap.
+//
+// Errors from the tap are ignored, only errors from w are returned. The tap must not retain the sample.
+// If tap is nil, w is returned as-is.
+func WriterTee[T any](w Writer[T], tap Writer[T]) Writer[T] {
+	if tap == nil {
+		return w
+	}
+	return &teeWriter[T]{w: w, tap: tap}
+}
+
+type teeWriter[T any] struct {
+	w   Writer[T]
+	tap Writer[T]
+}
+
+func (t *teeWriter[T]) WriteSample(sample T) error {
+	_ = t.tap.WriteSample(sample)
+	return t.w.WriteSample(sample)
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package media
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// seqReader returns frames filled with sequential values and is safe for concurrent use.
+type seqReader struct {
+	mu   sync.Mutex
+	next int16
+	max  int16
+}
+
+func (r *seqReader) ReadSample(buf PCM16Sample) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next >= r.max {
+		return 0, io.EOF
+	}
+	for i := range buf {
+		buf[i] = r.next
+	}
+	r.next++
+	return len(buf), nil
+}
+
+type syncCollector struct {
+	mu     sync.Mutex
+	frames []PCM16Sample
+}
+
+func (c *syncCollector) WriteSample(s PCM16Sample) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.frames = append(c.frames, slices.Clone(s))
+	return nil
+}
+
+func sortFrames(frames []PCM16Sample) {
+	slices.SortFunc(frames, func(a, b PCM16Sample) int {
+		return int(a[0]) - int(b[0])
+	})
+}
+
+func TestTee(t *testing.T) {
+	const (
+		frames  = 1000
+		readers = 8
+	)
+	var (
+		tap  syncCollector
+		read syncCollector
+	)
+	r := Tee[PCM16Sample](&seqReader{max: frames}, &tap)
+
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make(PCM16Sample, 160)
+			for {
+				n, err := r.ReadSample(buf)
+				if err != nil {
+					return
+				}
+				_ = read.WriteSample(buf[:n])
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, read.frames, frames)
+	sortFrames(read.frames)
+	sortFrames(tap.frames)
+	require.Equal(t, read.frames, tap.frames)
+	for i, frame := range tap.frames {
+		require.Len(t, frame, 160)
+		for _, v := range frame {
+			require.Equal(t, int16(i), v)
+		}
+	}
+}
+
+func TestWriterTee(t *testing.T) {
+	var (
+		out PCM16Sample
+		tap PCM16Sample
+	)
+	w := WriterTee[PCM16Sample](&out, WriterFunc[PCM16Sample](func(s PCM16Sample) error {
+		tap = append(tap, s...)
+		return errors.New("tap error")
+	}))
+	require.NoError(t, w.WriteSample(PCM16Sample{1, 2, 3}))
+	require.NoError(t, w.WriteSample(PCM16Sample{4, 5}))
+	require.Equal(t, PCM16Sample{1, 2, 3, 4, 5}, out)
+	require.Equal(t, out, tap)
+
+	w = WriterTee[PCM16Sample](&out, nil)
+	require.Equal(t, Writer[PCM16Sample](&out), w)
+}
@@ -28,6 +28,7 @@ func init() {
 		SDPName:     SDPName,
 		RTPDefType:  prtp.PayloadTypePCMU,
 		RTPIsStatic: true,
+		SampleRate:  8000,
 		Priority:    -10,
 	}, Decode, Encode))
 }
@@ -0,0 +1,45 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ulaw
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/audiotest"
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/rtp"
+)
+
+// minMOS is the minimal expected quality of G.711 round-trip.
+const minMOS = 4.3
+
+func TestRoundTripMOS(t *testing.T) {
+	ref := make(media.PCM16Sample, 3*rtp.DefSampleRate)
+	audiotest.GenSpeech(ref, rtp.DefSampleRate, 1)
+
+	var out media.PCM16Sample
+	enc := Encode(Decode(media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		out = append(out, s...)
+		return nil
+	})))
+	for i := 0; i+int(rtp.DefPacketDur) <= len(ref); i += int(rtp.DefPacketDur) {
+		require.NoError(t, enc.WriteSample(ref[i:i+int(rtp.DefPacketDur)]))
+	}
+	mos := audiotest.MOS(ref, out, rtp.DefSampleRate)
+	t.Logf("MOS: %.2f", mos)
+	require.GreaterOrEqual(t, mos, minMOS)
+}
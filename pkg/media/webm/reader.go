@@ -0,0 +1,115 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/at-wat/ebml-go"
+	"github.com/at-wat/ebml-go/webm"
+
+	"github.com/livekit/sip/pkg/media"
+)
+
+const pcm16CodecID = "A_PCM/INT/LIT"
+
+// NewPCM16Reader reads samples of the first PCM audio track of a WebM file, such as the ones written by NewPCM16Writer.
+//
+// The whole file is demuxed on creation, thus it's only meant for short recordings, e.g. in tests. Closing the reader closes r.
+func NewPCM16Reader(r io.ReadCloser) media.ReadCloser[media.PCM16Sample] {
+	rd := &readerPCM16{r: r}
+	rd.blocks, rd.err = readPCM16Blocks(r)
+	return rd
+}
+
+func readPCM16Blocks(r io.Reader) ([][]byte, error) {
+	// Clusters written by a live writer have an unknown size and end where the next cluster starts.
+	// The streaming block reader of ebml-go stops after the first of them, so the segment is decoded as a whole.
+	var file struct {
+		Header  webm.EBMLHeader    `ebml:"EBML"`
+		Segment webm.SegmentStream `ebml:"Segment,size=unknown"`
+	}
+	if err := ebml.Unmarshal(r, &file); err != nil {
+		return nil, fmt.Errorf("cannot read webm: %w", err)
+	}
+	track := uint64(0)
+	for _, t := range file.Segment.Tracks.TrackEntry {
+		if t.TrackType == 2 && t.CodecID == pcm16CodecID {
+			track = t.TrackNumber
+			break
+		}
+	}
+	if track == 0 {
+		return nil, errors.New("no PCM audio track in webm")
+	}
+	var out [][]byte
+	for _, c := range file.Segment.Cluster {
+		blocks := slices.Clone(c.SimpleBlock)
+		for _, g := range c.BlockGroup {
+			blocks = append(blocks, g.Block)
+		}
+		slices.SortStableFunc(blocks, func(a, b ebml.Block) int {
+			return int(a.Timecode) - int(b.Timecode)
+		})
+		for _, b := range blocks {
+			if b.TrackNumber != track {
+				continue
+			}
+			for _, data := range b.Data {
+				if len(data)%2 != 0 {
+					return nil, fmt.Errorf("invalid PCM block size: %d", len(data))
+				}
+				out = append(out, data)
+			}
+		}
+	}
+	return out, nil
+}
+
+type readerPCM16 struct {
+	r      io.Closer
+	err    error
+	blocks [][]byte
+	buf    media.PCM16Sample // samples of the current block which were not read yet
+}
+
+func (r *readerPCM16) ReadSample(out media.PCM16Sample) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	for len(r.buf) == 0 {
+		if len(r.blocks) == 0 {
+			return 0, io.EOF
+		}
+		b := r.blocks[0]
+		r.blocks = r.blocks[1:]
+		r.buf = make(media.PCM16Sample, len(b)/2)
+		for i := range r.buf {
+			r.buf[i] = int16(binary.LittleEndian.Uint16(b[2*i:]))
+		}
+	}
+	n := copy(out, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *readerPCM16) Close() error {
+	r.blocks, r.buf = nil, nil
+	return r.r.Close()
+}
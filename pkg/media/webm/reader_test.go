@@ -0,0 +1,81 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webm
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/audiotest"
+	"github.com/livekit/sip/pkg/media"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestPCM16Reader(t *testing.T) {
+	const (
+		sampleRate = 8000
+		frameDur   = 20 * time.Millisecond
+		frameSize  = sampleRate * int(frameDur/time.Millisecond) / 1000
+		// Long enough to need more than one cluster, which holds at most 32s of blocks.
+		frames = 2000
+		amp    = 10000
+	)
+	// Each frame has a signal with a different frequency, to check the order of frames.
+	signal := func(i int) int { return i % 5 }
+
+	var buf bytes.Buffer
+	w := NewPCM16Writer(nopWriteCloser{&buf}, sampleRate, frameDur)
+	frame := make(media.PCM16Sample, frameSize)
+	for i := 0; i < frames; i++ {
+		audiotest.GenSignal(frame, []audiotest.Wave{{Ind: signal(i), Amp: amp}})
+		require.NoError(t, w.WriteSample(frame))
+	}
+	require.NoError(t, w.Close())
+
+	r := NewPCM16Reader(io.NopCloser(&buf))
+	defer r.Close()
+	for i := 0; i < frames; i++ {
+		// Read in halves, to check that samples of a block are kept between reads.
+		got := make(media.PCM16Sample, frameSize)
+		for off := 0; off < frameSize; off += frameSize / 2 {
+			n, err := r.ReadSample(got[off : off+frameSize/2])
+			require.NoError(t, err, "frame %d", i)
+			require.Equal(t, frameSize/2, n)
+		}
+		waves := audiotest.FindSignal(got)
+		require.NotEmpty(t, waves, "frame %d", i)
+		require.Equal(t, signal(i), waves[0].Ind, "frame %d", i)
+		require.InDelta(t, amp, waves[0].Amp, amp*0.01)
+	}
+	_, err := r.ReadSample(frame)
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestPCM16ReaderInvalid(t *testing.T) {
+	r := NewPCM16Reader(io.NopCloser(bytes.NewReader([]byte("not a webm file"))))
+	defer r.Close()
+	_, err := r.ReadSample(make(media.PCM16Sample, 160))
+	require.Error(t, err)
+	require.NotErrorIs(t, err, io.EOF)
+}
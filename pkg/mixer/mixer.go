@@ -15,6 +15,8 @@
 package mixer
 
 import (
+	"io"
+	"math"
 	"sync"
 	"time"
 
@@ -32,12 +34,29 @@ const (
 	// inputBufferMin is the minimal number of buffered frames required to start mixing.
 	// It affects inputs initially, or after they start to starve.
 	inputBufferMin = inputBufferFrames/2 + 1
+
+	// levelTarget is the speech level in dBov, towards which quiet inputs are amplified, see SetInputLevel.
+	levelTarget = -20
+	// levelMaxGain is the max gain in dB applied based on the input level.
+	levelMaxGain = 12
+	// levelSilence is the level in dBov, below which the input is considered silent and its gain is kept.
+	levelSilence = -50
+	// levelSmoothing is the weight of each new level in the average level of the input.
+	levelSmoothing = 0.05
 )
 
 type Input struct {
 	mu        sync.Mutex
 	buf       *ringbuf.Buffer[int16]
 	buffering bool
+	closed    bool
+
+	// protected by Mixer.mu
+	gainDB      float64
+	levelDB     float64 // average input level in dBov, if hasLevel is set
+	hasLevel    bool
+	levelGainDB float64 // gain derived from the input level
+	gain        float64 // linear multiplier for gainDB and levelGainDB
 }
 
 type Mixer struct {
@@ -45,22 +64,46 @@ type Mixer struct {
 
 	mu     sync.Mutex
 	inputs []*Input
+	hold   media.Reader[media.PCM16Sample] // replaces all inputs when set
 
 	tickerDur time.Duration
 	ticker    *time.Ticker
 	mixBuf    []int32           // mix result buffer
 	mixTmp    media.PCM16Sample // temp buffer for reading input buffers
+	peak      int16             // max absolute sample value of inputs in the last mix; protected by mu
 
 	lastMix time.Time
 	stopped core.Fuse
+	done    chan struct{} // closed when the mixing goroutine exits
+	closed  bool          // protected by mu
 	mixCnt  uint
 }
 
-func NewMixer(out media.Writer[media.PCM16Sample], bufferDur time.Duration, sampleRate int) *Mixer {
+type options struct {
+	interval time.Duration
+}
+
+// Option configures the mixer.
+type Option func(o *options)
+
+// WithInterval sets how often frames are mixed. By default, it's the same as the frame duration.
+func WithInterval(dt time.Duration) Option {
+	return func(o *options) {
+		o.interval = dt
+	}
+}
+
+// NewMixer creates a mixer which writes frames of bufferDur to out, and starts mixing.
+func NewMixer(out media.Writer[media.PCM16Sample], bufferDur time.Duration, sampleRate int, opts ...Option) *Mixer {
+	o := options{interval: bufferDur}
+	for _, fnc := range opts {
+		fnc(&o)
+	}
 	mixSize := int(time.Duration(sampleRate) * bufferDur / time.Second)
 	m := newMixer(out, mixSize)
-	m.tickerDur = bufferDur
-	m.ticker = time.NewTicker(bufferDur)
+	m.tickerDur = o.interval
+	m.ticker = time.NewTicker(o.interval)
+	m.done = make(chan struct{})
 
 	go m.start()
 
@@ -78,6 +121,14 @@ func newMixer(out media.Writer[media.PCM16Sample], mixSize int) *Mixer {
 func (m *Mixer) mixInputs() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.peak = 0
+	if m.hold != nil {
+		n, _ := m.hold.ReadSample(m.mixTmp[:len(m.mixBuf)])
+		for j, v := range m.mixTmp[:n] {
+			m.mixBuf[j] += int32(v)
+		}
+		return
+	}
 	// Keep at least half of the samples buffered.
 	bufMin := inputBufferMin * len(m.mixBuf)
 	for _, inp := range m.inputs {
@@ -86,14 +137,35 @@ func (m *Mixer) mixInputs() {
 			continue
 		}
 		m.mixTmp = m.mixTmp[:n]
+		if inp.gain != 1 {
+			for j, v := range m.mixTmp {
+				s := int32(math.Round(float64(v) * inp.gain))
+				m.mixBuf[j] += s
+				m.updatePeak(s)
+			}
+			continue
+		}
 		for j, v := range m.mixTmp {
 			// Add the samples. This can potentially lead to overflow, but is unlikely and dividing by the source
 			// count would cause the volume to drop every time somebody joins
 			m.mixBuf[j] += int32(v)
+			m.updatePeak(int32(v))
 		}
 	}
 }
 
+func (m *Mixer) updatePeak(v int32) {
+	if v < 0 {
+		v = -v
+	}
+	if v > 0x7FFF {
+		v = 0x7FFF
+	}
+	if int16(v) > m.peak {
+		m.peak = int16(v)
+	}
+}
+
 func (m *Mixer) reset() {
 	for i := range m.mixBuf {
 		m.mixBuf[i] = 0
@@ -104,7 +176,10 @@ func (m *Mixer) mixOnce() {
 	m.mixCnt++
 	m.reset()
 	m.mixInputs()
+	m.writeMix()
+}
 
+func (m *Mixer) writeMix() {
 	// TODO: if we can guarantee that WriteSample won't store the sample, we can avoid allocation
 	out := make(media.PCM16Sample, len(m.mixBuf))
 	for i, v := range m.mixBuf {
@@ -140,6 +215,7 @@ func (m *Mixer) mixUpdate() {
 }
 
 func (m *Mixer) start() {
+	defer close(m.done)
 	defer m.ticker.Stop()
 	for {
 		select {
@@ -155,6 +231,57 @@ func (m *Mixer) Stop() {
 	m.stopped.Break()
 }
 
+// Close stops the mixer and waits for it to exit. Frames still buffered in the inputs are mixed and written,
+// and then the output is closed, if it implements io.Closer. Writing to inputs of a closed mixer returns io.ErrClosedPipe.
+func (m *Mixer) Close() error {
+	m.stopped.Break()
+	if m.done != nil {
+		<-m.done
+	}
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	for _, inp := range m.inputs {
+		inp.close()
+	}
+	m.mu.Unlock()
+
+	m.drain()
+	if c, ok := m.out.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// drain mixes all frames left in the inputs, ignoring the buffering threshold.
+// Nothing is written while on hold, since the inputs are not played then.
+func (m *Mixer) drain() {
+	for i := 0; i < inputBufferFrames; i++ {
+		m.reset()
+		m.mu.Lock()
+		if m.hold != nil {
+			m.mu.Unlock()
+			return
+		}
+		read := 0
+		for _, inp := range m.inputs {
+			n, _ := inp.readSample(0, m.mixTmp[:len(m.mixBuf)])
+			for j, v := range m.mixTmp[:n] {
+				m.mixBuf[j] += int32(math.Round(float64(v) * inp.gain))
+			}
+			read += n
+		}
+		m.mu.Unlock()
+		if read == 0 {
+			return
+		}
+		m.writeMix()
+	}
+}
+
 func (m *Mixer) NewInput() *Input {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -162,6 +289,8 @@ func (m *Mixer) NewInput() *Input {
 	inp := &Input{
 		buf:       ringbuf.New[int16](len(m.mixBuf) * inputBufferFrames),
 		buffering: true, // buffer some data initially
+		closed:    m.closed,
+		gain:      1,
 	}
 	m.inputs = append(m.inputs, inp)
 	return inp
@@ -181,6 +310,82 @@ func (m *Mixer) RemoveInput(inp *Input) {
 	}
 }
 
+// InputCount returns the number of inputs currently added to the mixer.
+func (m *Mixer) InputCount() int {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.inputs)
+}
+
+// PeakAmplitude returns the max absolute sample value across all inputs in the last mixing window, after the gain is applied.
+// It's zero while on hold, since the inputs are not mixed.
+func (m *Mixer) PeakAmplitude() int16 {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.peak
+}
+
+// SetHold replaces all mixer inputs with a given audio source, for example music-on-hold.
+// Setting it to nil resumes mixing the inputs.
+func (m *Mixer) SetHold(src media.Reader[media.PCM16Sample]) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hold = src
+}
+
+// SetInputGain sets the gain in dB, which is applied to the input before mixing. Zero means no change in volume.
+func (m *Mixer) SetInputGain(inp *Input, gainDB float64) {
+	if m == nil || inp == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inp.gainDB = gainDB
+	inp.updateGain()
+}
+
+// SetInputLevel reports the audio level of the input in dBov, for example from the audio level RTP header extension.
+// Quiet inputs are amplified towards a common speech level, in addition to the gain set with SetInputGain.
+// Levels of silence are ignored, so the gain does not grow during pauses in speech.
+func (m *Mixer) SetInputLevel(inp *Input, dBov float64) {
+	if m == nil || inp == nil || dBov < levelSilence {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !inp.hasLevel {
+		inp.levelDB, inp.hasLevel = dBov, true
+	} else {
+		inp.levelDB += (dBov - inp.levelDB) * levelSmoothing
+	}
+	inp.levelGainDB = max(0, min(levelTarget-inp.levelDB, levelMaxGain))
+	inp.updateGain()
+}
+
+// updateGain must be called with Mixer.mu held.
+func (i *Input) updateGain() {
+	i.gain = math.Pow(10, (i.gainDB+i.levelGainDB)/20)
+}
+
+// GetInputGain returns the gain of the input in dB, as set with SetInputGain.
+func (m *Mixer) GetInputGain(inp *Input) float64 {
+	if m == nil || inp == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return inp.gainDB
+}
+
 func (i *Input) readSample(bufMin int, out media.PCM16Sample) (int, error) {
 	i.mu.Lock()
 	defer i.mu.Unlock()
@@ -198,9 +403,18 @@ func (i *Input) readSample(bufMin int, out media.PCM16Sample) (int, error) {
 	return n, err
 }
 
+func (i *Input) close() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.closed = true
+}
+
 func (i *Input) WriteSample(sample media.PCM16Sample) error {
 	i.mu.Lock()
 	defer i.mu.Unlock()
+	if i.closed {
+		return io.ErrClosedPipe
+	}
 	_, err := i.buf.Write(sample)
 	return err
 }
@@ -15,11 +15,15 @@
 package mixer
 
 import (
+	"io"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
 
+	"github.com/livekit/sip/pkg/audiotest"
 	"github.com/livekit/sip/pkg/media"
 )
 
@@ -98,6 +102,80 @@ func TestMixer(t *testing.T) {
 		m.Expect(media.PCM16Sample{0x7FFF, 0x7FFF, -0x7FFF, -0x7FFF, 0x0})
 	})
 
+	t.Run("input gain", func(t *testing.T) {
+		var out media.PCM16Sample
+		m := newMixer(media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+			out = s
+			return nil
+		}), 160)
+
+		const amp = 10000
+		loud := m.NewInput()
+		defer m.RemoveInput(loud)
+		loud.buffering = false
+		m.SetInputGain(loud, 0)
+
+		quiet := m.NewInput()
+		defer m.RemoveInput(quiet)
+		quiet.buffering = false
+		m.SetInputGain(quiet, -6)
+		require.Equal(t, -6.0, m.GetInputGain(quiet))
+
+		sig := make(media.PCM16Sample, 160)
+		audiotest.GenSignal(sig, []audiotest.Wave{{Ind: 0, Amp: amp}})
+		loud.WriteSample(sig)
+
+		sig = make(media.PCM16Sample, 160)
+		audiotest.GenSignal(sig, []audiotest.Wave{{Ind: 3, Amp: amp}})
+		quiet.WriteSample(sig)
+
+		m.mixOnce()
+		waves := audiotest.FindSignal(out)
+		require.Len(t, waves, 2)
+		require.Equal(t, 0, waves[0].Ind)
+		require.Equal(t, 3, waves[1].Ind)
+		require.InDelta(t, amp, waves[0].Amp, amp*0.01)
+		// -6 dB is roughly a half of the amplitude.
+		require.InDelta(t, 0.5, float64(waves[1].Amp)/float64(waves[0].Amp), 0.01)
+	})
+
+	t.Run("input level", func(t *testing.T) {
+		var out media.PCM16Sample
+		m := newMixer(media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+			out = s
+			return nil
+		}), 5)
+		inp := m.NewInput()
+		defer m.RemoveInput(inp)
+		inp.buffering = false
+		mix := func() int16 {
+			inp.WriteSample([]int16{1000, 0, 0, 0, 0})
+			m.mixOnce()
+			return out[0]
+		}
+		require.EqualValues(t, 1000, mix())
+
+		// Speech at -26 dBov is amplified by 6 dB, silence doesn't change the gain.
+		m.SetInputLevel(inp, -26)
+		require.InDelta(t, 1995, mix(), 1)
+		m.SetInputLevel(inp, -127)
+		require.InDelta(t, 1995, mix(), 1)
+
+		// The gain is limited, and combined with the gain set explicitly.
+		for i := 0; i < 200; i++ {
+			m.SetInputLevel(inp, -45)
+		}
+		m.SetInputGain(inp, -6)
+		require.Equal(t, -6.0, m.GetInputGain(inp))
+		require.InDelta(t, 1995, mix(), 1)
+
+		// Loud inputs are not attenuated.
+		for i := 0; i < 200; i++ {
+			m.SetInputLevel(inp, -3)
+		}
+		require.InDelta(t, 501, mix(), 1)
+	})
+
 	t.Run("draining produces silence afterwards", func(t *testing.T) {
 		m := newTestMixer(t)
 		inp := m.NewInput()
@@ -189,3 +267,101 @@ func TestMixer(t *testing.T) {
 		m.CheckSampleN(steps)
 	})
 }
+
+type closeWriter struct {
+	mu      sync.Mutex
+	samples []media.PCM16Sample
+	closed  bool
+}
+
+func (w *closeWriter) WriteSample(s media.PCM16Sample) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return io.ErrClosedPipe
+	}
+	w.samples = append(w.samples, s)
+	return nil
+}
+
+func (w *closeWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return nil
+}
+
+func TestMixerClose(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	var w closeWriter
+	// Long interval, so that the mixer doesn't run before it's closed. Frames have 5 samples.
+	m := NewMixer(&w, 5*time.Millisecond, 1000, WithInterval(time.Hour))
+
+	inp := m.NewInput()
+	for i := 0; i < 2; i++ {
+		WriteSampleN(inp, i+1)
+	}
+	require.NoError(t, m.Close())
+
+	// Pending frames are written, even though the input didn't buffer enough to start mixing.
+	require.True(t, w.closed)
+	require.Equal(t, []media.PCM16Sample{
+		{5, 6, 7, 8, 9},
+		{10, 11, 12, 13, 14},
+	}, w.samples)
+
+	require.ErrorIs(t, inp.WriteSample(media.PCM16Sample{1, 2, 3, 4, 5}), io.ErrClosedPipe)
+	require.ErrorIs(t, m.NewInput().WriteSample(media.PCM16Sample{1, 2, 3, 4, 5}), io.ErrClosedPipe)
+	require.NoError(t, m.Close())
+}
+
+func TestMixerCloseOnHold(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	var w closeWriter
+	m := NewMixer(&w, 5*time.Millisecond, 1000, WithInterval(time.Hour))
+	inp := m.NewInput()
+	WriteSampleN(inp, 1)
+	m.SetHold(media.NewBufferReader(media.PCM16Sample{1, 1, 1, 1, 1}))
+	require.NoError(t, m.Close())
+
+	// Inputs are not played while on hold, even when the mixer is closed.
+	require.True(t, w.closed)
+	require.Empty(t, w.samples)
+}
+
+func TestMixerStats(t *testing.T) {
+	m := newTestMixer(t)
+	require.Zero(t, m.InputCount())
+	one := m.NewInput()
+	one.buffering = false
+	two := m.NewInput()
+	two.buffering = false
+	require.Equal(t, 2, m.InputCount())
+
+	one.WriteSample([]int16{10, -300, 20, 0, 0})
+	two.WriteSample([]int16{100, 0, 0, 0, -0x7FFF})
+	m.mixOnce()
+	require.EqualValues(t, 0x7FFF, m.PeakAmplitude())
+
+	m.RemoveInput(two)
+	require.Equal(t, 1, m.InputCount())
+	one.WriteSample([]int16{10, -300, 20, 0, 0})
+	m.mixOnce()
+	require.EqualValues(t, 300, m.PeakAmplitude())
+
+	// Gain is applied before measuring the peak.
+	m.SetInputGain(one, -20)
+	one.WriteSample([]int16{10, -300, 20, 0, 0})
+	m.mixOnce()
+	require.EqualValues(t, 30, m.PeakAmplitude())
+
+	// Starving inputs don't contribute.
+	m.mixOnce()
+	require.Zero(t, m.PeakAmplitude())
+
+	m.RemoveInput(one)
+	m.RemoveInput(one) // no-op
+	require.Zero(t, m.InputCount())
+}
@@ -0,0 +1,197 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mixer
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/livekit/sip/pkg/internal/ringbuf"
+	"github.com/livekit/sip/pkg/media"
+)
+
+const (
+	// mohStreamBuffer is the max number of samples buffered from the HTTP stream (1 sec at 8 kHz).
+	mohStreamBuffer = 8000
+	// mohReconnectDelay is a delay before reconnecting to the HTTP stream after it ends.
+	mohReconnectDelay = time.Second
+	// mohStreamWait is a delay before retrying to write to a full stream buffer.
+	mohStreamWait = 20 * time.Millisecond
+)
+
+// MusicOnHoldSource is a source of music-on-hold audio.
+//
+// Audio is expected to be raw 16 bit little-endian mono PCM with the same sample rate as the mixer.
+// Source can be shared between calls; each call must use a separate reader created with NewReader.
+type MusicOnHoldSource struct {
+	data media.PCM16Sample // audio loaded from file
+	url  string            // HTTP stream URL
+}
+
+// LoadMusicOnHoldFile loads music-on-hold audio from a file. The audio is played in a loop.
+func LoadMusicOnHoldFile(path string) (*MusicOnHoldSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := media.ReadPCM16(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read music-on-hold file: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, errors.New("music-on-hold file is empty")
+	}
+	return NewMusicOnHold(data), nil
+}
+
+// NewMusicOnHold creates a music-on-hold source which plays audio samples in a loop.
+func NewMusicOnHold(data media.PCM16Sample) *MusicOnHoldSource {
+	return &MusicOnHoldSource{data: data}
+}
+
+// NewMusicOnHoldURL creates a music-on-hold source which streams audio from an HTTP URL.
+// The stream is reopened when it ends.
+func NewMusicOnHoldURL(url string) *MusicOnHoldSource {
+	return &MusicOnHoldSource{url: url}
+}
+
+// NewReader creates a new reader for the music-on-hold audio. It must be closed after use.
+func (s *MusicOnHoldSource) NewReader() media.ReadCloser[media.PCM16Sample] {
+	if s.url != "" {
+		return newMOHStreamReader(s.url)
+	}
+	return &mohLoopReader{data: s.data}
+}
+
+type mohLoopReader struct {
+	data media.PCM16Sample
+	pos  int
+}
+
+func (r *mohLoopReader) ReadSample(buf media.PCM16Sample) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(buf) {
+		c := copy(buf[n:], r.data[r.pos:])
+		n += c
+		r.pos = (r.pos + c) % len(r.data)
+	}
+	return n, nil
+}
+
+func (r *mohLoopReader) Close() error {
+	return nil
+}
+
+type mohStreamReader struct {
+	url    string
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	buf *ringbuf.Buffer[int16]
+}
+
+func newMOHStreamReader(url string) *mohStreamReader {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &mohStreamReader{
+		url:    url,
+		cancel: cancel,
+		buf:    ringbuf.New[int16](mohStreamBuffer),
+	}
+	go r.run(ctx)
+	return r
+}
+
+func (r *mohStreamReader) run(ctx context.Context) {
+	for {
+		_ = r.stream(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(mohReconnectDelay):
+		}
+	}
+}
+
+func (r *mohStreamReader) stream(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	br := bufio.NewReader(resp.Body)
+	frame := make(media.PCM16Sample, 160)
+	for {
+		n := 0
+		for ; n < len(frame); n++ {
+			if err := binary.Read(br, binary.LittleEndian, &frame[n]); err != nil {
+				r.write(frame[:n])
+				return err
+			}
+		}
+		// Do not read faster than the audio is played.
+		for !r.write(frame) {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(mohStreamWait):
+			}
+		}
+	}
+}
+
+func (r *mohStreamReader) write(sample media.PCM16Sample) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.buf.Len()+len(sample) > r.buf.Size() {
+		return false
+	}
+	_, _ = r.buf.Write(sample)
+	return true
+}
+
+func (r *mohStreamReader) ReadSample(buf media.PCM16Sample) (int, error) {
+	r.mu.Lock()
+	n, _ := r.buf.Read(buf)
+	r.mu.Unlock()
+	// Stream is not fast enough - play silence instead.
+	for i := n; i < len(buf); i++ {
+		buf[i] = 0
+	}
+	return len(buf), nil
+}
+
+func (r *mohStreamReader) Close() error {
+	r.cancel()
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mixer
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/audiotest"
+	"github.com/livekit/sip/pkg/media"
+)
+
+func TestMusicOnHold(t *testing.T) {
+	const (
+		size    = 160
+		amp     = 10000
+		roomInd = 0
+		mohInd  = 3
+	)
+	sig := make(media.PCM16Sample, size)
+	audiotest.GenSignal(sig, []audiotest.Wave{{Ind: mohInd, Amp: amp}})
+
+	// Store the melody to a file to check loading as well.
+	path := filepath.Join(t.TempDir(), "moh.pcm")
+	data := make([]byte, 2*len(sig))
+	for i, v := range sig {
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(v))
+	}
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	moh, err := LoadMusicOnHoldFile(path)
+	require.NoError(t, err)
+
+	var out media.PCM16Sample
+	m := newMixer(media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		out = s
+		return nil
+	}), size)
+	inp := m.NewInput()
+	defer m.RemoveInput(inp)
+	inp.buffering = false
+
+	roomSig := make(media.PCM16Sample, size)
+	audiotest.GenSignal(roomSig, []audiotest.Wave{{Ind: roomInd, Amp: amp}})
+
+	expectWave := func(ind int) {
+		t.Helper()
+		_ = inp.WriteSample(roomSig)
+		m.mixOnce()
+		waves := audiotest.FindSignal(out)
+		require.Len(t, waves, 1)
+		require.Equal(t, ind, waves[0].Ind)
+		require.InDelta(t, amp, waves[0].Amp, amp*0.01)
+	}
+
+	expectWave(roomInd)
+
+	r := moh.NewReader()
+	defer r.Close()
+	m.SetHold(r)
+	// Check a few times to make sure music loops.
+	for i := 0; i < 3; i++ {
+		expectWave(mohInd)
+	}
+
+	m.SetHold(nil)
+	expectWave(roomInd)
+}
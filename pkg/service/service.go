@@ -16,6 +16,8 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -26,17 +28,34 @@ import (
 	"github.com/livekit/protocol/logger"
 	"github.com/livekit/protocol/rpc"
 	"github.com/livekit/psrpc"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/livekit/sip/pkg/config"
 	"github.com/livekit/sip/pkg/sip"
+	"github.com/livekit/sip/pkg/sip/authcache"
 	"github.com/livekit/sip/version"
 )
 
 const shutdownTimer = time.Second * 5
 
+// CallEndedTopic is a psrpc channel where the end of each inbound call is published, with the call timing.
+//
+// LiveKit RPC definitions don't have a message for it yet, so the event is sent as a generic protobuf Struct
+// with call_id, from_user, to_user, reason, started_at, answered_at, ended_at (RFC 3339) and duration_sec.
+const CallEndedTopic = "sip_call_ended"
+
+// MWISubscribeTopic is a psrpc channel where MWI subscriptions (RFC 3842) are published, so that LiveKit can
+// send message counts of the mailbox to the rooms on sip.MWITopic.
+//
+// Like CallEndedTopic, the event is a generic protobuf Struct, with subscription_id, mailbox, host, from_user,
+// trunk_id and expires_sec. Zero expires_sec means the subscription was removed.
+const MWISubscribeTopic = "sip_mwi_subscribe"
+
 type sipServiceStopFunc func()
 type sipServiceActiveCallsFunc func() int
+type sipServiceTrunksFunc func() []sip.TrunkStatus
 
 type Service struct {
 	conf *config.Config
@@ -45,20 +64,32 @@ type Service struct {
 	psrpcServer rpc.SIPInternalServerImpl
 	psrpcClient rpc.IOInfoClient
 	bus         psrpc.MessageBus
+	authCache   *authcache.Cache
 
 	promServer   *http.Server
 	rpcSIPServer rpc.SIPInternalServer
 
 	sipServiceStop        sipServiceStopFunc
 	sipServiceActiveCalls sipServiceActiveCallsFunc
+	sipServiceTrunks      sipServiceTrunksFunc
 
+	started  time.Time
 	shutdown core.Fuse
 	killed   atomic.Bool
 }
 
+// ServiceStatus describes the operational state of the service.
+type ServiceStatus struct {
+	Uptime      time.Duration
+	ActiveCalls int
+	Draining    bool // set once the service is stopped, while it waits for active calls to end
+	Version     string
+}
+
 func NewService(
 	conf *config.Config, log logger.Logger, srv rpc.SIPInternalServerImpl, sipServiceStop sipServiceStopFunc,
-	sipServiceActiveCalls sipServiceActiveCallsFunc, cli rpc.IOInfoClient, bus psrpc.MessageBus,
+	sipServiceActiveCalls sipServiceActiveCallsFunc, sipServiceTrunks sipServiceTrunksFunc,
+	cli rpc.IOInfoClient, bus psrpc.MessageBus,
 ) *Service {
 	s := &Service{
 		conf: conf,
@@ -67,19 +98,127 @@ func NewService(
 		psrpcServer: srv,
 		psrpcClient: cli,
 		bus:         bus,
+		authCache:   authcache.New(authCacheTTL(conf), authcache.DefaultSize),
 
 		sipServiceStop:        sipServiceStop,
 		sipServiceActiveCalls: sipServiceActiveCalls,
+		sipServiceTrunks:      sipServiceTrunks,
+
+		started: time.Now(),
 	}
 	if conf.PrometheusPort > 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/", promhttp.Handler())
+		mux.HandleFunc("/health", s.handleHealth)
+		if conf.TrunkStatusEnabled {
+			mux.HandleFunc("/trunks", s.handleTrunks)
+		}
 		s.promServer = &http.Server{
 			Addr:    fmt.Sprintf(":%d", conf.PrometheusPort),
-			Handler: promhttp.Handler(),
+			Handler: mux,
 		}
 	}
 	return s
 }
 
+// Status returns the current state of the service.
+func (s *Service) Status() ServiceStatus {
+	return ServiceStatus{
+		Uptime:      time.Since(s.started),
+		ActiveCalls: s.sipServiceActiveCalls(),
+		Draining:    s.shutdown.IsBroken(),
+		Version:     version.Version,
+	}
+}
+
+// handleHealth responds with the service status. Draining service responds with 503,
+// so that load balancers stop sending new calls to it.
+func (s *Service) handleHealth(w http.ResponseWriter, r *http.Request) {
+	st := s.Status()
+	w.Header().Set("Content-Type", "application/json")
+	if st.Draining {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(struct {
+		UptimeSec   int64  `json:"uptime_sec"`
+		ActiveCalls int    `json:"active_calls"`
+		Draining    bool   `json:"draining"`
+		Version     string `json:"version"`
+	}{
+		UptimeSec:   int64(st.Uptime / time.Second),
+		ActiveCalls: st.ActiveCalls,
+		Draining:    st.Draining,
+		Version:     st.Version,
+	})
+}
+
+type trunkStatusJSON struct {
+	Name          string `json:"name"`
+	ActiveCalls   int    `json:"active_calls"`
+	LastInvite    string `json:"last_invite,omitempty"`
+	Reachable     *bool  `json:"options_reachable"` // null if the trunk was never queried with OPTIONS
+	LastOptions   string `json:"last_options,omitempty"`
+	LastFailure   string `json:"last_failure,omitempty"`
+	LastFailureAt string `json:"last_failure_at,omitempty"`
+}
+
+// handleTrunks responds with the state of all trunks seen by this instance. Times are in RFC 3339.
+func (s *Service) handleTrunks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var trunks []sip.TrunkStatus
+	if s.sipServiceTrunks != nil {
+		trunks = s.sipServiceTrunks()
+	}
+	out := make([]trunkStatusJSON, 0, len(trunks))
+	for _, t := range trunks {
+		out = append(out, trunkStatusJSON{
+			Name:          t.ID,
+			ActiveCalls:   t.ActiveCalls,
+			LastInvite:    formatTime(t.LastInvite),
+			Reachable:     t.Reachable,
+			LastOptions:   formatTime(t.LastOptions),
+			LastFailure:   t.LastFailure,
+			LastFailureAt: formatTime(t.LastFailureAt),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Trunks []trunkStatusJSON `json:"trunks"`
+	}{Trunks: out})
+}
+
+var (
+	serviceInfoDesc = prometheus.NewDesc("livekit_sip_service_info", "Version of the SIP service.", []string{"version"}, nil)
+	drainingDesc    = prometheus.NewDesc("livekit_sip_service_draining", "Set to 1 while the service waits for active calls to end before shutting down.", nil, nil)
+	uptimeDesc      = prometheus.NewDesc("livekit_sip_service_uptime_seconds", "Time since the service started.", nil, nil)
+)
+
+// statusCollector exports the service status as Prometheus metrics.
+type statusCollector struct {
+	s *Service
+}
+
+func (c statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- serviceInfoDesc
+	ch <- drainingDesc
+	ch <- uptimeDesc
+}
+
+func (c statusCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.s.Status()
+	draining := 0.0
+	if st.Draining {
+		draining = 1
+	}
+	ch <- prometheus.MustNewConstMetric(serviceInfoDesc, prometheus.GaugeValue, 1, st.Version)
+	ch <- prometheus.MustNewConstMetric(drainingDesc, prometheus.GaugeValue, draining)
+	ch <- prometheus.MustNewConstMetric(uptimeDesc, prometheus.GaugeValue, st.Uptime.Seconds())
+}
+
 func (s *Service) Stop(kill bool) {
 	s.shutdown.Break()
 	s.killed.Store(kill)
@@ -94,6 +233,11 @@ func (s *Service) Run() error {
 			return err
 		}
 		defer promListener.Close()
+		status := statusCollector{s: s}
+		if err = prometheus.Register(status); err != nil {
+			return err
+		}
+		defer prometheus.Unregister(status)
 		go func() {
 			_ = s.promServer.Serve(promListener)
 		}()
@@ -132,30 +276,48 @@ func (s *Service) Run() error {
 	}
 }
 
+func authCacheTTL(conf *config.Config) time.Duration {
+	if conf.AuthCacheTTL > 0 {
+		return conf.AuthCacheTTL
+	}
+	return config.DefaultAuthCacheTTL
+}
+
 func (s *Service) GetAuthCredentials(ctx context.Context, from, to, toHost, srcAddress string) (username, password string, drop bool, err error) {
+	key := authcache.Key{From: from, To: to, ToHost: toHost, SrcAddress: srcAddress}
+	cred, err := s.authCache.Lookup(ctx, key, s.getAuthCredentials)
+	if err != nil {
+		return "", "", false, err
+	}
+	return cred.Username, cred.Password, cred.Drop, nil
+}
+
+func (s *Service) getAuthCredentials(ctx context.Context, key authcache.Key) (authcache.Credentials, error) {
 	resp, err := s.psrpcClient.GetSIPTrunkAuthentication(ctx, &rpc.GetSIPTrunkAuthenticationRequest{
-		From:       from,
-		To:         to,
-		ToHost:     toHost,
-		SrcAddress: srcAddress,
+		From:       key.From,
+		To:         key.To,
+		ToHost:     key.ToHost,
+		SrcAddress: key.SrcAddress,
 	})
 
 	if err != nil {
-		return "", "", false, err
+		return authcache.Credentials{}, err
 	}
 
-	return resp.Username, resp.Password, resp.Drop, nil
+	return authcache.Credentials{Username: resp.Username, Password: resp.Password, Drop: resp.Drop, TrunkID: resp.SipTrunkId}, nil
 }
 
 func (s *Service) DispatchCall(ctx context.Context, info *sip.CallInfo) sip.CallDispatch {
 	resp, err := s.psrpcClient.EvaluateSIPDispatchRules(ctx, &rpc.EvaluateSIPDispatchRulesRequest{
 
-		CallingNumber: info.FromUser,
-		CalledNumber:  info.ToUser,
-		CalledHost:    info.ToHost,
-		SrcAddress:    info.SrcAddress,
-		Pin:           info.Pin,
-		NoPin:         info.NoPin,
+		CallingNumber:   info.FromUser,
+		CalledNumber:    info.ToUser,
+		CalledHost:      info.ToHost,
+		SrcAddress:      info.SrcAddress,
+		Pin:             info.Pin,
+		NoPin:           info.NoPin,
+		ExtraAttributes: sip.HeadersToAttributes(info.Headers),
+		// Diversions (info.DiversionHeader) are not forwarded, the request has no field for them.
 	})
 
 	if err != nil {
@@ -168,7 +330,7 @@ func (s *Service) DispatchCall(ctx context.Context, info *sip.CallInfo) sip.Call
 		return sip.CallDispatch{Result: sip.DispatchNoRuleReject}
 	case rpc.SIPDispatchResult_LEGACY_ACCEPT_OR_PIN:
 		if resp.RequestPin {
-			return sip.CallDispatch{Result: sip.DispatchRequestPin}
+			return s.pinDispatch(sip.CallDispatch{Result: sip.DispatchRequestPin})
 		}
 		// TODO: finally deprecate and drop
 		return sip.CallDispatch{
@@ -177,6 +339,7 @@ func (s *Service) DispatchCall(ctx context.Context, info *sip.CallInfo) sip.Call
 			Identity:       resp.ParticipantIdentity,
 			Name:           resp.ParticipantName,
 			Metadata:       resp.ParticipantMetadata,
+			Attributes:     resp.ParticipantAttributes,
 			WsUrl:          resp.WsUrl,
 			Token:          resp.Token,
 			TrunkID:        resp.SipTrunkId,
@@ -189,16 +352,17 @@ func (s *Service) DispatchCall(ctx context.Context, info *sip.CallInfo) sip.Call
 			Identity:       resp.ParticipantIdentity,
 			Name:           resp.ParticipantName,
 			Metadata:       resp.ParticipantMetadata,
+			Attributes:     resp.ParticipantAttributes,
 			WsUrl:          resp.WsUrl,
 			Token:          resp.Token,
 			TrunkID:        resp.SipTrunkId,
 			DispatchRuleID: resp.SipDispatchRuleId,
 		}
 	case rpc.SIPDispatchResult_REQUEST_PIN:
-		return sip.CallDispatch{
+		return s.pinDispatch(sip.CallDispatch{
 			Result:  sip.DispatchRequestPin,
 			TrunkID: resp.SipTrunkId,
-		}
+		})
 	case rpc.SIPDispatchResult_REJECT:
 		return sip.CallDispatch{Result: sip.DispatchNoRuleReject}
 	case rpc.SIPDispatchResult_DROP:
@@ -206,6 +370,79 @@ func (s *Service) DispatchCall(ctx context.Context, info *sip.CallInfo) sip.Call
 	}
 }
 
+// pinDispatch sets PIN prompt settings from the config. Dispatch rules don't carry them yet.
+func (s *Service) pinDispatch(disp sip.CallDispatch) sip.CallDispatch {
+	disp.PinDigits = s.conf.PINDigits
+	disp.PinTimeout = s.conf.PINTimeout
+	disp.PinMaxAttempts = s.conf.PINMaxAttempts
+	return disp
+}
+
+// SubscribeMWI accepts MWI subscriptions matching a trunk, and forwards them to LiveKit on MWISubscribeTopic.
+// Message counts are published to the rooms on sip.MWITopic.
+func (s *Service) SubscribeMWI(ctx context.Context, sub *sip.MWISubscription) (string, error) {
+	key := authcache.Key{From: sub.FromUser, To: sub.User, ToHost: sub.Host, SrcAddress: sub.SrcAddress}
+	cred, err := s.authCache.Lookup(ctx, key, s.getAuthCredentials)
+	if err != nil {
+		return "", err
+	}
+	if cred.Drop {
+		return "", errors.New("subscription is dropped by the trunk")
+	}
+	s.log.Debugw("SIP MWI subscription", "mailbox", sub.User, "fromUser", sub.FromUser, "trunkID", cred.TrunkID, "expires", sub.Expires)
+	if s.bus == nil {
+		return cred.TrunkID, nil
+	}
+	ev, err := structpb.NewStruct(map[string]any{
+		"subscription_id": sub.ID,
+		"mailbox":         sub.User,
+		"host":            sub.Host,
+		"from_user":       sub.FromUser,
+		"trunk_id":        cred.TrunkID,
+		"expires_sec":     sub.Expires.Seconds(),
+	})
+	if err != nil {
+		return "", err
+	}
+	ch := psrpc.Channel{Legacy: MWISubscribeTopic, Server: MWISubscribeTopic, Local: MWISubscribeTopic}
+	if err = s.bus.Publish(ctx, ch, ev); err != nil {
+		return "", fmt.Errorf("cannot forward MWI subscription: %w", err)
+	}
+	return cred.TrunkID, nil
+}
+
+// CallEnded publishes the call timing on CallEndedTopic, so that LiveKit can update billing records.
+func (s *Service) CallEnded(ctx context.Context, info *sip.CallInfo, reason string) {
+	if s.bus == nil {
+		return
+	}
+	ev, err := structpb.NewStruct(map[string]any{
+		"call_id":      info.ID,
+		"from_user":    info.FromUser,
+		"to_user":      info.ToUser,
+		"reason":       reason,
+		"started_at":   formatTime(info.StartedAt),
+		"answered_at":  formatTime(info.AnsweredAt),
+		"ended_at":     formatTime(info.EndedAt),
+		"duration_sec": info.Duration().Seconds(),
+	})
+	if err != nil {
+		s.log.Warnw("cannot encode call ended event", err, "callID", info.ID)
+		return
+	}
+	ch := psrpc.Channel{Legacy: CallEndedTopic, Server: CallEndedTopic, Local: CallEndedTopic}
+	if err = s.bus.Publish(ctx, ch, ev); err != nil {
+		s.log.Warnw("cannot publish call ended event", err, "callID", info.ID)
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
 func (s *Service) CanAccept() bool {
 	return !s.shutdown.IsBroken()
 }
@@ -0,0 +1,242 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/rpc"
+	"github.com/livekit/psrpc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/sip"
+	"github.com/livekit/sip/version"
+)
+
+func newTestService(activeCalls int) *Service {
+	return NewService(&config.Config{}, logger.GetLogger(), nil, func() {}, func() int { return activeCalls }, nil, nil, nil)
+}
+
+func TestServiceStatus(t *testing.T) {
+	s := newTestService(2)
+	st := s.Status()
+	require.False(t, st.Draining)
+	require.Equal(t, 2, st.ActiveCalls)
+	require.Equal(t, version.Version, st.Version)
+	require.Positive(t, st.Uptime)
+
+	s.Stop(false)
+	st = s.Status()
+	require.True(t, st.Draining)
+	require.Equal(t, 2, st.ActiveCalls)
+}
+
+func TestServiceHealth(t *testing.T) {
+	s := newTestService(1)
+	get := func() (int, map[string]any) {
+		rec := httptest.NewRecorder()
+		s.handleHealth(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return rec.Code, body
+	}
+	code, body := get()
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, false, body["draining"])
+	require.EqualValues(t, 1, body["active_calls"])
+	require.Equal(t, version.Version, body["version"])
+
+	s.Stop(false)
+	code, body = get()
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, true, body["draining"])
+}
+
+func TestServiceStatusMetrics(t *testing.T) {
+	s := newTestService(0)
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(statusCollector{s: s}))
+	exp := `
+# HELP livekit_sip_service_draining Set to 1 while the service waits for active calls to end before shutting down.
+# TYPE livekit_sip_service_draining gauge
+livekit_sip_service_draining %d
+# HELP livekit_sip_service_info Version of the SIP service.
+# TYPE livekit_sip_service_info gauge
+livekit_sip_service_info{version="` + version.Version + `"} 1
+`
+	names := []string{"livekit_sip_service_info", "livekit_sip_service_draining"}
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(strings.Replace(exp, "%d", "0", 1)), names...))
+	s.Stop(false)
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(strings.Replace(exp, "%d", "1", 1)), names...))
+}
+
+func TestServiceTrunks(t *testing.T) {
+	reg := sip.NewTrunkRegistry()
+	s := NewService(&config.Config{}, logger.GetLogger(), nil, func() {}, func() int { return 0 }, reg.Trunks, nil, nil)
+	get := func() []map[string]any {
+		rec := httptest.NewRecorder()
+		s.handleTrunks(rec, httptest.NewRequest(http.MethodGet, "/trunks", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		var body struct {
+			Trunks []map[string]any `json:"trunks"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.NotNil(t, body.Trunks)
+		return body.Trunks
+	}
+	require.Empty(t, get())
+
+	start := time.Now()
+	end := reg.CallStarted("ST_b")
+	reg.CallStarted("ST_a")
+	reg.Options("ST_a", nil)
+	reg.CallFailed("ST_b", "status-503")
+	end()
+
+	trunks := get()
+	require.Len(t, trunks, 2)
+	a, b := trunks[0], trunks[1]
+	require.Equal(t, "ST_a", a["name"])
+	require.EqualValues(t, 1, a["active_calls"])
+	require.Equal(t, true, a["options_reachable"])
+	require.NotContains(t, a, "last_failure")
+	inv, err := time.Parse(time.RFC3339Nano, a["last_invite"].(string))
+	require.NoError(t, err)
+	require.WithinDuration(t, start, inv, time.Second)
+
+	require.Equal(t, "ST_b", b["name"])
+	require.EqualValues(t, 0, b["active_calls"])
+	require.Contains(t, b, "options_reachable")
+	require.Nil(t, b["options_reachable"])
+	require.Equal(t, "status-503", b["last_failure"])
+	require.NotEmpty(t, b["last_failure_at"])
+
+	// Read-only.
+	rec := httptest.NewRecorder()
+	s.handleTrunks(rec, httptest.NewRequest(http.MethodPost, "/trunks", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	// The endpoint is only served if enabled.
+	for _, enabled := range []bool{false, true} {
+		s := NewService(&config.Config{PrometheusPort: 9090, TrunkStatusEnabled: enabled}, logger.GetLogger(), nil, func() {}, func() int { return 0 }, reg.Trunks, nil, nil)
+		_, pattern := s.promServer.Handler.(*http.ServeMux).Handler(httptest.NewRequest(http.MethodGet, "/trunks", nil))
+		if enabled {
+			require.Equal(t, "/trunks", pattern)
+		} else {
+			require.Equal(t, "/", pattern)
+		}
+	}
+}
+
+type testAuthClient struct {
+	rpc.IOInfoClient
+	calls int
+	err   error
+}
+
+func (c *testAuthClient) GetSIPTrunkAuthentication(ctx context.Context, req *rpc.GetSIPTrunkAuthenticationRequest, opts ...psrpc.RequestOption) (*rpc.GetSIPTrunkAuthenticationResponse, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &rpc.GetSIPTrunkAuthenticationResponse{Username: "user_" + req.From, Password: "pass", SipTrunkId: "ST_" + req.From}, nil
+}
+
+func TestServiceAuthCache(t *testing.T) {
+	ctx := context.Background()
+	cli := &testAuthClient{}
+	s := NewService(&config.Config{}, logger.GetLogger(), nil, func() {}, func() int { return 0 }, nil, cli, nil)
+
+	for i := 0; i < 3; i++ {
+		user, pass, drop, err := s.GetAuthCredentials(ctx, "alice", "+100", "sip.example.com", "1.1.1.1")
+		require.NoError(t, err)
+		require.Equal(t, "user_alice", user)
+		require.Equal(t, "pass", pass)
+		require.False(t, drop)
+	}
+	require.Equal(t, 1, cli.calls)
+
+	_, _, _, err := s.GetAuthCredentials(ctx, "bob", "+100", "sip.example.com", "1.1.1.1")
+	require.NoError(t, err)
+	require.Equal(t, 2, cli.calls)
+
+	cli.err = errors.New("rpc failed")
+	for i := 0; i < 2; i++ {
+		_, _, _, err = s.GetAuthCredentials(ctx, "carol", "+100", "sip.example.com", "1.1.1.1")
+		require.Error(t, err)
+	}
+	require.Equal(t, 4, cli.calls)
+}
+
+// testBus records messages published to the bus.
+type testBus struct {
+	psrpc.MessageBus
+	channels []psrpc.Channel
+	msgs     []proto.Message
+}
+
+func (b *testBus) Publish(ctx context.Context, ch psrpc.Channel, msg proto.Message) error {
+	b.channels = append(b.channels, ch)
+	b.msgs = append(b.msgs, msg)
+	return nil
+}
+
+func TestServiceSubscribeMWI(t *testing.T) {
+	ctx := context.Background()
+	cli := &testAuthClient{}
+	bus := &testBus{}
+	s := NewService(&config.Config{}, logger.GetLogger(), nil, func() {}, func() int { return 0 }, nil, cli, bus)
+
+	trunkID, err := s.SubscribeMWI(ctx, &sip.MWISubscription{
+		ID:         "sub",
+		User:       "alice",
+		Host:       "sip.example.com",
+		FromUser:   "pbx",
+		SrcAddress: "1.1.1.1",
+		Expires:    time.Minute,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "ST_pbx", trunkID)
+	require.Len(t, bus.msgs, 1)
+	require.Equal(t, MWISubscribeTopic, bus.channels[0].Server)
+	require.Equal(t, map[string]any{
+		"subscription_id": "sub",
+		"mailbox":         "alice",
+		"host":            "sip.example.com",
+		"from_user":       "pbx",
+		"trunk_id":        "ST_pbx",
+		"expires_sec":     60.0,
+	}, bus.msgs[0].(*structpb.Struct).AsMap())
+
+	// Subscriptions which don't match a trunk are rejected, and not forwarded.
+	cli.err = errors.New("no trunk")
+	_, err = s.SubscribeMWI(ctx, &sip.MWISubscription{ID: "sub2", User: "alice", FromUser: "mallory"})
+	require.Error(t, err)
+	require.Len(t, bus.msgs, 1)
+}
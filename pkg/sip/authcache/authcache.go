@@ -0,0 +1,140 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package authcache caches trunk authentication lookups of inbound calls, so that repeated INVITEs
+// from the same caller don't query LiveKit server each time.
+package authcache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultSize is the maximal number of cached entries. Least recently used entries are evicted first.
+const DefaultSize = 10000
+
+// Key identifies an authentication lookup. Source address is a part of the key,
+// since trunks may be restricted to specific IPs.
+type Key struct {
+	From       string
+	To         string
+	ToHost     string
+	SrcAddress string
+}
+
+// Credentials is the result of the authentication lookup.
+type Credentials struct {
+	Username string
+	Password string
+	Drop     bool
+	TrunkID  string
+}
+
+// LookupFunc queries credentials for the key, usually via an RPC.
+type LookupFunc func(ctx context.Context, key Key) (Credentials, error)
+
+type entry struct {
+	key     Key
+	cred    Credentials
+	expires time.Time
+}
+
+// Cache is an LRU cache of authentication lookups, with a fixed TTL for each entry. It's safe for concurrent use.
+type Cache struct {
+	ttl  time.Duration
+	size int
+	now  func() time.Time
+
+	mu    sync.Mutex
+	items map[Key]*list.Element
+	lru   *list.List // of *entry, most recently used first
+}
+
+// New creates a cache which keeps up to size entries for ttl. DefaultSize is used if size is not positive.
+func New(ttl time.Duration, size int) *Cache {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Cache{
+		ttl:   ttl,
+		size:  size,
+		now:   time.Now,
+		items: make(map[Key]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+// Len returns the number of cached entries, including expired ones which are not evicted yet.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Get returns cached credentials, if they have not expired.
+func (c *Cache) Get(key Key) (Credentials, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return Credentials{}, false
+	}
+	e := el.Value.(*entry)
+	if !c.now().Before(e.expires) {
+		c.lru.Remove(el)
+		delete(c.items, key)
+		return Credentials{}, false
+	}
+	c.lru.MoveToFront(el)
+	return e.cred, true
+}
+
+// Set caches credentials for the key. Dropped calls are not cached, thus the trunk configuration
+// is checked again on the next attempt.
+func (c *Cache) Set(key Key, cred Credentials) {
+	if cred.Drop {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := c.now().Add(c.ttl)
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry)
+		e.cred, e.expires = cred, expires
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.lru.PushFront(&entry{key: key, cred: cred, expires: expires})
+	for c.lru.Len() > c.size {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.items, el.Value.(*entry).key)
+	}
+}
+
+// Lookup returns cached credentials, or calls fn and caches its result. Errors are not cached.
+// Concurrent lookups of the same key are not deduplicated.
+func (c *Cache) Lookup(ctx context.Context, key Key, fn LookupFunc) (Credentials, error) {
+	if cred, ok := c.Get(key); ok {
+		return cred, nil
+	}
+	cred, err := fn(ctx, key)
+	if err != nil {
+		return Credentials{}, err
+	}
+	c.Set(key, cred)
+	return cred, nil
+}
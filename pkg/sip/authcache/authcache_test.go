@@ -0,0 +1,91 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	c := New(time.Minute, 2)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	var (
+		calls  int
+		result = Credentials{Username: "user", Password: "pass"}
+		err    error
+	)
+	lookup := func(ctx context.Context, key Key) (Credentials, error) {
+		calls++
+		return result, err
+	}
+	alice := Key{From: "alice", To: "+100", ToHost: "sip.example.com", SrcAddress: "1.1.1.1"}
+
+	// Miss, then hit.
+	cred, gotErr := c.Lookup(ctx, alice, lookup)
+	require.NoError(t, gotErr)
+	require.Equal(t, result, cred)
+	require.Equal(t, 1, calls)
+	cred, gotErr = c.Lookup(ctx, alice, lookup)
+	require.NoError(t, gotErr)
+	require.Equal(t, result, cred)
+	require.Equal(t, 1, calls)
+
+	// Any part of the key is significant.
+	other := alice
+	other.SrcAddress = "2.2.2.2"
+	_, _ = c.Lookup(ctx, other, lookup)
+	require.Equal(t, 2, calls)
+
+	// Expiration.
+	now = now.Add(time.Minute)
+	_, _ = c.Lookup(ctx, alice, lookup)
+	require.Equal(t, 3, calls)
+	_, _ = c.Lookup(ctx, alice, lookup)
+	require.Equal(t, 3, calls)
+
+	// Least recently used entry is evicted.
+	bob := Key{From: "bob", To: "+100", ToHost: "sip.example.com"}
+	_, _ = c.Lookup(ctx, bob, lookup)
+	require.Equal(t, 4, calls)
+	require.Equal(t, 2, c.Len())
+	_, ok := c.Get(other)
+	require.False(t, ok)
+	_, ok = c.Get(alice)
+	require.True(t, ok)
+
+	// Failures are not cached.
+	carol := Key{From: "carol", To: "+100"}
+	err = errors.New("rpc failed")
+	_, gotErr = c.Lookup(ctx, carol, lookup)
+	require.Error(t, gotErr)
+	_, gotErr = c.Lookup(ctx, carol, lookup)
+	require.Error(t, gotErr)
+	require.Equal(t, 6, calls)
+
+	err, result = nil, Credentials{Drop: true}
+	cred, gotErr = c.Lookup(ctx, carol, lookup)
+	require.NoError(t, gotErr)
+	require.True(t, cred.Drop)
+	_, _ = c.Lookup(ctx, carol, lookup)
+	require.Equal(t, 8, calls)
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	prtp "github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/dtmf"
+	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/media/ulaw"
+)
+
+func TestService_BundleDemux(t *testing.T) {
+	s, _ := startTestService(t, &config.Config{})
+	call := addTestCall(s, "alice", "alice-tag")
+	audio := make(chan media.PCM16Sample, 10)
+	call.lkAudio.Set(media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		select {
+		case audio <- s:
+		default:
+		}
+		return nil
+	}))
+
+	peer := rtp.NewConn(nil)
+	require.NoError(t, peer.ListenAndServe(0, 0, "127.0.0.1"))
+	t.Cleanup(func() { _ = peer.Close() })
+	offer := strings.Replace(testBundleSDP, "40000", strconv.Itoa(peer.LocalAddr().Port), 1)
+	_, err := call.runMediaConn([]byte(offer), s.conf)
+	require.NoError(t, err)
+	t.Cleanup(call.closeMedia)
+	peer.SetDestAddr(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: call.rtpConn.LocalAddr().Port})
+
+	// Audio and telephone events of different media are sent to the same port.
+	frame := make([]int16, rtp.DefPacketDur)
+	require.NoError(t, peer.WriteRTP(&prtp.Packet{
+		Header:  prtp.Header{Version: 2, PayloadType: prtp.PayloadTypePCMU, SSRC: 0xA11CE},
+		Payload: ulaw.EncodeUlaw(frame),
+	}))
+	ev := make([]byte, 4)
+	_, err = dtmf.Encode(ev, dtmf.Event{Digit: '5', End: true})
+	require.NoError(t, err)
+	require.NoError(t, peer.WriteRTP(&prtp.Packet{
+		Header:  prtp.Header{Version: 2, PayloadType: 101, Marker: true, SSRC: 0xA11CE, SequenceNumber: 1},
+		Payload: ev,
+	}))
+
+	select {
+	case s := <-audio:
+		require.Len(t, s, int(rtp.DefPacketDur))
+	case <-time.After(time.Second):
+		t.Fatal("no audio received")
+	}
+	select {
+	case tone := <-call.dtmf:
+		require.Equal(t, byte('5'), tone.Digit)
+	case <-time.After(time.Second):
+		t.Fatal("no DTMF received")
+	}
+}
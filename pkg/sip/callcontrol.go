@@ -0,0 +1,103 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/sip/control"
+)
+
+var _ control.Call = (*inboundCall)(nil)
+
+// startControlWS starts the WebSocket call control API, if it's enabled. Tokens are signed with the API secret.
+func (s *Server) startControlWS() error {
+	if s.conf.ControlWSPort == 0 {
+		return nil
+	}
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", s.conf.ControlWSPort))
+	if err != nil {
+		return fmt.Errorf("cannot listen on the call control port %d: %w", s.conf.ControlWSPort, err)
+	}
+	s.controlSrv = &http.Server{
+		Handler: control.NewHandler(s.conf.ApiSecret, s.controlCall, s.log),
+	}
+	go func() {
+		_ = s.controlSrv.Serve(lis)
+	}()
+	return nil
+}
+
+// controlCall returns an active inbound call by the LiveKit call ID.
+func (s *Server) controlCall(callID string) (control.Call, bool) {
+	if c := s.inboundCallByID(callID); c != nil {
+		return c, true
+	}
+	return nil, false
+}
+
+// inboundCallByID returns an active inbound call by the LiveKit call ID, or nil if there's none.
+func (s *Server) inboundCallByID(callID string) *inboundCall {
+	s.cmu.RLock()
+	defer s.cmu.RUnlock()
+	for _, c := range s.activeCalls {
+		if c.id == callID {
+			return c
+		}
+	}
+	return nil
+}
+
+// SetMuted replaces the audio sent to the room with silence, or restores it.
+func (c *inboundCall) SetMuted(muted bool) {
+	if c.muted.Swap(muted) != muted {
+		c.log.Infow("Call mute changed by the operator", "muted", muted)
+	}
+}
+
+// InjectAudio plays the audio to the SIP participant, mixed with the room audio.
+func (c *inboundCall) InjectAudio(pcm media.PCM16Sample) error {
+	var frames []media.PCM16Sample
+	for len(pcm) > 0 {
+		n := min(len(pcm), int(rtp.DefPacketDur))
+		frames = append(frames, pcm[:n])
+		pcm = pcm[n:]
+	}
+	go c.playAudio(c.ctx, frames)
+	return nil
+}
+
+// Transfer the SIP participant to a given URI with SIP REFER.
+func (c *inboundCall) Transfer(to string) error {
+	return c.transferCall(to)
+}
+
+// muteWriter sends silence instead of the audio while muted.
+type muteWriter struct {
+	w     media.PCM16Writer
+	muted *atomic.Bool
+}
+
+func (m *muteWriter) WriteSample(sample media.PCM16Sample) error {
+	if m.muted.Load() {
+		sample = make(media.PCM16Sample, len(sample))
+	}
+	return m.w.WriteSample(sample)
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/sip/control"
+)
+
+func TestService_CallControl(t *testing.T) {
+	s, _ := startTestService(t, &config.Config{ApiSecret: "secret"})
+	call := addTestCall(s, "alice", "alice-tag")
+	setTestRoomConn(call.lkRoom)
+	toSIP := make(chan media.PCM16Sample, 100)
+	call.lkRoom.SetOutput(media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		select {
+		case toSIP <- s:
+		default:
+		}
+		return nil
+	}))
+
+	srv := httptest.NewServer(control.NewHandler(s.conf.ApiSecret, s.srv.controlCall, nil))
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/calls/" + call.id + "/control?token=" + control.Token("secret", call.id, time.Now().Add(time.Minute))
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	send := func(msg control.Message) control.Response {
+		t.Helper()
+		require.NoError(t, conn.WriteJSON(&msg))
+		var res control.Response
+		require.NoError(t, conn.ReadJSON(&res))
+		return res
+	}
+
+	// Audio sent to the room is replaced with silence while muted.
+	var toRoom []media.PCM16Sample
+	w := &muteWriter{w: media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		toRoom = append(toRoom, s)
+		return nil
+	}), muted: &call.muted}
+	require.Empty(t, send(control.Message{Action: control.ActionMute}).Error)
+	require.True(t, call.muted.Load())
+	require.NoError(t, w.WriteSample(media.PCM16Sample{1, 2}))
+	require.Empty(t, send(control.Message{Action: control.ActionUnmute}).Error)
+	require.False(t, call.muted.Load())
+	require.NoError(t, w.WriteSample(media.PCM16Sample{1, 2}))
+	require.Equal(t, []media.PCM16Sample{{0, 0}, {1, 2}}, toRoom)
+
+	// Injected audio is mixed into the audio sent to the SIP participant.
+	pcm := make([]byte, 10*2*rtp.DefPacketDur)
+	for i := 0; i < len(pcm); i += 2 {
+		binary.LittleEndian.PutUint16(pcm[i:], 1000)
+	}
+	require.Empty(t, send(control.Message{Action: control.ActionInjectAudio, Base64PCM: base64.StdEncoding.EncodeToString(pcm)}).Error)
+	timeout := time.After(time.Second)
+	for found := false; !found; {
+		select {
+		case frame := <-toSIP:
+			found = len(frame) > 0 && frame[0] == 1000
+		case <-timeout:
+			t.Fatal("no injected audio")
+		}
+	}
+
+	// The call is not answered, so REFER can't be sent yet.
+	res := send(control.Message{Action: control.ActionTransfer, To: "sip:carol@example.com"})
+	require.NotEmpty(t, res.Error)
+}
@@ -0,0 +1,230 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package callpprof collects CPU and memory profiles for individual SIP calls.
+//
+// CPU profiler is process-wide in Go, thus it runs while at least one call is profiled,
+// and goroutines of each call are marked with the "call_id" profiler label.
+// The profile is rotated each time a profiled call ends, and CPU time of the call is
+// collected from samples with its label. It's mostly useful for load testing.
+package callpprof
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"runtime/pprof"
+	"slices"
+	"sync"
+	"time"
+)
+
+// LabelCallID is the profiler label set for goroutines of the profiled call.
+const LabelCallID = "call_id"
+
+// CallProfile describes profiles collected for a single call.
+type CallProfile struct {
+	CallID   string
+	CPUFiles []string      // CPU profiles covering the call, in pprof format; use -tagfocus=call_id=<CallID> to filter samples
+	HeapFile string        // heap profile at the end of the call
+	Duration time.Duration // wall time of the call
+	CPU      time.Duration // CPU time used by goroutines of the call
+}
+
+var (
+	mu          sync.Mutex
+	active      = make(map[*Session]struct{})
+	window      *bytes.Buffer // current CPU profile; nil if the profiler is not running
+	subs        = make(map[int]func(p CallProfile))
+	subID       int
+	unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+)
+
+// Subscribe registers a function which is called for each completed call profile.
+func Subscribe(fnc func(p CallProfile)) (cancel func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	subID++
+	id := subID
+	subs[id] = fnc
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(subs, id)
+	}
+}
+
+// Session is an active profile of a call.
+type Session struct {
+	callID  string
+	labels  pprof.LabelSet
+	start   time.Time
+	cpu     time.Duration
+	files   []string
+	stopped bool
+}
+
+// Start starts profiling a call. Goroutines of the call must be started from Session.Do to be accounted.
+func Start(callID string) (*Session, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if window == nil {
+		buf := new(bytes.Buffer)
+		if err := pprof.StartCPUProfile(buf); err != nil {
+			return nil, err
+		}
+		window = buf
+	}
+	s := &Session{
+		callID: callID,
+		labels: pprof.Labels(LabelCallID, callID),
+		start:  time.Now(),
+	}
+	active[s] = struct{}{}
+	return s, nil
+}
+
+// Do runs the function with profiler labels of the call. Goroutines started by it inherit the labels.
+// It's safe to call on nil session, in which case the function is called directly.
+func (s *Session) Do(fnc func()) {
+	if s == nil {
+		fnc()
+		return
+	}
+	pprof.Do(context.Background(), s.labels, func(context.Context) {
+		fnc()
+	})
+}
+
+// rotate stops the current CPU profile, saves it and accounts CPU time to all active sessions.
+// The profiler is started again, if requested. Must be called with mu held.
+func rotate(restart bool) error {
+	if window == nil {
+		return nil
+	}
+	pprof.StopCPUProfile()
+	data := window.Bytes()
+	window = nil
+
+	var errs []error
+	cpu, err := labeledCPU(data, LabelCallID)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	file, err := writeTemp("calls", "cpu", data)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for s := range active {
+		s.cpu += cpu[s.callID]
+		if file != "" {
+			s.files = append(s.files, file)
+		}
+	}
+	if restart {
+		buf := new(bytes.Buffer)
+		if err = pprof.StartCPUProfile(buf); err != nil {
+			errs = append(errs, err)
+		} else {
+			window = buf
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Stop stops profiling and writes the heap profile. It's safe to call on nil session or call it multiple times.
+func (s *Session) Stop() (CallProfile, error) {
+	if s == nil {
+		return CallProfile{}, nil
+	}
+	mu.Lock()
+	if s.stopped {
+		mu.Unlock()
+		return CallProfile{}, nil
+	}
+	s.stopped = true
+	// Keep profiling other calls, if any.
+	err := rotate(len(active) > 1)
+	delete(active, s)
+	var fncs []func(p CallProfile)
+	for _, fnc := range subs {
+		fncs = append(fncs, fnc)
+	}
+	mu.Unlock()
+
+	p := CallProfile{
+		CallID:   s.callID,
+		CPUFiles: s.files,
+		Duration: time.Since(s.start),
+		CPU:      s.cpu,
+	}
+	var heap bytes.Buffer
+	if herr := pprof.Lookup("heap").WriteTo(&heap, 0); herr != nil {
+		err = errors.Join(err, herr)
+	} else if p.HeapFile, herr = writeTemp("call-"+s.callID, "heap", heap.Bytes()); herr != nil {
+		err = errors.Join(err, herr)
+	}
+	for _, fnc := range fncs {
+		fnc(p)
+	}
+	return p, err
+}
+
+func writeTemp(name, kind string, data []byte) (string, error) {
+	f, err := os.CreateTemp("", fmt.Sprintf("%s-*.%s.pprof", unsafeChars.ReplaceAllString(name, "_"), kind))
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// Stats are aggregated statistics of CPU time used by calls.
+type Stats struct {
+	Calls int
+	Mean  time.Duration
+	P99   time.Duration
+}
+
+// Summarize calculates CPU time statistics for call profiles.
+func Summarize(profiles []CallProfile) Stats {
+	if len(profiles) == 0 {
+		return Stats{}
+	}
+	cpu := make([]time.Duration, 0, len(profiles))
+	var sum time.Duration
+	for _, p := range profiles {
+		cpu = append(cpu, p.CPU)
+		sum += p.CPU
+	}
+	slices.Sort(cpu)
+	// Nearest-rank percentile.
+	i := int(math.Ceil(0.99*float64(len(cpu)))) - 1
+	return Stats{
+		Calls: len(cpu),
+		Mean:  sum / time.Duration(len(cpu)),
+		P99:   cpu[max(i, 0)],
+	}
+}
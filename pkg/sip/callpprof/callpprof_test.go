@@ -0,0 +1,127 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package callpprof
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// burnCPU keeps the CPU busy for a given duration in a new goroutine, which inherits profiler labels.
+func burnCPU(d time.Duration) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		x := 0
+		for end := time.Now().Add(d); time.Now().Before(end); {
+			for i := 0; i < 1000; i++ {
+				x += i * i
+			}
+		}
+		_ = x
+	}()
+	wg.Wait()
+}
+
+func removeProfile(t *testing.T, p CallProfile) {
+	t.Cleanup(func() {
+		for _, f := range p.CPUFiles {
+			_ = os.Remove(f)
+		}
+		_ = os.Remove(p.HeapFile)
+	})
+}
+
+func TestSession(t *testing.T) {
+	var nilSess *Session
+	called := false
+	nilSess.Do(func() { called = true })
+	require.True(t, called)
+	p, err := nilSess.Stop()
+	require.NoError(t, err)
+	require.Zero(t, p)
+
+	var (
+		gotMu sync.Mutex
+		got   []CallProfile
+	)
+	cancel := Subscribe(func(p CallProfile) {
+		gotMu.Lock()
+		defer gotMu.Unlock()
+		got = append(got, p)
+	})
+	defer cancel()
+
+	// Calls are profiled concurrently, CPU time is attributed to each call separately.
+	busy, err := Start("abc/123@host")
+	require.NoError(t, err)
+	idle, err := Start("idle")
+	require.NoError(t, err)
+
+	busy.Do(func() { burnCPU(300 * time.Millisecond) })
+	idle.Do(func() { time.Sleep(100 * time.Millisecond) })
+
+	pbusy, err := busy.Stop()
+	require.NoError(t, err)
+	removeProfile(t, pbusy)
+	require.Equal(t, "abc/123@host", pbusy.CallID)
+	require.Greater(t, pbusy.CPU, 100*time.Millisecond)
+	require.Len(t, pbusy.CPUFiles, 1)
+	require.FileExists(t, pbusy.CPUFiles[0])
+	require.True(t, strings.Contains(pbusy.HeapFile, "call-abc_123_host-"), pbusy.HeapFile)
+	require.FileExists(t, pbusy.HeapFile)
+
+	// Stopping again is a no-op.
+	p, err = busy.Stop()
+	require.NoError(t, err)
+	require.Zero(t, p)
+
+	pidle, err := idle.Stop()
+	require.NoError(t, err)
+	removeProfile(t, pidle)
+	require.Less(t, pidle.CPU, pbusy.CPU/2)
+	// The profile was rotated when the first call ended.
+	require.Len(t, pidle.CPUFiles, 2)
+	require.Equal(t, pbusy.CPUFiles[0], pidle.CPUFiles[0])
+
+	gotMu.Lock()
+	require.Equal(t, []CallProfile{pbusy, pidle}, got)
+	gotMu.Unlock()
+
+	// Profiler is stopped when no calls are profiled.
+	mu.Lock()
+	require.Nil(t, window)
+	mu.Unlock()
+}
+
+func TestSummarize(t *testing.T) {
+	require.Zero(t, Summarize(nil))
+
+	var profiles []CallProfile
+	for i := 1; i <= 200; i++ {
+		profiles = append(profiles, CallProfile{CPU: time.Duration(i) * time.Millisecond})
+	}
+	require.Equal(t, Stats{
+		Calls: 200,
+		Mean:  100500 * time.Microsecond,
+		P99:   198 * time.Millisecond,
+	}, Summarize(profiles))
+}
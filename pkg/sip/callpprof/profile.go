@@ -0,0 +1,187 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package callpprof
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var errInvalidProfile = errors.New("invalid cpu profile")
+
+// Field numbers of the pprof profile.proto messages.
+const (
+	fieldProfileSampleType  = 1
+	fieldProfileSample      = 2
+	fieldProfileStringTable = 6
+
+	fieldValueTypeType = 1
+
+	fieldSampleValue = 2
+	fieldSampleLabel = 3
+
+	fieldLabelKey = 1
+	fieldLabelStr = 2
+)
+
+type profileSample struct {
+	values []int64
+	labels [][2]int64 // key and value indexes in the string table
+}
+
+// labeledCPU sums CPU time of the gzipped pprof profile samples by the value of a given label.
+func labeledCPU(data []byte, key string) (map[string]time.Duration, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		strs    []string
+		types   []int64
+		samples []profileSample
+	)
+	err = consumeFields(raw, func(num protowire.Number, typ protowire.Type, b []byte) error {
+		switch num {
+		case fieldProfileSampleType:
+			var vt int64
+			err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) error {
+				if num == fieldValueTypeType {
+					v, n := protowire.ConsumeVarint(b)
+					if n < 0 {
+						return errInvalidProfile
+					}
+					vt = int64(v)
+				}
+				return nil
+			})
+			types = append(types, vt)
+			return err
+		case fieldProfileSample:
+			s, err := parseSample(b)
+			samples = append(samples, s)
+			return err
+		case fieldProfileStringTable:
+			strs = append(strs, string(b))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	str := func(i int64) string {
+		if i < 0 || int(i) >= len(strs) {
+			return ""
+		}
+		return strs[i]
+	}
+	cpuInd := -1
+	for i, t := range types {
+		if str(t) == "cpu" {
+			cpuInd = i
+		}
+	}
+	if cpuInd < 0 {
+		return nil, errInvalidProfile
+	}
+	out := make(map[string]time.Duration)
+	for _, s := range samples {
+		if cpuInd >= len(s.values) {
+			continue
+		}
+		for _, l := range s.labels {
+			if str(l[0]) == key {
+				out[str(l[1])] += time.Duration(s.values[cpuInd])
+			}
+		}
+	}
+	return out, nil
+}
+
+func parseSample(b []byte) (profileSample, error) {
+	var s profileSample
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) error {
+		switch num {
+		case fieldSampleValue:
+			if typ == protowire.VarintType {
+				v, _ := protowire.ConsumeVarint(b)
+				s.values = append(s.values, int64(v))
+				return nil
+			}
+			// Packed repeated field.
+			for len(b) > 0 {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return errInvalidProfile
+				}
+				s.values = append(s.values, int64(v))
+				b = b[n:]
+			}
+		case fieldSampleLabel:
+			var l [2]int64
+			err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) error {
+				if num != fieldLabelKey && num != fieldLabelStr {
+					return nil
+				}
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return errInvalidProfile
+				}
+				l[num-fieldLabelKey] = int64(v)
+				return nil
+			})
+			s.labels = append(s.labels, l)
+			return err
+		}
+		return nil
+	})
+	return s, err
+}
+
+// consumeFields calls the function for each field of the protobuf message.
+// Value of length-delimited fields is passed without the length prefix; other fields are passed as-is.
+func consumeFields(b []byte, fnc func(num protowire.Number, typ protowire.Type, b []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errInvalidProfile
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return errInvalidProfile
+		}
+		val := b[:n]
+		if typ == protowire.BytesType {
+			val, _ = protowire.ConsumeBytes(val)
+		}
+		if err := fnc(num, typ, val); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
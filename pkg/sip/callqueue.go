@@ -0,0 +1,101 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"time"
+
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/sip/parking"
+	"github.com/livekit/sip/pkg/sip/queue"
+)
+
+// queueAnnounceInterval is how often the position is announced to queued callers.
+const queueAnnounceInterval = 30 * time.Second
+
+// callQueue returns the queue of inbound calls waiting for a call slot on the trunk.
+func (s *Server) callQueue(trunkID string) *queue.CallQueue {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	q := s.queues[trunkID]
+	if q == nil {
+		q = queue.New(s.conf.CallQueueMaxLength)
+		s.queues[trunkID] = q
+	}
+	return q
+}
+
+// releaseTrunkSlot hands over the call slot of the trunk to the next queued call, or releases it if none are queued.
+func (s *Server) releaseTrunkSlot(trunkID string, release func()) {
+	if s.conf.CallQueueEnabled {
+		if _, ok := s.callQueue(trunkID).Dequeue(release); ok {
+			return
+		}
+	}
+	release()
+}
+
+// queueAnnouncement returns audio announcing the queue position. Recordings are read on each announcement.
+func (s *Server) queueAnnouncement(position int) []media.PCM16Sample {
+	if tmpl := s.conf.CallQueueAnnouncementTemplate; tmpl != "" {
+		frames, err := readPCM16File(queue.AnnouncementFile(tmpl, position))
+		if err == nil {
+			return frames
+		}
+		s.log.Debugw("Cannot read queue announcement, using tones", "position", position, "error", err)
+	}
+	return parking.Announcement(position, nil, &s.res.parkDigits)
+}
+
+// waitInQueue announces the queue position to the caller until the call is dequeued, and plays a tone once it is.
+// It returns false if the caller hangs up first.
+func (c *inboundCall) waitInQueue(ctx context.Context, q *queue.CallQueue, call *queue.Call) bool {
+	for {
+		pos := q.Position(call)
+		if pos == 0 {
+			break // dequeued
+		}
+		c.log.Debugw("Announcing queue position", "position", pos)
+		actx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-call.Done():
+				cancel()
+			case <-actx.Done():
+			}
+		}()
+		c.playAudio(actx, c.s.queueAnnouncement(pos))
+		cancel()
+
+		timer := time.NewTimer(queueAnnounceInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-call.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-call.Done():
+	}
+	c.log.Infow("Call dequeued", "waited", time.Since(call.QueuedAt))
+	c.playAudio(ctx, queue.ConnectTone())
+	return ctx.Err() == nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+func TestService_CallQueue(t *testing.T) {
+	const trunkID = "ST_queue"
+	joined := make(chan *testRoomConn, 1)
+	var release func()
+	s, addr := startTestService(t, &config.Config{
+		MaxConcurrentCalls: map[string]int{trunkID: 1},
+		CallQueueEnabled:   true,
+	}, func(s *Service) {
+		s.srv.connectRoom = newTestRoomConnector(joined)
+		s.SetHandler(acceptHandler("room", trunkID))
+		var ok bool
+		release, ok = s.srv.trunks.Acquire(trunkID, 1)
+		require.True(t, ok)
+	})
+
+	// The trunk is at capacity, so the call is answered and queued.
+	bob := newTestPhone(t, "bob")
+	bob.Call(t, addr, "+100", nil)
+	q := s.srv.callQueue(trunkID)
+	require.Equal(t, 1, q.Len())
+	select {
+	case <-joined:
+		t.Fatal("queued call joined the room")
+	case <-time.After(audioBridgeMaxDelay + 500*time.Millisecond):
+	}
+
+	// The slot is handed over once the active call ends.
+	s.srv.releaseTrunkSlot(trunkID, release)
+	select {
+	case <-joined:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dequeued call did not join the room")
+	}
+	require.Zero(t, q.Len())
+	require.Equal(t, 1, s.srv.trunks.Active(trunkID))
+}
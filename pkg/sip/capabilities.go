@@ -0,0 +1,241 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/pion/sdp/v2"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/dtmf"
+	lksdp "github.com/livekit/sip/pkg/media/sdp"
+	"github.com/livekit/sip/pkg/media/srtp/dtls"
+)
+
+// trunkCapabilities is a result of the OPTIONS request sent to the trunk.
+type trunkCapabilities struct {
+	allow   []string      // methods from the Allow header
+	accept  []string      // content types from the Accept header
+	codecs  []media.Codec // codecs from the SDP body, if any
+	expires time.Time
+}
+
+// acceptsSDP checks if the trunk accepts SDP bodies. All content types are assumed to be accepted if the header is not set.
+func (c *trunkCapabilities) acceptsSDP() bool {
+	return len(c.accept) == 0 || slices.Contains(c.accept, "application/sdp")
+}
+
+// allows checks if the trunk accepts requests with a given method. All methods are assumed to be allowed if the header is not set.
+func (c *trunkCapabilities) allows(method sip.RequestMethod) bool {
+	return c == nil || len(c.allow) == 0 || slices.Contains(c.allow, strings.ToLower(string(method)))
+}
+
+// filterCodecs removes codecs not supported by the trunk from the list. The list is returned as-is
+// if the trunk did not report any codecs, or none of them can carry audio.
+//
+// DTMF events are always kept, since trunks often omit them from OPTIONS responses.
+func (c *trunkCapabilities) filterCodecs(codecs []sdpCodecInfo) []sdpCodecInfo {
+	if c == nil || len(c.codecs) == 0 || !c.acceptsSDP() {
+		return codecs
+	}
+	out := make([]sdpCodecInfo, 0, len(codecs))
+	hasAudio := false
+	for _, info := range codecs {
+		if info.Codec.Info().SDPName == dtmf.SDPName {
+			out = append(out, info)
+			continue
+		}
+		if !slices.Contains(c.codecs, info.Codec) {
+			continue
+		}
+		hasAudio = true
+		out = append(out, info)
+	}
+	if !hasAudio {
+		return codecs
+	}
+	return out
+}
+
+// parseHeaderList returns comma-separated values of all headers with a given name.
+func parseHeaderList(res *sip.Response, name string) []string {
+	var out []string
+	for _, h := range res.GetHeaders(name) {
+		for _, v := range strings.Split(h.Value(), ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				v, _, _ = strings.Cut(v, ";")
+				out = append(out, strings.ToLower(v))
+			}
+		}
+	}
+	return out
+}
+
+func parseCapabilities(res *sip.Response) *trunkCapabilities {
+	caps := &trunkCapabilities{
+		allow:  parseHeaderList(res, "Allow"),
+		accept: parseHeaderList(res, "Accept"),
+	}
+	if len(res.Body()) == 0 {
+		return caps
+	}
+	var desc sdp.SessionDescription
+	if err := desc.Unmarshal(res.Body()); err != nil {
+		return caps
+	}
+	audio := sdpGetAudio(desc)
+	if audio == nil {
+		return caps
+	}
+	for _, a := range audio.Attributes {
+		if a.Key != "rtpmap" {
+			continue
+		}
+		_, name, ok := strings.Cut(a.Value, " ")
+		if !ok {
+			continue
+		}
+		if codec := lksdp.CodecByName(name); codec != nil && !slices.Contains(caps.codecs, codec) {
+			caps.codecs = append(caps.codecs, codec)
+		}
+	}
+	return caps
+}
+
+// capabilityCache keeps OPTIONS responses for each trunk address. Zero value is ready to use.
+type capabilityCache struct {
+	mu     sync.Mutex
+	trunks map[string]*trunkCapabilities
+}
+
+func (cc *capabilityCache) Get(trunk string, now time.Time) *trunkCapabilities {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	caps := cc.trunks[trunk]
+	if caps == nil || now.After(caps.expires) {
+		return nil
+	}
+	return caps
+}
+
+func (cc *capabilityCache) Set(trunk string, caps *trunkCapabilities) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.trunks == nil {
+		cc.trunks = make(map[string]*trunkCapabilities)
+	}
+	cc.trunks[trunk] = caps
+}
+
+// optionsFailedCacheTTL is how long a failed OPTIONS query is cached, so that the trunk is queried again soon.
+const optionsFailedCacheTTL = 30 * time.Second
+
+var errOptionsTimeout = errors.New("OPTIONS request timed out")
+
+// sipOptions sends an OPTIONS request to the trunk and waits for the final response.
+func (c *Client) sipOptions(conf sipOutboundConfig, timeout time.Duration) (*sip.Response, error) {
+	to, dest := sipTrunkURI("", conf.address)
+	from := &sip.FromHeader{Address: sip.Uri{User: conf.from, Host: c.signalingIp}, Params: sip.NewParams()}
+	from.Params.Add("tag", sip.GenerateTagN(16))
+
+	req := sip.NewRequest(sip.OPTIONS, to)
+	req.SetDestination(dest)
+	req.AppendHeader(&sip.ToHeader{Address: *to})
+	req.AppendHeader(from)
+	req.AppendHeader(&sip.ContactHeader{Address: from.Address})
+	req.AppendHeader(sip.NewHeader("Accept", "application/sdp"))
+
+	tx, err := c.sipCli.TransactionRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Terminate()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return nil, errOptionsTimeout
+		case <-tx.Done():
+			return nil, errNoResponse
+		case res := <-tx.Responses():
+			if res.StatusCode/100 == 1 {
+				continue
+			}
+			if res.StatusCode/100 != 2 {
+				return nil, fmt.Errorf("OPTIONS failed with status %d %s", res.StatusCode, res.Reason)
+			}
+			return res, nil
+		}
+	}
+}
+
+// trunkCapabilities returns cached capabilities of the trunk, or queries them with OPTIONS.
+// It returns nil if the query is disabled for the trunk. Failed queries are cached for a short time as well,
+// so that each call does not wait for the timeout.
+//
+// It may block until the timeout, thus it must not be called with the call lock held.
+func (c *Client) trunkCapabilities(conf sipOutboundConfig) *trunkCapabilities {
+	if !c.conf.QueryCapabilitiesBeforeDial[conf.address] {
+		return nil
+	}
+	now := time.Now()
+	if caps := c.caps.Get(conf.address, now); caps != nil {
+		return caps
+	}
+	ttl, timeout := c.conf.OptionsCapabilityCacheTTL, c.conf.OptionsCapabilityTimeout
+	if ttl <= 0 {
+		ttl = config.DefaultOptionsCapabilityCacheTTL
+	}
+	if timeout <= 0 {
+		timeout = config.DefaultOptionsCapabilityTimeout
+	}
+	caps := &trunkCapabilities{}
+	res, err := c.sipOptions(conf, timeout)
+	c.trunkStatus.Options(conf.trunkID, err)
+	if err != nil {
+		c.log.Warnw("Cannot query trunk capabilities, using default offer", err, "trunk", conf.address)
+		ttl = min(ttl, optionsFailedCacheTTL)
+	} else {
+		caps = parseCapabilities(res)
+		c.log.Infow("Trunk capabilities", "trunk", conf.address,
+			"allow", caps.allow, "accept", caps.accept, "codecs", len(caps.codecs))
+	}
+	caps.expires = now.Add(ttl)
+	c.caps.Set(conf.address, caps)
+	return caps
+}
+
+// sipOffer generates an SDP offer for the outbound call, using only codecs supported by the trunk, if known.
+// Codecs are ordered by the trunk preference, if it's configured. DTLS-SRTP media is offered, if enabled.
+// Capabilities are optional.
+func (c *Client) sipOffer(conf sipOutboundConfig, caps *trunkCapabilities, rtpListenerPort int) ([]byte, error) {
+	codecs := caps.filterCodecs(c.codecs())
+	codecs = sdpCodecsWithPreference(codecs, c.conf.CodecPreference[conf.trunkID])
+	var d *sdpDTLS
+	if c.dtlsCert != nil {
+		d = &sdpDTLS{Fingerprint: c.dtlsCert.Fingerprint(), Setup: dtls.RoleActPass}
+	}
+	return sdpGenerateOfferWithDTLS(c.signalingIp, rtpListenerPort, codecs, d)
+}
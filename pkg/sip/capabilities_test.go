@@ -0,0 +1,192 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/pion/sdp/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/dtmf"
+	"github.com/livekit/sip/pkg/media/g722"
+	"github.com/livekit/sip/pkg/media/ulaw"
+)
+
+const testOptionsSDP = "v=0\r\n" +
+	"o=- 1 1 IN IP4 127.0.0.1\r\n" +
+	"s=trunk\r\n" +
+	"c=IN IP4 127.0.0.1\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 0 RTP/AVP 0\r\n" +
+	"a=rtpmap:0 PCMU/8000\r\n"
+
+func TestOutboundCapabilities(t *testing.T) {
+	var options atomic.Int32
+	invites := make(chan []byte, 1)
+	uas := newTestUASWith(t, func(srv *sipgo.Server) {
+		srv.OnOptions(func(req *sip.Request, tx sip.ServerTransaction) {
+			options.Add(1)
+			res := sip.NewResponseFromRequest(req, 200, "OK", []byte(testOptionsSDP))
+			res.AppendHeader(sip.NewHeader("Allow", "INVITE, ACK, CANCEL, BYE, OPTIONS"))
+			res.AppendHeader(sip.NewHeader("Accept", "application/sdp"))
+			res.AppendHeader(&contentTypeHeaderSDP)
+			_ = tx.Respond(res)
+		})
+		srv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+			invites <- req.Body()
+			_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+		})
+	})
+	conf := sipOutboundConfig{address: uas.String(), from: "from", to: "to"}
+
+	call := newTestOutboundCall(t, &config.Config{
+		QueryCapabilitiesBeforeDial: map[string]bool{conf.address: true},
+	})
+	offer, err := call.c.sipOffer(conf, call.c.trunkCapabilities(conf), 40000)
+	require.NoError(t, err)
+	_, _, err = call.sipInvite(offer, conf)
+	require.NoError(t, err)
+
+	var desc sdp.SessionDescription
+	require.NoError(t, desc.Unmarshal(<-invites))
+	audio := sdpGetAudio(desc)
+	require.NotNil(t, audio)
+	require.Equal(t, []string{"0", "101"}, audio.MediaName.Formats)
+	// Opus is not supported by the trunk. DTMF events are kept, even though the trunk did not list them.
+	body := strings.ToLower(string(offer))
+	require.Contains(t, body, strings.ToLower(ulaw.SDPName))
+	require.NotContains(t, body, "opus")
+	require.Contains(t, body, dtmf.SDPName)
+
+	// Response is cached.
+	caps := call.c.trunkCapabilities(conf)
+	require.EqualValues(t, 1, options.Load())
+
+	// Methods not listed in Allow are not sent to the trunk.
+	require.True(t, caps.allows(sip.BYE))
+	require.False(t, caps.allows(sip.REFER))
+	call.sipInviteReq = sip.NewRequest(sip.INVITE, &sip.Uri{User: "to", Host: conf.address})
+	call.sipCaps = caps
+	require.ErrorContains(t, call.transferCall("sip:carol@example.com"), "REFER")
+	require.ErrorContains(t, call.sipMessage("hello"), "MESSAGE")
+}
+
+func TestFilterCodecs(t *testing.T) {
+	var pcmu, dtmfEv sdpCodecInfo
+	for _, c := range getCodecs() {
+		switch c.Codec.Info().SDPName {
+		case ulaw.SDPName:
+			pcmu = c
+		case dtmf.SDPName:
+			dtmfEv = c
+		}
+	}
+	require.NotNil(t, pcmu.Codec)
+	require.NotNil(t, dtmfEv.Codec)
+	all := []sdpCodecInfo{pcmu, dtmfEv}
+
+	var caps *trunkCapabilities
+	require.Equal(t, all, caps.filterCodecs(all))
+	require.True(t, caps.allows(sip.REFER))
+
+	// DTMF is kept, even if it's not listed by the trunk.
+	caps = &trunkCapabilities{codecs: []media.Codec{pcmu.Codec}}
+	require.Equal(t, all, caps.filterCodecs(all))
+	// No common audio codecs, the list is kept as-is.
+	caps = &trunkCapabilities{codecs: []media.Codec{dtmfEv.Codec}}
+	require.Equal(t, all, caps.filterCodecs(all))
+}
+
+func TestOutboundCapabilitiesTimeout(t *testing.T) {
+	uas := newTestUASWith(t, func(srv *sipgo.Server) {
+		srv.OnOptions(func(req *sip.Request, tx sip.ServerTransaction) {}) // never responds
+	})
+	conf := sipOutboundConfig{address: uas.String(), from: "from", to: "to"}
+
+	call := newTestOutboundCall(t, &config.Config{
+		QueryCapabilitiesBeforeDial: map[string]bool{conf.address: true},
+		OptionsCapabilityTimeout:    100 * time.Millisecond,
+	})
+	start := time.Now()
+	offer, err := call.c.sipOffer(conf, call.c.trunkCapabilities(conf), 40000)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), time.Second)
+
+	def, err := sdpGenerateOffer(call.c.signalingIp, 40000)
+	require.NoError(t, err)
+	var exp, got sdp.SessionDescription
+	require.NoError(t, exp.Unmarshal(def))
+	require.NoError(t, got.Unmarshal(offer))
+	require.Equal(t, sdpGetAudio(exp).MediaName.Formats, sdpGetAudio(got).MediaName.Formats)
+	require.Contains(t, string(offer), dtmf.SDPName)
+
+	// Failure is cached for a short time only.
+	caps := call.c.caps.Get(conf.address, time.Now())
+	require.NotNil(t, caps)
+	require.True(t, caps.expires.Before(time.Now().Add(optionsFailedCacheTTL+time.Second)))
+}
+
+func TestOutboundCodecPreference(t *testing.T) {
+	const (
+		trunkA = "ST_a"
+		trunkB = "ST_b"
+	)
+	call := newTestOutboundCall(t, &config.Config{
+		OutboundTrunks: map[string]string{
+			trunkA: "a.example.com:5060",
+			trunkB: "b.example.com:5060",
+		},
+		CodecPreference: map[string][]string{
+			trunkA: {"PCMU"},
+			trunkB: {"G722", "PCMU"},
+		},
+	})
+	// Offer all registered codecs, including ones disabled by default, without changing the global codec set.
+	call.c.codecs = func() []sdpCodecInfo {
+		var codecs []sdpCodecInfo
+		for _, name := range []string{ulaw.SDPName, g722.SDPName, dtmf.SDPName} {
+			i := slices.IndexFunc(media.Codecs(), func(c media.Codec) bool { return c.Info().SDPName == name })
+			require.True(t, i >= 0, name)
+			c := media.Codecs()[i]
+			typ := c.Info().RTPDefType
+			if !c.Info().RTPIsStatic {
+				typ = 101
+			}
+			codecs = append(codecs, sdpCodecInfo{Type: typ, Codec: c})
+		}
+		return codecs
+	}
+	formats := func(address string) []string {
+		trunkID := call.c.conf.OutboundTrunkID(address)
+		offer, err := call.c.sipOffer(sipOutboundConfig{trunkID: trunkID, address: address, from: "from", to: "to"}, nil, 40000)
+		require.NoError(t, err)
+		var desc sdp.SessionDescription
+		require.NoError(t, desc.Unmarshal(offer))
+		return sdpGetAudio(desc).MediaName.Formats
+	}
+	require.Equal(t, []string{"0", "9", "101"}, formats("a.example.com:5060"))
+	require.Equal(t, []string{"9", "0", "101"}, formats("b.example.com:5060"))
+	// Default order for other trunks.
+	require.Equal(t, []string{"0", "9", "101"}, formats("c.example.com:5060"))
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/cdr"
+	"github.com/livekit/sip/pkg/config"
+)
+
+type cdrWriterFunc func(r *cdr.CDRRecord) error
+
+func (f cdrWriterFunc) WriteCDR(r *cdr.CDRRecord) error {
+	return f(r)
+}
+
+func TestService_CDR(t *testing.T) {
+	joined := make(chan *testRoomConn, 1)
+	records := make(chan *cdr.CDRRecord, 1)
+	_, addr := startTestService(t, &config.Config{}, func(s *Service) {
+		s.srv.connectRoom = newTestRoomConnector(joined)
+		s.srv.cdrs = cdr.NewExporter(nil, cdrWriterFunc(func(r *cdr.CDRRecord) error {
+			records <- r
+			return nil
+		}))
+		t.Cleanup(s.srv.cdrs.Close)
+		s.SetHandler(acceptHandler("room", "ST_cdr"))
+	})
+
+	alice := newTestPhone(t, "alice")
+	started := time.Now()
+	req, res := alice.Call(t, addr, "+100", nil)
+	select {
+	case <-joined:
+	case <-time.After(5 * time.Second):
+		t.Fatal("call did not join the room")
+	}
+
+	// Send a few audio frames, one of them is lost.
+	answer := sdp.SessionDescription{}
+	require.NoError(t, answer.Unmarshal(res.Body()))
+	conn, err := net.DialUDP("udp", nil, sdpGetAudioDest(answer))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	for i := 0; i < 10; i++ {
+		if i != 5 {
+			p := rtp.Packet{Header: rtp.Header{Version: 2, SequenceNumber: uint16(i), Timestamp: uint32(160 * i), SSRC: 0xA11CE}, Payload: make([]byte, 160)}
+			data, err := p.Marshal()
+			require.NoError(t, err)
+			_, err = conn.Write(data)
+			require.NoError(t, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	require.Equal(t, sip.StatusCode(200), sendTestRequest(t, addr, "alice", newTestPhoneRequest(sip.BYE, addr, req, res)).StatusCode)
+	ended := time.Now()
+
+	var r *cdr.CDRRecord
+	select {
+	case r = <-records:
+	case <-time.After(5 * time.Second):
+		t.Fatal("CDR was not written")
+	}
+	require.NotEmpty(t, r.CallID)
+	require.Equal(t, "ST_cdr", r.TrunkID)
+	require.Equal(t, "alice", r.From)
+	require.Equal(t, "+100", r.To)
+	require.Equal(t, "inbound", r.Direction)
+	require.Equal(t, "hangup", r.HangupCause)
+	require.WithinRange(t, r.StartTime, started, r.AnswerTime)
+	require.WithinRange(t, r.AnswerTime, r.StartTime, r.EndTime)
+	require.WithinRange(t, r.EndTime, r.AnswerTime, ended.Add(time.Second))
+	require.InDelta(t, r.EndTime.Sub(r.AnswerTime).Seconds(), r.Duration, 0.001)
+	require.GreaterOrEqual(t, r.Duration, 0.2)
+	require.EqualValues(t, 9, r.PacketsReceived)
+	require.EqualValues(t, 9*(12+160), r.BytesReceived)
+	require.EqualValues(t, 1, r.LostPackets)
+	require.NotZero(t, r.PacketsSent)
+	require.NotZero(t, r.BytesSent)
+}
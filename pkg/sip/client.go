@@ -17,6 +17,7 @@ package sip
 import (
 	"context"
 	"fmt"
+	"net"
 	"sync"
 
 	"github.com/emiago/sipgo"
@@ -26,15 +27,28 @@ import (
 	"github.com/livekit/protocol/rpc"
 	"golang.org/x/exp/maps"
 
+	"github.com/livekit/sip/pkg/cdr"
 	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/errors"
+	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/media/srtp/dtls"
+	"github.com/livekit/sip/pkg/sip/publish"
+	"github.com/livekit/sip/pkg/sip/smime"
 	"github.com/livekit/sip/pkg/stats"
+	"github.com/livekit/sip/pkg/webhook"
 )
 
 type Client struct {
-	conf *config.Config
-	log  logger.Logger
-	mon  *stats.Monitor
+	conf  *config.Config
+	log   logger.Logger
+	mon   *stats.Monitor
+	ports *rtp.PortPool
+	hook  *webhook.Notifier
+	cdrs  *cdr.Exporter      // optional
+	pub   *publish.Publisher // optional
+	dial  *dialer            // optional
 
+	connectRoom      roomConnector
 	sipCli           *sipgo.Client
 	signalingIp      string
 	signalingIpLocal string
@@ -42,9 +56,23 @@ type Client struct {
 	closing     core.Fuse
 	cmu         sync.Mutex
 	activeCalls map[*outboundCall]struct{}
+	trunks      trunkLimiter
+	trunkStatus *TrunkRegistry // optional
+	caps        capabilityCache
+	srv         srvResolver // resolves trunk domains without a port; optional
+	srvCache    srvCache
+	hosts       hostResolver // resolves trunk hosts for dns_load_balance_mode; optional
+	dnsRR       dnsRoundRobin
+	codecs      func() []sdpCodecInfo // codecs offered to trunks
+	dtlsCert    *dtls.Certificate     // set if DTLS-SRTP is offered
+	smime       *smime.Signer         // signs outbound offers; optional
+	tcpPool     *DialerPool           // reuses TCP connections to trunks; optional
+	dialPlan    dialPlan
+
+	registrations []*registration // see outbound_register
 }
 
-func NewClient(conf *config.Config, log logger.Logger, mon *stats.Monitor) *Client {
+func NewClient(conf *config.Config, log logger.Logger, mon *stats.Monitor, ports *rtp.PortPool, hook *webhook.Notifier) *Client {
 	if log == nil {
 		log = logger.GetLogger()
 	}
@@ -52,7 +80,13 @@ func NewClient(conf *config.Config, log logger.Logger, mon *stats.Monitor) *Clie
 		conf:        conf,
 		log:         log,
 		mon:         mon,
+		ports:       ports,
+		hook:        hook,
+		connectRoom: connectLiveKit,
 		activeCalls: make(map[*outboundCall]struct{}),
+		codecs:      getCodecs,
+		srv:         newDNSResolver(),
+		hosts:       net.DefaultResolver,
 	}
 	return c
 }
@@ -77,6 +111,20 @@ func (c *Client) Start(agent *sipgo.UserAgent) error {
 	}
 	c.log.Infow("client starting", "local", c.signalingIpLocal, "external", c.signalingIp)
 
+	if c.conf.DTLSSRTPOutbound {
+		if c.dtlsCert, err = dtls.GenerateCertificate(); err != nil {
+			return err
+		}
+	}
+	if c.dialPlan, err = newDialPlan(c.conf.DialPlan); err != nil {
+		return err
+	}
+	if c.conf.SMIMECertFile != "" {
+		if c.smime, err = smime.LoadSigner(c.conf.SMIMECertFile, c.conf.SMIMEKeyFile); err != nil {
+			return err
+		}
+	}
+
 	if agent == nil {
 		ua, err := sipgo.NewUA(
 			sipgo.WithUserAgent(UserAgent),
@@ -91,6 +139,25 @@ func (c *Client) Start(agent *sipgo.UserAgent) error {
 	if err != nil {
 		return err
 	}
+	if c.conf.PoolMaxConns > 0 {
+		// Report the port of the TCP listener, so that it's used in Via.
+		port := 0
+		for _, l := range c.conf.SIPListeners() {
+			if l.Transport == "tcp" {
+				port = l.Port
+				break
+			}
+		}
+		c.tcpPool = NewDialerPool(c.conf.PoolMaxConns, c.conf.PoolIdleTimeout, port)
+		go func() {
+			_ = agent.TransportLayer().ServeTCP(c.tcpPool)
+		}()
+	}
+	c.pub, err = publish.NewPublisher(c.sipCli, c.conf.PublishURI, c.conf.PublishExpires, c.log)
+	if err != nil {
+		return err
+	}
+	c.startRegistrations()
 
 	return nil
 }
@@ -104,6 +171,11 @@ func (c *Client) Stop() {
 	for _, call := range calls {
 		call.Close()
 	}
+	c.pub.Close()
+	c.stopRegistrations()
+	if c.tcpPool != nil {
+		_ = c.tcpPool.Close()
+	}
 	if c.sipCli != nil {
 		c.sipCli.Close()
 		c.sipCli = nil
@@ -126,40 +198,74 @@ func (c *Client) CreateSIPParticipant(ctx context.Context, req *rpc.InternalCrea
 		"from-user", req.Number,
 		"to-host", req.Address, "to-user", req.CallTo,
 	)
-	log.Infow("Creating SIP participant")
-	call, err := c.newCall(c.conf, log, req.SipCallId, lkRoomConfig{
-		roomName: req.RoomName,
-		identity: req.ParticipantIdentity,
-		name:     req.ParticipantName,
-		meta:     req.ParticipantMetadata,
-		wsUrl:    req.WsUrl,
-		token:    req.Token,
-	})
-	if err != nil {
-		return nil, err
+	trunkID := c.conf.OutboundTrunkID(req.Address)
+	if trunkID != "" {
+		log = log.WithValues("sip-trunk", trunkID)
+	}
+	callTo := c.dialPlan.Normalize(trunkID, req.CallTo)
+	if callTo != req.CallTo {
+		log = log.WithValues("normalized-to-user", callTo)
+	}
+	limit := c.conf.GetMaxConcurrentCalls(trunkID)
+	release, ok := c.trunks.Acquire(trunkID, limit)
+	if !ok {
+		log.Warnw("Rejecting outbound call, trunk capacity exceeded", nil, "max-calls", limit)
+		return nil, errors.ErrTrunkCapacityExceeded
+	}
+	slot, trunkEnd := release, c.trunkStatus.CallStarted(trunkID)
+	release = func() {
+		slot()
+		trunkEnd()
 	}
-	// Start actual SIP call async.
-	go func() {
-		ctx := context.WithoutCancel(ctx)
-		err := call.UpdateSIP(ctx, sipOutboundConfig{
-			address:  req.Address,
-			from:     req.Number,
-			to:       req.CallTo,
-			user:     req.Username,
-			pass:     req.Password,
-			dtmf:     req.Dtmf,
-			ringtone: req.PlayRingtone,
+	log.Infow("Creating SIP participant")
+	prof := startCallProfile(c.conf, log, req.SipCallId)
+	var (
+		call *outboundCall
+		err  error
+	)
+	// Goroutines started for the call inherit its profiler labels.
+	prof.Do(func() {
+		call, err = c.newCall(c.conf, log, req.SipCallId, prof, release, lkRoomConfig{
+			roomName: req.RoomName,
+			identity: req.ParticipantIdentity,
+			name:     req.ParticipantName,
+			meta:     req.ParticipantMetadata,
+			attrs:    req.ParticipantAttributes,
+			wsUrl:    req.WsUrl,
+			token:    req.Token,
 		})
 		if err != nil {
-			log.Errorw("SIP call failed", err)
 			return
 		}
-		select {
-		case <-call.Disconnected():
-			call.CloseWithReason("removed")
-		case <-call.Closed():
-		}
-	}()
+		// Start actual SIP call async.
+		go func() {
+			ctx := context.WithoutCancel(ctx)
+			err := call.UpdateSIP(ctx, sipOutboundConfig{
+				trunkID:  trunkID,
+				address:  req.Address,
+				from:     req.Number,
+				fromName: fromDisplayName(c.conf.OutboundFrom[trunkID], req.ParticipantName, req.Number, req.RoomName),
+				to:       callTo,
+				user:     req.Username,
+				pass:     req.Password,
+				dtmf:     req.Dtmf,
+				ringtone: req.PlayRingtone,
+				headers:  AttributesToHeaders(req.ParticipantAttributes),
+			})
+			if err != nil {
+				log.Errorw("SIP call failed", err)
+				return
+			}
+			select {
+			case <-call.Disconnected():
+				call.CloseWithReason("removed")
+			case <-call.Closed():
+			}
+		}()
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	p := call.Participant()
 	return &rpc.InternalCreateSIPParticipantResponse{
@@ -173,6 +279,12 @@ func (c *Client) OnRequest(req *sip.Request, tx sip.ServerTransaction) {
 	switch req.Method {
 	case "BYE":
 		c.onBye(req, tx)
+	case "MESSAGE":
+		c.onMessage(req, tx)
+	case "INFO":
+		c.onInfo(req, tx)
+	case "NOTIFY":
+		c.onNotify(req, tx)
 	}
 }
 
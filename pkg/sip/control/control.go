@@ -0,0 +1,206 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package control implements a WebSocket API for controlling active calls in real time.
+package control
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/livekit/protocol/logger"
+
+	"github.com/livekit/sip/pkg/media"
+)
+
+const (
+	ActionMute        = "mute"
+	ActionUnmute      = "unmute"
+	ActionInjectAudio = "inject_audio"
+	ActionTransfer    = "transfer"
+)
+
+// Path is the pattern of the control endpoint of a call.
+const Path = "/calls/{call_id}/control"
+
+// Message is a control request sent by the operator over WebSocket.
+type Message struct {
+	Action    string `json:"action"`
+	Base64PCM string `json:"base64_pcm,omitempty"` // inject_audio: 16 bit little-endian PCM, 8 kHz mono
+	To        string `json:"to,omitempty"`         // transfer: target URI
+}
+
+// Response is sent back for each message.
+type Response struct {
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Call is an active call which can be controlled.
+type Call interface {
+	SetMuted(muted bool)
+	InjectAudio(pcm media.PCM16Sample) error
+	Transfer(to string) error
+}
+
+// LookupFunc returns an active call by its ID.
+type LookupFunc func(callID string) (Call, bool)
+
+// Token generates an access token for the control endpoint of the call, which is valid until a given time.
+// The token is passed to the endpoint in the "Authorization: Bearer" header, or in the "token" query parameter.
+func Token(secret, callID string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + sign(secret, callID, exp)
+}
+
+func sign(secret, callID, exp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(callID + "\n" + exp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyToken checks that the token was signed for the call and has not expired yet.
+func VerifyToken(secret, callID, token string, now time.Time) error {
+	exp, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return errors.New("malformed token")
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if !hmac.Equal([]byte(sig), []byte(sign(secret, callID, exp))) {
+		return errors.New("invalid token signature")
+	}
+	if !now.Before(time.Unix(unix, 0)) {
+		return errors.New("token expired")
+	}
+	return nil
+}
+
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+// DecodePCM decodes the audio of the inject_audio message.
+func DecodePCM(data string) (media.PCM16Sample, error) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw)%2 != 0 {
+		return nil, errors.New("odd number of bytes in PCM audio")
+	}
+	pcm := make(media.PCM16Sample, len(raw)/2)
+	for i := range pcm {
+		pcm[i] = int16(binary.LittleEndian.Uint16(raw[2*i:]))
+	}
+	return pcm, nil
+}
+
+// NewHandler creates an HTTP handler for the control endpoints of calls. Requests must be authorized with Token.
+func NewHandler(secret string, lookup LookupFunc, log logger.Logger) http.Handler {
+	if log == nil {
+		log = logger.GetLogger()
+	}
+	h := &handler{secret: secret, lookup: lookup, log: log}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+Path, h.serveCall)
+	return mux
+}
+
+type handler struct {
+	secret string
+	lookup LookupFunc
+	log    logger.Logger
+	up     websocket.Upgrader
+}
+
+func (h *handler) serveCall(w http.ResponseWriter, r *http.Request) {
+	callID := r.PathValue("call_id")
+	log := h.log.WithValues("callID", callID)
+	if err := VerifyToken(h.secret, callID, requestToken(r), time.Now()); err != nil {
+		log.Warnw("Rejecting call control request", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	call, ok := h.lookup(callID)
+	if !ok {
+		http.Error(w, "call not found", http.StatusNotFound)
+		return
+	}
+	conn, err := h.up.Upgrade(w, r, nil)
+	if err != nil {
+		log.Warnw("Cannot upgrade call control connection", err)
+		return
+	}
+	defer conn.Close()
+	log.Infow("Call control connected", "remote", r.RemoteAddr)
+	for {
+		var msg Message
+		if err = conn.ReadJSON(&msg); err != nil {
+			var cerr *websocket.CloseError
+			if !errors.As(err, &cerr) {
+				log.Warnw("Call control connection failed", err)
+			}
+			return
+		}
+		resp := Response{Action: msg.Action}
+		if err = handleMessage(call, &msg); err != nil {
+			log.Warnw("Call control action failed", err, "action", msg.Action)
+			resp.Error = err.Error()
+		}
+		if err = conn.WriteJSON(&resp); err != nil {
+			log.Warnw("Cannot send call control response", err, "action", msg.Action)
+			return
+		}
+	}
+}
+
+func handleMessage(call Call, msg *Message) error {
+	switch msg.Action {
+	case ActionMute:
+		call.SetMuted(true)
+		return nil
+	case ActionUnmute:
+		call.SetMuted(false)
+		return nil
+	case ActionInjectAudio:
+		pcm, err := DecodePCM(msg.Base64PCM)
+		if err != nil {
+			return fmt.Errorf("invalid audio: %w", err)
+		}
+		return call.InjectAudio(pcm)
+	case ActionTransfer:
+		if msg.To == "" {
+			return errors.New("transfer target is not set")
+		}
+		return call.Transfer(msg.To)
+	default:
+		return fmt.Errorf("unsupported action: %q", msg.Action)
+	}
+}
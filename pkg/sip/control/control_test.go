@@ -0,0 +1,123 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/media"
+)
+
+type testCall struct {
+	muted    []bool
+	audio    []media.PCM16Sample
+	transfer []string
+}
+
+func (c *testCall) SetMuted(muted bool) {
+	c.muted = append(c.muted, muted)
+}
+
+func (c *testCall) InjectAudio(pcm media.PCM16Sample) error {
+	c.audio = append(c.audio, pcm)
+	return nil
+}
+
+func (c *testCall) Transfer(to string) error {
+	if to == "sip:busy@example.com" {
+		return errors.New("busy")
+	}
+	c.transfer = append(c.transfer, to)
+	return nil
+}
+
+func TestToken(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	token := Token("secret", "SCL_1", now.Add(time.Minute))
+	require.NoError(t, VerifyToken("secret", "SCL_1", token, now))
+	require.Error(t, VerifyToken("secret", "SCL_2", token, now))
+	require.Error(t, VerifyToken("other", "SCL_1", token, now))
+	require.ErrorContains(t, VerifyToken("secret", "SCL_1", token, now.Add(time.Minute)), "expired")
+	require.Error(t, VerifyToken("secret", "SCL_1", "", now))
+	require.Error(t, VerifyToken("secret", "SCL_1", "x."+strings.SplitN(token, ".", 2)[1], now))
+}
+
+func TestDecodePCM(t *testing.T) {
+	pcm, err := DecodePCM(base64.StdEncoding.EncodeToString([]byte{1, 0, 0xff, 0xff}))
+	require.NoError(t, err)
+	require.Equal(t, media.PCM16Sample{1, -1}, pcm)
+	_, err = DecodePCM(base64.StdEncoding.EncodeToString([]byte{1}))
+	require.Error(t, err)
+	_, err = DecodePCM("!")
+	require.Error(t, err)
+}
+
+func TestHandler(t *testing.T) {
+	call := &testCall{}
+	srv := httptest.NewServer(NewHandler("secret", func(callID string) (Call, bool) {
+		if callID != "SCL_1" {
+			return nil, false
+		}
+		return call, true
+	}, nil))
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	token := Token("secret", "SCL_1", time.Now().Add(time.Minute))
+
+	// Unauthorized and unknown calls.
+	_, resp, err := websocket.DefaultDialer.Dial(url+"/calls/SCL_1/control", nil)
+	require.Error(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	_, resp, err = websocket.DefaultDialer.Dial(url+"/calls/SCL_2/control?token="+Token("secret", "SCL_2", time.Now().Add(time.Minute)), nil)
+	require.Error(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"/calls/SCL_1/control", http.Header{"Authorization": {"Bearer " + token}})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	send := func(msg Message) Response {
+		t.Helper()
+		require.NoError(t, conn.WriteJSON(&msg))
+		var res Response
+		require.NoError(t, conn.ReadJSON(&res))
+		require.Equal(t, msg.Action, res.Action)
+		return res
+	}
+
+	require.Empty(t, send(Message{Action: ActionMute}).Error)
+	require.Empty(t, send(Message{Action: ActionUnmute}).Error)
+	require.Equal(t, []bool{true, false}, call.muted)
+
+	pcm := base64.StdEncoding.EncodeToString([]byte{1, 0, 2, 0})
+	require.Empty(t, send(Message{Action: ActionInjectAudio, Base64PCM: pcm}).Error)
+	require.Equal(t, []media.PCM16Sample{{1, 2}}, call.audio)
+	require.NotEmpty(t, send(Message{Action: ActionInjectAudio, Base64PCM: "!"}).Error)
+
+	require.Empty(t, send(Message{Action: ActionTransfer, To: "sip:carol@example.com"}).Error)
+	require.Equal(t, []string{"sip:carol@example.com"}, call.transfer)
+	require.Equal(t, "busy", send(Message{Action: ActionTransfer, To: "sip:busy@example.com"}).Error)
+	require.NotEmpty(t, send(Message{Action: ActionTransfer}).Error)
+
+	require.Contains(t, send(Message{Action: "hangup"}).Error, "unsupported action")
+}
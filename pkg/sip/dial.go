@@ -0,0 +1,177 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/utils"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+const (
+	dialPrefix = "DIAL:"
+	// dialDedupWindow is the time during which repeated dialstrings for the same room are ignored.
+	// It only protects from retransmits: SIP participants other than the room leader never dial.
+	dialDedupWindow = 5 * time.Second
+	dialTimeout     = 10 * time.Second
+)
+
+var (
+	errNotDialString     = errors.New("not a dialstring")
+	errInvalidDialString = errors.New("invalid dialstring")
+)
+
+// parseDialString parses data channel dialstrings in the "DIAL:<number>@<trunk_id>" format.
+func parseDialString(s string) (number, trunkID string, err error) {
+	s, ok := strings.CutPrefix(strings.TrimSpace(s), dialPrefix)
+	if !ok {
+		return "", "", errNotDialString
+	}
+	number, trunkID, ok = strings.Cut(s, "@")
+	if !ok || number == "" || trunkID == "" {
+		return "", "", fmt.Errorf("%w: expected <number>@<trunk_id>", errInvalidDialString)
+	}
+	for i, c := range number {
+		switch {
+		case c >= '0' && c <= '9', c == '*', c == '#':
+		case c == '+' && i == 0:
+		default:
+			return "", "", fmt.Errorf("%w: unexpected character in number: %q", errInvalidDialString, c)
+		}
+	}
+	return number, trunkID, nil
+}
+
+// sipAPI is a subset of LiveKit SIP API used for dialing.
+type sipAPI interface {
+	ListSIPTrunk(ctx context.Context, in *livekit.ListSIPTrunkRequest) (*livekit.ListSIPTrunkResponse, error)
+	CreateSIPParticipant(ctx context.Context, in *livekit.CreateSIPParticipantRequest) (*livekit.SIPParticipantInfo, error)
+}
+
+// dialer creates outbound SIP participants requested by room participants over the data channel.
+type dialer struct {
+	conf *config.Config
+	log  logger.Logger
+	api  sipAPI
+
+	mu     sync.Mutex
+	recent map[string]time.Time // room + dialstring
+}
+
+func newDialer(conf *config.Config, log logger.Logger) *dialer {
+	if !conf.LiveKitDataChannelDialEnabled {
+		return nil
+	}
+	return &dialer{
+		conf:   conf,
+		log:    log,
+		api:    lksdk.NewSIPClient(conf.WsUrl, conf.ApiKey, conf.ApiSecret),
+		recent: make(map[string]time.Time),
+	}
+}
+
+// isDuplicate checks if the same dialstring was recently handled for the room.
+func (d *dialer) isDuplicate(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for k, t := range d.recent {
+		if now.Sub(t) > dialDedupWindow {
+			delete(d.recent, k)
+		}
+	}
+	if _, ok := d.recent[key]; ok {
+		return true
+	}
+	d.recent[key] = now
+	return false
+}
+
+// Dial validates the trunk and calls a number on behalf of the room. The new SIP participant joins the same room.
+func (d *dialer) Dial(ctx context.Context, roomName, sender, number, trunkID string) error {
+	if d == nil {
+		return nil
+	}
+	if d.isDuplicate(roomName + "\x00" + number + "@" + trunkID) {
+		return nil
+	}
+	log := d.log.WithValues("roomName", roomName, "sender", sender, "to-user", number, "sip-trunk", trunkID)
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+
+	resp, err := d.api.ListSIPTrunk(ctx, &livekit.ListSIPTrunkRequest{})
+	if err != nil {
+		return fmt.Errorf("cannot list SIP trunks: %w", err)
+	}
+	var trunk *livekit.SIPTrunkInfo
+	for _, t := range resp.Items {
+		if t.SipTrunkId == trunkID {
+			trunk = t
+			break
+		}
+	}
+	if trunk == nil {
+		return fmt.Errorf("SIP trunk %q not found", trunkID)
+	} else if trunk.OutboundAddress == "" {
+		return fmt.Errorf("SIP trunk %q has no outbound address", trunkID)
+	}
+	log.Infow("Dialing SIP participant requested over data channel")
+	p, err := d.api.CreateSIPParticipant(ctx, &livekit.CreateSIPParticipantRequest{
+		SipTrunkId:          trunkID,
+		SipCallTo:           number,
+		RoomName:            roomName,
+		ParticipantIdentity: "sip_" + number + "_" + utils.NewGuid(""), // the same number can be dialed more than once
+		ParticipantName:     number,
+		PlayRingtone:        true,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot create SIP participant: %w", err)
+	}
+	log.Infow("SIP participant created", "participant", p.ParticipantIdentity, "sip-call-id", p.SipCallId)
+	return nil
+}
+
+// dialFromRoom returns a data channel dial handler for the room.
+func (d *dialer) dialFromRoom(log logger.Logger, r *Room) func(sender, number, trunkID string) {
+	if d == nil {
+		return nil
+	}
+	return func(sender, number, trunkID string) {
+		if !d.conf.DataChannelSenderAllowed(sender) {
+			log.Warnw("Ignoring dialstring from unauthorized participant", nil, "sender", sender)
+			return
+		}
+		// Every SIP participant in the room receives the dialstring, but only one of them dials.
+		if !r.isSIPLeader() {
+			return
+		}
+		// Do not block the room callback while waiting for the API.
+		go func() {
+			if err := d.Dial(context.Background(), r.Participant().RoomName, sender, number, trunkID); err != nil {
+				log.Warnw("Cannot dial SIP participant", err, "sender", sender)
+			}
+		}()
+	}
+}
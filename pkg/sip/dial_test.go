@@ -0,0 +1,170 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/stats"
+)
+
+func TestParseDialString(t *testing.T) {
+	cases := []struct {
+		in     string
+		number string
+		trunk  string
+		err    error
+	}{
+		{in: "DIAL:+15551234567@ST_abc", number: "+15551234567", trunk: "ST_abc"},
+		{in: " DIAL:100#@ST_abc\n", number: "100#", trunk: "ST_abc"},
+		{in: "hello", err: errNotDialString},
+		{in: "DIAL:+15551234567", err: errInvalidDialString},
+		{in: "DIAL:@ST_abc", err: errInvalidDialString},
+		{in: "DIAL:555-1234@ST_abc", err: errInvalidDialString},
+		{in: "DIAL:1+5@ST_abc", err: errInvalidDialString},
+	}
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			number, trunk, err := parseDialString(c.in)
+			if c.err != nil {
+				require.ErrorIs(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.number, number)
+			require.Equal(t, c.trunk, trunk)
+		})
+	}
+}
+
+// fakeSIPAPI emulates LiveKit SIP API: created participants join the room.
+type fakeSIPAPI struct {
+	trunks []*livekit.SIPTrunkInfo
+	rooms  chan *livekit.CreateSIPParticipantRequest
+}
+
+func (f *fakeSIPAPI) ListSIPTrunk(ctx context.Context, in *livekit.ListSIPTrunkRequest) (*livekit.ListSIPTrunkResponse, error) {
+	return &livekit.ListSIPTrunkResponse{Items: f.trunks}, nil
+}
+
+func (f *fakeSIPAPI) CreateSIPParticipant(ctx context.Context, in *livekit.CreateSIPParticipantRequest) (*livekit.SIPParticipantInfo, error) {
+	f.rooms <- in
+	return &livekit.SIPParticipantInfo{
+		ParticipantIdentity: in.ParticipantIdentity,
+		RoomName:            in.RoomName,
+		SipCallId:           "SCL_test",
+	}, nil
+}
+
+// newTestInboundCall creates an inbound call the same way as for an INVITE, but without handling it.
+func newTestInboundCall(s *Service, user string) *inboundCall {
+	from := &sip.FromHeader{Address: sip.Uri{User: user, Host: "example.com"}, Params: sip.NewParams()}
+	to := &sip.ToHeader{Address: sip.Uri{User: "bob", Host: "example.com"}}
+	mon := s.srv.mon.NewCall(stats.Inbound, from.Address.String(), to.Address.String())
+	return s.srv.newInboundCall(logger.GetLogger(), mon, "SCL_"+user, user+"-tag", from, to, "")
+}
+
+func TestService_DataChannelDial(t *testing.T) {
+	s, _ := startTestService(t, &config.Config{
+		LiveKitDataChannelDialEnabled: true,
+		LiveKitDataChannelSenders:     []string{"agent"},
+	})
+	api := &fakeSIPAPI{
+		trunks: []*livekit.SIPTrunkInfo{
+			{SipTrunkId: "ST_in", InboundNumbers: []string{"+1000"}},
+			{SipTrunkId: "ST_out", OutboundAddress: "sip.example.com", OutboundNumber: "+1000"},
+		},
+		rooms: make(chan *livekit.CreateSIPParticipantRequest, 10),
+	}
+	s.srv.dial.api = api
+
+	call := newTestInboundCall(s, "alice")
+	call.lkRoom.p.RoomName = "room1"
+	setTestRoomConn(call.lkRoom)
+	conn := call.lkRoom.room.(*testRoomConn)
+	conn.rc.identity = "sip_alice"
+
+	sendFrom := func(sender, text string) {
+		call.lkRoom.handleData(&lksdk.UserDataPacket{Payload: []byte(text)}, lksdk.DataReceiveParams{SenderIdentity: sender})
+	}
+	send := func(text string) {
+		sendFrom("agent", text)
+	}
+	expectNone := func() {
+		select {
+		case req := <-api.rooms:
+			t.Fatal("unexpected call:", req)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	send("DIAL:+15551234567@ST_out")
+	select {
+	case req := <-api.rooms:
+		require.Equal(t, "ST_out", req.SipTrunkId)
+		require.Equal(t, "+15551234567", req.SipCallTo)
+		require.Equal(t, "room1", req.RoomName)
+		require.True(t, strings.HasPrefix(req.ParticipantIdentity, "sip_+15551234567_"), req.ParticipantIdentity)
+	case <-time.After(time.Second):
+		t.Fatal("SIP participant was not created")
+	}
+
+	// Retransmitted packet.
+	send("DIAL:+15551234567@ST_out")
+	expectNone()
+
+	// Participants not listed in the config are not allowed to dial.
+	sendFrom("user", "DIAL:+15557654321@ST_out")
+	sendFrom("", "DIAL:+15557654321@ST_out")
+	expectNone()
+
+	// Only the SIP participant with the lowest identity dials, even if others are on a different node.
+	conn.others = []string{"sip_+1000"}
+	send("DIAL:+15557654321@ST_out")
+	expectNone()
+	conn.others = []string{"sip_bob"}
+	send("DIAL:+15557654321@ST_out")
+	select {
+	case req := <-api.rooms:
+		require.Equal(t, "+15557654321", req.SipCallTo)
+	case <-time.After(time.Second):
+		t.Fatal("SIP participant was not created")
+	}
+
+	// Invalid dialstrings, unknown and inbound-only trunks.
+	send("DIAL:+15551234567")
+	send("DIAL:+15551234567@ST_unknown")
+	send("DIAL:+15551234567@ST_in")
+	call.lkRoom.handleData(&lksdk.UserDataPacket{Topic: MessageTopic, Payload: []byte("DIAL:+1555@ST_out")}, lksdk.DataReceiveParams{})
+	expectNone()
+}
+
+func TestService_DataChannelDialDisabled(t *testing.T) {
+	s, _ := startTestService(t, &config.Config{})
+	require.Nil(t, s.srv.dial)
+	require.Nil(t, s.cli.dial)
+	call := newTestInboundCall(s, "alice")
+	require.Nil(t, call.lkRoom.onDial)
+}
@@ -0,0 +1,272 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/frostbyte73/core"
+)
+
+// poolDialTimeout limits the time spent on dialing a pooled connection.
+const poolDialTimeout = 5 * time.Second
+
+// DialerPool keeps TCP connections to trunk hosts open between outbound calls.
+//
+// The pool dials connections itself and hands them over to the SIP transport through Accept, thus it must be
+// served as a TCP listener of the transport layer. The transport then reuses the connection for all requests
+// sent to the same address. At most one connection is kept per address, because the transport looks them up
+// by the remote address.
+type DialerPool struct {
+	maxConns    int
+	idleTimeout time.Duration
+	addr        net.Addr
+	dial        func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	accept     chan poolHandoff
+	registered chan struct{} // only used by Accept
+	closed     core.Fuse
+
+	mu    sync.Mutex
+	conns map[string]*poolConn
+}
+
+type poolHandoff struct {
+	conn *poolConn
+	done chan struct{}
+}
+
+// NewDialerPool creates a pool of at most maxConns connections. Connections not used for idleTimeout are closed.
+// The port is reported as the listening port of the pool, which the transport uses as the TCP port in Via.
+func NewDialerPool(maxConns int, idleTimeout time.Duration, port int) *DialerPool {
+	var d net.Dialer
+	p := &DialerPool{
+		maxConns:    maxConns,
+		idleTimeout: idleTimeout,
+		addr:        &net.TCPAddr{IP: net.IPv4zero, Port: port},
+		dial:        d.DialContext,
+		accept:      make(chan poolHandoff),
+		conns:       make(map[string]*poolConn),
+	}
+	if idleTimeout > 0 {
+		go p.evictLoop()
+	}
+	return p
+}
+
+// Acquire returns the address of the pooled connection to the given address. The connection is dialed and handed
+// over to the transport if there's none. Requests must be sent to the returned address to use the connection.
+func (p *DialerPool) Acquire(ctx context.Context, addr string) (string, error) {
+	raddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	key := raddr.String()
+	now := time.Now()
+
+	p.mu.Lock()
+	if c := p.conns[key]; c != nil {
+		c.lastUsed = now
+		p.mu.Unlock()
+		return key, nil
+	}
+	p.mu.Unlock()
+
+	conn, err := p.dial(ctx, "tcp", key)
+	if err != nil {
+		return "", err
+	}
+	c := &poolConn{Conn: conn, pool: p, key: key, lastUsed: now, readDone: make(chan struct{})}
+
+	p.mu.Lock()
+	if cur := p.conns[key]; cur != nil {
+		// Dialed concurrently by another call.
+		cur.lastUsed = now
+		p.mu.Unlock()
+		_ = conn.Close()
+		return key, nil
+	}
+	var evicted *poolConn
+	if p.maxConns > 0 && len(p.conns) >= p.maxConns {
+		for _, cur := range p.conns {
+			if evicted == nil || cur.lastUsed.Before(evicted.lastUsed) {
+				evicted = cur
+			}
+		}
+		delete(p.conns, evicted.key)
+	}
+	p.conns[key] = c
+	p.mu.Unlock()
+	if evicted != nil {
+		evicted.close()
+	}
+
+	h := poolHandoff{conn: c, done: make(chan struct{})}
+	select {
+	case p.accept <- h:
+	case <-ctx.Done():
+		p.Evict(key)
+		return "", ctx.Err()
+	case <-p.closed.Watch():
+		_ = c.Conn.Close()
+		return "", net.ErrClosed
+	}
+	// Wait for the transport to register the connection, otherwise it may dial a new one for the request.
+	select {
+	case <-h.done:
+	case <-ctx.Done():
+	case <-p.closed.Watch():
+	}
+	return key, nil
+}
+
+// Evict closes the connection to the address returned by Acquire, so that the next Acquire dials a new one.
+func (p *DialerPool) Evict(key string) {
+	p.mu.Lock()
+	c := p.conns[key]
+	delete(p.conns, key)
+	p.mu.Unlock()
+	if c != nil {
+		c.close()
+	}
+}
+
+// Len returns the number of pooled connections.
+func (p *DialerPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+func (p *DialerPool) evictLoop() {
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closed.Watch():
+			return
+		case <-ticker.C:
+			p.evictIdle(time.Now())
+		}
+	}
+}
+
+func (p *DialerPool) evictIdle(now time.Time) {
+	var idle []*poolConn
+	p.mu.Lock()
+	for key, c := range p.conns {
+		if now.Sub(c.lastUsed) >= p.idleTimeout {
+			idle = append(idle, c)
+			delete(p.conns, key)
+		}
+	}
+	p.mu.Unlock()
+	for _, c := range idle {
+		c.close()
+	}
+}
+
+func (p *DialerPool) remove(c *poolConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns[c.key] == c {
+		delete(p.conns, c.key)
+	}
+}
+
+// Accept hands over the connections dialed by the pool. It implements net.Listener.
+func (p *DialerPool) Accept() (net.Conn, error) {
+	// The transport registers the connection before accepting the next one.
+	if p.registered != nil {
+		close(p.registered)
+		p.registered = nil
+	}
+	select {
+	case h := <-p.accept:
+		p.registered = h.done
+		h.conn.served.Store(true)
+		return h.conn, nil
+	case <-p.closed.Watch():
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes all pooled connections. It implements net.Listener.
+func (p *DialerPool) Close() error {
+	p.closed.Break()
+	p.mu.Lock()
+	conns := p.conns
+	p.conns = make(map[string]*poolConn)
+	p.mu.Unlock()
+	for _, c := range conns {
+		c.close()
+	}
+	return nil
+}
+
+// Addr implements net.Listener.
+func (p *DialerPool) Addr() net.Addr {
+	return p.addr
+}
+
+// poolConn removes itself from the pool once the transport fails to read from it.
+type poolConn struct {
+	net.Conn
+	pool     *DialerPool
+	key      string
+	lastUsed time.Time // protected by the pool mutex
+	served   atomic.Bool
+
+	readOnce sync.Once
+	readDone chan struct{}
+}
+
+func (c *poolConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		c.pool.remove(c)
+		c.readOnce.Do(func() { close(c.readDone) })
+	}
+	return n, err
+}
+
+// close closes the connection, and waits for the transport to stop reading it.
+func (c *poolConn) close() {
+	_ = c.Conn.Close()
+	if !c.served.Load() {
+		return
+	}
+	select {
+	case <-c.readDone:
+	case <-time.After(time.Second):
+	}
+}
+
+// isBrokenPipe checks if the request failed because the connection was closed by the remote.
+func isBrokenPipe(err error) bool {
+	if errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	// The transaction layer doesn't wrap transport errors.
+	s := err.Error()
+	return strings.Contains(s, "broken pipe") || strings.Contains(s, "connection reset") || strings.Contains(s, "use of closed network connection")
+}
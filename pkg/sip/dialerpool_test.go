@@ -0,0 +1,262 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+// countingListener counts accepted connections.
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return c, err
+}
+
+// newTestTCPListener accepts TCP connections and discards the data, until the test ends.
+func newTestTCPListener(t testing.TB) *countingListener {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	cl := &countingListener{Listener: lis}
+	t.Cleanup(func() { _ = lis.Close() })
+	go func() {
+		for {
+			c, err := cl.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(io.Discard, c)
+				_ = c.Close()
+			}()
+		}
+	}()
+	return cl
+}
+
+// serveTestPool accepts connections of the pool the same way the transport does, reading them until they are closed.
+func serveTestPool(p *DialerPool) {
+	go func() {
+		for {
+			c, err := p.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(io.Discard, c)
+			}()
+		}
+	}()
+}
+
+func TestDialerPool(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("reuse", func(t *testing.T) {
+		lis := newTestTCPListener(t)
+		p := NewDialerPool(10, time.Minute, 0)
+		t.Cleanup(func() { _ = p.Close() })
+		serveTestPool(p)
+
+		for i := 0; i < 3; i++ {
+			addr, err := p.Acquire(ctx, lis.Addr().String())
+			require.NoError(t, err)
+			require.Equal(t, lis.Addr().String(), addr)
+		}
+		require.Equal(t, 1, p.Len())
+		require.Eventually(t, func() bool { return lis.accepted.Load() == 1 }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("max conns", func(t *testing.T) {
+		p := NewDialerPool(2, time.Minute, 0)
+		t.Cleanup(func() { _ = p.Close() })
+		serveTestPool(p)
+
+		var addrs []string
+		for i := 0; i < 3; i++ {
+			addr, err := p.Acquire(ctx, newTestTCPListener(t).Addr().String())
+			require.NoError(t, err)
+			addrs = append(addrs, addr)
+		}
+		require.Equal(t, 2, p.Len())
+		p.mu.Lock()
+		_, first := p.conns[addrs[0]]
+		p.mu.Unlock()
+		require.False(t, first, "least recently used connection must be closed")
+	})
+
+	t.Run("idle", func(t *testing.T) {
+		lis := newTestTCPListener(t)
+		p := NewDialerPool(10, 50*time.Millisecond, 0)
+		t.Cleanup(func() { _ = p.Close() })
+		serveTestPool(p)
+
+		_, err := p.Acquire(ctx, lis.Addr().String())
+		require.NoError(t, err)
+		require.Equal(t, 1, p.Len())
+		require.Eventually(t, func() bool { return p.Len() == 0 }, time.Second, 10*time.Millisecond)
+
+		_, err = p.Acquire(ctx, lis.Addr().String())
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return lis.accepted.Load() == 2 }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("remote close", func(t *testing.T) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = lis.Close() })
+		go func() {
+			for {
+				c, err := lis.Accept()
+				if err != nil {
+					return
+				}
+				_ = c.Close()
+			}
+		}()
+		p := NewDialerPool(10, time.Minute, 0)
+		t.Cleanup(func() { _ = p.Close() })
+		serveTestPool(p)
+
+		_, err = p.Acquire(ctx, lis.Addr().String())
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return p.Len() == 0 }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("evict", func(t *testing.T) {
+		lis := newTestTCPListener(t)
+		p := NewDialerPool(10, time.Minute, 0)
+		t.Cleanup(func() { _ = p.Close() })
+		serveTestPool(p)
+
+		addr, err := p.Acquire(ctx, lis.Addr().String())
+		require.NoError(t, err)
+		p.Evict(addr)
+		require.Equal(t, 0, p.Len())
+		_, err = p.Acquire(ctx, lis.Addr().String())
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return lis.accepted.Load() == 2 }, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestIsBrokenPipe(t *testing.T) {
+	require.True(t, isBrokenPipe(fmt.Errorf("write: %w", syscall.EPIPE)))
+	require.True(t, isBrokenPipe(errors.New("conn 10.0.0.1:5060 write err=write tcp: broken pipe. transport error")))
+	require.True(t, isBrokenPipe(errors.New("read tcp: connection reset by peer")))
+	require.False(t, isBrokenPipe(errors.New("transaction timeout")))
+}
+
+func TestOutboundDialerPool(t *testing.T) {
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+	tl, err := net.Listen("tcp", net.JoinHostPort(localIP, "0"))
+	require.NoError(t, err)
+	lis := &countingListener{Listener: tl}
+
+	ua, err := sipgo.NewUA()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ua.Close() })
+	srv, err := sipgo.NewServer(ua)
+	require.NoError(t, err)
+	acks := make(chan struct{}, 1)
+	srv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 486, "Busy Here", nil))
+	})
+	srv.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {
+		acks <- struct{}{}
+	})
+	go func() {
+		_ = srv.ServeTCP(lis)
+	}()
+
+	call := newTestOutboundCall(t, &config.Config{PoolMaxConns: 10, PoolIdleTimeout: time.Minute})
+	for i := 0; i < 3; i++ {
+		to := &sip.Uri{User: "bob", Host: localIP}
+		from := &sip.Uri{User: "alice", Host: localIP}
+		fromHeader := &sip.FromHeader{Address: *from, Params: sip.NewParams()}
+		fromHeader.Params.Add("tag", sip.GenerateTagN(16))
+		req := sip.NewRequest(sip.INVITE, to)
+		req.SetDestination(lis.Addr().String())
+		req.SetTransport("TCP")
+		req.AppendHeader(&sip.ToHeader{Address: *to})
+		req.AppendHeader(fromHeader)
+		req.AppendHeader(&sip.ContactHeader{Address: *from})
+
+		tx, err := call.c.inviteRequest(call.log, req)
+		require.NoError(t, err)
+		resp, err := sipResponse(tx)
+		tx.Terminate()
+		require.NoError(t, err)
+		require.Equal(t, sip.StatusCode(486), resp.StatusCode)
+		// The stream parser of the test UAS only handles one message per read, so don't send the next INVITE
+		// together with the ACK.
+		<-acks
+	}
+	require.EqualValues(t, 1, lis.accepted.Load())
+	require.Equal(t, 1, call.c.tcpPool.Len())
+}
+
+// BenchmarkDialerPool compares the connection setup for 100 sequential calls to the same trunk.
+func BenchmarkDialerPool(b *testing.B) {
+	const calls = 100
+	ctx := context.Background()
+	lis := newTestTCPListener(b)
+	addr := lis.Addr().String()
+
+	b.Run("dial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < calls; j++ {
+				c, err := net.Dial("tcp", addr)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_ = c.Close()
+			}
+		}
+	})
+	b.Run("pool", func(b *testing.B) {
+		p := NewDialerPool(10, time.Minute, 0)
+		defer p.Close()
+		serveTestPool(p)
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < calls; j++ {
+				if _, err := p.Acquire(ctx, addr); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
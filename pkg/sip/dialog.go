@@ -0,0 +1,202 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// dialogKey identifies SIP dialog by its Call-ID and the tag of the remote party.
+type dialogKey struct {
+	sipCallID string
+	tag       string
+}
+
+// dialogInfo describes a SIP dialog bridged to a LiveKit room.
+type dialogInfo struct {
+	CallID   string // LiveKit call ID
+	RoomName string
+	LocalTag string // tag of the bridge
+}
+
+// dialogRegistry tracks SIP dialogs which are currently bridged to LiveKit rooms.
+type dialogRegistry struct {
+	mu      sync.RWMutex
+	dialogs map[dialogKey]dialogInfo
+}
+
+func newDialogRegistry() *dialogRegistry {
+	return &dialogRegistry{dialogs: make(map[dialogKey]dialogInfo)}
+}
+
+func (r *dialogRegistry) Register(sipCallID, tag string, info dialogInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dialogs[dialogKey{sipCallID: sipCallID, tag: tag}] = info
+}
+
+func (r *dialogRegistry) Unregister(sipCallID, tag string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.dialogs, dialogKey{sipCallID: sipCallID, tag: tag})
+}
+
+func (r *dialogRegistry) Lookup(sipCallID, tag string) (dialogInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	info, ok := r.dialogs[dialogKey{sipCallID: sipCallID, tag: tag}]
+	return info, ok
+}
+
+// LookupReplaces finds the dialog referenced by the Replaces header. Call-ID and both tags must match (RFC 3891, section 3).
+func (r *dialogRegistry) LookupReplaces(h *replacesHeader) (dialogInfo, bool) {
+	info, ok := r.Lookup(h.CallID, h.FromTag)
+	if !ok || info.LocalTag != h.ToTag {
+		return dialogInfo{}, false
+	}
+	return info, true
+}
+
+// replacesHeader is a parsed value of the Replaces header (RFC 3891).
+type replacesHeader struct {
+	CallID  string
+	ToTag   string
+	FromTag string
+}
+
+func parseReplaces(val string) (*replacesHeader, error) {
+	parts := strings.Split(val, ";")
+	h := &replacesHeader{CallID: strings.TrimSpace(parts[0])}
+	if h.CallID == "" {
+		return nil, fmt.Errorf("no Call-ID in Replaces header")
+	}
+	for _, p := range parts[1:] {
+		k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+		switch strings.ToLower(k) {
+		case "to-tag":
+			h.ToTag = v
+		case "from-tag":
+			h.FromTag = v
+		}
+	}
+	if h.FromTag == "" {
+		return nil, fmt.Errorf("no from-tag in Replaces header")
+	}
+	if h.ToTag == "" {
+		return nil, fmt.Errorf("no to-tag in Replaces header")
+	}
+	return h, nil
+}
+
+// newUACDialogRequest creates a new request within the dialog established by the INVITE sent by us (RFC 3261, section 12.2.1.1).
+func newUACDialogRequest(method sip.RequestMethod, inviteReq *sip.Request, inviteResp *sip.Response, cseq uint32, body []byte) *sip.Request {
+	target := *inviteReq.Recipient
+	if contact, ok := inviteResp.Contact(); ok {
+		target = contact.Address
+	}
+	req := sip.NewRequest(method, &target)
+	req.SipVersion = inviteReq.SipVersion
+	// Route set is taken from Record-Route of the response in reverse order.
+	var routes []sip.Uri
+	for _, h := range inviteResp.GetHeaders("Record-Route") {
+		for rr, _ := h.(*sip.RecordRouteHeader); rr != nil; rr = rr.Next {
+			routes = append(routes, rr.Address)
+		}
+	}
+	for i := len(routes) - 1; i >= 0; i-- {
+		req.AppendHeader(&sip.RouteHeader{Address: routes[i]})
+	}
+	maxForwards := sip.MaxForwardsHeader(70)
+	req.AppendHeader(&maxForwards)
+	if h, ok := inviteReq.From(); ok {
+		req.AppendHeader(sip.HeaderClone(h))
+	}
+	if h, ok := inviteResp.To(); ok {
+		req.AppendHeader(sip.HeaderClone(h))
+	}
+	if h, ok := inviteReq.CallID(); ok {
+		req.AppendHeader(sip.HeaderClone(h))
+	}
+	req.AppendHeader(&sip.CSeqHeader{SeqNo: cseq, MethodName: method})
+	req.SetBody(body)
+	req.SetTransport(inviteReq.Transport())
+	req.SetDestination(inviteReq.Destination())
+	return req
+}
+
+// headerTag returns the tag parameter of the From or To header value.
+func headerTag(params sip.HeaderParams) string {
+	if params == nil {
+		return ""
+	}
+	tag, _ := params.Get("tag")
+	return tag
+}
+
+// dialogCall returns an active outbound call for an in-dialog request sent by the callee.
+// The request must match the Call-ID and both tags of the call dialog.
+func (c *Client) dialogCall(req *sip.Request) *outboundCall {
+	callID, ok := req.CallID()
+	if !ok {
+		return nil
+	}
+	from, ok := req.From()
+	if !ok {
+		return nil
+	}
+	to, ok := req.To()
+	if !ok {
+		return nil
+	}
+	remoteTag, localTag := headerTag(from.Params), headerTag(to.Params)
+	if remoteTag == "" || localTag == "" {
+		return nil
+	}
+	c.cmu.Lock()
+	calls := make([]*outboundCall, 0, len(c.activeCalls))
+	for cl := range c.activeCalls {
+		calls = append(calls, cl)
+	}
+	c.cmu.Unlock()
+	for _, cl := range calls {
+		if cl.matchDialog(callID.Value(), localTag, remoteTag) {
+			return cl
+		}
+	}
+	return nil
+}
+
+// matchDialog checks if the call dialog has a given Call-ID, local tag (From of our INVITE) and remote tag (To of the response).
+func (c *outboundCall) matchDialog(callID, localTag, remoteTag string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.sipInviteReq == nil || c.sipInviteResp == nil {
+		return false
+	}
+	id, ok := c.sipInviteReq.CallID()
+	if !ok || id.Value() != callID {
+		return false
+	}
+	from, ok := c.sipInviteReq.From()
+	if !ok || headerTag(from.Params) != localTag {
+		return false
+	}
+	to, ok := c.sipInviteResp.To()
+	return ok && headerTag(to.Params) == remoteTag
+}
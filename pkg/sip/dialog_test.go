@@ -0,0 +1,114 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"testing"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReplaces(t *testing.T) {
+	cases := []struct {
+		name string
+		val  string
+		exp  *replacesHeader
+	}{
+		{
+			name: "rfc",
+			val:  "425928@bobster.example.org;to-tag=7743;from-tag=6472",
+			exp:  &replacesHeader{CallID: "425928@bobster.example.org", ToTag: "7743", FromTag: "6472"},
+		},
+		{
+			name: "spaces and early-only",
+			val:  "425928@bobster.example.org; from-tag=6472 ;to-tag=7743;early-only",
+			exp:  &replacesHeader{CallID: "425928@bobster.example.org", ToTag: "7743", FromTag: "6472"},
+		},
+		{
+			name: "no from tag",
+			val:  "425928@bobster.example.org;to-tag=7743",
+		},
+		{
+			name: "no to tag",
+			val:  "425928@bobster.example.org;from-tag=6472",
+		},
+		{
+			name: "empty",
+			val:  "",
+		},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			h, err := parseReplaces(c.val)
+			if c.exp == nil {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.exp, h)
+		})
+	}
+}
+
+func TestUACDialogRequest(t *testing.T) {
+	inviteReq := sip.NewRequest(sip.INVITE, &sip.Uri{User: "bob", Host: "proxy.example.com"})
+	from := &sip.FromHeader{Address: sip.Uri{User: "alice", Host: "example.com"}, Params: sip.NewParams()}
+	from.Params.Add("tag", "alice-tag")
+	inviteReq.AppendHeader(from)
+	inviteReq.AppendHeader(&sip.ToHeader{Address: sip.Uri{User: "bob", Host: "example.com"}, Params: sip.NewParams()})
+	callID := sip.CallIDHeader("call@example.com")
+	inviteReq.AppendHeader(&callID)
+	inviteReq.AppendHeader(&sip.CSeqHeader{SeqNo: 1, MethodName: sip.INVITE})
+	inviteReq.SetDestination("10.0.0.1:5060")
+
+	inviteResp := sip.NewResponseFromRequest(inviteReq, 200, "OK", nil)
+	if h, ok := inviteResp.To(); ok {
+		h.Params.Add("tag", "bob-tag")
+	}
+	inviteResp.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: "bob", Host: "10.0.0.2", Port: 5070}})
+	inviteResp.AppendHeader(&sip.RecordRouteHeader{Address: sip.Uri{Host: "p1.example.com"}})
+	inviteResp.AppendHeader(&sip.RecordRouteHeader{Address: sip.Uri{Host: "p2.example.com"}})
+
+	req := newUACDialogRequest(sip.MESSAGE, inviteReq, inviteResp, 5, []byte("hi"))
+	require.Equal(t, sip.MESSAGE, req.Method)
+	require.Equal(t, "10.0.0.2", req.Recipient.Host)
+	require.Equal(t, 5070, req.Recipient.Port)
+	require.Equal(t, "10.0.0.1:5060", req.Destination())
+
+	var routes []string
+	for _, h := range req.GetHeaders("Route") {
+		routes = append(routes, h.(*sip.RouteHeader).Address.Host)
+	}
+	require.Equal(t, []string{"p2.example.com", "p1.example.com"}, routes)
+
+	reqFrom, ok := req.From()
+	require.True(t, ok)
+	tag, _ := reqFrom.Params.Get("tag")
+	require.Equal(t, "alice-tag", tag)
+	reqTo, ok := req.To()
+	require.True(t, ok)
+	tag, _ = reqTo.Params.Get("tag")
+	require.Equal(t, "bob-tag", tag)
+	reqCallID, ok := req.CallID()
+	require.True(t, ok)
+	require.Equal(t, "call@example.com", reqCallID.Value())
+	cseq, ok := req.CSeq()
+	require.True(t, ok)
+	require.Equal(t, uint32(5), cseq.SeqNo)
+	require.Equal(t, sip.MESSAGE, cseq.MethodName)
+	require.Equal(t, []byte("hi"), req.Body())
+}
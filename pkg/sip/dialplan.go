@@ -0,0 +1,55 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"regexp"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+// dialPlan normalizes the numbers called by outbound calls, as set in dial_plan.
+type dialPlan []dialPlanRule
+
+type dialPlanRule struct {
+	match   *regexp.Regexp
+	replace string
+	trunk   string // all trunks if empty
+}
+
+func newDialPlan(rules []config.DialPlanRule) (dialPlan, error) {
+	var p dialPlan
+	for _, r := range rules {
+		re, err := r.Compile()
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, dialPlanRule{match: re, replace: r.Replace, trunk: r.Trunk})
+	}
+	return p, nil
+}
+
+// Normalize applies the first rule of the trunk which matches the number. The number is returned as is if none match.
+func (p dialPlan) Normalize(trunkID, number string) string {
+	for _, r := range p {
+		if r.trunk != "" && r.trunk != trunkID {
+			continue
+		}
+		if r.match.MatchString(number) {
+			return r.match.ReplaceAllString(number, r.replace)
+		}
+	}
+	return number
+}
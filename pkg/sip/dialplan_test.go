@@ -0,0 +1,67 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+func TestDialPlan(t *testing.T) {
+	p, err := newDialPlan([]config.DialPlanRule{
+		// Separators are removed from E.164 numbers.
+		{Match: `\+1[-. ]?(\d{3})[-. ]?(\d{3})[-. ]?(\d{4})`, Replace: "+1$1$2$3"},
+		// International prefix of the trunk in Europe.
+		{Match: `00(\d+)`, Replace: "+$1", Trunk: "ST_eu"},
+		// National and local numbers in the US.
+		{Match: `(?:00|011)1(\d{10})`, Replace: "+1$1"},
+		{Match: `1?(\d{10})`, Replace: "+1$1"},
+		{Match: `(?P<local>\d{7})`, Replace: "+1415${local}"},
+		{Match: `(\d{3})-(\d{4})`, Replace: "+1415$1$2"},
+	})
+	require.NoError(t, err)
+	for _, c := range []struct {
+		trunk  string
+		number string
+		exp    string
+	}{
+		{number: "+14155551234", exp: "+14155551234"},
+		{number: "+1-415-555-1234", exp: "+14155551234"},
+		{number: "+1 415 555 1234", exp: "+14155551234"},
+		{number: "0014155551234", exp: "+14155551234"},
+		{number: "01114155551234", exp: "+14155551234"},
+		{trunk: "ST_eu", number: "00442071234567", exp: "+442071234567"},
+		{number: "00442071234567", exp: "00442071234567"},
+		{number: "14155551234", exp: "+14155551234"},
+		{number: "4155551234", exp: "+14155551234"},
+		{number: "5551234", exp: "+14155551234"},
+		{number: "555-1234", exp: "+14155551234"},
+		// The whole number must match.
+		{number: "55512345", exp: "55512345"},
+		{number: "ext-5551234", exp: "ext-5551234"},
+	} {
+		t.Run(c.trunk+"/"+c.number, func(t *testing.T) {
+			require.Equal(t, c.exp, p.Normalize(c.trunk, c.number))
+		})
+	}
+
+	var empty dialPlan
+	require.Equal(t, "5551234", empty.Normalize("", "5551234"))
+	_, err = newDialPlan([]config.DialPlanRule{{Match: "("}})
+	require.Error(t, err)
+}
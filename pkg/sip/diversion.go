@@ -0,0 +1,109 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/emiago/sipgo/parser"
+	"github.com/emiago/sipgo/sip"
+)
+
+// Diversion is a single entry of the Diversion header (RFC 5806), which describes one call forwarding hop.
+type Diversion struct {
+	Value   string // entry as received
+	URI     string // URI of the party which forwarded the call
+	User    string // number which the call was originally placed to at this hop
+	Reason  string // e.g. "unconditional", "user-busy", "no-answer"
+	Counter int    // number of diversions represented by the entry; 1 if not set
+}
+
+// parseDiversion returns all entries of Diversion headers of the request. The most recent diversion comes first.
+// Entries which cannot be parsed or have no user part are skipped.
+func parseDiversion(req *sip.Request) []Diversion {
+	var out []Diversion
+	for _, h := range req.GetHeaders("Diversion") {
+		for _, v := range splitHeaderValues(h.Value()) {
+			var uri sip.Uri
+			params := sip.NewParams()
+			if _, err := parser.ParseAddressValue(v, &uri, params); err != nil {
+				continue
+			}
+			d := Diversion{Value: v, URI: uri.String(), User: uri.User, Counter: 1}
+			if raw := addressURI(v); strings.HasPrefix(strings.ToLower(raw), "tel:") {
+				// Parser only supports SIP URIs.
+				d.URI = raw
+				d.User, _, _ = strings.Cut(raw[len("tel:"):], ";")
+			}
+			if d.User == "" {
+				continue
+			}
+			d.Reason, _ = params.Get("reason")
+			d.Reason = strings.Trim(d.Reason, `"`)
+			if s, ok := params.Get("counter"); ok {
+				if n, err := strconv.Atoi(s); err == nil && n > 0 {
+					d.Counter = n
+				}
+			}
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// addressURI returns the URI part of the name-addr or addr-spec.
+func addressURI(v string) string {
+	if i := strings.IndexByte(v, '<'); i >= 0 {
+		v = v[i+1:]
+		v, _, _ = strings.Cut(v, ">")
+		return v
+	}
+	v, _, _ = strings.Cut(v, ";")
+	return strings.TrimSpace(v)
+}
+
+// splitHeaderValues splits a comma-separated header value. Commas in quoted strings and in URIs are ignored.
+func splitHeaderValues(v string) []string {
+	var (
+		out    []string
+		quoted bool
+		inURI  bool
+		start  int
+	)
+	add := func(s string) {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '<':
+			inURI = true
+		case c == '>':
+			inURI = false
+		case c == ',' && !inURI:
+			add(v[start:i])
+			start = i + 1
+		}
+	}
+	add(v[start:])
+	return out
+}
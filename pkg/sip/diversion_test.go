@@ -0,0 +1,48 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"testing"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitHeaderValues(t *testing.T) {
+	require.Equal(t, []string{
+		`"Doe, John" <sip:a@example.com>;reason=x`,
+		`<sip:b@example.com;maddr=a,b>`,
+		`sip:c@example.com`,
+	}, splitHeaderValues(`"Doe, John" <sip:a@example.com>;reason=x , <sip:b@example.com;maddr=a,b>,, sip:c@example.com`))
+	require.Empty(t, splitHeaderValues(" "))
+}
+
+func TestParseDiversion(t *testing.T) {
+	req := sip.NewRequest(sip.INVITE, &sip.Uri{User: "to", Host: "example.com"})
+	require.Empty(t, parseDiversion(req))
+
+	req.AppendHeader(sip.NewHeader("Diversion", `<sip:+15550001@example.com>;reason=unconditional;counter=3, <sip:@>`))
+	req.AppendHeader(sip.NewHeader("Diversion", `sip:+15550000@example.com`))
+	got := parseDiversion(req)
+	require.Len(t, got, 2)
+	require.Equal(t, "+15550001", got[0].User)
+	require.Equal(t, "unconditional", got[0].Reason)
+	require.Equal(t, 3, got[0].Counter)
+	require.Equal(t, `<sip:+15550001@example.com>;reason=unconditional;counter=3`, got[0].Value)
+	require.Equal(t, "+15550000", got[1].User)
+	require.Equal(t, "", got[1].Reason)
+	require.Equal(t, 1, got[1].Counter)
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+// hostResolver looks up addresses of a host. It's implemented by net.Resolver.
+type hostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// trunkTargets resolves destinations of the trunk, according to its dns_load_balance_mode. Targets are tried in order,
+// moving to the next one if the current target is unreachable. It returns nil if the address must be used as-is.
+func (c *Client) trunkTargets(trunkID, address string) []sipTarget {
+	mode := config.DNSLoadBalanceWeightedSRV
+	if c.conf != nil {
+		mode = c.conf.GetDNSLoadBalanceMode(trunkID)
+	}
+	switch mode {
+	case config.DNSLoadBalanceFirst:
+		return nil
+	case config.DNSLoadBalanceRoundRobin, config.DNSLoadBalanceRandom:
+		return c.hostTargets(address, mode)
+	default:
+		return c.srvTargets(address)
+	}
+}
+
+// hostTargets resolves A records of the trunk host, and orders them for the round_robin or random mode.
+// Requests keep the port of the trunk address.
+func (c *Client) hostTargets(address string, mode config.DNSLoadBalanceMode) []sipTarget {
+	if c.hosts == nil {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, "5060"
+	}
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), srvLookupTimeout)
+	defer cancel()
+	addrs, err := c.hosts.LookupHost(ctx, host)
+	if err != nil {
+		c.log.Warnw("Cannot look up trunk host", err, "host", host)
+		return nil
+	} else if len(addrs) == 0 {
+		return nil
+	}
+	// Resolvers may rotate records themselves, so the order must not depend on them.
+	addrs = slices.Clone(addrs)
+	slices.Sort(addrs)
+	if mode == config.DNSLoadBalanceRandom {
+		rand.Shuffle(len(addrs), func(i, j int) {
+			addrs[i], addrs[j] = addrs[j], addrs[i]
+		})
+	} else {
+		i := c.dnsRR.Next(host, len(addrs))
+		addrs = append(addrs[i:], addrs[:i]...)
+	}
+	targets := make([]sipTarget, 0, len(addrs))
+	for _, a := range addrs {
+		targets = append(targets, sipTarget{addr: net.JoinHostPort(a, port)})
+	}
+	return targets
+}
+
+// dnsRoundRobin keeps the index of the first address for the next call, per trunk host. Zero value is ready to use.
+type dnsRoundRobin struct {
+	mu   sync.Mutex
+	next map[string]int
+}
+
+// Next returns the index of the first address for a call to the host with n addresses, and advances it.
+func (rr *dnsRoundRobin) Next(host string, n int) int {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if rr.next == nil {
+		rr.next = make(map[string]int)
+	}
+	i := rr.next[host] % n
+	rr.next[host] = (i + 1) % n
+	return i
+}
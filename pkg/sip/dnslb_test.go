@@ -0,0 +1,173 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/livekit/protocol/logger"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+// testHostResolver returns fixed addresses for each host.
+type testHostResolver struct {
+	hosts   map[string][]string
+	lookups atomic.Int32
+}
+
+func (r *testHostResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.lookups.Add(1)
+	addrs, ok := r.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func targetAddrs(targets []sipTarget) []string {
+	var out []string
+	for _, t := range targets {
+		out = append(out, t.addr)
+	}
+	return out
+}
+
+func TestTrunkTargetsLoadBalance(t *testing.T) {
+	hosts := &testHostResolver{hosts: map[string][]string{
+		"trunk.example.com": {"10.0.0.3", "10.0.0.1", "10.0.0.2"},
+	}}
+	srv := &testSRVResolver{records: map[string][]srvRecord{
+		"_sip._udp.trunk.example.com": {{Target: "sip.example.com.", Port: 5080, TTL: time.Minute}},
+	}}
+	newClient := func(mode config.DNSLoadBalanceMode) *Client {
+		return &Client{
+			conf:  &config.Config{DNSLoadBalanceMode: map[string]config.DNSLoadBalanceMode{"ST_a": mode}},
+			log:   logger.GetLogger(),
+			srv:   srv,
+			hosts: hosts,
+		}
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		c := newClient(config.DNSLoadBalanceRoundRobin)
+		// Each call starts with the next address, and fails over to the following ones.
+		for i := 0; i < 2; i++ {
+			require.Equal(t, []string{"10.0.0.1:5060", "10.0.0.2:5060", "10.0.0.3:5060"}, targetAddrs(c.trunkTargets("ST_a", "trunk.example.com")))
+			require.Equal(t, []string{"10.0.0.2:5060", "10.0.0.3:5060", "10.0.0.1:5060"}, targetAddrs(c.trunkTargets("ST_a", "trunk.example.com")))
+			require.Equal(t, []string{"10.0.0.3:5060", "10.0.0.1:5060", "10.0.0.2:5060"}, targetAddrs(c.trunkTargets("ST_a", "trunk.example.com")))
+		}
+		// Port of the trunk is kept, and the transport is left to the trunk address.
+		require.Equal(t, []sipTarget{
+			{addr: "10.0.0.1:5070"}, {addr: "10.0.0.2:5070"}, {addr: "10.0.0.3:5070"},
+		}, c.trunkTargets("ST_a", "trunk.example.com:5070"))
+
+		// Addresses are used as-is if the host can't be resolved.
+		require.Nil(t, c.trunkTargets("ST_a", "10.0.0.1"))
+		require.Nil(t, c.trunkTargets("ST_a", "other.example.com"))
+	})
+
+	t.Run("random", func(t *testing.T) {
+		c := newClient(config.DNSLoadBalanceRandom)
+		first := make(map[string]int)
+		for i := 0; i < 300; i++ {
+			targets := targetAddrs(c.trunkTargets("ST_a", "trunk.example.com"))
+			require.ElementsMatch(t, []string{"10.0.0.1:5060", "10.0.0.2:5060", "10.0.0.3:5060"}, targets)
+			first[targets[0]]++
+		}
+		require.Len(t, first, 3)
+		for addr, n := range first {
+			require.Greater(t, n, 50, addr)
+		}
+	})
+
+	t.Run("first", func(t *testing.T) {
+		c := newClient(config.DNSLoadBalanceFirst)
+		require.Nil(t, c.trunkTargets("ST_a", "trunk.example.com"))
+	})
+
+	t.Run("weighted srv", func(t *testing.T) {
+		c := newClient(config.DNSLoadBalanceWeightedSRV)
+		require.Equal(t, []sipTarget{{addr: "sip.example.com:5080", transport: "UDP"}}, c.trunkTargets("ST_a", "trunk.example.com"))
+		// Default for trunks without the mode.
+		require.Equal(t, []sipTarget{{addr: "sip.example.com:5080", transport: "UDP"}}, c.trunkTargets("ST_b", "trunk.example.com"))
+	})
+}
+
+// newTestUASOn starts a test UAS on a given UDP address, which responds to INVITEs with a given status.
+func newTestUASOn(t *testing.T, addr string, status sip.StatusCode, invites chan<- string) {
+	conn, err := net.ListenPacket("udp", addr)
+	require.NoError(t, err)
+	ua, err := sipgo.NewUA()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ua.Close() })
+	srv, err := sipgo.NewServer(ua)
+	require.NoError(t, err)
+	srv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+		invites <- addr
+		_ = tx.Respond(sip.NewResponseFromRequest(req, status, "", nil))
+	})
+	srv.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {})
+	go func() {
+		_ = srv.ServeUDP(conn)
+	}()
+}
+
+func TestOutboundLoadBalanceFailover(t *testing.T) {
+	// All addresses of the trunk host use the same port.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+	_ = conn.Close()
+
+	invites := make(chan string, 10)
+	newTestUASOn(t, "127.0.0.1:"+port, 200, invites)
+	newTestUASOn(t, "127.0.0.2:"+port, 503, invites)
+	newTestUASOn(t, "127.0.0.3:"+port, 200, invites)
+
+	retries := 0
+	call := newTestOutboundCall(t, &config.Config{
+		OutboundRetryCount: &retries,
+		DNSLoadBalanceMode: map[string]config.DNSLoadBalanceMode{"ST_a": config.DNSLoadBalanceRoundRobin},
+	})
+	call.c.hosts = &testHostResolver{hosts: map[string][]string{
+		"trunk.example.com": {"127.0.0.1", "127.0.0.2", "127.0.0.3"},
+	}}
+	conf := sipOutboundConfig{trunkID: "ST_a", address: "trunk.example.com:" + port, from: "from", to: "to"}
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		_, resp, err := call.sipInvite(nil, conf)
+		require.NoError(t, err)
+		require.Equal(t, sip.StatusCode(200), resp.StatusCode)
+		for len(invites) > 0 {
+			got = append(got, <-invites)
+		}
+	}
+	// Unavailable address is skipped without a retry, and the next call starts with the address after it.
+	require.Equal(t, []string{
+		"127.0.0.1:" + port,
+		"127.0.0.2:" + port, "127.0.0.3:" + port,
+		"127.0.0.3:" + port,
+	}, got)
+}
@@ -0,0 +1,42 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"encoding/json"
+
+	lksdk "github.com/livekit/server-sdk-go/v2"
+
+	"github.com/livekit/sip/pkg/media/dtmf"
+	"github.com/livekit/sip/pkg/media/rtp"
+)
+
+// DTMFMessage is published to the room as JSON on the topic set by dtmf_data_channel_topic
+// for each DTMF digit received from the SIP participant.
+type DTMFMessage struct {
+	Digit      string `json:"digit"`
+	CallID     string `json:"call_id"`
+	DurationMs int    `json:"duration_ms"`
+}
+
+func dtmfData(topic, callID string, ev dtmf.Event) *lksdk.UserDataPacket {
+	data, _ := json.Marshal(DTMFMessage{
+		Digit:  string([]byte{ev.Digit}),
+		CallID: callID,
+		// Both RTP events and detected tones use the timestamp units of the audio.
+		DurationMs: int(ev.Dur) * 1000 / rtp.DefSampleRate,
+	})
+	return &lksdk.UserDataPacket{Payload: data, Topic: topic}
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/livekit/protocol/livekit"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+	prtp "github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/dtmf"
+	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/media/tones"
+)
+
+// expectDTMFMessage skips SIP DTMF packets and returns the next DTMF message published on the topic.
+func expectDTMFMessage(t *testing.T, data <-chan lksdk.DataPacket, topic string) DTMFMessage {
+	t.Helper()
+	for {
+		select {
+		case p := <-data:
+			switch p := p.(type) {
+			case *livekit.SipDTMF:
+				continue
+			case *lksdk.UserDataPacket:
+				require.Equal(t, topic, p.Topic)
+				var msg DTMFMessage
+				require.NoError(t, json.Unmarshal(p.Payload, &msg))
+				return msg
+			default:
+				t.Fatalf("unexpected data packet: %T", p)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no DTMF message published")
+		}
+	}
+}
+
+func TestService_DTMFData(t *testing.T) {
+	const topic = "dtmf-test"
+	s, _ := startTestService(t, &config.Config{DTMFDataChannelTopic: topic})
+	call := addTestCall(s, "alice", "alice-tag")
+	call.forwardDTMF.Store(true)
+	data := setTestRoomConn(call.lkRoom)
+
+	t.Run("rfc4733", func(t *testing.T) {
+		payload := make([]byte, 4)
+		_, err := dtmf.Encode(payload, dtmf.Event{Code: 5, Digit: '5', Dur: 800, End: true})
+		require.NoError(t, err)
+		require.NoError(t, call.handleDTMF(&prtp.Packet{Header: prtp.Header{Marker: true}, Payload: payload}))
+		msg := expectDTMFMessage(t, data, topic)
+		require.Equal(t, DTMFMessage{Digit: "5", CallID: call.id, DurationMs: 100}, msg)
+	})
+	t.Run("inband", func(t *testing.T) {
+		d := dtmf.NewDetector(rtp.DefSampleRate, call.onDTMF)
+		frame := make(media.PCM16Sample, rtp.DefPacketDur)
+		_, freq := dtmf.Tone('#')
+		for ts := time.Duration(0); ts < 100*time.Millisecond; ts += rtp.DefFrameDur {
+			tones.Generate(frame, ts, rtp.DefFrameDur, 0x4000, freq)
+			require.NoError(t, d.WriteSample(frame))
+		}
+		msg := expectDTMFMessage(t, data, topic)
+		require.Equal(t, "#", msg.Digit)
+		require.Equal(t, call.id, msg.CallID)
+		require.Positive(t, msg.DurationMs)
+	})
+}
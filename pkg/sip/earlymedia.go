@@ -0,0 +1,148 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/livekit/protocol/logger"
+	"github.com/pion/sdp/v2"
+
+	"github.com/livekit/sip/pkg/media/rtp"
+)
+
+// earlyMediaState tells which audio the room hears while the outbound call is ringing.
+type earlyMediaState string
+
+const (
+	earlyMediaLocalRingback earlyMediaState = "local_ringback"      // ringback generated by us, if enabled
+	earlyMediaFarEnd        earlyMediaState = "far_end_early_media" // early media sent by the far end
+	earlyMediaConnected     earlyMediaState = "connected"           // call is answered
+)
+
+// pEarlyMediaFromFarEnd checks if the P-Early-Media header of the response authorizes early media sent by the far end
+// (RFC 5009). Directions are listed per media line, and only the first one is used for audio.
+func pEarlyMediaFromFarEnd(res *sip.Response) bool {
+	h := res.GetHeader("P-Early-Media")
+	if h == nil {
+		return false
+	}
+	dir, _, _ := strings.Cut(h.Value(), ",")
+	switch strings.ToLower(strings.TrimSpace(dir)) {
+	case "sendrecv", "sendonly":
+		return true
+	}
+	return false
+}
+
+// earlyMedia switches the audio sent to the room between the local ringback and the far-end early media,
+// until the call is connected.
+type earlyMedia struct {
+	log      logger.Logger
+	ringback func(ctx context.Context) // plays the local ringback until the context is cancelled; optional
+	farEnd   func(sdp []byte) error    // passes the far-end early media described by SDP to the room
+
+	mu    sync.Mutex
+	state earlyMediaState
+	stop  context.CancelFunc // stops the local ringback
+}
+
+func newEarlyMedia(log logger.Logger, ringback func(ctx context.Context), farEnd func(sdp []byte) error) *earlyMedia {
+	m := &earlyMedia{log: log, ringback: ringback, farEnd: farEnd, state: earlyMediaLocalRingback}
+	if ringback != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		m.stop = cancel
+		go ringback(ctx)
+	}
+	return m
+}
+
+// State returns the current state.
+func (m *earlyMedia) State() earlyMediaState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+func (m *earlyMedia) setState(state earlyMediaState) {
+	if m.state == state {
+		return
+	}
+	m.log.Infow("Early media state changed", "from", m.state, "to", state)
+	m.state = state
+	if m.stop != nil {
+		m.stop()
+		m.stop = nil
+	}
+}
+
+// Provisional switches to the far-end early media, if the provisional response authorizes it with P-Early-Media
+// and carries SDP. Local ringback is kept if the early media can't be used.
+func (m *earlyMedia) Provisional(res *sip.Response, body []byte) {
+	if !pEarlyMediaFromFarEnd(res) || len(body) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state != earlyMediaLocalRingback {
+		return
+	}
+	if err := m.farEnd(body); err != nil {
+		m.log.Warnw("Cannot use early media of the far end", err)
+		return
+	}
+	m.setState(earlyMediaFarEnd)
+}
+
+// Connected stops the early media when the call is answered.
+func (m *earlyMedia) Connected() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setState(earlyMediaConnected)
+}
+
+// Close stops the local ringback. It doesn't change the state.
+func (m *earlyMedia) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop != nil {
+		m.stop()
+		m.stop = nil
+	}
+}
+
+// linkEarlyMedia passes audio of the far-end early media described by the SDP answer to the room.
+// The media is linked again when the call is answered.
+func (c *outboundCall) linkEarlyMedia(trunkID string, body []byte) error {
+	answer := sdp.SessionDescription{}
+	if err := answer.Unmarshal(body); err != nil {
+		return err
+	}
+	res, err := sdpGetAudioCodecWith(answer, c.c.conf.CodecPreference[trunkID])
+	if err != nil {
+		return err
+	}
+	if dst := sdpGetAudioDest(answer); dst != nil {
+		c.rtpConn.SetDestAddr(dst)
+	}
+	mux := rtp.NewMux(nil)
+	mux.Register(res.AudioType, decodeAudio(res.Audio, res.AudioType, c.lkRoomIn))
+	c.rtpConn.OnRTP(mux)
+	c.log.Infow("Using far-end early media", "audio-codec", res.Audio.Info().SDPName, "audio-rtp", res.AudioType)
+	return nil
+}
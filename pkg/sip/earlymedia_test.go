@@ -0,0 +1,209 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/livekit/protocol/logger"
+	prtp "github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/rtp"
+	lksdp "github.com/livekit/sip/pkg/media/sdp"
+	"github.com/livekit/sip/pkg/media/ulaw"
+)
+
+func newTestProvisional(code sip.StatusCode, earlyMedia string) *sip.Response {
+	res := sip.NewResponse(code, "")
+	if earlyMedia != "" {
+		res.AppendHeader(sip.NewHeader("P-Early-Media", earlyMedia))
+	}
+	return res
+}
+
+func TestPEarlyMedia(t *testing.T) {
+	for _, c := range []struct {
+		header string
+		exp    bool
+	}{
+		{"", false},
+		{"sendrecv", true},
+		{"SendOnly", true},
+		{"recvonly", false},
+		{"inactive", false},
+		{"gated", false},
+		{"supported", false},
+		{"sendrecv, inactive", true},
+		{"inactive, sendrecv", false},
+	} {
+		require.Equal(t, c.exp, pEarlyMediaFromFarEnd(newTestProvisional(183, c.header)), c.header)
+	}
+}
+
+// testRingback records the state of the local ringback.
+type testRingback struct {
+	started chan struct{}
+	stopped chan struct{}
+}
+
+func newTestRingback() *testRingback {
+	return &testRingback{started: make(chan struct{}), stopped: make(chan struct{})}
+}
+
+func (r *testRingback) Play(ctx context.Context) {
+	close(r.started)
+	<-ctx.Done()
+	close(r.stopped)
+}
+
+func (r *testRingback) requireStopped(t *testing.T) {
+	t.Helper()
+	select {
+	case <-r.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("ringback is not stopped")
+	}
+}
+
+func TestEarlyMedia(t *testing.T) {
+	t.Run("far end", func(t *testing.T) {
+		rb := newTestRingback()
+		var bodies []string
+		m := newEarlyMedia(logger.GetLogger(), rb.Play, func(sdp []byte) error {
+			bodies = append(bodies, string(sdp))
+			return nil
+		})
+		<-rb.started
+		require.Equal(t, earlyMediaLocalRingback, m.State())
+
+		// Early media is not authorized or not described.
+		m.Provisional(newTestProvisional(180, ""), []byte("sdp"))
+		m.Provisional(newTestProvisional(183, "inactive"), []byte("sdp"))
+		m.Provisional(newTestProvisional(183, "sendrecv"), nil)
+		require.Equal(t, earlyMediaLocalRingback, m.State())
+		require.Empty(t, bodies)
+
+		m.Provisional(newTestProvisional(183, "sendrecv"), []byte("sdp"))
+		require.Equal(t, earlyMediaFarEnd, m.State())
+		require.Equal(t, []string{"sdp"}, bodies)
+		rb.requireStopped(t)
+
+		// Far-end media is linked once.
+		m.Provisional(newTestProvisional(183, "sendrecv"), []byte("sdp2"))
+		require.Equal(t, []string{"sdp"}, bodies)
+
+		m.Connected()
+		require.Equal(t, earlyMediaConnected, m.State())
+		m.Close()
+	})
+	t.Run("connected", func(t *testing.T) {
+		rb := newTestRingback()
+		m := newEarlyMedia(logger.GetLogger(), rb.Play, func(sdp []byte) error {
+			return nil
+		})
+		<-rb.started
+		m.Connected()
+		require.Equal(t, earlyMediaConnected, m.State())
+		rb.requireStopped(t)
+
+		// Early media is ignored after the call is answered.
+		m.Provisional(newTestProvisional(183, "sendrecv"), []byte("sdp"))
+		require.Equal(t, earlyMediaConnected, m.State())
+	})
+	t.Run("no ringback", func(t *testing.T) {
+		m := newEarlyMedia(logger.GetLogger(), nil, func(sdp []byte) error {
+			return nil
+		})
+		m.Provisional(newTestProvisional(183, "sendonly"), []byte("sdp"))
+		require.Equal(t, earlyMediaFarEnd, m.State())
+		m.Close()
+	})
+}
+
+func TestOutboundEarlyMedia(t *testing.T) {
+	call := newTestOutboundCall(t, &config.Config{})
+	call.rtpConn = rtp.NewConn(nil)
+	require.NoError(t, call.rtpConn.ListenAndServe(0, 0, "0.0.0.0"))
+	t.Cleanup(func() { _ = call.rtpConn.Close() })
+	received := make(chan struct{}, 1)
+	call.lkRoomIn = media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	rb := newTestRingback()
+	call.early = newEarlyMedia(call.log, rb.Play, func(body []byte) error {
+		return call.linkEarlyMedia("", body)
+	})
+	t.Cleanup(call.early.Close)
+	<-rb.started
+
+	// Far end sends early media after 183, and answers once it reaches the room.
+	remote := rtp.NewConn(nil)
+	require.NoError(t, remote.ListenAndServe(0, 0, "0.0.0.0"))
+	t.Cleanup(func() { _ = remote.Close() })
+	answer, err := sdpGenerateOfferWith("127.0.0.1", remote.LocalAddr().Port, []sdpCodecInfo{
+		{Type: prtp.PayloadTypePCMU, Codec: lksdp.CodecByName(ulaw.SDPName)},
+	})
+	require.NoError(t, err)
+	uas := newTestUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		res := sip.NewResponseFromRequest(req, 183, "Session Progress", answer)
+		res.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+		res.AppendHeader(sip.NewHeader("P-Early-Media", "sendrecv"))
+		_ = tx.Respond(res)
+
+		frame := make(media.PCM16Sample, rtp.DefPacketDur)
+		for i := range frame {
+			frame[i] = 1000
+		}
+		remote.SetDestAddr(call.rtpConn.LocalAddr())
+		timeout := time.After(2 * time.Second)
+		for seq := uint16(0); ; seq++ {
+			_ = remote.WriteRTP(&prtp.Packet{
+				Header:  prtp.Header{Version: 2, PayloadType: prtp.PayloadTypePCMU, SequenceNumber: seq, Timestamp: uint32(seq) * rtp.DefPacketDur, SSRC: 1},
+				Payload: ulaw.EncodeUlaw(frame),
+			})
+			select {
+			case <-received:
+				_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+				return
+			case <-timeout:
+				_ = tx.Respond(sip.NewResponseFromRequest(req, 408, "No early media", nil))
+				return
+			case <-time.After(rtp.DefFrameDur):
+			}
+		}
+	})
+	_, resp, err := call.sipInvite(nil, sipOutboundConfig{
+		address: uas.String(),
+		from:    "from",
+		to:      "to",
+	})
+	require.NoError(t, err)
+	require.Equal(t, sip.StatusCode(200), resp.StatusCode)
+	require.Equal(t, earlyMediaFarEnd, call.early.State())
+	rb.requireStopped(t)
+
+	call.early.Connected()
+	require.Equal(t, earlyMediaConnected, call.early.State())
+}
@@ -0,0 +1,304 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/livekit/protocol/logger"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+	"github.com/pion/sdp/v2"
+
+	"github.com/livekit/sip/pkg/media/rtp"
+)
+
+// MediaModeTopic is a LiveKit data topic used to announce the media mode of the SIP participant ("audio" or "t38").
+//
+// The mode is set with AttrMediaMode participant attribute as well, see HoldTopic.
+const MediaModeTopic = "sip_media_mode"
+
+const (
+	mediaModeAudio = "audio"
+	mediaModeT38   = "t38"
+)
+
+func mediaModeData(mode string) *lksdk.UserDataPacket {
+	return &lksdk.UserDataPacket{Payload: []byte(mode), Topic: MediaModeTopic}
+}
+
+// sdpT38 is a T.38 fax media of the session description (ITU-T T.38 Annex D).
+type sdpT38 struct {
+	SessionID uint64
+	Protos    []string
+	Dest      *net.UDPAddr
+	Attrs     []sdp.Attribute // T.38 fax parameters
+}
+
+// sdpParseT38 returns the T.38 media of the session description, if any. Other media is ignored.
+//
+// It's parsed separately, because the SDP library rejects "image" media.
+func sdpParseT38(data []byte) *sdpT38 {
+	var (
+		t38       *sdpT38
+		inT38     bool // parsing the T.38 media section
+		seenMedia bool
+		id        uint64
+		session   *net.UDPAddr // session-level connection
+		media     *net.UDPAddr // media-level connection of T.38
+		port      int
+	)
+	for _, line := range strings.Split(string(data), "\n") {
+		key, val, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "o":
+			if f := strings.Fields(val); len(f) >= 2 {
+				id, _ = strconv.ParseUint(f[1], 10, 64)
+			}
+		case "c":
+			if !seenMedia {
+				session = sdpParseConnection(val)
+			} else if inT38 {
+				media = sdpParseConnection(val)
+			}
+		case "m":
+			seenMedia = true
+			inT38 = false
+			if t38 != nil {
+				continue // only the first T.38 media is used
+			}
+			f := strings.Fields(val)
+			if len(f) < 4 || f[0] != "image" || !slices.Contains(f[3:], "t38") {
+				continue
+			}
+			if p, err := strconv.Atoi(f[1]); err == nil && p != 0 {
+				inT38, port = true, p
+				t38 = &sdpT38{Protos: strings.Split(f[2], "/")}
+			}
+		case "a":
+			if !inT38 {
+				continue
+			}
+			name, v, _ := strings.Cut(val, ":")
+			if strings.HasPrefix(strings.ToLower(name), "t38") {
+				t38.Attrs = append(t38.Attrs, sdp.Attribute{Key: name, Value: v})
+			}
+		}
+	}
+	if t38 == nil {
+		return nil
+	}
+	t38.SessionID = id
+	if media == nil {
+		media = session
+	}
+	if media != nil {
+		t38.Dest = &net.UDPAddr{IP: media.IP, Port: port}
+	}
+	return t38
+}
+
+// sdpParseConnection parses the address of the "c=" line.
+func sdpParseConnection(val string) *net.UDPAddr {
+	f := strings.Fields(val)
+	if len(f) != 3 || f[0] != "IN" {
+		return nil
+	}
+	ip, err := netip.ParseAddr(f[2])
+	if err != nil {
+		return nil
+	}
+	return &net.UDPAddr{IP: ip.AsSlice()}
+}
+
+// IsUDPTL checks if T.38 is sent with UDPTL over plain UDP. Other transports, e.g. UDPTL over DTLS, are not supported.
+func (t *sdpT38) IsUDPTL() bool {
+	return len(t.Protos) == 1 && strings.EqualFold(t.Protos[0], "udptl")
+}
+
+// sdpGenerateT38Answer accepts the T.38 offer. Fax parameters are negotiated end-to-end, so they are copied from the offer.
+func sdpGenerateT38Answer(offer *sdpT38, publicIp string, port int) ([]byte, error) {
+	return sdpGenerateAnswerWith(sdp.SessionDescription{Origin: sdp.Origin{SessionID: offer.SessionID}}, publicIp, []*sdp.MediaDescription{{
+		MediaName: sdp.MediaName{
+			Media:   "image",
+			Port:    sdp.RangedPort{Value: port},
+			Protos:  offer.Protos,
+			Formats: []string{"t38"},
+		},
+		Attributes: offer.Attrs,
+	}})
+}
+
+// faxRelay forwards T.38 UDPTL packets between the caller and the fax server, without decoding them.
+type faxRelay struct {
+	log    logger.Logger
+	conn   *net.UDPConn
+	ports  *rtp.PortPool
+	server *net.UDPAddr
+	peer   atomic.Pointer[net.UDPAddr]
+}
+
+func newFaxRelay(log logger.Logger, ports *rtp.PortPool, server string, peer *net.UDPAddr) (*faxRelay, error) {
+	srv, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := ports.ListenUDP(net.IPv4zero)
+	if err != nil {
+		return nil, err
+	}
+	r := &faxRelay{log: log, conn: conn, ports: ports, server: srv}
+	r.peer.Store(peer)
+	go r.readLoop()
+	return r, nil
+}
+
+func (r *faxRelay) LocalAddr() *net.UDPAddr {
+	return r.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// SetPeer updates the T.38 address of the caller, e.g. after a repeated re-INVITE.
+func (r *faxRelay) SetPeer(addr *net.UDPAddr) {
+	r.peer.Store(addr)
+}
+
+func (r *faxRelay) readLoop() {
+	buf := make([]byte, 1500) // MTU
+	for {
+		n, src, err := r.conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			r.log.Warnw("Cannot read T.38 packet", err)
+			return
+		}
+		peer := r.peer.Load()
+		var dst *net.UDPAddr
+		switch {
+		case src.IP.Equal(r.server.IP) && src.Port == r.server.Port:
+			dst = peer
+		case src.IP.Equal(peer.IP):
+			// Caller may send from a different port than the one in SDP, e.g. behind NAT.
+			dst = r.server
+		default:
+			continue // unknown source
+		}
+		if _, err = r.conn.WriteToUDP(buf[:n], dst); err != nil {
+			r.log.Debugw("Cannot forward T.38 packet", "error", err, "dst", dst)
+		}
+	}
+}
+
+func (r *faxRelay) Close() {
+	port := r.LocalAddr().Port
+	_ = r.conn.Close()
+	r.ports.Release(port)
+}
+
+// handleT38ReInvite switches the call to T.38 fax passthrough, if the fax server is configured.
+// Audio of the call is kept, so that the caller can switch back with another re-INVITE.
+func (c *inboundCall) handleT38ReInvite(req *sip.Request, tx sip.ServerTransaction, offer *sdpT38) {
+	if c.s.conf.T38FaxServer == "" || !offer.IsUDPTL() {
+		c.log.Infow("Rejecting T.38 re-INVITE", "proto", strings.Join(offer.Protos, "/"))
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 488, "Not Acceptable Here", nil))
+		return
+	}
+	dst := offer.Dest
+	if dst == nil || dst.IP.IsUnspecified() {
+		c.log.Warnw("No T.38 media address in re-INVITE", nil)
+		sipErrorResponse(tx, req)
+		return
+	}
+	// Same as for audio, media can only be redirected by the same peer which sent the initial INVITE.
+	if !sameHost(req.Source(), c.src) {
+		c.log.Warnw("Rejecting T.38 re-INVITE from another host", nil, "source", req.Source(), "media", dst)
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 488, "Not Acceptable Here", nil))
+		return
+	}
+
+	c.mediaMu.Lock()
+	started := c.fax == nil
+	if started {
+		r, err := newFaxRelay(c.log, c.s.ports, c.s.conf.T38FaxServer, dst)
+		if err != nil {
+			c.mediaMu.Unlock()
+			c.log.Errorw("Cannot start T.38 relay", err)
+			_ = tx.Respond(sip.NewResponseFromRequest(req, 500, "Server Error", nil))
+			return
+		}
+		c.fax = r
+	} else {
+		c.fax.SetPeer(dst)
+	}
+	port := c.fax.LocalAddr().Port
+	c.mediaMu.Unlock()
+
+	answerData, err := sdpGenerateT38Answer(offer, c.s.signalingIp, port)
+	if err != nil {
+		c.log.Errorw("Cannot generate T.38 answer", err)
+		if started {
+			c.stopFax()
+		}
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 500, "Server Error", nil))
+		return
+	}
+	resp := sip.NewResponseFromRequest(req, 200, "OK", answerData)
+	resp.AppendHeader(c.s.contactHeader(req))
+	resp.AppendHeader(&contentTypeHeaderSDP)
+	_ = tx.Respond(resp)
+	if started {
+		c.log.Infow("T.38 fax passthrough started", "media", dst, "faxServer", c.s.conf.T38FaxServer)
+		c.sendMediaMode(mediaModeT38)
+	}
+}
+
+// isFax checks if the call is in T.38 fax mode.
+func (c *inboundCall) isFax() bool {
+	c.mediaMu.Lock()
+	defer c.mediaMu.Unlock()
+	return c.fax != nil
+}
+
+// stopFax stops T.38 passthrough, if it's active. It returns true if the call was in fax mode.
+func (c *inboundCall) stopFax() bool {
+	c.mediaMu.Lock()
+	r := c.fax
+	c.fax = nil
+	c.mediaMu.Unlock()
+	if r == nil {
+		return false
+	}
+	r.Close()
+	return true
+}
+
+func (c *inboundCall) sendMediaMode(mode string) {
+	if c.done.Load() {
+		return
+	}
+	c.lkRoom.SetAttributes(map[string]string{AttrMediaMode: mode})
+	if err := c.lkRoom.SendData(mediaModeData(mode), lksdk.WithDataPublishReliable(true)); err != nil {
+		c.log.Warnw("Cannot send media mode to the room", err)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+	"github.com/pion/sdp/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+func newTestT38ReInvite(addr, tag string, port int) *sip.Request {
+	body := fmt.Sprintf("v=0\r\n"+
+		"o=- 1 2 IN IP4 127.0.0.1\r\n"+
+		"s=fax\r\n"+
+		"c=IN IP4 127.0.0.1\r\n"+
+		"t=0 0\r\n"+
+		"m=audio 0 RTP/AVP 0\r\n"+
+		"m=image %d udptl t38\r\n"+
+		"a=T38FaxVersion:0\r\n"+
+		"a=T38MaxBitRate:14400\r\n"+
+		"a=T38FaxRateManagement:transferredTCF\r\n"+
+		"a=T38FaxUdpEC:t38UDPRedundancy\r\n", port)
+	req := newTestDialogRequest(sip.INVITE, addr, "alice", tag, testSIPCallID(tag))
+	req.AppendHeader(&contentTypeHeaderSDP)
+	req.SetBody([]byte(body))
+	return req
+}
+
+func newTestUDP(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func expectUDP(t *testing.T, conn *net.UDPConn, exp string) *net.UDPAddr {
+	t.Helper()
+	buf := make([]byte, 1500)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, src, err := conn.ReadFromUDP(buf)
+	require.NoError(t, err)
+	require.Equal(t, exp, string(buf[:n]))
+	return src
+}
+
+// addTestMediaCall adds an active call from a given source address, with audio media and a fake LiveKit room.
+func addTestMediaCall(t *testing.T, s *Service, src string) (*inboundCall, <-chan lksdk.DataPacket) {
+	call := newTestInboundCall(s, "alice")
+	call.sipCallID = testSIPCallID(call.tag)
+	call.src = src
+	data := setTestRoomConn(call.lkRoom)
+	offer, err := sdpGenerateOffer("127.0.0.1", 40000)
+	require.NoError(t, err)
+	_, err = call.runMediaConn(offer, s.conf)
+	require.NoError(t, err)
+	t.Cleanup(call.closeMedia)
+	s.srv.cmu.Lock()
+	s.srv.activeCalls[call.tag] = call
+	s.srv.cmu.Unlock()
+	return call, data
+}
+
+func TestService_ReInviteT38(t *testing.T) {
+	fax := newTestUDP(t)   // fax server
+	trunk := newTestUDP(t) // T.38 media of the caller
+	s, addr := startTestService(t, &config.Config{T38FaxServer: fax.LocalAddr().String()})
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+
+	call, data := addTestMediaCall(t, s, localIP+":5060")
+
+	expectMode := func(t *testing.T, mode string) {
+		t.Helper()
+		select {
+		case p := <-data:
+			require.Equal(t, mediaModeData(mode), p)
+		case <-time.After(time.Second):
+			t.Fatal("no media mode")
+		}
+		require.Equal(t, mode, call.lkRoom.room.(*testRoomConn).Attributes()[AttrMediaMode])
+	}
+
+	res := sendTestRequest(t, addr, "alice", newTestT38ReInvite(addr, call.tag, trunk.LocalAddr().(*net.UDPAddr).Port))
+	require.Equal(t, sip.StatusCode(200), res.StatusCode)
+	answer := sdpParseT38(res.Body())
+	require.NotNil(t, answer)
+	require.Equal(t, []string{"udptl"}, answer.Protos)
+	require.Contains(t, answer.Attrs, sdp.Attribute{Key: "T38FaxUdpEC", Value: "t38UDPRedundancy"})
+	require.Len(t, answer.Attrs, 4)
+	expectMode(t, mediaModeT38)
+	require.True(t, call.isFax())
+
+	// Packets are passed through in both directions without changes.
+	relay := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: answer.Dest.Port}
+	_, err = trunk.WriteToUDP([]byte("t38-from-caller"), relay)
+	require.NoError(t, err)
+	src := expectUDP(t, fax, "t38-from-caller")
+	require.Equal(t, relay.Port, src.Port)
+
+	_, err = fax.WriteToUDP([]byte("t38-from-fax"), relay)
+	require.NoError(t, err)
+	expectUDP(t, trunk, "t38-from-fax")
+
+	// Caller switches back to audio.
+	res = sendTestRequest(t, addr, "alice", newTestReInvite(t, addr, call.tag, "sendrecv"))
+	require.Equal(t, sip.StatusCode(200), res.StatusCode)
+	expectMode(t, mediaModeAudio)
+	require.False(t, call.isFax())
+}
+
+func TestService_ReInviteT38Disabled(t *testing.T) {
+	s, addr := startTestService(t, &config.Config{})
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+
+	call, _ := addTestMediaCall(t, s, localIP+":5060")
+
+	res := sendTestRequest(t, addr, "alice", newTestT38ReInvite(addr, call.tag, 40010))
+	require.Equal(t, sip.StatusCode(488), res.StatusCode)
+	require.False(t, call.isFax())
+}
+
+func TestSDPParseT38(t *testing.T) {
+	offer, err := sdpGenerateOffer("127.0.0.1", 40000)
+	require.NoError(t, err)
+	require.Nil(t, sdpParseT38(offer))
+
+	t38 := sdpParseT38([]byte("v=0\r\n" +
+		"o=- 7 8 IN IP4 10.0.0.1\r\n" +
+		"c=IN IP4 10.0.0.1\r\n" +
+		"m=audio 4000 RTP/AVP 0\r\n" +
+		"c=IN IP4 10.0.0.2\r\n" +
+		"a=T38Ignored:1\r\n" +
+		"m=image 4002 UDP/TLS/UDPTL t38\r\n" +
+		"c=IN IP4 10.0.0.3\r\n" +
+		"a=T38FaxVersion:0\r\n" +
+		"a=sendrecv\r\n"))
+	require.NotNil(t, t38)
+	require.Equal(t, uint64(7), t38.SessionID)
+	require.Equal(t, "10.0.0.3:4002", t38.Dest.String())
+	require.Equal(t, []sdp.Attribute{{Key: "T38FaxVersion", Value: "0"}}, t38.Attrs)
+	require.False(t, t38.IsUDPTL())
+
+	// Rejected T.38 stream.
+	require.Nil(t, sdpParseT38([]byte("v=0\r\nc=IN IP4 10.0.0.1\r\nm=image 0 udptl t38\r\n")))
+}
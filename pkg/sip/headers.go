@@ -0,0 +1,101 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/emiago/sipgo/sip"
+	"golang.org/x/exp/maps"
+)
+
+// HeaderAttributePrefix is the prefix of participant attributes which are mapped to SIP headers,
+// e.g. attribute "sip.X-Custom-Header" is sent as header "X-Custom-Header".
+const HeaderAttributePrefix = "sip."
+
+// isCustomHeader checks if the header can be propagated between SIP and participant attributes.
+// Only extension headers (X-*) are allowed, so that attributes cannot override headers set by the bridge.
+func isCustomHeader(name string) bool {
+	if len(name) <= 2 || !strings.EqualFold(name[:2], "x-") {
+		return false
+	}
+	for _, r := range name {
+		// Token characters (RFC 3261, section 25.1).
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("-.!%*_+`'~", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// customHeaders returns extension headers of the request. Only the first value of repeated headers is kept.
+func customHeaders(req *sip.Request) map[string]string {
+	var out map[string]string
+	for _, h := range req.Headers() {
+		name := h.Name()
+		if !isCustomHeader(name) {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string)
+		}
+		if _, ok := out[name]; !ok {
+			out[name] = h.Value()
+		}
+	}
+	return out
+}
+
+// HeadersToAttributes maps SIP headers of the call to participant attributes with HeaderAttributePrefix.
+func HeadersToAttributes(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(headers))
+	for name, val := range headers {
+		out[HeaderAttributePrefix+name] = val
+	}
+	return out
+}
+
+// AttributesToHeaders returns SIP headers for participant attributes with HeaderAttributePrefix.
+// Other attributes, non-extension headers and values which cannot be sent in a header are ignored.
+func AttributesToHeaders(attrs map[string]string) map[string]string {
+	var out map[string]string
+	for key, val := range attrs {
+		name, ok := strings.CutPrefix(key, HeaderAttributePrefix)
+		if !ok || !isCustomHeader(name) || strings.ContainsAny(val, "\r\n") {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string)
+		}
+		out[name] = val
+	}
+	return out
+}
+
+// appendHeaders adds headers to the request, sorted by name.
+func appendHeaders(req *sip.Request, headers map[string]string) {
+	names := maps.Keys(headers)
+	slices.Sort(names)
+	for _, name := range names {
+		req.AppendHeader(sip.NewHeader(name, headers[name]))
+	}
+}
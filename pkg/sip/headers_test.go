@@ -0,0 +1,127 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+func TestAttributesToHeaders(t *testing.T) {
+	require.Nil(t, AttributesToHeaders(nil))
+	got := AttributesToHeaders(map[string]string{
+		"sip.X-Custom-Header": "foo",
+		"sip.x-lower":         "bar",
+		"sip.From":            "sip:evil@example.com",
+		"sip.X-Bad Name":      "baz",
+		"sip.X-Injected":      "a\r\nVia: evil",
+		"X-No-Prefix":         "qux",
+		"lk.other":            "1",
+	})
+	require.Equal(t, map[string]string{"X-Custom-Header": "foo", "x-lower": "bar"}, got)
+
+	require.Equal(t, map[string]string{"sip.X-Custom-Header": "foo"}, HeadersToAttributes(map[string]string{"X-Custom-Header": "foo"}))
+	require.Nil(t, HeadersToAttributes(nil))
+}
+
+func TestOutboundCustomHeaders(t *testing.T) {
+	invites := make(chan *sip.Request, 1)
+	uas := newTestUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		invites <- req
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+
+	call := newTestOutboundCall(t, &config.Config{})
+	_, _, err := call.sipInvite(nil, sipOutboundConfig{
+		address: uas.String(),
+		from:    "from",
+		to:      "to",
+		headers: AttributesToHeaders(map[string]string{"sip.X-Custom-Header": "foo", "name": "alice"}),
+	})
+	require.NoError(t, err)
+	req := <-invites
+	h := req.GetHeader("X-Custom-Header")
+	require.NotNil(t, h)
+	require.Equal(t, "foo", h.Value())
+	require.Nil(t, req.GetHeader("name"))
+}
+
+func TestService_CustomHeaders(t *testing.T) {
+	infos := make(chan *CallInfo, 1)
+	h := &TestHandler{
+		GetAuthCredentialsFunc: noAuth,
+		DispatchCallFunc: func(ctx context.Context, info *CallInfo) CallDispatch {
+			infos <- info
+			return CallDispatch{Result: DispatchNoRuleReject}
+		},
+	}
+	opts := testInviteOptions{
+		Headers: []sip.Header{
+			sip.NewHeader("X-Custom-Header", "foo"),
+			sip.NewHeader("X-Custom-Header", "ignored"),
+			sip.NewHeader("Subject", "not custom"),
+		},
+	}
+	testInviteWith(t, h, opts, "foo", "bar", func(tx sip.ClientTransaction) {
+		if !inboundHidePort {
+			res := getResponseOrFail(t, tx)
+			require.Equal(t, sip.StatusCode(180), res.StatusCode)
+		}
+		res := getResponseOrFail(t, tx)
+		require.Equal(t, sip.StatusCode(400), res.StatusCode)
+
+		info := <-infos
+		require.Equal(t, map[string]string{"X-Custom-Header": "foo"}, info.Headers)
+		require.Equal(t, map[string]string{"sip.X-Custom-Header": "foo"}, HeadersToAttributes(info.Headers))
+	})
+}
+
+func TestInboundDispatchAttributes(t *testing.T) {
+	joined := make(chan *testRoomConn, 1)
+	_, addr := startTestService(t, &config.Config{}, func(s *Service) {
+		s.srv.connectRoom = newTestRoomConnector(joined)
+		s.SetHandler(acceptHandler("room", "", func(h *TestHandler) {
+			h.DispatchCallFunc = func(ctx context.Context, info *CallInfo) CallDispatch {
+				return CallDispatch{
+					Result:     DispatchAccept,
+					RoomName:   "room",
+					Identity:   "sip_" + info.FromUser,
+					Attributes: HeadersToAttributes(info.Headers),
+				}
+			}
+		}))
+	})
+	p := newTestPhone(t, "alice")
+	req, _ := p.Call(t, addr, "bob", nil, sip.NewHeader("X-Custom-Header", "foo"))
+	callID, _ := req.CallID()
+	select {
+	case c := <-joined:
+		require.Equal(t, map[string]string{
+			"sip.X-Custom-Header": "foo",
+			AttrCallID:            callID.Value(),
+			AttrFrom:              "alice",
+			AttrTo:                "bob",
+			AttrHold:              "false",
+		}, c.Attributes())
+	case <-time.After(5 * time.Second):
+		t.Fatal("call did not join the room")
+	}
+}
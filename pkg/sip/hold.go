@@ -0,0 +1,182 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+	"github.com/pion/sdp/v2"
+
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/rtp"
+)
+
+// HoldTopic is a LiveKit data topic used to announce the hold state of the SIP participant ("true" or "false").
+//
+// The state is set with AttrHold participant attribute as well. Data is still published for clients
+// written before participant attributes were supported by the SDK.
+//
+// TODO: stop publishing HoldTopic, ForwardedToTopic and MediaModeTopic data once clients use participant attributes.
+const HoldTopic = "sip_hold"
+
+func holdData(hold bool) *lksdk.UserDataPacket {
+	return &lksdk.UserDataPacket{Payload: []byte(strconv.FormatBool(hold)), Topic: HoldTopic}
+}
+
+// publishHold announces the hold state of the SIP participant to the room with AttrHold attribute and HoldTopic data.
+func (r *Room) publishHold(hold bool) error {
+	r.SetAttributes(map[string]string{AttrHold: strconv.FormatBool(hold)})
+	return r.SendData(holdData(hold), lksdk.WithDataPublishReliable(true))
+}
+
+// sdpIsHold checks if the offer puts the call on hold (RFC 3264, section 8.4).
+func sdpIsHold(dir string) bool {
+	return dir == "sendonly" || dir == "inactive"
+}
+
+// dialogCall returns an active inbound call for an in-dialog request, for example a re-INVITE.
+// The request must match both the Call-ID and the From tag of the call.
+func (s *Server) dialogCall(req *sip.Request) *inboundCall {
+	to, ok := req.To()
+	if !ok || to.Params == nil {
+		return nil
+	}
+	if _, ok = to.Params.Get("tag"); !ok {
+		return nil
+	}
+	tag, err := getTagValue(req)
+	if err != nil {
+		return nil
+	}
+	callID, ok := req.CallID()
+	if !ok {
+		return nil
+	}
+	s.cmu.RLock()
+	c := s.activeCalls[tag]
+	s.cmu.RUnlock()
+	if c == nil || c.sipCallID != callID.Value() {
+		return nil
+	}
+	return c
+}
+
+// handleReInvite updates the media direction of an established call. Only hold and resume of the same session,
+// and switching to T.38 fax and back are supported.
+func (c *inboundCall) handleReInvite(req *sip.Request, tx sip.ServerTransaction) {
+	if !c.s.handleInviteAuth(c.log, req, tx, c.from.Address.User, c.authUser, c.authPass) {
+		// handleInviteAuth will generate the SIP Response as needed
+		return
+	}
+	c.handleOffer(req, tx)
+}
+
+// handleOffer answers the SDP offer of re-INVITE or UPDATE. Offers are handled one at a time:
+// a request received while another offer is processed is rejected with 491, as required by RFC 3261 and RFC 3311.
+func (c *inboundCall) handleOffer(req *sip.Request, tx sip.ServerTransaction) {
+	if !c.offerMu.TryLock() {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 491, "Request Pending", nil))
+		return
+	}
+	defer c.offerMu.Unlock()
+	if t38 := sdpParseT38(req.Body()); t38 != nil {
+		c.handleT38ReInvite(req, tx, t38)
+		return
+	}
+	offer := sdp.SessionDescription{}
+	if err := offer.Unmarshal(req.Body()); err != nil {
+		c.log.Warnw("Cannot parse SDP offer", err, "method", req.Method)
+		sipErrorResponse(tx, req)
+		return
+	}
+	c.mediaMu.Lock()
+	conn, res := c.rtpConn, c.mediaRes
+	c.mediaMu.Unlock()
+	if conn == nil || res == nil {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 491, "Request Pending", nil))
+		return
+	}
+	dir := sdpGetDirection(offer)
+	hold := sdpIsHold(dir)
+	c.log.Infow("SDP offer received", "method", req.Method, "direction", dir, "hold", hold)
+	if dst := sdpGetAudioDest(offer); dst != nil && !dst.IP.IsUnspecified() {
+		// Media can only be redirected by the same peer which sent the initial INVITE.
+		if sameHost(req.Source(), c.src) {
+			conn.SetDestAddr(dst)
+		} else {
+			c.log.Warnw("Ignoring media address from SDP offer", nil, "method", req.Method, "source", req.Source(), "media", dst)
+		}
+	}
+	c.setOnHold(hold)
+
+	answerData, err := sdpGenerateAnswer(offer, c.s.signalingIp, conn.LocalAddr().Port, res)
+	if err != nil {
+		c.log.Errorw("Cannot generate SDP answer", err, "method", req.Method)
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 500, "Server Error", nil))
+		return
+	}
+	resp := sip.NewResponseFromRequest(req, 200, "OK", answerData)
+	resp.AppendHeader(c.s.contactHeader(req))
+	resp.AppendHeader(&contentTypeHeaderSDP)
+	_ = tx.Respond(resp)
+	c.dialogMu.Lock()
+	c.localSDP = answerData
+	c.dialogMu.Unlock()
+	if c.stopFax() {
+		c.log.Infow("T.38 fax passthrough stopped")
+		c.sendMediaMode(mediaModeAudio)
+	}
+}
+
+// sameHost checks if both "host:port" addresses have the same host.
+func sameHost(a, b string) bool {
+	ha, _, err := net.SplitHostPort(a)
+	if err != nil {
+		return false
+	}
+	hb, _, err := net.SplitHostPort(b)
+	if err != nil {
+		return false
+	}
+	return ha == hb
+}
+
+// playMusicOnHold sends music-on-hold audio to the room in place of the caller audio, until stop is closed.
+func playMusicOnHold(src media.ReadCloser[media.PCM16Sample], dst media.PCM16Writer, stop <-chan struct{}) {
+	defer src.Close()
+	ticker := time.NewTicker(rtp.DefFrameDur)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		buf := make(media.PCM16Sample, rtp.DefPacketDur)
+		n, err := src.ReadSample(buf)
+		if err != nil {
+			return
+		} else if n == 0 {
+			continue
+		}
+		if err = dst.WriteSample(buf[:n]); err != nil {
+			return
+		}
+	}
+}
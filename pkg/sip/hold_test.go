@@ -0,0 +1,234 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/pion/sdp/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/mixer"
+)
+
+func newTestReInvite(t *testing.T, addr, tag, dir string) *sip.Request {
+	offer, err := sdpGenerateOffer("127.0.0.1", 40000)
+	require.NoError(t, err)
+	body := strings.Replace(string(offer), "a=sendrecv", "a="+dir, 1)
+
+	req := newTestDialogRequest(sip.INVITE, addr, "alice", tag, testSIPCallID(tag))
+	req.AppendHeader(&contentTypeHeaderSDP)
+	req.SetBody([]byte(body))
+	return req
+}
+
+func TestService_ReInviteHold(t *testing.T) {
+	s, addr := startTestService(t, &config.Config{})
+	call := addTestCall(s, "alice", "alice-tag")
+	data := setTestRoomConn(call.lkRoom)
+	offer, err := sdpGenerateOffer("127.0.0.1", 40000)
+	require.NoError(t, err)
+	_, err = call.runMediaConn(offer, s.conf)
+	require.NoError(t, err)
+	t.Cleanup(call.closeMedia)
+
+	// Emulate the participant track, as if the call joined the room.
+	var received atomic.Int64
+	call.lkTrack = media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		received.Add(int64(len(s)))
+		return nil
+	})
+	call.lkAudio.Set(call.lkTrack)
+
+	expectAudio := func(t *testing.T, exp bool) {
+		t.Helper()
+		before := received.Load()
+		require.NoError(t, call.lkAudio.WriteSample(make(media.PCM16Sample, rtp.DefPacketDur)))
+		require.Equal(t, exp, received.Load() > before)
+	}
+	expectHold := func(t *testing.T, hold bool) {
+		t.Helper()
+		select {
+		case p := <-data:
+			require.Equal(t, holdData(hold), p)
+		case <-time.After(time.Second):
+			t.Fatal("no hold state")
+		}
+		require.Equal(t, strconv.FormatBool(hold), call.lkRoom.room.(*testRoomConn).Attributes()[AttrHold])
+		require.Equal(t, hold, call.isOnHold())
+	}
+	reInvite := func(t *testing.T, dir, expDir string) {
+		t.Helper()
+		res := sendTestRequest(t, addr, "alice", newTestReInvite(t, addr, call.tag, dir))
+		require.Equal(t, sip.StatusCode(200), res.StatusCode)
+		var answer sdp.SessionDescription
+		require.NoError(t, answer.Unmarshal(res.Body()))
+		require.Equal(t, expDir, sdpGetDirection(answer))
+	}
+
+	// Room audio must not be sent to SIP when the answer is "recvonly" or "inactive".
+	expectOutput := func(t *testing.T, exp bool) {
+		t.Helper()
+		require.Equal(t, exp, call.lkRoom.Output() != nil)
+	}
+
+	expectAudio(t, true)
+	expectOutput(t, true)
+
+	reInvite(t, "sendonly", "recvonly")
+	expectHold(t, true)
+	expectAudio(t, false)
+	expectOutput(t, false)
+
+	reInvite(t, "sendrecv", "sendrecv")
+	expectHold(t, false)
+	expectAudio(t, true)
+	expectOutput(t, true)
+
+	reInvite(t, "inactive", "inactive")
+	expectHold(t, true)
+	expectAudio(t, false)
+	expectOutput(t, false)
+
+	reInvite(t, "recvonly", "sendonly")
+	expectHold(t, false)
+	expectAudio(t, true)
+	expectOutput(t, true)
+}
+
+func TestService_ReInviteMusicOnHold(t *testing.T) {
+	s, addr := startTestService(t, &config.Config{})
+	s.srv.moh = mixer.NewMusicOnHold(media.PCM16Sample{1000}) // set before the call is visible to the server
+	call := addTestCall(s, "alice", "alice-tag")
+	setTestRoomConn(call.lkRoom)
+	offer, err := sdpGenerateOffer("127.0.0.1", 40000)
+	require.NoError(t, err)
+	_, err = call.runMediaConn(offer, s.conf)
+	require.NoError(t, err)
+	t.Cleanup(call.closeMedia)
+
+	got := make(chan media.PCM16Sample, 100)
+	call.holdMu.Lock()
+	call.lkTrack = media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		select {
+		case got <- s:
+		default:
+		}
+		return nil
+	})
+	call.lkAudio.Set(call.lkTrack)
+	call.holdMu.Unlock()
+
+	// Room hears music-on-hold from the SIP participant.
+	res := sendTestRequest(t, addr, "alice", newTestReInvite(t, addr, call.tag, "sendonly"))
+	require.Equal(t, sip.StatusCode(200), res.StatusCode)
+	select {
+	case frame := <-got:
+		require.Equal(t, int16(1000), frame[0])
+	case <-time.After(time.Second):
+		t.Fatal("no music-on-hold")
+	}
+
+	res = sendTestRequest(t, addr, "alice", newTestReInvite(t, addr, call.tag, "sendrecv"))
+	require.Equal(t, sip.StatusCode(200), res.StatusCode)
+	time.Sleep(2 * rtp.DefFrameDur)
+	for len(got) > 0 {
+		<-got
+	}
+	select {
+	case <-got:
+		t.Fatal("unexpected music-on-hold after resume")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestService_ReInviteMediaAddress(t *testing.T) {
+	s, addr := startTestService(t, &config.Config{})
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+
+	reInvite := func(t *testing.T, src string) *inboundCall {
+		t.Helper()
+		call := newTestInboundCall(s, "alice")
+		call.sipCallID = testSIPCallID(call.tag)
+		call.src = src
+		setTestRoomConn(call.lkRoom)
+		offer, err := sdpGenerateOffer("127.0.0.1", 40000)
+		require.NoError(t, err)
+		_, err = call.runMediaConn(offer, s.conf)
+		require.NoError(t, err)
+		t.Cleanup(call.closeMedia)
+		s.srv.cmu.Lock()
+		s.srv.activeCalls[call.tag] = call
+		s.srv.cmu.Unlock()
+
+		req := newTestReInvite(t, addr, call.tag, "sendrecv")
+		req.SetBody([]byte(strings.Replace(string(req.Body()), "40000", "40002", 1)))
+		res := sendTestRequest(t, addr, "alice", req)
+		require.Equal(t, sip.StatusCode(200), res.StatusCode)
+		return call
+	}
+
+	// Requests from other hosts can't redirect media.
+	call := reInvite(t, "192.0.2.1:5060")
+	require.Equal(t, 40000, call.rtpConn.DestAddr().Port)
+
+	call = reInvite(t, localIP+":5060")
+	require.Equal(t, 40002, call.rtpConn.DestAddr().Port)
+}
+
+func TestService_ReInviteAuth(t *testing.T) {
+	s, addr := startTestService(t, &config.Config{})
+	call := addTestCall(s, "alice", "alice-tag")
+	s.srv.cmu.Lock()
+	call.authUser, call.authPass = "user", "pass"
+	s.srv.cmu.Unlock()
+
+	ua, err := sipgo.NewUA(sipgo.WithUserAgent("alice"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ua.Close() })
+	cli, err := sipgo.NewClient(ua)
+	require.NoError(t, err)
+	req := newTestReInvite(t, addr, call.tag, "sendonly")
+	req.SetDestination(addr)
+	tx, err := cli.TransactionRequest(req)
+	require.NoError(t, err)
+	t.Cleanup(tx.Terminate)
+	res := getResponseOrFail(t, tx)
+	for res.IsProvisional() {
+		res = getResponseOrFail(t, tx)
+	}
+	require.Equal(t, sip.StatusCode(407), res.StatusCode)
+	require.False(t, call.isOnHold())
+}
+
+func TestService_ReInviteNoMedia(t *testing.T) {
+	s, addr := startTestService(t, &config.Config{})
+	call := addTestCall(s, "alice", "alice-tag")
+
+	// Media is not established yet.
+	res := sendTestRequest(t, addr, "alice", newTestReInvite(t, addr, call.tag, "sendonly"))
+	require.Equal(t, sip.StatusCode(491), res.StatusCode)
+	require.False(t, call.isOnHold())
+}
@@ -0,0 +1,167 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/livekit/protocol/logger"
+	"github.com/pion/ice/v2"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media/rtp"
+)
+
+// iceGatherTimeout limits candidate gathering before the offer or answer is sent.
+// Candidates gathered so far are used if STUN or TURN servers don't respond in time.
+const iceGatherTimeout = 2 * time.Second
+
+// iceAgent gathers ICE candidates on the RTP port of the call, and runs connectivity checks once remote candidates
+// are known. STUN packets are demultiplexed from RTP, and media is sent to the remote address of the selected pair.
+type iceAgent struct {
+	log    logger.Logger
+	conn   *rtp.Conn
+	mux    *ice.UniversalUDPMuxDefault
+	agent  *ice.Agent
+	local  *sdpICE
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newICEAgent gathers local ICE candidates for the RTP connection. The connection must be listening.
+func newICEAgent(log logger.Logger, conf *config.Config, conn *rtp.Conn) (*iceAgent, error) {
+	urls, err := conf.ICEServers()
+	if err != nil {
+		return nil, err
+	}
+	mux := ice.NewUniversalUDPMuxDefault(ice.UniversalUDPMuxParams{UDPConn: conn.STUNConn()})
+	aconf := &ice.AgentConfig{
+		Urls:             urls,
+		NetworkTypes:     []ice.NetworkType{ice.NetworkTypeUDP4},
+		UDPMux:           mux,
+		UDPMuxSrflx:      mux,
+		MulticastDNSMode: ice.MulticastDNSModeDisabled,
+	}
+	if conf.NAT1To1IP != "" {
+		aconf.NAT1To1IPs = []string{conf.NAT1To1IP}
+		aconf.NAT1To1IPCandidateType = ice.CandidateTypeHost
+	}
+	agent, err := ice.NewAgent(aconf)
+	if err != nil {
+		_ = mux.Close()
+		return nil, err
+	}
+	a := &iceAgent{log: log, conn: conn, mux: mux, agent: agent}
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	if err = a.gather(); err != nil {
+		a.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *iceAgent) gather() error {
+	var (
+		mu    sync.Mutex
+		cands []string
+	)
+	done := make(chan struct{})
+	if err := a.agent.OnCandidate(func(c ice.Candidate) {
+		if c == nil {
+			close(done)
+			return
+		}
+		mu.Lock()
+		cands = append(cands, c.Marshal())
+		mu.Unlock()
+	}); err != nil {
+		return err
+	}
+	if err := a.agent.OnSelectedCandidatePairChange(a.onSelectedPair); err != nil {
+		return err
+	}
+	if err := a.agent.GatherCandidates(); err != nil {
+		return err
+	}
+	timer := time.NewTimer(iceGatherTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		a.log.Warnw("ICE candidate gathering timed out", nil, "timeout", iceGatherTimeout)
+	}
+	ufrag, pwd, err := a.agent.GetLocalUserCredentials()
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	a.local = &sdpICE{Ufrag: ufrag, Pwd: pwd, Candidates: cands}
+	a.log.Debugw("Gathered ICE candidates", "candidates", cands)
+	return nil
+}
+
+// Local returns local ICE parameters for SDP.
+func (a *iceAgent) Local() *sdpICE {
+	return a.local
+}
+
+// Start runs connectivity checks with remote candidates. The offerer takes the controlling role (RFC 8445, section 6.1.1).
+func (a *iceAgent) Start(remote *sdpICE, controlling bool) {
+	for _, s := range remote.Candidates {
+		c, err := ice.UnmarshalCandidate(s)
+		if err != nil {
+			a.log.Debugw("Ignoring ICE candidate", "candidate", s, "error", err)
+			continue
+		}
+		if c.Component() != ice.ComponentRTP {
+			// RTCP is multiplexed with RTP.
+			continue
+		}
+		if err = a.agent.AddRemoteCandidate(c); err != nil {
+			a.log.Debugw("Ignoring ICE candidate", "candidate", s, "error", err)
+		}
+	}
+	go func() {
+		var err error
+		if controlling {
+			_, err = a.agent.Dial(a.ctx, remote.Ufrag, remote.Pwd)
+		} else {
+			_, err = a.agent.Accept(a.ctx, remote.Ufrag, remote.Pwd)
+		}
+		if err != nil && a.ctx.Err() == nil {
+			a.log.Warnw("ICE connectivity checks failed", err)
+		}
+	}()
+}
+
+func (a *iceAgent) onSelectedPair(local, remote ice.Candidate) {
+	if local.Type() == ice.CandidateTypeRelay {
+		a.log.Warnw("ICE selected a relayed pair, which is not supported for media", nil, "local", local.String(), "remote", remote.String())
+		return
+	}
+	a.log.Infow("ICE connected", "local", local.String(), "remote", remote.String())
+	a.conn.SetDestAddr(&net.UDPAddr{IP: net.ParseIP(remote.Address()), Port: remote.Port()})
+}
+
+// Close stops connectivity checks and releases ICE resources. The RTP connection is not closed.
+func (a *iceAgent) Close() {
+	a.cancel()
+	_ = a.agent.Close()
+	_ = a.mux.Close()
+}
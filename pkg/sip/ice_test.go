@@ -0,0 +1,124 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/livekit/protocol/logger"
+	"github.com/pion/sdp/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media/rtp"
+)
+
+func newTestICEAgent(t *testing.T) (*rtp.Conn, *iceAgent) {
+	conn := rtp.NewConn(nil)
+	require.NoError(t, conn.ListenAndServe(0, 0, "127.0.0.1"))
+	t.Cleanup(func() { _ = conn.Close() })
+	a, err := newICEAgent(logger.GetLogger(), &config.Config{ICEEnabled: true}, conn)
+	require.NoError(t, err)
+	t.Cleanup(a.Close)
+	return conn, a
+}
+
+func TestICEGather(t *testing.T) {
+	conn, a := newTestICEAgent(t)
+	local := a.Local()
+	require.NotEmpty(t, local.Ufrag)
+	require.NotEmpty(t, local.Pwd)
+	port := " " + strconv.Itoa(conn.LocalAddr().Port) + " typ host"
+	found := false
+	for _, c := range local.Candidates {
+		if strings.Contains(c, " 127.0.0.1"+port) {
+			found = true
+		}
+	}
+	require.True(t, found, "no host candidate on the RTP port: %v", local.Candidates)
+
+	data, err := sdpGenerateOffer("127.0.0.1", conn.LocalAddr().Port)
+	require.NoError(t, err)
+	data, err = sdpWithICE(data, local)
+	require.NoError(t, err)
+	for _, line := range []string{"a=ice-ufrag:" + local.Ufrag, "a=ice-pwd:" + local.Pwd, "a=candidate:", "typ host", "a=end-of-candidates"} {
+		require.Contains(t, string(data), line)
+	}
+	desc := sdp.SessionDescription{}
+	require.NoError(t, desc.Unmarshal(data))
+	require.Equal(t, local, sdpGetICE(desc))
+}
+
+func TestICEConnect(t *testing.T) {
+	offerer, a := newTestICEAgent(t)
+	answerer, b := newTestICEAgent(t)
+	// Destinations from SDP are not reachable, so media only flows once ICE selects the pair.
+	unreachable := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+	offerer.SetDestAddr(unreachable)
+	answerer.SetDestAddr(unreachable)
+
+	b.Start(a.Local(), false)
+	a.Start(b.Local(), true)
+	require.Eventually(t, func() bool {
+		return offerer.DestAddr().Port == answerer.LocalAddr().Port &&
+			answerer.DestAddr().Port == offerer.LocalAddr().Port
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestService_ICE(t *testing.T) {
+	h := acceptHandler("room", "")
+	conn, a := newTestICEAgent(t)
+	offer, err := sdpGenerateOffer("127.0.0.1", conn.LocalAddr().Port)
+	require.NoError(t, err)
+	offer, err = sdpWithICE(offer, a.Local())
+	require.NoError(t, err)
+	opts := testInviteOptions{
+		Offer: offer,
+		Setup: func(s *Service) {
+			s.conf.ICEEnabled = true
+		},
+	}
+	testInviteWith(t, h, opts, "foo", "bar", func(tx sip.ClientTransaction) {
+		if !inboundHidePort {
+			res := getResponseOrFail(t, tx)
+			require.Equal(t, sip.StatusCode(180), res.StatusCode)
+		}
+		res := getResponseOrFail(t, tx)
+		require.Equal(t, sip.StatusCode(200), res.StatusCode)
+
+		var answer sdp.SessionDescription
+		require.NoError(t, answer.Unmarshal(res.Body()))
+		remote := sdpGetICE(answer)
+		require.NotNil(t, remote)
+		port := sdpGetAudioDest(answer).Port
+		host := false
+		for _, c := range remote.Candidates {
+			host = host || strings.HasSuffix(c, " "+strconv.Itoa(port)+" typ host")
+		}
+		require.True(t, host, "no host candidate on the RTP port: %v", remote.Candidates)
+
+		// The offerer is controlling, and sends media to the selected candidate.
+		conn.SetDestAddr(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9})
+		a.Start(remote, true)
+		require.Eventually(t, func() bool {
+			return conn.DestAddr().Port == port
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
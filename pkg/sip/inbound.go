@@ -16,7 +16,10 @@ package sip
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -28,11 +31,19 @@ import (
 	lksdk "github.com/livekit/server-sdk-go/v2"
 	"github.com/pion/sdp/v2"
 
+	"github.com/livekit/sip/pkg/cdr"
 	"github.com/livekit/sip/pkg/config"
 	"github.com/livekit/sip/pkg/media"
 	"github.com/livekit/sip/pkg/media/dtmf"
 	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/media/srtp/dtls"
+	"github.com/livekit/sip/pkg/sip/callpprof"
+	"github.com/livekit/sip/pkg/sip/parking"
+	"github.com/livekit/sip/pkg/sip/publish"
+	"github.com/livekit/sip/pkg/sip/queue"
 	"github.com/livekit/sip/pkg/stats"
+	"github.com/livekit/sip/pkg/transcription"
+	"github.com/livekit/sip/pkg/webhook"
 )
 
 const (
@@ -43,6 +54,8 @@ const (
 	// audioBridgeMaxDelay delays sending audio for certain time, unless RTP packet is received.
 	// This is done because of audio cutoff at the beginning of calls observed in the wild.
 	audioBridgeMaxDelay = 1 * time.Second
+	// callEndedTimeout limits how long Handler.CallEnded may take.
+	callEndedTimeout = 5 * time.Second
 )
 
 func sipErrorOrDrop(tx sip.ServerTransaction, req *sip.Request) {
@@ -57,7 +70,7 @@ func (s *Server) handleInviteAuth(log logger.Logger, req *sip.Request, tx sip.Se
 	if username == "" || password == "" {
 		return true
 	}
-	if inboundHidePort {
+	if inboundHidePort && req.Method == sip.INVITE && !requires100rel(req) {
 		// We will send password request anyway, so might as well signal that the progress is made.
 		_ = tx.Respond(sip.NewResponseFromRequest(req, 180, "Ringing", nil))
 	}
@@ -120,10 +133,14 @@ func (s *Server) handleInviteAuth(log logger.Logger, req *sip.Request, tx sip.Se
 }
 
 func (s *Server) onInvite(req *sip.Request, tx sip.ServerTransaction) {
+	if c := s.dialogCall(req); c != nil {
+		c.handleReInvite(req, tx)
+		return
+	}
 	ctx := context.Background()
 	s.mon.InviteReqRaw(stats.Inbound)
 
-	if !inboundHidePort {
+	if !inboundHidePort && !requires100rel(req) {
 		_ = tx.Respond(sip.NewResponseFromRequest(req, 180, "Ringing", nil))
 	}
 	tag, err := getTagValue(req)
@@ -151,6 +168,7 @@ func (s *Server) onInvite(req *sip.Request, tx sip.ServerTransaction) {
 	defer cmon.SessionDur()()
 	callID := lksip.NewCallID()
 	joinDur := cmon.JoinDur()
+	inviteDur := cmon.InviteDur()
 	log := s.log.WithValues(
 		"call-id", callID, "sip-tag", tag,
 		"from-ip", src, "from-host", from.Address.Host, "from-user", from.Address.User,
@@ -158,7 +176,21 @@ func (s *Server) onInvite(req *sip.Request, tx sip.ServerTransaction) {
 	)
 	log.Infow("INVITE received")
 
-	username, password, drop, err := s.handler.GetAuthCredentials(ctx, from.Address.User, to.Address.User, to.Address.Host, src)
+	toUser := to.Address.User
+	slotID, retrieve := s.parkingSlot(toUser)
+	if retrieve {
+		slot, ok := s.park.Lookup(slotID)
+		if !ok {
+			cmon.InviteErrorShort("no-parked-call")
+			log.Infow("Rejecting inbound, no call is parked in the slot", "slot", slotID)
+			_ = tx.Respond(sip.NewResponseFromRequest(req, 404, "Not Found", nil))
+			return
+		}
+		// Retrieval must be authorized for the number of the parked call.
+		toUser = slot.ToUser
+	}
+
+	username, password, drop, err := s.handler.GetAuthCredentials(ctx, from.Address.User, toUser, to.Address.Host, src)
 	if err != nil {
 		cmon.InviteErrorShort("no-rule")
 		log.Warnw("Rejecting inbound, doesn't match any Trunks", err)
@@ -177,9 +209,51 @@ func (s *Server) onInvite(req *sip.Request, tx sip.ServerTransaction) {
 	}
 	cmon.InviteAccept()
 
-	call := s.newInboundCall(log, cmon, callID, tag, from, to, src)
-	call.joinDur = joinDur
-	call.handleInvite(call.ctx, req, tx, s.conf)
+	var replaces *replacesHeader
+	if h := req.GetHeader("Replaces"); h != nil {
+		replaces, err = parseReplaces(h.Value())
+		if err != nil {
+			log.Warnw("Rejecting inbound, invalid Replaces header", err)
+			sipErrorResponse(tx, req)
+			return
+		}
+		if _, ok := s.dialogs.LookupReplaces(replaces); !ok {
+			log.Infow("Rejecting inbound, replaced dialog not found", "replaces-call-id", replaces.CallID)
+			_ = tx.Respond(sip.NewResponseFromRequest(req, 481, "Call/Transaction Does Not Exist", nil))
+			return
+		}
+	}
+
+	var retrieved *parking.Slot
+	if retrieve {
+		if retrieved, ok = s.park.Retrieve(slotID); !ok {
+			cmon.InviteErrorShort("no-parked-call")
+			log.Infow("Rejecting inbound, parked call was already retrieved", "slot", slotID)
+			_ = tx.Respond(sip.NewResponseFromRequest(req, 404, "Not Found", nil))
+			return
+		}
+	}
+
+	prof := startCallProfile(s.conf, log, callID)
+	// Goroutines started for the call inherit its profiler labels.
+	prof.Do(func() {
+		call := s.newInboundCall(log, cmon, callID, tag, from, to, src)
+		call.prof = prof
+		call.authUser, call.authPass = username, password
+		if sipCallID, ok := req.CallID(); ok {
+			call.sipCallID = sipCallID.Value()
+		}
+		call.replaces = replaces
+		call.diversion = parseDiversion(req)
+		call.headers = customHeaders(req)
+		if len(call.diversion) > 0 {
+			log.Infow("Inbound call was forwarded", "diverted-from", call.diversion[0].User, "diversion-reason", call.diversion[0].Reason, "diversions", len(call.diversion))
+		}
+		call.retrieve = retrieved
+		call.joinDur = joinDur
+		call.inviteDur = inviteDur
+		call.handleInvite(call.ctx, req, tx, s.conf)
+	})
 }
 
 func (s *Server) onBye(req *sip.Request, tx sip.ServerTransaction) {
@@ -207,25 +281,62 @@ type inboundCall struct {
 	mon           *stats.CallMonitor
 	id            string
 	tag           string
+	sipCallID     string
+	replaces      *replacesHeader         // set for attended transfers
+	diversion     []Diversion             // set for forwarded calls
+	headers       map[string]string       // custom headers of the INVITE
+	transcriber   *transcription.Streamer // set if transcription is enabled
+	retrieve      *parking.Slot           // set for calls retrieving a parked call
 	ctx           context.Context
 	cancel        func()
+	dialogMu      sync.Mutex // protects inviteReq, inviteResp and localSDP
 	inviteReq     *sip.Request
 	inviteResp    *sip.Response
+	localSDP      []byte        // last SDP offer or answer sent to the caller
+	sipCSeq       atomic.Uint32 // last CSeq used for requests sent in the dialog
+	transfer      sipTransfer
 	from          *sip.FromHeader
 	to            *sip.ToHeader
 	src           string
+	authUser      string // inbound credentials; re-INVITEs must be authorized with them too
+	authPass      string
+	mediaMu       sync.Mutex // protects rtpConn, mediaRes and fax, which are used by re-INVITEs
+	offerMu       sync.Mutex // held while an SDP offer of re-INVITE or UPDATE is handled
 	rtpConn       *rtp.Conn
+	rtpKeepAlive  func() // stops RTP keepalive
+	rtcpReports   func() // stops RTCP sender reports
+	trunkID       string
 	audioCodec    rtp.AudioCodec
 	audioHandler  atomic.Pointer[rtp.Handler]
+	lkAudio       media.SwitchWriter[media.PCM16Sample] // decoded audio sent to the room
+	preBuffer     *audioPreBuffer                       // audio received before the room is joined
 	audioReceived atomic.Bool
 	audioRecvChan chan struct{}
 	audioType     byte
+	mediaRes      *sdpCodecResult // negotiated codecs; reused for re-INVITE answers
+	fax           *faxRelay       // set while the call is in T.38 fax mode
+	remoteDTLS    *sdpDTLS        // DTLS-SRTP parameters of the caller; only set if DTLS-SRTP is negotiated
+	dtlsSess      *dtls.Session
+	iceAgent      *iceAgent       // set if ICE is negotiated
+	prack         prackState      // reliable provisional responses; only used if the caller requires 100rel
 	dtmf          chan dtmf.Event // buffered
 	lkRoom        *Room           // LiveKit room; only active after correct pin is entered
+	startedAt     time.Time
+	answeredAt    time.Time
 	callDur       func() time.Duration
 	joinDur       func() time.Duration
+	inviteDur     func() time.Duration
+	prof          *callpprof.Session // optional
 	forwardDTMF   atomic.Bool
+	muted         atomic.Bool // set by the call control API or MuteSIPParticipant; audio sent to the room is replaced with silence
 	done          atomic.Bool
+	endReason     atomic.Pointer[string] // set by CloseWithReason; overrides the reason passed to close
+
+	holdMu   sync.Mutex
+	onHold   bool
+	mohStop  chan struct{}     // stops music-on-hold sent to the room
+	lkTrack  media.PCM16Writer // participant track; lkAudio is detached from it while on hold
+	sipAudio media.PCM16Writer // audio sent to the SIP participant; detached from the room while on hold
 }
 
 func (s *Server) newInboundCall(log logger.Logger, mon *stats.CallMonitor, id, tag string, from *sip.FromHeader, to *sip.ToHeader, src string) *inboundCall {
@@ -240,7 +351,26 @@ func (s *Server) newInboundCall(log logger.Logger, mon *stats.CallMonitor, id, t
 		src:           src,
 		audioRecvChan: make(chan struct{}),
 		dtmf:          make(chan dtmf.Event, 10),
-		lkRoom:        NewRoom(log), // we need it created earlier so that the audio mixer is available for pin prompts
+		lkRoom:        newRoom(log, s.connectRoom), // we need it created earlier so that the audio mixer is available for pin prompts
+	}
+	c.lkRoom.monitorMixer(s.mon)
+	c.lkRoom.OnDial(s.dial.dialFromRoom(log, c.lkRoom))
+	c.lkRoom.OnMWI(func(data []byte) {
+		// Trunk is known before the room is joined.
+		s.onMWIUpdate(c.trunkID, data)
+	})
+	if s.conf.LiveKitDataChannelTransferEnabled {
+		c.lkRoom.OnTransfer(transferFromRoom(s.conf, log, c.lkRoom, id, c.transferCall))
+	}
+	if s.conf.TranscriptionWebhookURL != "" {
+		c.lkRoom.OnTranscription(func(text string) {
+			// Do not block the room callback while waiting for the SIP response.
+			go func() {
+				if err := c.sipTranscription(text); err != nil {
+					log.Warnw("Cannot send transcript with SIP INFO", err)
+				}
+			}()
+		})
 	}
 	c.ctx, c.cancel = context.WithCancel(context.Background())
 	s.cmu.Lock()
@@ -249,23 +379,113 @@ func (s *Server) newInboundCall(log logger.Logger, mon *stats.CallMonitor, id, t
 	return c
 }
 
+func (c *inboundCall) callInfo(pin string, noPin bool) *CallInfo {
+	info := &CallInfo{
+		ID:         c.id,
+		FromUser:   c.from.Address.User,
+		ToUser:     c.to.Address.User,
+		ToHost:     c.to.Address.Host,
+		SrcAddress: c.src,
+		Pin:        pin,
+		NoPin:      noPin,
+		OnHold:     c.isOnHold(),
+
+		DiversionHeader: c.diversion,
+		Headers:         c.headers,
+
+		StartedAt:  c.startedAt,
+		AnsweredAt: c.answeredAt,
+	}
+	if c.replaces != nil {
+		if d, ok := c.s.dialogs.LookupReplaces(c.replaces); ok {
+			info.ReplaceCallID = d.CallID
+			info.ReplaceRoomName = d.RoomName
+		}
+	}
+	return info
+}
+
+// dispatch evaluates dispatch rules for the call.
+//
+// A call replacing another one (attended transfer) always joins the room of the replaced call.
+// Token issued for a different room cannot be used in that case, so the bridge connects with its own credentials.
+func (c *inboundCall) dispatch(ctx context.Context, pin string, noPin bool) CallDispatch {
+	info := c.callInfo(pin, noPin)
+	disp := c.s.handler.DispatchCall(ctx, info)
+	if disp.Result == DispatchAccept && info.ReplaceRoomName != "" && disp.RoomName != info.ReplaceRoomName {
+		c.log.Infow("SIP call replaces an existing call, overriding the room",
+			"replaces-call-id", info.ReplaceCallID, "room", info.ReplaceRoomName, "dispatch-room", disp.RoomName)
+		disp.RoomName = info.ReplaceRoomName
+		disp.WsUrl, disp.Token = "", ""
+	}
+	return disp
+}
+
+func (c *inboundCall) newEvent(typ string) *webhook.CallEvent {
+	return &webhook.CallEvent{
+		Event:     typ,
+		CallID:    c.id,
+		Direction: stats.Inbound.String(),
+		From:      c.from.Address.User,
+		To:        c.to.Address.User,
+		RoomName:  c.lkRoom.Participant().RoomName,
+	}
+}
+
+// cdrRecord returns the call detail record of the call, which ends with a given reason.
+func (c *inboundCall) cdrRecord(reason string) *cdr.CDRRecord {
+	r := &cdr.CDRRecord{
+		CallID:      c.id,
+		TrunkID:     c.trunkID,
+		From:        c.from.Address.User,
+		To:          c.to.Address.User,
+		StartTime:   c.startedAt,
+		AnswerTime:  c.answeredAt,
+		EndTime:     time.Now(),
+		Direction:   stats.Inbound.String(),
+		HangupCause: reason,
+	}
+	if !r.AnswerTime.IsZero() {
+		r.Duration = r.EndTime.Sub(r.AnswerTime).Seconds()
+	}
+	c.mediaMu.Lock()
+	setCDRStats(r, c.rtpConn.Stats())
+	c.mediaMu.Unlock()
+	return r
+}
+
+// publishState sends the call state to the event state compositor, if configured.
+func (c *inboundCall) publishState() {
+	if c.s.pub == nil {
+		return
+	}
+	body, err := publish.CallDocument(c.to.Address.User, c.s.signalingIp, c.id, c.from.Address.String())
+	if err != nil {
+		c.log.Warnw("Cannot generate call state document", err)
+		return
+	}
+	c.s.pub.Publish(c.id, c.to.Address.User, body)
+}
+
 func (c *inboundCall) handleInvite(ctx context.Context, req *sip.Request, tx sip.ServerTransaction, conf *config.Config) {
+	c.startedAt = time.Now()
+	c.s.hook.Notify(c.newEvent(webhook.EventCallStarted))
+	c.publishState()
 	c.mon.CallStart()
 	defer c.mon.CallEnd()
 	defer c.close("other")
 	// Send initial request. In the best case scenario, we will immediately get a room name to join.
 	// Otherwise, we could even learn that this number is not allowed and reject the call, or ask for pin if required.
-	disp := c.s.handler.DispatchCall(ctx, &CallInfo{
-		ID:         c.id,
-		FromUser:   c.from.Address.User,
-		ToUser:     c.to.Address.User,
-		ToHost:     c.to.Address.Host,
-		SrcAddress: c.src,
-		Pin:        "",
-		NoPin:      false,
-	})
+	var disp CallDispatch
+	if c.retrieve != nil {
+		disp = c.retrieveDispatch(c.retrieve)
+	} else {
+		disp = c.dispatch(ctx, "", false)
+	}
 	if disp.TrunkID != "" {
 		c.log = c.log.WithValues("sip-trunk", disp.TrunkID)
+		c.trunkID = disp.TrunkID
+		defer c.s.trunkStatus.CallStarted(c.trunkID)()
 	}
 	if disp.DispatchRuleID != "" {
 		c.log = c.log.WithValues("sip-rule", disp.DispatchRuleID)
@@ -289,10 +509,46 @@ func (c *inboundCall) handleInvite(ctx context.Context, req *sip.Request, tx sip
 	case DispatchAccept, DispatchRequestPin:
 		// continue
 	}
+	limit := conf.GetMaxConcurrentCalls(c.trunkID)
+	release, ok := c.s.trunks.Acquire(c.trunkID, limit)
+	var queued *queue.Call // set while the call waits for a slot
+	if !ok && conf.CallQueueEnabled {
+		queued = &queue.Call{ID: c.id}
+		if pos, _ := c.s.callQueue(c.trunkID).Enqueue(queued); pos > 0 {
+			c.log.Infow("Queueing inbound call, trunk capacity exceeded", "max-calls", limit, "position", pos)
+		} else {
+			queued = nil
+		}
+	}
+	if !ok && queued == nil {
+		c.log.Warnw("Rejecting inbound call, trunk capacity exceeded", nil, "max-calls", limit)
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 503, "Service Unavailable", nil))
+		c.close("trunk-capacity-exceeded")
+		return
+	}
+	defer func() {
+		// The slot may be handed over after the caller hung up.
+		if queued != nil && !c.s.callQueue(c.trunkID).Remove(queued) {
+			release = queued.Slot()
+		}
+		if release != nil {
+			c.s.releaseTrunkSlot(c.trunkID, release)
+		}
+	}()
 
 	// We need to start media first, otherwise we won't be able to send audio prompts to the caller, or receive DTMF.
-	answerData, err := c.runMediaConn(req.Body(), conf)
-	if err != nil {
+	answerData, err := c.runMediaConn(messageSDP(c.log, req), conf)
+	if errors.Is(err, rtp.ListenErr) {
+		c.log.Errorw("Cannot allocate RTP port", err)
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 503, "Service Unavailable", nil))
+		c.close("no-rtp-ports")
+		return
+	} else if errors.Is(err, errSRTPNotEnabled) {
+		c.log.Infow("Rejecting inbound call, DTLS-SRTP is not enabled")
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 488, "Not Acceptable Here", nil))
+		c.close("media-not-acceptable")
+		return
+	} else if err != nil {
 		sipErrorResponse(tx, req)
 		c.close("media-failed")
 		return
@@ -301,7 +557,7 @@ func (c *inboundCall) handleInvite(ctx context.Context, req *sip.Request, tx sip
 	res := sip.NewResponseFromRequest(req, 200, "OK", answerData)
 
 	// This will effectively redirect future SIP requests to this server instance (if signalingIp is not LB).
-	res.AppendHeader(&sip.ContactHeader{Address: sip.Uri{Host: c.s.signalingIp, Port: c.s.conf.SIPPort}})
+	res.AppendHeader(c.s.contactHeader(req))
 
 	// When behind LB, the source IP may be incorrect and/or the UDP "session" timeout may expire.
 	// This is critical for sending new requests like BYE.
@@ -317,12 +573,76 @@ func (c *inboundCall) handleInvite(ctx context.Context, req *sip.Request, tx sip
 	}
 
 	res.AppendHeader(&contentTypeHeaderSDP)
+	if requires100rel(req) {
+		// Send the answer in a reliable 183 first. The 200 must only be sent once it's acknowledged with PRACK.
+		progress := sip.NewResponseFromRequest(req, 183, "Session Progress", answerData)
+		progress.AppendHeader(c.s.contactHeader(req))
+		progress.SetDestination(res.Destination())
+		progress.AppendHeader(&contentTypeHeaderSDP)
+		// Both responses must establish the same dialog.
+		if pto, ok := progress.To(); ok {
+			if tag, ok := pto.Params.Get("tag"); ok {
+				if to, ok := res.To(); ok {
+					to.Params.Add("tag", tag)
+				}
+			}
+		}
+		if err = c.sendReliable(ctx, tx, req, progress); err != nil {
+			c.log.Warnw("Reliable provisional response was not acknowledged", err)
+			if errors.Is(err, errPRACKTimeout) {
+				_ = tx.Respond(sip.NewResponseFromRequest(req, 504, "Server Time-out", nil))
+				c.close("prack-timeout")
+			} else {
+				c.close("prack-failed")
+			}
+			return
+		}
+	}
 	if err = tx.Respond(res); err != nil {
 		c.log.Errorw("Cannot respond to INVITE", err)
 		return
 	}
+	if c.inviteDur != nil {
+		c.inviteDur()
+	}
+	c.dialogMu.Lock()
 	c.inviteReq = req
 	c.inviteResp = res
+	c.localSDP = answerData
+	c.dialogMu.Unlock()
+	if h, ok := req.CSeq(); ok {
+		c.sipCSeq.Store(h.SeqNo)
+	}
+	if c.remoteDTLS != nil {
+		sess, err := startDTLS(ctx, c.rtpConn, c.s.dtlsCert, c.mediaRes.DTLS.Setup, c.remoteDTLS)
+		if err != nil {
+			c.log.Errorw("DTLS-SRTP handshake failed", err)
+			c.close("dtls-failed")
+			return
+		}
+		c.log.Infow("DTLS-SRTP established", "profile", sess.Profile())
+		c.dtlsSess = sess
+	}
+	c.answeredAt = time.Now()
+	c.s.hook.Notify(c.newEvent(webhook.EventCallAnswered))
+	if t := startMaxDuration(c.log, c.mon, c.trunkID, conf.GetMaxCallDuration(c.trunkID), c.CloseWithReason); t != nil {
+		defer t.Stop()
+	}
+	if conf.MediaTimeoutDetection {
+		w := &mediaWatch{
+			log:     c.log,
+			mon:     c.mon,
+			conn:    c.rtpConn,
+			timeout: conf.GetMediaTimeout(),
+			ignore: func() bool {
+				// No audio is expected during hold, or during the fax.
+				return c.isOnHold() || c.isFax()
+			},
+			refresh: c.sipRefreshSession,
+			close:   c.CloseWithReason,
+		}
+		go w.run(ctx.Done())
+	}
 
 	// Wait for either a first RTP packet or a predefined delay.
 	//
@@ -344,6 +664,13 @@ func (c *inboundCall) handleInvite(ctx context.Context, req *sip.Request, tx sip
 		delay.Stop()
 	case <-delay.C:
 	}
+	if queued != nil {
+		if !c.waitInQueue(ctx, c.s.callQueue(c.trunkID), queued) {
+			c.close("hangup")
+			return
+		}
+		release, queued = queued.Slot(), nil
+	}
 	switch disp.Result {
 	default:
 		c.log.Errorw("Rejecting inbound call", fmt.Errorf("unreachable dispatch result path: %v", disp.Result))
@@ -351,9 +678,9 @@ func (c *inboundCall) handleInvite(ctx context.Context, req *sip.Request, tx sip
 		c.close("unreachable-path")
 		return
 	case DispatchRequestPin:
-		c.pinPrompt(ctx)
+		c.pinPrompt(ctx, newPINConfig(disp))
 	case DispatchAccept:
-		c.joinRoom(ctx, disp.RoomName, disp.Identity, disp.Name, disp.Metadata, disp.WsUrl, disp.Token)
+		c.joinRoom(ctx, disp.RoomName, disp.Identity, disp.Name, disp.Metadata, disp.Attributes, disp.WsUrl, disp.Token)
 	}
 	// Wait for the caller to terminate the call.
 	select {
@@ -365,6 +692,8 @@ func (c *inboundCall) handleInvite(ctx context.Context, req *sip.Request, tx sip
 }
 
 func (c *inboundCall) sendBye() {
+	c.dialogMu.Lock()
+	defer c.dialogMu.Unlock()
 	if c.inviteReq == nil {
 		return
 	}
@@ -375,6 +704,9 @@ func (c *inboundCall) sendBye() {
 	} else {
 		bye.Recipient = &c.from.Address
 	}
+	if h, ok := bye.CSeq(); ok {
+		h.SeqNo = c.sipCSeq.Add(1)
+	}
 	bye.SetSource(c.inviteResp.Source())
 	bye.SetDestination(c.inviteResp.Destination())
 	bye.RemoveHeader("From")
@@ -390,140 +722,366 @@ func (c *inboundCall) sendBye() {
 	c.inviteResp = nil
 }
 
+// sipDialogRequest creates a new request from the bridge to the caller within the call dialog.
+func (c *inboundCall) sipDialogRequest(method sip.RequestMethod) (*sip.Request, error) {
+	c.dialogMu.Lock()
+	defer c.dialogMu.Unlock()
+	if c.inviteReq == nil || c.inviteResp == nil {
+		return nil, errors.New("call is not active")
+	}
+	target := c.from.Address
+	if contact, ok := c.inviteReq.Contact(); ok {
+		target = contact.Address
+	}
+	req := sip.NewRequest(method, &target)
+	req.SetDestination(c.inviteResp.Destination())
+	if to, ok := c.inviteResp.To(); ok {
+		req.AppendHeader((*sip.FromHeader)(to))
+	}
+	req.AppendHeader((*sip.ToHeader)(c.from))
+	if h, ok := c.inviteReq.CallID(); ok {
+		req.AppendHeader(h)
+	}
+	req.AppendHeader(&sip.CSeqHeader{SeqNo: c.sipCSeq.Add(1), MethodName: method})
+	return req, nil
+}
+
 func (c *inboundCall) runMediaConn(offerData []byte, conf *config.Config) (answerData []byte, _ error) {
+	start := time.Now()
 	offer := sdp.SessionDescription{}
 	if err := offer.Unmarshal(offerData); err != nil {
 		return nil, err
 	}
-	res, err := sdpGetAudioCodec(offer)
+	res, err := sdpGetAudioCodecWith(offer, conf.CodecPreference[c.trunkID])
 	if err != nil {
 		return nil, err
 	}
+	sdpDur := time.Since(start)
+	if conf.RedundancyLevel <= 0 {
+		res.REDType = 0
+	}
 	c.log.Infow("Using codecs",
 		"audio-codec", res.Audio.Info().SDPName, "audio-rtp", res.AudioType,
-		"dtmf-rtp", res.DTMFType,
+		"dtmf-rtp", res.DTMFType, "red-rtp", res.REDType,
 	)
+	remoteDTLS := sdpGetDTLS(offer)
+	if remoteDTLS != nil {
+		if c.s.dtlsCert == nil {
+			return nil, errSRTPNotEnabled
+		}
+		res.DTLS = &sdpDTLS{Fingerprint: c.s.dtlsCert.Fingerprint(), Setup: dtls.AnswerRole(remoteDTLS.Setup)}
+	}
 
-	conn := rtp.NewConn(func() {
-		c.close("media-timeout")
-	})
+	var onTimeout func()
+	if !conf.MediaTimeoutDetection {
+		onTimeout = func() {
+			if c.isFax() {
+				// No audio is sent during the fax.
+				c.log.Infow("Ignoring media timeout during T.38 fax")
+				return
+			}
+			c.CloseWithReason("media-timeout")
+		}
+	}
+	conn := rtp.NewConn(onTimeout)
+	if remoteDTLS != nil {
+		// Media is dropped until the DTLS handshake completes.
+		conn.RequireCipher()
+	}
 	mux := rtp.NewMux(nil)
 	mux.SetDefault(newRTPStatsHandler(c.mon, "", nil))
 	mux.Register(res.AudioType, newRTPStatsHandler(c.mon, res.Audio.Info().SDPName, rtp.HandlerFunc(c.handleAudio)))
 	if res.DTMFType != 0 {
 		mux.Register(res.DTMFType, newRTPStatsHandler(c.mon, dtmf.SDPName, rtp.HandlerFunc(c.handleDTMF)))
 	}
-	conn.OnRTP(mux)
+	if res.REDType != 0 {
+		mux.Register(res.REDType, newRTPStatsHandler(c.mon, rtp.REDSDPName, rtp.NewREDHandler(rtp.HandlerFunc(c.handleAudio), res.AudioType)))
+	}
+	clock := rtp.NewSenderClock(rtp.DefSampleRate)
+	rtpSync := newRTPSyncHandler(c.mon, c.trunkID, clock, newRTPSeqStatsHandler(c.mon, mux))
+	xr := newRTCPXRCollector(conf)
+	conn.OnRTP(newRTPXRHandler(xr, rtpSync))
+
+	// Decoding pipeline (SIP -> LK)
+	// Created early to detect in-band DTMF for the pin prompts. Audio is sent to the room after it's joined.
+	c.transcriber = newTranscriber(conf, c.log, c.id)
+	in := withTranscriber(&muteWriter{w: &c.lkAudio, muted: &c.muted}, c.transcriber)
+	if dtmfAllowInband(conf, res.DTMFType) {
+		c.log.Debugw("Using in-band DTMF detection")
+		in = media.WriterTee(in, dtmf.NewDetector(rtp.DefSampleRate, c.onDTMF))
+	}
+	h := decodeAudio(res.Audio, res.AudioType, rtp.NewDriftCompensator(in, rtpSync.Drift))
+	c.audioHandler.Store(&h)
+	// Audio received before the participant joins is kept, and flushed to the participant track first.
+	c.preBuffer = newAudioPreBuffer(conf.PreBufferDuration, rtp.DefSampleRate)
+	if c.lkAudio.Get() == nil {
+		c.lkAudio.Set(c.preBuffer)
+	}
+
 	if dst := sdpGetAudioDest(offer); dst != nil {
 		conn.SetDestAddr(dst)
 	}
-	if err := conn.ListenAndServe(conf.RTPPort.Start, conf.RTPPort.End, "0.0.0.0"); err != nil {
+	if err := conn.ListenAndServePool(c.s.ports, "0.0.0.0"); err != nil {
 		return nil, err
 	}
 	c.log.Debugw("begin listening on UDP", "port", conn.LocalAddr().Port)
+	var agent *iceAgent
+	if remoteICE := sdpGetICE(offer); remoteICE != nil && conf.ICEEnabled {
+		agent, err = newICEAgent(c.log, conf, conn)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		res.ICE = agent.Local()
+		agent.Start(remoteICE, false)
+	}
+	c.mediaMu.Lock()
 	c.rtpConn = conn
+	c.mediaRes = res
+	c.iceAgent = agent
+	c.mediaMu.Unlock()
+	c.remoteDTLS = remoteDTLS
 	c.audioCodec = res.Audio
 	c.audioType = res.AudioType
+	conn.TrackJitter(res.AudioType, rtp.CodecClockRate(res.Audio))
 
 	// Encoding pipeline (LK -> SIP)
 	// Need to be created earlier to send the pin prompts.
 	s := rtp.NewSeqWriter(newRTPStatsWriter(c.mon, "audio", conn))
-	sa := s.NewStream(c.audioType)
-	audio := c.audioCodec.EncodeRTP(sa)
-	c.lkRoom.SetOutput(audio)
-
-	return sdpGenerateAnswer(offer, c.s.signalingIp, conn.LocalAddr().Port, res)
-}
-
-func (c *inboundCall) pinPrompt(ctx context.Context) {
-	c.log.Infow("Requesting Pin for SIP call")
-	const pinLimit = 16
-	c.playAudio(ctx, c.s.res.enterPin)
-	pin := ""
-	noPin := false
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case b, ok := <-c.dtmf:
-			if !ok {
-				c.Close()
-				return
-			}
-			if b.Digit == 0 {
-				continue // unrecognized
-			}
-			if b.Digit == '#' {
-				// End of the pin
-				noPin = pin == ""
-
-				c.log.Infow("Checking Pin for SIP call", "pin", pin, "noPin", noPin)
-				disp := c.s.handler.DispatchCall(ctx, &CallInfo{
-					ID:         c.id,
-					FromUser:   c.from.Address.User,
-					ToUser:     c.to.Address.User,
-					ToHost:     c.to.Address.Host,
-					SrcAddress: c.src,
-					Pin:        pin,
-					NoPin:      noPin,
-				})
-				if disp.TrunkID != "" {
-					c.log = c.log.WithValues("sip-trunk", disp.TrunkID)
-				}
-				if disp.DispatchRuleID != "" {
-					c.log = c.log.WithValues("sip-rule", disp.DispatchRuleID)
-				}
-				if disp.Result != DispatchAccept || disp.RoomName == "" {
-					c.log.Infow("Rejecting call", "pin", pin, "noPin", noPin)
-					c.playAudio(ctx, c.s.res.wrongPin)
-					c.close("wrong-pin")
-					return
-				}
-				c.playAudio(ctx, c.s.res.roomJoin)
-				c.joinRoom(ctx, disp.RoomName, disp.Identity, disp.Name, disp.Metadata, disp.WsUrl, disp.Token)
-				return
-			}
-			// Gather pin numbers
-			pin += string(b.Digit)
-			if len(pin) > pinLimit {
-				c.playAudio(ctx, c.s.res.wrongPin)
-				c.close("wrong-pin")
-				return
-			}
-		}
+	var sa *rtp.Stream
+	codec := c.audioCodec
+	if res.REDType != 0 {
+		sa = s.NewStream(res.REDType)
+		codec = rtp.NewFECRedundancyCodec(c.audioCodec, c.audioType, conf.RedundancyLevel)
+	} else {
+		sa = s.NewStream(c.audioType)
+	}
+	audio := encodeAudio(conf, codec, sa)
+	c.holdMu.Lock()
+	c.sipAudio = audio
+	if !c.onHold {
+		c.lkRoom.SetOutput(audio)
+	}
+	c.holdMu.Unlock()
+	if dt := conf.NATKeepAlive(c.trunkID); dt > 0 {
+		c.rtpKeepAlive = s.KeepAlive(dt)
+	}
+	conn.OnRTCP(newRTCPStatsHandler(c.mon, c.trunkID, s.SSRC(), clock, xr))
+	if startRTCP(c.log, conn, res.RTCPMux, remoteDTLS != nil) {
+		c.rtcpReports = s.SenderReportsWith(conn, rtp.DefSampleRate, rtp.DefRTCPInterval, rtcpXRReports(xr, s.SSRC()))
+	}
+
+	start = time.Now()
+	answerData, err = sdpGenerateAnswer(offer, c.s.signalingIp, conn.LocalAddr().Port, res)
+	if err != nil {
+		return nil, err
+	}
+	c.mon.SDPNegotiationDur(sdpDur + time.Since(start))
+	return answerData, nil
+}
+
+func (c *inboundCall) pinPrompt(ctx context.Context, conf pinConfig) {
+	c.log.Infow("Requesting Pin for SIP call", "digits", conf.digits)
+	prompt := c.s.pinPromptAudio(conf.promptFile)
+	c.playAudio(ctx, prompt)
+	pin, err := collectPIN(ctx, c.dtmf, conf, func() {
+		c.log.Infow("Pin entry timed out, prompting again")
+		c.playAudio(ctx, c.s.res.wrongPin)
+		c.playAudio(ctx, prompt)
+	})
+	switch {
+	case ctx.Err() != nil:
+		return
+	case errors.Is(err, errPINClosed):
+		c.Close()
+		return
+	case errors.Is(err, errPINTimeout):
+		c.log.Infow("Rejecting call, no pin entered")
+		c.playAudio(ctx, c.s.res.wrongPin)
+		c.close("pin-timeout")
+		return
+	case err != nil:
+		c.playAudio(ctx, c.s.res.wrongPin)
+		c.close("wrong-pin")
+		return
 	}
+	noPin := pin == ""
+
+	c.log.Infow("Checking Pin for SIP call", "pin", pin, "noPin", noPin)
+	disp := c.dispatch(ctx, pin, noPin)
+	if disp.TrunkID != "" {
+		c.log = c.log.WithValues("sip-trunk", disp.TrunkID)
+	}
+	if disp.DispatchRuleID != "" {
+		c.log = c.log.WithValues("sip-rule", disp.DispatchRuleID)
+	}
+	if disp.Result != DispatchAccept || disp.RoomName == "" {
+		c.log.Infow("Rejecting call", "pin", pin, "noPin", noPin)
+		c.playAudio(ctx, c.s.res.wrongPin)
+		c.close("wrong-pin")
+		return
+	}
+	c.playAudio(ctx, c.s.res.roomJoin)
+	c.joinRoom(ctx, disp.RoomName, disp.Identity, disp.Name, disp.Metadata, disp.Attributes, disp.WsUrl, disp.Token)
 }
 
-// close should only be called from handleInvite.
+// close should only be called from handleInvite. Other goroutines must use Close or CloseWithReason.
 func (c *inboundCall) close(reason string) {
 	if !c.done.CompareAndSwap(false, true) {
 		return
 	}
+	if r := c.endReason.Load(); r != nil {
+		reason = *r
+	}
 	c.mon.CallTerminate(reason)
 	c.log.Infow("Closing inbound call", "reason", reason)
+	if c.answeredAt.IsZero() {
+		c.s.trunkStatus.CallFailed(c.trunkID, reason)
+	}
+	var ended *CallInfo
+	if !c.startedAt.IsZero() {
+		ev := c.newEvent(webhook.EventCallEnded)
+		ev.Duration = time.Since(c.startedAt).Seconds()
+		ev.Reason = reason
+		c.s.hook.Notify(ev)
+		c.s.cdrs.Export(c.cdrRecord(reason))
+		c.s.pub.Remove(c.id)
+		ended = c.endedInfo()
+	}
 	c.sendBye()
 	c.closeMedia()
+	if ended != nil {
+		// Don't delay the cleanup of the call by the handler.
+		go c.s.callEnded(ended, reason)
+	}
 	if c.callDur != nil {
 		c.callDur()
 	}
+	stopCallProfile(c.log, c.mon, c.prof)
 	c.s.cmu.Lock()
 	delete(c.s.activeCalls, c.tag)
 	c.s.cmu.Unlock()
+	c.s.dialogs.Unregister(c.sipCallID, c.tag)
 	c.cancel()
 }
 
+// callEnded notifies the handler that the call has ended.
+func (s *Server) callEnded(info *CallInfo, reason string) {
+	ctx, cancel := context.WithTimeout(context.Background(), callEndedTimeout)
+	defer cancel()
+	s.handler.CallEnded(ctx, info, reason)
+}
+
+// endedInfo returns the info of the call passed to Handler.CallEnded.
+// Unlike callInfo, it doesn't take any locks, since the call may be closed from any state.
+func (c *inboundCall) endedInfo() *CallInfo {
+	return &CallInfo{
+		ID:         c.id,
+		FromUser:   c.from.Address.User,
+		ToUser:     c.to.Address.User,
+		ToHost:     c.to.Address.Host,
+		SrcAddress: c.src,
+		Headers:    c.headers,
+		StartedAt:  c.startedAt,
+		AnsweredAt: c.answeredAt,
+		EndedAt:    time.Now(),
+	}
+}
+
 func (c *inboundCall) Close() error {
 	c.cancel()
 	return nil
 }
 
+// CloseWithReason ends the call from any goroutine. The call is closed by handleInvite, with a given reason.
+func (c *inboundCall) CloseWithReason(reason string) {
+	c.endReason.CompareAndSwap(nil, &reason)
+	c.cancel()
+}
+
+func (c *inboundCall) isOnHold() bool {
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+	return c.onHold
+}
+
+// setOnHold puts the call on hold or resumes it. While on hold, no audio is exchanged with the caller,
+// and the room hears music-on-hold (if configured) instead of the caller audio.
+func (c *inboundCall) setOnHold(hold bool) {
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+	if c.onHold == hold {
+		return
+	}
+	c.onHold = hold
+	// The answer to a hold offer is either "recvonly" or "inactive", thus room audio is not sent to SIP while on hold.
+	if hold {
+		c.lkAudio.Set(nil)
+		c.lkRoom.SetOutput(nil)
+	} else {
+		if c.lkTrack != nil {
+			c.lkAudio.Set(c.lkTrack)
+		}
+		if c.sipAudio != nil {
+			c.lkRoom.SetOutput(c.sipAudio)
+		}
+	}
+	if !c.done.Load() {
+		if err := c.lkRoom.publishHold(hold); err != nil {
+			c.log.Warnw("Cannot send hold state to the room", err)
+		}
+	}
+	if c.mohStop != nil {
+		close(c.mohStop)
+		c.mohStop = nil
+	}
+	if hold {
+		c.startMusicOnHoldLocked()
+	}
+}
+
+// startMusicOnHoldLocked plays music-on-hold to the room in place of the caller audio, if it's configured.
+// Must be called with holdMu held.
+func (c *inboundCall) startMusicOnHoldLocked() {
+	if c.s.moh == nil || c.lkTrack == nil || c.mohStop != nil {
+		return
+	}
+	c.log.Infow("Playing music-on-hold")
+	c.mohStop = make(chan struct{})
+	go playMusicOnHold(c.s.moh.NewReader(), c.lkTrack, c.mohStop)
+}
+
 func (c *inboundCall) closeMedia() {
+	c.setOnHold(false)
 	c.audioHandler.Store(nil)
+	_ = c.transcriber.Close()
+	c.lkAudio.Set(nil)
 	c.lkRoom.Close()
+	if c.rtpKeepAlive != nil {
+		c.rtpKeepAlive()
+		c.rtpKeepAlive = nil
+	}
+	if c.rtcpReports != nil {
+		c.rtcpReports()
+		c.rtcpReports = nil
+	}
+	if c.dtlsSess != nil {
+		_ = c.dtlsSess.Close()
+		c.dtlsSess = nil
+	}
+	c.stopFax()
+	c.mediaMu.Lock()
+	if c.iceAgent != nil {
+		c.iceAgent.Close()
+		c.iceAgent = nil
+	}
 	if c.rtpConn != nil {
+		logRTPStats(c.log, c.rtpConn.Stats())
 		c.rtpConn.Close()
 		c.rtpConn = nil
 	}
+	c.mediaRes = nil
+	c.mediaMu.Unlock()
 }
 
 func (c *inboundCall) handleAudio(p *rtp.Packet) error {
@@ -536,14 +1094,27 @@ func (c *inboundCall) handleAudio(p *rtp.Packet) error {
 	return nil
 }
 
-func (c *inboundCall) createLiveKitParticipant(ctx context.Context, roomName, parIdentity, parName, parMeta, wsUrl, token string) error {
+// participantAttributes returns attributes of the SIP participant joining the room, in addition to the dispatched ones.
+func (c *inboundCall) participantAttributes(extra map[string]string) map[string]string {
+	attrs := maps.Clone(extra)
+	if attrs == nil {
+		attrs = make(map[string]string)
+	}
+	attrs[AttrCallID] = c.sipCallID
+	attrs[AttrFrom] = c.from.Address.User
+	attrs[AttrTo] = c.to.Address.User
+	attrs[AttrHold] = "false"
+	return attrs
+}
+
+func (c *inboundCall) createLiveKitParticipant(ctx context.Context, roomName, parIdentity, parName, parMeta string, parAttrs map[string]string, wsUrl, token string) error {
 	c.forwardDTMF.Store(true)
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
 	}
-	err := c.lkRoom.Connect(c.s.conf, roomName, parIdentity, parName, parMeta, wsUrl, token)
+	err := c.lkRoom.Connect(c.s.conf, roomName, parIdentity, parName, parMeta, c.participantAttributes(parAttrs), wsUrl, token)
 	if err != nil {
 		return err
 	}
@@ -552,25 +1123,85 @@ func (c *inboundCall) createLiveKitParticipant(ctx context.Context, roomName, pa
 		_ = c.lkRoom.Close()
 		return err
 	}
-
-	// Decoding pipeline (SIP -> LK)
-	var h rtp.Handler = c.audioCodec.DecodeRTP(local, c.audioType)
-	c.audioHandler.Store(&h)
-
+	c.holdMu.Lock()
+	c.lkTrack = local
+	hold := c.onHold
+	if !hold {
+		if dur := c.preBuffer.Buffered(); dur > 0 {
+			c.log.Debugw("Flushing audio received before joining the room", "duration", dur)
+		}
+		if err := c.preBuffer.Flush(local); err != nil {
+			c.log.Warnw("Cannot flush audio received before joining the room", err)
+		}
+		c.lkAudio.Set(local)
+	} else {
+		c.startMusicOnHoldLocked()
+	}
+	c.holdMu.Unlock()
+	if hold {
+		_ = c.lkRoom.publishHold(true)
+	}
 	return nil
 }
 
-func (c *inboundCall) joinRoom(ctx context.Context, roomName, identity, name, meta, wsUrl, token string) {
+func (c *inboundCall) joinRoom(ctx context.Context, roomName, identity, name, meta string, attrs map[string]string, wsUrl, token string) {
+	// Caller must hear the announcement before any room audio is bridged.
+	if !c.playAnnouncement(ctx) {
+		c.close("hangup")
+		return
+	}
 	if c.joinDur != nil {
 		c.joinDur()
 	}
 	c.callDur = c.mon.CallDur()
 	c.log = c.log.WithValues("roomName", roomName, "identity", identity, "name", name)
 	c.log.Infow("Bridging SIP call")
-	if err := c.createLiveKitParticipant(ctx, roomName, identity, name, meta, wsUrl, token); err != nil {
+	if err := c.createLiveKitParticipant(ctx, roomName, identity, name, meta, attrs, wsUrl, token); err != nil {
 		c.log.Errorw("Cannot create LiveKit participant", err)
 		c.close("participant-failed")
+		return
+	}
+	c.s.dialogs.Register(c.sipCallID, c.tag, dialogInfo{CallID: c.id, RoomName: roomName, LocalTag: c.localTag()})
+	if c.replaces != nil {
+		c.replaceCall(c.replaces)
+	}
+}
+
+// localTag returns the tag of the bridge in the call dialog. It's empty until the call is answered.
+func (c *inboundCall) localTag() string {
+	c.dialogMu.Lock()
+	defer c.dialogMu.Unlock()
+	if c.inviteResp == nil {
+		return ""
+	}
+	to, ok := c.inviteResp.To()
+	if !ok {
+		return ""
+	}
+	tag, _ := to.Params.Get("tag")
+	return tag
+}
+
+// replaceCall hangs up the call which was replaced by this one (attended transfer).
+func (c *inboundCall) replaceCall(h *replacesHeader) {
+	c.s.cmu.RLock()
+	old := c.s.activeCalls[h.FromTag]
+	c.s.cmu.RUnlock()
+	if old == nil || old == c || old.sipCallID != h.CallID || old.localTag() != h.ToTag {
+		return
 	}
+	c.log.Infow("Replacing call", "replaced-call-id", old.id)
+	old.CloseWithReason("replaced")
+}
+
+// playAnnouncement plays the recording consent announcement, if configured. It returns false if the call ended meanwhile.
+func (c *inboundCall) playAnnouncement(ctx context.Context) bool {
+	if len(c.s.res.recordingAnnouncement) == 0 {
+		return true
+	}
+	c.log.Debugw("Playing recording announcement")
+	c.playAudio(ctx, c.s.res.recordingAnnouncement)
+	return ctx.Err() == nil
 }
 
 func (c *inboundCall) playAudio(ctx context.Context, frames []media.PCM16Sample) {
@@ -580,21 +1211,33 @@ func (c *inboundCall) playAudio(ctx context.Context, frames []media.PCM16Sample)
 }
 
 func (c *inboundCall) handleDTMF(p *rtp.Packet) error {
+	if !dtmfAllowRTP(c.s.conf) {
+		return nil
+	}
 	tone, ok := dtmf.DecodeRTP(p)
 	if !ok {
 		return nil
 	}
+	c.onDTMF(tone)
+	return nil
+}
+
+// onDTMF handles DTMF digits received via RTP events, SIP INFO or audio tones.
+func (c *inboundCall) onDTMF(tone dtmf.Event) {
+	ev := c.newEvent(webhook.EventCallDTMF)
+	ev.Digit = string([]byte{tone.Digit})
+	c.s.hook.Notify(ev)
 	if c.forwardDTMF.Load() {
 		_ = c.lkRoom.SendData(&livekit.SipDTMF{
 			Code:  uint32(tone.Code),
 			Digit: string([]byte{tone.Digit}),
 		}, lksdk.WithDataPublishReliable(true))
-		return nil
+		_ = c.lkRoom.SendData(dtmfData(c.s.conf.DTMFDataChannelTopic, c.id, tone), lksdk.WithDataPublishReliable(true))
+		return
 	}
 	// We should have enough buffer here.
 	select {
 	case c.dtmf <- tone:
 	default:
 	}
-	return nil
 }
@@ -0,0 +1,114 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"errors"
+
+	"github.com/emiago/sipgo/sip"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media/dtmf"
+)
+
+func dtmfMode(conf *config.Config) config.DTMFMode {
+	if conf.DTMFMode == "" {
+		return config.DTMFModeAuto
+	}
+	return conf.DTMFMode
+}
+
+func dtmfAllowRTP(conf *config.Config) bool {
+	m := dtmfMode(conf)
+	return m == config.DTMFModeRFC4733 || m == config.DTMFModeAuto
+}
+
+func dtmfAllowInfo(conf *config.Config) bool {
+	m := dtmfMode(conf)
+	return m == config.DTMFModeInfo || m == config.DTMFModeAuto
+}
+
+// dtmfAllowInband checks if audio should be scanned for DTMF tones.
+// In auto mode it's only done if the remote side did not negotiate RTP events.
+func dtmfAllowInband(conf *config.Config, dtmfType byte) bool {
+	switch dtmfMode(conf) {
+	case config.DTMFModeInband:
+		return true
+	case config.DTMFModeAuto:
+		return dtmfType == 0
+	}
+	return false
+}
+
+// decodeInfoDTMF decodes DTMF from the SIP INFO request. It responds to the request if decoding fails.
+func decodeInfoDTMF(conf *config.Config, req *sip.Request, tx sip.ServerTransaction) (dtmf.Event, bool) {
+	if !dtmfAllowInfo(conf) {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 469, "Bad Info Package", nil))
+		return dtmf.Event{}, false
+	}
+	var contentType string
+	if h, ok := req.ContentType(); ok {
+		contentType = h.Value()
+	}
+	ev, err := dtmf.DecodeInfo(contentType, req.Body())
+	if errors.Is(err, dtmf.ErrUnsupportedContentType) {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 415, "Unsupported Media Type", nil))
+		return dtmf.Event{}, false
+	} else if err != nil {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 400, "Bad Request", nil))
+		return dtmf.Event{}, false
+	}
+	return ev, true
+}
+
+func (s *Server) onInfo(req *sip.Request, tx sip.ServerTransaction) {
+	tag, err := getTagValue(req)
+	if err != nil {
+		sipErrorResponse(tx, req)
+		return
+	}
+	s.cmu.RLock()
+	c := s.activeCalls[tag]
+	s.cmu.RUnlock()
+	if c == nil {
+		if s.sipUnhandled != nil {
+			s.sipUnhandled(req, tx)
+			return
+		}
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 481, "Call/Transaction Does Not Exist", nil))
+		return
+	}
+	ev, ok := decodeInfoDTMF(s.conf, req, tx)
+	if !ok {
+		return
+	}
+	c.log.Debugw("SIP INFO DTMF", "digit", string([]byte{ev.Digit}))
+	c.onDTMF(ev)
+	_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+}
+
+func (c *Client) onInfo(req *sip.Request, tx sip.ServerTransaction) {
+	call := c.dialogCall(req)
+	if call == nil {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 481, "Call/Transaction Does Not Exist", nil))
+		return
+	}
+	ev, ok := decodeInfoDTMF(c.conf, req, tx)
+	if !ok {
+		return
+	}
+	call.onDTMF(ev)
+	_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+}
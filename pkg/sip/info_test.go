@@ -0,0 +1,93 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media/dtmf"
+)
+
+func newTestInfo(addr, tag, ctype, body string) *sip.Request {
+	req := sip.NewRequest(sip.INFO, &sip.Uri{User: "bob", Host: addr})
+	from := &sip.FromHeader{Address: sip.Uri{User: "alice", Host: "example.com"}, Params: sip.NewParams()}
+	from.Params.Add("tag", tag)
+	req.AppendHeader(from)
+	req.AppendHeader(sip.NewHeader("Content-Type", ctype))
+	req.SetBody([]byte(body))
+	return req
+}
+
+func TestService_InfoDTMF(t *testing.T) {
+	s, addr := startTestService(t, &config.Config{})
+	call := addTestCall(s, "alice", "alice-tag")
+
+	expectDigit := func(t *testing.T, digit byte) {
+		t.Helper()
+		select {
+		case ev := <-call.dtmf:
+			require.Equal(t, digit, ev.Digit)
+		case <-time.After(time.Second):
+			t.Fatal("no digit received")
+		}
+	}
+
+	cases := []struct {
+		name  string
+		ctype string
+		body  string
+		digit byte
+	}{
+		{name: "relay", ctype: dtmf.ContentTypeRelay, body: "Signal=5\r\nDuration=250\r\n", digit: '5'},
+		{name: "relay star", ctype: dtmf.ContentTypeRelay, body: "Signal=*\r\nDuration=160\r\n", digit: '*'},
+		{name: "relay code", ctype: dtmf.ContentTypeRelay, body: "Signal=11\r\n", digit: '#'},
+		{name: "dtmf", ctype: dtmf.ContentTypeDTMF, body: "7", digit: '7'},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := sendTestRequest(t, addr, "alice", newTestInfo(addr, call.tag, c.ctype, c.body))
+			require.Equal(t, sip.StatusCode(200), res.StatusCode)
+			expectDigit(t, c.digit)
+		})
+	}
+	t.Run("bad signal", func(t *testing.T) {
+		res := sendTestRequest(t, addr, "alice", newTestInfo(addr, call.tag, dtmf.ContentTypeRelay, "Signal=X"))
+		require.Equal(t, sip.StatusCode(400), res.StatusCode)
+		require.Empty(t, call.dtmf)
+	})
+	t.Run("content type", func(t *testing.T) {
+		res := sendTestRequest(t, addr, "alice", newTestInfo(addr, call.tag, "application/media_control+xml", "<xml/>"))
+		require.Equal(t, sip.StatusCode(415), res.StatusCode)
+		require.Empty(t, call.dtmf)
+	})
+	t.Run("unknown call", func(t *testing.T) {
+		res := sendTestRequest(t, addr, "alice", newTestInfo(addr, "unknown-tag", dtmf.ContentTypeRelay, "Signal=1"))
+		require.Equal(t, sip.StatusCode(481), res.StatusCode)
+	})
+}
+
+func TestService_InfoDTMFDisabled(t *testing.T) {
+	s, addr := startTestService(t, &config.Config{DTMFMode: config.DTMFModeRFC4733})
+	call := addTestCall(s, "alice", "alice-tag")
+
+	res := sendTestRequest(t, addr, "alice", newTestInfo(addr, call.tag, dtmf.ContentTypeRelay, "Signal=1"))
+	require.Equal(t, sip.StatusCode(469), res.StatusCode)
+	require.Empty(t, call.dtmf)
+}
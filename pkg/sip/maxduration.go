@@ -0,0 +1,36 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"time"
+
+	"github.com/livekit/protocol/logger"
+
+	"github.com/livekit/sip/pkg/stats"
+)
+
+// startMaxDuration closes the answered call once it reaches the max call duration of the trunk.
+// It returns nil if the trunk has no limit. The timer must be stopped once the call ends.
+func startMaxDuration(log logger.Logger, mon *stats.CallMonitor, trunkID string, max time.Duration, close func(reason string)) *time.Timer {
+	if max <= 0 {
+		return nil
+	}
+	return time.AfterFunc(max, func() {
+		log.Infow("Call reached max duration, closing", "max-duration", max)
+		mon.MaxDurationExceeded(trunkID)
+		close("max-duration")
+	})
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+func TestService_MaxCallDuration(t *testing.T) {
+	const maxDur = 2 * time.Second
+	joined := make(chan *testRoomConn, 1)
+	ended := make(chan string, 1)
+	_, addr := startTestService(t, &config.Config{
+		MaxCallDuration: map[string]time.Duration{"ST_limited": maxDur},
+	}, func(s *Service) {
+		s.srv.connectRoom = newTestRoomConnector(joined)
+		s.SetHandler(acceptHandler("room", "ST_limited", func(h *TestHandler) {
+			h.CallEndedFunc = func(ctx context.Context, info *CallInfo, reason string) {
+				ended <- reason
+			}
+		}))
+	})
+
+	alice := newTestPhone(t, "alice")
+	alice.Call(t, addr, "+100", nil)
+	answered := time.Now()
+	var room *testRoomConn
+	select {
+	case room = <-joined:
+	case <-time.After(5 * time.Second):
+		t.Fatal("call did not join the room")
+	}
+	select {
+	case <-alice.bye:
+		require.InDelta(t, maxDur, time.Since(answered), float64(500*time.Millisecond))
+	case <-time.After(2 * maxDur):
+		t.Fatal("call was not hung up")
+	}
+	select {
+	case reason := <-ended:
+		require.Equal(t, "max-duration", reason)
+	case <-time.After(time.Second):
+		t.Fatal("call ended event was not sent")
+	}
+	select {
+	case <-room.closed.Watch():
+	case <-time.After(time.Second):
+		t.Fatal("participant was not removed from the room")
+	}
+}
+
+func TestMaxDurationStop(t *testing.T) {
+	closed := make(chan string, 1)
+	tm := startMaxDuration(nil, nil, "ST_a", 50*time.Millisecond, func(reason string) { closed <- reason })
+	require.NotNil(t, tm)
+	// The call ended first.
+	require.True(t, tm.Stop())
+	select {
+	case <-closed:
+		t.Fatal("call closed after the timer was stopped")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	require.Nil(t, startMaxDuration(nil, nil, "ST_a", 0, func(reason string) { closed <- reason }))
+}
@@ -15,16 +15,65 @@
 package sip
 
 import (
+	"context"
+	"errors"
 	"strconv"
+	"sync/atomic"
+	"time"
 
+	"github.com/livekit/protocol/logger"
+	"github.com/pion/rtcp"
+
+	"github.com/livekit/sip/pkg/cdr"
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/noise"
+	"github.com/livekit/sip/pkg/media/resample"
+	"github.com/livekit/sip/pkg/media/rtcpxr"
 	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/media/srtp/dtls"
 	"github.com/livekit/sip/pkg/stats"
 )
 
 const (
 	channels = 1
+
+	dtlsHandshakeTimeout = 5 * time.Second
 )
 
+var errSRTPNotEnabled = errors.New("dtls-srtp is not enabled")
+
+// startDTLS runs the DTLS-SRTP handshake on the RTP connection, and enables SRTP for it.
+// It must be called after the SDP answer is sent or received, before the media starts.
+func startDTLS(ctx context.Context, conn *rtp.Conn, cert *dtls.Certificate, role dtls.Role, remote *sdpDTLS) (*dtls.Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, dtlsHandshakeTimeout)
+	defer cancel()
+	sess, err := dtls.Handshake(ctx, conn.DTLSConn(), cert, role, remote.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetCipher(sess)
+	return sess, nil
+}
+
+// decodeAudio creates a decoding pipeline for the SIP audio. Audio is converted to rtp.DefSampleRate used by the mixer.
+func decodeAudio(codec rtp.AudioCodec, typ byte, w media.PCM16Writer) rtp.Handler {
+	return codec.DecodeRTP(resample.Resample(w, rtp.CodecSampleRate(codec), rtp.DefSampleRate), typ)
+}
+
+// encodeAudio creates an encoding pipeline for the SIP audio. It accepts audio with rtp.DefSampleRate.
+// Noise reduction and comfort noise are applied before encoding, if enabled.
+func encodeAudio(conf *config.Config, codec rtp.AudioCodec, s *rtp.Stream) media.PCM16Writer {
+	w := resample.Resample(codec.EncodeRTP(s), rtp.DefSampleRate, rtp.CodecSampleRate(codec))
+	if conf.ComfortNoise {
+		w = noise.FillSilence(w, noise.NewComfortNoiseGenerator(conf.ComfortNoiseLevel))
+	}
+	if conf.NoiseReduction {
+		w = noise.SpectralSubtraction(w)
+	}
+	return w
+}
+
 func newRTPStatsHandler(mon *stats.CallMonitor, typ string, h rtp.Handler) rtp.Handler {
 	if h == nil {
 		h = rtp.HandlerFunc(func(p *rtp.Packet) error {
@@ -51,6 +100,182 @@ func (h *rtpStatsHandler) HandleRTP(p *rtp.Packet) error {
 	return h.h.HandleRTP(p)
 }
 
+// newRTPSeqStatsHandler counts lost and reordered packets of the incoming RTP stream.
+// It must see all packets of the stream, since payload types share the sequence numbers.
+// Packets which arrive late are only counted as reordered, not as lost.
+func newRTPSeqStatsHandler(mon *stats.CallMonitor, h rtp.Handler) rtp.Handler {
+	return &rtpSeqStatsHandler{h: h, mon: mon}
+}
+
+type rtpSeqStatsHandler struct {
+	h    rtp.Handler
+	mon  *stats.CallMonitor
+	seq  rtp.SequenceTracker
+	lost uint64 // lost packets already recorded
+}
+
+func (h *rtpSeqStatsHandler) HandleRTP(p *rtp.Packet) error {
+	_, _, reorder := h.seq.Process(p.SequenceNumber)
+	if h.mon != nil {
+		if lost := h.seq.Lost(); lost > h.lost {
+			h.mon.RTPPacketsLost(int(lost - h.lost))
+			h.lost = lost
+		}
+		if reorder {
+			h.mon.RTPPacketReordered()
+		}
+	}
+	return h.h.HandleRTP(p)
+}
+
+// newRTCPStatsHandler records the quality of our stream with a given SSRC reported by the remote side in RTCP.
+// Reports about other streams are ignored. Sender reports of remote streams update the clock, if it's set.
+// Round-trip time is passed to the XR collector, if it's set.
+func newRTCPStatsHandler(mon *stats.CallMonitor, trunk string, ssrc uint32, clock *rtp.SenderClock, xr *rtcpxr.Collector) rtp.RTCPHandler {
+	return &rtcpStatsHandler{mon: mon, trunk: trunk, ssrc: ssrc, clock: clock, xr: xr}
+}
+
+type rtcpStatsHandler struct {
+	mon   *stats.CallMonitor
+	trunk string
+	ssrc  uint32
+	clock *rtp.SenderClock
+	xr    *rtcpxr.Collector
+}
+
+func (h *rtcpStatsHandler) HandleRTCP(pkts []rtcp.Packet) error {
+	now := time.Now()
+	for _, p := range pkts {
+		var reports []rtcp.ReceptionReport
+		switch p := p.(type) {
+		case *rtcp.ReceiverReport:
+			reports = p.Reports
+		case *rtcp.SenderReport:
+			if h.clock != nil {
+				h.clock.Update(p)
+			}
+			reports = p.Reports
+		}
+		for _, r := range reports {
+			if r.SSRC != h.ssrc {
+				continue
+			}
+			st := rtp.NewReceptionStats(r, rtp.DefSampleRate, now)
+			if h.mon != nil {
+				h.mon.RTCPReceptionReport(h.trunk, st.FractionLost, st.Jitter, st.RTT)
+			}
+			if h.xr != nil && st.RTT > 0 {
+				h.xr.SetRoundTrip(st.RTT)
+			}
+		}
+	}
+	return nil
+}
+
+// newRTPXRHandler records VoIP metrics of the incoming RTP stream for RTCP XR. It returns h as is if xr is not set.
+func newRTPXRHandler(xr *rtcpxr.Collector, h rtp.Handler) rtp.Handler {
+	if xr == nil {
+		return h
+	}
+	return rtp.HandlerFunc(func(p *rtp.Packet) error {
+		xr.Packet(p.SSRC, p.SequenceNumber)
+		return h.HandleRTP(p)
+	})
+}
+
+// newRTCPXRCollector creates a collector of VoIP metrics, if RTCP XR is enabled.
+func newRTCPXRCollector(conf *config.Config) *rtcpxr.Collector {
+	if !conf.RTCPXREnabled {
+		return nil
+	}
+	return rtcpxr.NewCollector(rtp.DefFrameDur)
+}
+
+// rtcpXRReports returns extended reports sent by a given SSRC, which are added to RTCP sender reports.
+// It returns nil if xr is not set.
+func rtcpXRReports(xr *rtcpxr.Collector, ssrc uint32) func(now time.Time) []rtcp.Packet {
+	if xr == nil {
+		return nil
+	}
+	return func(now time.Time) []rtcp.Packet {
+		if r := xr.Report(ssrc); r != nil {
+			return []rtcp.Packet{r}
+		}
+		return nil
+	}
+}
+
+// startRTCP prepares the connection for RTCP reports. It returns false if reports cannot be sent.
+// RTCP is received on the next port after RTP, if it's not multiplexed with RTP. DTLS-SRTP requires rtcp-mux.
+func startRTCP(log logger.Logger, conn *rtp.Conn, mux, useDTLS bool) bool {
+	if mux {
+		return true
+	}
+	if useDTLS {
+		return false
+	}
+	if err := conn.ListenRTCP(); err != nil {
+		log.Warnw("Cannot listen for RTCP", err)
+		return false
+	}
+	return true
+}
+
+// logRTPStats logs RTP statistics of the call, once it ends.
+func logRTPStats(log logger.Logger, st rtp.CallStats) {
+	log.Infow("RTP stats",
+		"packets-sent", st.PacketsSent,
+		"packets-received", st.PacketsReceived,
+		"bytes-sent", st.BytesSent,
+		"bytes-received", st.BytesReceived,
+		"duration-ms", st.DurationMs,
+		"lost-packets", st.LostPackets,
+		"jitter-ms", st.JitterMs,
+	)
+}
+
+// setCDRStats copies RTP statistics of the call to its call detail record.
+func setCDRStats(r *cdr.CDRRecord, st rtp.CallStats) {
+	r.PacketsSent, r.PacketsReceived = st.PacketsSent, st.PacketsReceived
+	r.BytesSent, r.BytesReceived = st.BytesSent, st.BytesReceived
+	r.LostPackets, r.JitterMs = st.LostPackets, st.JitterMs
+}
+
+// rtpSyncInterval is how often the sync delay of the incoming stream is recorded.
+const rtpSyncInterval = time.Second
+
+// newRTPSyncHandler maps RTP timestamps of the incoming stream to the sender wall clock, using RTCP sender reports.
+// It records the delay between the sender time and the arrival, which is what other streams must be delayed by
+// to play in sync with this stream (lip sync).
+func newRTPSyncHandler(mon *stats.CallMonitor, trunk string, clock *rtp.SenderClock, h rtp.Handler) *rtpSyncHandler {
+	return &rtpSyncHandler{h: h, mon: mon, trunk: trunk, clock: clock}
+}
+
+type rtpSyncHandler struct {
+	h     rtp.Handler
+	mon   *stats.CallMonitor
+	trunk string
+	clock *rtp.SenderClock
+	last  time.Time
+	ssrc  atomic.Uint32 // of the last packet
+}
+
+// Drift returns the clock drift of the incoming stream. It can be used with rtp.NewDriftCompensator.
+func (h *rtpSyncHandler) Drift() (float64, bool) {
+	return h.clock.Drift(h.ssrc.Load())
+}
+
+func (h *rtpSyncHandler) HandleRTP(p *rtp.Packet) error {
+	h.ssrc.Store(p.SSRC)
+	if now := time.Now(); h.mon != nil && now.Sub(h.last) >= rtpSyncInterval {
+		if sent, ok := h.clock.Time(p.SSRC, p.Timestamp); ok {
+			h.last = now
+			h.mon.RTPSyncDelay(h.trunk, now.Sub(sent))
+		}
+	}
+	return h.h.HandleRTP(p)
+}
+
 func newRTPStatsWriter(mon *stats.CallMonitor, typ string, w rtp.Writer) rtp.Writer {
 	return &rtpStatsWriter{w: w, typ: typ, mon: mon}
 }
@@ -16,12 +16,22 @@ package sip
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/at-wat/ebml-go"
 	"github.com/at-wat/ebml-go/webm"
 
 	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/rtp"
 	"github.com/livekit/sip/pkg/media/ulaw"
+	webmm "github.com/livekit/sip/pkg/media/webm"
 	"github.com/livekit/sip/res"
 )
 
@@ -29,6 +39,13 @@ type mediaRes struct {
 	enterPin []media.PCM16Sample
 	roomJoin []media.PCM16Sample
 	wrongPin []media.PCM16Sample
+
+	recordingAnnouncement []media.PCM16Sample // optional
+
+	parkPrompt []media.PCM16Sample     // optional
+	parkDigits [10][]media.PCM16Sample // optional; DTMF tones are used for missing digits
+
+	pinPrompts map[string][]media.PCM16Sample // custom PIN prompts by file path; read on start
 }
 
 func (s *Server) initMediaRes() {
@@ -37,6 +54,105 @@ func (s *Server) initMediaRes() {
 	s.res.wrongPin = readMkvAudioFile(res.WrongPinMkv)
 }
 
+func (s *Server) loadMediaFiles() error {
+	if s.conf.RecordingAnnouncementFile != "" {
+		frames, err := readPCM16File(s.conf.RecordingAnnouncementFile)
+		if err != nil {
+			return fmt.Errorf("cannot read recording announcement file: %w", err)
+		}
+		s.res.recordingAnnouncement = frames
+	}
+	if path := s.conf.PINPromptFile; path != "" {
+		frames, err := readWebmPCM16File(path)
+		if err != nil {
+			return fmt.Errorf("cannot read pin prompt file: %w", err)
+		}
+		s.res.pinPrompts = map[string][]media.PCM16Sample{path: frames}
+	}
+	if dir := s.conf.ParkingAnnouncementDir; dir != "" {
+		if err := s.loadParkingAnnouncements(dir); err != nil {
+			return fmt.Errorf("cannot read parking announcement: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadParkingAnnouncements reads recordings used to announce parking slots. All files are optional.
+func (s *Server) loadParkingAnnouncements(dir string) error {
+	read := func(name string) ([]media.PCM16Sample, error) {
+		frames, err := readPCM16File(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return frames, err
+	}
+	var err error
+	if s.res.parkPrompt, err = read("parked.pcm"); err != nil {
+		return err
+	}
+	for i := range s.res.parkDigits {
+		if s.res.parkDigits[i], err = read(strconv.Itoa(i) + ".pcm"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pinPromptAudio returns the PIN prompt read from a given file, or from config.PINPromptFile if the file is not set.
+// Files are only read on start, the default prompt is used for files which were not loaded.
+func (s *Server) pinPromptAudio(path string) []media.PCM16Sample {
+	if path == "" {
+		path = s.conf.PINPromptFile
+	}
+	if path == "" {
+		return s.res.enterPin
+	}
+	frames, ok := s.res.pinPrompts[path]
+	if !ok {
+		s.log.Warnw("PIN prompt file is not loaded, using the default prompt", nil, "file", path)
+		return s.res.enterPin
+	}
+	return frames
+}
+
+// readPCM16File reads raw 16 bit PCM file and splits it into RTP frames.
+func readPCM16File(path string) ([]media.PCM16Sample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := media.ReadPCM16(f)
+	if err != nil {
+		return nil, err
+	}
+	frameSize := int(rtp.DefSampleRate * rtp.DefFrameDur / time.Second)
+	return media.SplitPCM16(data, frameSize), nil
+}
+
+// readWebmPCM16File reads PCM audio track of a WebM file, as written by webm.NewPCM16Writer, and splits it into RTP frames.
+func readWebmPCM16File(path string) ([]media.PCM16Sample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := webmm.NewPCM16Reader(f)
+	defer r.Close()
+	frameSize := int(rtp.DefSampleRate * rtp.DefFrameDur / time.Second)
+	var data media.PCM16Sample
+	buf := make(media.PCM16Sample, frameSize)
+	for {
+		n, err := r.ReadSample(buf)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		data = append(data, buf[:n]...)
+	}
+	return media.SplitPCM16(data, frameSize), nil
+}
+
 func readMkvAudioFile(data []byte) []media.PCM16Sample {
 	var ret struct {
 		Header  webm.EBMLHeader `ebml:"EBML"`
@@ -0,0 +1,192 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	lksdk "github.com/livekit/server-sdk-go/v2"
+	prtp "github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/audiotest"
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/rtp"
+	lksdp "github.com/livekit/sip/pkg/media/sdp"
+	"github.com/livekit/sip/pkg/media/ulaw"
+	webmm "github.com/livekit/sip/pkg/media/webm"
+)
+
+func TestRecordingAnnouncement(t *testing.T) {
+	const (
+		frameSize = 160
+		frames    = 10
+		amp       = 10000
+		annSig    = 0
+		roomSig   = 3
+	)
+	genFrames := func(ind int) []media.PCM16Sample {
+		var out []media.PCM16Sample
+		for i := 0; i < frames; i++ {
+			frame := make(media.PCM16Sample, frameSize)
+			audiotest.GenSignal(frame, []audiotest.Wave{{Ind: ind, Amp: amp}})
+			out = append(out, frame)
+		}
+		return out
+	}
+
+	var buf []byte
+	for _, frame := range genFrames(annSig) {
+		for _, v := range frame {
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(v))
+		}
+	}
+	path := filepath.Join(t.TempDir(), "announcement.pcm")
+	require.NoError(t, os.WriteFile(path, buf, 0644))
+
+	joined := make(chan *testRoomConn, 1)
+	s, addr := startTestService(t, &config.Config{RecordingAnnouncementFile: path}, func(s *Service) {
+		s.SetHandler(acceptHandler("room", ""))
+		// A participant is already talking in the room, so room audio starts as soon as the call joins.
+		connect := newTestRoomConnector(joined)
+		s.srv.connectRoom = func(conf *config.Config, rc lkRoomConfig, cb *lksdk.RoomCallback) (roomConn, error) {
+			s.srv.cmu.RLock()
+			for _, c := range s.srv.activeCalls {
+				go c.lkRoom.NewTrack().PlayAudio(context.Background(), genFrames(roomSig))
+			}
+			s.srv.cmu.RUnlock()
+			return connect(conf, rc, cb)
+		}
+	})
+	require.Len(t, s.srv.res.recordingAnnouncement, frames)
+
+	// Caller records audio sent by the service.
+	var (
+		mu  sync.Mutex
+		out []media.PCM16Sample
+	)
+	conn := rtp.NewConn(nil)
+	require.NoError(t, conn.ListenAndServe(0, 0, "0.0.0.0"))
+	t.Cleanup(func() { _ = conn.Close() })
+	conn.OnRTP(rtp.HandlerFunc(func(p *prtp.Packet) error {
+		mu.Lock()
+		defer mu.Unlock()
+		out = append(out, ulaw.DecodeUlaw(p.Payload))
+		return nil
+	}))
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+	offer, err := sdpGenerateOfferWith(localIP, conn.LocalAddr().Port, []sdpCodecInfo{
+		{Type: prtp.PayloadTypePCMU, Codec: lksdp.CodecByName(ulaw.SDPName)},
+	})
+	require.NoError(t, err)
+
+	newTestPhone(t, "alice").Call(t, addr, "room", offer)
+	select {
+	case <-joined:
+	case <-time.After(5 * time.Second):
+		t.Fatal("call did not join the room")
+	}
+	time.Sleep(frames * rtp.DefFrameDur * 2)
+
+	mu.Lock()
+	defer mu.Unlock()
+	lastAnn, firstRoom, annFrames := -1, -1, 0
+	for i, frame := range out {
+		for _, w := range audiotest.FindSignal(frame) {
+			if w.Amp < amp/2 {
+				continue
+			}
+			switch w.Ind {
+			case annSig:
+				lastAnn = i
+				annFrames++
+			case roomSig:
+				if firstRoom < 0 {
+					firstRoom = i
+				}
+			}
+		}
+	}
+	require.GreaterOrEqual(t, annFrames, frames/2, "announcement not played")
+	require.True(t, firstRoom >= 0, "room audio not found")
+	require.Less(t, lastAnn, firstRoom, "room audio bridged before the announcement ended")
+}
+
+func TestPINPromptFile(t *testing.T) {
+	const (
+		frames    = 10
+		amp       = 10000
+		promptSig = 2
+	)
+	path := filepath.Join(t.TempDir(), "pin.webm")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	w := webmm.NewPCM16Writer(f, rtp.DefSampleRate, rtp.DefFrameDur)
+	for i := 0; i < frames; i++ {
+		frame := make(media.PCM16Sample, rtp.DefPacketDur)
+		audiotest.GenSignal(frame, []audiotest.Wave{{Ind: promptSig, Amp: amp}})
+		require.NoError(t, w.WriteSample(frame))
+	}
+	require.NoError(t, w.Close())
+
+	s, addr := startTestService(t, &config.Config{PINPromptFile: path}, func(s *Service) {
+		s.SetHandler(&TestHandler{
+			GetAuthCredentialsFunc: noAuth,
+			DispatchCallFunc: func(ctx context.Context, info *CallInfo) CallDispatch {
+				return CallDispatch{Result: DispatchRequestPin, PinPromptFile: path}
+			},
+		})
+	})
+	require.Len(t, s.srv.res.pinPrompts[path], frames)
+	// The file is only read on start.
+	require.NoError(t, os.Remove(path))
+	// The file from the config is used if the dispatch doesn't set one, and the default prompt is used for files not loaded on start.
+	require.Equal(t, s.srv.res.pinPrompts[path], s.srv.pinPromptAudio(""))
+	require.Equal(t, s.srv.res.enterPin, s.srv.pinPromptAudio(path+".missing"))
+
+	// Caller counts frames of the prompt sent by the service.
+	var found atomic.Int32
+	conn := rtp.NewConn(nil)
+	require.NoError(t, conn.ListenAndServe(0, 0, "0.0.0.0"))
+	t.Cleanup(func() { _ = conn.Close() })
+	conn.OnRTP(rtp.HandlerFunc(func(p *prtp.Packet) error {
+		for _, w := range audiotest.FindSignal(ulaw.DecodeUlaw(p.Payload)) {
+			if w.Ind == promptSig && w.Amp >= amp/2 {
+				found.Add(1)
+			}
+		}
+		return nil
+	}))
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+	offer, err := sdpGenerateOfferWith(localIP, conn.LocalAddr().Port, []sdpCodecInfo{
+		{Type: prtp.PayloadTypePCMU, Codec: lksdp.CodecByName(ulaw.SDPName)},
+	})
+	require.NoError(t, err)
+
+	newTestPhone(t, "alice").Call(t, addr, "room", offer)
+	require.Eventually(t, func() bool {
+		return found.Load() >= frames/2
+	}, 5*time.Second, 50*time.Millisecond, "PIN prompt not played")
+}
@@ -0,0 +1,169 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	prtp "github.com/pion/rtp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/stats"
+)
+
+// testHistogramCount returns the number of samples of the histogram for a given trunk.
+func testHistogramCount(t *testing.T, name, trunk string) uint64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	var n uint64
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "trunk" && l.GetValue() == trunk {
+					n += m.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return n
+}
+
+// testHistogramQuantile returns the upper bound of the histogram bucket containing a given quantile of samples
+// with a given direction, and the number of samples.
+func testHistogramQuantile(t *testing.T, name, dir string, q float64) (float64, uint64) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			match := false
+			for _, l := range m.GetLabel() {
+				match = match || (l.GetName() == "dir" && l.GetValue() == dir)
+			}
+			if !match {
+				continue
+			}
+			h := m.GetHistogram()
+			for _, b := range h.GetBucket() {
+				if float64(b.GetCumulativeCount()) >= q*float64(h.GetSampleCount()) {
+					return b.GetUpperBound(), h.GetSampleCount()
+				}
+			}
+			return math.Inf(1), h.GetSampleCount()
+		}
+	}
+	return 0, 0
+}
+
+func TestRTCPStatsHandler(t *testing.T) {
+	const (
+		trunk  = "ST_rtcp"
+		local  = 5000
+		remote = 1234
+	)
+	m := stats.NewMonitor()
+	require.NoError(t, m.Start(&config.Config{}))
+	t.Cleanup(m.Stop)
+	mon := m.NewCall(stats.Inbound, "from", "to")
+
+	clock := rtp.NewSenderClock(rtp.DefSampleRate)
+	h := newRTCPStatsHandler(mon, trunk, local, clock, nil)
+	now := time.Now()
+	require.NoError(t, h.HandleRTCP([]rtcp.Packet{
+		&rtcp.SenderReport{
+			SSRC:    remote,
+			NTPTime: rtp.ToNTP(now),
+			RTPTime: 1000,
+			Reports: []rtcp.ReceptionReport{{SSRC: local, FractionLost: 64}},
+		},
+		// Reports about other streams are ignored.
+		&rtcp.ReceiverReport{SSRC: remote, Reports: []rtcp.ReceptionReport{{SSRC: local + 1, FractionLost: 128}}},
+	}))
+	require.EqualValues(t, 1, testHistogramCount(t, "livekit_sip_rtp_packet_loss_fraction", trunk))
+
+	// Sender report of the remote stream maps its timestamps to the wall clock.
+	sent, ok := clock.Time(remote, 1000+rtp.DefSampleRate)
+	require.True(t, ok)
+	require.WithinDuration(t, now.Add(time.Second), sent, time.Millisecond)
+	_, ok = clock.Time(local, 1000)
+	require.False(t, ok)
+
+	// Arrival of the packets is compared with the sender clock, at most once per interval.
+	var got int
+	sh := newRTPSyncHandler(mon, trunk, clock, rtp.HandlerFunc(func(p *rtp.Packet) error {
+		got++
+		return nil
+	}))
+	// Packets of streams without sender reports are not recorded.
+	require.NoError(t, sh.HandleRTP(&rtp.Packet{Header: prtp.Header{SSRC: remote + 1}}))
+	require.Zero(t, testHistogramCount(t, "livekit_sip_rtp_sync_delay_ms", trunk))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, sh.HandleRTP(&rtp.Packet{Header: prtp.Header{SSRC: remote, Timestamp: 1000 + uint32(i)*rtp.DefPacketDur}}))
+	}
+	require.Equal(t, 4, got)
+	require.EqualValues(t, 1, testHistogramCount(t, "livekit_sip_rtp_sync_delay_ms", trunk))
+}
+
+func TestRTCPXR(t *testing.T) {
+	const (
+		local  = 5000
+		remote = 1234
+	)
+	require.Nil(t, newRTCPXRCollector(&config.Config{}))
+	require.Nil(t, rtcpXRReports(nil, local))
+	xr := newRTCPXRCollector(&config.Config{RTCPXREnabled: true})
+	require.NotNil(t, xr)
+	// No reports until the remote stream is received.
+	extra := rtcpXRReports(xr, local)
+	require.Empty(t, extra(time.Now()))
+
+	var got int
+	h := newRTPXRHandler(xr, rtp.HandlerFunc(func(p *rtp.Packet) error {
+		got++
+		return nil
+	}))
+	for _, seq := range []uint16{1, 2, 4} {
+		require.NoError(t, h.HandleRTP(&rtp.Packet{Header: prtp.Header{SSRC: remote, SequenceNumber: seq}}))
+	}
+	require.Equal(t, 3, got)
+
+	// Round-trip time comes from RTCP reception reports.
+	sh := newRTCPStatsHandler(nil, "", local, rtp.NewSenderClock(rtp.DefSampleRate), xr)
+	now := time.Now()
+	lsr := uint32(rtp.ToNTP(now.Add(-100*time.Millisecond)) >> 16)
+	require.NoError(t, sh.HandleRTCP([]rtcp.Packet{
+		&rtcp.ReceiverReport{SSRC: remote, Reports: []rtcp.ReceptionReport{{SSRC: local, LastSenderReport: lsr}}},
+	}))
+
+	pkts := extra(now)
+	require.Len(t, pkts, 1)
+	rep := pkts[0].(*rtcp.ExtendedReport)
+	require.EqualValues(t, local, rep.SenderSSRC)
+	block := rep.Reports[0].(*rtcp.VoIPMetricsReportBlock)
+	require.EqualValues(t, remote, block.SSRC)
+	require.NotZero(t, block.LossRate)
+	require.NotZero(t, block.RoundTripDelay)
+}
@@ -0,0 +1,173 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/livekit/protocol/logger"
+	"github.com/pion/sdp/v2"
+
+	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/stats"
+)
+
+// mediaWatch detects one-way audio. If no RTP is received within the timeout, the session is refreshed with re-INVITE,
+// since the remote side might have changed its media address, or lost the session state. If media doesn't resume
+// within another timeout, the call is closed.
+type mediaWatch struct {
+	log     logger.Logger
+	mon     *stats.CallMonitor
+	conn    *rtp.Conn
+	timeout time.Duration
+	ignore  func() bool         // reports that no RTP is expected, e.g. during hold; optional
+	refresh func() error        // sends re-INVITE
+	close   func(reason string) // must be safe to call from any goroutine
+}
+
+func (w *mediaWatch) run(done <-chan struct{}) {
+	ticker := time.NewTicker(w.timeout / 4)
+	defer ticker.Stop()
+	start := time.Now()
+	var refreshed time.Time // set while waiting for media after re-INVITE
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		if w.ignore != nil && w.ignore() {
+			// Restart the timeout once media is expected again.
+			start, refreshed = now, time.Time{}
+			continue
+		}
+		last := w.conn.LastPacket()
+		if last.Before(start) {
+			last = start
+		}
+		if !refreshed.IsZero() {
+			if last.After(refreshed) {
+				w.log.Infow("Media resumed after re-INVITE")
+				w.mon.OneWayAudio("recovered")
+				refreshed = time.Time{}
+			} else if now.Sub(refreshed) >= w.timeout {
+				w.log.Warnw("No media after re-INVITE, closing the call", nil, "timeout", w.timeout)
+				w.mon.OneWayAudio("terminated")
+				w.close("media-timeout")
+				return
+			}
+			continue
+		}
+		if now.Sub(last) < w.timeout {
+			continue
+		}
+		w.log.Warnw("No media received, refreshing the session", nil, "timeout", w.timeout, "last", last)
+		w.mon.OneWayAudio("reinvite")
+		refreshed = time.Now()
+		if err := w.refresh(); err != nil {
+			w.log.Warnw("Cannot refresh the session, closing the call", err)
+			w.mon.OneWayAudio("terminated")
+			w.close("media-timeout")
+			return
+		}
+	}
+}
+
+// applyRefreshAnswer sends ACK for the 200 response to re-INVITE, and updates the media destination from its SDP.
+func applyRefreshAnswer(log logger.Logger, cli *sipgo.Client, req *sip.Request, resp *sip.Response, conn *rtp.Conn) error {
+	if conn == nil {
+		return errors.New("call is not active")
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected status from re-INVITE: %d %s", resp.StatusCode, resp.Reason)
+	}
+	ack := sip.NewAckRequest(req, resp, nil)
+	if contact, ok := resp.Contact(); ok {
+		ack.Recipient = &contact.Address
+	}
+	if err := cli.WriteRequest(ack); err != nil {
+		return err
+	}
+	answer := sdp.SessionDescription{}
+	if err := answer.Unmarshal(messageSDP(log, resp)); err != nil {
+		return err
+	}
+	if dst := sdpGetAudioDest(answer); dst != nil && !dst.IP.IsUnspecified() {
+		conn.SetDestAddr(dst)
+	}
+	return nil
+}
+
+// sipRefreshSession sends re-INVITE with the last SDP sent to the caller (RFC 3261, Section 14).
+func (c *inboundCall) sipRefreshSession() error {
+	if !c.offerMu.TryLock() {
+		// The caller is renegotiating the session already.
+		return nil
+	}
+	defer c.offerMu.Unlock()
+	req, err := c.sipDialogRequest(sip.INVITE)
+	if err != nil {
+		return err
+	}
+	c.dialogMu.Lock()
+	if c.inviteReq == nil || c.localSDP == nil {
+		c.dialogMu.Unlock()
+		return errors.New("call is not active")
+	}
+	req.AppendHeader(c.s.contactHeader(c.inviteReq))
+	req.SetBody(c.localSDP)
+	c.dialogMu.Unlock()
+	req.AppendHeader(&contentTypeHeaderSDP)
+
+	tx, err := c.s.sipCli.TransactionRequest(req)
+	if err != nil {
+		return err
+	}
+	defer tx.Terminate()
+	resp, err := sipResponse(tx)
+	if err != nil {
+		return err
+	}
+	c.mediaMu.Lock()
+	conn := c.rtpConn
+	c.mediaMu.Unlock()
+	return applyRefreshAnswer(c.log, c.s.sipCli, req, resp, conn)
+}
+
+// sipRefreshSession sends re-INVITE with the last SDP offer to the callee (RFC 3261, Section 14).
+func (c *outboundCall) sipRefreshSession() error {
+	c.mu.Lock()
+	if c.sipInviteReq == nil {
+		c.mu.Unlock()
+		return errors.New("call is not active")
+	}
+	req := c.sipDialogRequest(sip.INVITE, c.sipInviteReq.Body())
+	if h := c.sipInviteReq.GetHeader("Content-Type"); h != nil {
+		req.AppendHeader(sip.HeaderClone(h))
+	}
+	req.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: c.sipCur.from, Host: c.c.signalingIp}})
+	c.mu.Unlock()
+
+	resp, err := c.sipDialogTransaction(req)
+	if err != nil {
+		return err
+	}
+	return applyRefreshAnswer(c.log, c.c.sipCli, req, resp, c.rtpConn)
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+func startMediaTimeoutService(t *testing.T, ended chan<- string) string {
+	joined := make(chan *testRoomConn, 1)
+	_, addr := startTestService(t, &config.Config{
+		MediaTimeoutDetection: true,
+		MediaTimeout:          300 * time.Millisecond,
+	}, func(s *Service) {
+		s.srv.connectRoom = newTestRoomConnector(joined)
+		s.SetHandler(acceptHandler("room", "", func(h *TestHandler) {
+			h.CallEndedFunc = func(ctx context.Context, info *CallInfo, reason string) {
+				ended <- reason
+			}
+		}))
+	})
+	return addr
+}
+
+func TestService_MediaTimeout(t *testing.T) {
+	ended := make(chan string, 1)
+	addr := startMediaTimeoutService(t, ended)
+
+	// The phone never sends RTP, so the session is refreshed first, and the call is closed after that.
+	alice := newTestPhone(t, "alice")
+	start := time.Now()
+	alice.Call(t, addr, "+100", nil)
+	select {
+	case req := <-alice.invite:
+		require.NotEmpty(t, req.Body())
+		require.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no re-INVITE")
+	}
+	select {
+	case reason := <-ended:
+		require.Equal(t, "media-timeout", reason)
+	case <-time.After(5 * time.Second):
+		t.Fatal("call was not closed")
+	}
+	select {
+	case <-alice.bye:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no BYE")
+	}
+}
+
+func TestService_MediaTimeoutRecovered(t *testing.T) {
+	ended := make(chan string, 1)
+	addr := startMediaTimeoutService(t, ended)
+
+	alice := newTestPhone(t, "alice")
+	_, res := alice.Call(t, addr, "+100", nil)
+	answer := sdp.SessionDescription{}
+	require.NoError(t, answer.Unmarshal(res.Body()))
+	dst := sdpGetAudioDest(answer)
+	require.NotNil(t, dst)
+	select {
+	case <-alice.invite:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no re-INVITE")
+	}
+
+	// Media resumes after re-INVITE, so the call stays up.
+	conn, err := net.DialUDP("udp", nil, dst)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			p := rtp.Packet{Header: rtp.Header{Version: 2, SequenceNumber: uint16(i), Timestamp: uint32(160 * i), SSRC: 0xB0B}, Payload: make([]byte, 160)}
+			data, err := p.Marshal()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write(data)
+		}
+	}()
+	select {
+	case reason := <-ended:
+		t.Fatalf("call closed: %s", reason)
+	case <-alice.bye:
+		t.Fatal("call closed")
+	case <-time.After(time.Second):
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/emiago/sipgo/sip"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+)
+
+// MessageTopic is a LiveKit data topic used to relay SIP MESSAGE (RFC 3428) text in both directions.
+const MessageTopic = "sip_message"
+
+var errUnsupportedMessage = errors.New("unsupported message content")
+
+// decodeMessageText decodes a text/plain SIP MESSAGE body. Only UTF-8 and UTF-16 charsets are supported.
+func decodeMessageText(contentType string, body []byte) (string, error) {
+	charset := ""
+	if contentType != "" {
+		typ, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", errUnsupportedMessage, err)
+		}
+		if typ != "text/plain" {
+			return "", fmt.Errorf("%w: content type %q", errUnsupportedMessage, typ)
+		}
+		charset = strings.ToLower(params["charset"])
+	}
+	switch charset {
+	case "", "utf-8", "us-ascii":
+		if !utf8.Valid(body) {
+			return "", fmt.Errorf("%w: invalid utf-8", errUnsupportedMessage)
+		}
+		return string(body), nil
+	case "utf-16", "utf-16be", "utf-16le":
+		return decodeUTF16(charset, body)
+	default:
+		return "", fmt.Errorf("%w: charset %q", errUnsupportedMessage, charset)
+	}
+}
+
+func decodeUTF16(charset string, body []byte) (string, error) {
+	if len(body)%2 != 0 {
+		return "", fmt.Errorf("%w: odd utf-16 length", errUnsupportedMessage)
+	}
+	var order binary.ByteOrder = binary.BigEndian // RFC 2781 default
+	if charset == "utf-16le" {
+		order = binary.LittleEndian
+	}
+	if charset == "utf-16" && len(body) >= 2 {
+		switch {
+		case body[0] == 0xFE && body[1] == 0xFF:
+			body = body[2:]
+		case body[0] == 0xFF && body[1] == 0xFE:
+			order = binary.LittleEndian
+			body = body[2:]
+		}
+	}
+	units := make([]uint16, len(body)/2)
+	for i := range units {
+		units[i] = order.Uint16(body[2*i:])
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+func messageData(text string) *lksdk.UserDataPacket {
+	return &lksdk.UserDataPacket{Payload: []byte(text), Topic: MessageTopic}
+}
+
+func messageResponse(tx sip.ServerTransaction, req *sip.Request, err error) {
+	switch {
+	case err == nil:
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	case errors.Is(err, errUnsupportedMessage):
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 415, "Unsupported Media Type", nil))
+	default:
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 500, "Server Error", nil))
+	}
+}
+
+// onMessage relays in-dialog messages to the room. Out-of-dialog messages are unauthenticated, thus never relayed.
+func (s *Server) onMessage(req *sip.Request, tx sip.ServerTransaction) {
+	c := s.dialogCall(req)
+	if c == nil {
+		if s.sipUnhandled != nil {
+			s.sipUnhandled(req, tx)
+			return
+		}
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 404, "Not Found", nil))
+		return
+	}
+	messageResponse(tx, req, c.relayMessage(req))
+}
+
+func (c *inboundCall) relayMessage(req *sip.Request) error {
+	var contentType string
+	if h, ok := req.ContentType(); ok {
+		contentType = h.Value()
+	}
+	text, err := decodeMessageText(contentType, req.Body())
+	if err != nil {
+		c.log.Warnw("Cannot decode SIP MESSAGE", err)
+		return err
+	}
+	c.log.Debugw("Relaying SIP MESSAGE to the room")
+	return c.lkRoom.SendData(messageData(text), lksdk.WithDataPublishReliable(true))
+}
+
+func (c *Client) onMessage(req *sip.Request, tx sip.ServerTransaction) {
+	call := c.dialogCall(req)
+	if call == nil {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 404, "Not Found", nil))
+		return
+	}
+	messageResponse(tx, req, call.relayMessage(req))
+}
+
+func (c *outboundCall) relayMessage(req *sip.Request) error {
+	var contentType string
+	if h, ok := req.ContentType(); ok {
+		contentType = h.Value()
+	}
+	text, err := decodeMessageText(contentType, req.Body())
+	if err != nil {
+		c.log.Warnw("Cannot decode SIP MESSAGE", err)
+		return err
+	}
+	c.mu.RLock()
+	room := c.lkRoom
+	c.mu.RUnlock()
+	return room.SendData(messageData(text), lksdk.WithDataPublishReliable(true))
+}
+
+// sipMessage sends a text message to the remote side of the outbound call.
+func (c *outboundCall) sipMessage(text string) error {
+	return c.sipText(sip.MESSAGE, text)
+}
+
+// sipText sends an in-dialog request with a text/plain body to the remote side of the outbound call.
+func (c *outboundCall) sipText(method sip.RequestMethod, text string) error {
+	c.mu.Lock()
+	if c.sipInviteReq == nil {
+		c.mu.Unlock()
+		return errors.New("call is not active")
+	}
+	if !c.sipCaps.allows(method) {
+		c.mu.Unlock()
+		return fmt.Errorf("trunk does not allow %s", method)
+	}
+	req := c.sipDialogRequest(method, []byte(text))
+	c.mu.Unlock()
+	req.AppendHeader(sip.NewHeader("Content-Type", "text/plain;charset=UTF-8"))
+
+	resp, err := c.sipDialogTransaction(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status from SIP %s: %d %s", method, resp.StatusCode, resp.Reason)
+	}
+	return nil
+}
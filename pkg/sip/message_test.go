@@ -0,0 +1,204 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/livekit/protocol/logger"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/stats"
+)
+
+func TestDecodeMessageText(t *testing.T) {
+	cases := []struct {
+		name  string
+		ctype string
+		body  []byte
+		exp   string
+		err   bool
+	}{
+		{name: "no type", body: []byte("hello"), exp: "hello"},
+		{name: "utf-8", ctype: "text/plain;charset=UTF-8", body: []byte("héllo"), exp: "héllo"},
+		{name: "utf-16 bom be", ctype: "text/plain; charset=utf-16", body: []byte{0xFE, 0xFF, 0, 'h', 0, 'i'}, exp: "hi"},
+		{name: "utf-16 bom le", ctype: "text/plain; charset=utf-16", body: []byte{0xFF, 0xFE, 'h', 0, 'i', 0}, exp: "hi"},
+		{name: "utf-16 no bom", ctype: "text/plain; charset=utf-16", body: []byte{0, 'h', 0, 'i'}, exp: "hi"},
+		{name: "utf-16le", ctype: "text/plain; charset=UTF-16LE", body: []byte{'h', 0, 'i', 0}, exp: "hi"},
+		{name: "utf-16 surrogate", ctype: "text/plain; charset=utf-16be", body: []byte{0xD8, 0x3D, 0xDE, 0x00}, exp: "😀"},
+		{name: "odd utf-16", ctype: "text/plain; charset=utf-16", body: []byte{0, 'h', 0}, err: true},
+		{name: "invalid utf-8", ctype: "text/plain", body: []byte{0xFF}, err: true},
+		{name: "html", ctype: "text/html", body: []byte("<b>hi</b>"), err: true},
+		{name: "charset", ctype: "text/plain; charset=koi8-r", body: []byte("hi"), err: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := decodeMessageText(c.ctype, c.body)
+			if c.err {
+				require.ErrorIs(t, err, errUnsupportedMessage)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.exp, got)
+		})
+	}
+}
+
+// startTestService starts the SIP service on a random port and returns its address.
+// Setup functions are called before the service starts. Other ports are tried if the port is busy.
+func startTestService(t *testing.T, conf *config.Config, setup ...func(s *Service)) (*Service, string) {
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+
+	conf.RTPPortMin, conf.RTPPortMax = testPortRTPMin, testPortRTPMax
+	for i := 0; i < 10; i++ {
+		sipPort := rand.Intn(testPortSIPMax-testPortSIPMin) + testPortSIPMin
+		conf.SIPPort = sipPort
+		s, err := NewService(conf, logger.GetLogger())
+		require.NoError(t, err)
+		for _, f := range setup {
+			f(s)
+		}
+		if err = s.Start(); err != nil {
+			s.Stop()
+			continue
+		}
+		t.Cleanup(s.Stop)
+		return s, fmt.Sprintf("%s:%d", localIP, sipPort)
+	}
+	t.Fatal("cannot start the service")
+	return nil, ""
+}
+
+// noAuth lets all calls in without authentication. It's used as TestHandler.GetAuthCredentialsFunc.
+func noAuth(ctx context.Context, fromUser, toUser, toHost, srcAddress string) (username, password string, drop bool, err error) {
+	return "", "", false, nil
+}
+
+// acceptHandler returns a handler which accepts all calls without authentication, and joins them to the room
+// as "sip_<from user>". Options can set other handler functions.
+func acceptHandler(room, trunkID string, opts ...func(h *TestHandler)) *TestHandler {
+	h := &TestHandler{
+		GetAuthCredentialsFunc: noAuth,
+		DispatchCallFunc: func(ctx context.Context, info *CallInfo) CallDispatch {
+			return CallDispatch{Result: DispatchAccept, RoomName: room, Identity: "sip_" + info.FromUser, TrunkID: trunkID}
+		},
+	}
+	for _, o := range opts {
+		o(h)
+	}
+	return h
+}
+
+// addTestCall adds an active inbound call from a given user without media or a LiveKit connection.
+func addTestCall(s *Service, user, tag string) *inboundCall {
+	from := &sip.FromHeader{Address: sip.Uri{User: user, Host: "example.com"}, Params: sip.NewParams()}
+	from.Params.Add("tag", tag)
+	to := &sip.ToHeader{Address: sip.Uri{User: "bob", Host: "example.com"}}
+	mon := s.srv.mon.NewCall(stats.Inbound, from.Address.String(), to.Address.String())
+	call := s.srv.newInboundCall(logger.GetLogger(), mon, "SCL_"+tag, tag, from, to, "")
+	call.sipCallID = testSIPCallID(tag)
+	call.lkRoom = NewRoom(logger.GetLogger())
+	s.srv.cmu.Lock()
+	s.srv.activeCalls[tag] = call
+	s.srv.cmu.Unlock()
+	return call
+}
+
+func testSIPCallID(tag string) string {
+	return tag + "@example.com"
+}
+
+// newTestDialogRequest creates a request within a dialog with a given Call-ID and From tag.
+// Dialog of the test call added with addTestCall uses testSIPCallID for the Call-ID.
+func newTestDialogRequest(method sip.RequestMethod, addr, user, tag, sipCallID string) *sip.Request {
+	req := sip.NewRequest(method, &sip.Uri{User: "bob", Host: addr})
+	from := &sip.FromHeader{Address: sip.Uri{User: user, Host: "example.com"}, Params: sip.NewParams()}
+	from.Params.Add("tag", tag)
+	req.AppendHeader(from)
+	to := &sip.ToHeader{Address: sip.Uri{User: "bob", Host: addr}, Params: sip.NewParams()}
+	to.Params.Add("tag", "lk-tag")
+	req.AppendHeader(to)
+	callID := sip.CallIDHeader(sipCallID)
+	req.AppendHeader(&callID)
+	return req
+}
+
+// sendTestRequest sends a request from a new UA and waits for the response.
+func sendTestRequest(t *testing.T, addr, user string, req *sip.Request) *sip.Response {
+	ua, err := sipgo.NewUA(sipgo.WithUserAgent(user))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ua.Close() })
+	cli, err := sipgo.NewClient(ua)
+	require.NoError(t, err)
+
+	req.SetDestination(addr)
+	tx, err := cli.TransactionRequest(req)
+	require.NoError(t, err)
+	t.Cleanup(tx.Terminate)
+	return getResponseOrFail(t, tx)
+}
+
+func TestService_Message(t *testing.T) {
+	s, addr := startTestService(t, &config.Config{})
+
+	// Active call from "alice" with a fake LiveKit room.
+	call := addTestCall(s, "alice", "alice-tag")
+	data := setTestRoomConn(call.lkRoom)
+
+	send := func(t *testing.T, req *sip.Request, ctype string, body []byte) *sip.Response {
+		req.AppendHeader(sip.NewHeader("Content-Type", ctype))
+		req.SetBody(body)
+		return sendTestRequest(t, addr, "alice", req)
+	}
+
+	t.Run("utf-16", func(t *testing.T) {
+		req := newTestDialogRequest(sip.MESSAGE, addr, "alice", "alice-tag", testSIPCallID("alice-tag"))
+		res := send(t, req, "text/plain; charset=utf-16", []byte{0xFF, 0xFE, 'h', 0, 'i', 0})
+		require.Equal(t, sip.StatusCode(200), res.StatusCode)
+		select {
+		case p := <-data:
+			require.Equal(t, messageData("hi"), p)
+		case <-time.After(time.Second):
+			t.Fatal("no data packet")
+		}
+	})
+	t.Run("unsupported", func(t *testing.T) {
+		req := newTestDialogRequest(sip.MESSAGE, addr, "alice", "alice-tag", testSIPCallID("alice-tag"))
+		res := send(t, req, "text/html", []byte("<b>hi</b>"))
+		require.Equal(t, sip.StatusCode(415), res.StatusCode)
+		require.Empty(t, data)
+	})
+	t.Run("out of dialog", func(t *testing.T) {
+		// Same user, but not within the dialog of the call.
+		req := sip.NewRequest(sip.MESSAGE, &sip.Uri{User: "bob", Host: addr})
+		res := send(t, req, "text/plain", []byte("hi"))
+		require.Equal(t, sip.StatusCode(404), res.StatusCode)
+		require.Empty(t, data)
+	})
+	t.Run("wrong call id", func(t *testing.T) {
+		req := newTestDialogRequest(sip.MESSAGE, addr, "alice", "alice-tag", "other@example.com")
+		res := send(t, req, "text/plain", []byte("hi"))
+		require.Equal(t, sip.StatusCode(404), res.StatusCode)
+		require.Empty(t, data)
+	})
+}
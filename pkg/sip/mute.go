@@ -0,0 +1,102 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/pion/sdp/v2"
+)
+
+// MuteSIPParticipant mutes or unmutes the SIP participant of an inbound call with a given LiveKit call ID.
+//
+// Audio of the participant is replaced with silence in the room right away. The SIP UA is also asked to stop sending
+// media with UPDATE (RFC 3311) offering sendonly media, and to resume it with sendrecv. If the UPDATE fails,
+// the audio stays muted locally, and the error is returned.
+func (s *Server) MuteSIPParticipant(ctx context.Context, callID string, mute bool) error {
+	c := s.inboundCallByID(callID)
+	if c == nil {
+		return fmt.Errorf("SIP call %q not found", callID)
+	}
+	if c.muted.Swap(mute) != mute {
+		c.log.Infow("SIP participant mute changed", "muted", mute)
+	}
+	dir := "sendrecv"
+	if mute {
+		dir = "sendonly"
+	}
+	return c.sipUpdateDirection(ctx, dir)
+}
+
+// sipUpdateDirection sends UPDATE with the last local SDP, changing the media direction.
+func (c *inboundCall) sipUpdateDirection(ctx context.Context, dir string) error {
+	c.offerMu.Lock()
+	defer c.offerMu.Unlock()
+	req, err := c.sipDialogRequest(sip.UPDATE)
+	if err != nil {
+		return err
+	}
+	c.dialogMu.Lock()
+	if c.inviteReq == nil || c.localSDP == nil {
+		c.dialogMu.Unlock()
+		return errors.New("call is not active")
+	}
+	req.AppendHeader(c.s.contactHeader(c.inviteReq))
+	offer, err := sdpWithDirection(c.localSDP, dir)
+	c.dialogMu.Unlock()
+	if err != nil {
+		return err
+	}
+	req.SetBody(offer)
+	req.AppendHeader(&contentTypeHeaderSDP)
+
+	tx, err := c.s.sipCli.TransactionRequest(req)
+	if err != nil {
+		return err
+	}
+	defer tx.Terminate()
+	var resp *sip.Response
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err = sipResponse(tx)
+	}()
+	select {
+	case <-ctx.Done():
+		tx.Terminate()
+		<-done
+		return ctx.Err()
+	case <-done:
+	}
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected status from UPDATE: %d %s", resp.StatusCode, resp.Reason)
+	}
+	c.dialogMu.Lock()
+	c.localSDP = offer
+	c.dialogMu.Unlock()
+
+	answer := sdp.SessionDescription{}
+	if err = answer.Unmarshal(resp.Body()); err != nil {
+		return err
+	}
+	c.log.Infow("Media direction updated", "direction", dir, "answer", sdpGetDirection(answer))
+	return nil
+}
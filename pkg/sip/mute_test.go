@@ -0,0 +1,154 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	prtp "github.com/pion/rtp"
+	"github.com/pion/sdp/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/rtp"
+	lksdp "github.com/livekit/sip/pkg/media/sdp"
+	"github.com/livekit/sip/pkg/media/ulaw"
+)
+
+func TestService_MuteSIPParticipant(t *testing.T) {
+	joined := make(chan *testRoomConn, 1)
+	s, addr := startTestService(t, &config.Config{}, func(s *Service) {
+		s.srv.connectRoom = newTestRoomConnector(joined)
+		s.SetHandler(acceptHandler("room", ""))
+	})
+	ctx := context.Background()
+	require.Error(t, s.srv.MuteSIPParticipant(ctx, "SCL_unknown", true))
+
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+	peer := rtp.NewConn(nil)
+	require.NoError(t, peer.ListenAndServe(0, 0, localIP))
+	t.Cleanup(func() { _ = peer.Close() })
+	offer, err := sdpGenerateOfferWith(localIP, peer.LocalAddr().Port, []sdpCodecInfo{
+		{Type: prtp.PayloadTypePCMU, Codec: lksdp.CodecByName(ulaw.SDPName)},
+	})
+	require.NoError(t, err)
+
+	alice := newTestPhone(t, "alice")
+	_, res := alice.Call(t, addr, "+100", offer)
+	var answer sdp.SessionDescription
+	require.NoError(t, answer.Unmarshal(res.Body()))
+	peer.SetDestAddr(sdpGetAudioDest(answer))
+	select {
+	case <-joined:
+	case <-time.After(5 * time.Second):
+		t.Fatal("call did not join the room")
+	}
+	s.srv.cmu.RLock()
+	var call *inboundCall
+	for _, c := range s.srv.activeCalls {
+		call = c
+	}
+	s.srv.cmu.RUnlock()
+	require.NotNil(t, call)
+
+	// Count audio which reaches the room, once the participant track is published.
+	var loud atomic.Int64
+	require.Eventually(t, func() bool {
+		call.holdMu.Lock()
+		defer call.holdMu.Unlock()
+		return call.lkTrack != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	call.lkAudio.Set(media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		for _, v := range s {
+			if v != 0 {
+				loud.Add(1)
+				break
+			}
+		}
+		return nil
+	}))
+
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	go func() {
+		frame := make([]int16, rtp.DefPacketDur)
+		for i := range frame {
+			frame[i] = 4000
+		}
+		ticker := time.NewTicker(rtp.DefFrameDur)
+		defer ticker.Stop()
+		for seq := uint16(0); ; seq++ {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			_ = peer.WriteRTP(&prtp.Packet{
+				Header:  prtp.Header{Version: 2, PayloadType: prtp.PayloadTypePCMU, SSRC: 0xA11CE, SequenceNumber: seq, Timestamp: uint32(seq) * uint32(rtp.DefPacketDur)},
+				Payload: ulaw.EncodeUlaw(frame),
+			})
+		}
+	}()
+	require.Eventually(t, func() bool {
+		return loud.Load() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	expectUpdate := func(t *testing.T, dir string) {
+		t.Helper()
+		select {
+		case req := <-alice.update:
+			var offer sdp.SessionDescription
+			require.NoError(t, offer.Unmarshal(req.Body()))
+			require.Equal(t, dir, sdpGetDirection(offer))
+		case <-time.After(time.Second):
+			t.Fatal("no UPDATE")
+		}
+	}
+
+	// The UA keeps sending audio, but none of it reaches the room while muted.
+	require.NoError(t, s.srv.MuteSIPParticipant(ctx, call.id, true))
+	expectUpdate(t, "sendonly")
+	time.Sleep(100 * time.Millisecond)
+	loud.Store(0)
+	time.Sleep(500 * time.Millisecond)
+	require.Zero(t, loud.Load())
+
+	require.NoError(t, s.srv.MuteSIPParticipant(ctx, call.id, false))
+	expectUpdate(t, "sendrecv")
+	require.Eventually(t, func() bool {
+		return loud.Load() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSDPWithDirection(t *testing.T) {
+	data, err := sdpGenerateOffer("127.0.0.1", 40000)
+	require.NoError(t, err)
+	var orig sdp.SessionDescription
+	require.NoError(t, orig.Unmarshal(data))
+
+	data, err = sdpWithDirection(data, "sendonly")
+	require.NoError(t, err)
+	var desc sdp.SessionDescription
+	require.NoError(t, desc.Unmarshal(data))
+	require.Equal(t, "sendonly", sdpGetDirection(desc))
+	require.Equal(t, orig.Origin.SessionVersion+1, desc.Origin.SessionVersion)
+	require.Equal(t, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 40000}, sdpGetAudioDest(desc))
+}
@@ -0,0 +1,219 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mwi implements message-waiting indication subscriptions (RFC 3842).
+package mwi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	EventName   = "message-summary"
+	ContentType = "application/simple-message-summary"
+
+	// DefaultExpires is used when subscriber doesn't set the Expires header.
+	DefaultExpires = time.Hour
+)
+
+// Counts is the number of voice messages in a mailbox.
+type Counts struct {
+	New       int `json:"new"`
+	Old       int `json:"old"`
+	NewUrgent int `json:"new_urgent,omitempty"`
+	OldUrgent int `json:"old_urgent,omitempty"`
+}
+
+// Update is a JSON payload of the "mwi.update" data packet, which sets the message counts of the mailbox.
+type Update struct {
+	User string `json:"user"` // mailbox user, matched with the user part of the subscription URI
+	Counts
+}
+
+// ParseUpdate decodes the update. User must be set.
+func ParseUpdate(data []byte) (*Update, error) {
+	var u Update
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+	if u.User == "" {
+		return nil, errors.New("mwi update: user is not set")
+	}
+	return &u, nil
+}
+
+// Summary generates a simple-message-summary body for the mailbox. Account is optional.
+func Summary(account string, c Counts) []byte {
+	var buf bytes.Buffer
+	waiting := "no"
+	if c.New > 0 {
+		waiting = "yes"
+	}
+	fmt.Fprintf(&buf, "Messages-Waiting: %s\r\n", waiting)
+	if account != "" {
+		fmt.Fprintf(&buf, "Message-Account: %s\r\n", account)
+	}
+	fmt.Fprintf(&buf, "Voice-Message: %d/%d (%d/%d)\r\n", c.New, c.Old, c.NewUrgent, c.OldUrgent)
+	return buf.Bytes()
+}
+
+// ParseSummary parses a simple-message-summary body. It returns the message waiting flag and voice message counts.
+func ParseSummary(data []byte) (bool, Counts, error) {
+	var (
+		waiting bool
+		c       Counts
+		found   bool
+	)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		name, val, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "messages-waiting":
+			waiting = strings.EqualFold(val, "yes")
+			found = true
+		case "voice-message":
+			if _, err := fmt.Sscanf(val, "%d/%d (%d/%d)", &c.New, &c.Old, &c.NewUrgent, &c.OldUrgent); err != nil {
+				if _, err = fmt.Sscanf(val, "%d/%d", &c.New, &c.Old); err != nil {
+					return false, Counts{}, fmt.Errorf("invalid Voice-Message: %q", val)
+				}
+			}
+		}
+	}
+	if !found {
+		return false, Counts{}, errors.New("mwi summary: Messages-Waiting is not set")
+	}
+	return waiting, c, nil
+}
+
+// NotifyFunc delivers the message summary to the subscriber.
+type NotifyFunc func(sub *Subscription, body []byte) error
+
+// Subscription is a message summary subscription for a single mailbox.
+type Subscription struct {
+	ID      string // unique subscription ID, e.g. SIP Call-ID and tag
+	Account string // mailbox URI, e.g. sip:alice@host
+	User    string
+	TrunkID string // trunk of the subscriber; only updates from this trunk are sent to it
+	Expires time.Time
+	Notify  NotifyFunc
+	Dialog  any // state of the dialog which delivers notifications; not used by the manager
+}
+
+// Expired checks if subscription is expired at a given time.
+func (s *Subscription) Expired(now time.Time) bool {
+	return !s.Expires.IsZero() && !now.Before(s.Expires)
+}
+
+// mailbox identifies a mailbox of a given trunk. Mailboxes of different trunks are independent.
+type mailbox struct {
+	trunkID string
+	user    string
+}
+
+// Manager tracks message summary subscriptions and message counts of mailboxes.
+type Manager struct {
+	log logger.Logger
+
+	mu     sync.Mutex
+	subs   map[string]*Subscription
+	counts map[mailbox]Counts
+}
+
+func NewManager(log logger.Logger) *Manager {
+	if log == nil {
+		log = logger.GetLogger()
+	}
+	return &Manager{
+		log:    log,
+		subs:   make(map[string]*Subscription),
+		counts: make(map[mailbox]Counts),
+	}
+}
+
+// Subscribe adds or refreshes the subscription and sends the current message counts to it.
+func (m *Manager) Subscribe(sub *Subscription) error {
+	m.mu.Lock()
+	m.subs[sub.ID] = sub
+	c := m.counts[mailbox{trunkID: sub.TrunkID, user: sub.User}]
+	m.mu.Unlock()
+	return sub.Notify(sub, Summary(sub.Account, c))
+}
+
+// Lookup returns an active subscription with a given ID.
+func (m *Manager) Lookup(id string) (*Subscription, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sub, ok := m.subs[id]
+	if !ok || sub.Expired(time.Now()) {
+		return nil, false
+	}
+	return sub, true
+}
+
+// Unsubscribe removes the subscription.
+func (m *Manager) Unsubscribe(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.subs, id)
+}
+
+// Counts returns the last known message counts of the mailbox of a given trunk.
+func (m *Manager) Counts(trunkID, user string) Counts {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counts[mailbox{trunkID: trunkID, user: user}]
+}
+
+// Update sets message counts of the mailbox and notifies its subscribers. Only mailboxes with active subscriptions
+// from a given trunk are updated, so that rooms of one trunk cannot change message counts of mailboxes of other trunks.
+// It returns false if the update was ignored.
+func (m *Manager) Update(trunkID string, u *Update) bool {
+	key := mailbox{trunkID: trunkID, user: u.User}
+	var subs []*Subscription
+	m.mu.Lock()
+	now := time.Now()
+	for id, sub := range m.subs {
+		if sub.Expired(now) {
+			delete(m.subs, id)
+		} else if sub.TrunkID == trunkID && sub.User == u.User {
+			subs = append(subs, sub)
+		}
+	}
+	if len(subs) == 0 || u.Counts == (Counts{}) {
+		delete(m.counts, key)
+	} else {
+		m.counts[key] = u.Counts
+	}
+	m.mu.Unlock()
+
+	for _, sub := range subs {
+		if err := sub.Notify(sub, Summary(sub.Account, u.Counts)); err != nil {
+			m.log.Warnw("cannot notify message summary subscriber", err, "subscription", sub.ID)
+		}
+	}
+	return len(subs) > 0
+}
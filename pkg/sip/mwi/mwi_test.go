@@ -0,0 +1,96 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mwi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummary(t *testing.T) {
+	body := Summary("sip:alice@example.com", Counts{New: 2, Old: 8, OldUrgent: 1})
+	require.Equal(t, "Messages-Waiting: yes\r\nMessage-Account: sip:alice@example.com\r\nVoice-Message: 2/8 (0/1)\r\n", string(body))
+	waiting, c, err := ParseSummary(body)
+	require.NoError(t, err)
+	require.True(t, waiting)
+	require.Equal(t, Counts{New: 2, Old: 8, OldUrgent: 1}, c)
+
+	waiting, c, err = ParseSummary([]byte("Messages-Waiting: no\r\nVoice-Message: 0/3\r\n"))
+	require.NoError(t, err)
+	require.False(t, waiting)
+	require.Equal(t, Counts{Old: 3}, c)
+
+	_, _, err = ParseSummary([]byte("Voice-Message: 0/3\r\n"))
+	require.Error(t, err)
+	_, _, err = ParseSummary([]byte("Messages-Waiting: yes\r\nVoice-Message: x\r\n"))
+	require.Error(t, err)
+}
+
+func TestParseUpdate(t *testing.T) {
+	u, err := ParseUpdate([]byte(`{"user":"alice","new":1,"old":2,"new_urgent":1}`))
+	require.NoError(t, err)
+	require.Equal(t, &Update{User: "alice", Counts: Counts{New: 1, Old: 2, NewUrgent: 1}}, u)
+	_, err = ParseUpdate([]byte(`{"new":1}`))
+	require.Error(t, err)
+	_, err = ParseUpdate([]byte(`new=1`))
+	require.Error(t, err)
+}
+
+func TestManager(t *testing.T) {
+	m := NewManager(nil)
+
+	var got []Counts
+	sub := &Subscription{
+		ID:      "sub",
+		Account: "sip:alice@example.com",
+		User:    "alice",
+		TrunkID: "ST_a",
+		Expires: time.Now().Add(time.Minute),
+		Notify: func(sub *Subscription, body []byte) error {
+			_, c, err := ParseSummary(body)
+			require.NoError(t, err)
+			got = append(got, c)
+			return nil
+		},
+	}
+	// Mailboxes without subscriptions cannot be updated.
+	require.False(t, m.Update("ST_a", &Update{User: "alice", Counts: Counts{Old: 1}}))
+	require.Equal(t, Counts{}, m.Counts("ST_a", "alice"))
+
+	require.NoError(t, m.Subscribe(sub))
+	require.Equal(t, []Counts{{}}, got)
+	_, ok := m.Lookup("sub")
+	require.True(t, ok)
+
+	require.True(t, m.Update("ST_a", &Update{User: "alice", Counts: Counts{New: 1, Old: 1}}))
+	require.Equal(t, []Counts{{}, {New: 1, Old: 1}}, got)
+	require.Equal(t, Counts{New: 1, Old: 1}, m.Counts("ST_a", "alice"))
+
+	// Other mailboxes, and the same mailbox of other trunks do not trigger notifications.
+	require.False(t, m.Update("ST_a", &Update{User: "bob", Counts: Counts{New: 5}}))
+	require.False(t, m.Update("ST_b", &Update{User: "alice", Counts: Counts{New: 5}}))
+	require.Len(t, got, 2)
+	require.Equal(t, Counts{}, m.Counts("ST_b", "alice"))
+
+	// Expired subscriptions are removed.
+	sub.Expires = time.Now()
+	require.False(t, m.Update("ST_a", &Update{User: "alice"}))
+	require.Len(t, got, 2)
+	_, ok = m.Lookup("sub")
+	require.False(t, ok)
+	require.Equal(t, Counts{}, m.Counts("ST_a", "alice"))
+}
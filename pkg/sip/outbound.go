@@ -15,12 +15,18 @@
 package sip
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"math"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/emiago/sipgo/sip"
 	"github.com/frostbyte73/core"
@@ -30,56 +36,106 @@ import (
 	lksdk "github.com/livekit/server-sdk-go/v2"
 	"github.com/pion/sdp/v2"
 
+	"github.com/livekit/sip/pkg/cdr"
 	"github.com/livekit/sip/pkg/config"
 	"github.com/livekit/sip/pkg/media"
 	"github.com/livekit/sip/pkg/media/dtmf"
+	"github.com/livekit/sip/pkg/media/rtcpxr"
 	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/media/srtp/dtls"
 	"github.com/livekit/sip/pkg/media/tones"
+	"github.com/livekit/sip/pkg/sip/callpprof"
+	"github.com/livekit/sip/pkg/sip/publish"
 	"github.com/livekit/sip/pkg/stats"
+	"github.com/livekit/sip/pkg/transcription"
+	"github.com/livekit/sip/pkg/webhook"
 )
 
 type sipOutboundConfig struct {
+	trunkID  string // optional; resolved from the address with outbound_trunks
 	address  string
 	from     string
+	fromName string // optional; display name of the From header, the outbound number is used if not set
 	to       string
 	user     string
 	pass     string
 	dtmf     string
 	ringtone bool
+	headers  map[string]string // optional; custom headers added to the INVITE, see AttributesToHeaders
+}
+
+// equal checks if both configs describe the same call.
+func (c sipOutboundConfig) equal(o sipOutboundConfig) bool {
+	return c.trunkID == o.trunkID && c.address == o.address &&
+		c.from == o.from && c.fromName == o.fromName && c.to == o.to &&
+		c.user == o.user && c.pass == o.pass &&
+		c.dtmf == o.dtmf && c.ringtone == o.ringtone &&
+		maps.Equal(c.headers, o.headers)
 }
 
 type outboundCall struct {
-	c          *Client
-	log        logger.Logger
-	rtpConn    *rtp.Conn
-	rtpOut     *rtp.SeqWriter
-	rtpAudio   *rtp.Stream
-	rtpDTMF    *rtp.Stream
-	audioCodec rtp.AudioCodec
-	audioOut   media.Writer[media.PCM16Sample]
-	audioType  byte
-	dtmfType   byte
-	stopped    core.Fuse
+	c            *Client
+	log          logger.Logger
+	id           string
+	rtpConn      *rtp.Conn
+	rtpOut       *rtp.SeqWriter
+	rtpKeepAlive func()            // stops RTP keepalive
+	rtcpReports  func()            // stops RTCP sender reports
+	rtpClock     *rtp.SenderClock  // remote stream clock from RTCP sender reports
+	rtpXR        *rtcpxr.Collector // VoIP metrics of the remote stream for RTCP XR, if enabled
+	early        *earlyMedia       // switches between local ringback and far-end early media until answered
+	dtlsSess     *dtls.Session     // set if DTLS-SRTP is negotiated
+	iceAgent     *iceAgent         // set if ICE is enabled
+	rtpAudio     *rtp.Stream
+	rtpDTMF      *rtp.Stream
+	audioCodec   rtp.AudioCodec
+	audioOut     media.Writer[media.PCM16Sample]
+	audioType    byte
+	dtmfType     byte
+	stopped      core.Fuse
+	transcriber  *transcription.Streamer // set if transcription is enabled
 
 	mu            sync.RWMutex
 	mon           *stats.CallMonitor
 	mediaRunning  bool
 	lkRoom        *Room
 	lkRoomIn      media.Writer[media.PCM16Sample]
+	lkRoomName    string
 	sipCur        sipOutboundConfig
+	sipCaps       *trunkCapabilities // trunk capabilities from OPTIONS; optional
 	sipInviteReq  *sip.Request
 	sipInviteResp *sip.Response
 	sipRunning    bool
+	sipWatched    bool               // set once media timeout detection starts
+	sipMaxDur     *time.Timer        // closes the call once it reaches the max duration of the trunk; optional
+	sipCSeq       uint32             // last CSeq used in the dialog
+	sipStarted    time.Time          // for webhook events
+	sipStartedCfg sipOutboundConfig  // for webhook events
+	sipAnswered   time.Time          // for call detail records
+	prof          *callpprof.Session // optional
+	release       func()             // releases the trunk call slot; optional
+	transfer      sipTransfer
 }
 
-func (c *Client) newCall(conf *config.Config, log logger.Logger, id string, room lkRoomConfig) (*outboundCall, error) {
+// newCall creates an outbound call. The release function is called once the call ends, even if it fails to start.
+// Profile is optional; it's stopped once the call ends.
+func (c *Client) newCall(conf *config.Config, log logger.Logger, id string, prof *callpprof.Session, release func(), room lkRoomConfig) (*outboundCall, error) {
 	call := &outboundCall{
-		c:   c,
-		log: log,
+		c:       c,
+		log:     log,
+		id:      id,
+		release: release,
+		prof:    prof,
+
+		transcriber: newTranscriber(conf, log, id),
 	}
-	call.rtpConn = rtp.NewConn(func() {
-		call.close("media-timeout")
-	})
+	var onTimeout func()
+	if !conf.MediaTimeoutDetection {
+		onTimeout = func() {
+			call.close("media-timeout")
+		}
+	}
+	call.rtpConn = rtp.NewConn(onTimeout)
 
 	if err := call.startMedia(conf); err != nil {
 		call.close("media-failed")
@@ -129,6 +185,7 @@ func (c *outboundCall) close(reason string) {
 	c.lkRoom.SetOutput(nil)
 
 	if c.mediaRunning {
+		logRTPStats(c.log, c.rtpConn.Stats())
 		_ = c.rtpConn.Close()
 	}
 	c.mediaRunning = false
@@ -138,9 +195,14 @@ func (c *outboundCall) close(reason string) {
 	}
 	c.lkRoom = nil
 	c.lkRoomIn = nil
+	_ = c.transcriber.Close()
 
 	c.stopSIP(reason)
 	c.sipCur = sipOutboundConfig{}
+	stopCallProfile(c.log, c.mon, c.prof)
+	if c.release != nil {
+		c.release()
+	}
 
 	c.c.cmu.Lock()
 	delete(c.c.activeCalls, c)
@@ -154,9 +216,11 @@ func (c *outboundCall) Participant() Participant {
 }
 
 func (c *outboundCall) UpdateSIP(ctx context.Context, sipNew sipOutboundConfig) error {
+	// Query the trunk before taking the lock, since it may wait for the OPTIONS response. Responses are cached.
+	caps := c.c.trunkCapabilities(sipNew)
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.sipCur == sipNew {
+	if c.sipCur.equal(sipNew) {
 		return nil
 	}
 	if sipNew.address == "" || sipNew.to == "" {
@@ -166,7 +230,7 @@ func (c *outboundCall) UpdateSIP(ctx context.Context, sipNew sipOutboundConfig)
 		return nil
 	}
 	c.startMonitor(sipNew)
-	if err := c.updateSIP(ctx, sipNew); err != nil {
+	if err := c.updateSIP(ctx, sipNew, caps); err != nil {
 		c.close("invite-failed")
 		return fmt.Errorf("update SIP failed: %w", err)
 	}
@@ -183,7 +247,7 @@ func (c *outboundCall) startMedia(conf *config.Config) error {
 	if c.mediaRunning {
 		return nil
 	}
-	if err := c.rtpConn.ListenAndServe(conf.RTPPort.Start, conf.RTPPort.End, "0.0.0.0"); err != nil {
+	if err := c.rtpConn.ListenAndServePool(c.c.ports, "0.0.0.0"); err != nil {
 		return err
 	}
 	c.log.Debugw("begin listening on UDP", "port", c.rtpConn.LocalAddr().Port)
@@ -197,8 +261,30 @@ func (c *outboundCall) updateRoom(lkNew lkRoomConfig) error {
 		c.lkRoom = nil
 		c.lkRoomIn = nil
 	}
-	r := NewRoom(c.log)
-	if err := r.Connect(c.c.conf, lkNew.roomName, lkNew.identity, lkNew.name, lkNew.meta, lkNew.wsUrl, lkNew.token); err != nil {
+	r := newRoom(c.log, c.c.connectRoom)
+	r.monitorMixer(c.c.mon)
+	r.OnMessage(func(text string) {
+		// Do not block the room callback while waiting for the SIP response.
+		go func() {
+			if err := c.sipMessage(text); err != nil {
+				c.log.Warnw("Cannot send SIP MESSAGE", err)
+			}
+		}()
+	})
+	if c.c.conf.TranscriptionWebhookURL != "" {
+		r.OnTranscription(func(text string) {
+			go func() {
+				if err := c.sipTranscription(text); err != nil {
+					c.log.Warnw("Cannot send transcript with SIP INFO", err)
+				}
+			}()
+		})
+	}
+	r.OnDial(c.c.dial.dialFromRoom(c.log, r))
+	if c.c.conf.LiveKitDataChannelTransferEnabled {
+		r.OnTransfer(transferFromRoom(c.c.conf, c.log, r, c.id, c.transferCall))
+	}
+	if err := r.Connect(c.c.conf, lkNew.roomName, lkNew.identity, lkNew.name, lkNew.meta, lkNew.attrs, lkNew.wsUrl, lkNew.token); err != nil {
 		return err
 	}
 	local, err := r.NewParticipantTrack()
@@ -208,25 +294,33 @@ func (c *outboundCall) updateRoom(lkNew lkRoomConfig) error {
 	}
 	c.lkRoom = r
 	c.lkRoomIn = local
+	c.lkRoomName = lkNew.roomName
 	return nil
 }
 
-func (c *outboundCall) updateSIP(ctx context.Context, sipNew sipOutboundConfig) error {
-	if c.sipCur == sipNew {
+func (c *outboundCall) updateSIP(ctx context.Context, sipNew sipOutboundConfig, caps *trunkCapabilities) error {
+	if c.sipCur.equal(sipNew) {
 		return nil
 	}
 	c.stopSIP("update")
+	var ringback func(ctx context.Context)
 	if sipNew.ringtone {
 		const ringVolume = math.MaxInt16 / 2
-		rctx, rcancel := context.WithCancel(ctx)
-		defer rcancel()
-
-		go tones.Play(rctx, c.lkRoomIn, ringVolume, tones.ETSIRinging)
+		room := c.lkRoomIn
+		ringback = func(ctx context.Context) {
+			_ = tones.Play(ctx, room, ringVolume, tones.ETSIRinging)
+		}
 	}
-	err := c.sipSignal(sipNew)
+	c.early = newEarlyMedia(c.log, ringback, func(body []byte) error {
+		return c.linkEarlyMedia(sipNew.trunkID, body)
+	})
+	defer c.early.Close()
+	err := c.sipSignal(sipNew, caps)
 	if err != nil {
 		return err
 	}
+	c.early.Connected()
+	c.lkRoom.SetAttributes(c.participantAttributes(sipNew))
 
 	if sipNew.dtmf != "" {
 		if err := dtmf.Write(ctx, c.audioOut, c.rtpDTMF, sipNew.dtmf); err != nil {
@@ -236,9 +330,34 @@ func (c *outboundCall) updateSIP(ctx context.Context, sipNew sipOutboundConfig)
 
 	c.sipRunning = true
 	c.sipCur = sipNew
+	c.sipCaps = caps
+	if c.c.conf.MediaTimeoutDetection && !c.sipWatched {
+		c.sipWatched = true
+		w := &mediaWatch{
+			log:     c.log,
+			mon:     c.mon,
+			conn:    c.rtpConn,
+			timeout: c.c.conf.GetMediaTimeout(),
+			refresh: c.sipRefreshSession,
+			close:   c.CloseWithReason,
+		}
+		go w.run(c.Closed())
+	}
 	return nil
 }
 
+// participantAttributes returns attributes of the SIP participant for an answered call.
+func (c *outboundCall) participantAttributes(conf sipOutboundConfig) map[string]string {
+	attrs := map[string]string{
+		AttrFrom: conf.from,
+		AttrTo:   conf.to,
+	}
+	if h, ok := c.sipInviteReq.CallID(); ok {
+		attrs[AttrCallID] = h.Value()
+	}
+	return attrs
+}
+
 func (c *outboundCall) relinkMedia() {
 	if c.lkRoom == nil || !c.mediaRunning {
 		c.lkRoom.SetOutput(nil)
@@ -249,14 +368,24 @@ func (c *outboundCall) relinkMedia() {
 	c.lkRoom.SetOutput(c.audioOut)
 
 	// Decoding pipeline (SIP -> LK)
-	h := c.audioCodec.DecodeRTP(c.lkRoomIn, c.audioType)
+	in := withTranscriber(c.lkRoomIn, c.transcriber)
+	if dtmfAllowInband(c.c.conf, c.dtmfType) {
+		in = media.WriterTee(in, dtmf.NewDetector(rtp.DefSampleRate, c.onDTMF))
+	}
 	mux := rtp.NewMux(nil)
+	var rh rtp.Handler = newRTPSeqStatsHandler(c.mon, mux)
+	if c.rtpClock != nil {
+		rtpSync := newRTPSyncHandler(c.mon, c.sipCur.address, c.rtpClock, rh)
+		in = rtp.NewDriftCompensator(in, rtpSync.Drift)
+		rh = rtpSync
+	}
+	h := decodeAudio(c.audioCodec, c.audioType, in)
 	mux.SetDefault(newRTPStatsHandler(c.mon, "", nil))
 	mux.Register(c.audioType, newRTPStatsHandler(c.mon, c.audioCodec.Info().SDPName, h))
 	if c.dtmfType != 0 {
 		mux.Register(c.dtmfType, newRTPStatsHandler(c.mon, dtmf.SDPName, rtp.HandlerFunc(c.handleDTMF)))
 	}
-	c.rtpConn.OnRTP(mux)
+	c.rtpConn.OnRTP(newRTPXRHandler(c.rtpXR, rh))
 }
 
 func (c *outboundCall) SendDTMF(ctx context.Context, digits string) error {
@@ -270,19 +399,114 @@ func (c *outboundCall) SendDTMF(ctx context.Context, digits string) error {
 	return nil
 }
 
+var errNoResponse = errors.New("transaction failed to complete")
+
+// ForwardedToTopic is a LiveKit data topic used to announce the target of the outbound call forwarding (SIP 181).
+//
+// The target is set with AttrForwardedTo participant attribute as well, see HoldTopic.
+const ForwardedToTopic = "sip_forwarded_to"
+
 func sipResponse(tx sip.ClientTransaction) (*sip.Response, error) {
-	select {
-	case <-tx.Done():
-		return nil, fmt.Errorf("transaction failed to complete")
-	case res := <-tx.Responses():
-		if res.StatusCode == 100 || res.StatusCode == 180 || res.StatusCode == 183 {
-			return sipResponse(tx)
+	return sipResponseWith(tx, nil)
+}
+
+// sipResponseWith waits for a final response. Provisional responses other than 100 are passed to onProvisional, if set.
+func sipResponseWith(tx sip.ClientTransaction, onProvisional func(res *sip.Response)) (*sip.Response, error) {
+	for {
+		select {
+		case <-tx.Done():
+			return nil, errNoResponse
+		case res := <-tx.Responses():
+			switch res.StatusCode {
+			case 100:
+			case 180, 181, 183:
+				if onProvisional != nil {
+					onProvisional(res)
+				}
+			default:
+				return res, nil
+			}
 		}
-		return res, nil
 	}
 }
 
+// onProvisional handles a provisional response to the outbound INVITE.
+func (c *outboundCall) onProvisional(res *sip.Response) {
+	if c.early != nil {
+		c.early.Provisional(res, messageSDP(c.log, res))
+	}
+	if res.StatusCode != 181 {
+		return
+	}
+	cont, ok := res.Contact()
+	if !ok {
+		c.log.Infow("Call is being forwarded")
+		return
+	}
+	target := cont.Address.String()
+	c.log.Infow("Call is being forwarded", "target", target)
+	c.lkRoom.SetAttributes(map[string]string{AttrForwardedTo: target})
+	data := &lksdk.UserDataPacket{Payload: []byte(target), Topic: ForwardedToTopic}
+	if err := c.lkRoom.SendData(data, lksdk.WithDataPublishReliable(true)); err != nil {
+		c.log.Warnw("Cannot send forwarding target to the room", err)
+	}
+}
+
+func (c *outboundCall) newEvent(typ string) *webhook.CallEvent {
+	return &webhook.CallEvent{
+		Event:     typ,
+		CallID:    c.id,
+		Direction: stats.Outbound.String(),
+		From:      c.sipStartedCfg.from,
+		To:        c.sipStartedCfg.to,
+		RoomName:  c.lkRoomName,
+	}
+}
+
+// cdrRecord returns the call detail record of the call, which ends with a given reason.
+func (c *outboundCall) cdrRecord(reason string) *cdr.CDRRecord {
+	r := &cdr.CDRRecord{
+		CallID:      c.id,
+		TrunkID:     c.sipStartedCfg.trunkID,
+		From:        c.sipStartedCfg.from,
+		To:          c.sipStartedCfg.to,
+		StartTime:   c.sipStarted,
+		AnswerTime:  c.sipAnswered,
+		EndTime:     time.Now(),
+		Direction:   stats.Outbound.String(),
+		HangupCause: reason,
+	}
+	if !r.AnswerTime.IsZero() {
+		r.Duration = r.EndTime.Sub(r.AnswerTime).Seconds()
+	}
+	setCDRStats(r, c.rtpConn.Stats())
+	return r
+}
+
+// publishState sends the call state to the event state compositor, if configured.
+func (c *outboundCall) publishState(conf sipOutboundConfig) {
+	if c.c.pub == nil {
+		return
+	}
+	remote, _ := sipTrunkURI(conf.to, conf.address)
+	body, err := publish.CallDocument(conf.from, c.c.signalingIp, c.id, remote.String())
+	if err != nil {
+		c.log.Warnw("Cannot generate call state document", err)
+		return
+	}
+	c.c.pub.Publish(c.id, conf.from, body)
+}
+
 func (c *outboundCall) stopSIP(reason string) {
+	if !c.sipStarted.IsZero() {
+		ev := c.newEvent(webhook.EventCallEnded)
+		ev.Duration = time.Since(c.sipStarted).Seconds()
+		ev.Reason = reason
+		c.c.hook.Notify(ev)
+		c.c.cdrs.Export(c.cdrRecord(reason))
+		c.c.pub.Remove(c.id)
+		c.sipStarted, c.sipAnswered = time.Time{}, time.Time{}
+	}
 	if c.sipInviteReq != nil {
 		if err := c.sipBye(); err != nil {
 			c.log.Errorw("SIP bye failed", err)
@@ -292,17 +516,58 @@ func (c *outboundCall) stopSIP(reason string) {
 			c.mon.CallEnd()
 		}
 	}
+	if c.sipMaxDur != nil {
+		c.sipMaxDur.Stop()
+		c.sipMaxDur = nil
+	}
 	c.sipInviteReq = nil
 	c.sipInviteResp = nil
+	c.sipCSeq = 0
 	c.sipCur = sipOutboundConfig{}
+	c.sipCaps = nil
 	c.sipRunning = false
+	if c.rtpKeepAlive != nil {
+		c.rtpKeepAlive()
+		c.rtpKeepAlive = nil
+	}
+	if c.rtcpReports != nil {
+		c.rtcpReports()
+		c.rtcpReports = nil
+	}
+	if c.dtlsSess != nil {
+		_ = c.dtlsSess.Close()
+		c.dtlsSess = nil
+		c.rtpConn.SetCipher(nil)
+	}
+	if c.iceAgent != nil {
+		c.iceAgent.Close()
+		c.iceAgent = nil
+	}
 }
 
-func (c *outboundCall) sipSignal(conf sipOutboundConfig) error {
-	offer, err := sdpGenerateOffer(c.c.signalingIp, c.rtpConn.LocalAddr().Port)
+func (c *outboundCall) sipSignal(conf sipOutboundConfig, caps *trunkCapabilities) error {
+	start := time.Now()
+	offer, err := c.c.sipOffer(conf, caps, c.rtpConn.LocalAddr().Port)
 	if err != nil {
 		return err
 	}
+	sdpDur := time.Since(start)
+	if c.c.conf.ICEEnabled {
+		c.iceAgent, err = newICEAgent(c.log, c.c.conf, c.rtpConn)
+		if err != nil {
+			return err
+		}
+		if offer, err = sdpWithICE(offer, c.iceAgent.Local()); err != nil {
+			return err
+		}
+	}
+	if c.c.dtlsCert != nil {
+		// Media is dropped until the DTLS handshake completes.
+		c.rtpConn.RequireCipher()
+	}
+	c.sipStarted, c.sipStartedCfg = time.Now(), conf
+	c.c.hook.Notify(c.newEvent(webhook.EventCallStarted))
+	c.publishState(conf)
 	c.mon.CallStart()
 	joinDur := c.mon.JoinDur()
 	inviteReq, inviteResp, err := c.sipInvite(offer, conf)
@@ -313,16 +578,18 @@ func (c *outboundCall) sipSignal(conf sipOutboundConfig) error {
 	}
 	c.sipInviteReq, c.sipInviteResp = inviteReq, inviteResp
 
+	start = time.Now()
 	answer := sdp.SessionDescription{}
-	if err := answer.Unmarshal(c.sipInviteResp.Body()); err != nil {
+	if err := answer.Unmarshal(messageSDP(c.log, c.sipInviteResp)); err != nil {
 		return err
 	}
-	res, err := sdpGetAudioCodec(answer)
+	res, err := sdpGetAudioCodecWith(answer, c.c.conf.CodecPreference[conf.trunkID])
 	if err != nil {
 		c.mon.CallEnd()
 		c.log.Errorw("SIP SDP failed", err)
 		return err
 	}
+	c.mon.SDPNegotiationDur(sdpDur + time.Since(start))
 	c.log.Infow("Using codecs",
 		"audio-codec", res.Audio.Info().SDPName, "audio-rtp", res.AudioType,
 		"dtmf-rtp", res.DTMFType,
@@ -335,44 +602,135 @@ func (c *outboundCall) sipSignal(conf sipOutboundConfig) error {
 		return err
 	}
 	joinDur()
+	c.sipAnswered = time.Now()
+	c.c.hook.Notify(c.newEvent(webhook.EventCallAnswered))
+	c.sipMaxDur = startMaxDuration(c.log, c.mon, conf.trunkID, c.c.conf.GetMaxCallDuration(conf.trunkID), c.CloseWithReason)
 
 	c.audioCodec = res.Audio
 	c.audioType = res.AudioType
 	c.dtmfType = res.DTMFType
+	c.rtpConn.TrackJitter(res.AudioType, rtp.CodecClockRate(res.Audio))
 	if dst := sdpGetAudioDest(answer); dst != nil {
 		c.rtpConn.SetDestAddr(dst)
 	}
+	if c.iceAgent != nil {
+		if remote := sdpGetICE(answer); remote != nil {
+			c.iceAgent.Start(remote, true)
+		} else {
+			c.iceAgent.Close()
+			c.iceAgent = nil
+		}
+	}
+	if c.c.dtlsCert != nil {
+		remote := sdpGetDTLS(answer)
+		if remote == nil {
+			c.mon.CallEnd()
+			err = errors.New("trunk did not accept DTLS-SRTP")
+			c.log.Errorw("SIP SDP failed", err)
+			return err
+		}
+		sess, err := startDTLS(context.Background(), c.rtpConn, c.c.dtlsCert, dtls.OffererRole(remote.Setup), remote)
+		if err != nil {
+			c.mon.CallEnd()
+			c.log.Errorw("DTLS-SRTP handshake failed", err)
+			return err
+		}
+		c.log.Infow("DTLS-SRTP established", "profile", sess.Profile())
+		c.dtlsSess = sess
+	}
 
 	// TODO: this says "audio", but will actually count DTMF too
 	c.rtpOut = rtp.NewSeqWriter(newRTPStatsWriter(c.mon, "audio", c.rtpConn))
 	c.rtpAudio = c.rtpOut.NewStream(c.audioType)
 	c.rtpDTMF = c.rtpOut.NewStream(c.dtmfType)
+	if dt := c.c.conf.NATKeepAlive(conf.trunkID); dt > 0 {
+		c.rtpKeepAlive = c.rtpOut.KeepAlive(dt)
+	}
+	c.rtpClock = rtp.NewSenderClock(rtp.DefSampleRate)
+	c.rtpXR = newRTCPXRCollector(c.c.conf)
+	c.rtpConn.OnRTCP(newRTCPStatsHandler(c.mon, conf.address, c.rtpOut.SSRC(), c.rtpClock, c.rtpXR))
+	if startRTCP(c.log, c.rtpConn, res.RTCPMux, c.dtlsSess != nil) {
+		c.rtcpReports = c.rtpOut.SenderReportsWith(c.rtpConn, rtp.DefSampleRate, rtp.DefRTCPInterval, rtcpXRReports(c.rtpXR, c.rtpOut.SSRC()))
+	}
 
 	// Encoding pipeline (LK -> SIP)
-	c.audioOut = c.audioCodec.EncodeRTP(c.rtpAudio)
+	c.audioOut = encodeAudio(c.c.conf, c.audioCodec, c.rtpAudio)
 	return nil
 }
 
-func (c *outboundCall) sipAttemptInvite(offer []byte, conf sipOutboundConfig, authHeader string) (*sip.Request, *sip.Response, error) {
-	c.mon.InviteReq()
-
-	dest := conf.address + ":5060"
-	to := &sip.Uri{User: conf.to, Host: conf.address, Port: 5060}
-	if addr, sport, err := net.SplitHostPort(conf.address); err == nil {
+// sipTrunkURI returns the URI of the user on the trunk, and the destination address for requests.
+func sipTrunkURI(user, address string) (*sip.Uri, string) {
+	dest := address + ":5060"
+	to := &sip.Uri{User: user, Host: address, Port: 5060}
+	if addr, sport, err := net.SplitHostPort(address); err == nil {
 		if port, err := strconv.Atoi(sport); err == nil {
 			to.Host = addr
 			to.Port = port
-			dest = conf.address
+			dest = address
+		}
+	}
+	return to, dest
+}
+
+// sipAuth holds digest credentials for endpoint (401) and proxy (407) authentication.
+type sipAuth struct {
+	auth  string // Authorization
+	proxy string // Proxy-Authorization
+}
+
+// fromDisplayName renders the display name of the From header configured for the trunk.
+// Tokens with empty values are removed, and an empty result falls back to the fixed display name.
+func fromDisplayName(conf config.OutboundFromConfig, name, number, room string) string {
+	if conf.FromDisplayNameTemplate == "" {
+		return conf.FromDisplayName
+	}
+	r := strings.NewReplacer("{name}", name, "{number}", number, "{room}", room)
+	out := strings.Join(strings.Fields(r.Replace(conf.FromDisplayNameTemplate)), " ")
+	if out == "" {
+		return conf.FromDisplayName
+	}
+	return out
+}
+
+// sipMaxUDPRequest is the size of requests which must be sent over TCP instead of UDP (RFC 3261, Section 18.1.1).
+// It leaves room for the Via header, which is added by the client later.
+const sipMaxUDPRequest = int(sip.MTU) - 200 - 200
+
+// sipAttemptInvite sends a single INVITE to the trunk. If the target is set, the request is sent to it instead of
+// the trunk address, while the Request-URI keeps the trunk domain (RFC 3263).
+func (c *outboundCall) sipAttemptInvite(offer []byte, conf sipOutboundConfig, auth sipAuth, target *sipTarget) (*sip.Request, *sip.Response, error) {
+	c.mon.InviteReq()
+
+	to, dest := sipTrunkURI(conf.to, conf.address)
+	if target != nil {
+		dest = target.addr
+		if target.transport != "" {
+			// Resolved from SRV records, thus the Request-URI keeps the trunk domain without a port.
+			to.Port = 0
 		}
 	}
 	from := &sip.Uri{User: conf.from, Host: c.c.signalingIp}
 
-	fromHeader := &sip.FromHeader{Address: *from, DisplayName: conf.from, Params: sip.NewParams()}
+	fromName := conf.fromName
+	if fromName == "" {
+		fromName = conf.from
+	}
+	fromHeader := &sip.FromHeader{Address: *from, DisplayName: fromName, Params: sip.NewParams()}
 	fromHeader.Params.Add("tag", sip.GenerateTagN(16))
 
 	req := sip.NewRequest(sip.INVITE, to)
 	req.SetDestination(dest)
-	req.SetBody(offer)
+	if target != nil && target.transport != "" {
+		req.SetTransport(target.transport)
+	}
+	contentType, body := "application/sdp", offer
+	if c.c.smime != nil {
+		var err error
+		if contentType, body, err = c.c.smime.SignBody(contentType, offer); err != nil {
+			return nil, nil, err
+		}
+	}
+	req.SetBody(body)
 	req.AppendHeader(&sip.ToHeader{Address: *to})
 	req.AppendHeader(fromHeader)
 	req.AppendHeader(&sip.ContactHeader{Address: *from})
@@ -404,37 +762,121 @@ func (c *outboundCall) sipAttemptInvite(offer []byte, conf sipOutboundConfig, au
 			Params:          params,
 		})
 	}
-	req.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+	req.AppendHeader(sip.NewHeader("Content-Type", contentType))
 	req.AppendHeader(sip.NewHeader("Allow", "INVITE, ACK, CANCEL, BYE, NOTIFY, REFER, MESSAGE, OPTIONS, INFO, SUBSCRIBE"))
+	appendHeaders(req, conf.headers)
 
-	if authHeader != "" {
-		req.AppendHeader(sip.NewHeader("Proxy-Authorization", authHeader))
+	if auth.auth != "" {
+		req.AppendHeader(sip.NewHeader("Authorization", auth.auth))
+	}
+	if auth.proxy != "" {
+		req.AppendHeader(sip.NewHeader("Proxy-Authorization", auth.proxy))
+	}
+	if req.Transport() == "UDP" && len(req.String()) > sipMaxUDPRequest {
+		// Signed offers often don't fit into a datagram, so switch to TCP (RFC 3261, Section 18.1.1).
+		req.SetTransport("TCP")
 	}
 
-	tx, err := c.c.sipCli.TransactionRequest(req)
+	tx, err := c.c.inviteRequest(c.log, req)
 	if err != nil {
 		c.mon.InviteError("tx-failed")
 		return nil, nil, err
 	}
 	defer tx.Terminate()
 
-	resp, err := sipResponse(tx)
+	resp, err := sipResponseWith(tx, c.onProvisional)
 	if err != nil {
 		c.mon.InviteError("tx-failed")
 	}
 	return req, resp, err
 }
 
-func (c *outboundCall) sipInvite(offer []byte, conf sipOutboundConfig) (*sip.Request, *sip.Response, error) {
-	authHeader := ""
-	for {
-		req, resp, err := c.sipAttemptInvite(offer, conf, authHeader)
+// inviteRequest starts the INVITE transaction. INVITEs over TCP are sent over pooled connections if the pool is enabled,
+// and retried once over a new connection if the pooled one was closed by the trunk.
+func (c *Client) inviteRequest(log logger.Logger, req *sip.Request) (sip.ClientTransaction, error) {
+	if c.tcpPool == nil || req.Transport() != "TCP" {
+		return c.sipCli.TransactionRequest(req)
+	}
+	acquire := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), poolDialTimeout)
+		defer cancel()
+		addr, err := c.tcpPool.Acquire(ctx, req.Destination())
 		if err != nil {
+			// Let the transport dial the trunk, and report the error.
+			log.Debugw("Cannot acquire pooled connection", "error", err, "dest", req.Destination())
+			return
+		}
+		req.SetDestination(addr)
+	}
+	acquire()
+	tx, err := c.sipCli.TransactionRequest(req)
+	if err != nil && isBrokenPipe(err) {
+		log.Infow("Pooled connection is broken, retrying with a new one", "error", err, "dest", req.Destination())
+		c.tcpPool.Evict(req.Destination())
+		acquire()
+		tx, err = c.sipCli.TransactionRequest(req)
+	}
+	return tx, err
+}
+
+func (c *outboundCall) sipInvite(offer []byte, conf sipOutboundConfig) (_ *sip.Request, _ *sip.Response, gerr error) {
+	defer func() {
+		// The trunk may change on failover, so the last one is blamed.
+		if gerr != nil {
+			c.c.trunkStatus.CallFailed(conf.trunkID, gerr.Error())
+		}
+	}()
+	inviteDur := c.mon.InviteDur()
+	var auth sipAuth
+	maxRedirects := c.c.conf.GetMaxRedirects()
+	redirects := 0
+	visited := map[string]struct{}{
+		redirectTarget(conf): {},
+	}
+	retries := 0
+	targets, next := c.c.trunkTargets(conf.trunkID, conf.address), 0
+	trunks, nextTrunk := c.c.conf.FailoverTrunks(conf.trunkID), 0
+	trunkFailover := func(reason string) bool {
+		if !c.sipTrunkFailover(&conf, trunks, &nextTrunk, reason) {
+			return false
+		}
+		visited[redirectTarget(conf)] = struct{}{}
+		auth = sipAuth{}
+		targets, next = c.c.trunkTargets(conf.trunkID, conf.address), 0
+		return true
+	}
+	for {
+		var dst *sipTarget
+		if next < len(targets) {
+			dst = &targets[next]
+		}
+		c.c.trunkStatus.Invite(conf.trunkID)
+		req, resp, err := c.sipAttemptInvite(offer, conf, auth, dst)
+		if err != nil && c.sipFailover(targets, &next, "tx-failed") {
+			auth = sipAuth{}
+			continue
+		} else if err != nil && trunkFailover("tx-failed") {
+			continue
+		} else if errors.Is(err, errNoResponse) && c.sipRetry(&retries, "timeout") {
+			next = 0
+			continue
+		} else if err != nil {
 			return nil, nil, err
 		}
 		switch resp.StatusCode {
 		default:
 			c.mon.InviteError(fmt.Sprintf("status-%d", resp.StatusCode))
+			if resp.StatusCode == 503 && c.sipFailover(targets, &next, "status-503") {
+				auth = sipAuth{}
+				continue
+			}
+			if resp.StatusCode == 503 && trunkFailover("status-503") {
+				continue
+			}
+			if resp.StatusCode/100 == 5 && c.sipRetry(&retries, fmt.Sprintf("status-%d", resp.StatusCode)) {
+				next = 0
+				continue
+			}
 			return nil, nil, fmt.Errorf("Unexpected StatusCode from INVITE response %d", resp.StatusCode)
 		case 400:
 			c.mon.InviteError("status-400")
@@ -450,37 +892,168 @@ func (c *outboundCall) sipInvite(offer []byte, conf sipOutboundConfig) (*sip.Req
 			return nil, nil, fmt.Errorf("INVITE failed with status %d", resp.StatusCode)
 		case 200:
 			c.mon.InviteAccept()
+			inviteDur()
 			return req, resp, nil
+		case 301, 302:
+			c.mon.InviteError(fmt.Sprintf("status-%d", resp.StatusCode))
+			cont, ok := resp.Contact()
+			if !ok {
+				return nil, nil, fmt.Errorf("INVITE redirected with status %d, but no Contact was provided", resp.StatusCode)
+			}
+			conf = redirectConfig(conf, cont.Address)
+			target := redirectTarget(conf)
+			if _, ok := visited[target]; ok {
+				return nil, nil, fmt.Errorf("INVITE redirect loop detected: %s", target)
+			}
+			visited[target] = struct{}{}
+			redirects++
+			if redirects > maxRedirects {
+				return nil, nil, fmt.Errorf("INVITE redirected too many times (max %d)", maxRedirects)
+			}
+			c.log.Infow("INVITE redirected", "status", resp.StatusCode, "target", target)
+			// Credentials may be different for the new target, so start without auth.
+			auth = sipAuth{}
+			targets, next = c.c.trunkTargets(conf.trunkID, conf.address), 0
+			continue
+		case 401:
+			// endpoint auth required
+			c.mon.InviteError("auth-required")
+			if auth.auth != "" {
+				return nil, nil, fmt.Errorf("INVITE authentication failed with status %d", resp.StatusCode)
+			}
+			auth.auth, err = sipDigest(req, resp, "WWW-Authenticate", conf.user, conf.pass)
 		case 407:
-			// auth required
+			// proxy auth required
 			c.mon.InviteError("auth-required")
+			if auth.proxy != "" {
+				return nil, nil, fmt.Errorf("INVITE proxy authentication failed with status %d", resp.StatusCode)
+			}
+			user, pass := c.c.proxyCredentials(conf)
+			auth.proxy, err = sipDigest(req, resp, "Proxy-Authenticate", user, pass)
 		}
-		if conf.user == "" || conf.pass == "" {
-			return nil, nil, fmt.Errorf("Server responded with 407, but no username or password was provided")
-		}
-		headerVal := resp.GetHeader("Proxy-Authenticate")
-		challenge, err := digest.ParseChallenge(headerVal.Value())
 		if err != nil {
 			return nil, nil, err
 		}
+		// Try again with a computed digest
+	}
+}
 
-		toHeader, ok := resp.To()
-		if !ok {
-			return nil, nil, fmt.Errorf("No To Header on Request")
-		}
+// proxyCredentials returns credentials for proxy authentication of the trunk. Trunk credentials are used if not configured.
+func (c *Client) proxyCredentials(conf sipOutboundConfig) (user, pass string) {
+	if auth, ok := c.conf.ProxyAuth[conf.address]; ok {
+		return auth.ProxyAuthUser, auth.ProxyAuthPassword
+	}
+	return conf.user, conf.pass
+}
 
-		cred, err := digest.Digest(challenge, digest.Options{
-			Method:   req.Method.String(),
-			URI:      toHeader.Address.String(),
-			Username: conf.user,
-			Password: conf.pass,
-		})
-		if err != nil {
-			return nil, nil, err
-		}
-		authHeader = cred.String()
-		// Try again with a computed digest
+// sipDigest computes digest credentials for a challenge in a given response header.
+func sipDigest(req *sip.Request, resp *sip.Response, header, user, pass string) (string, error) {
+	toHeader, ok := resp.To()
+	if !ok {
+		return "", fmt.Errorf("No To Header on Request")
+	}
+	return sipDigestURI(req, resp, header, toHeader.Address.String(), user, pass)
+}
+
+// sipDigestURI computes digest credentials for a challenge in a given response header, with a given digest URI.
+func sipDigestURI(req *sip.Request, resp *sip.Response, header, uri, user, pass string) (string, error) {
+	if user == "" || pass == "" {
+		return "", fmt.Errorf("Server responded with %d, but no username or password was provided", resp.StatusCode)
+	}
+	headerVal := resp.GetHeader(header)
+	if headerVal == nil {
+		return "", fmt.Errorf("Server responded with %d, but no %s header was provided", resp.StatusCode, header)
+	}
+	challenge, err := digest.ParseChallenge(headerVal.Value())
+	if err != nil {
+		return "", err
+	}
+
+	cred, err := digest.Digest(challenge, digest.Options{
+		Method:   req.Method.String(),
+		URI:      uri,
+		GetBody:  digestBody(req.Body()),
+		Username: user,
+		Password: pass,
+	})
+	if err != nil {
+		return "", err
+	}
+	return cred.String(), nil
+}
+
+// digestBody returns the request body for digest auth. It's only hashed if the server requires qop=auth-int.
+func digestBody(body []byte) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+}
+
+// sipFailover switches to the next SRV target (RFC 3263, section 4.3) or the next address of the trunk host.
+// It returns false if no targets are left. Each target is a different server, thus credentials must be computed again.
+func (c *outboundCall) sipFailover(targets []sipTarget, next *int, reason string) bool {
+	if *next+1 >= len(targets) {
+		return false
+	}
+	*next++
+	c.log.Infow("Trying next trunk target", "reason", reason, "target", targets[*next].addr, "transport", targets[*next].transport)
+	return true
+}
+
+// sipTrunkFailover switches the call to the next trunk from outbound_trunk_failover. It returns false if no trunks are left.
+// Credentials of the call are used for all trunks.
+func (c *outboundCall) sipTrunkFailover(conf *sipOutboundConfig, trunks []string, next *int, reason string) bool {
+	if *next+1 >= len(trunks) {
+		return false
+	}
+	*next++
+	from := conf.trunkID
+	c.c.trunkStatus.CallFailed(from, reason)
+	conf.trunkID = trunks[*next]
+	conf.address = c.c.conf.OutboundTrunks[conf.trunkID]
+	c.mon.TrunkFailover(from, conf.trunkID)
+	c.log.Infow("Trying next trunk", "reason", reason, "from-trunk", from, "to-trunk", conf.trunkID, "to-host", conf.address)
+	return true
+}
+
+// sipRetry waits before retrying the INVITE. It returns false if no retries are left.
+// Each retry sends a new INVITE, thus it will have a new Call-ID.
+func (c *outboundCall) sipRetry(retries *int, reason string) bool {
+	if *retries >= c.c.conf.GetOutboundRetryCount() {
+		return false
+	}
+	delay := c.c.conf.OutboundRetryBackoffBase << *retries
+	*retries++
+	c.mon.OutboundRetry(reason)
+	c.log.Infow("Retrying INVITE", "reason", reason, "attempt", *retries, "delay", delay)
+	select {
+	case <-c.c.closing.Watch():
+		return false
+	case <-time.After(delay):
+	}
+	return true
+}
+
+// redirectConfig updates outbound config to point to a redirect target from the Contact header.
+func redirectConfig(conf sipOutboundConfig, target sip.Uri) sipOutboundConfig {
+	if target.User != "" {
+		conf.to = target.User
+	}
+	conf.address = target.Host
+	if target.Port != 0 {
+		conf.address = net.JoinHostPort(target.Host, strconv.Itoa(target.Port))
+	}
+	return conf
+}
+
+// redirectTarget returns a normalized target of the outbound INVITE, which is used to detect redirect loops.
+// Default SIP port is added if the address has none, so that "host" and "host:5060" are the same target.
+func redirectTarget(conf sipOutboundConfig) string {
+	addr := strings.ToLower(conf.address)
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "5060")
 	}
+	return conf.to + "@" + addr
 }
 
 func (c *outboundCall) sipAccept(inviteReq *sip.Request, inviteResp *sip.Response) error {
@@ -498,8 +1071,108 @@ func (c *outboundCall) sipAccept(inviteReq *sip.Request, inviteResp *sip.Respons
 	return c.c.sipCli.WriteRequest(sip.NewAckRequest(inviteReq, inviteResp, nil))
 }
 
+// sipDialogRequest creates a new request within the dialog established by the INVITE.
+func (c *outboundCall) sipDialogRequest(method sip.RequestMethod, body []byte) *sip.Request {
+	return newUACDialogRequest(method, c.sipInviteReq, c.sipInviteResp, c.sipNextCSeq(), body)
+}
+
+// sipNextCSeq returns the next CSeq of the dialog. Must be called with the call lock held.
+func (c *outboundCall) sipNextCSeq() uint32 {
+	if c.sipCSeq == 0 {
+		if h, ok := c.sipInviteReq.CSeq(); ok {
+			c.sipCSeq = h.SeqNo
+		}
+	}
+	c.sipCSeq++
+	return c.sipCSeq
+}
+
+// sipDialogTransaction sends a request within the dialog, and waits for the final response.
+//
+// Some proxies expire authentication mid-dialog, and challenge re-INVITE or other requests with 401 or 407.
+// Each challenge is answered once with the credentials of the trunk. Unlike the initial INVITE, the request is
+// sent again with the next CSeq of the dialog and the same route set. The call lock must not be held.
+func (c *outboundCall) sipDialogTransaction(req *sip.Request) (*sip.Response, error) {
+	var auth sipAuth
+	for {
+		tx, err := c.c.sipCli.TransactionRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := sipResponse(tx)
+		tx.Terminate()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != 401 && resp.StatusCode != 407 {
+			return resp, nil
+		}
+		next, err := c.sipDialogAuthRequest(req, resp, &auth)
+		if err != nil {
+			return nil, err
+		} else if next == nil {
+			return resp, nil
+		}
+		c.log.Infow("Answering SIP challenge mid-dialog", "method", req.Method, "status", resp.StatusCode)
+		req = next
+	}
+}
+
+// sipDialogAuthRequest answers a 401 or 407 challenge to the dialog request. It returns nil if the challenge
+// was answered already, or if the call has ended.
+func (c *outboundCall) sipDialogAuthRequest(req *sip.Request, resp *sip.Response, auth *sipAuth) (*sip.Request, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sipInviteReq == nil {
+		return nil, nil
+	}
+	conf := c.sipCur
+	// Digest URI is the Request-URI (RFC 3261, section 22.4).
+	uri := req.Recipient.String()
+	var err error
+	switch resp.StatusCode {
+	case 401:
+		if auth.auth != "" {
+			return nil, nil
+		}
+		auth.auth, err = sipDigestURI(req, resp, "WWW-Authenticate", uri, conf.user, conf.pass)
+	case 407:
+		if auth.proxy != "" {
+			return nil, nil
+		}
+		user, pass := c.c.proxyCredentials(conf)
+		auth.proxy, err = sipDigestURI(req, resp, "Proxy-Authenticate", uri, user, pass)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Route headers are copied as is, while Via is added again for the new transaction.
+	next := sip.NewRequest(req.Method, req.Recipient.Clone())
+	next.SipVersion = req.SipVersion
+	for _, h := range req.CloneHeaders() {
+		switch strings.ToLower(h.Name()) {
+		case "via", "authorization", "proxy-authorization":
+			continue
+		}
+		if cseq, ok := h.(*sip.CSeqHeader); ok {
+			cseq.SeqNo = c.sipNextCSeq()
+		}
+		next.AppendHeader(h)
+	}
+	next.SetBody(req.Body())
+	next.SetTransport(req.Transport())
+	next.SetDestination(req.Destination())
+	if auth.auth != "" {
+		next.AppendHeader(sip.NewHeader("Authorization", auth.auth))
+	}
+	if auth.proxy != "" {
+		next.AppendHeader(sip.NewHeader("Proxy-Authorization", auth.proxy))
+	}
+	return next, nil
+}
+
 func (c *outboundCall) sipBye() error {
-	req := sip.NewByeRequest(c.sipInviteReq, c.sipInviteResp, nil)
+	req := c.sipDialogRequest(sip.BYE, nil)
 	c.sipInviteReq.AppendHeader(sip.NewHeader("User-Agent", "LiveKit"))
 
 	tx, err := c.c.sipCli.TransactionRequest(req)
@@ -514,13 +1187,25 @@ func (c *outboundCall) sipBye() error {
 }
 
 func (c *outboundCall) handleDTMF(p *rtp.Packet) error {
+	if !dtmfAllowRTP(c.c.conf) {
+		return nil
+	}
 	ev, ok := dtmf.DecodeRTP(p)
 	if !ok {
 		return nil
 	}
+	c.onDTMF(ev)
+	return nil
+}
+
+// onDTMF handles DTMF digits received via RTP events, SIP INFO or audio tones.
+func (c *outboundCall) onDTMF(ev dtmf.Event) {
+	hev := c.newEvent(webhook.EventCallDTMF)
+	hev.Digit = string([]byte{ev.Digit})
+	c.c.hook.Notify(hev)
 	_ = c.lkRoom.SendData(&livekit.SipDTMF{
 		Code:  uint32(ev.Code),
 		Digit: string([]byte{ev.Digit}),
 	}, lksdk.WithDataPublishReliable(true))
-	return nil
+	_ = c.lkRoom.SendData(dtmfData(c.c.conf.DTMFDataChannelTopic, c.id, ev), lksdk.WithDataPublishReliable(true))
 }
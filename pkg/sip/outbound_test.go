@@ -0,0 +1,697 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/icholy/digest"
+	"github.com/livekit/protocol/logger"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/stats"
+)
+
+// newTestUAS starts a SIP server on a random UDP port, which handles INVITEs with a given function.
+func newTestUAS(t *testing.T, onInvite sipgo.RequestHandler) *net.UDPAddr {
+	return newTestUASWith(t, func(srv *sipgo.Server) {
+		srv.OnInvite(onInvite)
+	})
+}
+
+// newTestUASWith starts a test UAS with handlers registered by setup.
+func newTestUASWith(t *testing.T, setup func(srv *sipgo.Server)) *net.UDPAddr {
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(localIP)})
+	require.NoError(t, err)
+
+	ua, err := sipgo.NewUA()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ua.Close() })
+
+	srv, err := sipgo.NewServer(ua)
+	require.NoError(t, err)
+	setup(srv)
+	srv.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {})
+	go func() {
+		_ = srv.ServeUDP(conn)
+	}()
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+func newTestRedirect(t *testing.T, user string, target *net.UDPAddr) *net.UDPAddr {
+	return newTestUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		res := sip.NewResponseFromRequest(req, 302, "Moved Temporarily", nil)
+		res.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: user, Host: target.IP.String(), Port: target.Port}})
+		_ = tx.Respond(res)
+	})
+}
+
+func newTestOutboundCall(t *testing.T, conf *config.Config) *outboundCall {
+	log := logger.GetLogger()
+	mon := stats.NewMonitor()
+	require.NoError(t, mon.Start(conf))
+	t.Cleanup(mon.Stop)
+
+	cli := NewClient(conf, log, mon, rtp.NewPortPool(testPortRTPMin, testPortRTPMax), nil)
+	require.NoError(t, cli.Start(nil))
+	t.Cleanup(cli.Stop)
+
+	return &outboundCall{
+		c:   cli,
+		log: log,
+		mon: mon.NewCall(stats.Outbound, "from", "to"),
+	}
+}
+
+func TestOutboundRedirect(t *testing.T) {
+	recipients := make(chan string, 1)
+	final := newTestUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		recipients <- req.Recipient.User
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+	second := newTestRedirect(t, "final", final)
+	first := newTestRedirect(t, "second", second)
+
+	call := newTestOutboundCall(t, &config.Config{})
+	req, resp, err := call.sipInvite(nil, sipOutboundConfig{
+		address: first.String(),
+		from:    "from",
+		to:      "first",
+	})
+	require.NoError(t, err)
+	require.Equal(t, sip.StatusCode(200), resp.StatusCode)
+	require.Equal(t, "final", req.Recipient.User)
+	require.Equal(t, final.Port, req.Recipient.Port)
+	require.Equal(t, "final", <-recipients)
+}
+
+func TestOutboundRedirectDisabled(t *testing.T) {
+	final := newTestUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+	first := newTestRedirect(t, "final", final)
+
+	maxRedirects := 0
+	call := newTestOutboundCall(t, &config.Config{MaxRedirects: &maxRedirects})
+	_, _, err := call.sipInvite(nil, sipOutboundConfig{
+		address: first.String(),
+		from:    "from",
+		to:      "first",
+	})
+	require.ErrorContains(t, err, "too many")
+}
+
+func TestOutboundRedirectLimit(t *testing.T) {
+	final := newTestUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+	second := newTestRedirect(t, "final", final)
+	first := newTestRedirect(t, "second", second)
+
+	maxRedirects := 1
+	call := newTestOutboundCall(t, &config.Config{MaxRedirects: &maxRedirects})
+	_, _, err := call.sipInvite(nil, sipOutboundConfig{
+		address: first.String(),
+		from:    "from",
+		to:      "first",
+	})
+	require.ErrorContains(t, err, "too many")
+}
+
+func TestOutboundRedirectLoop(t *testing.T) {
+	var first *net.UDPAddr
+	second := newTestUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		res := sip.NewResponseFromRequest(req, 302, "Moved Temporarily", nil)
+		res.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: "first", Host: first.IP.String(), Port: first.Port}})
+		_ = tx.Respond(res)
+	})
+	first = newTestRedirect(t, "second", second)
+
+	// The loop must be detected by the visited targets, even if the redirect limit is not reached yet.
+	maxRedirects := 1
+	call := newTestOutboundCall(t, &config.Config{MaxRedirects: &maxRedirects})
+	_, _, err := call.sipInvite(nil, sipOutboundConfig{
+		address: first.String(),
+		from:    "from",
+		to:      "first",
+	})
+	require.ErrorContains(t, err, "loop")
+}
+
+// newTestRetryUAS starts a test UAS which responds to INVITEs with given status codes, one per attempt.
+// Call-IDs of all attempts are sent to the returned channel.
+func newTestRetryUAS(t *testing.T, codes ...sip.StatusCode) (*net.UDPAddr, <-chan string) {
+	callIDs := make(chan string, 10)
+	var attempts atomic.Int32
+	uas := newTestUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		callID, _ := req.CallID()
+		callIDs <- callID.Value()
+		code := codes[len(codes)-1]
+		if n := int(attempts.Add(1)); n <= len(codes) {
+			code = codes[n-1]
+		}
+		_ = tx.Respond(sip.NewResponseFromRequest(req, code, "", nil))
+	})
+	return uas, callIDs
+}
+
+// receivedCallIDs returns Call-IDs of all attempts received by the test UAS so far.
+func receivedCallIDs(callIDs <-chan string) []string {
+	var out []string
+	for {
+		select {
+		case id := <-callIDs:
+			out = append(out, id)
+		default:
+			return out
+		}
+	}
+}
+
+func TestOutboundRetry(t *testing.T) {
+	uas, callIDs := newTestRetryUAS(t, 503, 503, 200)
+
+	retries := 2
+	call := newTestOutboundCall(t, &config.Config{
+		OutboundRetryCount:       &retries,
+		OutboundRetryBackoffBase: time.Millisecond,
+	})
+	_, resp, err := call.sipInvite(nil, sipOutboundConfig{
+		address: uas.String(),
+		from:    "from",
+		to:      "to",
+	})
+	require.NoError(t, err)
+	require.Equal(t, sip.StatusCode(200), resp.StatusCode)
+	ids := receivedCallIDs(callIDs)
+	require.Len(t, ids, 3)
+	require.NotEqual(t, ids[0], ids[1])
+	require.NotEqual(t, ids[1], ids[2])
+}
+
+func TestOutboundRetryDisabled(t *testing.T) {
+	uas, callIDs := newTestRetryUAS(t, 503, 200)
+
+	retries := 0
+	call := newTestOutboundCall(t, &config.Config{
+		OutboundRetryCount:       &retries,
+		OutboundRetryBackoffBase: time.Millisecond,
+	})
+	_, _, err := call.sipInvite(nil, sipOutboundConfig{
+		address: uas.String(),
+		from:    "from",
+		to:      "to",
+	})
+	require.Error(t, err)
+	require.Len(t, receivedCallIDs(callIDs), 1)
+}
+
+func TestOutboundNoRetry(t *testing.T) {
+	uas, callIDs := newTestRetryUAS(t, 404)
+
+	retries := 2
+	call := newTestOutboundCall(t, &config.Config{
+		OutboundRetryCount:       &retries,
+		OutboundRetryBackoffBase: time.Millisecond,
+	})
+	_, _, err := call.sipInvite(nil, sipOutboundConfig{
+		address: uas.String(),
+		from:    "from",
+		to:      "to",
+	})
+	require.Error(t, err)
+	require.Len(t, receivedCallIDs(callIDs), 1)
+}
+
+// testCounterValue returns the value of the counter with given labels.
+func testCounterValue(t *testing.T, name string, labels map[string]string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			match := 0
+			for _, l := range m.GetLabel() {
+				if v, ok := labels[l.GetName()]; ok && v == l.GetValue() {
+					match++
+				}
+			}
+			if match == len(labels) {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestOutboundTrunkFailover(t *testing.T) {
+	attempts := make(chan string, 10)
+	newServer := func(name string, status sip.StatusCode) *net.UDPAddr {
+		return newTestUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+			attempts <- name
+			_ = tx.Respond(sip.NewResponseFromRequest(req, status, "", nil))
+		})
+	}
+	primary := newServer("primary", 503)
+	secondary := newServer("secondary", 503)
+	backup := newServer("backup", 200)
+	unused := newServer("unused", 200)
+
+	retries := 0
+	call := newTestOutboundCall(t, &config.Config{
+		OutboundRetryCount: &retries,
+		OutboundTrunks: map[string]string{
+			"ST_primary":   primary.String(),
+			"ST_secondary": secondary.String(),
+			"ST_backup":    backup.String(),
+			"ST_unused":    unused.String(),
+		},
+		OutboundTrunkFailover: map[string][]config.TrunkRef{
+			"ST_primary": {
+				{ID: "ST_unused", Priority: 3},
+				{ID: "ST_backup", Priority: 2},
+				{ID: "ST_secondary", Priority: 1},
+			},
+		},
+	})
+	_, resp, err := call.sipInvite(nil, sipOutboundConfig{
+		trunkID: "ST_primary",
+		address: primary.String(),
+		from:    "from",
+		to:      "to",
+	})
+	require.NoError(t, err)
+	require.Equal(t, sip.StatusCode(200), resp.StatusCode)
+	// Handlers may still run, so the channel is drained without closing it.
+	var got []string
+drain:
+	for {
+		select {
+		case name := <-attempts:
+			got = append(got, name)
+		default:
+			break drain
+		}
+	}
+	require.Equal(t, []string{"primary", "secondary", "backup"}, got)
+	require.EqualValues(t, 1, testCounterValue(t, "livekit_sip_trunk_failover_total", map[string]string{"from_trunk": "ST_primary", "to_trunk": "ST_secondary"}))
+	require.EqualValues(t, 1, testCounterValue(t, "livekit_sip_trunk_failover_total", map[string]string{"from_trunk": "ST_secondary", "to_trunk": "ST_backup"}))
+}
+
+func TestOutboundInviteLatency(t *testing.T) {
+	uas := newTestUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+	call := newTestOutboundCall(t, &config.Config{})
+	const calls = 100
+	for i := 0; i < calls; i++ {
+		_, _, err := call.sipInvite(nil, sipOutboundConfig{
+			address: uas.String(),
+			from:    "from",
+			to:      "to",
+		})
+		require.NoError(t, err)
+	}
+	p99, n := testHistogramQuantile(t, "livekit_sip_invite_processing_duration_seconds", "outbound", 0.99)
+	require.EqualValues(t, calls, n)
+	require.LessOrEqual(t, p99, 0.25)
+}
+
+func TestOutboundForwarded(t *testing.T) {
+	call := newTestOutboundCall(t, &config.Config{})
+	call.lkRoom = NewRoom(logger.GetLogger())
+	data := setTestRoomConn(call.lkRoom)
+	conn := call.lkRoom.room.(*testRoomConn)
+	forwarded := make(chan lksdk.DataPacket, 1)
+	forwardedAttr := make(chan string, 1)
+	uas := newTestUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		res := sip.NewResponseFromRequest(req, 181, "Call Is Being Forwarded", nil)
+		res.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: "+15550000", Host: "forward.example.com"}})
+		_ = tx.Respond(res)
+		// The target must be known before the call is answered.
+		select {
+		case p := <-data:
+			forwarded <- p
+			forwardedAttr <- conn.Attributes()[AttrForwardedTo]
+		case <-time.After(time.Second):
+		}
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+
+	_, resp, err := call.sipInvite(nil, sipOutboundConfig{
+		address: uas.String(),
+		from:    "from",
+		to:      "to",
+	})
+	require.NoError(t, err)
+	require.Equal(t, sip.StatusCode(200), resp.StatusCode)
+	select {
+	case p := <-forwarded:
+		require.Equal(t, &lksdk.UserDataPacket{
+			Payload: []byte("sip:+15550000@forward.example.com"),
+			Topic:   ForwardedToTopic,
+		}, p)
+		require.Equal(t, "sip:+15550000@forward.example.com", <-forwardedAttr)
+	default:
+		t.Fatal("forwarding target was not sent before the answer")
+	}
+}
+
+func TestOutboundFromDisplayName(t *testing.T) {
+	names := make(chan string, 1)
+	uas := newTestUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		from, _ := req.From()
+		names <- from.DisplayName
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+
+	call := newTestOutboundCall(t, &config.Config{})
+	_, _, err := call.sipInvite(nil, sipOutboundConfig{
+		address:  uas.String(),
+		from:     "+15550100",
+		fromName: fromDisplayName(config.OutboundFromConfig{FromDisplayNameTemplate: "{name} ({number})"}, "Alice", "+15550100", "sales"),
+		to:       "bob",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Alice (+15550100)", <-names)
+
+	_, _, err = call.sipInvite(nil, sipOutboundConfig{
+		address: uas.String(),
+		from:    "+15550100",
+		to:      "bob",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "+15550100", <-names)
+}
+
+func TestFromDisplayName(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		conf     config.OutboundFromConfig
+		partName string
+		exp      string
+	}{
+		{name: "none", partName: "Alice"},
+		{name: "fixed", conf: config.OutboundFromConfig{FromDisplayName: "Support"}, partName: "Alice", exp: "Support"},
+		{name: "template", conf: config.OutboundFromConfig{FromDisplayName: "Support", FromDisplayNameTemplate: "{name} from {room}"}, partName: "Alice", exp: "Alice from sales"},
+		{name: "missing", conf: config.OutboundFromConfig{FromDisplayNameTemplate: "{name} {number}"}, exp: "+15550100"},
+		{name: "empty", conf: config.OutboundFromConfig{FromDisplayName: "Support", FromDisplayNameTemplate: "{name}"}, exp: "Support"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.exp, fromDisplayName(c.conf, c.partName, "+15550100", "sales"))
+		})
+	}
+}
+
+func md5Hex(s string) string {
+	h := md5.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func TestDigestAuthInt(t *testing.T) {
+	// RFC 2617, section 3.5.
+	cred, err := digest.Digest(&digest.Challenge{
+		Realm: "testrealm@host.com",
+		Nonce: "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+		QOP:   []string{"auth"},
+	}, digest.Options{
+		Method:   "GET",
+		URI:      "/dir/index.html",
+		Username: "Mufasa",
+		Password: "Circle Of Life",
+		Cnonce:   "0a4f113b",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "6629fae49393a05397450978507c4ef1", cred.Response)
+
+	// Same exchange with auth-int, where H(entity-body) is a part of A2.
+	body := []byte("v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=test\r\n")
+	cred, err = digest.Digest(&digest.Challenge{
+		Realm: "testrealm@host.com",
+		Nonce: "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+		QOP:   []string{"auth-int"},
+	}, digest.Options{
+		Method:   "INVITE",
+		URI:      "sip:bob@host.com",
+		GetBody:  digestBody(body),
+		Username: "Mufasa",
+		Password: "Circle Of Life",
+		Cnonce:   "0a4f113b",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "auth-int", cred.QOP)
+	// Precomputed with H(entity-body) = "2cce5b00026f83d97ff7a34a86a29639".
+	require.Equal(t, "40e8228f60d2b32442432ead1410902f", cred.Response)
+}
+
+func TestOutboundAuthInt(t *testing.T) {
+	const (
+		realm = "sip.example.com"
+		nonce = "5f7c2a"
+	)
+	authorized := make(chan *digest.Credentials, 1)
+	uas := newTestUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		h := req.GetHeader("Proxy-Authorization")
+		if h == nil {
+			res := sip.NewResponseFromRequest(req, 407, "Proxy Authentication Required", nil)
+			res.AppendHeader(sip.NewHeader("Proxy-Authenticate", fmt.Sprintf(`Digest realm=%q, nonce=%q, qop="auth-int"`, realm, nonce)))
+			_ = tx.Respond(res)
+			return
+		}
+		cred, err := digest.ParseCredentials(h.Value())
+		if err != nil {
+			_ = tx.Respond(sip.NewResponseFromRequest(req, 400, "Bad Request", nil))
+			return
+		}
+		ha1 := md5Hex("user:" + realm + ":pass")
+		ha2 := md5Hex("INVITE:" + cred.URI + ":" + md5Hex(string(req.Body())))
+		exp := md5Hex(fmt.Sprintf("%s:%s:%08x:%s:%s:%s", ha1, nonce, cred.Nc, cred.Cnonce, cred.QOP, ha2))
+		if cred.QOP != "auth-int" || cred.Response != exp {
+			_ = tx.Respond(sip.NewResponseFromRequest(req, 403, "Forbidden", nil))
+			return
+		}
+		authorized <- cred
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+
+	call := newTestOutboundCall(t, &config.Config{})
+	offer, err := sdpGenerateOffer(call.c.signalingIp, 40000)
+	require.NoError(t, err)
+	_, resp, err := call.sipInvite(offer, sipOutboundConfig{
+		address: uas.String(),
+		from:    "from",
+		to:      "to",
+		user:    "user",
+		pass:    "pass",
+	})
+	require.NoError(t, err)
+	require.Equal(t, sip.StatusCode(200), resp.StatusCode)
+	select {
+	case cred := <-authorized:
+		require.Equal(t, "user", cred.Username)
+	default:
+		t.Fatal("request was not authorized")
+	}
+}
+
+// checkTestDigest verifies digest credentials in a request header against a given password.
+func checkTestDigest(req *sip.Request, header, realm, nonce, pass string) *digest.Credentials {
+	h := req.GetHeader(header)
+	if h == nil {
+		return nil
+	}
+	cred, err := digest.ParseCredentials(h.Value())
+	if err != nil {
+		return nil
+	}
+	exp, err := digest.Digest(&digest.Challenge{Realm: realm, Nonce: nonce}, digest.Options{
+		Method:   req.Method.String(),
+		URI:      cred.URI,
+		Username: cred.Username,
+		Password: pass,
+	})
+	if err != nil || exp.Response != cred.Response {
+		return nil
+	}
+	return cred
+}
+
+func TestOutboundProxyAuth(t *testing.T) {
+	const (
+		realm = "proxy.example.com"
+		nonce = "a1b2c3"
+	)
+	statuses := make(chan sip.StatusCode, 10)
+	uas := newTestUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		respond := func(res *sip.Response) {
+			statuses <- res.StatusCode
+			_ = tx.Respond(res)
+		}
+		// Proxy checks its own credentials first, then the endpoint checks trunk credentials.
+		if cred := checkTestDigest(req, "Proxy-Authorization", realm, nonce, "proxy-pass"); cred == nil || cred.Username != "proxy-user" {
+			res := sip.NewResponseFromRequest(req, 407, "Proxy Authentication Required", nil)
+			res.AppendHeader(sip.NewHeader("Proxy-Authenticate", fmt.Sprintf(`Digest realm=%q, nonce=%q`, realm, nonce)))
+			respond(res)
+			return
+		}
+		if cred := checkTestDigest(req, "Authorization", "sip.example.com", nonce, "pass"); cred == nil || cred.Username != "user" {
+			res := sip.NewResponseFromRequest(req, 401, "Unauthorized", nil)
+			res.AppendHeader(sip.NewHeader("WWW-Authenticate", fmt.Sprintf(`Digest realm="sip.example.com", nonce=%q`, nonce)))
+			respond(res)
+			return
+		}
+		respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+	conf := sipOutboundConfig{
+		address: uas.String(),
+		from:    "from",
+		to:      "to",
+		user:    "user",
+		pass:    "pass",
+	}
+
+	call := newTestOutboundCall(t, &config.Config{
+		ProxyAuth: map[string]config.ProxyAuthConfig{
+			conf.address: {ProxyAuthUser: "proxy-user", ProxyAuthPassword: "proxy-pass"},
+		},
+	})
+	_, resp, err := call.sipInvite(nil, conf)
+	require.NoError(t, err)
+	require.Equal(t, sip.StatusCode(200), resp.StatusCode)
+	var got []sip.StatusCode
+	for len(statuses) > 0 {
+		got = append(got, <-statuses)
+	}
+	require.Equal(t, []sip.StatusCode{407, 401, 200}, got)
+}
+
+func TestOutboundProxyAuthFailed(t *testing.T) {
+	var attempts atomic.Int32
+	uas := newTestUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		attempts.Add(1)
+		res := sip.NewResponseFromRequest(req, 407, "Proxy Authentication Required", nil)
+		res.AppendHeader(sip.NewHeader("Proxy-Authenticate", `Digest realm="proxy.example.com", nonce="a1b2c3"`))
+		_ = tx.Respond(res)
+	})
+
+	call := newTestOutboundCall(t, &config.Config{})
+	_, _, err := call.sipInvite(nil, sipOutboundConfig{
+		address: uas.String(),
+		from:    "from",
+		to:      "to",
+		user:    "user",
+		pass:    "wrong",
+	})
+	require.ErrorContains(t, err, "proxy authentication failed")
+	require.EqualValues(t, 2, attempts.Load())
+}
+
+func TestOutboundReInviteProxyAuth(t *testing.T) {
+	const (
+		realm = "proxy.example.com"
+		nonce = "exp1red"
+	)
+	var rejectAll atomic.Bool
+	reinvites := make(chan *sip.Request, 10)
+	// Address of the UAS is only known once it's started, and the handler runs in a different goroutine.
+	var proxy atomic.Pointer[net.UDPAddr]
+	uas := newTestUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		if to, _ := req.To(); headerTag(to.Params) == "" {
+			// Initial INVITE is accepted without authentication, through a proxy.
+			res := sip.NewResponseFromRequest(req, 200, "OK", nil)
+			if to, ok := res.To(); ok {
+				to.Params.Add("tag", "callee-tag")
+			}
+			addr := proxy.Load()
+			res.AppendHeader(&sip.RecordRouteHeader{Address: sip.Uri{Host: addr.IP.String(), Port: addr.Port, UriParams: sip.HeaderParams{"lr": ""}}})
+			_ = tx.Respond(res)
+			return
+		}
+		reinvites <- req
+		// Authentication of the proxy has expired mid-dialog.
+		if cred := checkTestDigest(req, "Proxy-Authorization", realm, nonce, "pass"); rejectAll.Load() || cred == nil || cred.URI != req.Recipient.String() {
+			res := sip.NewResponseFromRequest(req, 407, "Proxy Authentication Required", nil)
+			res.AppendHeader(sip.NewHeader("Proxy-Authenticate", fmt.Sprintf(`Digest realm=%q, nonce=%q`, realm, nonce)))
+			_ = tx.Respond(res)
+			return
+		}
+		body := []byte("v=0\r\no=- 1 2 IN IP4 127.0.0.2\r\ns=-\r\nc=IN IP4 127.0.0.2\r\nt=0 0\r\nm=audio 4000 RTP/AVP 0\r\n")
+		res := sip.NewResponseFromRequest(req, 200, "OK", body)
+		res.AppendHeader(&contentTypeHeaderSDP)
+		_ = tx.Respond(res)
+	})
+	proxy.Store(uas)
+	conf := sipOutboundConfig{address: uas.String(), from: "from", to: "to", user: "user", pass: "pass"}
+	call := newTestOutboundCall(t, &config.Config{})
+	req, resp, err := call.sipInvite(nil, conf)
+	require.NoError(t, err)
+	call.sipCur = conf
+	call.sipInviteReq, call.sipInviteResp = req, resp
+	call.rtpConn = rtp.NewConn(nil)
+
+	require.NoError(t, call.sipRefreshSession())
+	require.Len(t, reinvites, 2)
+	first, second := <-reinvites, <-reinvites
+	require.Nil(t, first.GetHeader("Proxy-Authorization"))
+	require.NotNil(t, second.GetHeader("Proxy-Authorization"))
+
+	// Challenged request is sent again in the same dialog, with the next CSeq and the same route set.
+	cseq1, _ := first.CSeq()
+	cseq2, _ := second.CSeq()
+	require.Equal(t, cseq1.SeqNo+1, cseq2.SeqNo)
+	callID1, _ := first.CallID()
+	callID2, _ := second.CallID()
+	require.Equal(t, callID1.Value(), callID2.Value())
+	require.NotNil(t, first.GetHeader("Route"))
+	require.Equal(t, first.GetHeader("Route").Value(), second.GetHeader("Route").Value())
+	via1, _ := first.Via()
+	via2, _ := second.Via()
+	b1, _ := via1.Params.Get("branch")
+	b2, _ := via2.Params.Get("branch")
+	require.NotEqual(t, b1, b2)
+
+	// The call continues with the media address from the answer.
+	require.Equal(t, "127.0.0.2:4000", call.rtpConn.DestAddr().String())
+
+	// Challenge is only answered once.
+	rejectAll.Store(true)
+	require.ErrorContains(t, call.sipRefreshSession(), "407")
+	require.Len(t, reinvites, 2)
+}
+
+func TestRedirectTarget(t *testing.T) {
+	require.Equal(t, redirectTarget(sipOutboundConfig{to: "bob", address: "sip.example.com"}),
+		redirectTarget(sipOutboundConfig{to: "bob", address: "SIP.example.com:5060"}))
+	require.NotEqual(t, redirectTarget(sipOutboundConfig{to: "bob", address: "sip.example.com"}),
+		redirectTarget(sipOutboundConfig{to: "bob", address: "sip.example.com:5080"}))
+	require.Equal(t, "bob@[::1]:5060", redirectTarget(sipOutboundConfig{to: "bob", address: "[::1]"}))
+}
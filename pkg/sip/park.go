@@ -0,0 +1,146 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emiago/sipgo/parser"
+	"github.com/emiago/sipgo/sip"
+
+	"github.com/livekit/sip/pkg/sip/parking"
+)
+
+// parkingSlot returns the slot addressed by the retrieval INVITE, if parking is enabled.
+func (s *Server) parkingSlot(toUser string) (int, bool) {
+	if !s.conf.ParkingEnabled {
+		return 0, false
+	}
+	return parking.ParseSlotUser(toUser)
+}
+
+func (s *Server) onRefer(req *sip.Request, tx sip.ServerTransaction) {
+	tag, err := getTagValue(req)
+	if err != nil {
+		sipErrorResponse(tx, req)
+		return
+	}
+	s.cmu.RLock()
+	c := s.activeCalls[tag]
+	s.cmu.RUnlock()
+	if c == nil {
+		if s.sipUnhandled != nil {
+			s.sipUnhandled(req, tx)
+			return
+		}
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 481, "Call/Transaction Does Not Exist", nil))
+		return
+	}
+	c.handleRefer(req, tx)
+}
+
+// handleRefer handles REFER requests sent by the caller. Only transfers to the parking service are supported.
+func (c *inboundCall) handleRefer(req *sip.Request, tx sip.ServerTransaction) {
+	h := req.GetHeader("Refer-To")
+	if h == nil {
+		sipErrorResponse(tx, req)
+		return
+	}
+	var target sip.Uri
+	if _, err := parser.ParseAddressValue(h.Value(), &target, sip.NewParams()); err != nil {
+		sipErrorResponse(tx, req)
+		return
+	}
+	if !c.s.conf.ParkingEnabled || !parking.IsParkUser(target.User) {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 501, "Not Implemented", nil))
+		return
+	}
+	c.dialogMu.Lock()
+	answered := c.inviteResp != nil
+	c.dialogMu.Unlock()
+	if !answered {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 481, "Call/Transaction Does Not Exist", nil))
+		return
+	}
+	// The room is parked, not the caller: it keeps waiting after the caller hangs up.
+	// Dialog is registered once the call joins the room, which also makes the call logger safe to use.
+	d, ok := c.s.dialogs.Lookup(c.sipCallID, c.tag)
+	if !ok {
+		// Call was not bridged yet, e.g. it was waiting for a pin.
+		c.s.log.Infow("Cannot park call, not in a room", "call-id", c.id, "sip-tag", c.tag)
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 403, "Forbidden", nil))
+		return
+	}
+	// Referred-By identifies the party which parked the call (RFC 3892).
+	var referredBy string
+	if h := req.GetHeader("Referred-By"); h != nil {
+		referredBy = h.Value()
+	}
+	slot, err := c.s.park.Park(c.id, d.RoomName, c.to.Address.User)
+	if err != nil {
+		c.log.Warnw("Cannot park call", err, "referred-by", referredBy)
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 503, "Service Unavailable", nil))
+		return
+	}
+	c.log.Infow("Parking call", "slot", slot.ID, "referred-by", referredBy)
+	_ = tx.Respond(sip.NewResponseFromRequest(req, 202, "Accepted", nil))
+	go c.parkCall(slot)
+}
+
+// parkCall announces the slot number to the caller, and reports that the call is parked.
+// The caller is expected to hang up after that, while the slot is kept until the call is retrieved.
+func (c *inboundCall) parkCall(slot *parking.Slot) {
+	c.playAudio(c.ctx, parking.Announcement(slot.ID, c.s.res.parkPrompt, &c.s.res.parkDigits))
+	c.notifyRefer(200, "OK")
+}
+
+// notifyRefer reports the final status of the REFER request received from the caller (RFC 3515).
+func (c *inboundCall) notifyRefer(status int, reason string) {
+	req, err := c.sipDialogRequest(sip.NOTIFY)
+	if err != nil {
+		return
+	}
+	req.AppendHeader(sip.NewHeader("Event", "refer"))
+	req.AppendHeader(sip.NewHeader("Subscription-State", "terminated;reason=noresource"))
+	req.AppendHeader(sip.NewHeader("Content-Type", "message/sipfrag;version=2.0"))
+	req.SetBody([]byte(fmt.Sprintf("SIP/2.0 %d %s\r\n", status, reason)))
+	tx, err := c.s.sipCli.TransactionRequest(req)
+	if err != nil {
+		c.log.Warnw("Cannot send REFER NOTIFY", err)
+		return
+	}
+	go func() {
+		defer tx.Terminate()
+		select {
+		case <-tx.Responses():
+		case <-tx.Done():
+		case <-time.After(notifyTimeout):
+		}
+	}()
+}
+
+// retrieveDispatch bridges the call to the room of a parked call.
+func (c *inboundCall) retrieveDispatch(slot *parking.Slot) CallDispatch {
+	c.log = c.log.WithValues("slot", slot.ID)
+	c.log.Infow("Retrieving parked call", "parked-call-id", slot.CallID, "parkRoom", slot.RoomName)
+	user := c.from.Address.User
+	return CallDispatch{
+		Result:   DispatchAccept,
+		RoomName: slot.RoomName,
+		Identity: "sip_" + user,
+		Name:     "Phone " + user,
+	}
+}
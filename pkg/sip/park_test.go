@@ -0,0 +1,162 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+func newTestRefer(addr, tag, target string) *sip.Request {
+	req := newTestInfo(addr, tag, "", "")
+	req.Method = sip.REFER
+	req.RemoveHeader("Content-Type")
+	if target != "" {
+		req.AppendHeader(sip.NewHeader("Refer-To", target))
+	}
+	return req
+}
+
+func TestService_ReferPark(t *testing.T) {
+	refer := func(t *testing.T, parking bool, target string) sip.StatusCode {
+		s, addr := startTestService(t, &config.Config{ParkingEnabled: parking})
+		call := addTestCall(s, "alice", "alice-tag")
+		res := sendTestRequest(t, addr, "alice", newTestRefer(addr, call.tag, target))
+		_, parked := s.srv.park.Lookup(1)
+		require.False(t, parked)
+		return res.StatusCode
+	}
+	require.Equal(t, sip.StatusCode(501), refer(t, false, "<sip:park@example.com>"))
+	require.Equal(t, sip.StatusCode(501), refer(t, true, "<sip:bob@example.com>"))
+	require.Equal(t, sip.StatusCode(400), refer(t, true, ""))
+	// Call is not answered yet.
+	require.Equal(t, sip.StatusCode(481), refer(t, true, "<sip:park@example.com>"))
+}
+
+// newTestPhoneRequest creates a request within the dialog of the call placed by the test phone.
+func newTestPhoneRequest(method sip.RequestMethod, addr string, invite *sip.Request, res *sip.Response) *sip.Request {
+	req := sip.NewRequest(method, &sip.Uri{User: invite.Recipient.User, Host: addr})
+	from, _ := invite.From()
+	req.AppendHeader(sip.HeaderClone(from))
+	to, _ := res.To()
+	req.AppendHeader(sip.HeaderClone(to))
+	callID, _ := invite.CallID()
+	req.AppendHeader(sip.HeaderClone(callID))
+	return req
+}
+
+func TestService_ParkHangup(t *testing.T) {
+	joined := make(chan *testRoomConn, 2)
+	s, addr := startTestService(t, &config.Config{ParkingEnabled: true}, func(s *Service) {
+		s.srv.connectRoom = newTestRoomConnector(joined)
+		s.SetHandler(acceptHandler("room", ""))
+	})
+	waitJoined := func(t *testing.T) *testRoomConn {
+		select {
+		case c := <-joined:
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatal("call did not join the room")
+			return nil
+		}
+	}
+
+	alice := newTestPhone(t, "alice")
+	req, res := alice.Call(t, addr, "+100", nil)
+	waitJoined(t)
+
+	refer := newTestPhoneRequest(sip.REFER, addr, req, res)
+	refer.AppendHeader(sip.NewHeader("Refer-To", "<sip:park@example.com>"))
+	require.Equal(t, sip.StatusCode(202), sendTestRequest(t, addr, "alice", refer).StatusCode)
+	select {
+	case notify := <-alice.notify:
+		require.Equal(t, "SIP/2.0 200 OK\r\n", string(notify.Body()))
+	case <-time.After(5 * time.Second):
+		t.Fatal("call was not parked")
+	}
+
+	// The caller hangs up after the call is parked, while the room keeps waiting in the slot.
+	require.Equal(t, sip.StatusCode(200), sendTestRequest(t, addr, "alice", newTestPhoneRequest(sip.BYE, addr, req, res)).StatusCode)
+	require.Eventually(t, func() bool {
+		s.srv.cmu.RLock()
+		defer s.srv.cmu.RUnlock()
+		return len(s.srv.activeCalls) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	slot, ok := s.srv.park.Lookup(1)
+	require.True(t, ok)
+	require.Equal(t, "room", slot.RoomName)
+
+	bob := newTestPhone(t, "bob")
+	bob.Call(t, addr, "slot-1", nil)
+	require.Equal(t, "room", waitJoined(t).rc.roomName)
+	_, ok = s.srv.park.Lookup(1)
+	require.False(t, ok)
+}
+
+func TestService_RetrieveParked(t *testing.T) {
+	const parkedNumber = "+100"
+	toUsers := make(chan string, 2)
+	h := &TestHandler{
+		GetAuthCredentialsFunc: func(ctx context.Context, fromUser, toUser, toHost, srcAddress string) (username, password string, drop bool, err error) {
+			toUsers <- toUser
+			return "", "", false, nil
+		},
+		DispatchCallFunc: func(ctx context.Context, info *CallInfo) CallDispatch {
+			t.Error("unexpected dispatch")
+			return CallDispatch{Result: DispatchNoRuleReject}
+		},
+	}
+	var srv *Server
+	opts := testInviteOptions{
+		Setup: func(s *Service) {
+			srv = s.srv
+			s.conf.ParkingEnabled = true
+			_, err := s.srv.park.Park("SCL_parked", "room", parkedNumber)
+			require.NoError(t, err)
+		},
+	}
+	testInviteWith(t, h, opts, "foo", "slot-1", func(tx sip.ClientTransaction) {
+		if !inboundHidePort {
+			res := getResponseOrFail(t, tx)
+			require.Equal(t, sip.StatusCode(180), res.StatusCode)
+		}
+		res := getResponseOrFail(t, tx)
+		require.Equal(t, sip.StatusCode(200), res.StatusCode)
+		// Retrieval is authorized against the number of the parked call.
+		require.Equal(t, parkedNumber, <-toUsers)
+		_, ok := srv.park.Lookup(1)
+		require.False(t, ok)
+	})
+
+	// Empty slot.
+	opts.Setup = func(s *Service) {
+		s.conf.ParkingEnabled = true
+	}
+	testInviteWith(t, h, opts, "foo", "slot-1", func(tx sip.ClientTransaction) {
+		if !inboundHidePort {
+			res := getResponseOrFail(t, tx)
+			require.Equal(t, sip.StatusCode(180), res.StatusCode)
+		}
+		res := getResponseOrFail(t, tx)
+		require.Equal(t, sip.StatusCode(404), res.StatusCode)
+		require.Empty(t, toUsers)
+	})
+}
@@ -0,0 +1,175 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package parking implements call parking. The SIP participant parks the other side of the call: its LiveKit room
+// keeps waiting after the participant hangs up, and is assigned a numeric slot. A call is retrieved by dialing its slot.
+//
+// Slots are kept in memory, thus parking only works when the retrieval INVITE reaches the same SIP node.
+package parking
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/dtmf"
+	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/media/tones"
+)
+
+const (
+	// ParkUser is the user part of the Refer-To URI which parks the call, e.g. sip:park@host.
+	ParkUser = "park"
+
+	// SlotTimeout is how long the call stays parked if it's not retrieved.
+	SlotTimeout = 30 * time.Minute
+
+	slotPrefix = "slot-"
+)
+
+var ErrNoSlots = errors.New("no free parking slots")
+
+// IsParkUser checks if the user part of the URI addresses the parking service.
+func IsParkUser(user string) bool {
+	return strings.EqualFold(user, ParkUser)
+}
+
+// SlotUser returns the user part of the URI used to retrieve the call from a given slot, e.g. "slot-1".
+func SlotUser(id int) string {
+	return slotPrefix + strconv.Itoa(id)
+}
+
+// ParseSlotUser parses the slot ID from the user part of the retrieval URI.
+func ParseSlotUser(user string) (int, bool) {
+	s, ok := strings.CutPrefix(strings.ToLower(user), slotPrefix)
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.Atoi(s)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// Slot describes a parked call.
+type Slot struct {
+	ID       int
+	CallID   string // LiveKit call ID of the SIP participant which parked the call
+	RoomName string // room the parked call waits in
+	ToUser   string // number the parked call was placed to; retrieval is authorized against it
+	ParkedAt time.Time
+}
+
+// Lot tracks parked calls. Slots are numbered from 1, and the lowest free slot is always used.
+// Slots which are not retrieved within SlotTimeout are freed.
+type Lot struct {
+	mu    sync.Mutex
+	max   int
+	slots map[int]*Slot
+}
+
+func NewLot(maxSlots int) *Lot {
+	if maxSlots <= 0 {
+		maxSlots = config.DefaultParkingMaxSlots
+	}
+	return &Lot{max: maxSlots, slots: make(map[int]*Slot)}
+}
+
+// Park assigns a slot to the call waiting in a given room. If the call is already parked, its current slot is returned.
+func (l *Lot) Park(callID, roomName, toUser string) (*Slot, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expire(time.Now())
+	for _, s := range l.slots {
+		if s.CallID == callID {
+			return s, nil
+		}
+	}
+	for id := 1; id <= l.max; id++ {
+		if _, ok := l.slots[id]; ok {
+			continue
+		}
+		s := &Slot{ID: id, CallID: callID, RoomName: roomName, ToUser: toUser, ParkedAt: time.Now()}
+		l.slots[id] = s
+		return s, nil
+	}
+	return nil, ErrNoSlots
+}
+
+// Lookup returns the call parked in a given slot, without retrieving it.
+func (l *Lot) Lookup(id int) (*Slot, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expire(time.Now())
+	s, ok := l.slots[id]
+	return s, ok
+}
+
+// Retrieve returns the call parked in a given slot and frees the slot. Each call can only be retrieved once.
+func (l *Lot) Retrieve(id int) (*Slot, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expire(time.Now())
+	s, ok := l.slots[id]
+	if ok {
+		delete(l.slots, id)
+	}
+	return s, ok
+}
+
+// expire frees slots parked for longer than SlotTimeout. Must be called with the lock held.
+func (l *Lot) expire(now time.Time) {
+	for id, s := range l.slots {
+		if now.Sub(s.ParkedAt) > SlotTimeout {
+			delete(l.slots, id)
+		}
+	}
+}
+
+const (
+	digitDur   = 200 * time.Millisecond
+	digitPause = 300 * time.Millisecond
+	digitAmp   = 8000
+)
+
+// Announcement returns audio announcing the slot number. The prompt (if any) is played first, followed by each digit.
+// Digits without a recording are played as DTMF tones.
+func Announcement(id int, prompt []media.PCM16Sample, digits *[10][]media.PCM16Sample) []media.PCM16Sample {
+	frames := append([]media.PCM16Sample{}, prompt...)
+	for _, d := range []byte(strconv.Itoa(id)) {
+		if digits != nil && len(digits[d-'0']) != 0 {
+			frames = append(frames, digits[d-'0']...)
+		} else {
+			_, freq := dtmf.Tone(d)
+			frames = appendTone(frames, freq, digitDur)
+		}
+		frames = appendTone(frames, nil, digitPause)
+	}
+	return frames
+}
+
+func appendTone(frames []media.PCM16Sample, freq []tones.Hz, dur time.Duration) []media.PCM16Sample {
+	var ts time.Duration
+	for ; ts < dur; ts += rtp.DefFrameDur {
+		buf := make(media.PCM16Sample, rtp.DefPacketDur)
+		tones.Generate(buf, ts, rtp.DefFrameDur, digitAmp, freq)
+		frames = append(frames, buf)
+	}
+	return frames
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parking
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/dtmf"
+	"github.com/livekit/sip/pkg/media/rtp"
+)
+
+func TestParseSlotUser(t *testing.T) {
+	require.True(t, IsParkUser("park"))
+	require.True(t, IsParkUser("Park"))
+	require.False(t, IsParkUser("parking"))
+
+	require.Equal(t, "slot-12", SlotUser(12))
+	for _, c := range []struct {
+		user string
+		id   int
+		ok   bool
+	}{
+		{"slot-1", 1, true},
+		{"SLOT-42", 42, true},
+		{"slot-0", 0, false},
+		{"slot--1", 0, false},
+		{"slot-", 0, false},
+		{"slot-a", 0, false},
+		{"park", 0, false},
+		{"+111111111", 0, false},
+	} {
+		id, ok := ParseSlotUser(c.user)
+		require.Equal(t, c.ok, ok, c.user)
+		require.Equal(t, c.id, id, c.user)
+	}
+}
+
+func TestLot(t *testing.T) {
+	l := NewLot(2)
+	a, err := l.Park("call-a", "room-a", "+100")
+	require.NoError(t, err)
+	require.Equal(t, 1, a.ID)
+	require.Equal(t, "room-a", a.RoomName)
+	require.Equal(t, "+100", a.ToUser)
+
+	// Parking the same call again keeps the slot.
+	again, err := l.Park("call-a", "room-a", "+100")
+	require.NoError(t, err)
+	require.Same(t, a, again)
+
+	b, err := l.Park("call-b", "room-b", "+200")
+	require.NoError(t, err)
+	require.Equal(t, 2, b.ID)
+	_, err = l.Park("call-c", "room-c", "+300")
+	require.ErrorIs(t, err, ErrNoSlots)
+
+	got, ok := l.Lookup(1)
+	require.True(t, ok)
+	require.Same(t, a, got)
+
+	// Calls are retrieved only once.
+	got, ok = l.Retrieve(1)
+	require.True(t, ok)
+	require.Same(t, a, got)
+	_, ok = l.Retrieve(1)
+	require.False(t, ok)
+
+	// Lowest free slot is reused.
+	c, err := l.Park("call-c", "room-c", "+300")
+	require.NoError(t, err)
+	require.Equal(t, 1, c.ID)
+
+	// Calls which are not retrieved in time are dropped.
+	b.ParkedAt = b.ParkedAt.Add(-SlotTimeout - time.Second)
+	_, ok = l.Lookup(2)
+	require.False(t, ok)
+	_, ok = l.Lookup(1)
+	require.True(t, ok)
+}
+
+func TestAnnouncement(t *testing.T) {
+	detect := func(frames []media.PCM16Sample) string {
+		var digits []byte
+		d := dtmf.NewDetector(rtp.DefSampleRate, func(ev dtmf.Event) {
+			digits = append(digits, ev.Digit)
+		})
+		for _, f := range frames {
+			require.NoError(t, d.WriteSample(f))
+		}
+		return string(digits)
+	}
+	require.Equal(t, "7", detect(Announcement(7, nil, nil)))
+	require.Equal(t, "105", detect(Announcement(105, nil, nil)))
+
+	// Recordings are used when available, and tones otherwise.
+	prompt := []media.PCM16Sample{make(media.PCM16Sample, rtp.DefPacketDur)}
+	var digits [10][]media.PCM16Sample
+	digits[1] = []media.PCM16Sample{make(media.PCM16Sample, rtp.DefPacketDur)}
+	frames := Announcement(12, prompt, &digits)
+	require.Same(t, &prompt[0][0], &frames[0][0])
+	require.Same(t, &digits[1][0][0], &frames[1][0])
+	require.Equal(t, "2", detect(frames))
+}
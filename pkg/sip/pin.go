@@ -0,0 +1,94 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media/dtmf"
+)
+
+var (
+	errPINTimeout = errors.New("pin entry timed out")
+	errPINTooLong = errors.New("pin is too long")
+	errPINClosed  = errors.New("dtmf input closed")
+)
+
+// pinConfig controls how PIN digits are collected.
+type pinConfig struct {
+	digits      int           // number of digits; terminated by # if zero
+	timeout     time.Duration // timeout for the next digit; no timeout if zero
+	maxAttempts int           // number of prompts before failing on timeout
+	promptFile  string        // custom prompt audio; the default prompt is used if empty
+}
+
+func newPINConfig(disp CallDispatch) pinConfig {
+	return pinConfig{
+		digits:      min(disp.PinDigits, config.MaxPINDigits),
+		timeout:     disp.PinTimeout,
+		maxAttempts: max(disp.PinMaxAttempts, 1),
+		promptFile:  disp.PinPromptFile,
+	}
+}
+
+// collectPIN buffers DTMF digits until the expected number of digits is entered, or # is pressed.
+// If the timeout passes without a digit, the digits are discarded and retry is called to prompt again,
+// until the attempts run out. An empty PIN is returned if # is pressed first.
+func collectPIN(ctx context.Context, events <-chan dtmf.Event, conf pinConfig, retry func()) (string, error) {
+	pin := ""
+	attempt := 1
+	var timeout <-chan time.Time
+	resetTimer := func() {
+		if conf.timeout > 0 {
+			timeout = time.After(conf.timeout)
+		}
+	}
+	resetTimer()
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-timeout:
+			if attempt >= conf.maxAttempts {
+				return "", errPINTimeout
+			}
+			attempt++
+			pin = ""
+			retry()
+			resetTimer()
+		case b, ok := <-events:
+			if !ok {
+				return "", errPINClosed
+			}
+			if b.Digit == 0 {
+				continue // unrecognized
+			}
+			if b.Digit == '#' {
+				return pin, nil
+			}
+			pin += string(b.Digit)
+			if conf.digits > 0 && len(pin) >= conf.digits {
+				return pin, nil
+			}
+			if len(pin) > config.MaxPINDigits {
+				return "", errPINTooLong
+			}
+			resetTimer()
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/media/dtmf"
+)
+
+func pinEvents(digits string) chan dtmf.Event {
+	ch := make(chan dtmf.Event, len(digits))
+	for _, d := range []byte(digits) {
+		ch <- dtmf.Event{Digit: d}
+	}
+	return ch
+}
+
+func TestCollectPIN(t *testing.T) {
+	ctx := context.Background()
+	noRetry := func() { t.Fatal("unexpected retry") }
+
+	t.Run("hash", func(t *testing.T) {
+		pin, err := collectPIN(ctx, pinEvents("1234#5"), pinConfig{maxAttempts: 1}, noRetry)
+		require.NoError(t, err)
+		require.Equal(t, "1234", pin)
+	})
+	t.Run("no pin", func(t *testing.T) {
+		pin, err := collectPIN(ctx, pinEvents("#"), pinConfig{maxAttempts: 1}, noRetry)
+		require.NoError(t, err)
+		require.Equal(t, "", pin)
+	})
+	t.Run("digits", func(t *testing.T) {
+		pin, err := collectPIN(ctx, pinEvents("123456"), pinConfig{digits: 4, maxAttempts: 1}, noRetry)
+		require.NoError(t, err)
+		require.Equal(t, "1234", pin)
+	})
+	t.Run("hash before digits", func(t *testing.T) {
+		pin, err := collectPIN(ctx, pinEvents("12#"), pinConfig{digits: 4, maxAttempts: 1}, noRetry)
+		require.NoError(t, err)
+		require.Equal(t, "12", pin)
+	})
+	t.Run("too long", func(t *testing.T) {
+		_, err := collectPIN(ctx, pinEvents("12345678901234567"), pinConfig{maxAttempts: 1}, noRetry)
+		require.ErrorIs(t, err, errPINTooLong)
+	})
+	t.Run("closed", func(t *testing.T) {
+		ch := pinEvents("12")
+		close(ch)
+		_, err := collectPIN(ctx, ch, pinConfig{maxAttempts: 1}, noRetry)
+		require.ErrorIs(t, err, errPINClosed)
+	})
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := collectPIN(ctx, make(chan dtmf.Event), pinConfig{maxAttempts: 1}, noRetry)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+	t.Run("timeout", func(t *testing.T) {
+		retries := 0
+		conf := pinConfig{timeout: 10 * time.Millisecond, maxAttempts: 3}
+		_, err := collectPIN(ctx, make(chan dtmf.Event), conf, func() { retries++ })
+		require.ErrorIs(t, err, errPINTimeout)
+		require.Equal(t, 2, retries)
+	})
+	t.Run("retry", func(t *testing.T) {
+		ch := make(chan dtmf.Event, 8)
+		ch <- dtmf.Event{Digit: '1'}
+		ch <- dtmf.Event{Digit: '2'}
+		conf := pinConfig{digits: 4, timeout: 10 * time.Millisecond, maxAttempts: 2}
+		pin, err := collectPIN(ctx, ch, conf, func() {
+			// Digits entered before the timeout are discarded.
+			for _, d := range []byte("5678") {
+				ch <- dtmf.Event{Digit: d}
+			}
+		})
+		require.NoError(t, err)
+		require.Equal(t, "5678", pin)
+	})
+}
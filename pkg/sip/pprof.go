@@ -0,0 +1,53 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"github.com/livekit/protocol/logger"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/sip/callpprof"
+	"github.com/livekit/sip/pkg/stats"
+)
+
+// startCallProfile starts a per-call profile, if enabled in the config.
+func startCallProfile(conf *config.Config, log logger.Logger, callID string) *callpprof.Session {
+	if !conf.PPROFPerCallEnabled {
+		return nil
+	}
+	s, err := callpprof.Start(callID)
+	if err != nil {
+		log.Warnw("Cannot start call profile", err)
+		return nil
+	}
+	return s
+}
+
+func stopCallProfile(log logger.Logger, mon *stats.CallMonitor, s *callpprof.Session) {
+	if s == nil {
+		return
+	}
+	p, err := s.Stop()
+	if err != nil {
+		log.Warnw("Cannot write call profile", err)
+	}
+	if len(p.CPUFiles) == 0 {
+		return
+	}
+	if mon != nil {
+		mon.CallCPU(p.CPU)
+	}
+	log.Infow("Call profile written", "cpuProfiles", p.CPUFiles, "heapProfile", p.HeapFile, "cpu", p.CPU, "duration", p.Duration)
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/sip/callpprof"
+)
+
+func TestService_CallProfile(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		name := "disabled"
+		if enabled {
+			name = "enabled"
+		}
+		t.Run(name, func(t *testing.T) {
+			joined := make(chan *testRoomConn, 1)
+			s, addr := startTestService(t, &config.Config{PPROFPerCallEnabled: enabled}, func(s *Service) {
+				s.srv.connectRoom = newTestRoomConnector(joined)
+				s.SetHandler(acceptHandler("room", ""))
+			})
+			profiles := make(chan callpprof.CallProfile, 10)
+			cancel := callpprof.Subscribe(func(p callpprof.CallProfile) {
+				profiles <- p
+			})
+			defer cancel()
+
+			alice := newTestPhone(t, "alice")
+			req, res := alice.Call(t, addr, "profile", nil)
+			select {
+			case <-joined:
+			case <-time.After(5 * time.Second):
+				t.Fatal("call did not join the room")
+			}
+			from, _ := req.From()
+			tag, _ := from.Params.Get("tag")
+			s.srv.cmu.RLock()
+			call := s.srv.activeCalls[tag]
+			s.srv.cmu.RUnlock()
+			require.NotNil(t, call)
+			require.Equal(t, sip.StatusCode(200), sendTestRequest(t, addr, "alice", newTestPhoneRequest(sip.BYE, addr, req, res)).StatusCode)
+			// The profile is stopped before the call is removed.
+			require.Eventually(t, func() bool {
+				s.srv.cmu.RLock()
+				defer s.srv.cmu.RUnlock()
+				_, ok := s.srv.activeCalls[tag]
+				return !ok
+			}, 5*time.Second, 10*time.Millisecond)
+
+			if !enabled {
+				require.Empty(t, profiles)
+				return
+			}
+			select {
+			case p := <-profiles:
+				t.Cleanup(func() {
+					for _, f := range p.CPUFiles {
+						_ = os.Remove(f)
+					}
+					_ = os.Remove(p.HeapFile)
+				})
+				require.Equal(t, call.id, p.CallID)
+				require.NotEmpty(t, p.CPUFiles)
+				require.FileExists(t, p.HeapFile)
+			case <-time.After(time.Second):
+				t.Fatal("no call profile")
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+const (
+	// sipT1 is an estimate of the round-trip time (RFC 3261, section 17.1.1.1).
+	sipT1 = 500 * time.Millisecond
+	// prackTimeout is how long a reliable provisional response is retransmitted without PRACK (RFC 3262, section 3).
+	prackTimeout = 64 * sipT1
+)
+
+var errPRACKTimeout = errors.New("PRACK was not received")
+
+// requires100rel checks if the INVITE requires reliable provisional responses (RFC 3262).
+func requires100rel(req *sip.Request) bool {
+	for _, h := range req.GetHeaders("Require") {
+		for _, tag := range strings.Split(h.Value(), ",") {
+			if strings.EqualFold(strings.TrimSpace(tag), "100rel") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseRAck parses the RAck header of PRACK: response number, CSeq number and method of the acknowledged response.
+func parseRAck(v string) (rseq, cseq uint32, method sip.RequestMethod, err error) {
+	f := strings.Fields(v)
+	if len(f) != 3 {
+		return 0, 0, "", fmt.Errorf("invalid RAck: %q", v)
+	}
+	r, err := strconv.ParseUint(f[0], 10, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("invalid RAck: %q", v)
+	}
+	c, err := strconv.ParseUint(f[1], 10, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("invalid RAck: %q", v)
+	}
+	return uint32(r), uint32(c), sip.RequestMethod(strings.ToUpper(f[2])), nil
+}
+
+// prackState tracks reliable provisional responses of the inbound call. Zero value is ready to use.
+type prackState struct {
+	mu      sync.Mutex
+	rseq    uint32 // RSeq of the last reliable response; zero if none were sent
+	cseq    uint32 // CSeq of the INVITE
+	pending bool   // last response is waiting for PRACK
+	acked   chan struct{}
+}
+
+// sendReliable sends a reliable provisional response, and retransmits it until PRACK is received (RFC 3262, section 3).
+// Retransmissions start after T1, and the interval doubles each time.
+func (c *inboundCall) sendReliable(ctx context.Context, tx sip.ServerTransaction, req *sip.Request, res *sip.Response) error {
+	cseq, ok := req.CSeq()
+	if !ok {
+		return errors.New("no CSeq in INVITE")
+	}
+	acked := make(chan struct{})
+	c.prack.mu.Lock()
+	if c.prack.rseq == 0 {
+		// Initial value must be in 1..2**31-1 to leave room for increments.
+		c.prack.rseq = uint32(rand.Int31n(1<<31-1)) + 1
+	} else {
+		c.prack.rseq++
+	}
+	rseq := c.prack.rseq
+	c.prack.cseq, c.prack.pending, c.prack.acked = cseq.SeqNo, true, acked
+	c.prack.mu.Unlock()
+
+	res.AppendHeader(sip.NewHeader("Require", "100rel"))
+	res.AppendHeader(sip.NewHeader("RSeq", strconv.FormatUint(uint64(rseq), 10)))
+
+	timeout := time.NewTimer(prackTimeout)
+	defer timeout.Stop()
+	for dt := sipT1; ; dt *= 2 {
+		if err := tx.Respond(res); err != nil {
+			return err
+		}
+		retry := time.NewTimer(dt)
+		select {
+		case <-acked:
+			retry.Stop()
+			return nil
+		case <-ctx.Done():
+			retry.Stop()
+			return ctx.Err()
+		case <-tx.Done():
+			retry.Stop()
+			return errors.New("INVITE transaction terminated")
+		case <-timeout.C:
+			retry.Stop()
+			return errPRACKTimeout
+		case <-retry.C:
+			c.log.Debugw("Retransmitting reliable provisional response", "status", res.StatusCode, "rseq", rseq)
+		}
+	}
+}
+
+// onPrack handles PRACK requests for reliable provisional responses of inbound calls.
+func (s *Server) onPrack(req *sip.Request, tx sip.ServerTransaction) {
+	c := s.dialogCall(req)
+	if c == nil {
+		if s.sipUnhandled != nil {
+			s.sipUnhandled(req, tx)
+			return
+		}
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 481, "Call/Transaction Does Not Exist", nil))
+		return
+	}
+	c.handlePrack(req, tx)
+}
+
+// handlePrack acknowledges the reliable provisional response matching the RAck header.
+// Retransmitted PRACKs for the last response are acknowledged again. Other PRACKs are rejected with 481.
+func (c *inboundCall) handlePrack(req *sip.Request, tx sip.ServerTransaction) {
+	h := req.GetHeader("RAck")
+	if h == nil {
+		sipErrorResponse(tx, req)
+		return
+	}
+	rseq, cseq, method, err := parseRAck(h.Value())
+	if err != nil {
+		c.log.Debugw("Invalid PRACK", "error", err)
+		sipErrorResponse(tx, req)
+		return
+	}
+	c.prack.mu.Lock()
+	ok := rseq != 0 && rseq == c.prack.rseq && cseq == c.prack.cseq && method == sip.INVITE
+	if ok && c.prack.pending {
+		c.prack.pending = false
+		close(c.prack.acked)
+	}
+	c.prack.mu.Unlock()
+	if !ok {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 481, "Call/Transaction Does Not Exist", nil))
+		return
+	}
+	_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+func TestService_PRACK(t *testing.T) {
+	joined := make(chan *testRoomConn, 1)
+	_, addr := startTestService(t, &config.Config{}, func(s *Service) {
+		s.srv.connectRoom = newTestRoomConnector(joined)
+		s.SetHandler(acceptHandler("room", ""))
+	})
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+	offer, err := sdpGenerateOffer(localIP, 0xB0B)
+	require.NoError(t, err)
+
+	alice := newTestPhone(t, "alice")
+	req := sip.NewRequest(sip.INVITE, &sip.Uri{User: "+100", Host: addr})
+	req.SetDestination(addr)
+	req.SetBody(offer)
+	req.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+	req.AppendHeader(sip.NewHeader("Require", "100rel"))
+	tx, err := alice.cli.TransactionRequest(req)
+	require.NoError(t, err)
+	t.Cleanup(tx.Terminate)
+
+	// No unreliable 180 is sent; the answer comes in a reliable 183.
+	progress := getResponseOrFail(t, tx)
+	require.Equal(t, sip.StatusCode(183), progress.StatusCode)
+	require.NotNil(t, progress.GetHeader("Require"))
+	require.Equal(t, "100rel", progress.GetHeader("Require").Value())
+	require.NotNil(t, progress.GetHeader("RSeq"))
+	rseq := progress.GetHeader("RSeq").Value()
+	require.NotEmpty(t, progress.Body())
+
+	// Without PRACK, the 183 is retransmitted after T1 instead of answering the call.
+	res := getResponseOrFail(t, tx)
+	require.Equal(t, sip.StatusCode(183), res.StatusCode)
+	require.Equal(t, rseq, res.GetHeader("RSeq").Value())
+
+	cseq, _ := req.CSeq()
+	rack := func(rseq uint64) sip.Header {
+		return sip.NewHeader("RAck", strconv.FormatUint(rseq, 10)+" "+strconv.FormatUint(uint64(cseq.SeqNo), 10)+" INVITE")
+	}
+	rnum, err := strconv.ParseUint(rseq, 10, 32)
+	require.NoError(t, err)
+
+	// PRACK for an unknown response is rejected.
+	prack := newTestPhoneRequest(sip.PRACK, addr, req, progress)
+	prack.AppendHeader(rack(rnum + 1))
+	require.Equal(t, sip.StatusCode(481), sendTestRequest(t, addr, "alice", prack).StatusCode)
+
+	prack = newTestPhoneRequest(sip.PRACK, addr, req, progress)
+	prack.AppendHeader(rack(rnum))
+	require.Equal(t, sip.StatusCode(200), sendTestRequest(t, addr, "alice", prack).StatusCode)
+
+	// The call is answered only after PRACK, in the same dialog as the 183.
+	for {
+		res = getResponseOrFail(t, tx)
+		if res.StatusCode != 183 {
+			break
+		}
+	}
+	require.Equal(t, sip.StatusCode(200), res.StatusCode)
+	pto, _ := progress.To()
+	to, _ := res.To()
+	ptag, _ := pto.Params.Get("tag")
+	tag, _ := to.Params.Get("tag")
+	require.Equal(t, ptag, tag)
+	require.NoError(t, alice.cli.WriteRequest(sip.NewAckRequest(req, res, nil)))
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/livekit/sip/pkg/media"
+)
+
+// audioPreBuffer keeps the most recent audio of the caller received before the participant joins the room,
+// which hides the gap between the answer and the participant track being published.
+// Once flushed, buffered audio is written first, and later audio is passed through.
+type audioPreBuffer struct {
+	rate int
+	max  int // samples
+
+	mu      sync.Mutex
+	out     media.PCM16Writer // set once flushed
+	frames  []media.PCM16Sample
+	samples int
+}
+
+// newAudioPreBuffer creates a buffer for up to dur of audio. The buffer is disabled if dur is not positive,
+// and audio is dropped until it's flushed.
+func newAudioPreBuffer(dur time.Duration, sampleRate int) *audioPreBuffer {
+	return &audioPreBuffer{rate: sampleRate, max: int(dur * time.Duration(sampleRate) / time.Second)}
+}
+
+func (b *audioPreBuffer) WriteSample(sample media.PCM16Sample) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.out != nil {
+		return b.out.WriteSample(sample)
+	}
+	if b.max <= 0 || len(sample) == 0 {
+		return nil
+	}
+	// Samples are usually reused by the decoder.
+	b.frames = append(b.frames, slices.Clone(sample))
+	b.samples += len(sample)
+	// Drop the oldest frames, keeping the most recent audio.
+	drop := 0
+	for b.samples > b.max && drop < len(b.frames)-1 {
+		b.samples -= len(b.frames[drop])
+		drop++
+	}
+	if drop > 0 {
+		b.frames = slices.Delete(b.frames, 0, drop)
+	}
+	return nil
+}
+
+// Buffered returns the duration of buffered audio.
+func (b *audioPreBuffer) Buffered() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Duration(b.samples) * time.Second / time.Duration(b.rate)
+}
+
+// Flush writes buffered audio to w, and passes all later audio to it. It's a no-op if the buffer was flushed already.
+func (b *audioPreBuffer) Flush(w media.PCM16Writer) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.out != nil {
+		return nil
+	}
+	b.out = w
+	frames := b.frames
+	b.frames, b.samples = nil, 0
+	for _, frame := range frames {
+		if err := w.WriteSample(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
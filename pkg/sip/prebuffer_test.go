@@ -0,0 +1,146 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	prtp "github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/rtp"
+	lksdp "github.com/livekit/sip/pkg/media/sdp"
+	"github.com/livekit/sip/pkg/media/ulaw"
+)
+
+func TestAudioPreBuffer(t *testing.T) {
+	var got []int16
+	out := media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		got = append(got, s[0])
+		return nil
+	})
+	frame := make(media.PCM16Sample, rtp.DefPacketDur)
+
+	b := newAudioPreBuffer(3*rtp.DefFrameDur, rtp.DefSampleRate)
+	for i := int16(1); i <= 5; i++ {
+		frame[0] = i
+		require.NoError(t, b.WriteSample(frame))
+	}
+	// Only the most recent audio is kept, and frames are copied.
+	require.Equal(t, 3*rtp.DefFrameDur, b.Buffered())
+	require.NoError(t, b.Flush(out))
+	require.Equal(t, []int16{3, 4, 5}, got)
+	require.Zero(t, b.Buffered())
+
+	// Later audio is passed through.
+	frame[0] = 6
+	require.NoError(t, b.WriteSample(frame))
+	require.NoError(t, b.Flush(nil))
+	require.Equal(t, []int16{3, 4, 5, 6}, got)
+
+	// Audio is dropped until flushed, if disabled.
+	got = nil
+	b = newAudioPreBuffer(-1, rtp.DefSampleRate)
+	require.NoError(t, b.WriteSample(frame))
+	require.Zero(t, b.Buffered())
+	require.NoError(t, b.Flush(out))
+	require.Empty(t, got)
+}
+
+func TestService_PreBuffer(t *testing.T) {
+	const (
+		early = 10 // frames sent before the participant joins
+		live  = 5  // frames sent after
+	)
+	s, _ := startTestService(t, &config.Config{PreBufferDuration: 5 * rtp.DefFrameDur})
+	call := addTestCall(s, "alice", "alice-tag")
+
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+	peer := rtp.NewConn(nil)
+	require.NoError(t, peer.ListenAndServe(0, 0, localIP))
+	t.Cleanup(func() { _ = peer.Close() })
+	offer, err := sdpGenerateOfferWith(localIP, peer.LocalAddr().Port, []sdpCodecInfo{
+		{Type: prtp.PayloadTypePCMU, Codec: lksdp.CodecByName(ulaw.SDPName)},
+	})
+	require.NoError(t, err)
+	_, err = call.runMediaConn(offer, s.conf)
+	require.NoError(t, err)
+	t.Cleanup(call.closeMedia)
+	peer.SetDestAddr(&net.UDPAddr{IP: net.ParseIP(localIP), Port: call.rtpConn.LocalAddr().Port})
+
+	// Each frame has a distinct level, so the order of frames reaching the room can be checked.
+	level := func(i int) int16 {
+		return ulaw.DecodeUlawFrame(ulaw.EncodeUlawFrame(int16(500 * (i + 1))))
+	}
+	send := func(i int) {
+		frame := make([]int16, rtp.DefPacketDur)
+		for j := range frame {
+			frame[j] = level(i)
+		}
+		require.NoError(t, peer.WriteRTP(&prtp.Packet{
+			Header:  prtp.Header{Version: 2, PayloadType: prtp.PayloadTypePCMU, SSRC: 0xA11CE, SequenceNumber: uint16(i), Timestamp: uint32(i) * uint32(rtp.DefPacketDur)},
+			Payload: ulaw.EncodeUlaw(frame),
+		}))
+		time.Sleep(rtp.DefFrameDur)
+	}
+
+	// Early media arrives before the participant joins the room.
+	for i := 0; i < early; i++ {
+		send(i)
+	}
+	require.Eventually(t, func() bool {
+		return call.preBuffer.Buffered() == 5*rtp.DefFrameDur
+	}, time.Second, 10*time.Millisecond)
+
+	// Participant joins: buffered audio is flushed to the track first, as createLiveKitParticipant does.
+	var (
+		mu  sync.Mutex
+		got []int16
+	)
+	track := media.WriterFunc[media.PCM16Sample](func(s media.PCM16Sample) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, s...)
+		return nil
+	})
+	require.NoError(t, call.preBuffer.Flush(track))
+	call.lkAudio.Set(track)
+
+	for i := early; i < early+live; i++ {
+		send(i)
+	}
+	var exp []int16
+	for i := early - 5; i < early+live; i++ {
+		for j := 0; j < int(rtp.DefPacketDur); j++ {
+			exp = append(exp, level(i))
+		}
+	}
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) >= len(exp)
+	}, time.Second, 10*time.Millisecond)
+
+	// The most recent early media is followed by live audio, without a gap.
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, exp, got)
+}
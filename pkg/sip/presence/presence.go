@@ -0,0 +1,269 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package presence implements room presence subscriptions (RFC 3856) with PIDF bodies (RFC 3863).
+package presence
+
+import (
+	"encoding/xml"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/livekit/protocol/auth"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/webhook"
+	"golang.org/x/exp/maps"
+)
+
+const (
+	EventName   = "presence"
+	ContentType = "application/pidf+xml"
+
+	// DefaultExpires is used when subscriber doesn't set the Expires header.
+	DefaultExpires = time.Hour
+)
+
+// NotifyFunc delivers PIDF document to the subscriber.
+type NotifyFunc func(sub *Subscription, body []byte) error
+
+// Subscription is a presence subscription for a single LiveKit room.
+type Subscription struct {
+	ID       string // unique subscription ID, e.g. SIP Call-ID and tag
+	Entity   string // presentity URI, e.g. sip:room@host
+	RoomName string
+	Expires  time.Time
+	Notify   NotifyFunc
+	Dialog   any // state of the dialog which delivers notifications; not used by the manager
+}
+
+// Expired checks if subscription is expired at a given time.
+func (s *Subscription) Expired(now time.Time) bool {
+	return !s.Expires.IsZero() && !now.Before(s.Expires)
+}
+
+// Manager tracks presence subscriptions and participants in LiveKit rooms.
+type Manager struct {
+	log logger.Logger
+
+	mu    sync.Mutex
+	subs  map[string]*Subscription
+	rooms map[string]map[string]struct{} // room name -> participant identities
+}
+
+func NewManager(log logger.Logger) *Manager {
+	if log == nil {
+		log = logger.GetLogger()
+	}
+	return &Manager{
+		log:   log,
+		subs:  make(map[string]*Subscription),
+		rooms: make(map[string]map[string]struct{}),
+	}
+}
+
+// Subscribe adds or refreshes the subscription and sends the current room state to it.
+func (m *Manager) Subscribe(sub *Subscription) error {
+	m.mu.Lock()
+	m.subs[sub.ID] = sub
+	body, err := m.pidfLocked(sub.Entity, sub.RoomName)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return sub.Notify(sub, body)
+}
+
+// Lookup returns an active subscription with a given ID.
+func (m *Manager) Lookup(id string) (*Subscription, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sub, ok := m.subs[id]
+	if !ok || sub.Expired(time.Now()) {
+		return nil, false
+	}
+	return sub, true
+}
+
+// Unsubscribe removes the subscription.
+func (m *Manager) Unsubscribe(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.subs, id)
+}
+
+// Subscriptions returns the number of active subscriptions.
+func (m *Manager) Subscriptions() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pruneLocked(time.Now())
+	return len(m.subs)
+}
+
+// Participants returns identities of participants known to be in the room.
+func (m *Manager) Participants(roomName string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.participantsLocked(roomName)
+}
+
+// ParticipantJoined updates room state and notifies the subscribers.
+func (m *Manager) ParticipantJoined(roomName, identity string) {
+	m.mu.Lock()
+	room := m.rooms[roomName]
+	if room == nil {
+		room = make(map[string]struct{})
+		m.rooms[roomName] = room
+	}
+	room[identity] = struct{}{}
+	m.mu.Unlock()
+	m.notifyRoom(roomName)
+}
+
+// ParticipantLeft updates room state and notifies the subscribers.
+func (m *Manager) ParticipantLeft(roomName, identity string) {
+	m.mu.Lock()
+	if room := m.rooms[roomName]; room != nil {
+		delete(room, identity)
+		if len(room) == 0 {
+			delete(m.rooms, roomName)
+		}
+	}
+	m.mu.Unlock()
+	m.notifyRoom(roomName)
+}
+
+// HandleWebhookEvent updates room state from LiveKit webhook events.
+func (m *Manager) HandleWebhookEvent(ev *livekit.WebhookEvent) {
+	if ev.Room == nil || ev.Participant == nil {
+		return
+	}
+	switch ev.Event {
+	case webhook.EventParticipantJoined:
+		m.ParticipantJoined(ev.Room.Name, ev.Participant.Identity)
+	case webhook.EventParticipantLeft:
+		m.ParticipantLeft(ev.Room.Name, ev.Participant.Identity)
+	}
+}
+
+// WebhookHandler returns HTTP handler for LiveKit webhooks.
+func (m *Manager) WebhookHandler(keys auth.KeyProvider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ev, err := webhook.ReceiveWebhookEvent(r, keys)
+		if err != nil {
+			m.log.Warnw("cannot receive webhook", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.HandleWebhookEvent(ev)
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func (m *Manager) notifyRoom(roomName string) {
+	type notification struct {
+		sub  *Subscription
+		body []byte
+	}
+	var list []notification
+	m.mu.Lock()
+	m.pruneLocked(time.Now())
+	for _, sub := range m.subs {
+		if sub.RoomName != roomName {
+			continue
+		}
+		body, err := m.pidfLocked(sub.Entity, roomName)
+		if err != nil {
+			m.log.Errorw("cannot generate presence document", err)
+			continue
+		}
+		list = append(list, notification{sub: sub, body: body})
+	}
+	m.mu.Unlock()
+
+	for _, n := range list {
+		if err := n.sub.Notify(n.sub, n.body); err != nil {
+			m.log.Warnw("cannot notify presence subscriber", err, "subscription", n.sub.ID)
+		}
+	}
+}
+
+func (m *Manager) pruneLocked(now time.Time) {
+	for id, sub := range m.subs {
+		if sub.Expired(now) {
+			delete(m.subs, id)
+		}
+	}
+}
+
+func (m *Manager) participantsLocked(roomName string) []string {
+	list := maps.Keys(m.rooms[roomName])
+	slices.Sort(list)
+	return list
+}
+
+func (m *Manager) pidfLocked(entity, roomName string) ([]byte, error) {
+	return PIDF(entity, m.participantsLocked(roomName))
+}
+
+const (
+	BasicOpen   = "open"
+	BasicClosed = "closed"
+)
+
+// Document is a PIDF presence document.
+type Document struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:pidf presence"`
+	Entity  string   `xml:"entity,attr"`
+	Tuples  []Tuple  `xml:"tuple"`
+}
+
+type Tuple struct {
+	ID      string `xml:"id,attr"`
+	Basic   string `xml:"status>basic"`
+	Contact string `xml:"contact,omitempty"`
+}
+
+// PIDF generates a presence document for the room with a given participants.
+// Each participant is reported as a separate open tuple. Empty room is reported with a single closed tuple.
+func PIDF(entity string, participants []string) ([]byte, error) {
+	doc := Document{Entity: entity}
+	for _, identity := range participants {
+		doc.Tuples = append(doc.Tuples, Tuple{ID: identity, Basic: BasicOpen, Contact: identity})
+	}
+	if len(doc.Tuples) == 0 {
+		doc.Tuples = append(doc.Tuples, Tuple{ID: "room", Basic: BasicClosed})
+	}
+	return Marshal(&doc)
+}
+
+// Marshal encodes a presence document with the XML header.
+func Marshal(doc *Document) ([]byte, error) {
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// ParsePIDF parses a presence document.
+func ParsePIDF(data []byte) (*Document, error) {
+	var doc Document
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
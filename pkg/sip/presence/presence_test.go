@@ -0,0 +1,79 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/webhook"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager(t *testing.T) {
+	m := NewManager(nil)
+
+	var docs []*Document
+	sub := &Subscription{
+		ID:       "sub",
+		Entity:   "pres:room@example.com",
+		RoomName: "room",
+		Expires:  time.Now().Add(time.Minute),
+		Notify: func(sub *Subscription, body []byte) error {
+			doc, err := ParsePIDF(body)
+			require.NoError(t, err)
+			docs = append(docs, doc)
+			return nil
+		},
+	}
+	require.NoError(t, m.Subscribe(sub))
+	require.Len(t, docs, 1)
+	require.Equal(t, "pres:room@example.com", docs[0].Entity)
+	require.Equal(t, []Tuple{{ID: "room", Basic: BasicClosed}}, docs[0].Tuples)
+	got, ok := m.Lookup("sub")
+	require.True(t, ok)
+	require.Same(t, sub, got)
+	_, ok = m.Lookup("other")
+	require.False(t, ok)
+
+	m.HandleWebhookEvent(&livekit.WebhookEvent{
+		Event:       webhook.EventParticipantJoined,
+		Room:        &livekit.Room{Name: "room"},
+		Participant: &livekit.ParticipantInfo{Identity: "alice"},
+	})
+	require.Len(t, docs, 2)
+	require.Equal(t, []Tuple{{ID: "alice", Basic: BasicOpen, Contact: "alice"}}, docs[1].Tuples)
+
+	// Other rooms do not trigger notifications.
+	m.ParticipantJoined("other", "bob")
+	require.Len(t, docs, 2)
+
+	m.HandleWebhookEvent(&livekit.WebhookEvent{
+		Event:       webhook.EventParticipantLeft,
+		Room:        &livekit.Room{Name: "room"},
+		Participant: &livekit.ParticipantInfo{Identity: "alice"},
+	})
+	require.Len(t, docs, 3)
+	require.Equal(t, []Tuple{{ID: "room", Basic: BasicClosed}}, docs[2].Tuples)
+
+	// Expired subscriptions are removed.
+	sub.Expires = time.Now()
+	m.ParticipantJoined("room", "alice")
+	require.Len(t, docs, 3)
+	_, ok = m.Lookup("sub")
+	require.False(t, ok)
+	require.Equal(t, 0, m.Subscriptions())
+}
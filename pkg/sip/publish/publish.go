@@ -0,0 +1,292 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package publish sends event state publications (RFC 3903) for SIP calls to an event state compositor.
+package publish
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/parser"
+	"github.com/emiago/sipgo/sip"
+	"github.com/frostbyte73/core"
+	"github.com/livekit/protocol/logger"
+
+	"github.com/livekit/sip/pkg/sip/presence"
+)
+
+const (
+	// DefaultExpires is the publication lifetime requested from the compositor.
+	DefaultExpires = time.Hour
+
+	queueSize      = 256
+	requestTimeout = 5 * time.Second
+)
+
+// errConditionFailed is returned when the compositor doesn't know the ETag (412 Conditional Request Failed).
+var errConditionFailed = errors.New("publication is unknown to the compositor")
+
+// Requester sends SIP requests. It is implemented by sipgo.Client.
+type Requester interface {
+	TransactionRequest(req *sip.Request, options ...sipgo.ClientRequestOption) (sip.ClientTransaction, error)
+}
+
+// publication is the state of a single entity published to the compositor.
+type publication struct {
+	id    string
+	uri   sip.Uri
+	body  []byte
+	etag  string
+	gen   int // incremented with each update, so that stale refresh timers are ignored
+	timer *time.Timer
+}
+
+type op struct {
+	id   string
+	user string
+	body []byte // nil for refresh and removal
+	kind opKind
+	gen  int
+}
+
+type opKind int
+
+const (
+	opPublish opKind = iota
+	opRefresh
+	opRemove
+)
+
+// Publisher sends PUBLISH requests to an event state compositor. Each publication is refreshed before it expires,
+// and removed when the call ends. Requests are sent in order, in a separate goroutine.
+//
+// A nil Publisher is valid and ignores all calls.
+type Publisher struct {
+	log     logger.Logger
+	cli     Requester
+	target  sip.Uri
+	expires time.Duration
+
+	pubs   map[string]*publication // only accessed by the run goroutine
+	queue  chan op
+	closed core.Fuse
+	done   chan struct{}
+}
+
+// NewPublisher creates a publisher for the compositor URI. It returns nil if the URI is empty.
+func NewPublisher(cli Requester, uri string, expires time.Duration, log logger.Logger) (*Publisher, error) {
+	if uri == "" {
+		return nil, nil
+	}
+	var target sip.Uri
+	if err := parser.ParseUri(uri, &target); err != nil {
+		return nil, fmt.Errorf("invalid event state compositor URI %q: %w", uri, err)
+	}
+	if expires <= 0 {
+		expires = DefaultExpires
+	}
+	if log == nil {
+		log = logger.GetLogger()
+	}
+	p := &Publisher{
+		log:     log.WithValues("compositor", uri),
+		cli:     cli,
+		target:  target,
+		expires: expires,
+		pubs:    make(map[string]*publication),
+		queue:   make(chan op, queueSize),
+		done:    make(chan struct{}),
+	}
+	go p.run()
+	return p, nil
+}
+
+// CallDocument returns a PIDF document for an active call. The entity is the local user of the call.
+func CallDocument(user, host, callID, remote string) ([]byte, error) {
+	doc := presence.Document{
+		Entity: fmt.Sprintf("pres:%s@%s", user, host),
+		Tuples: []presence.Tuple{{ID: callID, Basic: presence.BasicOpen, Contact: remote}},
+	}
+	return presence.Marshal(&doc)
+}
+
+// Publish creates or modifies the publication with a given ID. User is used in the Request-URI.
+func (p *Publisher) Publish(id, user string, body []byte) {
+	p.enqueue(op{kind: opPublish, id: id, user: user, body: body})
+}
+
+// Remove removes the publication with a given ID from the compositor.
+func (p *Publisher) Remove(id string) {
+	p.enqueue(op{kind: opRemove, id: id})
+}
+
+// Close removes all active publications and stops the publisher.
+func (p *Publisher) Close() {
+	if p == nil {
+		return
+	}
+	p.closed.Break()
+	<-p.done
+}
+
+func (p *Publisher) enqueue(o op) {
+	if p == nil || p.closed.IsBroken() {
+		return
+	}
+	select {
+	case p.queue <- o:
+	default:
+		p.log.Warnw("publish queue is full, dropping request", nil, "id", o.id)
+	}
+}
+
+func (p *Publisher) run() {
+	defer close(p.done)
+	for {
+		select {
+		case <-p.closed.Watch():
+			for id := range p.pubs {
+				p.handle(op{kind: opRemove, id: id})
+			}
+			return
+		case o := <-p.queue:
+			p.handle(o)
+		}
+	}
+}
+
+func (p *Publisher) handle(o op) {
+	pub := p.pubs[o.id]
+	var err error
+	switch o.kind {
+	case opPublish:
+		if pub == nil {
+			uri := p.target
+			uri.User = o.user
+			pub = &publication{id: o.id, uri: uri}
+			p.pubs[o.id] = pub
+		}
+		pub.body = o.body
+		err = p.update(pub, pub.body)
+	case opRefresh:
+		if pub == nil || pub.gen != o.gen {
+			return // already updated or removed
+		}
+		err = p.update(pub, nil)
+	case opRemove:
+		if pub == nil {
+			return
+		}
+		delete(p.pubs, o.id)
+		pub.stopTimer()
+		if pub.etag == "" {
+			return // not published
+		}
+		_, err = p.send(pub, nil, 0)
+	}
+	if err != nil {
+		p.log.Warnw("cannot publish event state", err, "id", o.id)
+	}
+}
+
+// update sends the initial publication, a modification with a new body, or a refresh without a body.
+// If the compositor lost the publication, it is created again with the last known state.
+func (p *Publisher) update(pub *publication, body []byte) error {
+	pub.stopTimer()
+	pub.gen++
+	expires, err := p.send(pub, body, p.expires)
+	if errors.Is(err, errConditionFailed) && pub.etag != "" {
+		pub.etag = ""
+		expires, err = p.send(pub, pub.body, p.expires)
+	}
+	if err != nil {
+		return err
+	}
+	// Refresh before the publication expires (RFC 3903, section 4.1).
+	gen := pub.gen
+	pub.timer = time.AfterFunc(expires-expires/5, func() {
+		p.enqueue(op{kind: opRefresh, id: pub.id, gen: gen})
+	})
+	return nil
+}
+
+func (pub *publication) stopTimer() {
+	if pub.timer != nil {
+		pub.timer.Stop()
+		pub.timer = nil
+	}
+}
+
+// send sends a single PUBLISH request and returns the expiration granted by the compositor.
+func (p *Publisher) send(pub *publication, body []byte, expires time.Duration) (time.Duration, error) {
+	uri := pub.uri
+	req := sip.NewRequest(sip.PUBLISH, &uri)
+	req.AppendHeader(sip.NewHeader("Event", presence.EventName))
+	req.AppendHeader(sip.NewHeader("Expires", strconv.Itoa(int(expires/time.Second))))
+	if pub.etag != "" {
+		req.AppendHeader(sip.NewHeader("SIP-If-Match", pub.etag))
+	}
+	if body != nil {
+		req.AppendHeader(sip.NewHeader("Content-Type", presence.ContentType))
+		req.SetBody(body)
+	}
+	tx, err := p.cli.TransactionRequest(req)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Terminate()
+
+	var res *sip.Response
+	timeout := time.NewTimer(requestTimeout)
+	defer timeout.Stop()
+wait:
+	for {
+		select {
+		case <-tx.Done():
+			return 0, errors.New("PUBLISH transaction failed")
+		case <-timeout.C:
+			return 0, errors.New("PUBLISH timed out")
+		case res = <-tx.Responses():
+			if res.StatusCode >= 200 {
+				break wait
+			}
+		}
+	}
+	switch {
+	case res.StatusCode == 412:
+		return 0, errConditionFailed
+	case res.StatusCode/100 != 2:
+		return 0, fmt.Errorf("PUBLISH failed with status %d", res.StatusCode)
+	}
+	if expires == 0 {
+		return 0, nil // removed
+	}
+	h := res.GetHeader("SIP-ETag")
+	if h == nil {
+		return 0, errors.New("no SIP-ETag in PUBLISH response")
+	}
+	pub.etag = strings.TrimSpace(h.Value())
+	// Compositor may shorten the expiration.
+	if h := res.GetHeader("Expires"); h != nil {
+		if sec, err := strconv.Atoi(strings.TrimSpace(h.Value())); err == nil && sec > 0 && time.Duration(sec)*time.Second < expires {
+			expires = time.Duration(sec) * time.Second
+		}
+	}
+	return expires, nil
+}
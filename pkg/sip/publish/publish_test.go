@@ -0,0 +1,162 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/sip/presence"
+)
+
+type testPublish struct {
+	user    string
+	ifMatch string
+	expires string
+	body    string
+}
+
+// newTestCompositor starts an event state compositor, which responds to each PUBLISH with a given function.
+func newTestCompositor(t *testing.T, respond func(p testPublish, req *sip.Request) *sip.Response) (*net.UDPAddr, <-chan testPublish) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	ua, err := sipgo.NewUA()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ua.Close() })
+
+	srv, err := sipgo.NewServer(ua)
+	require.NoError(t, err)
+	reqs := make(chan testPublish, 10)
+	srv.OnRequest(sip.PUBLISH, func(req *sip.Request, tx sip.ServerTransaction) {
+		p := testPublish{user: req.Recipient.User, body: string(req.Body())}
+		if h := req.GetHeader("SIP-If-Match"); h != nil {
+			p.ifMatch = h.Value()
+		}
+		if h := req.GetHeader("Expires"); h != nil {
+			p.expires = h.Value()
+		}
+		reqs <- p
+		_ = tx.Respond(respond(p, req))
+	})
+	go func() {
+		_ = srv.ServeUDP(conn)
+	}()
+	return conn.LocalAddr().(*net.UDPAddr), reqs
+}
+
+func newTestPublisher(t *testing.T, addr *net.UDPAddr) *Publisher {
+	ua, err := sipgo.NewUA()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ua.Close() })
+	cli, err := sipgo.NewClient(ua, sipgo.WithClientHostname("127.0.0.1"))
+	require.NoError(t, err)
+
+	p, err := NewPublisher(cli, "sip:"+addr.String(), time.Minute, nil)
+	require.NoError(t, err)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func expectPublish(t *testing.T, reqs <-chan testPublish, exp testPublish) {
+	t.Helper()
+	select {
+	case p := <-reqs:
+		require.Equal(t, exp, p)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for PUBLISH")
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	etag := 0
+	addr, reqs := newTestCompositor(t, func(p testPublish, req *sip.Request) *sip.Response {
+		if p.ifMatch == "etag-3" {
+			// Compositor lost the publication.
+			return sip.NewResponseFromRequest(req, 412, "Conditional Request Failed", nil)
+		}
+		etag++
+		res := sip.NewResponseFromRequest(req, 200, "OK", nil)
+		res.AppendHeader(sip.NewHeader("SIP-ETag", "etag-"+strconv.Itoa(etag)))
+		if p.expires != "0" {
+			res.AppendHeader(sip.NewHeader("Expires", "1")) // shorter than requested
+		}
+		return res
+	})
+	p := newTestPublisher(t, addr)
+
+	body1, err := CallDocument("alice", "example.com", "call", "sip:bob@example.com")
+	require.NoError(t, err)
+	doc, err := presence.ParsePIDF(body1)
+	require.NoError(t, err)
+	require.Equal(t, "pres:alice@example.com", doc.Entity)
+	require.Equal(t, []presence.Tuple{{ID: "call", Basic: presence.BasicOpen, Contact: "sip:bob@example.com"}}, doc.Tuples)
+	body2, err := CallDocument("alice", "example.com", "call", "sip:carol@example.com")
+	require.NoError(t, err)
+
+	// Initial publication.
+	p.Publish("call", "alice", body1)
+	expectPublish(t, reqs, testPublish{user: "alice", expires: "60", body: string(body1)})
+
+	// Modification.
+	p.Publish("call", "alice", body2)
+	expectPublish(t, reqs, testPublish{user: "alice", ifMatch: "etag-1", expires: "60", body: string(body2)})
+
+	// Refresh without a body, before the granted expiration.
+	expectPublish(t, reqs, testPublish{user: "alice", ifMatch: "etag-2", expires: "60"})
+
+	// Refresh fails, so the publication is created again with the last state.
+	expectPublish(t, reqs, testPublish{user: "alice", ifMatch: "etag-3", expires: "60"})
+	expectPublish(t, reqs, testPublish{user: "alice", expires: "60", body: string(body2)})
+
+	// Removal.
+	p.Remove("call")
+	expectPublish(t, reqs, testPublish{user: "alice", ifMatch: "etag-4", expires: "0"})
+	select {
+	case r := <-reqs:
+		t.Fatalf("unexpected PUBLISH after removal: %+v", r)
+	case <-time.After(1200 * time.Millisecond):
+	}
+}
+
+func TestPublisherClose(t *testing.T) {
+	addr, reqs := newTestCompositor(t, func(p testPublish, req *sip.Request) *sip.Response {
+		res := sip.NewResponseFromRequest(req, 200, "OK", nil)
+		res.AppendHeader(sip.NewHeader("SIP-ETag", "etag-"+p.user))
+		return res
+	})
+	p := newTestPublisher(t, addr)
+
+	p.Publish("call", "alice", []byte("state"))
+	expectPublish(t, reqs, testPublish{user: "alice", expires: "60", body: "state"})
+
+	// Active publications are removed on close.
+	p.Close()
+	expectPublish(t, reqs, testPublish{user: "alice", ifMatch: "etag-alice", expires: "0"})
+
+	// Nil publisher ignores all calls.
+	p, err := NewPublisher(nil, "", 0, nil)
+	require.NoError(t, err)
+	require.Nil(t, p)
+	p.Publish("call", "alice", nil)
+	p.Remove("call")
+	p.Close()
+}
@@ -0,0 +1,167 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package queue implements queuing of inbound calls which cannot be bridged yet, because the trunk is at capacity.
+// Queued calls are answered and wait for a call slot to be handed over by a call which ends.
+//
+// Queues are kept in memory, thus they only include calls on the same SIP node.
+package queue
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/media/tones"
+)
+
+// PositionPlaceholder is replaced with the queue position in the announcement file template.
+const PositionPlaceholder = "{position}"
+
+// Call is a queued call.
+type Call struct {
+	ID       string // LiveKit call ID
+	QueuedAt time.Time
+
+	done chan struct{}
+	slot func() // set before done is closed
+}
+
+// Done is closed once the call is dequeued.
+func (c *Call) Done() <-chan struct{} {
+	return c.done
+}
+
+// Slot returns the call slot handed over by CallQueue.Dequeue. It's only set once the call is dequeued.
+// The call must release the slot when it ends.
+func (c *Call) Slot() func() {
+	select {
+	case <-c.done:
+		return c.slot
+	default:
+		return nil
+	}
+}
+
+// CallQueue keeps calls in the order they were queued. Positions are numbered from 1.
+type CallQueue struct {
+	mu    sync.Mutex
+	max   int
+	calls []*Call
+}
+
+func New(maxLen int) *CallQueue {
+	if maxLen <= 0 {
+		maxLen = config.DefaultCallQueueMaxLength
+	}
+	return &CallQueue{max: maxLen}
+}
+
+// Enqueue adds the call to the end of the queue, and returns its position. Done is closed once the call is dequeued.
+// If the queue is full, the call is not added, and the position is zero.
+func (q *CallQueue) Enqueue(call *Call) (position int, done <-chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.calls) >= q.max {
+		return 0, nil
+	}
+	call.QueuedAt = time.Now()
+	call.done = make(chan struct{})
+	q.calls = append(q.calls, call)
+	return len(q.calls), call.done
+}
+
+// Dequeue removes the first call from the queue and hands over the call slot to it.
+// It returns false if the queue is empty, in which case the slot must be released by the caller.
+func (q *CallQueue) Dequeue(slot func()) (*Call, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.calls) == 0 {
+		return nil, false
+	}
+	call := q.calls[0]
+	q.calls = q.calls[1:]
+	call.slot = slot
+	close(call.done)
+	return call, true
+}
+
+// Remove removes the call from the queue, e.g. when the caller hangs up. Calls behind it move up.
+// It returns false if the call is not queued. The call may be dequeued already, see Call.Slot.
+func (q *CallQueue) Remove(call *Call) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, c := range q.calls {
+		if c == call {
+			q.calls = append(q.calls[:i], q.calls[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Position returns the current position of the call, or zero if it's not queued.
+func (q *CallQueue) Position(call *Call) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, c := range q.calls {
+		if c == call {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Len returns the number of queued calls.
+func (q *CallQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.calls)
+}
+
+// AnnouncementFile returns the path of the recording announcing a given position.
+func AnnouncementFile(template string, position int) string {
+	return strings.ReplaceAll(template, PositionPlaceholder, strconv.Itoa(position))
+}
+
+const (
+	toneFreq  = 1000
+	toneDur   = 150 * time.Millisecond
+	tonePause = 100 * time.Millisecond
+	toneAmp   = 8000
+)
+
+// ConnectTone returns two short beeps played to the caller before the call is bridged.
+func ConnectTone() []media.PCM16Sample {
+	var frames []media.PCM16Sample
+	for i := 0; i < 2; i++ {
+		frames = appendTone(frames, []tones.Hz{toneFreq}, toneDur)
+		frames = appendTone(frames, nil, tonePause)
+	}
+	return frames
+}
+
+func appendTone(frames []media.PCM16Sample, freq []tones.Hz, dur time.Duration) []media.PCM16Sample {
+	var ts time.Duration
+	for ; ts < dur; ts += rtp.DefFrameDur {
+		buf := make(media.PCM16Sample, rtp.DefPacketDur)
+		tones.Generate(buf, ts, rtp.DefFrameDur, toneAmp, freq)
+		frames = append(frames, buf)
+	}
+	return frames
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func isDone(c *Call) bool {
+	select {
+	case <-c.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestCallQueue(t *testing.T) {
+	q := New(3)
+	calls := []*Call{{ID: "call-a"}, {ID: "call-b"}, {ID: "call-c"}}
+	for i, c := range calls {
+		pos, done := q.Enqueue(c)
+		require.Equal(t, i+1, pos)
+		require.NotNil(t, done)
+	}
+	require.Equal(t, 3, q.Len())
+	pos, done := q.Enqueue(&Call{ID: "call-d"})
+	require.Zero(t, pos, "queue is full")
+	require.Nil(t, done)
+
+	// Calls are dequeued in order, and get the slot released by the previous call.
+	for i, exp := range calls {
+		require.Equal(t, 1, q.Position(exp))
+		require.Nil(t, exp.Slot())
+		slots := 0
+		c, ok := q.Dequeue(func() { slots++ })
+		require.True(t, ok)
+		require.Same(t, exp, c)
+		require.True(t, isDone(c))
+		require.Zero(t, q.Position(c))
+		require.Equal(t, len(calls)-i-1, q.Len())
+		c.Slot()()
+		require.Equal(t, 1, slots)
+	}
+	_, ok := q.Dequeue(func() {})
+	require.False(t, ok)
+}
+
+func TestCallQueueRemove(t *testing.T) {
+	q := New(0)
+	a, b, c := &Call{ID: "call-a"}, &Call{ID: "call-b"}, &Call{ID: "call-c"}
+	q.Enqueue(a)
+	q.Enqueue(b)
+	q.Enqueue(c)
+
+	// Caller hung up, calls behind move up.
+	require.True(t, q.Remove(b))
+	require.False(t, q.Remove(b))
+	require.False(t, isDone(b))
+	require.Equal(t, 1, q.Position(a))
+	require.Equal(t, 2, q.Position(c))
+
+	// Dequeued calls are not in the queue anymore, but keep the slot.
+	_, ok := q.Dequeue(func() {})
+	require.True(t, ok)
+	require.False(t, q.Remove(a))
+	require.NotNil(t, a.Slot())
+	require.Equal(t, 1, q.Position(c))
+}
+
+func TestAnnouncementFile(t *testing.T) {
+	require.Equal(t, "/prompts/queue-12.pcm", AnnouncementFile("/prompts/queue-{position}.pcm", 12))
+	require.NotEmpty(t, ConnectTone())
+}
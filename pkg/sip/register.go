@@ -0,0 +1,296 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/icholy/digest"
+	"github.com/livekit/protocol/logger"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+const (
+	// registerRenew is the part of the registration lifetime after which it's renewed.
+	registerRenew = 0.75
+	// registerRetryInterval is the delay before a failed registration is retried.
+	registerRetryInterval = 30 * time.Second
+	// unregisterTimeout limits the time spent removing the registration on shutdown.
+	unregisterTimeout = 5 * time.Second
+)
+
+// registration keeps the service registered with an outbound trunk, see config.RegisterConfig.
+// Each registration is managed by a separate goroutine.
+type registration struct {
+	c       *Client
+	log     logger.Logger
+	address string
+	conf    config.RegisterConfig
+	retry   time.Duration
+
+	// Same for all requests of the registration (RFC 3261, section 10.2).
+	callID sip.CallIDHeader
+	tag    string
+	cseq   uint32
+
+	mu         sync.Mutex
+	registered bool
+	expires    time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// startRegistrations registers with all trunks which have outbound_register enabled.
+func (c *Client) startRegistrations() {
+	for trunkID, conf := range c.conf.OutboundRegister {
+		if !conf.RegisterEnabled {
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		r := &registration{
+			c:       c,
+			log:     c.log.WithValues("sip-trunk", trunkID, "register-user", conf.RegisterUser),
+			address: c.conf.OutboundTrunks[trunkID],
+			conf:    conf,
+			retry:   registerRetryInterval,
+			callID:  sip.CallIDHeader(sip.GenerateTagN(32)),
+			tag:     sip.GenerateTagN(16),
+			ctx:     ctx,
+			cancel:  cancel,
+			done:    make(chan struct{}),
+		}
+		c.registrations = append(c.registrations, r)
+		go r.run()
+	}
+}
+
+// stopRegistrations removes all registrations.
+func (c *Client) stopRegistrations() {
+	for _, r := range c.registrations {
+		r.Close()
+	}
+	c.registrations = nil
+}
+
+// Registered returns true if the registration is active.
+func (r *registration) Registered() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.registered && time.Now().Before(r.expires)
+}
+
+// Close stops renewing the registration, and removes it from the registrar.
+func (r *registration) Close() {
+	r.cancel()
+	<-r.done
+}
+
+func (r *registration) run() {
+	defer close(r.done)
+	for {
+		delay := r.retry
+		expires, err := r.register(r.ctx, r.conf.Expiry())
+		switch {
+		case r.ctx.Err() != nil:
+			// Closed while registering.
+		case err != nil:
+			r.log.Warnw("SIP registration failed", err, "retry", delay)
+			r.setState(false, time.Time{})
+		default:
+			if !r.Registered() {
+				r.log.Infow("SIP registration succeeded", "expires", expires)
+			}
+			r.setState(true, time.Now().Add(expires))
+			delay = time.Duration(float64(expires) * registerRenew)
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-r.ctx.Done():
+			t.Stop()
+			r.mu.Lock()
+			registered := r.registered
+			r.mu.Unlock()
+			// Even if the registration seems expired, a renewal might have been in flight.
+			if registered {
+				r.unregister()
+			}
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (r *registration) setState(registered bool, expires time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.registered, r.expires = registered, expires
+}
+
+// unregister removes the registration (RFC 3261, section 10.2.2). It's not retried.
+func (r *registration) unregister() {
+	ctx, cancel := context.WithTimeout(context.Background(), unregisterTimeout)
+	defer cancel()
+	if _, err := r.register(ctx, 0); err != nil {
+		r.log.Warnw("SIP unregistration failed", err)
+	} else {
+		r.log.Infow("SIP registration removed")
+	}
+	r.setState(false, time.Time{})
+}
+
+// register sends REGISTER, answering digest challenges. It returns the lifetime granted by the registrar.
+func (r *registration) register(ctx context.Context, expires time.Duration) (time.Duration, error) {
+	var auth sipAuth
+	for attempt := 0; ; attempt++ {
+		req, resp, err := r.send(ctx, expires, auth)
+		if err != nil {
+			return 0, err
+		}
+		switch resp.StatusCode {
+		case 200:
+			return registerExpires(resp, expires), nil
+		case 401, 407:
+			if attempt > 0 {
+				return 0, fmt.Errorf("REGISTER authentication failed with status %d", resp.StatusCode)
+			}
+			if resp.StatusCode == 401 {
+				auth.auth, err = registerDigest(req, resp, "WWW-Authenticate", r.conf)
+			} else {
+				auth.proxy, err = registerDigest(req, resp, "Proxy-Authenticate", r.conf)
+			}
+			if err != nil {
+				return 0, err
+			}
+		case 423:
+			// Interval Too Brief (RFC 3261, section 10.2.8).
+			h := resp.GetHeader("Min-Expires")
+			if h == nil || attempt > 0 {
+				return 0, errors.New("REGISTER interval too brief")
+			}
+			sec, err := strconv.Atoi(strings.TrimSpace(h.Value()))
+			if err != nil || sec <= 0 {
+				return 0, fmt.Errorf("invalid Min-Expires: %q", h.Value())
+			}
+			expires = time.Duration(sec) * time.Second
+		default:
+			return 0, fmt.Errorf("REGISTER failed with status %d %s", resp.StatusCode, resp.Reason)
+		}
+	}
+}
+
+// send sends a single REGISTER and waits for the final response.
+func (r *registration) send(ctx context.Context, expires time.Duration, auth sipAuth) (*sip.Request, *sip.Response, error) {
+	aor, dest := sipTrunkURI(r.conf.RegisterUser, r.address)
+	registrar := *aor
+	registrar.User = ""
+
+	req := sip.NewRequest(sip.REGISTER, &registrar)
+	req.SetDestination(dest)
+	from := &sip.FromHeader{Address: *aor, Params: sip.NewParams()}
+	from.Params.Add("tag", r.tag)
+	req.AppendHeader(from)
+	req.AppendHeader(&sip.ToHeader{Address: *aor})
+	callID := r.callID
+	req.AppendHeader(&callID)
+	r.cseq++
+	req.AppendHeader(&sip.CSeqHeader{SeqNo: r.cseq, MethodName: sip.REGISTER})
+	req.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: r.conf.RegisterUser, Host: r.c.signalingIp, Port: r.c.conf.SIPPort}})
+	req.AppendHeader(sip.NewHeader("Expires", strconv.Itoa(int(expires/time.Second))))
+	if auth.auth != "" {
+		req.AppendHeader(sip.NewHeader("Authorization", auth.auth))
+	}
+	if auth.proxy != "" {
+		req.AppendHeader(sip.NewHeader("Proxy-Authorization", auth.proxy))
+	}
+
+	tx, err := r.c.sipCli.TransactionRequest(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Terminate()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-tx.Done():
+			return nil, nil, errNoResponse
+		case resp := <-tx.Responses():
+			if resp.StatusCode >= 200 {
+				return req, resp, nil
+			}
+		}
+	}
+}
+
+// registerExpires returns the lifetime granted by the registrar. It's set in the expires parameter of our Contact,
+// or in the Expires header (RFC 3261, section 10.2.4).
+func registerExpires(resp *sip.Response, requested time.Duration) time.Duration {
+	parse := func(v string) (time.Duration, bool) {
+		sec, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || sec <= 0 {
+			return 0, false
+		}
+		return time.Duration(sec) * time.Second, true
+	}
+	if c, ok := resp.Contact(); ok && c.Params != nil {
+		if v, ok := c.Params.Get("expires"); ok {
+			if dt, ok := parse(v); ok {
+				return dt
+			}
+		}
+	}
+	if h := resp.GetHeader("Expires"); h != nil {
+		if dt, ok := parse(h.Value()); ok {
+			return dt
+		}
+	}
+	return requested
+}
+
+// registerDigest computes digest credentials for REGISTER. Unlike INVITE, the digest URI is the registrar.
+func registerDigest(req *sip.Request, resp *sip.Response, header string, conf config.RegisterConfig) (string, error) {
+	if conf.RegisterPassword == "" {
+		return "", fmt.Errorf("registrar responded with %d, but no password was provided", resp.StatusCode)
+	}
+	h := resp.GetHeader(header)
+	if h == nil {
+		return "", fmt.Errorf("registrar responded with %d, but no %s header was provided", resp.StatusCode, header)
+	}
+	challenge, err := digest.ParseChallenge(h.Value())
+	if err != nil {
+		return "", err
+	}
+	cred, err := digest.Digest(challenge, digest.Options{
+		Method:   req.Method.String(),
+		URI:      req.Recipient.String(),
+		GetBody:  digestBody(req.Body()),
+		Username: conf.RegisterUser,
+		Password: conf.RegisterPassword,
+	})
+	if err != nil {
+		return "", err
+	}
+	return cred.String(), nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/icholy/digest"
+	"github.com/livekit/protocol/logger"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/stats"
+)
+
+type testRegister struct {
+	at      time.Time
+	expires string
+	cseq    uint32
+	callID  string
+	contact string
+}
+
+func TestOutboundRegister(t *testing.T) {
+	challenge := digest.Challenge{Realm: "trunk", Nonce: "n0nce", Algorithm: "MD5"}
+	registers := make(chan testRegister, 10)
+	addr := newTestUASWith(t, func(srv *sipgo.Server) {
+		srv.OnRegister(func(req *sip.Request, tx sip.ServerTransaction) {
+			h := req.GetHeader("Authorization")
+			if h == nil {
+				res := sip.NewResponseFromRequest(req, 401, "Unauthorized", nil)
+				res.AppendHeader(sip.NewHeader("WWW-Authenticate", challenge.String()))
+				_ = tx.Respond(res)
+				return
+			}
+			cred, err := digest.ParseCredentials(h.Value())
+			require.NoError(t, err)
+			exp, err := digest.Digest(&challenge, digest.Options{Method: "REGISTER", URI: cred.URI, Username: "alice", Password: "secret"})
+			require.NoError(t, err)
+			require.Equal(t, "alice", cred.Username)
+			require.Equal(t, req.Recipient.String(), cred.URI)
+			if cred.Response != exp.Response {
+				_ = tx.Respond(sip.NewResponseFromRequest(req, 403, "Forbidden", nil))
+				return
+			}
+			to, _ := req.To()
+			require.Equal(t, "alice", to.Address.User)
+			cseq, _ := req.CSeq()
+			callID, _ := req.CallID()
+			contact, _ := req.Contact()
+			registers <- testRegister{
+				at:      time.Now(),
+				expires: req.GetHeader("Expires").Value(),
+				cseq:    cseq.SeqNo,
+				callID:  callID.Value(),
+				contact: contact.Address.User,
+			}
+			// The registrar grants a shorter lifetime than requested.
+			res := sip.NewResponseFromRequest(req, 200, "OK", nil)
+			res.AppendHeader(sip.NewHeader("Expires", "1"))
+			_ = tx.Respond(res)
+		})
+	})
+
+	conf := &config.Config{
+		SIPPort:        5060,
+		OutboundTrunks: map[string]string{"ST_reg": addr.String()},
+		OutboundRegister: map[string]config.RegisterConfig{
+			"ST_reg": {RegisterEnabled: true, RegisterUser: "alice", RegisterPassword: "secret", RegisterExpiry: 120},
+		},
+	}
+	mon := stats.NewMonitor()
+	require.NoError(t, mon.Start(conf))
+	t.Cleanup(mon.Stop)
+	cli := NewClient(conf, logger.GetLogger(), mon, rtp.NewPortPool(testPortRTPMin, testPortRTPMax), nil)
+	require.NoError(t, cli.Start(nil))
+	stopped := false
+	t.Cleanup(func() {
+		if !stopped {
+			cli.Stop()
+		}
+	})
+
+	expectRegister := func(t *testing.T) testRegister {
+		t.Helper()
+		select {
+		case r := <-registers:
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatal("no REGISTER")
+			return testRegister{}
+		}
+	}
+	first := expectRegister(t)
+	require.Equal(t, "120", first.expires)
+	require.Equal(t, "alice", first.contact)
+	require.Len(t, cli.registrations, 1)
+	require.Eventually(t, cli.registrations[0].Registered, time.Second, 10*time.Millisecond)
+
+	// Renewed at 75% of the granted lifetime, in the same registration.
+	second := expectRegister(t)
+	require.InDelta(t, 750*time.Millisecond, second.at.Sub(first.at), float64(200*time.Millisecond))
+	require.Equal(t, first.callID, second.callID)
+	require.Greater(t, second.cseq, first.cseq)
+	require.True(t, cli.registrations[0].Registered())
+
+	// The registration is removed on shutdown.
+	cli.Stop()
+	stopped = true
+	last := expectRegister(t)
+	require.Equal(t, "0", last.expires)
+	require.Equal(t, first.callID, last.callID)
+}
+
+func TestRegisterExpires(t *testing.T) {
+	res := sip.NewResponse(200, "OK")
+	require.Equal(t, time.Hour, registerExpires(res, time.Hour))
+
+	res.AppendHeader(sip.NewHeader("Expires", "600"))
+	require.Equal(t, 10*time.Minute, registerExpires(res, time.Hour))
+
+	contact := &sip.ContactHeader{Address: sip.Uri{User: "alice", Host: "example.com"}, Params: sip.NewParams()}
+	contact.Params.Add("expires", "300")
+	res.AppendHeader(contact)
+	require.Equal(t, 5*time.Minute, registerExpires(res, time.Hour))
+}
@@ -16,11 +16,16 @@ package sip
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"sync/atomic"
 
 	"github.com/frostbyte73/core"
 	"github.com/pion/webrtc/v3"
+	pmedia "github.com/pion/webrtc/v3/pkg/media"
 
+	"github.com/livekit/protocol/auth"
+	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/logger"
 	lksdk "github.com/livekit/server-sdk-go/v2"
 
@@ -29,6 +34,18 @@ import (
 	"github.com/livekit/sip/pkg/media/opus"
 	"github.com/livekit/sip/pkg/media/rtp"
 	"github.com/livekit/sip/pkg/mixer"
+	"github.com/livekit/sip/pkg/stats"
+)
+
+// Attributes of the SIP participant in the LiveKit room.
+const (
+	AttrCallID = "sip_call_id" // Call-ID of the SIP dialog
+	AttrFrom   = "sip_from"    // number of the caller
+	AttrTo     = "sip_to"      // number of the callee
+	AttrHold   = "sip_hold"    // hold state of the call, "true" or "false"
+
+	AttrForwardedTo = "sip_forwarded_to" // target of the outbound call forwarding (SIP 181)
+	AttrMediaMode   = "sip_media_mode"   // media mode of the call, "audio" or "t38"
 )
 
 type Participant struct {
@@ -41,12 +58,23 @@ type Participant struct {
 
 type Room struct {
 	log     logger.Logger
-	room    *lksdk.Room
+	connect roomConnector
+	room    roomConn
 	mix     *mixer.Mixer
+	unmon   func() // stops exporting mixer stats; optional
 	out     media.SwitchWriter[media.PCM16Sample]
+	pmu     sync.Mutex // protects p, which is read by webhook events while the room connects
 	p       Participant
 	ready   atomic.Bool
 	stopped core.Fuse
+
+	onMessage       func(text string)                    // text messages from participants; set before Connect
+	onTranscription func(text string)                    // transcripts from participants; set before Connect
+	onMWI           func(data []byte)                    // message counts of mailboxes; set before Connect
+	onDial          func(sender, number, trunkID string) // dialstrings from participants; set before Connect
+	onTransfer      func(sender, callID, target string)  // transfer requests from participants; set before Connect
+	opusOpts        []opus.EncodeOption                  // encoder options for the participant track; set on Connect
+	track           *media.DeadlineWriter[pmedia.Sample] // writer of the participant track; set by NewParticipantTrack
 }
 
 type lkRoomConfig struct {
@@ -54,12 +82,108 @@ type lkRoomConfig struct {
 	identity string
 	name     string
 	meta     string
+	attrs    map[string]string // optional; participant attributes
 	wsUrl    string
 	token    string
 }
 
+// roomConn is a connection of the SIP participant to a LiveKit room.
+type roomConn interface {
+	SID() string
+	Identity() string
+	// SIPParticipants returns identities of all SIP participants in the room, including the local one.
+	SIPParticipants() []string
+	PublishTrack(track webrtc.TrackLocal, opts *lksdk.TrackPublicationOptions) error
+	PublishDataPacket(data lksdk.DataPacket, opts ...lksdk.DataPublishOption) error
+	// SetAttributes updates attributes of the local participant. Empty values remove attributes.
+	SetAttributes(attrs map[string]string)
+	Disconnect()
+}
+
+// roomConnector connects the SIP participant to a LiveKit room.
+type roomConnector func(conf *config.Config, rc lkRoomConfig, cb *lksdk.RoomCallback) (roomConn, error)
+
+// connectLiveKit connects to a LiveKit room with API credentials from the config, or with a token, if it's provided.
+func connectLiveKit(conf *config.Config, rc lkRoomConfig, cb *lksdk.RoomCallback) (roomConn, error) {
+	var (
+		room *lksdk.Room
+		err  error
+	)
+	if rc.wsUrl == "" || rc.token == "" {
+		// Same as lksdk.ConnectToRoom, but the participant must be able to update its own attributes.
+		grant := &auth.VideoGrant{RoomJoin: true, Room: rc.roomName}
+		grant.SetCanUpdateOwnMetadata(true)
+		var token string
+		token, err = auth.NewAccessToken(conf.ApiKey, conf.ApiSecret).
+			AddGrant(grant).
+			SetIdentity(rc.identity).
+			SetName(rc.name).
+			SetMetadata(rc.meta).
+			SetAttributes(rc.attrs).
+			SetKind(livekit.ParticipantInfo_SIP).
+			ToJWT()
+		if err != nil {
+			return nil, err
+		}
+		room, err = lksdk.ConnectToRoomWithToken(conf.WsUrl, token, cb, lksdk.WithAutoSubscribe(false))
+	} else {
+		room, err = lksdk.ConnectToRoomWithToken(rc.wsUrl, rc.token, cb)
+		if err == nil && len(rc.attrs) != 0 {
+			// Attributes of the token are set by the issuer, thus they can only be updated after joining.
+			room.LocalParticipant.SetAttributes(rc.attrs)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return lksdkRoom{room}, nil
+}
+
+type lksdkRoom struct {
+	room *lksdk.Room
+}
+
+func (r lksdkRoom) SID() string {
+	return r.room.LocalParticipant.SID()
+}
+
+func (r lksdkRoom) Identity() string {
+	return r.room.LocalParticipant.Identity()
+}
+
+func (r lksdkRoom) SIPParticipants() []string {
+	ids := []string{r.room.LocalParticipant.Identity()}
+	for _, p := range r.room.GetRemoteParticipants() {
+		if p.Kind() == lksdk.ParticipantSIP {
+			ids = append(ids, p.Identity())
+		}
+	}
+	return ids
+}
+
+func (r lksdkRoom) PublishTrack(track webrtc.TrackLocal, opts *lksdk.TrackPublicationOptions) error {
+	_, err := r.room.LocalParticipant.PublishTrack(track, opts)
+	return err
+}
+
+func (r lksdkRoom) PublishDataPacket(data lksdk.DataPacket, opts ...lksdk.DataPublishOption) error {
+	return r.room.LocalParticipant.PublishDataPacket(data, opts...)
+}
+
+func (r lksdkRoom) SetAttributes(attrs map[string]string) {
+	r.room.LocalParticipant.SetAttributes(attrs)
+}
+
+func (r lksdkRoom) Disconnect() {
+	r.room.Disconnect()
+}
+
 func NewRoom(log logger.Logger) *Room {
-	r := &Room{log: log}
+	return newRoom(log, connectLiveKit)
+}
+
+func newRoom(log logger.Logger, connect roomConnector) *Room {
+	r := &Room{log: log, connect: connect}
 	r.mix = mixer.NewMixer(&r.out, rtp.DefFrameDur, rtp.DefSampleRate)
 	return r
 }
@@ -71,17 +195,16 @@ func (r *Room) Closed() <-chan struct{} {
 	return r.stopped.Watch()
 }
 
-func (r *Room) Connect(conf *config.Config, roomName, identity, name, meta, wsUrl, token string) error {
-	var (
-		err  error
-		room *lksdk.Room
-	)
+func (r *Room) Connect(conf *config.Config, roomName, identity, name, meta string, attrs map[string]string, wsUrl, token string) error {
+	r.opusOpts = opusEncodeOptions(conf)
+	r.pmu.Lock()
 	r.p = Participant{
 		RoomName: roomName,
 		Identity: identity,
 		Name:     name,
 		Metadata: meta,
 	}
+	r.pmu.Unlock()
 	roomCallback := &lksdk.RoomCallback{
 		ParticipantCallback: lksdk.ParticipantCallback{
 			OnTrackPublished: func(publication *lksdk.RemoteTrackPublication, rp *lksdk.RemoteParticipant) {
@@ -95,40 +218,45 @@ func (r *Room) Connect(conf *config.Config, roomName, identity, name, meta, wsUr
 				mTrack := r.NewTrack()
 				defer mTrack.Close()
 
-				odec, err := opus.Decode(mTrack, rtp.DefSampleRate, channels)
+				odec, err := media.NewPipeline[opus.Sample, media.PCM16Sample](
+					opus.DecodeStage(rtp.DefSampleRate, channels, opus.WithFECDecode()),
+				).Build(mTrack)
 				if err != nil {
+					r.log.Errorw("cannot create track decoder", err, "trackID", pub.SID())
 					return
 				}
-				h := rtp.NewMediaStreamIn[opus.Sample](odec)
+				var h rtp.Handler = rtp.NewMediaStreamIn[opus.Sample](odec)
+				if id := rtp.FindExtension(receiverExtensions(pub.Receiver()), rtp.AudioLevelURI); id != 0 {
+					h = rtp.NewAudioLevelHandler(h, id, func(l rtp.AudioLevel) {
+						mTrack.SetLevel(l.DBov())
+					})
+				}
 				_ = rtp.HandleLoop(track, h)
 			},
+			OnDataPacket: r.handleData,
 		},
 		OnDisconnected: func() {
 			r.stopped.Break()
 		},
 	}
 
-	if wsUrl == "" || token == "" {
-		room, err = lksdk.ConnectToRoom(conf.WsUrl,
-			lksdk.ConnectInfo{
-				APIKey:              conf.ApiKey,
-				APISecret:           conf.ApiSecret,
-				RoomName:            roomName,
-				ParticipantIdentity: identity,
-				ParticipantName:     name,
-				ParticipantMetadata: meta,
-				ParticipantKind:     lksdk.ParticipantSIP,
-			}, roomCallback, lksdk.WithAutoSubscribe(false))
-	} else {
-		room, err = lksdk.ConnectToRoomWithToken(wsUrl, token, roomCallback)
-	}
-
+	room, err := r.connect(conf, lkRoomConfig{
+		roomName: roomName,
+		identity: identity,
+		name:     name,
+		meta:     meta,
+		attrs:    attrs,
+		wsUrl:    wsUrl,
+		token:    token,
+	}, roomCallback)
 	if err != nil {
 		return err
 	}
 	r.room = room
-	r.p.ID = r.room.LocalParticipant.SID()
-	r.p.Identity = r.room.LocalParticipant.Identity()
+	r.pmu.Lock()
+	r.p.ID = room.SID()
+	r.p.Identity = room.Identity()
+	r.pmu.Unlock()
 	r.ready.Store(true)
 	return nil
 }
@@ -150,44 +278,184 @@ func (r *Room) Close() error {
 		r.room.Disconnect()
 		r.room = nil
 	}
+	r.closeTrack()
 	if r.mix != nil {
 		r.mix.Stop()
 		r.mix = nil
 	}
+	if r.unmon != nil {
+		r.unmon()
+		r.unmon = nil
+	}
 	return nil
 }
 
+// monitorMixer exports mixer stats to the monitor until the room is closed.
+func (r *Room) monitorMixer(mon *stats.Monitor) {
+	r.unmon = mon.AddMixer(r.mix)
+}
+
+// Leave disconnects from the LiveKit room, but keeps the mixer, so that the call can connect to a different room.
+func (r *Room) Leave() {
+	r.ready.Store(false)
+	if r.room != nil {
+		r.room.Disconnect()
+		r.room = nil
+	}
+	r.closeTrack()
+}
+
 func (r *Room) Participant() Participant {
 	if r == nil {
 		return Participant{}
 	}
+	r.pmu.Lock()
+	defer r.pmu.Unlock()
 	return r.p
 }
 
+// opusEncodeOptions returns Opus encoder options for audio published to the room.
+func opusEncodeOptions(conf *config.Config) []opus.EncodeOption {
+	opts := []opus.EncodeOption{opus.WithDTX(), opus.WithFEC()}
+	if conf.OpusEncoderBitrate != 0 {
+		opts = append(opts, opus.WithBitrate(conf.OpusEncoderBitrate))
+	}
+	if conf.OpusEncoderComplexity != nil {
+		opts = append(opts, opus.WithComplexity(*conf.OpusEncoderComplexity))
+	}
+	return opts
+}
+
+// trackWriteTimeout is the max time to wait for the participant track to accept a frame.
+const trackWriteTimeout = 5 * rtp.DefFrameDur
+
 func (r *Room) NewParticipantTrack() (media.Writer[media.PCM16Sample], error) {
 	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "pion")
 	if err != nil {
 		return nil, err
 	}
-	p := r.room.LocalParticipant
-	if _, err = p.PublishTrack(track, &lksdk.TrackPublicationOptions{
-		Name: p.Identity(),
+	if err = r.room.PublishTrack(track, &lksdk.TrackPublicationOptions{
+		Name: r.room.Identity(),
 	}); err != nil {
 		return nil, err
 	}
-	ow := media.FromSampleWriter[opus.Sample](track, rtp.DefFrameDur)
-	pw, err := opus.Encode(ow, rtp.DefSampleRate, channels)
-	if err != nil {
-		return nil, err
+	p := media.NewPipeline[media.PCM16Sample, pmedia.Sample](
+		opus.EncodeStage(rtp.DefSampleRate, channels, r.opusOpts...),
+		media.SampleWriterStage[opus.Sample](rtp.DefFrameDur),
+	)
+	r.log.Debugw("publishing participant track", "pipeline", p.String())
+	// A stalled track must not block the SIP media pipeline, so late frames are dropped.
+	r.closeTrack()
+	r.track = media.NewDeadlineWriter[pmedia.Sample](track, trackWriteTimeout)
+	return p.Build(r.track)
+}
+
+func (r *Room) closeTrack() {
+	if r.track != nil {
+		_ = r.track.Close()
+		r.track = nil
 	}
-	return pw, nil
+}
+
+// OnMessage sets a handler for text messages published by room participants on MessageTopic.
+func (r *Room) OnMessage(fnc func(text string)) {
+	r.onMessage = fnc
+}
+
+// OnTranscription sets a handler for transcripts published by room participants on TranscriptionTopic.
+func (r *Room) OnTranscription(fnc func(text string)) {
+	r.onTranscription = fnc
+}
+
+// OnMWI sets a handler for mailbox updates published on MWITopic.
+func (r *Room) OnMWI(fnc func(data []byte)) {
+	r.onMWI = fnc
+}
+
+// OnDial sets a handler for "DIAL:<number>@<trunk_id>" dialstrings published by room participants.
+func (r *Room) OnDial(fnc func(sender, number, trunkID string)) {
+	r.onDial = fnc
+}
+
+// OnTransfer sets a handler for "TRANSFER:<call_id>:<target_uri>" requests published by room participants.
+func (r *Room) OnTransfer(fnc func(sender, callID, target string)) {
+	r.onTransfer = fnc
+}
+
+func (r *Room) handleData(data lksdk.DataPacket, params lksdk.DataReceiveParams) {
+	p, ok := data.(*lksdk.UserDataPacket)
+	if !ok {
+		return
+	}
+	if p.Topic == MessageTopic {
+		if r.onMessage != nil {
+			r.onMessage(string(p.Payload))
+		}
+		return
+	}
+	if p.Topic == MWITopic {
+		if r.onMWI != nil {
+			r.onMWI(p.Payload)
+		}
+		return
+	}
+	if p.Topic == TranscriptionTopic {
+		if r.onTranscription != nil {
+			r.onTranscription(string(p.Payload))
+		}
+		return
+	}
+	if r.onTransfer != nil {
+		callID, target, err := parseTransfer(string(p.Payload))
+		if err == nil {
+			r.onTransfer(params.SenderIdentity, callID, target)
+			return
+		} else if !errors.Is(err, errNotTransfer) {
+			r.log.Warnw("Ignoring transfer request", err, "sender", params.SenderIdentity)
+			return
+		}
+	}
+	if r.onDial == nil {
+		return
+	}
+	number, trunkID, err := parseDialString(string(p.Payload))
+	if errors.Is(err, errNotDialString) {
+		return
+	} else if err != nil {
+		r.log.Warnw("Ignoring dialstring", err, "sender", params.SenderIdentity)
+		return
+	}
+	r.onDial(params.SenderIdentity, number, trunkID)
+}
+
+// isSIPLeader checks if the participant has the lowest identity among SIP participants in the room.
+// All SIP participants receive the same data packets, possibly on different nodes, but only the leader must act on them.
+func (r *Room) isSIPLeader() bool {
+	if r == nil || !r.ready.Load() {
+		return false
+	}
+	self := r.room.Identity()
+	for _, id := range r.room.SIPParticipants() {
+		if id < self {
+			return false
+		}
+	}
+	return true
 }
 
 func (r *Room) SendData(data lksdk.DataPacket, opts ...lksdk.DataPublishOption) error {
 	if r == nil || !r.ready.Load() {
 		return nil
 	}
-	return r.room.LocalParticipant.PublishDataPacket(data, opts...)
+	return r.room.PublishDataPacket(data, opts...)
+}
+
+// SetAttributes updates attributes of the SIP participant. Empty values remove attributes.
+func (r *Room) SetAttributes(attrs map[string]string) {
+	if r == nil || !r.ready.Load() {
+		return
+	}
+	r.room.SetAttributes(attrs)
 }
 
 func (r *Room) NewTrack() *Track {
@@ -212,3 +480,20 @@ func (t *Track) PlayAudio(ctx context.Context, frames []media.PCM16Sample) {
 func (t *Track) WriteSample(pcm media.PCM16Sample) error {
 	return t.inp.WriteSample(pcm)
 }
+
+// SetLevel reports the audio level of the track in dBov, see mixer.Mixer.SetInputLevel.
+func (t *Track) SetLevel(dBov float64) {
+	t.mix.SetInputLevel(t.inp, dBov)
+}
+
+// receiverExtensions returns RTP header extensions negotiated for the receiver.
+func receiverExtensions(r *webrtc.RTPReceiver) []rtp.ExtensionHeader {
+	if r == nil {
+		return nil
+	}
+	var exts []rtp.ExtensionHeader
+	for _, ext := range r.GetParameters().HeaderExtensions {
+		exts = append(exts, rtp.ExtensionHeader{ID: uint8(ext.ID), URI: ext.URI})
+	}
+	return exts
+}
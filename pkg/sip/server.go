@@ -16,9 +16,16 @@ package sip
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/emiago/sipgo"
 	"github.com/emiago/sipgo/sip"
@@ -26,8 +33,18 @@ import (
 	"github.com/livekit/protocol/logger"
 	"golang.org/x/exp/maps"
 
+	"github.com/livekit/sip/pkg/cdr"
 	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/media/srtp/dtls"
+	"github.com/livekit/sip/pkg/mixer"
+	"github.com/livekit/sip/pkg/sip/mwi"
+	"github.com/livekit/sip/pkg/sip/parking"
+	"github.com/livekit/sip/pkg/sip/presence"
+	"github.com/livekit/sip/pkg/sip/publish"
+	"github.com/livekit/sip/pkg/sip/queue"
 	"github.com/livekit/sip/pkg/stats"
+	"github.com/livekit/sip/pkg/webhook"
 )
 
 const (
@@ -47,6 +64,42 @@ type CallInfo struct {
 	SrcAddress string
 	Pin        string
 	NoPin      bool
+
+	// ReplaceCallID and ReplaceRoomName are set when the INVITE has a Replaces header (attended transfer)
+	// which references an existing call. New caller is expected to join the same room.
+	ReplaceCallID   string
+	ReplaceRoomName string
+
+	// OnHold is set when the caller is put on hold and hears music-on-hold instead of the room audio.
+	OnHold bool
+
+	// DiversionHeader lists entries of the Diversion header, if the call was forwarded to this number.
+	// The most recent diversion comes first. It's only available to the Handler,
+	// the protocol has no field to forward it with EvaluateSIPDispatchRulesRequest yet.
+	DiversionHeader []Diversion
+
+	// Headers lists custom (X-*) headers of the INVITE. They are mapped to participant attributes
+	// with HeadersToAttributes when the call is dispatched.
+	Headers map[string]string
+
+	// StartedAt is set when the INVITE is received, AnsweredAt when the call is answered with 200 OK.
+	// EndedAt is only set on the info passed to Handler.CallEnded.
+	StartedAt  time.Time
+	AnsweredAt time.Time
+	EndedAt    time.Time
+}
+
+// Duration returns the time from the answer to the end of the call, or to now if the call is still active.
+// It's zero if the call was not answered.
+func (info *CallInfo) Duration() time.Duration {
+	if info.AnsweredAt.IsZero() {
+		return 0
+	}
+	end := info.EndedAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return end.Sub(info.AnsweredAt)
 }
 
 type DispatchResult int
@@ -64,23 +117,53 @@ type CallDispatch struct {
 	Identity       string
 	Name           string
 	Metadata       string
+	Attributes     map[string]string // participant attributes, for example custom headers mapped with HeadersToAttributes
 	WsUrl          string
 	Token          string
 	TrunkID        string
 	DispatchRuleID string
+
+	// PIN prompt settings, used with DispatchRequestPin.
+	PinDigits      int           // number of digits; the PIN is terminated by # if not set
+	PinTimeout     time.Duration // timeout for the next digit, after which the prompt is repeated; no timeout if not set
+	PinMaxAttempts int           // number of prompts before the call is closed on timeout; one if not set
+	PinPromptFile  string        // WebM file played instead of the default PIN prompt; optional, see config.PINPromptFile
+}
+
+// MWISubscription is a message-waiting indication subscription (RFC 3842) received from a SIP endpoint.
+type MWISubscription struct {
+	ID         string // Call-ID and tag of the subscription dialog
+	User       string // mailbox user from the request URI
+	Host       string
+	FromUser   string
+	SrcAddress string
+	Expires    time.Duration // zero when unsubscribing
 }
 
 type Handler interface {
 	GetAuthCredentials(ctx context.Context, fromUser, toUser, toHost, srcAddress string) (username, password string, drop bool, err error)
 	DispatchCall(ctx context.Context, info *CallInfo) CallDispatch
+	// SubscribeMWI is called for each MWI SUBSCRIBE, including refreshes and unsubscribes.
+	// It returns the trunk of the subscriber; only rooms of calls from this trunk can update the mailbox.
+	// The subscription is rejected if it returns an error.
+	SubscribeMWI(ctx context.Context, sub *MWISubscription) (trunkID string, err error)
+	// CallEnded is called once the inbound call ends, with the reason it was closed.
+	CallEnded(ctx context.Context, info *CallInfo, reason string)
 }
 
 type Server struct {
 	log              logger.Logger
 	mon              *stats.Monitor
 	sipSrv           *sipgo.Server
-	sipConn          *net.UDPConn
+	sipCli           *sipgo.Client // for requests in dialogs created by the server, e.g. NOTIFY
+	sipListeners     []io.Closer
 	sipUnhandled     sipgo.RequestHandler
+	ports            *rtp.PortPool
+	hook             *webhook.Notifier
+	cdrs             *cdr.Exporter      // optional
+	pub              *publish.Publisher // optional
+	dial             *dialer            // optional
+	connectRoom      roomConnector
 	signalingIp      string
 	signalingIpLocal string
 
@@ -88,11 +171,24 @@ type Server struct {
 
 	cmu         sync.RWMutex
 	activeCalls map[string]*inboundCall
+	dialogs     *dialogRegistry
+	presence    *presence.Manager
+	mwi         *mwi.Manager
+	presenceSrv *http.Server // optional
+	controlSrv  *http.Server // optional
+	park        *parking.Lot
+	trunks      trunkLimiter
+	trunkStatus *TrunkRegistry    // optional
+	dtlsCert    *dtls.Certificate // set if DTLS-SRTP is enabled
+
+	queueMu sync.Mutex
+	queues  map[string]*queue.CallQueue // inbound calls waiting for a slot, by trunk ID
 
 	handler Handler
 	conf    *config.Config
 
 	res mediaRes
+	moh *mixer.MusicOnHoldSource // optional
 }
 
 type inProgressInvite struct {
@@ -100,7 +196,7 @@ type inProgressInvite struct {
 	challenge digest.Challenge
 }
 
-func NewServer(conf *config.Config, log logger.Logger, mon *stats.Monitor) *Server {
+func NewServer(conf *config.Config, log logger.Logger, mon *stats.Monitor, ports *rtp.PortPool, hook *webhook.Notifier) *Server {
 	if log == nil {
 		log = logger.GetLogger()
 	}
@@ -108,7 +204,15 @@ func NewServer(conf *config.Config, log logger.Logger, mon *stats.Monitor) *Serv
 		log:               log,
 		conf:              conf,
 		mon:               mon,
+		ports:             ports,
+		hook:              hook,
+		connectRoom:       connectLiveKit,
 		activeCalls:       make(map[string]*inboundCall),
+		dialogs:           newDialogRegistry(),
+		presence:          presence.NewManager(log),
+		mwi:               mwi.NewManager(log),
+		park:              parking.NewLot(conf.ParkingMaxSlots),
+		queues:            make(map[string]*queue.CallQueue),
 		inProgressInvites: []*inProgressInvite{},
 	}
 	s.initMediaRes()
@@ -157,6 +261,22 @@ func (s *Server) Start(agent *sipgo.UserAgent, unhandled sipgo.RequestHandler) e
 	}
 	s.log.Infow("server starting", "local", s.signalingIpLocal, "external", s.signalingIp)
 
+	if err = s.loadMediaFiles(); err != nil {
+		return err
+	}
+	if s.conf.MusicOnHoldFile != "" {
+		if s.moh, err = mixer.LoadMusicOnHoldFile(s.conf.MusicOnHoldFile); err != nil {
+			return err
+		}
+	} else if s.conf.MusicOnHoldURL != "" {
+		s.moh = mixer.NewMusicOnHoldURL(s.conf.MusicOnHoldURL)
+	}
+	if s.conf.DTLSSRTPEnabled {
+		if s.dtlsCert, err = dtls.GenerateCertificate(); err != nil {
+			return err
+		}
+	}
+
 	if agent == nil {
 		ua, err := sipgo.NewUA(
 			sipgo.WithUserAgent(UserAgent),
@@ -172,31 +292,104 @@ func (s *Server) Start(agent *sipgo.UserAgent, unhandled sipgo.RequestHandler) e
 		return err
 	}
 
+	s.sipCli, err = sipgo.NewClient(agent, sipgo.WithClientHostname(s.signalingIp))
+	if err != nil {
+		return err
+	}
+	s.pub, err = publish.NewPublisher(s.sipCli, s.conf.PublishURI, s.conf.PublishExpires, s.log)
+	if err != nil {
+		return err
+	}
+
 	s.sipSrv.OnInvite(s.onInvite)
 	s.sipSrv.OnBye(s.onBye)
+	s.sipSrv.OnSubscribe(s.onSubscribe)
+	s.sipSrv.OnMessage(s.onMessage)
+	s.sipSrv.OnInfo(s.onInfo)
+	s.sipSrv.OnNotify(s.onNotify)
+	s.sipSrv.OnRefer(s.onRefer)
+	s.sipSrv.OnUpdate(s.onUpdate)
+	s.sipSrv.OnPrack(s.onPrack)
+	if err = s.startPresenceWebhook(); err != nil {
+		return err
+	}
+	if err = s.startControlWS(); err != nil {
+		return err
+	}
 	s.sipUnhandled = unhandled
 
 	// Ignore ACKs
 	s.sipSrv.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {})
 
-	lis, err := net.ListenUDP("udp", &net.UDPAddr{
-		IP:   net.IPv4(0, 0, 0, 0),
-		Port: s.conf.SIPPort,
-	})
-	if err != nil {
-		return fmt.Errorf("cannot listen on the signaling port %d: %w", s.conf.SIPPort, err)
+	for _, l := range s.conf.SIPListeners() {
+		if err = s.startListener(l); err != nil {
+			return err
+		}
 	}
-	s.sipConn = lis
+	return nil
+}
+
+// startListener starts serving SIP on a given transport. All listeners share the same request handlers.
+func (s *Server) startListener(l config.ListenerConfig) error {
+	addr := net.JoinHostPort(l.Address, strconv.Itoa(l.Port))
+	var (
+		lis   io.Closer
+		serve func() error
+	)
+	switch l.Transport {
+	case "udp":
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return fmt.Errorf("cannot listen on the signaling port %d: %w", l.Port, err)
+		}
+		lis, serve = conn, func() error { return s.sipSrv.ServeUDP(conn) }
+	case "tcp":
+		tl, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("cannot listen on the signaling port %d/tcp: %w", l.Port, err)
+		}
+		lis, serve = tl, func() error { return s.sipSrv.ServeTCP(tl) }
+	case "tls":
+		cert, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile)
+		if err != nil {
+			return fmt.Errorf("cannot load TLS certificate: %w", err)
+		}
+		tl, err := tls.Listen("tcp", addr, &tls.Config{Certificates: []tls.Certificate{cert}})
+		if err != nil {
+			return fmt.Errorf("cannot listen on the signaling port %d/tls: %w", l.Port, err)
+		}
+		lis, serve = tl, func() error { return s.sipSrv.ServeTLS(tl) }
+	default:
+		return fmt.Errorf("unsupported SIP transport: %q", l.Transport)
+	}
+	s.sipListeners = append(s.sipListeners, lis)
+	s.log.Infow("SIP listener started", "transport", l.Transport, "addr", addr)
 
 	go func() {
-		if err := s.sipSrv.ServeUDP(lis); err != nil {
-			panic(fmt.Errorf("SIP listen UDP error: %w", err))
+		if err := serve(); err != nil && !errors.Is(err, net.ErrClosed) {
+			panic(fmt.Errorf("SIP listen %s error: %w", l.Transport, err))
 		}
 	}()
-
 	return nil
 }
 
+// contactHeader returns a Contact header pointing to the listener that received the request.
+func (s *Server) contactHeader(req *sip.Request) *sip.ContactHeader {
+	transport := strings.ToLower(req.Transport())
+	uri := sip.Uri{Host: s.signalingIp, Port: s.conf.SIPPort}
+	for _, l := range s.conf.SIPListeners() {
+		if l.Transport == transport {
+			uri.Port = l.Port
+			break
+		}
+	}
+	if transport != "" && transport != "udp" {
+		uri.UriParams = sip.NewParams()
+		uri.UriParams.Add("transport", transport)
+	}
+	return &sip.ContactHeader{Address: uri}
+}
+
 func (s *Server) Stop() {
 	s.cmu.Lock()
 	calls := maps.Values(s.activeCalls)
@@ -208,7 +401,18 @@ func (s *Server) Stop() {
 	if s.sipSrv != nil {
 		s.sipSrv.Close()
 	}
-	if s.sipConn != nil {
-		s.sipConn.Close()
+	s.pub.Close()
+	if s.sipCli != nil {
+		s.sipCli.Close()
+	}
+	if s.presenceSrv != nil {
+		_ = s.presenceSrv.Close()
+	}
+	if s.controlSrv != nil {
+		_ = s.controlSrv.Close()
+	}
+	for _, l := range s.sipListeners {
+		_ = l.Close()
 	}
+	s.sipListeners = nil
 }
@@ -0,0 +1,123 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	mrand "math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/livekit/protocol/logger"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+// writeTestCert writes a self-signed certificate and a key to a temp directory.
+func writeTestCert(t *testing.T, ip string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "livekit-sip-test"},
+		IPAddresses:  []net.IP{net.ParseIP(ip)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func TestService_Listeners(t *testing.T) {
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+	sipPort := mrand.Intn(testPortSIPMax-testPortSIPMin) + testPortSIPMin
+	tlsPort := sipPort + 1
+	certFile, keyFile := writeTestCert(t, localIP)
+
+	conf := &config.Config{
+		SIPPort:    sipPort,
+		RTPPortMin: testPortRTPMin,
+		RTPPortMax: testPortRTPMax,
+		Listeners: []config.ListenerConfig{
+			{Transport: "udp"},
+			{Transport: "tcp"},
+			{Transport: "tls", Port: tlsPort, CertFile: certFile, KeyFile: keyFile},
+		},
+	}
+	s, err := NewService(conf, logger.GetLogger())
+	require.NoError(t, err)
+	t.Cleanup(s.Stop)
+	require.NoError(t, s.Start())
+
+	for _, c := range []struct {
+		transport string
+		port      int
+	}{
+		{"udp", sipPort},
+		{"tcp", sipPort},
+		{"tls", tlsPort},
+	} {
+		c := c
+		t.Run(c.transport, func(t *testing.T) {
+			t.Parallel()
+			ua, err := sipgo.NewUA(
+				sipgo.WithUserAgent("test-"+c.transport),
+				sipgo.WithUserAgenTLSConfig(&tls.Config{InsecureSkipVerify: true}),
+			)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = ua.Close() })
+			cli, err := sipgo.NewClient(ua)
+			require.NoError(t, err)
+
+			uri := &sip.Uri{User: "bob", Host: localIP, Port: c.port, UriParams: sip.NewParams()}
+			uri.UriParams.Add("transport", c.transport)
+			req := sip.NewRequest(sip.MESSAGE, uri)
+			req.SetBody([]byte("hi"))
+			req.SetDestination(fmt.Sprintf("%s:%d", localIP, c.port))
+
+			tx, err := cli.TransactionRequest(req)
+			require.NoError(t, err)
+			t.Cleanup(tx.Terminate)
+			// No active calls, but the request must reach the shared handlers.
+			res := getResponseOrFail(t, tx)
+			require.Equal(t, sip.StatusCode(404), res.StatusCode)
+		})
+	}
+}
@@ -20,9 +20,12 @@ import (
 	"github.com/livekit/protocol/rpc"
 	"golang.org/x/exp/maps"
 
+	"github.com/livekit/sip/pkg/cdr"
 	"github.com/livekit/sip/pkg/config"
 	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/rtp"
 	"github.com/livekit/sip/pkg/stats"
+	"github.com/livekit/sip/pkg/webhook"
 	"github.com/livekit/sip/version"
 )
 
@@ -30,23 +33,43 @@ type Service struct {
 	conf *config.Config
 	log  logger.Logger
 	mon  *stats.Monitor
+	hook *webhook.Notifier
+	cdrs *cdr.Exporter
 	cli  *Client
 	srv  *Server
+
+	trunks *TrunkRegistry
 }
 
 func NewService(conf *config.Config, log logger.Logger) (*Service, error) {
 	if log == nil {
 		log = logger.GetLogger()
 	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
 	mon := stats.NewMonitor()
-	cli := NewClient(conf, log, mon)
+	ports := rtp.NewPortPool(int(conf.RTPPortMin), int(conf.RTPPortMax))
+	hook := webhook.NewNotifier(conf.WebhookURL, conf.WebhookSecret, log)
+	cdrs, err := newCDRExporter(conf, log)
+	if err != nil {
+		return nil, err
+	}
+	cli := NewClient(conf, log, mon, ports, hook)
 	s := &Service{
 		conf: conf,
 		log:  log,
 		mon:  mon,
+		hook: hook,
+		cdrs: cdrs,
 		cli:  cli,
 	}
-	s.srv = NewServer(conf, log, mon)
+	s.srv = NewServer(conf, log, mon, ports, hook)
+	s.cli.cdrs, s.srv.cdrs = cdrs, cdrs
+	dial := newDialer(conf, log)
+	s.cli.dial, s.srv.dial = dial, dial
+	s.trunks = NewTrunkRegistry()
+	s.cli.trunkStatus, s.srv.trunkStatus = s.trunks, s.trunks
 	return s, nil
 }
 
@@ -62,10 +85,34 @@ func (s *Service) ActiveCalls() int {
 	return activeClientCalls + activeServerCalls
 }
 
+// Trunks returns the status of all trunks used by inbound or outbound calls.
+func (s *Service) Trunks() []TrunkStatus {
+	return s.trunks.Trunks()
+}
+
 func (s *Service) Stop() {
 	s.cli.Stop()
 	s.srv.Stop()
 	s.mon.Stop()
+	s.hook.Close()
+	s.cdrs.Close()
+}
+
+// newCDRExporter creates an exporter of call detail records configured with cdr_file and cdr_webhook_url.
+// It returns nil if neither is set.
+func newCDRExporter(conf *config.Config, log logger.Logger) (*cdr.Exporter, error) {
+	var writers []cdr.CDRWriter
+	if conf.CDRFile != "" {
+		w, err := cdr.NewFileWriter(conf.CDRFile, conf.CDRFormat)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, w)
+	}
+	if conf.CDRWebhookURL != "" {
+		writers = append(writers, cdr.NewWebhookWriter(conf.CDRWebhookURL, conf.WebhookSecret))
+	}
+	return cdr.NewExporter(log, writers...), nil
 }
 
 func (s *Service) SetHandler(handler Handler) {
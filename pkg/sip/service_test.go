@@ -3,17 +3,25 @@ package sip
 import (
 	"context"
 	"fmt"
+	"maps"
 	"math/rand"
+	"net"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/emiago/sipgo"
 	"github.com/emiago/sipgo/sip"
-	"github.com/livekit/mediatransportutil/pkg/rtcconfig"
+	"github.com/frostbyte73/core"
 	"github.com/livekit/protocol/logger"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+	"github.com/pion/sdp/v2"
+	"github.com/pion/webrtc/v3"
 	"github.com/stretchr/testify/require"
 
 	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/media/srtp/dtls"
 )
 
 const (
@@ -49,6 +57,8 @@ func expectNoResponse(t *testing.T, tx sip.ClientTransaction) {
 type TestHandler struct {
 	GetAuthCredentialsFunc func(ctx context.Context, fromUser, toUser, toHost, srcAddress string) (username, password string, drop bool, err error)
 	DispatchCallFunc       func(ctx context.Context, info *CallInfo) CallDispatch
+	SubscribeMWIFunc       func(ctx context.Context, sub *MWISubscription) (string, error) // optional
+	CallEndedFunc          func(ctx context.Context, info *CallInfo, reason string)        // optional
 }
 
 func (h TestHandler) GetAuthCredentials(ctx context.Context, fromUser, toUser, toHost, srcAddress string) (username, password string, drop bool, err error) {
@@ -56,10 +66,229 @@ func (h TestHandler) GetAuthCredentials(ctx context.Context, fromUser, toUser, t
 }
 
 func (h TestHandler) DispatchCall(ctx context.Context, info *CallInfo) CallDispatch {
-	return h.DispatchCall(ctx, info)
+	return h.DispatchCallFunc(ctx, info)
+}
+
+func (h TestHandler) SubscribeMWI(ctx context.Context, sub *MWISubscription) (string, error) {
+	if h.SubscribeMWIFunc == nil {
+		return "", nil
+	}
+	return h.SubscribeMWIFunc(ctx, sub)
+}
+
+func (h TestHandler) CallEnded(ctx context.Context, info *CallInfo, reason string) {
+	if h.CallEndedFunc != nil {
+		h.CallEndedFunc(ctx, info, reason)
+	}
+}
+
+// testRoomConn is a fake LiveKit room connection.
+type testRoomConn struct {
+	rc     lkRoomConfig
+	data   chan lksdk.DataPacket
+	others []string // identities of other SIP participants in the room
+	closed core.Fuse
+
+	mu    sync.Mutex
+	attrs map[string]string
+}
+
+// newTestRoomConnector returns a room connector which sends all new room connections to a given channel.
+func newTestRoomConnector(joined chan<- *testRoomConn) roomConnector {
+	return func(conf *config.Config, rc lkRoomConfig, cb *lksdk.RoomCallback) (roomConn, error) {
+		c := &testRoomConn{rc: rc, data: make(chan lksdk.DataPacket, 10), attrs: maps.Clone(rc.attrs)}
+		joined <- c
+		return c, nil
+	}
+}
+
+// setTestRoomConn connects the room to a fake LiveKit room and returns a channel with data packets sent to it.
+func setTestRoomConn(r *Room) <-chan lksdk.DataPacket {
+	c := &testRoomConn{data: make(chan lksdk.DataPacket, 10)}
+	r.room = c
+	r.ready.Store(true)
+	return c.data
+}
+
+func (c *testRoomConn) SID() string {
+	return "PA_" + c.rc.identity
+}
+
+func (c *testRoomConn) Identity() string {
+	return c.rc.identity
+}
+
+func (c *testRoomConn) SIPParticipants() []string {
+	return append([]string{c.rc.identity}, c.others...)
+}
+
+func (c *testRoomConn) PublishTrack(track webrtc.TrackLocal, opts *lksdk.TrackPublicationOptions) error {
+	return nil
+}
+
+func (c *testRoomConn) PublishDataPacket(data lksdk.DataPacket, opts ...lksdk.DataPublishOption) error {
+	select {
+	case c.data <- data:
+	default:
+	}
+	return nil
+}
+
+func (c *testRoomConn) SetAttributes(attrs map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.attrs == nil {
+		c.attrs = make(map[string]string)
+	}
+	for k, v := range attrs {
+		if v == "" {
+			delete(c.attrs, k)
+		} else {
+			c.attrs[k] = v
+		}
+	}
+}
+
+// Attributes returns current attributes of the participant.
+func (c *testRoomConn) Attributes() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return maps.Clone(c.attrs)
+}
+
+func (c *testRoomConn) Disconnect() {
+	c.closed.Break()
+}
+
+// testPhone places calls to the service and accepts requests sent by the service in these dialogs.
+type testPhone struct {
+	cli    *sipgo.Client
+	bye    chan *sip.Request
+	notify chan *sip.Request
+	info   chan *sip.Request
+	invite chan *sip.Request // re-INVITEs; answered with the default offer
+	update chan *sip.Request // UPDATEs; answered the same way as re-INVITEs
+}
+
+func newTestPhone(t *testing.T, user string) *testPhone {
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(localIP)})
+	require.NoError(t, err)
+
+	ua, err := sipgo.NewUA(sipgo.WithUserAgent(user))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ua.Close() })
+	srv, err := sipgo.NewServer(ua)
+	require.NoError(t, err)
+	p := &testPhone{bye: make(chan *sip.Request, 1), notify: make(chan *sip.Request, 1), info: make(chan *sip.Request, 1), invite: make(chan *sip.Request, 1), update: make(chan *sip.Request, 1)}
+	srv.OnBye(func(req *sip.Request, tx sip.ServerTransaction) {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+		p.bye <- req
+	})
+	srv.OnNotify(func(req *sip.Request, tx sip.ServerTransaction) {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+		select {
+		case p.notify <- req:
+		default:
+		}
+	})
+	answerOffer := func(offers chan<- *sip.Request) sipgo.RequestHandler {
+		return func(req *sip.Request, tx sip.ServerTransaction) {
+			answer, err := sdpGenerateOffer(localIP, 0xB0B)
+			if err != nil {
+				_ = tx.Respond(sip.NewResponseFromRequest(req, 500, "Server Error", nil))
+				return
+			}
+			res := sip.NewResponseFromRequest(req, 200, "OK", answer)
+			res.AppendHeader(&contentTypeHeaderSDP)
+			_ = tx.Respond(res)
+			select {
+			case offers <- req:
+			default:
+			}
+		}
+	}
+	srv.OnInvite(answerOffer(p.invite))
+	srv.OnUpdate(answerOffer(p.update))
+	srv.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {})
+	srv.OnInfo(func(req *sip.Request, tx sip.ServerTransaction) {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+		select {
+		case p.info <- req:
+		default:
+		}
+	})
+	ready := &readyConn{PacketConn: conn, ready: make(chan struct{})}
+	go func() {
+		_ = srv.ServeUDP(ready)
+	}()
+	// Client picks the listener registered by ServeUDP, so it must not be used before that.
+	<-ready.ready
+	p.cli, err = sipgo.NewClient(ua, sipgo.WithClientHostname(localIP))
+	require.NoError(t, err)
+	return p
+}
+
+// readyConn signals when the first read starts, which happens after the UDP listener is registered.
+type readyConn struct {
+	net.PacketConn
+	once  sync.Once
+	ready chan struct{}
+}
+
+func (c *readyConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.once.Do(func() { close(c.ready) })
+	return c.PacketConn.ReadFrom(b)
+}
+
+// Call places a call to the service and acknowledges the answer. It returns the INVITE and the final response.
+// Default offer is used if it's not set.
+func (p *testPhone) Call(t *testing.T, addr, to string, offer []byte, headers ...sip.Header) (*sip.Request, *sip.Response) {
+	if offer == nil {
+		localIP, err := config.GetLocalIP()
+		require.NoError(t, err)
+		offer, err = sdpGenerateOffer(localIP, 0xB0B)
+		require.NoError(t, err)
+	}
+
+	req := sip.NewRequest(sip.INVITE, &sip.Uri{User: to, Host: addr})
+	req.SetDestination(addr)
+	req.SetBody(offer)
+	req.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+	for _, h := range headers {
+		req.AppendHeader(h)
+	}
+	tx, err := p.cli.TransactionRequest(req)
+	require.NoError(t, err)
+	t.Cleanup(tx.Terminate)
+	for {
+		res := getResponseOrFail(t, tx)
+		if res.IsProvisional() {
+			continue
+		}
+		require.Equal(t, sip.StatusCode(200), res.StatusCode)
+		require.NoError(t, p.cli.WriteRequest(sip.NewAckRequest(req, res, nil)))
+		return req, res
+	}
+}
+
+type testInviteOptions struct {
+	RTPPortMin uint16 // test range is used by default
+	RTPPortMax uint16
+	Headers    []sip.Header
+	Setup      func(s *Service)
+	Offer      []byte // default offer is used if not set
 }
 
 func testInvite(t *testing.T, h Handler, from, to string, test func(tx sip.ClientTransaction)) {
+	testInviteWith(t, h, testInviteOptions{}, from, to, test)
+}
+
+func testInviteWith(t *testing.T, h Handler, opts testInviteOptions, from, to string, test func(tx sip.ClientTransaction)) {
+	if opts.RTPPortMin == 0 && opts.RTPPortMax == 0 {
+		opts.RTPPortMin, opts.RTPPortMax = testPortRTPMin, testPortRTPMax
+	}
 	sipPort := rand.Intn(testPortSIPMax-testPortSIPMin) + testPortSIPMin
 	localIP, err := config.GetLocalIP()
 	require.NoError(t, err)
@@ -67,12 +296,16 @@ func testInvite(t *testing.T, h Handler, from, to string, test func(tx sip.Clien
 	sipServerAddress := fmt.Sprintf("%s:%d", localIP, sipPort)
 
 	s, err := NewService(&config.Config{
-		SIPPort: sipPort,
-		RTPPort: rtcconfig.PortRange{Start: testPortRTPMin, End: testPortRTPMax},
+		SIPPort:    sipPort,
+		RTPPortMin: opts.RTPPortMin,
+		RTPPortMax: opts.RTPPortMax,
 	}, logger.GetLogger())
 	require.NoError(t, err)
 	require.NotNil(t, s)
 	t.Cleanup(s.Stop)
+	if opts.Setup != nil {
+		opts.Setup(s)
+	}
 
 	s.SetHandler(h)
 
@@ -84,14 +317,20 @@ func testInvite(t *testing.T, h Handler, from, to string, test func(tx sip.Clien
 	sipClient, err := sipgo.NewClient(sipUserAgent)
 	require.NoError(t, err)
 
-	offer, err := sdpGenerateOffer(localIP, 0xB0B)
-	require.NoError(t, err)
+	offer := opts.Offer
+	if offer == nil {
+		offer, err = sdpGenerateOffer(localIP, 0xB0B)
+		require.NoError(t, err)
+	}
 
 	inviteRecipent := &sip.Uri{User: to, Host: sipServerAddress}
 	inviteRequest := sip.NewRequest(sip.INVITE, inviteRecipent)
 	inviteRequest.SetDestination(sipServerAddress)
 	inviteRequest.SetBody(offer)
 	inviteRequest.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+	for _, hdr := range opts.Headers {
+		inviteRequest.AppendHeader(hdr)
+	}
 
 	tx, err := sipClient.TransactionRequest(inviteRequest)
 	require.NoError(t, err)
@@ -142,3 +381,370 @@ func TestService_AuthDrop(t *testing.T) {
 		expectNoResponse(t, tx)
 	})
 }
+
+func TestService_NoRTPPorts(t *testing.T) {
+	const (
+		expectedFromUser = "foo"
+		expectedToUser   = "bar"
+	)
+	// Occupy the only RTP port available to the service.
+	busy, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: testPortRTPMin})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = busy.Close() })
+
+	h := acceptHandler("room", "")
+	opts := testInviteOptions{
+		RTPPortMin: testPortRTPMin,
+		RTPPortMax: testPortRTPMin,
+	}
+	testInviteWith(t, h, opts, expectedFromUser, expectedToUser, func(tx sip.ClientTransaction) {
+		if !inboundHidePort {
+			res := getResponseOrFail(t, tx)
+			require.Equal(t, sip.StatusCode(180), res.StatusCode)
+		}
+
+		res := getResponseOrFail(t, tx)
+		require.Equal(t, sip.StatusCode(503), res.StatusCode)
+	})
+}
+
+func TestService_RTPPoolExhausted(t *testing.T) {
+	const (
+		portMin = testPortRTPMin + 10
+		portMax = portMin + 1
+	)
+	h := acceptHandler("room", "")
+	var pool *rtp.PortPool
+	opts := testInviteOptions{
+		RTPPortMin: portMin,
+		RTPPortMax: portMax,
+		Setup: func(s *Service) {
+			// Active calls hold all ports of the pool.
+			pool = s.srv.ports
+			for i := 0; i < pool.Size(); i++ {
+				conn, err := pool.ListenUDP(net.IPv4zero)
+				require.NoError(t, err)
+				port := conn.LocalAddr().(*net.UDPAddr).Port
+				t.Cleanup(func() {
+					_ = conn.Close()
+					pool.Release(port)
+				})
+				require.GreaterOrEqual(t, port, portMin)
+				require.LessOrEqual(t, port, portMax)
+			}
+		},
+	}
+	testInviteWith(t, h, opts, "foo", "bar", func(tx sip.ClientTransaction) {
+		if !inboundHidePort {
+			res := getResponseOrFail(t, tx)
+			require.Equal(t, sip.StatusCode(180), res.StatusCode)
+		}
+		res := getResponseOrFail(t, tx)
+		require.Equal(t, sip.StatusCode(503), res.StatusCode)
+		require.Equal(t, 2, pool.InUse())
+	})
+}
+
+func TestService_DTLSNotEnabled(t *testing.T) {
+	h := acceptHandler("room", "")
+	offer, err := sdpGenerateOfferWithDTLS("127.0.0.1", 0xB0B, getCodecs(), &sdpDTLS{Fingerprint: "sha-256 AB:CD", Setup: dtls.RoleActPass})
+	require.NoError(t, err)
+	testInviteWith(t, h, testInviteOptions{Offer: offer}, "foo", "bar", func(tx sip.ClientTransaction) {
+		if !inboundHidePort {
+			res := getResponseOrFail(t, tx)
+			require.Equal(t, sip.StatusCode(180), res.StatusCode)
+		}
+		res := getResponseOrFail(t, tx)
+		require.Equal(t, sip.StatusCode(488), res.StatusCode)
+	})
+}
+
+func TestService_DTLSSRTP(t *testing.T) {
+	h := acceptHandler("room", "")
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+	conn := rtp.NewConn(nil)
+	require.NoError(t, conn.ListenAndServe(0, 0, "0.0.0.0"))
+	t.Cleanup(func() { _ = conn.Close() })
+	conn.RequireCipher()
+	cert, err := dtls.GenerateCertificate()
+	require.NoError(t, err)
+
+	offer, err := sdpGenerateOfferWithDTLS(localIP, conn.LocalAddr().Port, getCodecs(), &sdpDTLS{Fingerprint: cert.Fingerprint(), Setup: dtls.RoleActPass})
+	require.NoError(t, err)
+	opts := testInviteOptions{
+		Offer: offer,
+		Setup: func(s *Service) {
+			s.conf.DTLSSRTPEnabled = true
+		},
+	}
+	testInviteWith(t, h, opts, "foo", "bar", func(tx sip.ClientTransaction) {
+		if !inboundHidePort {
+			res := getResponseOrFail(t, tx)
+			require.Equal(t, sip.StatusCode(180), res.StatusCode)
+		}
+		res := getResponseOrFail(t, tx)
+		require.Equal(t, sip.StatusCode(200), res.StatusCode)
+
+		var answer sdp.SessionDescription
+		require.NoError(t, answer.Unmarshal(res.Body()))
+		remote := sdpGetDTLS(answer)
+		require.NotNil(t, remote)
+		// Answerer initiates the handshake.
+		require.Equal(t, dtls.RoleActive, remote.Setup)
+		conn.SetDestAddr(sdpGetAudioDest(answer))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		sess, err := dtls.Handshake(ctx, conn.DTLSConn(), cert, dtls.OffererRole(remote.Setup), remote.Fingerprint)
+		require.NoError(t, err)
+		_ = sess.Close()
+	})
+}
+
+func TestService_Replaces(t *testing.T) {
+	const (
+		replacedSIPCallID = "replaced-call@example.com"
+		replacedTag       = "transferor-tag"
+		replacedCallID    = "SCL_replaced"
+		replacedRoom      = "transfer-room"
+	)
+	setup := func(s *Service) {
+		s.srv.dialogs.Register(replacedSIPCallID, replacedTag, dialogInfo{CallID: replacedCallID, RoomName: replacedRoom, LocalTag: "abc"})
+	}
+	t.Run("known dialog", func(t *testing.T) {
+		infos := make(chan *CallInfo, 1)
+		h := &TestHandler{
+			GetAuthCredentialsFunc: noAuth,
+			DispatchCallFunc: func(ctx context.Context, info *CallInfo) CallDispatch {
+				infos <- info
+				return CallDispatch{Result: DispatchNoRuleReject}
+			},
+		}
+		opts := testInviteOptions{
+			Headers: []sip.Header{sip.NewHeader("Replaces", replacedSIPCallID+";to-tag=abc;from-tag="+replacedTag)},
+			Setup:   setup,
+		}
+		testInviteWith(t, h, opts, "foo", "bar", func(tx sip.ClientTransaction) {
+			if !inboundHidePort {
+				res := getResponseOrFail(t, tx)
+				require.Equal(t, sip.StatusCode(180), res.StatusCode)
+			}
+			res := getResponseOrFail(t, tx)
+			require.Equal(t, sip.StatusCode(400), res.StatusCode)
+
+			info := <-infos
+			require.Equal(t, replacedCallID, info.ReplaceCallID)
+			require.Equal(t, replacedRoom, info.ReplaceRoomName)
+		})
+	})
+	for name, replaces := range map[string]string{
+		"unknown dialog": "unknown@example.com;to-tag=abc;from-tag=" + replacedTag,
+		"unknown to-tag": replacedSIPCallID + ";to-tag=xyz;from-tag=" + replacedTag,
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := &TestHandler{
+				GetAuthCredentialsFunc: noAuth,
+				DispatchCallFunc: func(ctx context.Context, info *CallInfo) CallDispatch {
+					t.Error("unexpected dispatch")
+					return CallDispatch{Result: DispatchNoRuleReject}
+				},
+			}
+			opts := testInviteOptions{
+				Headers: []sip.Header{sip.NewHeader("Replaces", replaces)},
+				Setup:   setup,
+			}
+			testInviteWith(t, h, opts, "foo", "bar", func(tx sip.ClientTransaction) {
+				if !inboundHidePort {
+					res := getResponseOrFail(t, tx)
+					require.Equal(t, sip.StatusCode(180), res.StatusCode)
+				}
+				res := getResponseOrFail(t, tx)
+				require.Equal(t, sip.StatusCode(481), res.StatusCode)
+			})
+		})
+	}
+}
+
+func TestService_Diversion(t *testing.T) {
+	infos := make(chan *CallInfo, 1)
+	h := &TestHandler{
+		GetAuthCredentialsFunc: noAuth,
+		DispatchCallFunc: func(ctx context.Context, info *CallInfo) CallDispatch {
+			infos <- info
+			return CallDispatch{Result: DispatchNoRuleReject}
+		},
+	}
+	opts := testInviteOptions{
+		Headers: []sip.Header{
+			sip.NewHeader("Diversion", `"Office" <sip:+15550002@example.com>;reason=no-answer;counter=1, <sip:+15550001@example.com;user=phone>;reason=unconditional`),
+			sip.NewHeader("Diversion", `<tel:+15550000>;reason="user-busy";counter=2;privacy=off`),
+		},
+	}
+	testInviteWith(t, h, opts, "foo", "bar", func(tx sip.ClientTransaction) {
+		if !inboundHidePort {
+			res := getResponseOrFail(t, tx)
+			require.Equal(t, sip.StatusCode(180), res.StatusCode)
+		}
+		res := getResponseOrFail(t, tx)
+		require.Equal(t, sip.StatusCode(400), res.StatusCode)
+
+		info := <-infos
+		require.Len(t, info.DiversionHeader, 3)
+		var (
+			users   []string
+			reasons []string
+		)
+		for _, d := range info.DiversionHeader {
+			users = append(users, d.User)
+			reasons = append(reasons, d.Reason)
+		}
+		require.Equal(t, []string{"+15550002", "+15550001", "+15550000"}, users)
+		require.Equal(t, []string{"no-answer", "unconditional", "user-busy"}, reasons)
+		require.Equal(t, 2, info.DiversionHeader[2].Counter)
+		require.Equal(t, "tel:+15550000", info.DiversionHeader[2].URI)
+	})
+}
+
+func TestService_AttendedTransfer(t *testing.T) {
+	joined := make(chan *testRoomConn, 2)
+	s, addr := startTestService(t, &config.Config{}, func(s *Service) {
+		s.srv.connectRoom = newTestRoomConnector(joined)
+		s.SetHandler(&TestHandler{
+			GetAuthCredentialsFunc: noAuth,
+			DispatchCallFunc: func(ctx context.Context, info *CallInfo) CallDispatch {
+				// Dispatch rules send the transfer target to a different room, but it must join the room of the replaced call.
+				return CallDispatch{Result: DispatchAccept, RoomName: info.FromUser + "-room", Identity: "sip_" + info.FromUser}
+			},
+		})
+	})
+	expectJoin := func(identity string) *testRoomConn {
+		t.Helper()
+		select {
+		case c := <-joined:
+			require.Equal(t, identity, c.rc.identity)
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatal("call did not join the room")
+			return nil
+		}
+	}
+
+	// Transferor is in the room. It calls the transfer target separately.
+	alice := newTestPhone(t, "alice")
+	aliceReq, aliceRes := alice.Call(t, addr, "transfer", nil)
+	aliceRoom := expectJoin("sip_alice")
+	require.Equal(t, "alice-room", aliceRoom.rc.roomName)
+
+	// Transfer target replaces the transferor's call.
+	callIDHdr, _ := aliceReq.CallID()
+	callID := callIDHdr.Value()
+	require.Equal(t, map[string]string{
+		AttrCallID: callID,
+		AttrFrom:   "alice",
+		AttrTo:     "transfer",
+		AttrHold:   "false",
+	}, aliceRoom.Attributes())
+	from, _ := aliceReq.From()
+	fromTag, _ := from.Params.Get("tag")
+	to, _ := aliceRes.To()
+	toTag, _ := to.Params.Get("tag")
+	replaces := fmt.Sprintf("%s;to-tag=%s;from-tag=%s", callID, toTag, fromTag)
+	carol := newTestPhone(t, "carol")
+	carol.Call(t, addr, "transfer", nil, sip.NewHeader("Replaces", replaces))
+	carolRoom := expectJoin("sip_carol")
+	require.Equal(t, "alice-room", carolRoom.rc.roomName)
+
+	// Replaced call is torn down.
+	select {
+	case bye := <-alice.bye:
+		byeCallID, _ := bye.CallID()
+		require.Equal(t, callID, byeCallID.Value())
+	case <-time.After(5 * time.Second):
+		t.Fatal("replaced call was not hung up")
+	}
+	select {
+	case <-aliceRoom.closed.Watch():
+	case <-time.After(time.Second):
+		t.Fatal("replaced call did not leave the room")
+	}
+	s.srv.cmu.RLock()
+	_, ok := s.srv.activeCalls[fromTag]
+	s.srv.cmu.RUnlock()
+	require.False(t, ok)
+}
+
+func TestService_CallEnded(t *testing.T) {
+	joined := make(chan *testRoomConn, 1)
+	ended := make(chan *CallInfo, 1)
+	s, addr := startTestService(t, &config.Config{}, func(s *Service) {
+		s.srv.connectRoom = newTestRoomConnector(joined)
+		s.SetHandler(&TestHandler{
+			GetAuthCredentialsFunc: noAuth,
+			DispatchCallFunc: func(ctx context.Context, info *CallInfo) CallDispatch {
+				require.False(t, info.StartedAt.IsZero())
+				require.Zero(t, info.Duration())
+				return CallDispatch{Result: DispatchAccept, RoomName: "room", Identity: "sip_alice"}
+			},
+			CallEndedFunc: func(ctx context.Context, info *CallInfo, reason string) {
+				ended <- info
+			},
+		})
+	})
+
+	alice := newTestPhone(t, "alice")
+	req, _ := alice.Call(t, addr, "bob", nil)
+	answered := time.Now()
+	select {
+	case <-joined:
+	case <-time.After(5 * time.Second):
+		t.Fatal("call did not join the room")
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	from, _ := req.From()
+	fromTag, _ := from.Params.Get("tag")
+	s.srv.cmu.RLock()
+	call := s.srv.activeCalls[fromTag]
+	s.srv.cmu.RUnlock()
+	require.NotNil(t, call)
+	_ = call.Close()
+	select {
+	case <-alice.bye:
+	case <-time.After(5 * time.Second):
+		t.Fatal("call was not hung up")
+	}
+	select {
+	case info := <-ended:
+		require.Equal(t, "alice", info.FromUser)
+		require.False(t, info.AnsweredAt.Before(info.StartedAt))
+		require.InDelta(t, info.EndedAt.Sub(answered), info.Duration(), float64(100*time.Millisecond))
+	case <-time.After(5 * time.Second):
+		t.Fatal("call ended event was not sent")
+	}
+}
+
+func TestService_InviteLatency(t *testing.T) {
+	const calls = 100
+	joined := make(chan *testRoomConn, calls)
+	s, addr := startTestService(t, &config.Config{}, func(s *Service) {
+		s.srv.connectRoom = newTestRoomConnector(joined)
+		s.SetHandler(acceptHandler("room", ""))
+	})
+	alice := newTestPhone(t, "alice")
+	for i := 0; i < calls; i++ {
+		req, res := alice.Call(t, addr, "latency", nil)
+		// Hang up, so that RTP ports are available for the next call.
+		require.Equal(t, sip.StatusCode(200), sendTestRequest(t, addr, "alice", newTestPhoneRequest(sip.BYE, addr, req, res)).StatusCode)
+	}
+	require.Eventually(t, func() bool {
+		return s.ActiveCalls() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	p99, n := testHistogramQuantile(t, "livekit_sip_invite_processing_duration_seconds", "inbound", 0.99)
+	require.EqualValues(t, calls, n)
+	require.LessOrEqual(t, p99, 0.25)
+	p99, n = testHistogramQuantile(t, "livekit_sip_sdp_negotiation_duration_seconds", "inbound", 0.99)
+	require.EqualValues(t, calls, n)
+	require.LessOrEqual(t, p99, 0.01)
+}
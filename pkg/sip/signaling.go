@@ -30,6 +30,7 @@ import (
 	"github.com/livekit/sip/pkg/media/dtmf"
 	"github.com/livekit/sip/pkg/media/rtp"
 	lksdp "github.com/livekit/sip/pkg/media/sdp"
+	"github.com/livekit/sip/pkg/media/srtp/dtls"
 )
 
 func getCodecs() []sdpCodecInfo {
@@ -71,11 +72,46 @@ type sdpCodecInfo struct {
 	Codec media.Codec
 }
 
+// codecMatches checks if the codec has a given name: either the full SDP name (e.g. "PCMU/8000"), or only the encoding.
+func codecMatches(c media.Codec, name string) bool {
+	sdpName := c.Info().SDPName
+	if strings.EqualFold(sdpName, name) {
+		return true
+	}
+	enc, _, _ := strings.Cut(sdpName, "/")
+	return strings.EqualFold(enc, name)
+}
+
+// codecRank returns the position of the codec in the preference list. Codecs that are not listed are ranked last.
+func codecRank(pref []string, c media.Codec) int {
+	for i, name := range pref {
+		if codecMatches(c, name) {
+			return i
+		}
+	}
+	return len(pref)
+}
+
+// sdpCodecsWithPreference orders codecs by the trunk preference. Codecs that are not listed keep the default order.
+func sdpCodecsWithPreference(codecs []sdpCodecInfo, pref []string) []sdpCodecInfo {
+	if len(pref) == 0 {
+		return codecs
+	}
+	codecs = slices.Clone(codecs)
+	slices.SortStableFunc(codecs, func(a, b sdpCodecInfo) int {
+		return codecRank(pref, a.Codec) - codecRank(pref, b.Codec)
+	})
+	return codecs
+}
+
 func sdpMediaOffer(rtpListenerPort int) []*sdp.MediaDescription {
+	return sdpMediaOfferWith(rtpListenerPort, getCodecs())
+}
+
+func sdpMediaOfferWith(rtpListenerPort int, codecs []sdpCodecInfo) []*sdp.MediaDescription {
 	// Static compiler check for sample rate hardcoded below.
 	var _ = [1]struct{}{}[8000-rtp.DefSampleRate]
 
-	codecs := getCodecs()
 	attrs := make([]sdp.Attribute, 0, len(codecs)+4)
 	formats := make([]string, 0, len(codecs))
 	dtmfType := -1
@@ -98,6 +134,7 @@ func sdpMediaOffer(rtpListenerPort int) []*sdp.MediaDescription {
 	attrs = append(attrs, []sdp.Attribute{
 		{Key: "ptime", Value: "20"},
 		{Key: "maxptime", Value: "150"},
+		{Key: "rtcp-mux"},
 		{Key: "sendrecv"},
 	}...)
 
@@ -114,7 +151,7 @@ func sdpMediaOffer(rtpListenerPort int) []*sdp.MediaDescription {
 	}
 }
 
-func sdpAnswerMediaDesc(rtpListenerPort int, res *sdpCodecResult) []*sdp.MediaDescription {
+func sdpAnswerMediaDesc(rtpListenerPort int, res *sdpCodecResult, dir string) []*sdp.MediaDescription {
 	// Static compiler check for sample rate hardcoded below.
 	var _ = [1]struct{}{}[8000-rtp.DefSampleRate]
 
@@ -128,28 +165,159 @@ func sdpAnswerMediaDesc(rtpListenerPort int, res *sdpCodecResult) []*sdp.MediaDe
 			{Key: "fmtp", Value: fmt.Sprintf("%d 0-16", res.DTMFType)},
 		}...)
 	}
+	formats := []string{"0", "101"}
+	if res.REDType != 0 {
+		// Clock rate and channels of RED match the audio codec.
+		_, params, _ := strings.Cut(res.Audio.Info().SDPName, "/")
+		attrs = append(attrs, []sdp.Attribute{
+			{Key: "rtpmap", Value: fmt.Sprintf("%d %s/%s", res.REDType, rtp.REDSDPName, params)},
+			{Key: "fmtp", Value: fmt.Sprintf("%d %d/%d", res.REDType, res.AudioType, res.AudioType)},
+		}...)
+		formats = append(formats, strconv.Itoa(int(res.REDType)))
+	}
 	attrs = append(attrs, []sdp.Attribute{
 		{Key: "ptime", Value: "20"},
 		{Key: "maxptime", Value: "150"},
-		{Key: "sendrecv"},
 	}...)
-	return []*sdp.MediaDescription{
-		{
-			MediaName: sdp.MediaName{
-				Media:   "audio",
-				Port:    sdp.RangedPort{Value: rtpListenerPort},
-				Protos:  []string{"RTP", "AVP"},
-				Formats: []string{"0", "101"},
-			},
-			Attributes: attrs,
+	if res.RTCPMux {
+		attrs = append(attrs, sdp.Attribute{Key: "rtcp-mux"})
+	}
+	attrs = append(attrs, sdp.Attribute{Key: dir})
+	m := &sdp.MediaDescription{
+		MediaName: sdp.MediaName{
+			Media:   "audio",
+			Port:    sdp.RangedPort{Value: rtpListenerPort},
+			Protos:  []string{"RTP", "AVP"},
+			Formats: formats,
 		},
+		Attributes: attrs,
+	}
+	sdpSetDTLS(m, res.DTLS)
+	sdpSetICE(m, res.ICE)
+	return []*sdp.MediaDescription{m}
+}
+
+// sdpDTLS holds DTLS-SRTP parameters of the media description (RFC 5763).
+type sdpDTLS struct {
+	Fingerprint string
+	Setup       dtls.Role
+}
+
+// sdpSetDTLS switches the media to DTLS-SRTP transport, if the parameters are set.
+func sdpSetDTLS(m *sdp.MediaDescription, d *sdpDTLS) {
+	if d == nil {
+		return
+	}
+	m.MediaName.Protos = strings.Split(dtls.SDPProto, "/")
+	m.Attributes = append(m.Attributes,
+		sdp.Attribute{Key: "fingerprint", Value: d.Fingerprint},
+		sdp.Attribute{Key: "setup", Value: string(d.Setup)},
+	)
+}
+
+// sdpGetDTLS returns DTLS-SRTP parameters of the audio media, or nil if it doesn't use DTLS-SRTP transport.
+func sdpGetDTLS(desc sdp.SessionDescription) *sdpDTLS {
+	audio := sdpGetAudio(desc)
+	if audio == nil || !strings.EqualFold(strings.Join(audio.MediaName.Protos, "/"), dtls.SDPProto) {
+		return nil
 	}
+	// RFC 4145 defaults to "active".
+	d := &sdpDTLS{Setup: dtls.RoleActive}
+	// Media-level attributes take precedence over the session-level ones.
+	for _, list := range [][]sdp.Attribute{desc.Attributes, audio.Attributes} {
+		for _, a := range list {
+			switch a.Key {
+			case "fingerprint":
+				d.Fingerprint = a.Value
+			case "setup":
+				d.Setup = dtls.Role(strings.ToLower(a.Value))
+			}
+		}
+	}
+	return d
+}
+
+// sdpICE holds ICE parameters of the media description (RFC 8839).
+type sdpICE struct {
+	Ufrag      string
+	Pwd        string
+	Candidates []string // values of "candidate" attributes
+}
+
+// sdpSetICE adds ICE attributes to the media, if the parameters are set. All candidates are gathered before
+// the description is sent, thus it's also marked with "end-of-candidates".
+func sdpSetICE(m *sdp.MediaDescription, ice *sdpICE) {
+	if ice == nil {
+		return
+	}
+	m.Attributes = append(m.Attributes,
+		sdp.Attribute{Key: "ice-ufrag", Value: ice.Ufrag},
+		sdp.Attribute{Key: "ice-pwd", Value: ice.Pwd},
+	)
+	for _, c := range ice.Candidates {
+		m.Attributes = append(m.Attributes, sdp.Attribute{Key: "candidate", Value: c})
+	}
+	m.Attributes = append(m.Attributes, sdp.Attribute{Key: "end-of-candidates"})
+}
+
+// sdpGetICE returns ICE parameters of the audio media, or nil if they are not set.
+func sdpGetICE(desc sdp.SessionDescription) *sdpICE {
+	audio := sdpGetAudio(desc)
+	if audio == nil {
+		return nil
+	}
+	ice := &sdpICE{}
+	// Media-level attributes take precedence over the session-level ones. Candidates are only set for the media.
+	for _, list := range [][]sdp.Attribute{desc.Attributes, audio.Attributes} {
+		for _, a := range list {
+			switch a.Key {
+			case "ice-ufrag":
+				ice.Ufrag = a.Value
+			case "ice-pwd":
+				ice.Pwd = a.Value
+			}
+		}
+	}
+	if ice.Ufrag == "" || ice.Pwd == "" {
+		return nil
+	}
+	for _, a := range audio.Attributes {
+		if a.Key == "candidate" {
+			ice.Candidates = append(ice.Candidates, a.Value)
+		}
+	}
+	return ice
+}
+
+// sdpWithICE adds ICE attributes to the audio media of the SDP.
+func sdpWithICE(data []byte, ice *sdpICE) ([]byte, error) {
+	desc := sdp.SessionDescription{}
+	if err := desc.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	audio := sdpGetAudio(desc)
+	if audio == nil {
+		return nil, errors.New("no audio in sdp")
+	}
+	sdpSetICE(audio, ice)
+	return desc.Marshal()
 }
 
 func sdpGenerateOffer(publicIp string, rtpListenerPort int) ([]byte, error) {
+	return sdpGenerateOfferWith(publicIp, rtpListenerPort, getCodecs())
+}
+
+// sdpGenerateOfferWith generates an SDP offer with a given list of codecs.
+func sdpGenerateOfferWith(publicIp string, rtpListenerPort int, codecs []sdpCodecInfo) ([]byte, error) {
+	return sdpGenerateOfferWithDTLS(publicIp, rtpListenerPort, codecs, nil)
+}
+
+// sdpGenerateOfferWithDTLS is similar to sdpGenerateOfferWith, but offers DTLS-SRTP media, if the parameters are set.
+func sdpGenerateOfferWithDTLS(publicIp string, rtpListenerPort int, codecs []sdpCodecInfo, d *sdpDTLS) ([]byte, error) {
 	sessId := rand.Uint64() // TODO: do we need to track these?
 
-	mediaDesc := sdpMediaOffer(rtpListenerPort)
+	mediaDesc := sdpMediaOfferWith(rtpListenerPort, codecs)
+	sdpSetDTLS(mediaDesc[0], d)
 	answer := sdp.SessionDescription{
 		Version: 0,
 		Origin: sdp.Origin{
@@ -182,7 +350,28 @@ func sdpGenerateOffer(publicIp string, rtpListenerPort int) ([]byte, error) {
 }
 
 func sdpGenerateAnswer(offer sdp.SessionDescription, publicIp string, rtpListenerPort int, res *sdpCodecResult) ([]byte, error) {
+	media := sdpAnswerMediaDesc(rtpListenerPort, res, sdpAnswerDirection(sdpGetDirection(offer)))
+	if audio := sdpGetAudio(offer); audio != nil {
+		if mid := sdpGetMid(audio); mid != "" {
+			media[0].Attributes = append(media[0].Attributes, sdp.Attribute{Key: "mid", Value: mid})
+		}
+	}
+	return sdpGenerateAnswerWith(offer, publicIp, media)
+}
 
+// sdpGenerateAnswerWith generates an SDP answer with given media descriptions.
+// Media with IDs from the BUNDLE group of the offer are bundled in the answer as well.
+func sdpGenerateAnswerWith(offer sdp.SessionDescription, publicIp string, media []*sdp.MediaDescription) ([]byte, error) {
+	var bundle []string
+	for _, m := range media {
+		if mid := sdpGetMid(m); mid != "" && sdpGetBundle(offer, mid) != nil {
+			bundle = append(bundle, mid)
+		}
+	}
+	var attrs []sdp.Attribute
+	if len(bundle) != 0 {
+		attrs = append(attrs, sdp.Attribute{Key: "group", Value: "BUNDLE " + strings.Join(bundle, " ")})
+	}
 	answer := sdp.SessionDescription{
 		Version: 0,
 		Origin: sdp.Origin{
@@ -207,7 +396,8 @@ func sdpGenerateAnswer(offer sdp.SessionDescription, publicIp string, rtpListene
 				},
 			},
 		},
-		MediaDescriptions: sdpAnswerMediaDesc(rtpListenerPort, res),
+		Attributes:        attrs,
+		MediaDescriptions: media,
 	}
 
 	return answer.Marshal()
@@ -222,6 +412,118 @@ func sdpGetAudio(offer sdp.SessionDescription) *sdp.MediaDescription {
 	return nil
 }
 
+// sdpGetMid returns the media ID (RFC 5888) of the media description.
+func sdpGetMid(m *sdp.MediaDescription) string {
+	mid, _ := m.Attribute("mid")
+	return mid
+}
+
+// sdpGetBundle returns media IDs of the BUNDLE group (RFC 8843) which has a given media ID, or nil if it's not bundled.
+// The first ID is the tagged media, which sets the transport of the group.
+func sdpGetBundle(desc sdp.SessionDescription, mid string) []string {
+	if mid == "" {
+		return nil
+	}
+	for _, a := range desc.Attributes {
+		if a.Key != "group" {
+			continue
+		}
+		f := strings.Fields(a.Value)
+		if len(f) > 1 && f[0] == "BUNDLE" && slices.Contains(f[1:], mid) {
+			return f[1:]
+		}
+	}
+	return nil
+}
+
+// sdpGetBundledAudio returns the first audio media description, followed by other audio media bundled with it.
+// Bundled media share the same port, thus RTP packets of all of them are demultiplexed by the payload type.
+// For example, telephone-event may be sent in a separate media description.
+func sdpGetBundledAudio(desc sdp.SessionDescription) []*sdp.MediaDescription {
+	audio := sdpGetAudio(desc)
+	if audio == nil {
+		return nil
+	}
+	list := []*sdp.MediaDescription{audio}
+	bundle := sdpGetBundle(desc, sdpGetMid(audio))
+	for _, m := range desc.MediaDescriptions {
+		if m != audio && m.MediaName.Media == "audio" && slices.Contains(bundle, sdpGetMid(m)) {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+// sdpGetPort returns the port of the media description. Media which is only bundled (RFC 8843, section 6)
+// has zero port, and uses the port of the first bundled media with a non-zero port.
+func sdpGetPort(desc sdp.SessionDescription, m *sdp.MediaDescription) int {
+	if port := m.MediaName.Port.Value; port != 0 {
+		return port
+	}
+	for _, mid := range sdpGetBundle(desc, sdpGetMid(m)) {
+		for _, b := range desc.MediaDescriptions {
+			if sdpGetMid(b) == mid && b.MediaName.Port.Value != 0 {
+				return b.MediaName.Port.Value
+			}
+		}
+	}
+	return 0
+}
+
+// sdpGetDirection returns the audio media direction attribute. RFC 3264 defaults to "sendrecv".
+func sdpGetDirection(offer sdp.SessionDescription) string {
+	var attrs []sdp.Attribute
+	if audio := sdpGetAudio(offer); audio != nil {
+		attrs = audio.Attributes
+	}
+	// Media-level attribute takes precedence over the session-level one.
+	for _, list := range [][]sdp.Attribute{attrs, offer.Attributes} {
+		for _, a := range list {
+			switch a.Key {
+			case "sendrecv", "sendonly", "recvonly", "inactive":
+				return a.Key
+			}
+		}
+	}
+	return "sendrecv"
+}
+
+// sdpAnswerDirection returns the direction for the answer, which mirrors the one in the offer.
+func sdpAnswerDirection(dir string) string {
+	switch dir {
+	case "sendonly":
+		return "recvonly"
+	case "recvonly":
+		return "sendonly"
+	case "inactive":
+		return "inactive"
+	}
+	return "sendrecv"
+}
+
+// sdpWithDirection replaces the audio media direction, and increments the session version of the SDP (RFC 3264, section 8).
+func sdpWithDirection(data []byte, dir string) ([]byte, error) {
+	desc := sdp.SessionDescription{}
+	if err := desc.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	audio := sdpGetAudio(desc)
+	if audio == nil {
+		return nil, errors.New("no audio in SDP")
+	}
+	isDirection := func(a sdp.Attribute) bool {
+		switch a.Key {
+		case "sendrecv", "sendonly", "recvonly", "inactive":
+			return true
+		}
+		return false
+	}
+	desc.Attributes = slices.DeleteFunc(desc.Attributes, isDirection)
+	audio.Attributes = append(slices.DeleteFunc(audio.Attributes, isDirection), sdp.Attribute{Key: dir})
+	desc.Origin.SessionVersion++
+	return desc.Marshal()
+}
+
 func sdpGetAudioDest(offer sdp.SessionDescription) *net.UDPAddr {
 	ci := offer.ConnectionInformation
 	if ci.NetworkType != "IN" {
@@ -237,7 +539,7 @@ func sdpGetAudioDest(offer sdp.SessionDescription) *net.UDPAddr {
 	}
 	return &net.UDPAddr{
 		IP:   ip.AsSlice(),
-		Port: audio.MediaName.Port.Value,
+		Port: sdpGetPort(offer, audio),
 	}
 }
 
@@ -245,25 +547,56 @@ type sdpCodecResult struct {
 	Audio     rtp.AudioCodec
 	AudioType byte
 	DTMFType  byte
+	RTCPMux   bool     // RTCP is multiplexed with RTP on the same port (RFC 5761)
+	DTLS      *sdpDTLS // local DTLS-SRTP parameters for the answer; only set if DTLS-SRTP is negotiated
+	ICE       *sdpICE  // local ICE parameters for the answer; only set if ICE is negotiated
+	REDType   byte     // redundant audio (RFC 2198) carrying the audio codec; zero if not offered
 }
 
 func sdpGetAudioCodec(offer sdp.SessionDescription) (*sdpCodecResult, error) {
-	audio := sdpGetAudio(offer)
-	if audio == nil {
+	return sdpGetAudioCodecWith(offer, nil)
+}
+
+// sdpGetAudioCodecWith selects the audio codec ranked by the trunk preference first, and then by the codec priority.
+func sdpGetAudioCodecWith(offer sdp.SessionDescription, pref []string) (*sdpCodecResult, error) {
+	bundle := sdpGetBundledAudio(offer)
+	if len(bundle) == 0 {
 		return nil, errors.New("no audio in sdp")
 	}
-	return sdpGetCodec(audio.Attributes)
+	var attrs []sdp.Attribute
+	for _, m := range bundle {
+		attrs = append(attrs, m.Attributes...)
+	}
+	return sdpGetCodecWith(attrs, pref)
 }
 
 func sdpGetCodec(attrs []sdp.Attribute) (*sdpCodecResult, error) {
+	return sdpGetCodecWith(attrs, nil)
+}
+
+func sdpGetCodecWith(attrs []sdp.Attribute, pref []string) (*sdpCodecResult, error) {
 	var (
+		rank       int
 		priority   int
 		audioCodec rtp.AudioCodec
 		audioType  byte
 		dtmfType   byte
+		rtcpMux    bool
+		redTypes   []byte
+		fmtp       = make(map[byte]string)
 	)
 	for _, m := range attrs {
 		switch m.Key {
+		case "rtcp-mux":
+			rtcpMux = true
+		case "fmtp":
+			sub := strings.SplitN(m.Value, " ", 2)
+			if len(sub) != 2 {
+				continue
+			}
+			if typ, err := strconv.Atoi(sub[0]); err == nil {
+				fmtp[byte(typ)] = sub[1]
+			}
 		case "rtpmap":
 			sub := strings.SplitN(m.Value, " ", 2)
 			if len(sub) != 2 {
@@ -278,13 +611,19 @@ func sdpGetCodec(attrs []sdp.Attribute) (*sdpCodecResult, error) {
 				dtmfType = byte(typ)
 				continue
 			}
+			if strings.HasPrefix(strings.ToLower(name), rtp.REDSDPName+"/") {
+				redTypes = append(redTypes, byte(typ))
+				continue
+			}
 			codec, ok := lksdp.CodecByName(name).(rtp.AudioCodec)
 			if !ok {
 				continue
 			}
-			if audioCodec == nil || codec.Info().Priority > priority {
+			r := codecRank(pref, codec)
+			if audioCodec == nil || r < rank || (r == rank && codec.Info().Priority > priority) {
 				audioType = byte(typ)
 				audioCodec = codec
+				rank = r
 				priority = codec.Info().Priority
 			}
 		}
@@ -296,5 +635,19 @@ func sdpGetCodec(attrs []sdp.Attribute) (*sdpCodecResult, error) {
 		Audio:     audioCodec,
 		AudioType: audioType,
 		DTMFType:  dtmfType,
+		RTCPMux:   rtcpMux,
+		REDType:   sdpFindRED(redTypes, fmtp, audioType),
 	}, nil
 }
+
+// sdpFindRED returns the RED payload type which only carries the audio codec, e.g. "a=fmtp:63 111/111".
+func sdpFindRED(redTypes []byte, fmtp map[byte]string, audioType byte) byte {
+	styp := strconv.Itoa(int(audioType))
+	for _, typ := range redTypes {
+		blocks := strings.Split(fmtp[typ], "/")
+		if !slices.ContainsFunc(blocks, func(v string) bool { return strings.TrimSpace(v) != styp }) {
+			return typ
+		}
+	}
+	return 0
+}
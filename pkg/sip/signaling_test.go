@@ -15,6 +15,8 @@
 package sip
 
 import (
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/pion/sdp/v2"
@@ -24,6 +26,7 @@ import (
 	"github.com/livekit/sip/pkg/media/g722"
 	"github.com/livekit/sip/pkg/media/rtp"
 	lksdp "github.com/livekit/sip/pkg/media/sdp"
+	"github.com/livekit/sip/pkg/media/srtp/dtls"
 	"github.com/livekit/sip/pkg/media/ulaw"
 )
 
@@ -45,6 +48,7 @@ func TestSDPMediaOffer(t *testing.T) {
 				{Key: "fmtp", Value: "101 0-16"},
 				{Key: "ptime", Value: "20"},
 				{Key: "maxptime", Value: "150"},
+				{Key: "rtcp-mux"},
 				{Key: "sendrecv"},
 			},
 		},
@@ -68,6 +72,7 @@ func TestSDPMediaOffer(t *testing.T) {
 				{Key: "fmtp", Value: "101 0-16"},
 				{Key: "ptime", Value: "20"},
 				{Key: "maxptime", Value: "150"},
+				{Key: "rtcp-mux"},
 				{Key: "sendrecv"},
 			},
 		},
@@ -197,8 +202,153 @@ func TestSDPMediaAnswer(t *testing.T) {
 				{Key: "fmtp", Value: "101 0-16"},
 				{Key: "ptime", Value: "20"},
 				{Key: "maxptime", Value: "150"},
+				{Key: "rtcp-mux"},
 				{Key: "sendrecv"},
 			},
 		},
 	}, offer)
 }
+
+func TestSDPCodecPreference(t *testing.T) {
+	// Codec selection only considers enabled codecs. G722 is disabled by default, unless the service enabled it.
+	if !media.CodecEnabled(lksdp.CodecByName(g722.SDPName)) {
+		media.CodecSetEnabled(g722.SDPName, true)
+		t.Cleanup(func() { media.CodecSetEnabled(g722.SDPName, false) })
+	}
+	attrs := []sdp.Attribute{
+		{Key: "rtpmap", Value: "0 PCMU/8000"},
+		{Key: "rtpmap", Value: "9 G722/8000"},
+		{Key: "rtpmap", Value: "101 telephone-event/8000"},
+	}
+	got, err := sdpGetCodecWith(attrs, nil)
+	require.NoError(t, err)
+	require.Equal(t, getCodec(g722.SDPName), got.Audio)
+
+	// Trunk preference wins over codec priority.
+	got, err = sdpGetCodecWith(attrs, []string{"pcmu"})
+	require.NoError(t, err)
+	require.Equal(t, getCodec(ulaw.SDPName), got.Audio)
+	require.EqualValues(t, 0, got.AudioType)
+	require.EqualValues(t, 101, got.DTMFType)
+
+	// Codecs that are not offered are skipped.
+	got, err = sdpGetCodecWith(attrs, []string{"opus/48000/2", "G722/8000", "PCMU"})
+	require.NoError(t, err)
+	require.Equal(t, getCodec(g722.SDPName), got.Audio)
+}
+
+func TestSDPDTLS(t *testing.T) {
+	const fp = "sha-256 AB:CD"
+	plain, err := sdpGenerateOffer("127.0.0.1", 12345)
+	require.NoError(t, err)
+	var desc sdp.SessionDescription
+	require.NoError(t, desc.Unmarshal(plain))
+	require.Nil(t, sdpGetDTLS(desc))
+
+	data, err := sdpGenerateOfferWithDTLS("127.0.0.1", 12345, getCodecs(), &sdpDTLS{Fingerprint: fp, Setup: dtls.RoleActPass})
+	require.NoError(t, err)
+	desc = sdp.SessionDescription{}
+	require.NoError(t, desc.Unmarshal(data))
+	require.Equal(t, []string{"UDP", "TLS", "RTP", "SAVP"}, sdpGetAudio(desc).MediaName.Protos)
+	require.Equal(t, &sdpDTLS{Fingerprint: fp, Setup: dtls.RoleActPass}, sdpGetDTLS(desc))
+
+	// Session-level fingerprint is used if it's not set for the media, and setup defaults to active.
+	desc.Attributes = []sdp.Attribute{{Key: "fingerprint", Value: "sha-256 00:11"}}
+	audio := sdpGetAudio(desc)
+	audio.Attributes = slices.DeleteFunc(audio.Attributes, func(a sdp.Attribute) bool {
+		return a.Key == "fingerprint" || a.Key == "setup"
+	})
+	require.Equal(t, &sdpDTLS{Fingerprint: "sha-256 00:11", Setup: dtls.RoleActive}, sdpGetDTLS(desc))
+
+	res := &sdpCodecResult{Audio: getCodec(ulaw.SDPName), DTLS: &sdpDTLS{Fingerprint: fp, Setup: dtls.RoleActive}}
+	m := sdpAnswerMediaDesc(12345, res, "sendrecv")[0]
+	require.Equal(t, []string{"UDP", "TLS", "RTP", "SAVP"}, m.MediaName.Protos)
+	require.Contains(t, m.Attributes, sdp.Attribute{Key: "fingerprint", Value: fp})
+	require.Contains(t, m.Attributes, sdp.Attribute{Key: "setup", Value: "active"})
+}
+
+const testBundleSDP = "v=0\r\n" +
+	"o=- 1234 1234 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"c=IN IP4 127.0.0.1\r\n" +
+	"t=0 0\r\n" +
+	"a=group:BUNDLE a0 a1\r\n" +
+	"m=audio 40000 RTP/AVP 0\r\n" +
+	"a=mid:a0\r\n" +
+	"a=rtpmap:0 PCMU/8000\r\n" +
+	"a=rtcp-mux\r\n" +
+	"m=audio 0 RTP/AVP 101\r\n" +
+	"a=mid:a1\r\n" +
+	"a=bundle-only\r\n" +
+	"a=rtpmap:101 telephone-event/8000\r\n" +
+	"a=fmtp:101 0-16\r\n"
+
+func TestSDPBundle(t *testing.T) {
+	var desc sdp.SessionDescription
+	require.NoError(t, desc.Unmarshal([]byte(testBundleSDP)))
+	require.Equal(t, []string{"a0", "a1"}, sdpGetBundle(desc, "a1"))
+	require.Nil(t, sdpGetBundle(desc, "v0"))
+	require.Len(t, sdpGetBundledAudio(desc), 2)
+
+	// Telephone events of the bundled media are received on the same port.
+	res, err := sdpGetAudioCodec(desc)
+	require.NoError(t, err)
+	require.Equal(t, byte(0), res.AudioType)
+	require.Equal(t, byte(101), res.DTMFType)
+	require.True(t, res.RTCPMux)
+	require.Equal(t, 40000, sdpGetAudioDest(desc).Port)
+	require.Equal(t, 40000, sdpGetPort(desc, desc.MediaDescriptions[1]))
+
+	data, err := sdpGenerateAnswer(desc, "127.0.0.1", 12345, res)
+	require.NoError(t, err)
+	var answer sdp.SessionDescription
+	require.NoError(t, answer.Unmarshal(data))
+	group, _ := answer.Attribute("group")
+	require.Equal(t, "BUNDLE a0", group)
+	require.Equal(t, "a0", sdpGetMid(sdpGetAudio(answer)))
+
+	// Without BUNDLE, only the first audio media is used.
+	desc.Attributes = nil
+	require.Len(t, sdpGetBundledAudio(desc), 1)
+	res, err = sdpGetAudioCodec(desc)
+	require.NoError(t, err)
+	require.Zero(t, res.DTMFType)
+}
+
+func TestSDPRedundancy(t *testing.T) {
+	const offer = "v=0\r\n" +
+		"o=- 1234 1234 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"c=IN IP4 127.0.0.1\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 40000 RTP/AVP 62 63 0\r\n" +
+		"a=rtpmap:62 red/8000\r\n" +
+		"a=fmtp:62 0/101\r\n" +
+		"a=rtpmap:63 RED/8000\r\n" +
+		"a=fmtp:63 0/0\r\n" +
+		"a=rtpmap:0 PCMU/8000\r\n"
+	var desc sdp.SessionDescription
+	require.NoError(t, desc.Unmarshal([]byte(offer)))
+	res, err := sdpGetAudioCodec(desc)
+	require.NoError(t, err)
+	require.Equal(t, byte(0), res.AudioType)
+	// RED which also carries other types is not used.
+	require.Equal(t, byte(63), res.REDType)
+
+	data, err := sdpGenerateAnswer(desc, "127.0.0.1", 12345, res)
+	require.NoError(t, err)
+	var answer sdp.SessionDescription
+	require.NoError(t, answer.Unmarshal(data))
+	audio := sdpGetAudio(answer)
+	require.Contains(t, audio.MediaName.Formats, "63")
+	require.Contains(t, audio.Attributes, sdp.Attribute{Key: "rtpmap", Value: "63 red/8000"})
+	require.Contains(t, audio.Attributes, sdp.Attribute{Key: "fmtp", Value: "63 0/0"})
+
+	// Not offered for the selected codec.
+	desc.MediaDescriptions[0].Attributes = slices.DeleteFunc(desc.MediaDescriptions[0].Attributes, func(a sdp.Attribute) bool {
+		return strings.HasPrefix(a.Value, "63 ")
+	})
+	res, err = sdpGetAudioCodec(desc)
+	require.NoError(t, err)
+	require.Zero(t, res.REDType)
+}
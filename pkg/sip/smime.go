@@ -0,0 +1,48 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"errors"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/livekit/protocol/logger"
+
+	"github.com/livekit/sip/pkg/sip/smime"
+)
+
+type sdpMessage interface {
+	GetHeader(name string) sip.Header
+	Body() []byte
+}
+
+// messageSDP returns the SDP body of the message. Bodies signed with S/MIME are unwrapped and verified.
+// Signature mismatches are only logged, since the signer certificate is not trusted anyway.
+func messageSDP(log logger.Logger, m sdpMessage) []byte {
+	h := m.GetHeader("Content-Type")
+	if h == nil || !smime.IsSigned(h.Value()) {
+		return m.Body()
+	}
+	b, err := smime.Open(h.Value(), m.Body())
+	if errors.Is(err, smime.ErrInvalidSignature) {
+		log.Warnw("S/MIME signature of SDP doesn't match", err)
+	} else if err != nil {
+		log.Warnw("Cannot verify S/MIME signature of SDP", err)
+		return m.Body()
+	} else {
+		log.Debugw("S/MIME signature of SDP verified", "signer", b.Signer.Subject.String())
+	}
+	return b.Content
+}
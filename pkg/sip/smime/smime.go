@@ -0,0 +1,349 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package smime signs and verifies bodies of SIP messages with S/MIME (RFC 3261, Section 23).
+//
+// Bodies are sent as multipart/signed with a detached PKCS#7 signature. Only SHA-256 digests and RSA or ECDSA keys
+// are supported. The certificate of the signer is included in the signature, but it's not checked against any roots.
+package smime
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+const (
+	ContentTypeSigned    = "multipart/signed"
+	ContentTypeSignature = "application/pkcs7-signature"
+)
+
+// ErrInvalidSignature is returned by Open when the body doesn't match the signature.
+var ErrInvalidSignature = errors.New("invalid S/MIME signature")
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSA           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidRSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidECDSAWithSHA2 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial asn1.RawValue
+}
+
+type signerInfo struct {
+	Version            int
+	IssuerAndSerial    issuerAndSerial
+	DigestAlgorithm    algorithmIdentifier
+	SignatureAlgorithm algorithmIdentifier
+	Signature          []byte
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []algorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+// Signer signs bodies with a certificate and its private key.
+type Signer struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// NewSigner creates a signer for a given certificate and its private key.
+func NewSigner(cert *x509.Certificate, key crypto.Signer) (*Signer, error) {
+	switch key.Public().(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported S/MIME key type: %T", key.Public())
+	}
+	return &Signer{cert: cert, key: key}, nil
+}
+
+// LoadSigner loads a PEM-encoded certificate and private key for signing.
+func LoadSigner(certFile, keyFile string) (*Signer, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load S/MIME certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse S/MIME certificate: %w", err)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported S/MIME key type: %T", pair.PrivateKey)
+	}
+	return NewSigner(cert, key)
+}
+
+// Certificate returns the certificate of the signer.
+func (s *Signer) Certificate() *x509.Certificate {
+	return s.cert
+}
+
+// Sign creates a detached DER-encoded PKCS#7 signature of the content.
+func (s *Signer) Sign(content []byte) ([]byte, error) {
+	digest := sha256.Sum256(content)
+	sig, err := s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	sigAlg := algorithmIdentifier{Algorithm: oidECDSAWithSHA2}
+	if _, ok := s.key.Public().(*rsa.PublicKey); ok {
+		sigAlg = algorithmIdentifier{Algorithm: oidRSA, Parameters: asn1.NullRawValue}
+	}
+	digestAlg := algorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []algorithmIdentifier{digestAlg},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: s.cert.Raw},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			IssuerAndSerial:    issuerAndSerial{Issuer: asn1.RawValue{FullBytes: s.cert.RawIssuer}, Serial: asn1.RawValue{FullBytes: mustMarshal(s.cert.SerialNumber)}},
+			DigestAlgorithm:    digestAlg,
+			SignatureAlgorithm: sigAlg,
+			Signature:          sig,
+		}},
+	}
+	inner, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: inner},
+	})
+}
+
+func mustMarshal(v any) []byte {
+	data, err := asn1.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// Verify checks a detached DER-encoded PKCS#7 signature of the content, and returns the certificate of the signer.
+func Verify(content, sig []byte) (*x509.Certificate, error) {
+	var info contentInfo
+	if _, err := asn1.Unmarshal(sig, &info); err != nil {
+		return nil, fmt.Errorf("malformed S/MIME signature: %w", err)
+	} else if !info.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("unexpected S/MIME content type: %v", info.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("malformed S/MIME signed data: %w", err)
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, errors.New("no signers in S/MIME signature")
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("malformed S/MIME certificate: %w", err)
+	}
+	si := sd.SignerInfos[0]
+	if !si.DigestAlgorithm.Algorithm.Equal(oidSHA256) {
+		return nil, fmt.Errorf("unsupported S/MIME digest algorithm: %v", si.DigestAlgorithm.Algorithm)
+	}
+	var cert *x509.Certificate
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, si.IssuerAndSerial.Issuer.FullBytes) && bytes.Equal(mustMarshal(c.SerialNumber), si.IssuerAndSerial.Serial.FullBytes) {
+			cert = c
+			break
+		}
+	}
+	if cert == nil {
+		return nil, errors.New("no signer certificate in S/MIME signature")
+	}
+	var alg x509.SignatureAlgorithm
+	switch a := si.SignatureAlgorithm.Algorithm; {
+	case a.Equal(oidRSA), a.Equal(oidRSAWithSHA256):
+		alg = x509.SHA256WithRSA
+	case a.Equal(oidECDSAWithSHA2):
+		alg = x509.ECDSAWithSHA256
+	default:
+		return nil, fmt.Errorf("unsupported S/MIME signature algorithm: %v", a)
+	}
+	if err = cert.CheckSignature(alg, content, si.Signature); err != nil {
+		return cert, ErrInvalidSignature
+	}
+	return cert, nil
+}
+
+// Body is the content of a multipart/signed body.
+type Body struct {
+	ContentType string
+	Content     []byte
+	Signer      *x509.Certificate // set if the signature is valid
+}
+
+// SignBody wraps the body into multipart/signed with a detached signature. It returns the new content type and body.
+func (s *Signer) SignBody(contentType string, body []byte) (string, []byte, error) {
+	entity := mimeEntity(contentType, body)
+	sig, err := s.Sign(entity)
+	if err != nil {
+		return "", nil, err
+	}
+	var rnd [12]byte
+	if _, err = rand.Read(rnd[:]); err != nil {
+		return "", nil, err
+	}
+	boundary := "smime-" + hex.EncodeToString(rnd[:])
+	var buf bytes.Buffer
+	buf.WriteString("--" + boundary + "\r\n")
+	buf.Write(entity)
+	buf.WriteString("\r\n--" + boundary + "\r\n")
+	buf.WriteString("Content-Type: " + ContentTypeSignature + "; name=smime.p7s\r\n")
+	buf.WriteString("Content-Disposition: attachment; handling=required; filename=smime.p7s\r\n")
+	buf.WriteString("Content-Transfer-Encoding: binary\r\n\r\n")
+	buf.Write(sig)
+	buf.WriteString("\r\n--" + boundary + "--\r\n")
+	ctype := mime.FormatMediaType(ContentTypeSigned, map[string]string{
+		"protocol": ContentTypeSignature,
+		"micalg":   "sha-256",
+		"boundary": boundary,
+	})
+	return ctype, buf.Bytes(), nil
+}
+
+func mimeEntity(contentType string, body []byte) []byte {
+	return append([]byte("Content-Type: "+contentType+"\r\n\r\n"), body...)
+}
+
+// IsSigned checks if the content type is multipart/signed.
+func IsSigned(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == ContentTypeSigned
+}
+
+// Open extracts the signed content from a multipart/signed body and verifies the signature.
+// If the signature doesn't match the content, both the body and ErrInvalidSignature are returned.
+func Open(contentType string, body []byte) (*Body, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	} else if mediaType != ContentTypeSigned {
+		return nil, fmt.Errorf("unexpected content type: %q", mediaType)
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, errors.New("no boundary in multipart/signed body")
+	}
+	parts, err := splitParts(body, boundary)
+	if err != nil {
+		return nil, err
+	}
+	entity := parts[0]
+	ctype, content, err := parseEntity(entity)
+	if err != nil {
+		return nil, err
+	}
+	sigType, sig, err := parseEntity(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if t, _, _ := mime.ParseMediaType(sigType); t != ContentTypeSignature && t != "application/x-pkcs7-signature" {
+		return nil, fmt.Errorf("unexpected signature type: %q", sigType)
+	}
+	b := &Body{ContentType: ctype, Content: content}
+	cert, err := Verify(entity, sig)
+	if err != nil {
+		return b, err
+	}
+	b.Signer = cert
+	return b, nil
+}
+
+// splitParts returns raw contents of the first two parts of the multipart body.
+func splitParts(body []byte, boundary string) ([][]byte, error) {
+	delim := []byte("--" + boundary)
+	i := bytes.Index(body, delim)
+	if i < 0 {
+		return nil, errors.New("no parts in multipart/signed body")
+	}
+	body = body[i+len(delim):]
+	var parts [][]byte
+	for len(parts) < 2 {
+		body = bytes.TrimPrefix(body, []byte("\r\n"))
+		i = bytes.Index(body, append([]byte("\r\n"), delim...))
+		if i < 0 {
+			return nil, errors.New("malformed multipart/signed body")
+		}
+		parts = append(parts, body[:i])
+		body = body[i+2+len(delim):]
+	}
+	return parts, nil
+}
+
+// parseEntity returns the content type and the content of a MIME entity.
+func parseEntity(entity []byte) (string, []byte, error) {
+	header, content, ok := bytes.Cut(entity, []byte("\r\n\r\n"))
+	if !ok {
+		return "", nil, errors.New("malformed MIME entity")
+	}
+	var ctype, enc string
+	for _, line := range strings.Split(string(header), "\r\n") {
+		name, value, _ := strings.Cut(line, ":")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "content-type":
+			ctype = strings.TrimSpace(value)
+		case "content-transfer-encoding":
+			enc = strings.ToLower(strings.TrimSpace(value))
+		}
+	}
+	switch enc {
+	case "", "binary", "8bit", "7bit":
+		return ctype, content, nil
+	case "base64":
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(content)), ""))
+		if err != nil {
+			return "", nil, fmt.Errorf("malformed base64 content: %w", err)
+		}
+		return ctype, data, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported transfer encoding: %q", enc)
+	}
+}
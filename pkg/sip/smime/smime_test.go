@@ -0,0 +1,130 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package smime
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCert(t testing.TB, key crypto.Signer, name string) *x509.Certificate {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func newTestSigners(t testing.TB) map[string]*Signer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signers := make(map[string]*Signer)
+	for name, key := range map[string]crypto.Signer{"rsa": rsaKey, "ecdsa": ecKey} {
+		s, err := NewSigner(newTestCert(t, key, name), key)
+		require.NoError(t, err)
+		signers[name] = s
+	}
+	return signers
+}
+
+const testSDP = "v=0\r\no=- 1 1 IN IP4 10.0.0.1\r\ns=-\r\nc=IN IP4 10.0.0.1\r\nt=0 0\r\nm=audio 5000 RTP/AVP 0\r\n"
+
+func TestSignVerify(t *testing.T) {
+	for name, s := range newTestSigners(t) {
+		t.Run(name, func(t *testing.T) {
+			sig, err := s.Sign([]byte(testSDP))
+			require.NoError(t, err)
+			cert, err := Verify([]byte(testSDP), sig)
+			require.NoError(t, err)
+			require.Equal(t, name, cert.Subject.CommonName)
+
+			_, err = Verify([]byte(testSDP+"a=sendonly\r\n"), sig)
+			require.ErrorIs(t, err, ErrInvalidSignature)
+			_, err = Verify([]byte(testSDP), sig[:len(sig)/2])
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestSignBody(t *testing.T) {
+	for name, s := range newTestSigners(t) {
+		t.Run(name, func(t *testing.T) {
+			ctype, body, err := s.SignBody("application/sdp", []byte(testSDP))
+			require.NoError(t, err)
+			require.True(t, IsSigned(ctype))
+			require.Contains(t, ctype, `protocol="application/pkcs7-signature"`)
+
+			b, err := Open(ctype, body)
+			require.NoError(t, err)
+			require.Equal(t, "application/sdp", b.ContentType)
+			require.Equal(t, testSDP, string(b.Content))
+			require.Equal(t, name, b.Signer.Subject.CommonName)
+
+			// Content is still returned if it was modified.
+			tampered := []byte(strings.Replace(string(body), "5000", "5002", 1))
+			b, err = Open(ctype, tampered)
+			require.ErrorIs(t, err, ErrInvalidSignature)
+			require.Contains(t, string(b.Content), "m=audio 5002")
+			require.Nil(t, b.Signer)
+
+			_, err = Open("application/sdp", body)
+			require.Error(t, err)
+			_, err = Open(ctype, []byte(testSDP))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestLoadSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	cert := newTestCert(t, key, "sip.example.com")
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	s, err := LoadSigner(certFile, keyFile)
+	require.NoError(t, err)
+	require.Equal(t, cert.Raw, s.Certificate().Raw)
+	_, err = LoadSigner(certFile, certFile)
+	require.Error(t, err)
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/livekit/protocol/logger"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/sip/smime"
+)
+
+func newTestSMIMEFiles(t *testing.T) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sip.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+// newTestTCPUAS starts a test UAS on TCP only, since signed INVITEs are too large for UDP.
+func newTestTCPUAS(t *testing.T, onInvite sipgo.RequestHandler) net.Addr {
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", net.JoinHostPort(localIP, "0"))
+	require.NoError(t, err)
+
+	ua, err := sipgo.NewUA()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ua.Close() })
+	srv, err := sipgo.NewServer(ua)
+	require.NoError(t, err)
+	srv.OnInvite(onInvite)
+	srv.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {})
+	go func() {
+		_ = srv.ServeTCP(lis)
+	}()
+	return lis.Addr()
+}
+
+func TestOutboundSMIME(t *testing.T) {
+	type invite struct {
+		contentType string
+		body        []byte
+	}
+	invites := make(chan invite, 1)
+	uas := newTestTCPUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+		invites <- invite{req.GetHeader("Content-Type").Value(), req.Body()}
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+
+	certFile, keyFile := newTestSMIMEFiles(t)
+	call := newTestOutboundCall(t, &config.Config{SMIMECertFile: certFile, SMIMEKeyFile: keyFile})
+	offer := []byte("v=0\r\no=- 1 1 IN IP4 10.0.0.1\r\ns=-\r\nt=0 0\r\n")
+	_, _, err := call.sipInvite(offer, sipOutboundConfig{
+		address: uas.String(),
+		from:    "alice",
+		to:      "bob",
+	})
+	require.NoError(t, err)
+
+	inv := <-invites
+	require.True(t, smime.IsSigned(inv.contentType))
+	b, err := smime.Open(inv.contentType, inv.body)
+	require.NoError(t, err)
+	require.Equal(t, "application/sdp", b.ContentType)
+	require.Equal(t, offer, b.Content)
+	require.Equal(t, "sip.example.com", b.Signer.Subject.CommonName)
+}
+
+func TestMessageSDP(t *testing.T) {
+	certFile, keyFile := newTestSMIMEFiles(t)
+	signer, err := smime.LoadSigner(certFile, keyFile)
+	require.NoError(t, err)
+	offer := "v=0\r\no=- 1 1 IN IP4 10.0.0.1\r\ns=-\r\nt=0 0\r\n"
+	ctype, signed, err := signer.SignBody("application/sdp", []byte(offer))
+	require.NoError(t, err)
+
+	newReq := func(contentType string, body []byte) *sip.Request {
+		req := sip.NewRequest(sip.INVITE, &sip.Uri{User: "bob", Host: "example.com"})
+		req.AppendHeader(sip.NewHeader("Content-Type", contentType))
+		req.SetBody(body)
+		return req
+	}
+	log := logger.GetLogger()
+	require.Equal(t, offer, string(messageSDP(log, newReq("application/sdp", []byte(offer)))))
+	require.Equal(t, offer, string(messageSDP(log, newReq(ctype, signed))))
+
+	// Tampered content is still used, the mismatch is only logged.
+	tampered := strings.Replace(string(signed), "10.0.0.1", "10.0.0.2", 1)
+	require.Equal(t, strings.Replace(offer, "10.0.0.1", "10.0.0.2", 1), string(messageSDP(log, newReq(ctype, []byte(tampered)))))
+}
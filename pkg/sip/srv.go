@@ -0,0 +1,309 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// srvLookupTimeout limits the time spent on SRV queries for a single call.
+	srvLookupTimeout = 2 * time.Second
+	// srvFailedCacheTTL is how long empty or failed SRV lookups are cached.
+	srvFailedCacheTTL = 30 * time.Second
+)
+
+// srvRecord is a DNS SRV record (RFC 2782).
+type srvRecord struct {
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+	TTL      time.Duration
+}
+
+// srvResolver looks up SRV records with a given name, e.g. "_sip._udp.example.com".
+// It returns no records and no error if the name does not exist.
+type srvResolver interface {
+	LookupSRV(ctx context.Context, name string) ([]srvRecord, error)
+}
+
+// sipTarget is a destination for requests sent to the trunk, resolved from SRV or A records.
+type sipTarget struct {
+	addr      string // host:port
+	transport string // "UDP" or "TCP"; empty for addresses of the trunk host
+}
+
+// srvTransports lists SRV services to look up, in the order of preference.
+var srvTransports = []struct {
+	proto     string
+	transport string
+}{
+	{"udp", "UDP"},
+	{"tcp", "TCP"},
+}
+
+// srvTargets resolves destinations of the trunk with DNS SRV (RFC 3263), if the address is a domain without a port.
+// Servers are ordered by priority and weight, UDP servers are tried before TCP. It returns nil if the address has
+// no SRV records, in which case it must be used as-is.
+func (c *Client) srvTargets(address string) []sipTarget {
+	if c.srv == nil {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return nil // port is set explicitly
+	}
+	if net.ParseIP(address) != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), srvLookupTimeout)
+	defer cancel()
+	var targets []sipTarget
+	for _, t := range srvTransports {
+		for _, r := range c.lookupSRV(ctx, "_sip._"+t.proto+"."+address) {
+			addr := net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port)))
+			targets = append(targets, sipTarget{addr: addr, transport: t.transport})
+		}
+	}
+	return targets
+}
+
+// lookupSRV returns cached SRV records for a given name, or queries them. Records are returned in the order
+// in which servers must be tried.
+func (c *Client) lookupSRV(ctx context.Context, name string) []srvRecord {
+	now := time.Now()
+	records, ok := c.srvCache.Get(name, now)
+	if !ok {
+		var err error
+		records, err = c.srv.LookupSRV(ctx, name)
+		if err != nil {
+			c.log.Warnw("Cannot look up SRV records", err, "name", name)
+		}
+		c.srvCache.Set(name, records, now)
+	}
+	return srvOrder(records, rand.Intn)
+}
+
+// srvOrder sorts records by priority, and orders records with the same priority by a weighted random selection
+// described in RFC 2782. The target "." means that the service is not available, thus such records are dropped.
+func srvOrder(records []srvRecord, randn func(n int) int) []srvRecord {
+	records = slices.DeleteFunc(slices.Clone(records), func(r srvRecord) bool {
+		return r.Target == "." || r.Target == ""
+	})
+	slices.SortStableFunc(records, func(a, b srvRecord) int {
+		return int(a.Priority) - int(b.Priority)
+	})
+	out := make([]srvRecord, 0, len(records))
+	for len(records) > 0 {
+		n := 1
+		for n < len(records) && records[n].Priority == records[0].Priority {
+			n++
+		}
+		group := records[:n]
+		// Zero weight records are placed first, so that they have a small chance of being selected.
+		slices.SortStableFunc(group, func(a, b srvRecord) int {
+			return min(int(a.Weight), 1) - min(int(b.Weight), 1)
+		})
+		for len(group) > 0 {
+			sum := 0
+			for _, r := range group {
+				sum += int(r.Weight)
+			}
+			pick, run := randn(sum+1), 0
+			i := 0
+			for ; i < len(group)-1; i++ {
+				run += int(group[i].Weight)
+				if run >= pick {
+					break
+				}
+			}
+			out = append(out, group[i])
+			group = slices.Delete(group, i, i+1)
+		}
+		records = records[n:]
+	}
+	return out
+}
+
+// srvCache keeps SRV records until their TTL expires. Zero value is ready to use.
+type srvCache struct {
+	mu      sync.Mutex
+	entries map[string]srvCacheEntry
+}
+
+type srvCacheEntry struct {
+	records []srvRecord
+	expires time.Time
+}
+
+func (sc *srvCache) Get(name string, now time.Time) ([]srvRecord, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	e, ok := sc.entries[name]
+	if !ok || now.After(e.expires) {
+		return nil, false
+	}
+	return e.records, true
+}
+
+// Set caches the records for the lowest TTL among them. Empty results are cached for a short time.
+func (sc *srvCache) Set(name string, records []srvRecord, now time.Time) {
+	ttl := srvFailedCacheTTL
+	for i, r := range records {
+		if i == 0 || r.TTL < ttl {
+			ttl = r.TTL
+		}
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.entries == nil {
+		sc.entries = make(map[string]srvCacheEntry)
+	}
+	sc.entries[name] = srvCacheEntry{records: records, expires: now.Add(ttl)}
+}
+
+// dnsResolver sends SRV queries to the nameservers from resolv.conf. Unlike net.Resolver, it reports the TTL of records.
+type dnsResolver struct {
+	servers []string
+}
+
+func newDNSResolver() *dnsResolver {
+	return &dnsResolver{servers: resolvConfServers("/etc/resolv.conf")}
+}
+
+// resolvConfServers returns addresses of nameservers from the resolv.conf file. Local nameserver is used if none are set.
+func resolvConfServers(path string) []string {
+	var servers []string
+	if f, err := os.Open(path); err == nil {
+		defer f.Close()
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			fields := strings.Fields(s.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				servers = append(servers, net.JoinHostPort(fields[1], "53"))
+			}
+		}
+	}
+	if len(servers) == 0 {
+		servers = []string{"127.0.0.1:53"}
+	}
+	return servers
+}
+
+func (r *dnsResolver) LookupSRV(ctx context.Context, name string) ([]srvRecord, error) {
+	var errs []error
+	for _, server := range r.servers {
+		records, err := querySRV(ctx, server, name)
+		if err == nil {
+			return records, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// querySRV sends a single SRV query over UDP. Truncated responses are used as-is.
+func querySRV(ctx context.Context, server, name string) ([]srvRecord, error) {
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, err
+	}
+	id := uint16(rand.Intn(1 << 16))
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET}},
+	}
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err = conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(buf[:n])
+		if err != nil || h.ID != id || !h.Response {
+			continue // not a response to our query
+		}
+		return parseSRVAnswers(&p, h)
+	}
+}
+
+func parseSRVAnswers(p *dnsmessage.Parser, h dnsmessage.Header) ([]srvRecord, error) {
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("SRV query failed: %v", h.RCode)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+	var records []srvRecord
+	for {
+		ah, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			return records, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if ah.Type != dnsmessage.TypeSRV {
+			if err = p.SkipAnswer(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		srv, err := p.SRVResource()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, srvRecord{
+			Target:   srv.Target.String(),
+			Port:     srv.Port,
+			Priority: srv.Priority,
+			Weight:   srv.Weight,
+			TTL:      time.Duration(ah.TTL) * time.Second,
+		})
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+// testSRVResolver returns fixed SRV records for each name.
+type testSRVResolver struct {
+	records map[string][]srvRecord
+	lookups atomic.Int32
+}
+
+func (r *testSRVResolver) LookupSRV(ctx context.Context, name string) ([]srvRecord, error) {
+	r.lookups.Add(1)
+	return r.records[name], nil
+}
+
+func testSRV(addr *net.UDPAddr, prio, weight uint16) srvRecord {
+	return srvRecord{Target: addr.IP.String() + ".", Port: uint16(addr.Port), Priority: prio, Weight: weight, TTL: time.Minute}
+}
+
+func srvTargets(records []srvRecord) []string {
+	var out []string
+	for _, r := range records {
+		out = append(out, r.Target)
+	}
+	return out
+}
+
+func TestSRVOrder(t *testing.T) {
+	records := []srvRecord{
+		{Target: "c.", Priority: 20, Weight: 10},
+		{Target: "a.", Priority: 10, Weight: 30},
+		{Target: ".", Priority: 5},
+		{Target: "b.", Priority: 10, Weight: 10},
+		{Target: "z.", Priority: 10, Weight: 0},
+	}
+	// Lowest priority goes first. Within the priority, weights are summed in order (z=0, a=30, b=40),
+	// and the first record reaching the random value is picked.
+	require.Equal(t, []string{"z.", "a.", "b.", "c."}, srvTargets(srvOrder(records, func(n int) int { return 0 })))
+	require.Equal(t, []string{"b.", "a.", "z.", "c."}, srvTargets(srvOrder(records, func(n int) int { return n - 1 })))
+	picks := []int{20, 0, 0, 0}
+	require.Equal(t, []string{"a.", "z.", "b.", "c."}, srvTargets(srvOrder(records, func(n int) int {
+		v := picks[0]
+		picks = picks[1:]
+		return v
+	})))
+	// Input is not modified.
+	require.Equal(t, "c.", records[0].Target)
+}
+
+func TestSRVCache(t *testing.T) {
+	var c srvCache
+	now := time.Now()
+	records := []srvRecord{{Target: "a.", TTL: time.Minute}, {Target: "b.", TTL: 10 * time.Second}}
+	c.Set("_sip._udp.example.com", records, now)
+	got, ok := c.Get("_sip._udp.example.com", now.Add(9*time.Second))
+	require.True(t, ok)
+	require.Equal(t, records, got)
+	// Lowest TTL is used.
+	_, ok = c.Get("_sip._udp.example.com", now.Add(11*time.Second))
+	require.False(t, ok)
+
+	// Names without records are cached as well.
+	c.Set("_sip._tcp.example.com", nil, now)
+	got, ok = c.Get("_sip._tcp.example.com", now.Add(srvFailedCacheTTL-time.Second))
+	require.True(t, ok)
+	require.Empty(t, got)
+	_, ok = c.Get("_sip._tcp.example.com", now.Add(srvFailedCacheTTL+time.Second))
+	require.False(t, ok)
+}
+
+func TestTrunkTargets(t *testing.T) {
+	res := &testSRVResolver{records: map[string][]srvRecord{
+		"_sip._udp.example.com": {
+			{Target: "sip2.example.com.", Port: 5062, Priority: 20, TTL: time.Minute},
+			{Target: "sip1.example.com.", Port: 5061, Priority: 10, TTL: time.Minute},
+		},
+		"_sip._tcp.example.com": {
+			{Target: "tcp.example.com.", Port: 5060, Priority: 1, TTL: time.Minute},
+		},
+	}}
+	c := &Client{srv: res}
+	require.Equal(t, []sipTarget{
+		{addr: "sip1.example.com:5061", transport: "UDP"},
+		{addr: "sip2.example.com:5062", transport: "UDP"},
+		{addr: "tcp.example.com:5060", transport: "TCP"},
+	}, c.trunkTargets("", "example.com"))
+	require.EqualValues(t, 2, res.lookups.Load())
+
+	// Results are cached.
+	c.trunkTargets("", "example.com")
+	require.EqualValues(t, 2, res.lookups.Load())
+
+	// SRV is not used if the port or the IP is set.
+	require.Nil(t, c.trunkTargets("", "example.com:5060"))
+	require.Nil(t, c.trunkTargets("", "10.0.0.1"))
+	require.Nil(t, c.trunkTargets("", "other.example.com"))
+	require.EqualValues(t, 4, res.lookups.Load())
+}
+
+func TestParseSRVAnswers(t *testing.T) {
+	name := dnsmessage.MustNewName("_sip._udp.example.com.")
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, Response: true})
+	require.NoError(t, b.StartQuestions())
+	require.NoError(t, b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET}))
+	require.NoError(t, b.StartAnswers())
+	require.NoError(t, b.SRVResource(
+		dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: 300},
+		dnsmessage.SRVResource{Priority: 10, Weight: 5, Port: 5060, Target: dnsmessage.MustNewName("sip.example.com.")},
+	))
+	require.NoError(t, b.AResource(
+		dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: 300},
+		dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}},
+	))
+	msg, err := b.Finish()
+	require.NoError(t, err)
+
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	require.NoError(t, err)
+	records, err := parseSRVAnswers(&p, h)
+	require.NoError(t, err)
+	require.Equal(t, []srvRecord{
+		{Target: "sip.example.com.", Port: 5060, Priority: 10, Weight: 5, TTL: 300 * time.Second},
+	}, records)
+
+	// Missing names have no records.
+	records, err = parseSRVAnswers(&p, dnsmessage.Header{Response: true, RCode: dnsmessage.RCodeNameError})
+	require.NoError(t, err)
+	require.Empty(t, records)
+	_, err = parseSRVAnswers(&p, dnsmessage.Header{Response: true, RCode: dnsmessage.RCodeServerFailure})
+	require.Error(t, err)
+}
+
+func TestResolvConfServers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	require.NoError(t, os.WriteFile(path, []byte("# comment\nsearch example.com\nnameserver 10.0.0.53\nnameserver ::1\n"), 0644))
+	require.Equal(t, []string{"10.0.0.53:53", "[::1]:53"}, resolvConfServers(path))
+	require.Equal(t, []string{"127.0.0.1:53"}, resolvConfServers(filepath.Join(t.TempDir(), "missing")))
+}
+
+func TestOutboundSRVFailover(t *testing.T) {
+	type attempt struct {
+		server string
+		host   string
+	}
+	attempts := make(chan attempt, 10)
+	newServer := func(name string, status sip.StatusCode) *net.UDPAddr {
+		return newTestUAS(t, func(req *sip.Request, tx sip.ServerTransaction) {
+			attempts <- attempt{server: name, host: req.Recipient.Host + ":" + strconv.Itoa(req.Recipient.Port)}
+			_ = tx.Respond(sip.NewResponseFromRequest(req, status, "", nil))
+		})
+	}
+	primary := newServer("primary", 503)
+	secondary := newServer("secondary", 503)
+	backup := newServer("backup", 200)
+
+	retries := 0
+	call := newTestOutboundCall(t, &config.Config{OutboundRetryCount: &retries})
+	call.c.srv = &testSRVResolver{records: map[string][]srvRecord{
+		"_sip._udp.trunk.example.com": {
+			testSRV(backup, 20, 0),
+			testSRV(secondary, 10, 0),
+			testSRV(primary, 10, 100),
+		},
+	}}
+	_, resp, err := call.sipInvite(nil, sipOutboundConfig{
+		address: "trunk.example.com",
+		from:    "from",
+		to:      "to",
+	})
+	require.NoError(t, err)
+	require.Equal(t, sip.StatusCode(200), resp.StatusCode)
+
+	var got []string
+	for len(attempts) > 0 {
+		a := <-attempts
+		// Request-URI keeps the trunk domain.
+		require.Equal(t, "trunk.example.com:0", a.host)
+		got = append(got, a.server)
+	}
+	// Servers with the same priority are tried in a random order, but all of them are tried before the next priority.
+	require.Len(t, got, 3)
+	require.ElementsMatch(t, []string{"primary", "secondary"}, got[:2])
+	require.Equal(t, "backup", got[2])
+}
@@ -0,0 +1,401 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/livekit/protocol/auth"
+	"github.com/livekit/protocol/logger"
+
+	"github.com/livekit/sip/pkg/sip/mwi"
+	"github.com/livekit/sip/pkg/sip/presence"
+)
+
+const (
+	notifyTimeout    = 5 * time.Second
+	subscribeTimeout = 5 * time.Second // for Handler.SubscribeMWI
+)
+
+// startPresenceWebhook starts an HTTP server for LiveKit webhooks, which update presence state of the rooms.
+func (s *Server) startPresenceWebhook() error {
+	if s.conf.PresenceWebhookPort == 0 {
+		return nil
+	}
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", s.conf.PresenceWebhookPort))
+	if err != nil {
+		return fmt.Errorf("cannot listen on the presence webhook port %d: %w", s.conf.PresenceWebhookPort, err)
+	}
+	s.presenceSrv = &http.Server{
+		Handler: s.presence.WebhookHandler(auth.NewSimpleKeyProvider(s.conf.ApiKey, s.conf.ApiSecret)),
+	}
+	go func() {
+		_ = s.presenceSrv.Serve(lis)
+	}()
+	return nil
+}
+
+// eventPackage returns event package name from the Event header, without parameters.
+func eventPackage(req *sip.Request) string {
+	h := req.GetHeader("Event")
+	if h == nil {
+		return ""
+	}
+	name, _, _ := strings.Cut(h.Value(), ";")
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// MWITopic is a LiveKit data topic with message counts of a mailbox, which are sent to MWI subscribers.
+// The payload is a JSON-encoded mwi.Update.
+const MWITopic = "mwi.update"
+
+// allowEvents lists event packages supported by SUBSCRIBE.
+var allowEvents = presence.EventName + ", " + mwi.EventName
+
+// subscribeRequest contains parameters of the SUBSCRIBE request, common for all event packages.
+type subscribeRequest struct {
+	id      string // Call-ID and From tag
+	callID  string
+	from    *sip.FromHeader
+	user    string // user part of the request URI
+	expires time.Duration
+	log     logger.Logger
+}
+
+func (s *Server) onSubscribe(req *sip.Request, tx sip.ServerTransaction) {
+	switch eventPackage(req) {
+	case presence.EventName:
+		s.onSubscribePresence(req, tx)
+	case mwi.EventName:
+		s.onSubscribeMWI(req, tx)
+	default:
+		res := sip.NewResponseFromRequest(req, 489, "Bad Event", nil)
+		res.AppendHeader(sip.NewHeader("Allow-Events", allowEvents))
+		_ = tx.Respond(res)
+	}
+}
+
+// parseSubscribe validates the SUBSCRIBE request. It responds to the request if it's invalid.
+func (s *Server) parseSubscribe(req *sip.Request, tx sip.ServerTransaction, defExpires time.Duration) (*subscribeRequest, bool) {
+	tag, err := getTagValue(req)
+	if err != nil {
+		sipErrorResponse(tx, req)
+		return nil, false
+	}
+	from, _ := req.From()
+	callID, ok := req.CallID()
+	if !ok {
+		sipErrorResponse(tx, req)
+		return nil, false
+	}
+	user := req.Recipient.User
+	if user == "" {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 404, "Not Found", nil))
+		return nil, false
+	}
+	expires := defExpires
+	if h := req.GetHeader("Expires"); h != nil {
+		sec, err := strconv.Atoi(strings.TrimSpace(h.Value()))
+		if err != nil || sec < 0 {
+			sipErrorResponse(tx, req)
+			return nil, false
+		}
+		expires = time.Duration(sec) * time.Second
+	}
+	return &subscribeRequest{
+		id:      callID.Value() + ";" + tag,
+		callID:  callID.Value(),
+		from:    from,
+		user:    user,
+		expires: expires,
+		log:     s.log.WithValues("sip-call-id", callID.Value(), "sip-tag", tag),
+	}, true
+}
+
+// authSubscribe authorizes the SUBSCRIBE the same way as INVITE: it must match a trunk, and pass its digest auth.
+// It responds to the request if it's not authorized.
+func (s *Server) authSubscribe(req *sip.Request, tx sip.ServerTransaction, sr *subscribeRequest) bool {
+	username, password, drop, err := s.handler.GetAuthCredentials(context.Background(), sr.from.Address.User, sr.user, req.Recipient.Host, req.Source())
+	if err != nil {
+		sr.log.Warnw("Rejecting SUBSCRIBE, doesn't match any Trunks", err)
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 403, "Forbidden", nil))
+		return false
+	} else if drop {
+		sr.log.Debugw("Dropping SUBSCRIBE flood")
+		tx.Terminate()
+		return false
+	}
+	return s.handleInviteAuth(sr.log, req, tx, sr.from.Address.User, username, password)
+}
+
+// isInDialog checks if the SUBSCRIBE is a refresh or unsubscribe, which are sent within the dialog created
+// by the initial SUBSCRIBE (RFC 6665, section 4.1.2.2).
+func isInDialog(req *sip.Request) bool {
+	to, _ := req.To()
+	return to != nil && to.Params.Has("tag")
+}
+
+// acceptSubscribe responds to the SUBSCRIBE and returns the dialog for notifications. A new dialog is created if d is nil.
+func (s *Server) acceptSubscribe(req *sip.Request, tx sip.ServerTransaction, sr *subscribeRequest, d *subscriptionDialog, event, contentType string) (*subscriptionDialog, bool) {
+	res := sip.NewResponseFromRequest(req, 200, "OK", nil)
+	res.AppendHeader(sip.NewHeader("Expires", strconv.Itoa(int(sr.expires/time.Second))))
+	res.AppendHeader(s.contactHeader(req))
+	if err := tx.Respond(res); err != nil {
+		sr.log.Errorw("Cannot respond to SUBSCRIBE", err)
+		return nil, false
+	}
+	if d == nil {
+		to, _ := res.To()
+		d = &subscriptionDialog{
+			s:           s,
+			event:       event,
+			contentType: contentType,
+			callID:      sr.callID,
+			local:       sip.FromHeader{Address: to.Address, Params: to.Params},
+			remote:      sip.ToHeader{Address: sr.from.Address, Params: sr.from.Params},
+			target:      sr.from.Address,
+		}
+	}
+	d.update(req)
+	return d, true
+}
+
+func (s *Server) onSubscribePresence(req *sip.Request, tx sip.ServerTransaction) {
+	sr, ok := s.parseSubscribe(req, tx, presence.DefaultExpires)
+	if !ok {
+		return
+	}
+	roomName := sr.user
+	log := sr.log.WithValues("roomName", roomName)
+	if !s.authSubscribe(req, tx, sr) {
+		return
+	}
+
+	var d *subscriptionDialog
+	if isInDialog(req) {
+		if sub, ok := s.presence.Lookup(sr.id); ok {
+			d = matchDialog(sub.Dialog, req)
+		}
+		if d == nil {
+			log.Infow("Presence subscription not found")
+			_ = tx.Respond(sip.NewResponseFromRequest(req, 481, "Subscription Does Not Exist", nil))
+			return
+		}
+	}
+	d, ok = s.acceptSubscribe(req, tx, sr, d, presence.EventName, presence.ContentType)
+	if !ok {
+		return
+	}
+	sub := &presence.Subscription{
+		ID:       sr.id,
+		Entity:   fmt.Sprintf("pres:%s@%s", roomName, req.Recipient.Host),
+		RoomName: roomName,
+		Notify: func(sub *presence.Subscription, body []byte) error {
+			return d.notify(sub.Expires, body)
+		},
+		Dialog: d,
+	}
+	if sr.expires == 0 {
+		// Unsubscribe: send the final state and remove the subscription.
+		log.Infow("Presence unsubscribe")
+		s.presence.Unsubscribe(sr.id)
+		sub.Expires = time.Now()
+		body, err := presence.PIDF(sub.Entity, s.presence.Participants(roomName))
+		if err == nil {
+			err = sub.Notify(sub, body)
+		}
+		if err != nil {
+			log.Warnw("Cannot send final presence NOTIFY", err)
+		}
+		return
+	}
+	sub.Expires = time.Now().Add(sr.expires)
+	log.Infow("Presence subscribe", "expires", sr.expires)
+	if err := s.presence.Subscribe(sub); err != nil {
+		log.Warnw("Cannot send presence NOTIFY", err)
+	}
+}
+
+// onSubscribeMWI handles message summary subscriptions (RFC 3842). Subscriptions are forwarded to the handler,
+// while message counts are received from rooms on MWITopic.
+func (s *Server) onSubscribeMWI(req *sip.Request, tx sip.ServerTransaction) {
+	sr, ok := s.parseSubscribe(req, tx, mwi.DefaultExpires)
+	if !ok {
+		return
+	}
+	log := sr.log.WithValues("mailbox", sr.user)
+	if !s.authSubscribe(req, tx, sr) {
+		return
+	}
+
+	var d *subscriptionDialog
+	if isInDialog(req) {
+		if sub, ok := s.mwi.Lookup(sr.id); ok {
+			d = matchDialog(sub.Dialog, req)
+		}
+		if d == nil {
+			log.Infow("MWI subscription not found")
+			_ = tx.Respond(sip.NewResponseFromRequest(req, 481, "Subscription Does Not Exist", nil))
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), subscribeTimeout)
+	trunkID, err := s.handler.SubscribeMWI(ctx, &MWISubscription{
+		ID:         sr.id,
+		User:       sr.user,
+		Host:       req.Recipient.Host,
+		FromUser:   sr.from.Address.User,
+		SrcAddress: req.Source(),
+		Expires:    sr.expires,
+	})
+	cancel()
+	if err != nil && sr.expires != 0 {
+		log.Warnw("Rejecting MWI subscription", err)
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 403, "Forbidden", nil))
+		return
+	}
+	d, ok = s.acceptSubscribe(req, tx, sr, d, mwi.EventName, mwi.ContentType)
+	if !ok {
+		return
+	}
+	sub := &mwi.Subscription{
+		ID:      sr.id,
+		Account: fmt.Sprintf("sip:%s@%s", sr.user, req.Recipient.Host),
+		User:    sr.user,
+		TrunkID: trunkID,
+		Notify: func(sub *mwi.Subscription, body []byte) error {
+			return d.notify(sub.Expires, body)
+		},
+		Dialog: d,
+	}
+	if sr.expires == 0 {
+		log.Infow("MWI unsubscribe")
+		s.mwi.Unsubscribe(sr.id)
+		sub.Expires = time.Now()
+		if err := sub.Notify(sub, mwi.Summary(sub.Account, s.mwi.Counts(trunkID, sr.user))); err != nil {
+			log.Warnw("Cannot send final MWI NOTIFY", err)
+		}
+		return
+	}
+	sub.Expires = time.Now().Add(sr.expires)
+	log.Infow("MWI subscribe", "expires", sr.expires)
+	if err := s.mwi.Subscribe(sub); err != nil {
+		log.Warnw("Cannot send MWI NOTIFY", err)
+	}
+}
+
+// onMWIUpdate sends message counts received from the room of a call from a given trunk to the subscribers.
+func (s *Server) onMWIUpdate(trunkID string, data []byte) {
+	u, err := mwi.ParseUpdate(data)
+	if err != nil {
+		s.log.Warnw("Ignoring MWI update", err)
+		return
+	}
+	if !s.mwi.Update(trunkID, u) {
+		s.log.Infow("Ignoring MWI update, mailbox is not subscribed from the trunk", "mailbox", u.User, "sip-trunk", trunkID)
+	}
+}
+
+// matchDialog returns the dialog of an active subscription, if the request was sent within it.
+func matchDialog(dialog any, req *sip.Request) *subscriptionDialog {
+	d, ok := dialog.(*subscriptionDialog)
+	if !ok {
+		return nil
+	}
+	to, _ := req.To()
+	localTag, _ := d.local.Params.Get("tag")
+	if tag, _ := to.Params.Get("tag"); tag != localTag {
+		return nil
+	}
+	return d
+}
+
+// subscriptionDialog is a dialog created by a SUBSCRIBE. It is used to send NOTIFY requests.
+type subscriptionDialog struct {
+	s           *Server
+	event       string
+	contentType string
+	callID      string
+	local       sip.FromHeader
+	remote      sip.ToHeader
+	cseq        atomic.Uint32
+
+	mu     sync.Mutex
+	target sip.Uri
+	dest   string
+}
+
+// update sets the remote target of the dialog from the SUBSCRIBE request.
+func (d *subscriptionDialog) update(req *sip.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dest = req.Source()
+	if contact, ok := req.Contact(); ok {
+		// NOTIFY is sent to the subscriber's Contact, which may not be the address the SUBSCRIBE was sent from.
+		d.target = contact.Address
+		if contact.Address.Host != "" {
+			port := 5060
+			if contact.Address.Port != 0 {
+				port = contact.Address.Port
+			}
+			d.dest = fmt.Sprintf("%s:%d", contact.Address.Host, port)
+		}
+	}
+}
+
+// notify sends the state to the subscriber. Subscription is reported as terminated once it expires.
+func (d *subscriptionDialog) notify(expires time.Time, body []byte) error {
+	state := "terminated;reason=timeout"
+	if left := time.Until(expires); left > 0 {
+		state = fmt.Sprintf("active;expires=%d", int(left/time.Second))
+	}
+	d.mu.Lock()
+	target, dest := d.target, d.dest
+	d.mu.Unlock()
+	req := sip.NewRequest(sip.NOTIFY, &target)
+	req.SetDestination(dest)
+	req.AppendHeader(&d.local)
+	req.AppendHeader(&d.remote)
+	callID := sip.CallIDHeader(d.callID)
+	req.AppendHeader(&callID)
+	req.AppendHeader(&sip.CSeqHeader{SeqNo: d.cseq.Add(1), MethodName: sip.NOTIFY})
+	req.AppendHeader(sip.NewHeader("Event", d.event))
+	req.AppendHeader(sip.NewHeader("Subscription-State", state))
+	req.AppendHeader(sip.NewHeader("Content-Type", d.contentType))
+	req.SetBody(body)
+
+	tx, err := d.s.sipCli.TransactionRequest(req)
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer tx.Terminate()
+		select {
+		case <-tx.Responses():
+		case <-tx.Done():
+		case <-time.After(notifyTimeout):
+		}
+	}()
+	return nil
+}
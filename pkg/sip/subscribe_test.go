@@ -0,0 +1,308 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/livekit/protocol/logger"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/sip/mwi"
+	"github.com/livekit/sip/pkg/sip/presence"
+)
+
+func TestService_Presence(t *testing.T) {
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+	s, sipServerAddress := startTestService(t, &config.Config{}, func(s *Service) {
+		s.SetHandler(&TestHandler{
+			GetAuthCredentialsFunc: func(ctx context.Context, fromUser, toUser, toHost, srcAddress string) (username, password string, drop bool, err error) {
+				switch toUser {
+				case "unknown":
+					return "", "", false, errors.New("no trunk")
+				case "secure":
+					return "user", "pass", false, nil
+				}
+				return "", "", false, nil
+			},
+		})
+	})
+
+	notify := make(chan *sip.Request, 10)
+	subCli, subAddr := newTestSubscriber(t, notify)
+
+	var last *sip.Request
+	expectNotify := func() *presence.Document {
+		t.Helper()
+		select {
+		case req := <-notify:
+			ev := req.GetHeader("Event")
+			require.NotNil(t, ev)
+			require.Equal(t, presence.EventName, ev.Value())
+			doc, err := presence.ParsePIDF(req.Body())
+			require.NoError(t, err)
+			last = req
+			return doc
+		case <-time.After(5 * time.Second):
+			t.Fatal("no NOTIFY received")
+			return nil
+		}
+	}
+
+	req := sip.NewRequest(sip.SUBSCRIBE, &sip.Uri{User: "room", Host: sipServerAddress})
+	req.SetDestination(sipServerAddress)
+	req.AppendHeader(sip.NewHeader("Event", presence.EventName))
+	req.AppendHeader(sip.NewHeader("Expires", "60"))
+	req.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: "sub", Host: localIP, Port: subAddr.Port}})
+	tx, err := subCli.TransactionRequest(req)
+	require.NoError(t, err)
+	t.Cleanup(tx.Terminate)
+	res := getResponseOrFail(t, tx)
+	require.Equal(t, sip.StatusCode(200), res.StatusCode)
+
+	// Initial state.
+	doc := expectNotify()
+	require.Equal(t, []presence.Tuple{{ID: "room", Basic: presence.BasicClosed}}, doc.Tuples)
+
+	s.srv.presence.ParticipantJoined("room", "alice")
+	doc = expectNotify()
+	require.Equal(t, []presence.Tuple{{ID: "alice", Basic: presence.BasicOpen, Contact: "alice"}}, doc.Tuples)
+	first := last
+
+	// Refresh within the same dialog.
+	refresh := func(toTag string) *sip.Response {
+		t.Helper()
+		from, _ := req.From()
+		to, _ := res.To()
+		callID, _ := req.CallID()
+		cseq, _ := req.CSeq()
+		r := sip.NewRequest(sip.SUBSCRIBE, &sip.Uri{User: "room", Host: sipServerAddress})
+		r.SetDestination(sipServerAddress)
+		r.AppendHeader(from)
+		toParams := sip.NewParams()
+		toParams.Add("tag", toTag)
+		r.AppendHeader(&sip.ToHeader{Address: to.Address, Params: toParams})
+		r.AppendHeader(callID)
+		r.AppendHeader(&sip.CSeqHeader{SeqNo: cseq.SeqNo + 1, MethodName: sip.SUBSCRIBE})
+		r.AppendHeader(sip.NewHeader("Event", presence.EventName))
+		r.AppendHeader(sip.NewHeader("Expires", "60"))
+		r.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: "sub", Host: localIP, Port: subAddr.Port}})
+		tx, err := subCli.TransactionRequest(r)
+		require.NoError(t, err)
+		t.Cleanup(tx.Terminate)
+		return getResponseOrFail(t, tx)
+	}
+	to, _ := res.To()
+	localTag, _ := to.Params.Get("tag")
+	res = refresh(localTag)
+	require.Equal(t, sip.StatusCode(200), res.StatusCode)
+	to, _ = res.To()
+	tag, _ := to.Params.Get("tag")
+	require.Equal(t, localTag, tag)
+	expectNotify()
+	require.Equal(t, 1, s.srv.presence.Subscriptions())
+
+	// NOTIFY must continue the same dialog.
+	firstFrom, _ := first.From()
+	lastFrom, _ := last.From()
+	firstCSeq, _ := first.CSeq()
+	lastCSeq, _ := last.CSeq()
+	require.Equal(t, firstFrom.Params["tag"], lastFrom.Params["tag"])
+	require.Equal(t, localTag, lastFrom.Params["tag"])
+	require.Greater(t, lastCSeq.SeqNo, firstCSeq.SeqNo)
+
+	// Unknown dialog.
+	res = refresh("unknown")
+	require.Equal(t, sip.StatusCode(481), res.StatusCode)
+
+	// Subscriptions must match a trunk, and pass its auth.
+	for room, status := range map[string]sip.StatusCode{"unknown": 403, "secure": 407} {
+		r := sip.NewRequest(sip.SUBSCRIBE, &sip.Uri{User: room, Host: sipServerAddress})
+		r.SetDestination(sipServerAddress)
+		r.AppendHeader(sip.NewHeader("Event", presence.EventName))
+		r.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: "sub", Host: localIP, Port: subAddr.Port}})
+		tx, err := subCli.TransactionRequest(r)
+		require.NoError(t, err)
+		t.Cleanup(tx.Terminate)
+		require.Equal(t, status, getResponseOrFail(t, tx).StatusCode, room)
+	}
+	require.Equal(t, 1, s.srv.presence.Subscriptions())
+}
+
+func TestService_SubscribeBadEvent(t *testing.T) {
+	sipPort := rand.Intn(testPortSIPMax-testPortSIPMin) + testPortSIPMin
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+	sipServerAddress := fmt.Sprintf("%s:%d", localIP, sipPort)
+
+	s, err := NewService(&config.Config{
+		SIPPort:    sipPort,
+		RTPPortMin: testPortRTPMin,
+		RTPPortMax: testPortRTPMax,
+	}, logger.GetLogger())
+	require.NoError(t, err)
+	t.Cleanup(s.Stop)
+	require.NoError(t, s.Start())
+
+	ua, err := sipgo.NewUA()
+	require.NoError(t, err)
+	cli, err := sipgo.NewClient(ua)
+	require.NoError(t, err)
+
+	req := sip.NewRequest(sip.SUBSCRIBE, &sip.Uri{User: "room", Host: sipServerAddress})
+	req.SetDestination(sipServerAddress)
+	req.AppendHeader(sip.NewHeader("Event", "dialog"))
+	tx, err := cli.TransactionRequest(req)
+	require.NoError(t, err)
+	t.Cleanup(tx.Terminate)
+	res := getResponseOrFail(t, tx)
+	require.Equal(t, sip.StatusCode(489), res.StatusCode)
+	allow := res.GetHeader("Allow-Events")
+	require.NotNil(t, allow)
+	require.Equal(t, "presence, message-summary", allow.Value())
+}
+
+// newTestSubscriber starts a SIP client which receives NOTIFY requests on a given channel.
+// It returns the client and the address of its listener.
+func newTestSubscriber(t *testing.T, notify chan<- *sip.Request) (*sipgo.Client, *net.UDPAddr) {
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(localIP)})
+	require.NoError(t, err)
+
+	// NOTIFY listener has its own UA, requests are sent from a different one.
+	srvUA, err := sipgo.NewUA()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = srvUA.Close() })
+	srv, err := sipgo.NewServer(srvUA)
+	require.NoError(t, err)
+	srv.OnNotify(func(req *sip.Request, tx sip.ServerTransaction) {
+		notify <- req
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	})
+	ready := &readyConn{PacketConn: conn, ready: make(chan struct{})}
+	go func() {
+		_ = srv.ServeUDP(ready)
+	}()
+	<-ready.ready
+
+	cliUA, err := sipgo.NewUA()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = cliUA.Close() })
+	cli, err := sipgo.NewClient(cliUA, sipgo.WithClientHostname(localIP))
+	require.NoError(t, err)
+	return cli, conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestService_MWI(t *testing.T) {
+	subs := make(chan *MWISubscription, 10)
+	s, addr := startTestService(t, &config.Config{}, func(s *Service) {
+		s.SetHandler(&TestHandler{
+			GetAuthCredentialsFunc: noAuth,
+			SubscribeMWIFunc: func(ctx context.Context, sub *MWISubscription) (string, error) {
+				subs <- sub
+				if sub.User == "denied" {
+					return "", errors.New("unknown mailbox")
+				}
+				return "ST_pbx", nil
+			},
+		})
+	})
+	notify := make(chan *sip.Request, 10)
+	cli, subAddr := newTestSubscriber(t, notify)
+
+	expectSummary := func() (bool, mwi.Counts) {
+		t.Helper()
+		select {
+		case req := <-notify:
+			ev := req.GetHeader("Event")
+			require.NotNil(t, ev)
+			require.Equal(t, mwi.EventName, ev.Value())
+			ctype, ok := req.ContentType()
+			require.True(t, ok)
+			require.Equal(t, mwi.ContentType, ctype.Value())
+			require.Contains(t, string(req.Body()), "Message-Account: sip:alice@")
+			waiting, c, err := mwi.ParseSummary(req.Body())
+			require.NoError(t, err)
+			return waiting, c
+		case <-time.After(5 * time.Second):
+			t.Fatal("no NOTIFY received")
+			return false, mwi.Counts{}
+		}
+	}
+	subscribe := func(user string) *sip.Response {
+		t.Helper()
+		req := sip.NewRequest(sip.SUBSCRIBE, &sip.Uri{User: user, Host: addr})
+		req.SetDestination(addr)
+		req.AppendHeader(sip.NewHeader("Event", mwi.EventName))
+		req.AppendHeader(sip.NewHeader("Expires", "60"))
+		req.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: "pbx", Host: subAddr.IP.String(), Port: subAddr.Port}})
+		tx, err := cli.TransactionRequest(req)
+		require.NoError(t, err)
+		t.Cleanup(tx.Terminate)
+		return getResponseOrFail(t, tx)
+	}
+
+	res := subscribe("alice")
+	require.Equal(t, sip.StatusCode(200), res.StatusCode)
+	sub := <-subs
+	require.Equal(t, "alice", sub.User)
+	require.Equal(t, time.Minute, sub.Expires)
+
+	// Initial state.
+	waiting, c := expectSummary()
+	require.False(t, waiting)
+	require.Equal(t, mwi.Counts{}, c)
+
+	// Message counts are published to the room of a call from the trunk of the subscriber.
+	newCall := func(user, trunkID string) *inboundCall {
+		call := addTestCall(s, user, user+"-tag")
+		call.trunkID = trunkID
+		// addTestCall replaces the room of the call
+		call.lkRoom.OnMWI(func(data []byte) { s.srv.onMWIUpdate(trunkID, data) })
+		return call
+	}
+	call := newCall("bob", "ST_pbx")
+	call.lkRoom.handleData(&lksdk.UserDataPacket{Topic: MWITopic, Payload: []byte(`{"user":"alice","new":2,"old":8,"old_urgent":1}`)}, lksdk.DataReceiveParams{})
+	waiting, c = expectSummary()
+	require.True(t, waiting)
+	require.Equal(t, mwi.Counts{New: 2, Old: 8, OldUrgent: 1}, c)
+
+	// Other mailboxes, invalid updates and updates from rooms of other trunks are not sent.
+	call.lkRoom.handleData(&lksdk.UserDataPacket{Topic: MWITopic, Payload: []byte(`{"user":"carol","new":1}`)}, lksdk.DataReceiveParams{})
+	call.lkRoom.handleData(&lksdk.UserDataPacket{Topic: MWITopic, Payload: []byte(`{"new":1}`)}, lksdk.DataReceiveParams{})
+	other := newCall("mallory", "ST_other")
+	other.lkRoom.handleData(&lksdk.UserDataPacket{Topic: MWITopic, Payload: []byte(`{"user":"alice","new":9}`)}, lksdk.DataReceiveParams{})
+	select {
+	case <-notify:
+		t.Fatal("unexpected NOTIFY")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Handler rejects the subscription.
+	res = subscribe("denied")
+	require.Equal(t, sip.StatusCode(403), res.StatusCode)
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"fmt"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/livekit/protocol/logger"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media"
+	"github.com/livekit/sip/pkg/media/rtp"
+	"github.com/livekit/sip/pkg/transcription"
+)
+
+// TranscriptionTopic is a LiveKit data topic for transcripts of the call, which are relayed to the SIP side with INFO.
+const TranscriptionTopic = "sip_transcription"
+
+// newTranscriber creates a streamer of the decoded SIP audio, if transcription is enabled.
+func newTranscriber(conf *config.Config, log logger.Logger, callID string) *transcription.Streamer {
+	return transcription.NewStreamer(conf.TranscriptionWebhookURL, callID, rtp.DefSampleRate, log)
+}
+
+// withTranscriber adds the transcriber to the decoding pipeline, if it's set.
+func withTranscriber(w media.PCM16Writer, t *transcription.Streamer) media.PCM16Writer {
+	if t == nil {
+		return w
+	}
+	return media.WriterTee[media.PCM16Sample](w, t)
+}
+
+// sipTranscription sends the transcript to the caller with SIP INFO.
+func (c *inboundCall) sipTranscription(text string) error {
+	req, err := c.sipDialogRequest(sip.INFO)
+	if err != nil {
+		return err
+	}
+	req.AppendHeader(sip.NewHeader("Content-Type", "text/plain;charset=UTF-8"))
+	req.SetBody([]byte(text))
+	tx, err := c.s.sipCli.TransactionRequest(req)
+	if err != nil {
+		return err
+	}
+	defer tx.Terminate()
+	resp, err := sipResponse(tx)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status from SIP INFO: %d %s", resp.StatusCode, resp.Reason)
+	}
+	return nil
+}
+
+// sipTranscription sends the transcript to the remote side of the outbound call with SIP INFO.
+func (c *outboundCall) sipTranscription(text string) error {
+	return c.sipText(sip.INFO, text)
+}
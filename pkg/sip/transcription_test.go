@@ -0,0 +1,119 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	lksdk "github.com/livekit/server-sdk-go/v2"
+	prtp "github.com/pion/rtp"
+	"github.com/pion/sdp/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media/rtp"
+	lksdp "github.com/livekit/sip/pkg/media/sdp"
+	"github.com/livekit/sip/pkg/media/ulaw"
+	"github.com/livekit/sip/pkg/transcription"
+)
+
+// testTranscriptionBatch is a batch of audio received by the mock transcription endpoint.
+type testTranscriptionBatch struct {
+	callID  string
+	samples int
+}
+
+// newTestTranscriptionServer starts a mock transcription endpoint which reports every batch of audio it receives.
+func newTestTranscriptionServer(t *testing.T) (string, <-chan testTranscriptionBatch) {
+	batches := make(chan testTranscriptionBatch, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, transcription.ContentType(rtp.DefSampleRate), r.Header.Get("Content-Type"))
+		batches <- testTranscriptionBatch{callID: r.Header.Get(transcription.CallIDHeader), samples: len(body) / 2}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, batches
+}
+
+func TestService_Transcription(t *testing.T) {
+	const frameSize = 160
+	url, batches := newTestTranscriptionServer(t)
+
+	callbacks := make(chan *lksdk.RoomCallback, 1)
+	_, addr := startTestService(t, &config.Config{TranscriptionWebhookURL: url}, func(s *Service) {
+		s.SetHandler(acceptHandler("room", ""))
+		connect := newTestRoomConnector(make(chan *testRoomConn, 1))
+		s.srv.connectRoom = func(conf *config.Config, rc lkRoomConfig, cb *lksdk.RoomCallback) (roomConn, error) {
+			callbacks <- cb
+			return connect(conf, rc, cb)
+		}
+	})
+
+	conn := rtp.NewConn(nil)
+	require.NoError(t, conn.ListenAndServe(0, 0, "0.0.0.0"))
+	t.Cleanup(func() { _ = conn.Close() })
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+	offer, err := sdpGenerateOfferWith(localIP, conn.LocalAddr().Port, []sdpCodecInfo{
+		{Type: prtp.PayloadTypePCMU, Codec: lksdp.CodecByName(ulaw.SDPName)},
+	})
+	require.NoError(t, err)
+
+	phone := newTestPhone(t, "alice")
+	_, res := phone.Call(t, addr, "room", offer)
+	var answer sdp.SessionDescription
+	require.NoError(t, answer.Unmarshal(res.Body()))
+	conn.SetDestAddr(sdpGetAudioDest(answer))
+
+	// Caller audio is posted to the transcription endpoint in batches.
+	frame := make([]int16, frameSize)
+	for i := 0; i < int(transcription.DefaultBatchDur/rtp.DefFrameDur)+10; i++ {
+		require.NoError(t, conn.WriteRTP(&prtp.Packet{
+			Header:  prtp.Header{Version: 2, PayloadType: prtp.PayloadTypePCMU, SequenceNumber: uint16(i), Timestamp: uint32(i * frameSize), SSRC: 0xA11CE},
+			Payload: ulaw.EncodeUlaw(frame),
+		}))
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case b := <-batches:
+		require.Contains(t, b.callID, "SCL_")
+		require.Equal(t, rtp.DefSampleRate*int(transcription.DefaultBatchDur/time.Millisecond)/1000, b.samples)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no audio posted for transcription")
+	}
+
+	// Transcripts from the room are relayed to the caller.
+	var cb *lksdk.RoomCallback
+	select {
+	case cb = <-callbacks:
+	case <-time.After(5 * time.Second):
+		t.Fatal("call did not join the room")
+	}
+	cb.ParticipantCallback.OnDataPacket(&lksdk.UserDataPacket{Topic: TranscriptionTopic, Payload: []byte("hello world")}, lksdk.DataReceiveParams{SenderIdentity: "agent"})
+	select {
+	case req := <-phone.info:
+		require.Equal(t, "hello world", string(req.Body()))
+		h, ok := req.ContentType()
+		require.True(t, ok)
+		require.Equal(t, "text/plain;charset=UTF-8", h.Value())
+	case <-time.After(5 * time.Second):
+		t.Fatal("transcript was not relayed")
+	}
+}
@@ -0,0 +1,331 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/parser"
+	"github.com/emiago/sipgo/sip"
+	"github.com/livekit/protocol/logger"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+const (
+	transferPrefix = "TRANSFER:"
+
+	// TransferTopic is a LiveKit data topic used to report the result of transfers requested over the data channel.
+	TransferTopic = "sip_transfer"
+
+	EventTransferCompleted = "transfer.completed"
+	EventTransferFailed    = "transfer.failed"
+)
+
+var (
+	errNotTransfer        = errors.New("not a transfer request")
+	errInvalidTransfer    = errors.New("invalid transfer request")
+	errTransferInProgress = errors.New("transfer already in progress")
+	errNoTransfer         = errors.New("no transfer was started")
+)
+
+// parseTransfer parses data channel transfer requests in the "TRANSFER:<call_id>:<target_uri>" format.
+func parseTransfer(s string) (callID, target string, err error) {
+	s, ok := strings.CutPrefix(strings.TrimSpace(s), transferPrefix)
+	if !ok {
+		return "", "", errNotTransfer
+	}
+	callID, target, ok = strings.Cut(s, ":")
+	if !ok || callID == "" || target == "" {
+		return "", "", fmt.Errorf("%w: expected <call_id>:<target_uri>", errInvalidTransfer)
+	}
+	if !strings.HasPrefix(target, "sip:") && !strings.HasPrefix(target, "sips:") {
+		return "", "", fmt.Errorf("%w: target must be a SIP URI", errInvalidTransfer)
+	}
+	var uri sip.Uri
+	if err = parser.ParseUri(target, &uri); err != nil || uri.Host == "" {
+		return "", "", fmt.Errorf("%w: invalid target URI %q", errInvalidTransfer, target)
+	}
+	return callID, target, nil
+}
+
+// transferEvent is a JSON payload sent to the room on TransferTopic.
+type transferEvent struct {
+	Event  string `json:"event"`
+	CallID string `json:"call_id"`
+	Target string `json:"target"`
+	Status int    `json:"status,omitempty"` // SIP status reported by the remote side
+	Error  string `json:"error,omitempty"`
+}
+
+func sendTransferEvent(log logger.Logger, r *Room, ev *transferEvent) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		log.Errorw("Cannot encode transfer event", err)
+		return
+	}
+	log.Infow("Transfer finished", "event", ev.Event, "target", ev.Target, "status", ev.Status)
+	if err = r.SendData(&lksdk.UserDataPacket{Payload: data, Topic: TransferTopic}, lksdk.WithDataPublishReliable(true)); err != nil {
+		log.Warnw("Cannot send transfer event", err)
+	}
+}
+
+// TransferResult is the outcome of a REFER transfer, as reported by the final NOTIFY of the referred party.
+type TransferResult struct {
+	Success bool
+	// Code is the SIP status of the call to the transfer target, or the status of the REFER request, if it was rejected.
+	// It's zero if the REFER request failed without a response.
+	Code int
+}
+
+// sipTransfer tracks a REFER transfer of the call. Only one transfer can be in progress.
+//
+// The transfer starts when the REFER is sent, and ends either when the REFER fails, or when the final NOTIFY
+// with the "refer" event is received (RFC 3515).
+type sipTransfer struct {
+	mu     sync.Mutex
+	target string
+	done   chan struct{} // closed once the last transfer ends
+	res    TransferResult
+}
+
+func (t *sipTransfer) begin(target string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.target != "" {
+		return errTransferInProgress
+	}
+	t.target = target
+	t.done = make(chan struct{})
+	t.res = TransferResult{}
+	return nil
+}
+
+// finish ends the transfer in progress with a given status and returns its target.
+func (t *sipTransfer) finish(code int) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	target := t.target
+	if target == "" {
+		return ""
+	}
+	t.target = ""
+	t.res = TransferResult{Success: code >= 200 && code < 300, Code: code}
+	close(t.done)
+	return target
+}
+
+// await waits for the last transfer to end and returns its result.
+func (t *sipTransfer) await(ctx context.Context, closed <-chan struct{}) (TransferResult, error) {
+	t.mu.Lock()
+	done := t.done
+	t.mu.Unlock()
+	if done == nil {
+		return TransferResult{}, errNoTransfer
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return TransferResult{}, ctx.Err()
+	case <-closed:
+		// Completed transfers close the call, so the result may be ready as well.
+		select {
+		case <-done:
+		default:
+			return TransferResult{}, errors.New("call closed before the transfer ended")
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.res, nil
+}
+
+// refer sends a REFER request created for the call dialog and waits for it to be accepted.
+// The outcome of the transfer is reported later by NOTIFY requests.
+func (t *sipTransfer) refer(cli *sipgo.Client, req *sip.Request, contact *sip.ContactHeader, target string) error {
+	if err := t.begin(target); err != nil {
+		return err
+	}
+	req.AppendHeader(sip.NewHeader("Refer-To", "<"+target+">"))
+	req.AppendHeader(contact)
+	tx, err := cli.TransactionRequest(req)
+	if err != nil {
+		t.finish(0)
+		return err
+	}
+	defer tx.Terminate()
+	resp, err := sipResponse(tx)
+	if err != nil {
+		t.finish(0)
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		t.finish(int(resp.StatusCode))
+		return fmt.Errorf("REFER rejected with status %d %s", resp.StatusCode, resp.Reason)
+	}
+	return nil
+}
+
+// parseSipFrag returns the status code from a "message/sipfrag" body, e.g. "SIP/2.0 200 OK".
+func parseSipFrag(body []byte) (int, error) {
+	line, _, _ := strings.Cut(string(body), "\n")
+	f := strings.Fields(line)
+	if len(f) < 2 || !strings.HasPrefix(f[0], "SIP/") {
+		return 0, fmt.Errorf("invalid sipfrag: %q", line)
+	}
+	code, err := strconv.Atoi(f[1])
+	if err != nil || code < 100 || code > 699 {
+		return 0, fmt.Errorf("invalid sipfrag status: %q", f[1])
+	}
+	return code, nil
+}
+
+// handleNotify responds to a NOTIFY request for the transfer. It returns the final transfer event, if any.
+func (t *sipTransfer) handleNotify(req *sip.Request, tx sip.ServerTransaction, callID string) *transferEvent {
+	if h := req.GetHeader("Event"); h == nil || !strings.HasPrefix(strings.TrimSpace(h.Value()), "refer") {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 489, "Bad Event", nil))
+		return nil
+	}
+	status, err := parseSipFrag(req.Body())
+	if err != nil {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 400, "Bad Request", nil))
+		return nil
+	}
+	var target string
+	if status >= 200 {
+		target = t.finish(status)
+	} else {
+		t.mu.Lock()
+		target = t.target
+		t.mu.Unlock()
+	}
+	if target == "" {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 481, "Call/Transaction Does Not Exist", nil))
+		return nil
+	}
+	_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+	if status < 200 {
+		return nil // still in progress
+	}
+	ev := &transferEvent{Event: EventTransferCompleted, CallID: callID, Target: target, Status: status}
+	if status >= 300 {
+		ev.Event = EventTransferFailed
+	}
+	return ev
+}
+
+// transferFromRoom returns a data channel transfer handler for the call.
+func transferFromRoom(conf *config.Config, log logger.Logger, r *Room, callID string, transfer func(target string) error) func(sender, callID, target string) {
+	return func(sender, id, target string) {
+		if id != callID {
+			return // transfer for another SIP participant
+		}
+		if !conf.DataChannelSenderAllowed(sender) {
+			log.Warnw("Ignoring transfer request from unauthorized participant", nil, "sender", sender, "target", target)
+			return
+		}
+		// Do not block the room callback while waiting for the SIP response.
+		go func() {
+			log.Infow("Transferring call requested over data channel", "sender", sender, "target", target)
+			if err := transfer(target); err != nil {
+				log.Warnw("Cannot transfer call", err, "target", target)
+				sendTransferEvent(log, r, &transferEvent{Event: EventTransferFailed, CallID: callID, Target: target, Error: err.Error()})
+			}
+		}()
+	}
+}
+
+func (s *Server) onNotify(req *sip.Request, tx sip.ServerTransaction) {
+	c := s.dialogCall(req)
+	if c == nil {
+		if s.sipUnhandled != nil {
+			s.sipUnhandled(req, tx)
+			return
+		}
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 481, "Call/Transaction Does Not Exist", nil))
+		return
+	}
+	if ev := c.transfer.handleNotify(req, tx, c.id); ev != nil {
+		sendTransferEvent(c.log, c.lkRoom, ev)
+		if ev.Event == EventTransferCompleted {
+			// Caller is connected to the target now, thus our leg of the call ends (RFC 5589, section 6.1).
+			_ = c.Close()
+		}
+	}
+}
+
+// transferCall asks the caller to call the target instead (RFC 3515).
+func (c *inboundCall) transferCall(target string) error {
+	var contact *sip.ContactHeader
+	c.dialogMu.Lock()
+	if c.inviteReq != nil {
+		contact = c.s.contactHeader(c.inviteReq)
+	}
+	c.dialogMu.Unlock()
+	req, err := c.sipDialogRequest(sip.REFER)
+	if err != nil {
+		return err
+	}
+	return c.transfer.refer(c.s.sipCli, req, contact, target)
+}
+
+func (c *Client) onNotify(req *sip.Request, tx sip.ServerTransaction) {
+	call := c.dialogCall(req)
+	if call == nil {
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 481, "Call/Transaction Does Not Exist", nil))
+		return
+	}
+	if ev := call.transfer.handleNotify(req, tx, call.id); ev != nil {
+		call.mu.RLock()
+		room := call.lkRoom
+		call.mu.RUnlock()
+		sendTransferEvent(call.log, room, ev)
+		if ev.Event == EventTransferCompleted {
+			// Callee is connected to the target now, thus our leg of the call ends (RFC 5589, section 6.1).
+			call.CloseWithReason("transferred")
+		}
+	}
+}
+
+// AwaitTransferResult waits for the last transfer of the call to end, and returns its result.
+// It fails if no transfer was started, or if the call ends while the transfer is still in progress.
+func (c *outboundCall) AwaitTransferResult(ctx context.Context) (TransferResult, error) {
+	return c.transfer.await(ctx, c.Closed())
+}
+
+// transferCall asks the callee to call the target instead (RFC 3515).
+func (c *outboundCall) transferCall(target string) error {
+	c.mu.Lock()
+	if c.sipInviteReq == nil {
+		c.mu.Unlock()
+		return errors.New("call is not active")
+	}
+	if !c.sipCaps.allows(sip.REFER) {
+		c.mu.Unlock()
+		return errors.New("trunk does not allow REFER")
+	}
+	req := c.sipDialogRequest(sip.REFER, nil)
+	contact := &sip.ContactHeader{Address: sip.Uri{User: c.sipCur.from, Host: c.c.signalingIp}}
+	c.mu.Unlock()
+	return c.transfer.refer(c.c.sipCli, req, contact, target)
+}
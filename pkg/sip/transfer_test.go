@@ -0,0 +1,361 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/media/rtp"
+)
+
+func TestParseTransfer(t *testing.T) {
+	cases := []struct {
+		in     string
+		callID string
+		target string
+		err    error
+	}{
+		{in: "TRANSFER:SCL_1:sip:bob@example.com", callID: "SCL_1", target: "sip:bob@example.com"},
+		{in: " TRANSFER:SCL_1:sip:+15551234567@example.com:5060 ", callID: "SCL_1", target: "sip:+15551234567@example.com:5060"},
+		{in: "DIAL:+1555@ST_out", err: errNotTransfer},
+		{in: "TRANSFER:SCL_1", err: errInvalidTransfer},
+		{in: "TRANSFER::sip:bob@example.com", err: errInvalidTransfer},
+		{in: "TRANSFER:SCL_1:", err: errInvalidTransfer},
+		{in: "TRANSFER:SCL_1:bob", err: errInvalidTransfer},
+	}
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			callID, target, err := parseTransfer(c.in)
+			if c.err != nil {
+				require.ErrorIs(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.callID, callID)
+			require.Equal(t, c.target, target)
+		})
+	}
+}
+
+func newTestNotify(addr, tag, event, body string) *sip.Request {
+	req := newTestDialogRequest(sip.NOTIFY, addr, "alice", tag, testSIPCallID(tag))
+	req.AppendHeader(sip.NewHeader("Content-Type", "message/sipfrag;version=2.0"))
+	req.SetBody([]byte(body))
+	if event != "" {
+		req.AppendHeader(sip.NewHeader("Event", event))
+	}
+	return req
+}
+
+func expectTransferEvent(t *testing.T, data <-chan lksdk.DataPacket) transferEvent {
+	t.Helper()
+	select {
+	case p := <-data:
+		u, ok := p.(*lksdk.UserDataPacket)
+		require.True(t, ok)
+		require.Equal(t, TransferTopic, u.Topic)
+		var ev transferEvent
+		require.NoError(t, json.Unmarshal(u.Payload, &ev))
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no transfer event")
+	}
+	return transferEvent{}
+}
+
+func TestService_TransferNotify(t *testing.T) {
+	s, addr := startTestService(t, &config.Config{})
+	call := addTestCall(s, "alice", "alice-tag")
+	data := setTestRoomConn(call.lkRoom)
+	notify := func(t *testing.T, event, body string) sip.StatusCode {
+		t.Helper()
+		return sendTestRequest(t, addr, "alice", newTestNotify(addr, call.tag, event, body)).StatusCode
+	}
+
+	// No transfer in progress.
+	require.Equal(t, sip.StatusCode(481), notify(t, "refer", "SIP/2.0 200 OK"))
+
+	require.NoError(t, call.transfer.begin("sip:bob@example.com"))
+	require.ErrorIs(t, call.transfer.begin("sip:carol@example.com"), errTransferInProgress)
+
+	require.Equal(t, sip.StatusCode(489), notify(t, "presence", "SIP/2.0 200 OK"))
+	require.Equal(t, sip.StatusCode(400), notify(t, "refer", "hello"))
+	require.Equal(t, sip.StatusCode(200), notify(t, "refer", "SIP/2.0 100 Trying"))
+	require.Empty(t, data)
+
+	// Failed transfer keeps the call.
+	require.Equal(t, sip.StatusCode(200), notify(t, "refer", "SIP/2.0 486 Busy Here"))
+	require.Equal(t, transferEvent{
+		Event:  EventTransferFailed,
+		CallID: call.id,
+		Target: "sip:bob@example.com",
+		Status: 486,
+	}, expectTransferEvent(t, data))
+	require.NoError(t, call.ctx.Err())
+
+	// Transfer is done, a new one can be started.
+	require.NoError(t, call.transfer.begin("sip:carol@example.com"))
+	require.Equal(t, sip.StatusCode(200), notify(t, "refer;id=2", "SIP/2.0 200 OK\r\n"))
+	require.Equal(t, transferEvent{
+		Event:  EventTransferCompleted,
+		CallID: call.id,
+		Target: "sip:carol@example.com",
+		Status: 200,
+	}, expectTransferEvent(t, data))
+	// Caller is connected to the target, so the call ends.
+	require.Error(t, call.ctx.Err())
+}
+
+func TestService_TransferHangup(t *testing.T) {
+	joined := make(chan *testRoomConn, 1)
+	s, addr := startTestService(t, &config.Config{}, func(s *Service) {
+		s.srv.connectRoom = newTestRoomConnector(joined)
+		s.SetHandler(acceptHandler("room", ""))
+	})
+	alice := newTestPhone(t, "alice")
+	req, res := alice.Call(t, addr, "transfer", nil)
+	select {
+	case <-joined:
+	case <-time.After(5 * time.Second):
+		t.Fatal("call did not join the room")
+	}
+	from, _ := req.From()
+	fromTag, _ := from.Params.Get("tag")
+	s.srv.cmu.RLock()
+	call := s.srv.activeCalls[fromTag]
+	s.srv.cmu.RUnlock()
+	require.NotNil(t, call)
+	require.NoError(t, call.transfer.begin("sip:bob@example.com"))
+
+	// The phone reports that the transfer target answered.
+	notify := sip.NewRequest(sip.NOTIFY, &sip.Uri{User: "transfer", Host: addr})
+	notify.AppendHeader(sip.HeaderClone(from))
+	to, _ := res.To()
+	notify.AppendHeader(sip.HeaderClone(to))
+	callID, _ := req.CallID()
+	notify.AppendHeader(sip.HeaderClone(callID))
+	notify.AppendHeader(sip.NewHeader("Event", "refer"))
+	notify.AppendHeader(sip.NewHeader("Content-Type", "message/sipfrag;version=2.0"))
+	notify.SetBody([]byte("SIP/2.0 200 OK"))
+	require.Equal(t, sip.StatusCode(200), sendTestRequest(t, addr, "alice", notify).StatusCode)
+
+	select {
+	case bye := <-alice.bye:
+		byeCallID, _ := bye.CallID()
+		require.Equal(t, callID.Value(), byeCallID.Value())
+	case <-time.After(5 * time.Second):
+		t.Fatal("transferred call was not hung up")
+	}
+}
+
+func TestOutboundRefer(t *testing.T) {
+	refers := make(chan *sip.Request, 1)
+	uas := newTestUASWith(t, func(srv *sipgo.Server) {
+		srv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+			res := sip.NewResponseFromRequest(req, 200, "OK", nil)
+			if to, ok := res.To(); ok {
+				to.Params.Add("tag", "callee-tag")
+			}
+			_ = tx.Respond(res)
+		})
+		srv.OnRefer(func(req *sip.Request, tx sip.ServerTransaction) {
+			refers <- req
+			_ = tx.Respond(sip.NewResponseFromRequest(req, 202, "Accepted", nil))
+		})
+	})
+	call := newTestOutboundCall(t, &config.Config{})
+	conf := sipOutboundConfig{address: uas.String(), from: "from", to: "bob"}
+	req, resp, err := call.sipInvite(nil, conf)
+	require.NoError(t, err)
+	call.sipCur = conf
+	call.sipInviteReq, call.sipInviteResp = req, resp
+
+	require.NoError(t, call.transferCall("sip:carol@example.com"))
+	var refer *sip.Request
+	select {
+	case refer = <-refers:
+	case <-time.After(time.Second):
+		t.Fatal("no REFER")
+	}
+	h := refer.GetHeader("Refer-To")
+	require.NotNil(t, h)
+	require.Equal(t, "<sip:carol@example.com>", h.Value())
+	require.NotNil(t, refer.GetHeader("Contact"))
+
+	// REFER is sent within the call dialog.
+	callID, _ := req.CallID()
+	referCallID, _ := refer.CallID()
+	require.Equal(t, callID.Value(), referCallID.Value())
+	to, _ := refer.To()
+	require.Equal(t, "callee-tag", headerTag(to.Params))
+	from, _ := req.From()
+	referFrom, _ := refer.From()
+	require.Equal(t, headerTag(from.Params), headerTag(referFrom.Params))
+	cseq, _ := req.CSeq()
+	referCSeq, _ := refer.CSeq()
+	require.Equal(t, cseq.SeqNo+1, referCSeq.SeqNo)
+
+	// Only one transfer can be in progress.
+	require.ErrorIs(t, call.transferCall("sip:dave@example.com"), errTransferInProgress)
+
+	// NOTIFY from the callee is matched by the dialog, not by the user.
+	call.c.cmu.Lock()
+	call.c.activeCalls[call] = struct{}{}
+	call.c.cmu.Unlock()
+	notify := newUASDialogRequest(sip.NOTIFY, req, resp)
+	require.Equal(t, call, call.c.dialogCall(notify))
+	other := newUASDialogRequest(sip.NOTIFY, req, resp)
+	other.RemoveHeader("Call-ID")
+	otherID := sip.CallIDHeader("other@example.com")
+	other.AppendHeader(&otherID)
+	require.Nil(t, call.c.dialogCall(other))
+}
+
+func TestOutboundTransferResult(t *testing.T) {
+	referStatus := atomic.Int32{}
+	referStatus.Store(202)
+	uas := newTestUASWith(t, func(srv *sipgo.Server) {
+		srv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+			res := sip.NewResponseFromRequest(req, 200, "OK", nil)
+			if to, ok := res.To(); ok {
+				to.Params.Add("tag", "callee-tag")
+			}
+			_ = tx.Respond(res)
+		})
+		srv.OnRefer(func(req *sip.Request, tx sip.ServerTransaction) {
+			_ = tx.Respond(sip.NewResponseFromRequest(req, sip.StatusCode(referStatus.Load()), "", nil))
+		})
+		srv.OnBye(func(req *sip.Request, tx sip.ServerTransaction) {
+			_ = tx.Respond(sip.NewResponseFromRequest(req, 200, "OK", nil))
+		})
+	})
+	call := newTestOutboundCall(t, &config.Config{})
+	call.rtpConn = rtp.NewConn(nil)
+	conf := sipOutboundConfig{address: uas.String(), from: "from", to: "bob"}
+	req, resp, err := call.sipInvite(nil, conf)
+	require.NoError(t, err)
+	call.sipCur = conf
+	call.sipInviteReq, call.sipInviteResp = req, resp
+	call.c.cmu.Lock()
+	call.c.activeCalls[call] = struct{}{}
+	call.c.cmu.Unlock()
+
+	// NOTIFY requests are sent by the callee to the client.
+	cli := newTestUASWith(t, func(srv *sipgo.Server) {
+		srv.OnNotify(call.c.onNotify)
+	})
+	notify := func(t *testing.T, body string) {
+		t.Helper()
+		n := newUASDialogRequest(sip.NOTIFY, req, resp)
+		n.AppendHeader(sip.NewHeader("Event", "refer"))
+		n.AppendHeader(sip.NewHeader("Content-Type", "message/sipfrag;version=2.0"))
+		n.SetBody([]byte(body))
+		require.Equal(t, sip.StatusCode(200), sendTestRequest(t, cli.String(), "bob", n).StatusCode)
+	}
+	await := func(t *testing.T) (TransferResult, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		return call.AwaitTransferResult(ctx)
+	}
+
+	_, err = await(t)
+	require.ErrorIs(t, err, errNoTransfer)
+
+	// REFER is rejected.
+	referStatus.Store(403)
+	require.Error(t, call.transferCall("sip:carol@example.com"))
+	res, err := await(t)
+	require.NoError(t, err)
+	require.Equal(t, TransferResult{Success: false, Code: 403}, res)
+
+	// Transfer target is busy.
+	referStatus.Store(202)
+	require.NoError(t, call.transferCall("sip:carol@example.com"))
+	notify(t, "SIP/2.0 100 Trying")
+	_, err = await(t)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	notify(t, "SIP/2.0 486 Busy Here")
+	res, err = await(t)
+	require.NoError(t, err)
+	require.Equal(t, TransferResult{Success: false, Code: 486}, res)
+	require.False(t, call.stopped.IsBroken())
+
+	// Transfer target answers, so the call ends. The result is still available.
+	require.NoError(t, call.transferCall("sip:dave@example.com"))
+	notify(t, "SIP/2.0 100 Trying")
+	notify(t, "SIP/2.0 200 OK")
+	res, err = await(t)
+	require.NoError(t, err)
+	require.Equal(t, TransferResult{Success: true, Code: 200}, res)
+	require.True(t, call.stopped.IsBroken())
+}
+
+// newUASDialogRequest creates a request sent by the callee within the dialog of the INVITE.
+func newUASDialogRequest(method sip.RequestMethod, inviteReq *sip.Request, inviteResp *sip.Response) *sip.Request {
+	req := sip.NewRequest(method, &sip.Uri{User: "from", Host: "example.com"})
+	to, _ := inviteResp.To()
+	req.AppendHeader(&sip.FromHeader{Address: to.Address, Params: to.Params.Clone().(sip.HeaderParams)})
+	from, _ := inviteReq.From()
+	req.AppendHeader(&sip.ToHeader{Address: from.Address, Params: from.Params.Clone().(sip.HeaderParams)})
+	callID, _ := inviteReq.CallID()
+	req.AppendHeader(sip.HeaderClone(callID))
+	return req
+}
+
+func TestService_DataChannelTransfer(t *testing.T) {
+	s, _ := startTestService(t, &config.Config{
+		LiveKitDataChannelTransferEnabled: true,
+		LiveKitDataChannelSenders:         []string{"agent"},
+	})
+	call := newTestInboundCall(s, "alice")
+	data := setTestRoomConn(call.lkRoom)
+	send := func(text string) {
+		call.lkRoom.handleData(&lksdk.UserDataPacket{Payload: []byte(text)}, lksdk.DataReceiveParams{SenderIdentity: "agent"})
+	}
+
+	// Transfer for another SIP participant in the room.
+	send("TRANSFER:SCL_bob:sip:carol@example.com")
+	// Invalid transfer request.
+	send("TRANSFER:" + call.id + ":carol")
+	select {
+	case p := <-data:
+		t.Fatal("unexpected data packet:", p)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The call is not answered yet, so the transfer must fail.
+	send("TRANSFER:" + call.id + ":sip:carol@example.com")
+	ev := expectTransferEvent(t, data)
+	require.Equal(t, EventTransferFailed, ev.Event)
+	require.Equal(t, call.id, ev.CallID)
+	require.Equal(t, "sip:carol@example.com", ev.Target)
+	require.NotEmpty(t, ev.Error)
+}
+
+func TestService_DataChannelTransferDisabled(t *testing.T) {
+	s, _ := startTestService(t, &config.Config{})
+	call := newTestInboundCall(s, "alice")
+	require.Nil(t, call.lkRoom.onTransfer)
+}
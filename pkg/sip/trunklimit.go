@@ -0,0 +1,53 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"sync"
+)
+
+// trunkLimiter counts active calls per trunk ID. Zero value is ready to use.
+type trunkLimiter struct {
+	mu    sync.Mutex
+	calls map[string]int // only trunks with active calls
+}
+
+// Active returns the number of active calls on the trunk.
+func (l *trunkLimiter) Active(trunk string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.calls[trunk]
+}
+
+// Acquire reserves a call slot on the trunk, unless there are limit active calls already. Zero limit means no limit.
+// The release function must be called when the call ends. It's safe to call it multiple times.
+func (l *trunkLimiter) Acquire(trunk string, limit int) (release func(), ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit > 0 && l.calls[trunk] >= limit {
+		return nil, false
+	}
+	if l.calls == nil {
+		l.calls = make(map[string]int)
+	}
+	l.calls[trunk]++
+	return sync.OnceFunc(func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.calls[trunk]--; l.calls[trunk] <= 0 {
+			delete(l.calls, trunk)
+		}
+	}), true
+}
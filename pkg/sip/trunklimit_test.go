@@ -0,0 +1,134 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/livekit/protocol/rpc"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/errors"
+	"github.com/livekit/sip/pkg/media/rtp"
+)
+
+func TestTrunkLimiter(t *testing.T) {
+	const (
+		trunk = "ST_a"
+		limit = 5
+	)
+	var (
+		l        trunkLimiter
+		wg       sync.WaitGroup
+		accepted atomic.Int32
+		releases = make(chan func(), limit+1)
+	)
+	for i := 0; i < limit+1; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if release, ok := l.Acquire(trunk, limit); ok {
+				accepted.Add(1)
+				releases <- release
+			}
+		}()
+	}
+	wg.Wait()
+	close(releases)
+	require.EqualValues(t, limit, accepted.Load())
+	require.Equal(t, limit, l.Active(trunk))
+
+	// Other trunks and unlimited trunks are not affected.
+	releaseOther, ok := l.Acquire("ST_b", limit)
+	require.True(t, ok)
+	for i := 0; i < limit+1; i++ {
+		_, ok = l.Acquire("ST_unlimited", 0)
+		require.True(t, ok)
+	}
+
+	release := <-releases
+	release()
+	release()
+	require.Equal(t, limit-1, l.Active(trunk))
+	_, ok = l.Acquire(trunk, limit)
+	require.True(t, ok)
+	_, ok = l.Acquire(trunk, limit)
+	require.False(t, ok)
+
+	// Trunks without active calls are forgotten.
+	releaseOther()
+	require.Equal(t, 0, l.Active("ST_b"))
+	l.mu.Lock()
+	_, ok = l.calls["ST_b"]
+	l.mu.Unlock()
+	require.False(t, ok)
+}
+
+func TestOutboundTrunkCapacity(t *testing.T) {
+	const (
+		trunkID = "ST_out"
+		address = "sip.example.com"
+		limit   = 2
+	)
+	call := newTestOutboundCall(t, &config.Config{
+		OutboundTrunks:     map[string]string{trunkID: address},
+		MaxConcurrentCalls: map[string]int{trunkID: limit},
+	})
+	cli := call.c
+	for i := 0; i < limit; i++ {
+		_, ok := cli.trunks.Acquire(trunkID, limit)
+		require.True(t, ok)
+	}
+	_, err := cli.CreateSIPParticipant(context.Background(), &rpc.InternalCreateSIPParticipantRequest{
+		Address:  address,
+		Number:   "+1000",
+		CallTo:   "+2000",
+		RoomName: "room",
+	})
+	require.ErrorIs(t, err, errors.ErrTrunkCapacityExceeded)
+	require.Equal(t, limit, cli.trunks.Active(trunkID))
+
+	// Slot is released when the call ends.
+	release, ok := cli.trunks.Acquire("ST_other", 1)
+	require.True(t, ok)
+	call.release = release
+	call.rtpConn = rtp.NewConn(nil)
+	call.Close()
+	require.Equal(t, 0, cli.trunks.Active("ST_other"))
+}
+
+func TestInboundTrunkCapacity(t *testing.T) {
+	const trunkID = "ST_in"
+	h := acceptHandler("room", trunkID)
+	opts := testInviteOptions{
+		Setup: func(s *Service) {
+			s.conf.MaxConcurrentCalls = map[string]int{trunkID: 1}
+			_, ok := s.srv.trunks.Acquire(trunkID, 1)
+			require.True(t, ok)
+		},
+	}
+	testInviteWith(t, h, opts, "foo", "bar", func(tx sip.ClientTransaction) {
+		res := getResponseOrFail(t, tx)
+		for res.IsProvisional() {
+			res = getResponseOrFail(t, tx)
+		}
+		require.Equal(t, sip.StatusCode(503), res.StatusCode)
+	})
+}
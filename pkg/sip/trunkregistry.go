@@ -0,0 +1,115 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TrunkStatus is a snapshot of the trunk state, as seen by this instance.
+type TrunkStatus struct {
+	ID            string
+	ActiveCalls   int
+	LastInvite    time.Time // last INVITE sent or received; zero if none
+	Reachable     *bool     // result of the last OPTIONS request; nil if the trunk was never queried
+	LastOptions   time.Time
+	LastFailure   string // reason of the last failed call
+	LastFailureAt time.Time
+}
+
+// TrunkRegistry tracks the state of each trunk. Both inbound and outbound calls update it.
+// Calls without a trunk ID are not tracked. Nil registry ignores all updates.
+type TrunkRegistry struct {
+	mu     sync.Mutex
+	trunks map[string]*TrunkStatus
+}
+
+func NewTrunkRegistry() *TrunkRegistry {
+	return &TrunkRegistry{trunks: make(map[string]*TrunkStatus)}
+}
+
+func (r *TrunkRegistry) update(trunk string, fnc func(st *TrunkStatus)) {
+	if r == nil || trunk == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := r.trunks[trunk]
+	if st == nil {
+		st = &TrunkStatus{ID: trunk}
+		r.trunks[trunk] = st
+	}
+	fnc(st)
+}
+
+// CallStarted records an INVITE on the trunk and counts the call as active.
+// The returned function must be called when the call ends. It's safe to call it multiple times.
+func (r *TrunkRegistry) CallStarted(trunk string) (end func()) {
+	now := time.Now()
+	r.update(trunk, func(st *TrunkStatus) {
+		st.ActiveCalls++
+		st.LastInvite = now
+	})
+	return sync.OnceFunc(func() {
+		r.update(trunk, func(st *TrunkStatus) {
+			st.ActiveCalls--
+		})
+	})
+}
+
+// Invite records an INVITE sent to the trunk, e.g. after a redirect or a failover.
+func (r *TrunkRegistry) Invite(trunk string) {
+	now := time.Now()
+	r.update(trunk, func(st *TrunkStatus) {
+		st.LastInvite = now
+	})
+}
+
+// CallFailed records the reason of a failed call.
+func (r *TrunkRegistry) CallFailed(trunk string, reason string) {
+	now := time.Now()
+	r.update(trunk, func(st *TrunkStatus) {
+		st.LastFailure, st.LastFailureAt = reason, now
+	})
+}
+
+// Options records the result of the OPTIONS request sent to the trunk.
+func (r *TrunkRegistry) Options(trunk string, err error) {
+	now := time.Now()
+	ok := err == nil
+	r.update(trunk, func(st *TrunkStatus) {
+		st.Reachable, st.LastOptions = &ok, now
+	})
+}
+
+// Trunks returns the status of all trunks seen so far, sorted by ID.
+func (r *TrunkRegistry) Trunks() []TrunkStatus {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]TrunkStatus, 0, len(r.trunks))
+	for _, st := range r.trunks {
+		out = append(out, *st)
+	}
+	slices.SortFunc(out, func(a, b TrunkStatus) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return out
+}
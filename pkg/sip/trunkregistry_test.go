@@ -0,0 +1,55 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrunkRegistry(t *testing.T) {
+	r := NewTrunkRegistry()
+	end1 := r.CallStarted("ST_a")
+	end2 := r.CallStarted("ST_a")
+	r.CallStarted("")() // not tracked
+	r.Options("ST_b", errors.New("timeout"))
+
+	st := r.Trunks()
+	require.Len(t, st, 2)
+	require.Equal(t, "ST_a", st[0].ID)
+	require.Equal(t, 2, st[0].ActiveCalls)
+	require.False(t, st[0].LastInvite.IsZero())
+	require.Nil(t, st[0].Reachable)
+	require.Equal(t, "ST_b", st[1].ID)
+	require.NotNil(t, st[1].Reachable)
+	require.False(t, *st[1].Reachable)
+
+	end1()
+	end1() // no-op
+	r.CallFailed("ST_a", "no-dispatch")
+	st = r.Trunks()
+	require.Equal(t, 1, st[0].ActiveCalls)
+	require.Equal(t, "no-dispatch", st[0].LastFailure)
+	end2()
+	require.Zero(t, r.Trunks()[0].ActiveCalls)
+
+	// Nil registry ignores updates.
+	var nr *TrunkRegistry
+	nr.CallStarted("ST_a")()
+	nr.CallFailed("ST_a", "other")
+	require.Empty(t, nr.Trunks())
+}
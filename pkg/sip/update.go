@@ -0,0 +1,49 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"github.com/emiago/sipgo/sip"
+)
+
+// onUpdate handles SIP UPDATE (RFC 3311) requests in dialogs of inbound calls.
+func (s *Server) onUpdate(req *sip.Request, tx sip.ServerTransaction) {
+	c := s.dialogCall(req)
+	if c == nil {
+		if s.sipUnhandled != nil {
+			s.sipUnhandled(req, tx)
+			return
+		}
+		_ = tx.Respond(sip.NewResponseFromRequest(req, 481, "Call/Transaction Does Not Exist", nil))
+		return
+	}
+	c.handleUpdate(req, tx)
+}
+
+// handleUpdate answers the SDP offer of UPDATE the same way as for re-INVITE. Unlike re-INVITE, UPDATE is also
+// accepted before the call is answered, once the initial offer is answered. UPDATE without SDP only refreshes the session.
+func (c *inboundCall) handleUpdate(req *sip.Request, tx sip.ServerTransaction) {
+	if !c.s.handleInviteAuth(c.log, req, tx, c.from.Address.User, c.authUser, c.authPass) {
+		// handleInviteAuth will generate the SIP Response as needed
+		return
+	}
+	if len(req.Body()) == 0 {
+		resp := sip.NewResponseFromRequest(req, 200, "OK", nil)
+		resp.AppendHeader(c.s.contactHeader(req))
+		_ = tx.Respond(resp)
+		return
+	}
+	c.handleOffer(req, tx)
+}
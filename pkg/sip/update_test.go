@@ -0,0 +1,86 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sip
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emiago/sipgo/sip"
+	"github.com/pion/sdp/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+func newTestUpdate(t *testing.T, addr, tag string, port int, dir string) *sip.Request {
+	offer, err := sdpGenerateOffer("127.0.0.1", port)
+	require.NoError(t, err)
+	body := strings.Replace(string(offer), "a=sendrecv", "a="+dir, 1)
+
+	req := newTestDialogRequest(sip.UPDATE, addr, "alice", tag, testSIPCallID(tag))
+	req.AppendHeader(&contentTypeHeaderSDP)
+	req.SetBody([]byte(body))
+	return req
+}
+
+func TestService_Update(t *testing.T) {
+	s, addr := startTestService(t, &config.Config{})
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+	call, _ := addTestMediaCall(t, s, localIP+":5060")
+
+	// New media address and direction are applied.
+	res := sendTestRequest(t, addr, "alice", newTestUpdate(t, addr, call.tag, 40002, "sendonly"))
+	require.Equal(t, sip.StatusCode(200), res.StatusCode)
+	var answer sdp.SessionDescription
+	require.NoError(t, answer.Unmarshal(res.Body()))
+	require.Equal(t, "recvonly", sdpGetDirection(answer))
+	require.Equal(t, 40002, call.rtpConn.DestAddr().Port)
+	require.True(t, call.isOnHold())
+
+	res = sendTestRequest(t, addr, "alice", newTestUpdate(t, addr, call.tag, 40004, "sendrecv"))
+	require.Equal(t, sip.StatusCode(200), res.StatusCode)
+	require.Equal(t, 40004, call.rtpConn.DestAddr().Port)
+	require.False(t, call.isOnHold())
+
+	// Session refresh without SDP.
+	res = sendTestRequest(t, addr, "alice", newTestDialogRequest(sip.UPDATE, addr, "alice", call.tag, testSIPCallID(call.tag)))
+	require.Equal(t, sip.StatusCode(200), res.StatusCode)
+	require.Empty(t, res.Body())
+	require.Equal(t, 40004, call.rtpConn.DestAddr().Port)
+}
+
+func TestService_UpdatePending(t *testing.T) {
+	s, addr := startTestService(t, &config.Config{})
+	localIP, err := config.GetLocalIP()
+	require.NoError(t, err)
+	call, _ := addTestMediaCall(t, s, localIP+":5060")
+
+	// Another offer is in progress.
+	call.offerMu.Lock()
+	res := sendTestRequest(t, addr, "alice", newTestUpdate(t, addr, call.tag, 40002, "sendonly"))
+	require.Equal(t, sip.StatusCode(491), res.StatusCode)
+	res = sendTestRequest(t, addr, "alice", newTestReInvite(t, addr, call.tag, "sendonly"))
+	require.Equal(t, sip.StatusCode(491), res.StatusCode)
+	call.offerMu.Unlock()
+	require.False(t, call.isOnHold())
+	require.Equal(t, 40000, call.rtpConn.DestAddr().Port)
+
+	// Media is not established yet.
+	other := addTestCall(s, "alice", "other-tag")
+	res = sendTestRequest(t, addr, "alice", newTestUpdate(t, addr, other.tag, 40002, "sendonly"))
+	require.Equal(t, sip.StatusCode(491), res.StatusCode)
+}
@@ -15,6 +15,7 @@
 package siptest
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
 	"errors"
@@ -143,6 +144,7 @@ type Client struct {
 	sipServer  *sipgo.Server
 	inviteReq  *sip.Request
 	inviteResp *sip.Response
+	cseq       uint32 // last CSeq used for requests sent in the dialog
 }
 
 func (c *Client) LocalIP() string {
@@ -195,7 +197,7 @@ func (c *Client) Record(w io.WriteCloser) {
 
 func (c *Client) Dial(ip string, uri string, number string) error {
 	c.log.Debug("dialing SIP server", "ip", ip, "uri", uri, "number", number)
-	offer, err := c.createOffer()
+	offer, err := c.createOffer("sendrecv")
 	if err != nil {
 		return err
 	}
@@ -230,8 +232,11 @@ func (c *Client) Dial(ip string, uri string, number string) error {
 			}
 
 			cred, _ := digest.Digest(challenge, digest.Options{
-				Method:   req.Method.String(),
-				URI:      toHeader.Address.String(),
+				Method: req.Method.String(),
+				URI:    toHeader.Address.String(),
+				GetBody: func() (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(req.Body())), nil
+				},
 				Username: c.conf.AuthUser,
 				Password: c.conf.AuthPass,
 			})
@@ -270,6 +275,9 @@ func (c *Client) Dial(ip string, uri string, number string) error {
 	}
 	c.inviteReq = req
 	c.inviteResp = resp
+	if h, ok := req.CSeq(); ok {
+		c.cseq = h.SeqNo
+	}
 	c.mediaConn.SetDestAddr(dstAddr)
 	c.log.Debug("client connected", "media-dst", dstAddr)
 	return nil
@@ -298,9 +306,44 @@ func (c *Client) attemptInvite(ip, uri, number string, offer []byte, authHeader
 	return req, resp, err
 }
 
+// dialogRequest creates a new request within the call dialog (RFC 3261, section 12.2.1.1).
+func (c *Client) dialogRequest(method sip.RequestMethod) *sip.Request {
+	target := *c.inviteReq.Recipient
+	if contact, ok := c.inviteResp.Contact(); ok {
+		target = contact.Address
+	}
+	req := sip.NewRequest(method, &target)
+	req.SipVersion = c.inviteReq.SipVersion
+	var routes []sip.Uri
+	for _, h := range c.inviteResp.GetHeaders("Record-Route") {
+		for rr, _ := h.(*sip.RecordRouteHeader); rr != nil; rr = rr.Next {
+			routes = append(routes, rr.Address)
+		}
+	}
+	for i := len(routes) - 1; i >= 0; i-- {
+		req.AppendHeader(&sip.RouteHeader{Address: routes[i]})
+	}
+	maxForwards := sip.MaxForwardsHeader(70)
+	req.AppendHeader(&maxForwards)
+	if h, ok := c.inviteReq.From(); ok {
+		req.AppendHeader(sip.HeaderClone(h))
+	}
+	if h, ok := c.inviteResp.To(); ok {
+		req.AppendHeader(sip.HeaderClone(h))
+	}
+	if h, ok := c.inviteReq.CallID(); ok {
+		req.AppendHeader(sip.HeaderClone(h))
+	}
+	c.cseq++
+	req.AppendHeader(&sip.CSeqHeader{SeqNo: c.cseq, MethodName: method})
+	req.SetTransport(c.inviteReq.Transport())
+	req.SetDestination(c.inviteReq.Destination())
+	return req
+}
+
 func (c *Client) sendBye() {
 	c.log.Debug("sending bye")
-	req := sip.NewByeRequest(c.inviteReq, c.inviteResp, nil)
+	req := c.dialogRequest(sip.BYE)
 	req.AppendHeader(sip.NewHeader("User-Agent", "LiveKit"))
 
 	tx, err := c.sipClient.TransactionRequest(req)
@@ -313,13 +356,79 @@ func (c *Client) sendBye() {
 	}
 }
 
+// Refer sends a REFER request in the call dialog, asking the server to transfer the call to a given target.
+func (c *Client) Refer(target string) error {
+	c.log.Debug("sending refer", "target", target)
+	req := c.dialogRequest(sip.REFER)
+	req.AppendHeader(sip.NewHeader("Refer-To", "<"+target+">"))
+	req.AppendHeader(sip.NewHeader("Referred-By", fmt.Sprintf("<sip:%s@%s>", c.conf.Number, c.conf.IP)))
+
+	tx, err := c.sipClient.TransactionRequest(req)
+	if err != nil {
+		return err
+	}
+	defer tx.Terminate()
+	resp, err := getResponse(tx)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 202 {
+		return fmt.Errorf("unexpected status from REFER response %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// CallID returns the Call-ID of the established call.
+func (c *Client) CallID() string {
+	if c.inviteReq == nil {
+		return ""
+	}
+	h, ok := c.inviteReq.CallID()
+	if !ok {
+		return ""
+	}
+	return h.Value()
+}
+
+// Hold puts the call on hold or resumes it with a re-INVITE (RFC 3264, section 8.4).
+func (c *Client) Hold(hold bool) error {
+	c.log.Debug("sending re-invite", "hold", hold)
+	dir := "sendrecv"
+	if hold {
+		dir = "sendonly"
+	}
+	offer, err := c.createOffer(dir)
+	if err != nil {
+		return err
+	}
+	req := c.dialogRequest(sip.INVITE)
+	req.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+	req.AppendHeader(sip.NewHeader("Contact", fmt.Sprintf("<sip:livekit@%s:5060>", c.conf.IP)))
+	req.SetBody(offer)
+
+	tx, err := c.sipClient.TransactionRequest(req)
+	if err != nil {
+		return err
+	}
+	defer tx.Terminate()
+	resp, err := getResponse(tx)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected status from re-INVITE response %d", resp.StatusCode)
+	}
+	return c.sipClient.WriteRequest(sip.NewAckRequest(req, resp, nil))
+}
+
 func (c *Client) SendDTMF(digits string) error {
 	c.log.Debug("sending dtmf", "str", digits)
 	w := c.audioCodec.EncodeRTP(c.mediaAudio)
 	return dtmf.Write(context.Background(), w, c.mediaDTMF, digits)
 }
 
-func (c *Client) createOffer() ([]byte, error) {
+// createOffer creates an SDP offer with a given media direction (RFC 3264, section 5.1).
+func (c *Client) createOffer(dir string) ([]byte, error) {
 	sessionId := rand.Uint64()
 
 	offer := sdp.SessionDescription{
@@ -357,6 +466,7 @@ func (c *Client) createOffer() ([]byte, error) {
 				Attributes: []sdp.Attribute{
 					{Key: "rtpmap", Value: fmt.Sprintf("%d %s", c.audioType, c.audioCodec.Info().SDPName)},
 					{Key: "rtpmap", Value: "101 " + dtmf.SDPName},
+					{Key: dir},
 				},
 			},
 		},
@@ -411,8 +521,18 @@ const (
 	signalAmp    = math.MaxInt16 / 4
 	signalAmpMin = signalAmp - signalAmp/4 // TODO: why it's so low?
 	signalAmpMax = signalAmp + signalAmp/10
+	signalSNRMin = 10 // dB
 )
 
+// expectedSignals returns signals that are expected to be found in audio generated for given frequencies.
+func expectedSignals(vals []int) []audiotest.Wave {
+	out := make([]audiotest.Wave, 0, len(vals))
+	for _, v := range vals {
+		out = append(out, audiotest.Wave{Ind: v, Amp: signalAmp})
+	}
+	return out
+}
+
 // SendSignal generate an audio signal with a given value. It repeats the signal n times, each frame containing one signal.
 // If n <= 0, it will send the signal until the context is cancelled.
 func (c *Client) SendSignal(ctx context.Context, n int, val int) error {
@@ -456,6 +576,14 @@ func (c *Client) WaitSignals(ctx context.Context, vals []int, w io.WriteCloser)
 	decoded := make(media.PCM16Sample, rtp.DefPacketDur)
 	dec := c.audioCodec.DecodeRTP(&decoded, c.audioType)
 	lastLog := time.Now()
+	// Best SNR of frames that matched the signals. Signals with low SNR indicate audio quality regressions.
+	var (
+		lowSNR     float64
+		lowSNRSeen bool
+	)
+	expected := expectedSignals(vals)
+	// Difference between the expected and the last non-matching signals, for logs and errors.
+	var lastDiff string
 	for {
 		p, _, err := c.mediaConn.ReadRTP()
 		if err != nil {
@@ -464,6 +592,11 @@ func (c *Client) WaitSignals(ctx context.Context, vals []int, w io.WriteCloser)
 		}
 		select {
 		case <-ctx.Done():
+			if lowSNRSeen {
+				return fmt.Errorf("%w: signals %v found, but SNR is too low: %.1f dB", ctx.Err(), vals, lowSNR)
+			} else if lastDiff != "" {
+				return fmt.Errorf("%w: signals %v not found: %s", ctx.Err(), vals, lastDiff)
+			}
 			return ctx.Err()
 		default:
 		}
@@ -501,17 +634,25 @@ func (c *Client) WaitSignals(ctx context.Context, vals []int, w io.WriteCloser)
 				}
 			}
 			if ok {
-				c.log.Debug("signal found", "sig", vals)
-				return nil
+				// Amplitude is not enough, the signal must not be distorted either.
+				if snr := audiotest.ToneSNR(decoded, vals); snr < signalSNRMin {
+					if !lowSNRSeen || snr > lowSNR {
+						lowSNR, lowSNRSeen = snr, true
+					}
+				} else {
+					c.log.Debug("signal found", "sig", vals, "snr", snr)
+					return nil
+				}
 			}
 		}
 		// Remove most other components from the logs.
 		if len(out) > len(vals)*2 {
 			out = out[:len(vals)*2]
 		}
+		lastDiff = audiotest.WaveSlice(out).Diff(expected)
 		if time.Since(lastLog) > time.Second {
 			lastLog = time.Now()
-			c.log.Debug("skipping signal", "len", len(decoded), "signals", out)
+			c.log.Debug("skipping signal", "len", len(decoded), "diff", lastDiff)
 		}
 	}
 }
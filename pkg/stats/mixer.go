@@ -0,0 +1,92 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/livekit/sip/pkg/config"
+)
+
+// MixerStats is implemented by the audio mixer of each call.
+type MixerStats interface {
+	InputCount() int
+	PeakAmplitude() int16
+}
+
+// mixers tracks audio mixers of active calls. Gauges are computed when metrics are collected.
+type mixers struct {
+	mu  sync.Mutex
+	set map[MixerStats]struct{}
+}
+
+func (m *mixers) add(mix MixerStats) (remove func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.set == nil {
+		m.set = make(map[MixerStats]struct{})
+	}
+	m.set[mix] = struct{}{}
+	return sync.OnceFunc(func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.set, mix)
+	})
+}
+
+// stats returns the total number of inputs, and the max peak amplitude across all mixers.
+func (m *mixers) stats() (inputs int, peak int16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for mix := range m.set {
+		inputs += mix.InputCount()
+		peak = max(peak, mix.PeakAmplitude())
+	}
+	return inputs, peak
+}
+
+func (m *Monitor) startMixers(conf *config.Config) {
+	mustRegister(m, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "sip",
+		Name:        "mixer_active_inputs",
+		Help:        "Number of active inputs across audio mixers of all calls",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, func() float64 {
+		inputs, _ := m.mixers.stats()
+		return float64(inputs)
+	}))
+
+	mustRegister(m, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "sip",
+		Name:        "mixer_peak_amplitude",
+		Help:        "Max absolute sample value of mixer inputs in the last mixing window, across all calls",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, func() float64 {
+		_, peak := m.mixers.stats()
+		return float64(peak)
+	}))
+}
+
+// AddMixer exports stats of the call mixer. The returned function must be called once the mixer is stopped.
+func (m *Monitor) AddMixer(mix MixerStats) (remove func()) {
+	if m == nil {
+		return func() {}
+	}
+	return m.mixers.add(mix)
+}
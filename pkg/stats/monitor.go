@@ -23,6 +23,26 @@ import (
 	"github.com/livekit/sip/pkg/config"
 )
 
+var cpuBuckets = []float64{
+	1, 5, 10, 50, 100, 500, 1000, 5000, 10000, 60000, 600000,
+}
+
+var rtcpMsBuckets = []float64{
+	1, 5, 10, 20, 30, 50, 100, 200, 300, 500, 1000,
+}
+
+var syncBuckets = []float64{
+	-1000, -500, -200, -100, -50, -20, 0, 20, 50, 100, 200, 500, 1000,
+}
+
+var lossBuckets = []float64{
+	0, 0.01, 0.02, 0.05, 0.1, 0.2, 0.3, 0.5, 1,
+}
+
+var latencyBuckets = []float64{
+	0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30,
+}
+
 var durBuckets = []float64{
 	0.1, 0.5, 1, 10, 60, 10 * 60, 30 * 60, 3600, 6 * 3600, 12 * 3600, 24 * 3600,
 }
@@ -46,12 +66,27 @@ type Monitor struct {
 	inviteReq       *prometheus.CounterVec
 	inviteAccept    *prometheus.CounterVec
 	inviteErr       *prometheus.CounterVec
+	outboundRetry   *prometheus.CounterVec
+	trunkFailover   *prometheus.CounterVec
 	callsActive     *prometheus.GaugeVec
 	callsTerminated *prometheus.CounterVec
 	packetsRTP      *prometheus.CounterVec
+	packetsLost     *prometheus.CounterVec
+	packetsReorder  *prometheus.CounterVec
+	oneWayAudio     *prometheus.CounterVec
+	maxDuration     *prometheus.CounterVec
 	durSession      *prometheus.HistogramVec
 	durCall         *prometheus.HistogramVec
 	durJoin         *prometheus.HistogramVec
+	durInvite       *prometheus.HistogramVec
+	durSDP          *prometheus.HistogramVec
+	callCPU         *prometheus.HistogramVec
+	callCPUQuantile *prometheus.SummaryVec
+	rtpJitter       *prometheus.HistogramVec
+	rtpLoss         *prometheus.HistogramVec
+	rtpRTT          *prometheus.HistogramVec
+	rtpSyncDelay    *prometheus.HistogramVec
+	mixers          mixers
 
 	metrics  []prometheus.Collector
 	started  core.Fuse
@@ -106,6 +141,22 @@ func (m *Monitor) Start(conf *config.Config) error {
 		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
 	}, []string{"dir", "to", "reason"}))
 
+	m.outboundRetry = mustRegister(m, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "sip",
+		Name:        "outbound_retry_total",
+		Help:        "Number of retried outbound SIP INVITE requests",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"dir", "to", "reason"}))
+
+	m.trunkFailover = mustRegister(m, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "sip",
+		Name:        "trunk_failover_total",
+		Help:        "Number of outbound calls switched to a failover trunk",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"dir", "to", "from_trunk", "to_trunk"}))
+
 	m.callsActive = mustRegister(m, prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace:   "livekit",
 		Subsystem:   "sip",
@@ -130,6 +181,38 @@ func (m *Monitor) Start(conf *config.Config) error {
 		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
 	}, []string{"dir", "to", "op", "payload"}))
 
+	m.packetsLost = mustRegister(m, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "sip",
+		Name:        "packets_rtp_lost",
+		Help:        "Number of RTP packets lost, detected from gaps in sequence numbers which were not filled by the next 64 packets",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"dir", "to"}))
+
+	m.packetsReorder = mustRegister(m, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "sip",
+		Name:        "packets_rtp_reordered",
+		Help:        "Number of RTP packets received out of order",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"dir", "to"}))
+
+	m.oneWayAudio = mustRegister(m, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "sip",
+		Name:        "one_way_audio",
+		Help:        "Number of media timeouts with no RTP received, by the recovery stage: reinvite, recovered or terminated",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"dir", "to", "result"}))
+
+	m.maxDuration = mustRegister(m, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "sip",
+		Name:        "call_max_duration_exceeded_total",
+		Help:        "Number of calls hung up after reaching the max call duration of the trunk",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+	}, []string{"dir", "to", "trunk"}))
+
 	m.durSession = mustRegister(m, prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace:   "livekit",
 		Subsystem:   "sip",
@@ -157,6 +240,80 @@ func (m *Monitor) Start(conf *config.Config) error {
 		Buckets:     durBuckets,
 	}, []string{"dir"}))
 
+	m.durInvite = mustRegister(m, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   "livekit",
+		Subsystem:   "sip",
+		Name:        "invite_processing_duration_seconds",
+		Help:        "SIP INVITE processing duration (from INVITE received or sent to 200 OK sent or received)",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+		Buckets:     latencyBuckets,
+	}, []string{"dir"}))
+
+	m.durSDP = mustRegister(m, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   "livekit",
+		Subsystem:   "sip",
+		Name:        "sdp_negotiation_duration_seconds",
+		Help:        "Time spent parsing and generating SDP offers and answers of a SIP call",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+		Buckets:     latencyBuckets,
+	}, []string{"dir"}))
+
+	m.callCPU = mustRegister(m, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   "livekit",
+		Subsystem:   "sip",
+		Name:        "call_cpu_ms",
+		Help:        "CPU time used by goroutines of a profiled SIP call",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+		Buckets:     cpuBuckets,
+	}, []string{"dir"}))
+
+	m.callCPUQuantile = mustRegister(m, prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace:   "livekit",
+		Subsystem:   "sip",
+		Name:        "call_cpu_quantile_ms",
+		Help:        "Mean (sum/count), median and p99 CPU time used by goroutines of a profiled SIP call",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+		Objectives:  map[float64]float64{0.5: 0.05, 0.99: 0.001},
+	}, []string{"dir"}))
+
+	m.rtpJitter = mustRegister(m, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   "livekit",
+		Subsystem:   "sip",
+		Name:        "rtp_jitter_ms",
+		Help:        "RTP interarrival jitter reported by the remote side in RTCP",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+		Buckets:     rtcpMsBuckets,
+	}, []string{"dir", "trunk"}))
+
+	m.rtpLoss = mustRegister(m, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   "livekit",
+		Subsystem:   "sip",
+		Name:        "rtp_packet_loss_fraction",
+		Help:        "Fraction of RTP packets lost, reported by the remote side in RTCP",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+		Buckets:     lossBuckets,
+	}, []string{"dir", "trunk"}))
+
+	m.rtpRTT = mustRegister(m, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   "livekit",
+		Subsystem:   "sip",
+		Name:        "rtp_rtt_ms",
+		Help:        "RTP round-trip time, calculated from RTCP reports",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+		Buckets:     rtcpMsBuckets,
+	}, []string{"dir", "trunk"}))
+
+	m.rtpSyncDelay = mustRegister(m, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   "livekit",
+		Subsystem:   "sip",
+		Name:        "rtp_sync_delay_ms",
+		Help:        "Delay between the sender wall clock time of RTP packets from RTCP sender reports and their arrival, used for lip sync",
+		ConstLabels: prometheus.Labels{"node_id": conf.NodeID},
+		Buckets:     syncBuckets,
+	}, []string{"dir", "trunk"}))
+
+	m.startMixers(conf)
+
 	m.started.Break()
 
 	return nil
@@ -232,6 +389,14 @@ func (c *CallMonitor) InviteError(reason string) {
 	c.m.inviteErr.With(c.labels(prometheus.Labels{"reason": reason})).Inc()
 }
 
+func (c *CallMonitor) OutboundRetry(reason string) {
+	c.m.outboundRetry.With(c.labels(prometheus.Labels{"reason": reason})).Inc()
+}
+
+func (c *CallMonitor) TrunkFailover(fromTrunk, toTrunk string) {
+	c.m.trunkFailover.With(c.labels(prometheus.Labels{"from_trunk": fromTrunk, "to_trunk": toTrunk})).Inc()
+}
+
 func (c *CallMonitor) CallStart() {
 	c.m.callsActive.With(c.labels(nil)).Inc()
 }
@@ -252,6 +417,22 @@ func (c *CallMonitor) RTPPacketRecv(payloadType string) {
 	c.m.packetsRTP.With(c.labels(prometheus.Labels{"op": "recv", "payload": payloadType})).Inc()
 }
 
+func (c *CallMonitor) RTPPacketsLost(n int) {
+	c.m.packetsLost.With(c.labels(nil)).Add(float64(n))
+}
+
+func (c *CallMonitor) RTPPacketReordered() {
+	c.m.packetsReorder.With(c.labels(nil)).Inc()
+}
+
+func (c *CallMonitor) OneWayAudio(result string) {
+	c.m.oneWayAudio.With(c.labels(prometheus.Labels{"result": result})).Inc()
+}
+
+func (c *CallMonitor) MaxDurationExceeded(trunk string) {
+	c.m.maxDuration.With(c.labels(prometheus.Labels{"trunk": trunk})).Inc()
+}
+
 func (c *CallMonitor) SessionDur() func() time.Duration {
 	return prometheus.NewTimer(c.m.durSession.With(c.labelsShort(nil))).ObserveDuration
 }
@@ -263,3 +444,35 @@ func (c *CallMonitor) CallDur() func() time.Duration {
 func (c *CallMonitor) JoinDur() func() time.Duration {
 	return prometheus.NewTimer(c.m.durJoin.With(c.labelsShort(nil))).ObserveDuration
 }
+
+// InviteDur starts measuring INVITE processing. It must be stopped once 200 OK is sent (inbound) or received (outbound).
+func (c *CallMonitor) InviteDur() func() time.Duration {
+	return prometheus.NewTimer(c.m.durInvite.With(c.labelsShort(nil))).ObserveDuration
+}
+
+// SDPNegotiationDur records the time spent parsing the remote SDP and generating the local one, excluding network delays.
+func (c *CallMonitor) SDPNegotiationDur(d time.Duration) {
+	c.m.durSDP.With(c.labelsShort(nil)).Observe(d.Seconds())
+}
+
+// RTCPReceptionReport records stream quality reported by the remote side. Zero RTT means it's unknown and is not recorded.
+func (c *CallMonitor) RTCPReceptionReport(trunk string, lossFraction float64, jitter, rtt time.Duration) {
+	l := c.labelsShort(prometheus.Labels{"trunk": trunk})
+	c.m.rtpLoss.With(l).Observe(lossFraction)
+	c.m.rtpJitter.With(l).Observe(float64(jitter) / float64(time.Millisecond))
+	if rtt > 0 {
+		c.m.rtpRTT.With(l).Observe(float64(rtt) / float64(time.Millisecond))
+	}
+}
+
+// RTPSyncDelay records the delay between the sender wall clock time of the RTP packet and its arrival.
+// Streams with the same delay are in sync. It may be negative, since clocks of the sender and the receiver may differ.
+func (c *CallMonitor) RTPSyncDelay(trunk string, delay time.Duration) {
+	c.m.rtpSyncDelay.With(c.labelsShort(prometheus.Labels{"trunk": trunk})).Observe(float64(delay) / float64(time.Millisecond))
+}
+
+func (c *CallMonitor) CallCPU(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	c.m.callCPU.With(c.labelsShort(nil)).Observe(ms)
+	c.m.callCPUQuantile.With(c.labelsShort(nil)).Observe(ms)
+}
@@ -0,0 +1,177 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package transcription streams audio of SIP calls to an external transcription service.
+package transcription
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/livekit/protocol/logger"
+
+	"github.com/livekit/sip/pkg/media"
+)
+
+const (
+	// CallIDHeader identifies the call the audio belongs to.
+	CallIDHeader = "X-LiveKit-SIP-Call-ID"
+	// SeqHeader is the sequence number of the batch within the call, starting from 0.
+	SeqHeader = "X-LiveKit-SIP-Seq"
+
+	// DefaultBatchDur is the duration of audio posted in a single request.
+	DefaultBatchDur = time.Second
+
+	queueSize      = 16
+	requestTimeout = 5 * time.Second
+)
+
+// ContentType returns the content type of the audio posted with a given sample rate: 16 bit big-endian mono PCM (RFC 2586).
+func ContentType(sampleRate int) string {
+	return "audio/L16;rate=" + strconv.Itoa(sampleRate) + ";channels=1"
+}
+
+// Streamer batches PCM audio frames of a single call and posts them to the webhook URL, in a separate goroutine.
+// It implements media.PCM16Writer and must be closed when the call ends.
+//
+// A nil Streamer is valid and ignores all samples.
+type Streamer struct {
+	log        logger.Logger
+	url        string
+	callID     string
+	sampleRate int
+	batch      int // samples
+	cli        *http.Client
+
+	mu     sync.Mutex
+	buf    media.PCM16Sample
+	queue  chan media.PCM16Sample
+	closed bool
+	done   chan struct{}
+}
+
+// NewStreamer creates a streamer for the call audio with a given sample rate. It returns nil if url is empty.
+func NewStreamer(url, callID string, sampleRate int, log logger.Logger) *Streamer {
+	if url == "" {
+		return nil
+	}
+	if log == nil {
+		log = logger.GetLogger()
+	}
+	s := &Streamer{
+		log:        log.WithValues("transcriptionWebhook", url),
+		url:        url,
+		callID:     callID,
+		sampleRate: sampleRate,
+		batch:      sampleRate * int(DefaultBatchDur/time.Millisecond) / 1000,
+		cli:        &http.Client{Timeout: requestTimeout},
+		queue:      make(chan media.PCM16Sample, queueSize),
+		done:       make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// WriteSample appends the frame to the current batch. Batches are dropped if the webhook can't keep up.
+func (s *Streamer) WriteSample(sample media.PCM16Sample) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.buf = append(s.buf, sample...)
+	if len(s.buf) >= s.batch {
+		s.flushLocked()
+	}
+	return nil
+}
+
+// flushLocked queues the current batch for delivery. Must be called with mu held.
+func (s *Streamer) flushLocked() {
+	if len(s.buf) == 0 {
+		return
+	}
+	select {
+	case s.queue <- s.buf:
+	default:
+		s.log.Warnw("transcription queue is full, dropping audio", nil, "callID", s.callID)
+	}
+	s.buf = nil
+}
+
+// Close posts the remaining audio and stops the streamer. It does not wait for the delivery.
+func (s *Streamer) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.flushLocked()
+	close(s.queue)
+	return nil
+}
+
+// Done is closed once all queued audio is delivered after Close.
+func (s *Streamer) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Streamer) run() {
+	defer close(s.done)
+	seq := 0
+	for frame := range s.queue {
+		if err := s.post(seq, frame); err != nil {
+			s.log.Warnw("failed to post audio for transcription", err, "callID", s.callID, "seq", seq)
+		}
+		seq++
+	}
+}
+
+func (s *Streamer) post(seq int, frame media.PCM16Sample) error {
+	body := make([]byte, 2*len(frame))
+	for i, v := range frame {
+		binary.BigEndian.PutUint16(body[2*i:], uint16(v))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentType(s.sampleRate))
+	req.Header.Set(CallIDHeader, s.callID)
+	req.Header.Set(SeqHeader, strconv.Itoa(seq))
+	resp, err := s.cli.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
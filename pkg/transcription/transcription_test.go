@@ -0,0 +1,97 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transcription
+
+import (
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/sip/pkg/media"
+)
+
+type testBatch struct {
+	callID string
+	seq    string
+	ctype  string
+	audio  media.PCM16Sample
+}
+
+func newTestServer(t *testing.T) (string, <-chan testBatch) {
+	batches := make(chan testBatch, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		b := testBatch{
+			callID: r.Header.Get(CallIDHeader),
+			seq:    r.Header.Get(SeqHeader),
+			ctype:  r.Header.Get("Content-Type"),
+			audio:  make(media.PCM16Sample, len(body)/2),
+		}
+		for i := range b.audio {
+			b.audio[i] = int16(binary.BigEndian.Uint16(body[2*i:]))
+		}
+		batches <- b
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, batches
+}
+
+func TestStreamer(t *testing.T) {
+	require.Nil(t, NewStreamer("", "call", 8000, nil))
+	var nilStreamer *Streamer
+	require.NoError(t, nilStreamer.WriteSample(media.PCM16Sample{1}))
+	require.NoError(t, nilStreamer.Close())
+
+	url, batches := newTestServer(t)
+	s := NewStreamer(url, "call", 8000, nil)
+	frame := make(media.PCM16Sample, 160)
+	for i := range frame {
+		frame[i] = int16(i - 80)
+	}
+	// 1.5 seconds of audio is posted as a full batch, and the rest is posted on close.
+	for i := 0; i < 75; i++ {
+		require.NoError(t, s.WriteSample(frame))
+	}
+	var b testBatch
+	select {
+	case b = <-batches:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no audio posted")
+	}
+	require.Equal(t, "call", b.callID)
+	require.Equal(t, "0", b.seq)
+	require.Equal(t, "audio/L16;rate=8000;channels=1", b.ctype)
+	require.Len(t, b.audio, 8000)
+	require.Equal(t, frame, b.audio[:160])
+	require.Empty(t, batches)
+
+	require.NoError(t, s.Close())
+	require.NoError(t, s.WriteSample(frame)) // ignored
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("streamer did not stop")
+	}
+	b = <-batches
+	require.Equal(t, "1", b.seq)
+	require.Len(t, b.audio, 4000)
+	require.Empty(t, batches)
+}
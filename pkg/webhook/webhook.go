@@ -0,0 +1,195 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webhook delivers SIP call lifecycle events to an external HTTP endpoint.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/frostbyte73/core"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	EventCallStarted  = "call.started"
+	EventCallAnswered = "call.answered"
+	EventCallDTMF     = "call.dtmf"
+	EventCallEnded    = "call.ended"
+)
+
+const (
+	// SignatureHeader contains hex-encoded HMAC-SHA256 of the request body, prefixed with "sha256=".
+	SignatureHeader = "X-LiveKit-SIP-Signature"
+
+	// DefaultRetries is the number of times the delivery is retried after the first failed attempt.
+	DefaultRetries = 3
+	// DefaultBackoff is the delay before the first retry. It doubles with each retry.
+	DefaultBackoff = 500 * time.Millisecond
+
+	queueSize      = 256
+	requestTimeout = 5 * time.Second
+)
+
+// CallEvent is a JSON payload sent to the webhook.
+type CallEvent struct {
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	CallID    string    `json:"call_id"`
+	Direction string    `json:"direction,omitempty"`
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to,omitempty"`
+	RoomName  string    `json:"room_name,omitempty"`
+	Digit     string    `json:"digit,omitempty"`        // call.dtmf only
+	Duration  float64   `json:"duration_sec,omitempty"` // call.ended only
+	Reason    string    `json:"reason,omitempty"`       // call.ended only
+}
+
+// Notifier posts call events to a webhook URL. Events are delivered in order, in a separate goroutine.
+//
+// A nil Notifier is valid and ignores all events.
+type Notifier struct {
+	log     logger.Logger
+	url     string
+	secret  []byte
+	cli     *http.Client
+	retries int
+	backoff time.Duration
+
+	queue  chan *CallEvent
+	closed core.Fuse
+	done   chan struct{}
+}
+
+// NewNotifier creates a webhook notifier. It returns nil if url is empty.
+func NewNotifier(url, secret string, log logger.Logger) *Notifier {
+	if url == "" {
+		return nil
+	}
+	if log == nil {
+		log = logger.GetLogger()
+	}
+	n := &Notifier{
+		log:     log.WithValues("webhook", url),
+		url:     url,
+		secret:  []byte(secret),
+		cli:     &http.Client{Timeout: requestTimeout},
+		retries: DefaultRetries,
+		backoff: DefaultBackoff,
+		queue:   make(chan *CallEvent, queueSize),
+		done:    make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+// Notify schedules an event for delivery. Timestamp is set automatically, if not provided.
+func (n *Notifier) Notify(ev *CallEvent) {
+	if n == nil || n.closed.IsBroken() {
+		return
+	}
+	if ev.Timestamp.IsZero() {
+		ev.Timestamp = time.Now()
+	}
+	select {
+	case n.queue <- ev:
+	default:
+		n.log.Warnw("webhook queue is full, dropping event", nil, "event", ev.Event, "callID", ev.CallID)
+	}
+}
+
+// Close stops the delivery. Events that are still queued are dropped.
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	n.closed.Break()
+	<-n.done
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for {
+		select {
+		case <-n.closed.Watch():
+			return
+		case ev := <-n.queue:
+			if err := n.deliver(ev); err != nil {
+				n.log.Warnw("failed to deliver webhook", err, "event", ev.Event, "callID", ev.CallID)
+			}
+		}
+	}
+}
+
+// Sign computes the signature for the webhook body.
+func Sign(secret, body []byte) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write(body)
+	return "sha256=" + hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify checks the signature of the webhook body.
+func Verify(secret, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+func (n *Notifier) deliver(ev *CallEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	backoff := n.backoff
+	for i := 0; ; i++ {
+		err = n.post(body)
+		if err == nil || i >= n.retries {
+			return err
+		}
+		select {
+		case <-n.closed.Watch():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (n *Notifier) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.secret) != 0 {
+		req.Header.Set(SignatureHeader, Sign(n.secret, body))
+	}
+	resp, err := n.cli.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "secret"
+
+func newTestServer(t *testing.T, fails int) (string, <-chan *CallEvent) {
+	events := make(chan *CallEvent, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if !Verify([]byte(testSecret), body, r.Header.Get(SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if fails > 0 {
+			fails--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var ev CallEvent
+		require.NoError(t, json.Unmarshal(body, &ev))
+		events <- &ev
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, events
+}
+
+func newTestNotifier(t *testing.T, url string) *Notifier {
+	n := NewNotifier(url, testSecret, nil)
+	n.backoff = time.Millisecond
+	t.Cleanup(n.Close)
+	return n
+}
+
+func expectEvent(t *testing.T, events <-chan *CallEvent, typ string) *CallEvent {
+	t.Helper()
+	select {
+	case ev := <-events:
+		require.Equal(t, typ, ev.Event)
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for", typ)
+		return nil
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	url, events := newTestServer(t, 0)
+	n := newTestNotifier(t, url)
+
+	n.Notify(&CallEvent{Event: EventCallStarted, CallID: "call", From: "from", To: "to"})
+	n.Notify(&CallEvent{Event: EventCallAnswered, CallID: "call"})
+	n.Notify(&CallEvent{Event: EventCallDTMF, CallID: "call", Digit: "5"})
+	n.Notify(&CallEvent{Event: EventCallEnded, CallID: "call", Duration: 1.5, Reason: "hangup"})
+
+	ev := expectEvent(t, events, EventCallStarted)
+	require.Equal(t, "call", ev.CallID)
+	require.Equal(t, "from", ev.From)
+	require.Equal(t, "to", ev.To)
+	require.False(t, ev.Timestamp.IsZero())
+
+	expectEvent(t, events, EventCallAnswered)
+
+	ev = expectEvent(t, events, EventCallDTMF)
+	require.Equal(t, "5", ev.Digit)
+
+	ev = expectEvent(t, events, EventCallEnded)
+	require.Equal(t, 1.5, ev.Duration)
+	require.Equal(t, "hangup", ev.Reason)
+}
+
+func TestNotifierRetry(t *testing.T) {
+	url, events := newTestServer(t, DefaultRetries)
+	n := newTestNotifier(t, url)
+
+	n.Notify(&CallEvent{Event: EventCallStarted, CallID: "call"})
+	expectEvent(t, events, EventCallStarted)
+}
+
+func TestNilNotifier(t *testing.T) {
+	var n *Notifier
+	n.Notify(&CallEvent{Event: EventCallStarted})
+	n.Close()
+	require.Nil(t, NewNotifier("", testSecret, nil))
+}
@@ -6,11 +6,13 @@ import (
 	"net"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/redis"
 	lksdk "github.com/livekit/server-sdk-go/v2"
 	"github.com/ory/dockertest/v3"
+	"github.com/stretchr/testify/require"
 
 	"github.com/livekit/sip/test/lktest"
 )
@@ -96,3 +98,40 @@ func runLiveKit(t testing.TB) *LiveKit {
 
 	return lk
 }
+
+var (
+	_ lktest.TB = (testing.TB)(nil)
+	_ lktest.B  = (*testing.B)(nil)
+)
+
+func BenchmarkParticipantSendReceive(b *testing.B) {
+	lk := runLiveKit(b)
+	for _, pairs := range []int{1, 5} {
+		b.Run(fmt.Sprintf("pairs=%d", pairs), func(b *testing.B) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			lktest.BenchmarkParticipantSendReceive(b, ctx, lk.LiveKit, fmt.Sprintf("bench-%d", pairs), pairs, b.N)
+		})
+	}
+}
+
+func TestParticipantDTMF(t *testing.T) {
+	lk := runLiveKit(t)
+	const roomName = "test-dtmf-inject"
+	pSend := lk.ConnectParticipant(t, roomName, "send", nil)
+	pRecv := lk.ConnectParticipant(t, roomName, "recv", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), participantsJoinTimeout)
+	defer cancel()
+	lk.ExpectRoomWithParticipants(t, ctx, roomName, []lktest.ParticipantInfo{
+		{Identity: "send"},
+		{Identity: "recv"},
+	})
+
+	require.NoError(t, pSend.InjectDTMF(ctx, "123", 50*time.Millisecond, 50*time.Millisecond))
+	require.NoError(t, pRecv.WaitDTMF(ctx, "12"))
+	require.NoError(t, pRecv.WaitDTMF(ctx, "3"))
+
+	require.NoError(t, pSend.InjectDTMF(ctx, "9", 50*time.Millisecond, 0))
+	require.Error(t, pRecv.WaitDTMF(ctx, "8"))
+}
@@ -2,16 +2,17 @@ package integration
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"math/rand"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
-	"github.com/livekit/mediatransportutil/pkg/rtcconfig"
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/logger"
 	"github.com/livekit/protocol/redis"
@@ -25,6 +26,7 @@ import (
 	"github.com/livekit/sip/pkg/media/ulaw"
 	"github.com/livekit/sip/pkg/service"
 	"github.com/livekit/sip/pkg/sip"
+	"github.com/livekit/sip/pkg/sip/parking"
 	"github.com/livekit/sip/pkg/siptest"
 	"github.com/livekit/sip/test/lktest"
 )
@@ -36,7 +38,7 @@ type SIPServer struct {
 	URI     string
 }
 
-func runSIPServer(t testing.TB, lk *LiveKit) *SIPServer {
+func runSIPServer(t testing.TB, lk *LiveKit, opts ...func(conf *config.Config)) *SIPServer {
 	rc, err := redis.GetRedisClient(lk.Redis)
 	if err != nil {
 		t.Fatal(err)
@@ -57,10 +59,14 @@ func runSIPServer(t testing.TB, lk *LiveKit) *SIPServer {
 		WsUrl:         lk.WsUrl,
 		Redis:         lk.Redis,
 		SIPPort:       sipPort,
-		RTPPort:       rtcconfig.PortRange{Start: 20000, End: 20010},
+		RTPPortMin:    20000,
+		RTPPortMax:    20010,
 		UseExternalIP: false,
 		Logging:       logger.Config{Level: "debug"},
 	}
+	for _, opt := range opts {
+		opt(conf)
+	}
 	_ = conf.InitLogger()
 	log := logger.GetLogger()
 
@@ -69,7 +75,7 @@ func runSIPServer(t testing.TB, lk *LiveKit) *SIPServer {
 		t.Fatal(err)
 	}
 
-	svc := service.NewService(conf, log, sipsrv.InternalServerImpl(), sipsrv.Stop, sipsrv.ActiveCalls, psrpcCli, bus)
+	svc := service.NewService(conf, log, sipsrv.InternalServerImpl(), sipsrv.Stop, sipsrv.ActiveCalls, sipsrv.Trunks, psrpcCli, bus)
 	sipsrv.SetHandler(svc)
 	t.Cleanup(func() {
 		svc.Stop(true)
@@ -260,6 +266,12 @@ func TestSIPJoinOpenRoom(t *testing.T) {
 		{Identity: "test"},
 		{Identity: "sip_" + clientNumber, Name: "Phone " + clientNumber, Kind: livekit.ParticipantInfo_SIP, Metadata: meta},
 	})
+	lk.ExpectParticipantAttributes(t, ctx, roomName, "sip_"+clientNumber, map[string]string{
+		sip.AttrCallID: cli.CallID(),
+		sip.AttrFrom:   clientNumber,
+		sip.AttrTo:     serverNumber,
+		sip.AttrHold:   "false",
+	})
 
 	// Wait for WebRTC to come online.
 	time.Sleep(webrtcSetupDelay)
@@ -275,13 +287,23 @@ func TestSIPJoinOpenRoom(t *testing.T) {
 		return dtmf == dtmfDigits
 	}, 5*time.Second, time.Second/2)
 
+	// Hold state must be visible to LK participants.
+	for _, hold := range []bool{true, false} {
+		require.NoError(t, cli.Hold(hold))
+		ctx, cancel := context.WithTimeout(context.Background(), participantsJoinTimeout)
+		lk.ExpectParticipantAttributes(t, ctx, roomName, "sip_"+clientNumber, map[string]string{
+			sip.AttrHold: strconv.FormatBool(hold),
+		})
+		cancel()
+	}
+
 	cli.Close()
 	r.Disconnect()
 
 	// SIP participant must disconnect from LK room on hangup.
 	ctx, cancel = context.WithTimeout(context.Background(), participantsLeaveTimeout)
 	defer cancel()
-	lk.ExpectRoomWithParticipants(t, ctx, roomName, nil)
+	lk.ExpectRoomEmpty(t, ctx, roomName)
 }
 
 func TestSIPJoinPinRoom(t *testing.T) {
@@ -342,7 +364,76 @@ func TestSIPJoinPinRoom(t *testing.T) {
 	// SIP participant must disconnect from LK room on hangup.
 	ctx, cancel = context.WithTimeout(context.Background(), participantsLeaveTimeout)
 	defer cancel()
-	lk.ExpectRoomWithParticipants(t, ctx, roomName, nil)
+	lk.ExpectRoomEmpty(t, ctx, roomName)
+}
+
+func TestSIPDTMFDataChannel(t *testing.T) {
+	lk := runLiveKit(t)
+	var (
+		dmu  sync.Mutex
+		msgs []sip.DTMFMessage
+	)
+	const (
+		roomName = "test-dtmf"
+		topic    = "test-dtmf"
+	)
+	p := lk.ConnectParticipant(t, roomName, "test", &lksdk.RoomCallback{
+		ParticipantCallback: lksdk.ParticipantCallback{
+			OnDataPacket: func(data lksdk.DataPacket, params lksdk.DataReceiveParams) {
+				pkt, ok := data.(*lksdk.UserDataPacket)
+				if !ok || pkt.Topic != topic {
+					return
+				}
+				var msg sip.DTMFMessage
+				if err := json.Unmarshal(pkt.Payload, &msg); err != nil {
+					t.Error("cannot decode DTMF message", err)
+					return
+				}
+				dmu.Lock()
+				msgs = append(msgs, msg)
+				dmu.Unlock()
+			},
+		},
+	})
+	p.SetDTMFTopic(topic)
+	srv := runSIPServer(t, lk, func(conf *config.Config) {
+		conf.DTMFDataChannelTopic = topic
+	})
+
+	nc := srv.CreateTrunkAndDirect(t, serverNumber, roomName, "", "")
+
+	cli := runClient(t, nc, "", clientNumber, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), participantsJoinTimeout)
+	defer cancel()
+	lk.ExpectRoomWithParticipants(t, ctx, roomName, []lktest.ParticipantInfo{
+		{Identity: "test"},
+		{Identity: "sip_" + clientNumber, Name: "Phone " + clientNumber, Kind: livekit.ParticipantInfo_SIP},
+	})
+
+	// Wait for WebRTC to come online.
+	time.Sleep(webrtcSetupDelay)
+
+	const dtmfDigits = "5*#"
+	err := cli.SendDTMF(dtmfDigits)
+	require.NoError(t, err)
+	require.NoError(t, p.WaitDTMF(ctx, dtmfDigits))
+
+	require.Eventually(t, func() bool {
+		dmu.Lock()
+		defer dmu.Unlock()
+		return len(msgs) == len(dtmfDigits)
+	}, 5*time.Second, time.Second/2)
+
+	dmu.Lock()
+	defer dmu.Unlock()
+	for i, msg := range msgs {
+		require.Equal(t, string(dtmfDigits[i]), msg.Digit)
+		require.NotEmpty(t, msg.CallID)
+		require.Positive(t, msg.DurationMs)
+	}
+	cli.Close()
+	p.Room.Disconnect()
 }
 
 func TestSIPJoinOpenRoomWithPin(t *testing.T) {
@@ -447,13 +538,75 @@ func TestSIPAudio(t *testing.T) {
 
 					ctx, cancel = context.WithTimeout(context.Background(), participantsLeaveTimeout)
 					defer cancel()
-					lk.ExpectRoomWithParticipants(t, ctx, roomName, nil)
+					lk.ExpectRoomEmpty(t, ctx, roomName)
 				})
 			}
 		})
 	}
 }
 
+func TestSIPParking(t *testing.T) {
+	lk := runLiveKit(t)
+	srv := runSIPServer(t, lk, func(conf *config.Config) {
+		conf.ParkingEnabled = true
+	})
+
+	const (
+		roomName   = "test-open"
+		meta       = `{"test":true}`
+		retrNumber = "+222222222"
+	)
+	nc := srv.CreateTrunkAndDirect(t, serverNumber, roomName, "", meta)
+
+	parked := runClient(t, nc, "parked", clientNumber, false)
+	ctx, cancel := context.WithTimeout(context.Background(), participantsJoinTimeout)
+	defer cancel()
+	lk.ExpectRoomWithParticipants(t, ctx, roomName, []lktest.ParticipantInfo{
+		{Identity: "sip_" + clientNumber, Name: "Phone " + clientNumber, Kind: livekit.ParticipantInfo_SIP, Metadata: meta},
+	})
+
+	// Parked call moves to a dedicated room.
+	require.NoError(t, parked.Refer("sip:park@"+nc.SIP.URI))
+	ctx, cancel = context.WithTimeout(context.Background(), participantsLeaveTimeout)
+	defer cancel()
+	lk.ExpectRoomEmpty(t, ctx, roomName)
+
+	var parkRoom string
+	require.Eventually(t, func() bool {
+		for _, r := range lk.ListRooms(t) {
+			if strings.HasPrefix(r.Name, "park_") {
+				parkRoom = r.Name
+				return true
+			}
+		}
+		return false
+	}, participantsJoinTimeout, time.Second/4)
+	t.Log("Parking room:", parkRoom)
+
+	// Retrieve the call from the first slot.
+	retr := *nc
+	retr.Number = parking.SlotUser(1)
+	retriever := runClient(t, &retr, "retriever", retrNumber, false)
+	ctx, cancel = context.WithTimeout(context.Background(), participantsJoinTimeout)
+	defer cancel()
+	lk.ExpectRoomWithParticipants(t, ctx, parkRoom, []lktest.ParticipantInfo{
+		{Identity: "sip_" + clientNumber, Name: "Phone " + clientNumber, Kind: livekit.ParticipantInfo_SIP, Metadata: meta},
+		{Identity: "sip_" + retrNumber, Name: "Phone " + retrNumber, Kind: livekit.ParticipantInfo_SIP},
+	})
+
+	// Audio of the parked call must continue in the new session.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	lktest.CheckAudioForParticipants(t, ctx, parked, retriever)
+	cancel()
+
+	parked.Close()
+	retriever.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), participantsLeaveTimeout)
+	defer cancel()
+	lk.ExpectRoomEmpty(t, ctx, parkRoom)
+}
+
 func TestSIPOutbound(t *testing.T) {
 	// Run two LK and SIP servers and make a SIP call from one to the other.
 	lkOut := runLiveKit(t)
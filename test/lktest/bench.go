@@ -0,0 +1,97 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lktest
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// BenchmarkParticipantSendReceive measures end-to-end audio latency between pairs of participants.
+// Each pair joins a separate room, and all pairs exchange audio concurrently to put the server under load.
+// For each of n iterations, latency of each pair is measured with MeasureLatency.
+func BenchmarkParticipantSendReceive(b B, ctx context.Context, lk *LiveKit, room string, pairs, n int) {
+	b.StopTimer()
+	type pair struct {
+		send, recv *Participant
+	}
+	list := make([]pair, pairs)
+	for i := range list {
+		name := fmt.Sprintf("%s-%d", room, i+1)
+		list[i] = pair{
+			send: lk.ConnectParticipant(b, name, "sender", nil),
+			recv: lk.ConnectParticipant(b, name, "receiver", nil),
+		}
+	}
+	// Participants can only subscribe to tracks that are "live", so give them the chance to do so.
+	for _, p := range list {
+		require.NoError(b, p.send.SendSignal(ctx, 3, 0))
+		require.NoError(b, p.recv.SendSignal(ctx, 3, 0))
+	}
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+	)
+	b.ResetTimer()
+	b.StartTimer()
+	for i := 0; i < n; i++ {
+		// Alternate signals, so that audio left from the previous iteration is not detected.
+		sig := i%4 + 1
+		var wg sync.WaitGroup
+		errc := make(chan error, len(list))
+		for _, p := range list {
+			p := p
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				dt, err := MeasureLatency(ctx, p.send, p.recv, sig)
+				if err != nil {
+					errc <- err
+					return
+				}
+				mu.Lock()
+				latencies = append(latencies, dt)
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		close(errc)
+		for err := range errc {
+			require.NoError(b, err)
+		}
+	}
+	b.StopTimer()
+
+	if len(latencies) == 0 {
+		return
+	}
+	slices.Sort(latencies)
+	var sum time.Duration
+	for _, dt := range latencies {
+		sum += dt
+	}
+	toMS := func(dt time.Duration) float64 {
+		return float64(dt) / float64(time.Millisecond)
+	}
+	b.ReportMetric(toMS(sum/time.Duration(len(latencies))), "ms/latency")
+	b.ReportMetric(toMS(latencies[len(latencies)*95/100]), "ms/p95")
+	b.Logf("audio latency for %d pairs: min %v, max %v", pairs, latencies[0], latencies[len(latencies)-1])
+}
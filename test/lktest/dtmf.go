@@ -0,0 +1,143 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lktest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	lksdk "github.com/livekit/server-sdk-go/v2"
+
+	"github.com/livekit/sip/pkg/config"
+	"github.com/livekit/sip/pkg/sip"
+)
+
+// dtmfCollector keeps DTMF messages received on the data channel, until they are consumed by WaitDTMF.
+type dtmfCollector struct {
+	mu     sync.Mutex
+	topic  string
+	digits []sip.DTMFMessage
+	notify chan struct{}
+}
+
+func newDTMFCollector() *dtmfCollector {
+	return &dtmfCollector{
+		topic:  config.DefaultDTMFDataChannelTopic,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (c *dtmfCollector) handleData(t TB, data lksdk.DataPacket) {
+	p, ok := data.(*lksdk.UserDataPacket)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p.Topic != c.topic {
+		return
+	}
+	var msg sip.DTMFMessage
+	if err := json.Unmarshal(p.Payload, &msg); err != nil {
+		t.Error("cannot decode DTMF message", err)
+		return
+	}
+	c.digits = append(c.digits, msg)
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// SetDTMFTopic changes the data channel topic used by InjectDTMF and WaitDTMF.
+// It must match dtmf_data_channel_topic of the SIP service. Default is config.DefaultDTMFDataChannelTopic.
+func (p *Participant) SetDTMFTopic(topic string) {
+	p.dtmf.mu.Lock()
+	defer p.dtmf.mu.Unlock()
+	p.dtmf.topic = topic
+}
+
+func (p *Participant) dtmfTopic() string {
+	p.dtmf.mu.Lock()
+	defer p.dtmf.mu.Unlock()
+	return p.dtmf.topic
+}
+
+// InjectDTMF publishes digits to the room as DTMF data channel messages, the same way the SIP service publishes
+// digits received from SIP participants. Each digit is followed by a pause of digitDuration plus interDigitGap,
+// which mimics a phone keypad.
+func (p *Participant) InjectDTMF(ctx context.Context, digits string, digitDuration, interDigitGap time.Duration) error {
+	topic := p.dtmfTopic()
+	for i, digit := range digits {
+		data, err := json.Marshal(sip.DTMFMessage{
+			Digit:      string(digit),
+			DurationMs: int(digitDuration / time.Millisecond),
+		})
+		if err != nil {
+			return err
+		}
+		if err = p.Room.LocalParticipant.PublishDataPacket(&lksdk.UserDataPacket{
+			Payload: data,
+			Topic:   topic,
+		}, lksdk.WithDataPublishReliable(true)); err != nil {
+			return fmt.Errorf("cannot send DTMF digit %q: %w", digit, err)
+		}
+		if i == len(digits)-1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(digitDuration + interDigitGap):
+		}
+	}
+	return nil
+}
+
+// WaitDTMF waits until DTMF data channel messages for all expected digits are received, and checks that digits match.
+// Received messages are consumed, so consecutive calls check consecutive digits.
+func (p *Participant) WaitDTMF(ctx context.Context, expected string) error {
+	c := p.dtmf
+	for {
+		c.mu.Lock()
+		if len(c.digits) >= len(expected) {
+			msgs := c.digits[:len(expected)]
+			c.digits = c.digits[len(expected):]
+			c.mu.Unlock()
+			var got strings.Builder
+			for _, msg := range msgs {
+				got.WriteString(msg.Digit)
+			}
+			if got.String() != expected {
+				return fmt.Errorf("unexpected DTMF digits: got %q, expected %q", got.String(), expected)
+			}
+			return nil
+		}
+		var got strings.Builder
+		for _, msg := range c.digits {
+			got.WriteString(msg.Digit)
+		}
+		c.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("DTMF digits %q not received, got %q: %w", expected, got.String(), ctx.Err())
+		case <-c.notify:
+		}
+	}
+}
@@ -16,7 +16,11 @@ package lktest
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"maps"
 	"math"
 	"slices"
 	"strings"
@@ -90,32 +94,70 @@ func (lk *LiveKit) CreateSIPParticipant(t TB, trunk, room, identity, name, meta,
 }
 
 func (lk *LiveKit) Connect(t TB, room, identity string, cb *lksdk.RoomCallback) *lksdk.Room {
-	r := lksdk.NewRoom(cb)
-	err := r.Join(lk.WsUrl, lksdk.ConnectInfo{
-		APIKey:              lk.ApiKey,
-		APISecret:           lk.ApiSecret,
+	return lk.join(t, lksdk.ConnectInfo{
 		RoomName:            room,
 		ParticipantIdentity: identity,
-	})
-	if err != nil {
+	}, cb)
+}
+
+func (lk *LiveKit) join(t TB, info lksdk.ConnectInfo, cb *lksdk.RoomCallback) *lksdk.Room {
+	info.APIKey, info.APISecret = lk.ApiKey, lk.ApiSecret
+	r := lksdk.NewRoom(cb)
+	if err := r.Join(lk.WsUrl, info); err != nil {
 		t.Fatal(err)
 	}
 	t.Cleanup(r.Disconnect)
 	return r
 }
 
+// SIPParticipantMeta is the metadata of participants joined with ConnectSIPParticipant.
+type SIPParticipantMeta struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Trunk string `json:"trunk,omitempty"`
+}
+
+// ConnectSIPParticipant joins the room directly as a SIP participant for a call from a given number,
+// bypassing the SIP stack entirely. Identity and name are the same as for inbound calls: "sip_<from>" and "Phone <from>".
+// Called number and the trunk are set in the metadata, see SIPParticipantMeta.
+//
+// This allows testing dispatch rules and participant metadata without the SIP service or a network.
+func (lk *LiveKit) ConnectSIPParticipant(t TB, room, from, to, trunk string) *Participant {
+	meta, err := json.Marshal(SIPParticipantMeta{From: from, To: to, Trunk: trunk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lk.connectParticipant(t, lksdk.ConnectInfo{
+		RoomName:            room,
+		ParticipantIdentity: "sip_" + from,
+		ParticipantName:     "Phone " + from,
+		ParticipantKind:     lksdk.ParticipantSIP,
+		ParticipantMetadata: string(meta),
+	}, nil)
+}
+
 func (lk *LiveKit) ConnectParticipant(t TB, room, identity string, cb *lksdk.RoomCallback) *Participant {
+	return lk.connectParticipant(t, lksdk.ConnectInfo{
+		RoomName:            room,
+		ParticipantIdentity: identity,
+	}, cb)
+}
+
+func (lk *LiveKit) connectParticipant(t TB, info lksdk.ConnectInfo, cb *lksdk.RoomCallback) *Participant {
 	if cb == nil {
 		cb = new(lksdk.RoomCallback)
 	}
-	p := &Participant{t: t}
+	p := &Participant{t: t, dtmf: newDTMFCollector()}
 	pr, pw := media.Pipe[media.PCM16Sample]()
+	p.AudioIn = pr
+	p.mix = mixer.NewMixer(pw, rtp.DefFrameDur, rtp.DefSampleRate)
 	t.Cleanup(func() {
-		pw.Close()
+		// Close the reader first, so that the mixer doesn't block on the pipe. Mixer closes the writer.
 		pr.Close()
+		if err := p.mix.Close(); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			t.Log("cannot close the mixer", "err", err)
+		}
 	})
-	p.AudioIn = pr
-	p.mix = mixer.NewMixer(pw, rtp.DefFrameDur, rtp.DefSampleRate)
 	cb.ParticipantCallback.OnTrackPublished = func(pub *lksdk.RemoteTrackPublication, rp *lksdk.RemoteParticipant) {
 		if pub.Kind() == lksdk.TrackKindAudio {
 			if err := pub.SetSubscribed(true); err != nil {
@@ -134,7 +176,14 @@ func (lk *LiveKit) ConnectParticipant(t TB, room, identity string, cb *lksdk.Roo
 		h := rtp.NewMediaStreamIn[opus.Sample](odec)
 		_ = rtp.HandleLoop(track, h)
 	}
-	p.Room = lk.Connect(t, room, identity, cb)
+	onData := cb.ParticipantCallback.OnDataPacket
+	cb.ParticipantCallback.OnDataPacket = func(data lksdk.DataPacket, params lksdk.DataReceiveParams) {
+		p.dtmf.handleData(t, data)
+		if onData != nil {
+			onData(data, params)
+		}
+	}
+	p.Room = lk.join(t, info, cb)
 	track, err := p.newAudioTrack()
 	if err != nil {
 		t.Fatal(err)
@@ -144,14 +193,22 @@ func (lk *LiveKit) ConnectParticipant(t TB, room, identity string, cb *lksdk.Roo
 }
 
 type Participant struct {
-	t   TB
-	mix *mixer.Mixer
+	t    TB
+	mix  *mixer.Mixer
+	dtmf *dtmfCollector
 
 	Room     *lksdk.Room
 	AudioOut media.Writer[media.PCM16Sample]
 	AudioIn  media.Reader[media.PCM16Sample]
 }
 
+// TapAudio attaches taps to AudioIn and AudioOut, which receive a copy of all audio passing through them.
+// Either tap can be nil. Useful for capturing audio while debugging tests.
+func (p *Participant) TapAudio(in, out media.Writer[media.PCM16Sample]) {
+	p.AudioIn = media.Tee(p.AudioIn, in)
+	p.AudioOut = media.WriterTee(p.AudioOut, out)
+}
+
 func (p *Participant) newAudioTrack() (media.Writer[media.PCM16Sample], error) {
 	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "pion")
 	if err != nil {
@@ -175,20 +232,40 @@ const (
 	signalAmp    = math.MaxInt16 / 4
 	signalAmpMin = signalAmp - signalAmp/4 // TODO: why it's so low?
 	signalAmpMax = signalAmp + signalAmp/10
+	signalSNRMin = 10 // dB
 )
 
+// expectedSignals returns signals that are expected to be found in audio generated for given frequencies.
+func expectedSignals(vals []int) []audiotest.Wave {
+	out := make([]audiotest.Wave, 0, len(vals))
+	for _, v := range vals {
+		out = append(out, audiotest.Wave{Ind: v, Amp: signalAmp})
+	}
+	return out
+}
+
 func (p *Participant) SendSignal(ctx context.Context, n int, val int) error {
 	signal := make(media.PCM16Sample, rtp.DefPacketDur)
 	audiotest.GenSignal(signal, []audiotest.Wave{{Ind: val, Amp: signalAmp}})
 	sid, id := p.Room.LocalParticipant.SID(), p.Room.LocalParticipant.Identity()
 	p.t.Log("sending signal", "sid", sid, "id", id, "len", len(signal), "n", n, "sig", val)
 
+	var r media.ReadCloser[media.PCM16Sample] = media.RepeatReader[media.PCM16Sample](media.NewBufferReader(signal))
+	if n > 0 {
+		r = media.LimitReader[media.PCM16Sample](r, n)
+	}
+	defer r.Close()
+
 	ticker := time.NewTicker(rtp.DefFrameDur)
 	defer ticker.Stop()
+	buf := make(media.PCM16Sample, len(signal))
 	i := 0
 	for {
-		if n > 0 && i >= n {
-			break
+		sz, err := r.ReadSample(buf)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
 		}
 		select {
 		case <-ctx.Done():
@@ -200,39 +277,66 @@ func (p *Participant) SendSignal(ctx context.Context, n int, val int) error {
 		case <-ticker.C:
 		}
 
-		if err := p.AudioOut.WriteSample(signal); err != nil {
+		if err = p.AudioOut.WriteSample(buf[:sz]); err != nil {
 			return err
 		}
 		i++
 	}
-	return nil
 }
 
 func (p *Participant) WaitSignals(ctx context.Context, vals []int, w io.WriteCloser) error {
+	_, err := p.waitSignals(ctx, p.AudioIn, vals, w)
+	return err
+}
+
+// waitSignals waits for the signals in audio frames read from src, and returns the time when the frame containing them was read.
+// Reading can be bounded with media.LimitReader, in which case an error is returned if src ends before the signals are found.
+func (p *Participant) waitSignals(ctx context.Context, src media.Reader[media.PCM16Sample], vals []int, w io.WriteCloser) (time.Time, error) {
 	var ws media.PCM16WriteCloser
 	if w != nil {
 		ws = webmm.NewPCM16Writer(w, rtp.DefSampleRate, rtp.DefFrameDur)
 		defer ws.Close()
 	}
 	lastLog := time.Now()
+	// Best SNR of frames that matched the signals. Signals with low SNR indicate audio quality regressions.
+	var (
+		lowSNR     float64
+		lowSNRSeen bool
+	)
+	expected := expectedSignals(vals)
+	// Difference between the expected and the last non-matching signals, for logs and errors.
+	var lastDiff string
 	buf := make(media.PCM16Sample, rtp.DefPacketDur)
 	sid, id := p.Room.LocalParticipant.SID(), p.Room.LocalParticipant.Identity()
 	for {
-		n, err := p.AudioIn.ReadSample(buf)
-		if err != nil {
+		n, err := src.ReadSample(buf)
+		at := time.Now()
+		if errors.Is(err, io.EOF) {
+			if lowSNRSeen {
+				return time.Time{}, fmt.Errorf("signals %v found, but SNR is too low: %.1f dB", vals, lowSNR)
+			} else if lastDiff != "" {
+				return time.Time{}, fmt.Errorf("signals %v not found: %s", vals, lastDiff)
+			}
+			return time.Time{}, fmt.Errorf("signals %v not found", vals)
+		} else if err != nil {
 			p.t.Log("cannot read rtp packet", "err", err)
-			return err
+			return time.Time{}, err
 		}
 		decoded := buf[:n]
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			if lowSNRSeen {
+				return time.Time{}, fmt.Errorf("%w: signals %v found, but SNR is too low: %.1f dB", ctx.Err(), vals, lowSNR)
+			} else if lastDiff != "" {
+				return time.Time{}, fmt.Errorf("%w: signals %v not found: %s", ctx.Err(), vals, lastDiff)
+			}
+			return time.Time{}, ctx.Err()
 		default:
 		}
 
 		if ws != nil {
 			if err = ws.WriteSample(decoded); err != nil {
-				return err
+				return time.Time{}, err
 			}
 		}
 		if !slices.ContainsFunc(decoded, func(v int16) bool { return v != 0 }) {
@@ -255,21 +359,83 @@ func (p *Participant) WaitSignals(ctx context.Context, vals []int, w io.WriteClo
 				}
 			}
 			if ok {
-				p.t.Log("signal found", "sid", sid, "id", id, "sig", vals)
-				return nil
+				// Amplitude is not enough, the signal must not be distorted either.
+				if snr := audiotest.ToneSNR(decoded, vals); snr < signalSNRMin {
+					if !lowSNRSeen || snr > lowSNR {
+						lowSNR, lowSNRSeen = snr, true
+					}
+				} else {
+					p.t.Log("signal found", "sid", sid, "id", id, "sig", vals, "snr", snr)
+					return at, nil
+				}
 			}
 		}
 		// Remove most other components from the logs.
 		if len(out) > len(vals)*2 {
 			out = out[:len(vals)*2]
 		}
+		lastDiff = audiotest.WaveSlice(out).Diff(expected)
 		if time.Since(lastLog) > time.Second {
 			lastLog = time.Now()
-			p.t.Log("skipping signal", "sid", sid, "id", id, "len", len(decoded), "signals", out)
+			p.t.Log("skipping signal", "sid", sid, "id", id, "len", len(decoded), "diff", lastDiff)
 		}
 	}
 }
 
+const (
+	// latencyBurstFrames is the length of the signal burst sent by MeasureLatency.
+	latencyBurstFrames = 25
+	// latencyMaxFrames is the number of frames MeasureLatency reads before giving up.
+	latencyMaxFrames = int(5 * time.Second / rtp.DefFrameDur)
+)
+
+// MeasureLatency sends a short burst of a signal from the sender, and returns the time until the receiver detects it.
+// Time is taken right before the first frame is written and right after the matching frame is read,
+// so the result is not rounded to the frame duration.
+func MeasureLatency(ctx context.Context, sender, receiver *Participant, signal int) (time.Duration, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		at  time.Time
+		err error
+	}
+	// Start reading first, so that the detection time doesn't include the goroutine startup.
+	res := make(chan result, 1)
+	go func() {
+		at, err := receiver.waitSignals(ctx, media.LimitReader(receiver.AudioIn, latencyMaxFrames), []int{signal}, nil)
+		res <- result{at: at, err: err}
+	}()
+
+	frame := make(media.PCM16Sample, rtp.DefPacketDur)
+	audiotest.GenSignal(frame, []audiotest.Wave{{Ind: signal, Amp: signalAmp}})
+	ticker := time.NewTicker(rtp.DefFrameDur)
+	defer ticker.Stop()
+	var start time.Time
+	for i := 0; i < latencyBurstFrames; i++ {
+		if i == 0 {
+			start = time.Now()
+		} else {
+			select {
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			case r := <-res:
+				// Detected before the burst ended.
+				return r.at.Sub(start), r.err
+			case <-ticker.C:
+			}
+		}
+		if err := sender.AudioOut.WriteSample(frame); err != nil {
+			return 0, err
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case r := <-res:
+		return r.at.Sub(start), r.err
+	}
+}
+
 type ParticipantInfo struct {
 	Identity string
 	Name     string
@@ -311,6 +477,36 @@ wait:
 	}
 }
 
+// ExpectParticipantAttributes waits until the participant has all given attributes. Other attributes are ignored.
+func (lk *LiveKit) ExpectParticipantAttributes(t TB, ctx context.Context, room, identity string, attrs map[string]string) {
+	got := make(map[string]string, len(attrs))
+	ticker := time.NewTicker(time.Second / 4)
+	defer ticker.Stop()
+wait:
+	for {
+		clear(got)
+		for _, p := range lk.RoomParticipants(t, room) {
+			if p.Identity != identity {
+				continue
+			}
+			for k := range attrs {
+				if v, ok := p.Attributes[k]; ok {
+					got[k] = v
+				}
+			}
+		}
+		if maps.Equal(attrs, got) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			break wait
+		case <-ticker.C:
+		}
+	}
+	require.Equal(t, attrs, got, "attributes of %q", identity)
+}
+
 func (lk *LiveKit) waitRooms(t TB, ctx context.Context, none bool, filter func(r *livekit.Room) bool) []*livekit.Room {
 	var rooms []*livekit.Room
 	ticker := time.NewTicker(time.Second / 4)
@@ -343,20 +539,60 @@ func (lk *LiveKit) waitRooms(t TB, ctx context.Context, none bool, filter func(r
 	}
 }
 
-func (lk *LiveKit) ExpectRoomWithParticipants(t TB, ctx context.Context, room string, participants []ParticipantInfo) {
-	filter := func(r *livekit.Room) bool {
+// WaitRoomExists waits until the room is created or the context expires.
+func (lk *LiveKit) WaitRoomExists(t TB, ctx context.Context, room string) *livekit.Room {
+	rooms := lk.waitRooms(t, ctx, false, func(r *livekit.Room) bool {
 		return r.Name == room
-	}
-	rooms := lk.waitRooms(t, ctx, len(participants) == 0, filter)
-	if len(participants) == 0 && len(rooms) == 0 {
+	})
+	require.Len(t, rooms, 1, "room %q does not exist", room)
+	return rooms[0]
+}
+
+// WaitRoomGone waits until the room is deleted or the context expires.
+func (lk *LiveKit) WaitRoomGone(t TB, ctx context.Context, room string) {
+	rooms := lk.waitRooms(t, ctx, true, func(r *livekit.Room) bool {
+		return r.Name == room
+	})
+	require.Empty(t, rooms, "room %q still exists", room)
+}
+
+func (lk *LiveKit) ExpectRoomWithParticipants(t TB, ctx context.Context, room string, participants []ParticipantInfo) {
+	if len(participants) == 0 {
+		lk.ExpectRoomEmpty(t, ctx, room)
 		return
 	}
-	require.Len(t, rooms, 1)
-	require.True(t, filter(rooms[0]))
-
+	lk.WaitRoomExists(t, ctx, room)
 	lk.ExpectParticipants(t, ctx, room, participants)
 }
 
+// ExpectRoomEmpty waits until the room has no participants or is deleted.
+func (lk *LiveKit) ExpectRoomEmpty(t TB, ctx context.Context, room string) {
+	var list []*livekit.ParticipantInfo
+	ticker := time.NewTicker(time.Second / 4)
+	defer ticker.Stop()
+	for {
+		if !slices.ContainsFunc(lk.ListRooms(t), func(r *livekit.Room) bool {
+			return r.Name == room
+		}) {
+			return // room is gone
+		}
+		resp, err := lk.Rooms.ListParticipants(context.Background(), &livekit.ListParticipantsRequest{Room: room})
+		if err == nil {
+			// Room may be deleted while we list participants, so errors are not fatal.
+			list = resp.Participants
+			if len(list) == 0 {
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			require.Empty(t, list, "room %q is not empty", room)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func (lk *LiveKit) ExpectRoomPrefWithParticipants(t TB, ctx context.Context, pref, number string, participants []ParticipantInfo) {
 	filter := func(r *livekit.Room) bool {
 		return r.Name != pref && strings.HasPrefix(r.Name, pref+"_"+number+"_")
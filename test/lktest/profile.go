@@ -0,0 +1,49 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lktest
+
+import (
+	"sync"
+
+	"github.com/livekit/sip/pkg/sip/callpprof"
+)
+
+type CallProfile = callpprof.CallProfile
+
+// RunWithProfiling runs fn and returns profiles of all calls completed during the run.
+// SIP service must run in the same process with pprof_per_call_enabled set for this to work.
+func RunWithProfiling(t TB, fn func()) []CallProfile {
+	var (
+		mu       sync.Mutex
+		profiles []CallProfile
+	)
+	cancel := callpprof.Subscribe(func(p CallProfile) {
+		mu.Lock()
+		defer mu.Unlock()
+		profiles = append(profiles, p)
+	})
+	defer cancel()
+	fn()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, p := range profiles {
+		t.Logf("call %s: cpu %v, duration %v, profiles %v", p.CallID, p.CPU, p.Duration, p.CPUFiles)
+	}
+	if st := callpprof.Summarize(profiles); st.Calls != 0 {
+		t.Logf("%d calls: mean cpu %v, p99 cpu %v", st.Calls, st.Mean, st.P99)
+	}
+	return profiles
+}
@@ -18,6 +18,8 @@ import (
 	"context"
 
 	"github.com/livekit/protocol/livekit"
+
+	"github.com/livekit/sip/pkg/sip"
 )
 
 type SIPOutboundTestParams struct {
@@ -63,6 +65,17 @@ func TestSIPOutbound(t TB, ctx context.Context, lkOut, lkIn *LiveKit, params SIP
 		{Identity: "sip_" + params.NumberOut, Name: "Phone " + params.NumberOut, Kind: livekit.ParticipantInfo_SIP, Metadata: params.MetaIn},
 	})
 
+	t.Log("checking attributes")
+	lkOut.ExpectParticipantAttributes(t, ctx, params.RoomOut, outIdentity, map[string]string{
+		sip.AttrFrom: params.NumberOut,
+		sip.AttrTo:   params.NumberIn,
+	})
+	lkIn.ExpectParticipantAttributes(t, ctx, params.RoomIn, "sip_"+params.NumberOut, map[string]string{
+		sip.AttrFrom: params.NumberOut,
+		sip.AttrTo:   params.NumberIn,
+		sip.AttrHold: "false",
+	})
+
 	t.Log("testing audio")
 	CheckAudioForParticipants(t, ctx, pOut, pIn)
 }
@@ -41,6 +41,16 @@ type TB interface {
 	Skipf(format string, args ...any)
 }
 
+// B mirrors the subset of testing.B used by benchmarks. Both testing.TB and testing.B satisfy TB,
+// and testing.B satisfies B. Since the number of iterations is a field of testing.B, it's passed separately.
+type B interface {
+	TB
+	ResetTimer()
+	StartTimer()
+	StopTimer()
+	ReportMetric(n float64, unit string)
+}
+
 func TestMain(run func(ctx context.Context, t TB)) {
 	defer func() {
 		switch r := recover().(type) {